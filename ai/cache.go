@@ -0,0 +1,49 @@
+package ai
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"github.com/zucced/goquery/cache"
+)
+
+// ResponseCache keeps the model's responses to identical prompts, so asking
+// the same question about the same schema again skips the model. Nil disables
+// caching.
+var ResponseCache cache.Cache
+
+// responseCacheTimeout bounds how long a prompt waits for the cache
+const responseCacheTimeout = time.Second
+
+// responseKey identifies a prompt sent to a model
+func responseKey(model, prompt string) string {
+	sum := sha256.Sum256([]byte(model + "\x00" + prompt))
+	return hex.EncodeToString(sum[:])
+}
+
+// cachedResponse returns the cached response of a model to a prompt
+func cachedResponse(ctx context.Context, model, prompt string) (string, bool) {
+	if ResponseCache == nil {
+		return "", false
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, responseCacheTimeout)
+	defer cancel()
+
+	response, ok := ResponseCache.Get(ctx, responseKey(model, prompt))
+	return string(response), ok
+}
+
+// cacheResponse keeps the response of a model to a prompt
+func cacheResponse(ctx context.Context, model, prompt, response string) {
+	if ResponseCache == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, responseCacheTimeout)
+	defer cancel()
+
+	ResponseCache.Set(ctx, responseKey(model, prompt), []byte(response))
+}
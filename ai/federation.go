@@ -0,0 +1,158 @@
+package ai
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/zucced/goquery/config"
+	"github.com/zucced/goquery/models"
+)
+
+// federatedSampleRows is the number of rows of each table shown to the model
+const federatedSampleRows = 3
+
+// GenerateFederatedSQL generates a DuckDB query that answers a natural
+// language question by joining or aggregating the results of saved queries,
+// each loaded as a table
+func GenerateFederatedSQL(ctx context.Context, naturalQuery string, tables []models.FederatedTable, cfg *config.Config) (string, error) {
+	startTime := time.Now()
+
+	apiKey := cfg.OpenRouterAPIKey
+	if apiKey == "" {
+		return "", fmt.Errorf("OpenRouter API key not configured")
+	}
+
+	var schemaDesc strings.Builder
+	for _, table := range tables {
+		schemaDesc.WriteString(fmt.Sprintf("Table: %s (%d rows)\n", table.Name, len(table.Rows)))
+		schemaDesc.WriteString("Columns:\n")
+		for _, column := range table.Columns {
+			schemaDesc.WriteString(fmt.Sprintf("  - %s: %s\n", column.Name, column.Type))
+		}
+
+		if len(table.Rows) > 0 {
+			schemaDesc.WriteString("Sample rows:\n")
+			for i, row := range table.Rows {
+				if i == federatedSampleRows {
+					break
+				}
+				schemaDesc.WriteString("  " + sampleRow(table.Columns, row) + "\n")
+			}
+		}
+		schemaDesc.WriteString("\n")
+	}
+
+	prompt := fmt.Sprintf(`You are an expert SQL query generator for DuckDB.
+The tables below hold the results of queries run against different databases, which may be different engines such as PostgreSQL and MongoDB. Generate a single DuckDB SELECT query that answers the natural language question by joining, comparing or aggregating these tables.
+Only return the SQL query without any explanation or markdown formatting.
+Strictly use only the tables and columns listed. Quote column names that aren't plain lowercase identifiers with double quotes.
+Join on the columns that identify the same thing in both tables, using the sample rows to match their formats. Cast when the joined columns have different types, e.g. a VARCHAR id against a BIGINT one. Ids of MongoDB documents are 24 character hex strings.
+VARCHAR columns may hold JSON documents; use json_extract_string to read their fields.
+Do not create, modify or drop tables.
+
+%s
+Natural Language Query: %s
+
+SQL Query:`, schemaDesc.String(), naturalQuery)
+
+	// Use model from config or fallback to default
+	modelName := cfg.OpenRouterModel
+	if modelName == "" {
+		modelName = "deepseek-chat"
+	}
+
+	// Identical prompts get the same answer
+	if cached, ok := cachedResponse(ctx, modelName, prompt); ok {
+		return cached, nil
+	}
+
+	request := OpenRouterRequest{
+		Model: modelName,
+		Messages: []OpenRouterChatMessage{
+			{
+				Role:    "user",
+				Content: prompt,
+			},
+		},
+	}
+
+	requestBody, err := json.Marshal(request)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal request: %v", err)
+	}
+
+	// Use base URL from config or fallback to default
+	baseURL := cfg.OpenRouterBaseURL
+	if baseURL == "" {
+		baseURL = "https://api.deepseek.com/chat/completions"
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", baseURL, bytes.NewBuffer(requestBody))
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %v", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to send request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read response: %v", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(body))
+	}
+
+	var response OpenRouterResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		return "", fmt.Errorf("failed to unmarshal response: %v", err)
+	}
+
+	if len(response.Choices) == 0 {
+		return "", fmt.Errorf("no response from the model")
+	}
+
+	generatedQuery := strings.TrimSpace(response.Choices[0].Message.Content)
+	cacheResponse(ctx, modelName, prompt, generatedQuery)
+	fmt.Printf("Generated federated query:\n%s\n", generatedQuery)
+
+	generationTime := time.Since(startTime)
+	fmt.Printf("Federated query generation completed in %s\n", generationTime)
+
+	return generatedQuery, nil
+}
+
+// sampleRow describes a row of a federated table as JSON, with values as
+// they are loaded and long ones shortened
+func sampleRow(columns []models.FederatedColumn, row models.QueryResult) string {
+	sample := make(map[string]interface{}, len(columns))
+	for _, column := range columns {
+		value := column.Value(row[column.Name])
+		if s, ok := value.(string); ok {
+			if runes := []rune(s); len(runes) > 80 {
+				value = string(runes[:80]) + "..."
+			}
+		}
+		sample[column.Name] = value
+	}
+
+	data, err := json.Marshal(sample)
+	if err != nil {
+		return "{}"
+	}
+	return string(data)
+}
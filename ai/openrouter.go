@@ -2,15 +2,20 @@ package ai
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
+	"math"
 	"net/http"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/zucced/goquery/config"
 	"github.com/zucced/goquery/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 // OpenRouter API functions
@@ -30,8 +35,8 @@ func addNestedFields(builder *strings.Builder, fields []models.Column, indent in
 		}
 
 		// Add the field with proper indentation
-		builder.WriteString(fmt.Sprintf("%s- %s: %s%s%s\n",
-			indentStr, field.Name, field.Type, primaryKey, nullable))
+		builder.WriteString(fmt.Sprintf("%s- %s: %s%s%s%s%s\n",
+			indentStr, field.Name, field.Type, primaryKey, nullable, mixedTypesNote(field), annotationNote(field)))
 
 		// Recursively add nested fields if any
 		if len(field.Fields) > 0 {
@@ -40,6 +45,235 @@ func addNestedFields(builder *strings.Builder, fields []models.Column, indent in
 	}
 }
 
+// tableKindNote marks views, which are often curated for reporting
+func tableKindNote(table models.Table) string {
+	switch table.Kind {
+	case models.TableKindView:
+		return " (VIEW)"
+	case models.TableKindMaterializedView:
+		return " (MATERIALIZED VIEW)"
+	default:
+		return ""
+	}
+}
+
+// mixedTypesNote lists the types of a field that sampled documents disagree on
+func mixedTypesNote(column models.Column) string {
+	if !column.MixedTypes {
+		return ""
+	}
+
+	var types []string
+	for t := range column.Types {
+		if t != "null" {
+			types = append(types, t)
+		}
+	}
+	sort.Strings(types)
+	return fmt.Sprintf(" (MIXED TYPES: %s)", strings.Join(types, ", "))
+}
+
+// annotationNote appends what is known about a column's meaning, preferring
+// the user's description over the database's comment
+func annotationNote(column models.Column) string {
+	description := column.Description
+	if description == "" {
+		description = column.Comment
+	}
+
+	var notes []string
+	if description = strings.Join(strings.Fields(description), " "); description != "" {
+		notes = append(notes, description)
+	}
+	if semantics := semanticsNote(column.Semantics); semantics != "" {
+		notes = append(notes, semantics)
+	}
+
+	if len(notes) == 0 {
+		return ""
+	}
+	return " -- " + strings.Join(notes, " ")
+}
+
+// semanticsNote describes how a column's values must be interpreted
+func semanticsNote(semantics *models.ColumnSemantics) string {
+	if semantics == nil {
+		return ""
+	}
+
+	currency := "the currency"
+	if semantics.Currency != "" {
+		currency = semantics.Currency
+	}
+
+	switch semantics.Kind {
+	case models.SemanticCurrency:
+		return fmt.Sprintf("[MONEY: amount in whole units of %s]", currency)
+	case models.SemanticCurrencyCents:
+		return fmt.Sprintf("[MONEY IN CENTS: amount in minor units of %s, divide by 100 for whole amounts]", currency)
+	case models.SemanticSoftDelete:
+		return "[SOFT DELETE: set when the row is deleted, exclude those rows unless asked for deleted data]"
+	case models.SemanticEnum:
+		values := make([]string, len(semantics.Values))
+		for i, value := range semantics.Values {
+			values[i] = "'" + value + "'"
+		}
+		return fmt.Sprintf("[ENUM: one of %s]", strings.Join(values, ", "))
+	case models.SemanticUnixTimestamp:
+		return "[UNIX TIMESTAMP: seconds since 1970-01-01 UTC]"
+	case models.SemanticPercentage:
+		return "[PERCENTAGE: from 0 to 100]"
+	default:
+		return ""
+	}
+}
+
+// profileNote summarizes how a column's values are distributed, when its
+// table was profiled: how often it is null, and either its values when there
+// are few of them or their range
+func profileNote(db *models.Database, tableName, columnName string) string {
+	profile := db.Profiles[tableName]
+	if profile == nil {
+		return ""
+	}
+	column := profile.Column(columnName)
+	if column == nil {
+		return ""
+	}
+
+	var notes []string
+	if percent := math.Round(column.NullRate * 100); percent > 0 {
+		notes = append(notes, fmt.Sprintf("%g%% null", percent))
+	} else if column.NullRate > 0 {
+		notes = append(notes, "rarely null")
+	}
+
+	if column.DistinctCount > 0 && len(column.TopValues) == column.DistinctCount {
+		values := make([]string, len(column.TopValues))
+		for i, value := range column.TopValues {
+			values[i] = "'" + value.Value + "'"
+		}
+		notes = append(notes, "values "+strings.Join(values, ", "))
+	} else if column.Min != nil && column.Max != nil {
+		notes = append(notes, fmt.Sprintf("from %s to %s", profileValue(column.Min), profileValue(column.Max)))
+	}
+
+	if len(notes) == 0 {
+		return ""
+	}
+	return fmt.Sprintf(" [SAMPLED: %s]", strings.Join(notes, "; "))
+}
+
+// profileValue formats the minimum or maximum of a profiled column
+func profileValue(value interface{}) string {
+	switch v := value.(type) {
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case time.Time:
+		return v.UTC().Format(time.RFC3339)
+	case primitive.DateTime:
+		return v.Time().UTC().Format(time.RFC3339)
+	case string:
+		return "'" + v + "'"
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// writeRelationships adds the references between tables to the schema
+// description. When tableName is set only its relationships are included.
+func writeRelationships(builder *strings.Builder, relationships []models.Relationship, tableName string) {
+	var lines []string
+	for _, relationship := range relationships {
+		if tableName != "" && !relationship.Involves(tableName) {
+			continue
+		}
+
+		inferred := ""
+		if relationship.Inferred {
+			inferred = " (inferred from naming)"
+		}
+		lines = append(lines, fmt.Sprintf("  - %s.(%s) -> %s.(%s)%s",
+			relationship.FromTable, strings.Join(relationship.FromColumns, ", "),
+			relationship.ToTable, strings.Join(relationship.ToColumns, ", "),
+			inferred))
+	}
+
+	if len(lines) == 0 {
+		return
+	}
+
+	builder.WriteString("Relationships:\n")
+	builder.WriteString(strings.Join(lines, "\n"))
+	builder.WriteString("\n")
+}
+
+// writeFunctions adds the database's functions to the schema description.
+// Procedures are left out since they can't be called from a query.
+func writeFunctions(builder *strings.Builder, functions []models.Function) {
+	var lines []string
+	for _, function := range functions {
+		if function.Kind != models.RoutineFunction {
+			continue
+		}
+
+		line := fmt.Sprintf("  - %s(%s) RETURNS %s", function.Name, function.Arguments, function.ReturnType)
+		if comment := strings.Join(strings.Fields(function.Comment), " "); comment != "" {
+			line += " -- " + comment
+		}
+		lines = append(lines, line)
+	}
+
+	if len(lines) == 0 {
+		return
+	}
+
+	builder.WriteString("Functions:\n")
+	builder.WriteString(strings.Join(lines, "\n"))
+	builder.WriteString("\n")
+}
+
+// sqlWriteInstructions tells the model whether it may generate statements that modify data
+func sqlWriteInstructions(db *models.Database) string {
+	if db.AllowWrites {
+		return "Only generate INSERT, UPDATE or DELETE statements when the query explicitly asks to modify data; otherwise generate a read-only SELECT query."
+	}
+	return "Only generate read-only SELECT queries. Never generate statements that modify data or schema."
+}
+
+// aggregationInstructions restricts the model to aggregate queries when the
+// connection doesn't allow reading individual rows
+func aggregationInstructions(db *models.Database) string {
+	if db.Execution == nil || !db.Execution.AllowAggregationsOnly {
+		return ""
+	}
+	if db.Type == "mongodb" {
+		return "Only generate countDocuments, distinct, or aggregate operations with a $group or $count stage. Never return individual documents.\n"
+	}
+	return "\nOnly generate aggregate queries using COUNT, SUM, AVG, MIN, MAX or GROUP BY. Never return individual rows."
+}
+
+// mongoDBWriteInstructions describes the write operations the model may generate for MongoDB
+func mongoDBWriteInstructions(db *models.Database) string {
+	if !db.AllowWrites {
+		return "\nOnly generate read operations. Never generate operations that modify data.\n"
+	}
+	return `
+Only when the query explicitly asks to modify data, use operation "insertOne", "updateMany" or "deleteMany", for example:
+
+var collection = "users"
+var operation = "updateMany"
+*FILTER_START
+bson.M{"status": "pending"}
+*FILTER_END
+*UPDATE_START
+bson.M{"$set": bson.M{"status": "active"}}
+*UPDATE_END
+
+For insertOne, wrap the new document in *DOCUMENT_START and *DOCUMENT_END. For deleteMany, only include the filter.
+`
+}
+
 // OpenRouterRequest represents a request to the OpenRouter API
 type OpenRouterRequest struct {
 	Model    string                  `json:"model"`
@@ -68,7 +302,7 @@ type OpenRouterResponse struct {
 }
 
 // FindMatchingSchemaTable finds the closest matching schema table for a natural language query
-func FindMatchingSchemaTable(naturalQuery string, db *models.Database, cfg *config.Config) (string, error) {
+func FindMatchingSchemaTable(ctx context.Context, naturalQuery string, db *models.Database, cfg *config.Config) (string, error) {
 	startTime := time.Now()
 
 	apiKey := cfg.OpenRouterAPIKey
@@ -80,8 +314,9 @@ func FindMatchingSchemaTable(naturalQuery string, db *models.Database, cfg *conf
 	var tableNames strings.Builder
 	tableNames.WriteString("Available Collections/Tables:\n")
 
-	if db.Schema != nil {
-		for _, table := range db.Schema.Tables {
+	// Hidden tables are never offered to the model
+	if schema := db.VisibleSchema(); schema != nil {
+		for _, table := range schema.Tables {
 			tableNames.WriteString(fmt.Sprintf("- %s\n", table.Name))
 		}
 	}
@@ -104,6 +339,11 @@ Most Relevant Table/Collection:`, tableNames.String(), naturalQuery)
 		modelName = "deepseek-chat"
 	}
 
+	// Identical prompts get the same answer
+	if cached, ok := cachedResponse(ctx, modelName, prompt); ok {
+		return cached, nil
+	}
+
 	request := OpenRouterRequest{
 		Model: modelName,
 		Messages: []OpenRouterChatMessage{
@@ -125,7 +365,7 @@ Most Relevant Table/Collection:`, tableNames.String(), naturalQuery)
 		baseURL = "https://api.deepseek.com/chat/completions"
 	}
 
-	req, err := http.NewRequest("POST", baseURL, bytes.NewBuffer(requestBody))
+	req, err := http.NewRequestWithContext(ctx, "POST", baseURL, bytes.NewBuffer(requestBody))
 	if err != nil {
 		return "", fmt.Errorf("failed to create request: %v", err)
 	}
@@ -162,6 +402,7 @@ Most Relevant Table/Collection:`, tableNames.String(), naturalQuery)
 	}
 
 	matchingTable := strings.TrimSpace(response.Choices[0].Message.Content)
+	cacheResponse(ctx, modelName, prompt, matchingTable)
 	fmt.Printf("Matching table for query: %s\n", matchingTable)
 
 	generationTime := time.Since(startTime)
@@ -172,7 +413,7 @@ Most Relevant Table/Collection:`, tableNames.String(), naturalQuery)
 
 // GenerateSQL generates a database query from a natural language query using OpenRouter's DeepSeek model
 // If tableName is provided, only that table's schema will be included in the prompt
-func GenerateSQL(naturalQuery string, db *models.Database, cfg *config.Config, tableName string) (string, error) {
+func GenerateSQL(ctx context.Context, naturalQuery string, db *models.Database, cfg *config.Config, tableName string) (string, error) {
 	startTime := time.Now()
 
 	apiKey := cfg.OpenRouterAPIKey
@@ -183,14 +424,15 @@ func GenerateSQL(naturalQuery string, db *models.Database, cfg *config.Config, t
 	var schemaDesc strings.Builder
 	schemaDesc.WriteString("Database Schema:\n")
 
-	if db.Schema != nil {
-		for _, table := range db.Schema.Tables {
+	// Hidden tables are never offered to the model
+	if schema := db.VisibleSchema(); schema != nil {
+		for _, table := range schema.Tables {
 			// If tableName is provided, only include that table
 			if tableName != "" && table.Name != tableName {
 				continue
 			}
 
-			schemaDesc.WriteString(fmt.Sprintf("Collection: %s\n", table.Name))
+			schemaDesc.WriteString(fmt.Sprintf("Collection: %s%s\n", table.Name, tableKindNote(table)))
 			schemaDesc.WriteString("Fields:\n")
 
 			for _, column := range table.Columns {
@@ -203,16 +445,31 @@ func GenerateSQL(naturalQuery string, db *models.Database, cfg *config.Config, t
 					nullable = " NOT NULL"
 				}
 
-				schemaDesc.WriteString(fmt.Sprintf("  - %s: %s%s%s\n",
-					column.Name, column.Type, primaryKey, nullable))
+				schemaDesc.WriteString(fmt.Sprintf("  - %s: %s%s%s%s%s%s\n",
+					column.Name, column.Type, primaryKey, nullable, mixedTypesNote(column), annotationNote(column), profileNote(db, table.Name, column.Name)))
 
 				// Include nested fields for MongoDB documents
 				if len(column.Fields) > 0 && db.Type == "mongodb" {
 					addNestedFields(&schemaDesc, column.Fields, 4) // 4 spaces indentation for nested fields
 				}
 			}
+
+			if len(table.Indexes) > 0 {
+				schemaDesc.WriteString("Indexes:\n")
+				for _, index := range table.Indexes {
+					unique := ""
+					if index.Unique {
+						unique = " UNIQUE"
+					}
+					schemaDesc.WriteString(fmt.Sprintf("  - %s (%s)%s\n",
+						index.Name, strings.Join(index.Columns, ", "), unique))
+				}
+			}
 			schemaDesc.WriteString("\n")
 		}
+
+		writeRelationships(&schemaDesc, schema.Relationships, tableName)
+		writeFunctions(&schemaDesc, schema.Functions)
 	}
 
 	var prompt string
@@ -221,12 +478,16 @@ func GenerateSQL(naturalQuery string, db *models.Database, cfg *config.Config, t
 Given the following MongoDB database schema and natural language query, generate Go code that uses the MongoDB Go driver (go.mongodb.org/mongo-driver) to define the query.
 Return only the Go code without any explanation, comments, markdown formatting, or backticks.
 Strictly use only fields that exist in the provided schema. When a query mentions a field, match it to the closest semantically matching field name from the schema (e.g., if user asks for 'tax', use 'taxAmount' or 'vatAmount' if they exist, but never create non-existent fields like 'tax').
+Text after -- on a field describes what it contains; use it to match the query to the right fields. Follow the bracketed notes: convert cents before reporting amounts, leave out soft-deleted documents by default and only compare enum fields to their listed values. SAMPLED notes describe a sample of the data; when filtering on a field they list values for, use those exact values.
 The code must be complete, syntactically correct, and strictly use Go syntax (no JSON notation).
 Support complex queries including find with sort, limit, projection, and aggregate pipelines with match, lookup, group, unwind, etc.
 Use bson.D, bson.M, or mongo.Pipeline as appropriate for the operation.
+When using $lookup, use the fields listed under Relationships as localField and foreignField.
+When the question allows a choice, prefer filtering and sorting on indexed fields, using the leading fields of compound indexes.
 Wrap each component in specific placeholders to aid parsing, as shown below.
 For find operations, include placeholders for filter, sort, limit, and projection separately.
 For aggregate operations, include a placeholder for the pipeline.
+Use operation "countDocuments" when the question only asks how many documents match, "distinct" when it asks for the unique values of a single field, and "findOne" when it asks for a single document (e.g. the latest or first one).
 For find operations, generate code like:
 
 var collection = "users"
@@ -268,23 +529,60 @@ mongo.Pipeline{
 }
 *PIPELINE_END
 
+For countDocuments operations, generate code like:
+
+var collection = "users"
+var operation = "countDocuments"
+*FILTER_START
+bson.M{"status": "active"}
+*FILTER_END
+
+For distinct operations, generate code like:
+
+var collection = "orders"
+var operation = "distinct"
+var field = "status"
+*FILTER_START
+bson.M{}
+*FILTER_END
+
+For findOne operations, generate code like:
+
+var collection = "users"
+var operation = "findOne"
+*FILTER_START
+bson.M{"status": "active"}
+*FILTER_END
+*SORT_START
+bson.D{{"createdAt", -1}}
+*SORT_END
+*PROJECTION_START
+bson.D{{"name", 1}, {"email", 1}}
+*PROJECTION_END
+%s
 Database Schema:
 %s
 
-Natural Language Query: %s`, schemaDesc.String(), naturalQuery)
+Natural Language Query: %s`, mongoDBWriteInstructions(db)+aggregationInstructions(db), schemaDesc.String(), naturalQuery)
 	} else {
 		prompt = fmt.Sprintf(`You are an expert SQL query generator for %s databases.
 Given the following database schema and natural language query, generate a valid SQL query.
+When joining tables, join on the columns listed under Relationships.
+Entries marked VIEW or MATERIALIZED VIEW are curated reporting views; query them like tables and prefer them when they already answer the question.
+When a function listed under Functions computes what the query asks for, call it instead of re-deriving its logic.
 Only return the SQL query without any explanation or markdown formatting.
 Only use SQL syntax and functions that are compatible with %s databases.
 Do not use any database-specific functions or syntax that is not supported by %s.
 Strictly use only fields that exist in the provided schema. When a query mentions a field, match it to the closest semantically matching field name from the schema (e.g., if user asks for 'tax', use 'taxAmount' or 'vatAmount' if they exist, but never create non-existent fields like 'tax').
+Text after -- on a column describes what it contains; use it to match the query to the right columns. Follow the bracketed notes: convert cents before reporting amounts, leave out soft-deleted rows by default and only compare enum columns to their listed values. SAMPLED notes describe a sample of the data; when filtering on a column they list values for, use those exact values.
+When the question allows a choice, prefer filtering, joining and sorting on indexed columns, using the leading columns of multi-column indexes.
+%s
 
 %s
 
 Natural Language Query: %s
 
-SQL Query:`, db.Type, db.Type, db.Type, schemaDesc.String(), naturalQuery)
+SQL Query:`, db.Type, db.Type, db.Type, sqlWriteInstructions(db)+aggregationInstructions(db), schemaDesc.String(), naturalQuery)
 	}
 
 	// Use model from config or fallback to default
@@ -293,6 +591,11 @@ SQL Query:`, db.Type, db.Type, db.Type, schemaDesc.String(), naturalQuery)
 		modelName = "deepseek-chat"
 	}
 
+	// Identical prompts get the same answer
+	if cached, ok := cachedResponse(ctx, modelName, prompt); ok {
+		return cached, nil
+	}
+
 	request := OpenRouterRequest{
 		Model: modelName,
 		Messages: []OpenRouterChatMessage{
@@ -314,7 +617,7 @@ SQL Query:`, db.Type, db.Type, db.Type, schemaDesc.String(), naturalQuery)
 		baseURL = "https://api.deepseek.com/chat/completions"
 	}
 
-	req, err := http.NewRequest("POST", baseURL, bytes.NewBuffer(requestBody))
+	req, err := http.NewRequestWithContext(ctx, "POST", baseURL, bytes.NewBuffer(requestBody))
 	if err != nil {
 		return "", fmt.Errorf("failed to create request: %v", err)
 	}
@@ -348,6 +651,7 @@ SQL Query:`, db.Type, db.Type, db.Type, schemaDesc.String(), naturalQuery)
 	}
 
 	generatedQuery := strings.TrimSpace(response.Choices[0].Message.Content)
+	cacheResponse(ctx, modelName, prompt, generatedQuery)
 	fmt.Printf("Generated MongoDB query code:\n%s\n", generatedQuery)
 
 	generationTime := time.Since(startTime)
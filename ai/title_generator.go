@@ -2,6 +2,7 @@ package ai
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -12,7 +13,7 @@ import (
 )
 
 // GenerateQueryTitle generates a concise title for a natural language query using OpenRouter's Gemini model
-func GenerateQueryTitle(naturalQuery string, cfg *config.Config) (string, error) {
+func GenerateQueryTitle(ctx context.Context, naturalQuery string, cfg *config.Config) (string, error) {
 	// Get API key from config
 	apiKey := cfg.OpenRouterAPIKey
 	if apiKey == "" {
@@ -52,7 +53,7 @@ Title:`, naturalQuery)
 	}
 
 	// Create HTTP request
-	req, err := http.NewRequest("POST", baseURL, bytes.NewBuffer(requestBody))
+	req, err := http.NewRequestWithContext(ctx, "POST", baseURL, bytes.NewBuffer(requestBody))
 	if err != nil {
 		return "", fmt.Errorf("failed to create request: %v", err)
 	}
@@ -0,0 +1,296 @@
+// Package alerts evaluates the alert rules on saved queries after each run
+// and notifies the alerts' channels when they trigger or resolve. Each
+// notification is sent by a background job, so failed emails are retried.
+package alerts
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/zucced/goquery/mailer"
+	"github.com/zucced/goquery/models"
+	"github.com/zucced/goquery/notify"
+	"github.com/zucced/goquery/webhooks"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// JobType is the type of the jobs that send notifications
+const JobType = "alert_notification"
+
+// Sources of the runs alerts are evaluated after
+const (
+	SourceManual    = "manual"    // Run by a user
+	SourceScheduled = "scheduled" // Run by a card refresh interval or a report schedule
+)
+
+// notificationJob is the payload of the jobs that send notifications. The
+// state and value are those of the transition, the alert may have changed
+// again by the time the job runs.
+type notificationJob struct {
+	AlertID primitive.ObjectID `bson:"alert_id"`
+	State   models.AlertState  `bson:"state"`
+	Value   *float64           `bson:"value,omitempty"`
+}
+
+// Evaluate evaluates the enabled alerts on a query against the results of a
+// run that ended with runErr, and queues a notification for each alert that
+// triggered or resolved. A failed run leaves the alerts' states as they were
+// and is recorded as their last error. Anomaly rules only learn from
+// scheduled runs, whose regular timing their baseline depends on. Failures
+// are logged rather than returned, alerts never fail the run that caused
+// them.
+func Evaluate(ctx context.Context, store models.Store, source string, queryID primitive.ObjectID, results []models.QueryResult, runErr error) {
+	// Runs cut short by the caller giving up say nothing about the results
+	if runErr != nil && ctx.Err() != nil {
+		return
+	}
+
+	// The caller's context may be about to end, e.g. when a request returns
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+	defer cancel()
+
+	alerts, err := store.GetEnabledAlertsByQueryID(ctx, queryID)
+	if err != nil {
+		log.Printf("Failed to list alerts on query %s: %v", queryID.Hex(), err)
+		return
+	}
+	if len(alerts) == 0 {
+		return
+	}
+
+	// Alerts see the results as their owners may, so they can't reveal the
+	// values of masked columns
+	query, err := store.GetQueryByID(ctx, queryID)
+	if err != nil {
+		log.Printf("Failed to load query %s for its alerts: %v", queryID.Hex(), err)
+		return
+	}
+	if query == nil {
+		return
+	}
+
+	for _, alert := range alerts {
+		if alert.Rule.Kind == models.AlertRuleAnomaly && source != SourceScheduled {
+			continue
+		}
+		masked, err := store.NewResultMasker(alert.UserID).Mask(ctx, query, results)
+		if err != nil {
+			log.Printf("Failed to mask results for alert %s: %v", alert.ID.Hex(), err)
+			continue
+		}
+		evaluate(ctx, store, alert, masked, runErr)
+	}
+}
+
+// EvaluateCard evaluates the alerts on the query behind a dashboard card after
+// the card was refreshed. data is nil when the card couldn't be run at all.
+func EvaluateCard(ctx context.Context, store models.Store, source string, card *models.DashboardCard, data *models.CardData, runErr error) {
+	if data == nil || card.QueryID.IsZero() {
+		return
+	}
+	Evaluate(ctx, store, source, card.QueryID, data.Results, runErr)
+}
+
+// evaluate applies a single alert's rule, saves the outcome, and records an
+// event and queues a notification when its state changed
+func evaluate(ctx context.Context, store models.Store, alert *models.Alert, results []models.QueryResult, runErr error) {
+	previous := alert.State
+	now := time.Now()
+	alert.LastEvaluatedAt = &now
+	alert.LastError = ""
+
+	value, holds, sample, err := alert.Evaluate(results)
+	switch {
+	case runErr != nil:
+		alert.LastError = "The query failed: " + runErr.Error()
+	case err != nil:
+		alert.LastError = err.Error()
+	default:
+		alert.LastValue = &value
+		alert.State = models.AlertStateOK
+		if holds {
+			alert.State = models.AlertStateTriggered
+			if previous != models.AlertStateTriggered {
+				alert.LastTriggeredAt = &now
+			}
+		}
+	}
+
+	// Alerts saved before the notified state was recorded had their channels
+	// told about every transition that wasn't silenced
+	notified := alert.NotifiedState
+	if alert.NotifiedState == "" {
+		alert.NotifiedState = previous
+	}
+
+	// Snoozed alerts, and those in a maintenance window, keep their state up
+	// to date without paging anyone. Their channels are told once the
+	// silence ends if the state still differs from what they last heard.
+	notify := alert.Silence(time.Now()) == "" && alert.PendingNotification()
+	if notify {
+		alert.NotifiedState = alert.State
+	}
+
+	saved, err := store.SaveAlertEvaluation(ctx, alert, previous, notified)
+	if err != nil {
+		log.Printf("Failed to save evaluation of alert %s: %v", alert.ID.Hex(), err)
+		return
+	}
+	if !saved {
+		return
+	}
+
+	// Anomaly rules keep the history of the values they learned
+	if sample != nil && runErr == nil {
+		if err := store.CreateAlertSample(ctx, sample); err != nil {
+			log.Printf("Failed to record sample of alert %s: %v", alert.ID.Hex(), err)
+		}
+	}
+
+	// The alert's history records every trigger, and every resolve of a
+	// trigger, silenced or not. A first evaluation that finds all is well
+	// isn't an event.
+	changed := alert.State != previous && (alert.State == models.AlertStateTriggered || previous == models.AlertStateTriggered)
+	if changed {
+		event := &models.AlertEvent{AlertID: alert.ID, Type: models.AlertEventResolved, Value: alert.LastValue}
+		if alert.State == models.AlertStateTriggered {
+			event.Type = models.AlertEventTriggered
+		}
+		if err := store.CreateAlertEvent(ctx, event); err != nil {
+			log.Printf("Failed to record event of alert %s: %v", alert.ID.Hex(), err)
+		}
+	}
+
+	if !notify {
+		return
+	}
+	job := notificationJob{AlertID: alert.ID, State: alert.State, Value: alert.LastValue}
+	if _, err := store.EnqueueJob(ctx, JobType, job, "", time.Time{}); err != nil {
+		log.Printf("Failed to queue notification for alert %s: %v", alert.ID.Hex(), err)
+	}
+}
+
+// Notifier sends alert notifications through the channels of each alert
+type Notifier struct {
+	store       models.Store
+	mail        mailer.Mailer
+	hooks       *webhooks.Dispatcher
+	sender      *notify.Sender
+	frontendURL string
+}
+
+// NewNotifier creates a notifier. Deliver must be registered as the handler
+// of JobType jobs.
+func NewNotifier(store models.Store, mail mailer.Mailer, hooks *webhooks.Dispatcher, sender *notify.Sender, frontendURL string) *Notifier {
+	return &Notifier{
+		store:       store,
+		mail:        mail,
+		hooks:       hooks,
+		sender:      sender,
+		frontendURL: frontendURL,
+	}
+}
+
+// Deliver sends a queued notification, the handler of JobType jobs
+func (n *Notifier) Deliver(ctx context.Context, job *models.Job) error {
+	var notification notificationJob
+	if err := job.DecodePayload(&notification); err != nil {
+		return err
+	}
+
+	// Alerts deleted, disabled or snoozed since then are no longer wanted
+	alert, err := n.store.GetAlertByID(ctx, notification.AlertID)
+	if err != nil {
+		return err
+	}
+	if alert == nil || !alert.Enabled || alert.Silence(time.Now()) != "" {
+		return nil
+	}
+
+	query, err := n.store.GetQueryByID(ctx, alert.QueryID)
+	if err != nil {
+		return err
+	}
+	if query == nil {
+		return nil
+	}
+
+	// Email first, a failure retries the job and webhooks would be sent twice
+	if alert.Notifies(models.AlertChannelEmail) && len(alert.Recipients) > 0 {
+		if err := n.sendEmail(ctx, alert, query, notification); err != nil {
+			return err
+		}
+	}
+
+	if alert.Notifies(models.AlertChannelWebhook) {
+		n.hooks.AlertChanged(alert, query, notification.State, notification.Value)
+	}
+
+	n.sender.SendTo(alert.UserID, alert.ChannelIDs, models.NotificationSourceAlert, n.channelMessage(alert, query, notification))
+
+	return nil
+}
+
+// channelMessage builds the message notification channels get about an alert
+func (n *Notifier) channelMessage(alert *models.Alert, query *models.Query, notification notificationJob) *notify.Message {
+	title := fmt.Sprintf("Alert %q resolved", alert.Name)
+	event := webhooks.EventAlertResolved
+	if notification.State == models.AlertStateTriggered {
+		title = fmt.Sprintf("Alert %q triggered", alert.Name)
+		event = webhooks.EventAlertTriggered
+	}
+
+	body := "Rule: " + alert.Rule.String()
+	if notification.Value != nil {
+		body += "\nValue: " + strconv.FormatFloat(*notification.Value, 'g', -1, 64)
+	}
+
+	return &notify.Message{
+		Title: title,
+		Body:  body,
+		Link:  n.frontendURL + "/queries/" + query.ID.Hex(),
+		Data: map[string]interface{}{
+			"event":    event,
+			"alert_id": alert.ID,
+			"query_id": query.ID,
+			"state":    notification.State,
+			"value":    notification.Value,
+		},
+	}
+}
+
+// sendEmail emails a notification to the alert's recipients. Without a
+// configured provider there is nothing to send.
+func (n *Notifier) sendEmail(ctx context.Context, alert *models.Alert, query *models.Query, notification notificationJob) error {
+	value := ""
+	if notification.Value != nil {
+		value = strconv.FormatFloat(*notification.Value, 'g', -1, 64)
+	}
+
+	queryName := query.Name
+	if queryName == "" {
+		queryName = query.NaturalQuery
+	}
+
+	msg, err := mailer.Render(mailer.TemplateAlert, alert.Recipients, map[string]interface{}{
+		"Alert":     alert.Name,
+		"Query":     queryName,
+		"Rule":      alert.Rule.String(),
+		"Triggered": notification.State == models.AlertStateTriggered,
+		"HasValue":  notification.Value != nil,
+		"Value":     value,
+		"Link":      n.frontendURL + "/queries/" + query.ID.Hex(),
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := n.mail.Send(ctx, msg); err != nil && !errors.Is(err, mailer.ErrNotConfigured) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,736 @@
+package api
+
+import (
+	"context"
+	"log"
+	"net/mail"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/zucced/goquery/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// maxAlertSnooze caps how far ahead an alert can be snoozed
+const maxAlertSnooze = 30 * 24 * time.Hour
+
+// AlertRuleRequest represents the rule of an alert. Anomaly rules take a
+// sensitivity instead of an operator and threshold.
+type AlertRuleRequest struct {
+	Kind        string   `json:"kind" validate:"oneof=value row_count anomaly"`
+	Column      string   `json:"column" validate:"required_unless=Kind row_count,max=200"`
+	Operator    string   `json:"operator" validate:"required_unless=Kind anomaly,omitempty,oneof=gt gte lt lte eq ne"`
+	Threshold   *float64 `json:"threshold" validate:"required_unless=Kind anomaly"`
+	Sensitivity *float64 `json:"sensitivity" validate:"omitempty,gt=0,lte=10"`
+}
+
+// AlertRequest represents the request body for creating or updating an alert.
+// The query can't be changed once the alert is created.
+type AlertRequest struct {
+	QueryID    string           `json:"query_id" validate:"required,objectid"`
+	Name       string           `json:"name" validate:"max=200"`
+	Rule       AlertRuleRequest `json:"rule"`
+	Channels   []string         `json:"channels" validate:"max=2,dive,oneof=email webhook"`
+	Recipients []string         `json:"recipients" validate:"max=50,dive,notblank"`
+	ChannelIDs []string         `json:"channel_ids" validate:"max=20,dive,objectid"`
+	Enabled    *bool            `json:"enabled"`
+
+	MaintenanceWindows []MaintenanceWindowRequest `json:"maintenance_windows" validate:"max=10,dive"`
+}
+
+// MaintenanceWindowRequest represents a recurring maintenance window of an
+// alert. Windows are in the user's timezone unless one is given.
+type MaintenanceWindowRequest struct {
+	Cron     string `json:"cron" validate:"notblank,cron"`
+	Duration int    `json:"duration" validate:"min=1,max=10080"`
+	Timezone string `json:"timezone" validate:"omitempty,timezone"`
+}
+
+// SnoozeAlertRequest represents the request body for snoozing an alert,
+// until a time or for a number of minutes
+type SnoozeAlertRequest struct {
+	Until   *time.Time `json:"until" validate:"required_without=Minutes"`
+	Minutes int        `json:"minutes" validate:"omitempty,min=1,max=43200"`
+}
+
+// AlertActionRequest represents the optional request body for acknowledging
+// or resolving an alert
+type AlertActionRequest struct {
+	Note string `json:"note" validate:"max=1000"`
+}
+
+// validate normalizes an alert request and its recipients, returning an
+// error message if a recipient isn't an email address, email is chosen
+// without any or there is nothing to notify
+func (req *AlertRequest) validate() string {
+	req.Name = strings.TrimSpace(req.Name)
+	req.Rule.Column = strings.TrimSpace(req.Rule.Column)
+	if req.Rule.Kind == models.AlertRuleRowCount {
+		req.Rule.Column = ""
+	}
+
+	recipients := make([]string, 0, len(req.Recipients))
+	for _, recipient := range req.Recipients {
+		addr, err := mail.ParseAddress(strings.TrimSpace(recipient))
+		if err != nil {
+			return "Invalid recipient: " + recipient
+		}
+		recipients = append(recipients, addr.Address)
+	}
+	req.Recipients = recipients
+
+	channels := make([]string, 0, len(req.Channels))
+	seen := make(map[string]bool, len(req.Channels))
+	for _, channel := range req.Channels {
+		if !seen[channel] {
+			seen[channel] = true
+			channels = append(channels, channel)
+		}
+	}
+	req.Channels = channels
+
+	if seen[models.AlertChannelEmail] && len(req.Recipients) == 0 {
+		return "Recipients are required for email notifications"
+	}
+	if len(req.Channels) == 0 && len(req.ChannelIDs) == 0 {
+		return "channels or channel_ids are required"
+	}
+
+	return ""
+}
+
+// windows returns the maintenance windows the request describes, in
+// timezone unless a window has its own
+func (req *AlertRequest) windows(timezone string) []models.MaintenanceWindow {
+	if len(req.MaintenanceWindows) == 0 {
+		return nil
+	}
+
+	windows := make([]models.MaintenanceWindow, 0, len(req.MaintenanceWindows))
+	for _, window := range req.MaintenanceWindows {
+		if window.Timezone == "" {
+			window.Timezone = timezone
+		}
+		windows = append(windows, models.MaintenanceWindow{
+			Cron:     strings.TrimSpace(window.Cron),
+			Duration: window.Duration,
+			Timezone: window.Timezone,
+		})
+	}
+	return windows
+}
+
+// setSilenced sets on alerts whether they are snoozed or in a maintenance window now
+func setSilenced(alerts ...*models.Alert) {
+	now := time.Now()
+	for _, alert := range alerts {
+		alert.Silenced = alert.Silence(now)
+	}
+}
+
+// rule returns the alert rule the request describes
+func (req *AlertRequest) rule() models.AlertRule {
+	rule := models.AlertRule{
+		Kind:   req.Rule.Kind,
+		Column: req.Rule.Column,
+	}
+	if rule.Kind == models.AlertRuleAnomaly {
+		rule.Sensitivity = models.DefaultAnomalySensitivity
+		if req.Rule.Sensitivity != nil {
+			rule.Sensitivity = *req.Rule.Sensitivity
+		}
+		return rule
+	}
+
+	rule.Operator = req.Rule.Operator
+	rule.Threshold = *req.Rule.Threshold
+	return rule
+}
+
+// CreateAlertHandler handles creating an alert on a saved query
+func CreateAlertHandler(store models.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from context
+		userID := c.Locals("user_id").(primitive.ObjectID)
+
+		// Parse and validate request body
+		var req AlertRequest
+		if invalid := parseRequest(c, &req); invalid != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(invalid)
+		}
+
+		// Validate request
+		if msg := req.validate(); msg != "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": msg,
+			})
+		}
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Get query
+		queryID, _ := primitive.ObjectIDFromHex(req.QueryID)
+		query, err := store.GetQueryByID(ctx, queryID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve query: " + err.Error(),
+			})
+		}
+
+		if query == nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Query not found",
+			})
+		}
+
+		// Check if user can access query
+		allowed, err := store.CanAccessQuery(ctx, query, userID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to check query access: " + err.Error(),
+			})
+		}
+		if !allowed {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "You don't have permission to access this query",
+			})
+		}
+
+		// Check the notification channels belong to the user
+		channelIDs, msg, err := ownedChannelIDs(ctx, store, userID, req.ChannelIDs)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve notification channels: " + err.Error(),
+			})
+		}
+		if msg != "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": msg,
+			})
+		}
+
+		if req.Name == "" {
+			req.Name = query.Name
+		}
+
+		enabled := true
+		if req.Enabled != nil {
+			enabled = *req.Enabled
+		}
+
+		// Create alert
+		alert, err := store.CreateAlert(ctx, &models.Alert{
+			UserID:     userID,
+			QueryID:    query.ID,
+			Name:       req.Name,
+			Rule:       req.rule(),
+			Channels:   req.Channels,
+			Recipients: req.Recipients,
+			ChannelIDs: channelIDs,
+			Enabled:    enabled,
+
+			MaintenanceWindows: req.windows(preferredTimezone(ctx, store, userID)),
+		})
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to create alert: " + err.Error(),
+			})
+		}
+
+		// Return response
+		setSilenced(alert)
+		return c.Status(fiber.StatusCreated).JSON(alert)
+	}
+}
+
+// GetAlertsHandler handles listing the alerts of the current user, optionally
+// only those on a query
+func GetAlertsHandler(store models.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from context
+		userID := c.Locals("user_id").(primitive.ObjectID)
+
+		// Get query ID from query
+		var queryID primitive.ObjectID
+		if value := c.Query("query_id"); value != "" {
+			id, err := primitive.ObjectIDFromHex(value)
+			if err != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": "Invalid query ID",
+				})
+			}
+			queryID = id
+		}
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Get alerts
+		alerts, err := store.GetAlertsByUserID(ctx, userID, queryID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve alerts: " + err.Error(),
+			})
+		}
+
+		// Return response
+		setSilenced(alerts...)
+		return c.JSON(fiber.Map{
+			"alerts": alerts,
+		})
+	}
+}
+
+// GetAlertHandler handles retrieving a single alert
+func GetAlertHandler(store models.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		alert, err := loadAlert(c, store)
+		if alert == nil {
+			return err
+		}
+
+		// Return response
+		setSilenced(alert)
+		return c.JSON(alert)
+	}
+}
+
+// UpdateAlertHandler handles updating an alert's name, rule, channels and
+// whether it is enabled
+func UpdateAlertHandler(store models.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Parse and validate request body
+		var req AlertRequest
+		if invalid := parseRequest(c, &req, "QueryID"); invalid != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(invalid)
+		}
+
+		// Validate request
+		if msg := req.validate(); msg != "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": msg,
+			})
+		}
+
+		alert, err := loadAlert(c, store)
+		if alert == nil {
+			return err
+		}
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Check the notification channels belong to the user
+		channelIDs, msg, err := ownedChannelIDs(ctx, store, alert.UserID, req.ChannelIDs)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve notification channels: " + err.Error(),
+			})
+		}
+		if msg != "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": msg,
+			})
+		}
+
+		// Update fields
+		rule := req.rule()
+		ruleChanged := rule != alert.Rule
+		if req.Name != "" {
+			alert.Name = req.Name
+		}
+		alert.Rule = rule
+		alert.Channels = req.Channels
+		alert.Recipients = req.Recipients
+		alert.ChannelIDs = channelIDs
+		alert.MaintenanceWindows = req.windows(preferredTimezone(ctx, store, alert.UserID))
+		if req.Enabled != nil {
+			alert.Enabled = *req.Enabled
+		}
+
+		// Save alert
+		if err := store.UpdateAlert(ctx, alert, ruleChanged); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to update alert: " + err.Error(),
+			})
+		}
+
+		// Return response
+		setSilenced(alert)
+		return c.JSON(alert)
+	}
+}
+
+// DeleteAlertHandler handles deleting an alert
+func DeleteAlertHandler(store models.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		alert, err := loadAlert(c, store)
+		if alert == nil {
+			return err
+		}
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Delete alert
+		if err := store.DeleteAlert(ctx, alert.ID); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to delete alert: " + err.Error(),
+			})
+		}
+
+		// Return response
+		return c.JSON(fiber.Map{
+			"message": "Alert deleted successfully",
+		})
+	}
+}
+
+// SnoozeAlertHandler handles keeping an alert from notifying until a time.
+// It is still evaluated meanwhile, so its state stays current.
+func SnoozeAlertHandler(store models.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Parse and validate request body
+		var req SnoozeAlertRequest
+		if invalid := parseRequest(c, &req); invalid != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(invalid)
+		}
+
+		now := time.Now()
+		until := now.Add(time.Duration(req.Minutes) * time.Minute)
+		if req.Until != nil {
+			until = *req.Until
+		}
+		if !until.After(now) || until.After(now.Add(maxAlertSnooze)) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "until must be in the next 30 days",
+			})
+		}
+
+		alert, err := loadAlert(c, store)
+		if alert == nil {
+			return err
+		}
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Snooze alert
+		until = until.UTC()
+		if err := store.SnoozeAlert(ctx, alert.ID, &until); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to snooze alert: " + err.Error(),
+			})
+		}
+		alert.SnoozedUntil = &until
+
+		// Return response
+		setSilenced(alert)
+		return c.JSON(alert)
+	}
+}
+
+// UnsnoozeAlertHandler handles lifting an alert's snooze
+func UnsnoozeAlertHandler(store models.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		alert, err := loadAlert(c, store)
+		if alert == nil {
+			return err
+		}
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Lift snooze
+		if err := store.SnoozeAlert(ctx, alert.ID, nil); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to unsnooze alert: " + err.Error(),
+			})
+		}
+		alert.SnoozedUntil = nil
+
+		// Return response
+		setSilenced(alert)
+		return c.JSON(alert)
+	}
+}
+
+// AcknowledgeAlertHandler handles acknowledging a triggered alert, so others
+// on call know someone is looking into it
+func AcknowledgeAlertHandler(store models.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from context
+		userID := c.Locals("user_id").(primitive.ObjectID)
+
+		// Parse request body, which is optional
+		var req AlertActionRequest
+		if len(c.Body()) > 0 {
+			if invalid := parseRequest(c, &req); invalid != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(invalid)
+			}
+		}
+
+		alert, err := loadRespondableAlert(c, store)
+		if alert == nil {
+			return err
+		}
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Acknowledge alert
+		acknowledged, err := store.AcknowledgeAlert(ctx, alert, userID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to acknowledge alert: " + err.Error(),
+			})
+		}
+		if !acknowledged {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error": "Only a triggered alert that isn't acknowledged yet can be acknowledged",
+			})
+		}
+
+		recordAlertAction(ctx, store, alert, userID, models.AlertEventAcknowledged, req.Note)
+
+		// Return response
+		setSilenced(alert)
+		return c.JSON(alert)
+	}
+}
+
+// ResolveAlertHandler handles resolving a triggered alert by hand. The
+// alert's channels aren't notified, and the next run triggers it again if
+// the rule still holds.
+func ResolveAlertHandler(store models.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from context
+		userID := c.Locals("user_id").(primitive.ObjectID)
+
+		// Parse request body, which is optional
+		var req AlertActionRequest
+		if len(c.Body()) > 0 {
+			if invalid := parseRequest(c, &req); invalid != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(invalid)
+			}
+		}
+
+		alert, err := loadRespondableAlert(c, store)
+		if alert == nil {
+			return err
+		}
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Resolve alert
+		resolved, err := store.ResolveAlert(ctx, alert)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to resolve alert: " + err.Error(),
+			})
+		}
+		if !resolved {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error": "Only a triggered alert can be resolved",
+			})
+		}
+
+		recordAlertAction(ctx, store, alert, userID, models.AlertEventResolved, req.Note)
+
+		// Return response
+		setSilenced(alert)
+		return c.JSON(alert)
+	}
+}
+
+// GetAlertEventsHandler handles listing an alert's triggers, resolves and
+// acknowledgements, newest first, with pagination
+func GetAlertEventsHandler(store models.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get pagination parameters from query
+		page, err := strconv.ParseInt(c.Query("page", "1"), 10, 64)
+		if err != nil || page < 1 {
+			page = 1
+		}
+
+		limit, err := strconv.ParseInt(c.Query("limit", "20"), 10, 64)
+		if err != nil || limit < 1 || limit > 100 {
+			limit = 20
+		}
+
+		alert, err := loadAlert(c, store)
+		if alert == nil {
+			return err
+		}
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Get events
+		events, totalCount, err := store.GetAlertEvents(ctx, alert.ID, page, limit)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve alert events: " + err.Error(),
+			})
+		}
+
+		// Calculate pagination metadata
+		totalPages := (totalCount + limit - 1) / limit
+
+		// Return response with pagination metadata
+		return c.JSON(fiber.Map{
+			"events": events,
+			"pagination": fiber.Map{
+				"total": totalCount,
+				"page":  page,
+				"limit": limit,
+				"pages": totalPages,
+			},
+		})
+	}
+}
+
+// recordAlertAction records an action a user took on an alert in its
+// history. The action already happened, so a failure is only logged.
+func recordAlertAction(ctx context.Context, store models.Store, alert *models.Alert, userID primitive.ObjectID, eventType, note string) {
+	event := &models.AlertEvent{
+		AlertID: alert.ID,
+		Type:    eventType,
+		ActorID: &userID,
+		Note:    strings.TrimSpace(note),
+	}
+	if user, err := store.GetUserByID(ctx, userID); err == nil && user != nil {
+		event.ActorEmail = user.Email
+	}
+
+	if err := store.CreateAlertEvent(ctx, event); err != nil {
+		log.Printf("Failed to record event of alert %s: %v", alert.ID.Hex(), err)
+	}
+}
+
+// GetAlertSamplesHandler handles listing the values an anomaly rule learned,
+// with how each compared to its baseline
+func GetAlertSamplesHandler(store models.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get limit from query
+		limit := c.QueryInt("limit", 50)
+		if limit < 1 || limit > 200 {
+			limit = 50
+		}
+
+		alert, err := loadAlert(c, store)
+		if alert == nil {
+			return err
+		}
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Get samples
+		samples, err := store.GetAlertSamples(ctx, alert.ID, limit)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve alert samples: " + err.Error(),
+			})
+		}
+
+		// Return response
+		return c.JSON(fiber.Map{
+			"baseline": alert.Baseline,
+			"samples":  samples,
+		})
+	}
+}
+
+// loadAlert resolves the alert in the request path and checks the user owns it.
+// When the alert is nil the returned error is the response already written.
+func loadAlert(c *fiber.Ctx, store models.Store) (*models.Alert, error) {
+	return findAlert(c, store, func(ctx context.Context, alert *models.Alert, userID primitive.ObjectID) (bool, error) {
+		return alert.UserID == userID, nil
+	})
+}
+
+// loadRespondableAlert resolves the alert in the request path and checks the
+// user may respond to it, see canRespondToAlert
+func loadRespondableAlert(c *fiber.Ctx, store models.Store) (*models.Alert, error) {
+	return findAlert(c, store, func(ctx context.Context, alert *models.Alert, userID primitive.ObjectID) (bool, error) {
+		return canRespondToAlert(ctx, store, alert, userID)
+	})
+}
+
+// canRespondToAlert reports whether a user may acknowledge or resolve an
+// alert: its owner, the recipients it emails and whoever can read its query,
+// so others on call can respond when it fires
+func canRespondToAlert(ctx context.Context, store models.Store, alert *models.Alert, userID primitive.ObjectID) (bool, error) {
+	if alert.UserID == userID {
+		return true, nil
+	}
+
+	user, err := store.GetUserByID(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	if user != nil {
+		for _, recipient := range alert.Recipients {
+			if strings.EqualFold(recipient, user.Email) {
+				return true, nil
+			}
+		}
+	}
+
+	query, err := store.GetQueryByID(ctx, alert.QueryID)
+	if err != nil || query == nil {
+		return false, err
+	}
+	return store.CanAccessQuery(ctx, query, userID)
+}
+
+// findAlert resolves the alert in the request path and checks the user may
+// use it. Alerts the user may not use are reported as not found.
+func findAlert(c *fiber.Ctx, store models.Store, allowed func(context.Context, *models.Alert, primitive.ObjectID) (bool, error)) (*models.Alert, error) {
+	// Get user ID from context
+	userID := c.Locals("user_id").(primitive.ObjectID)
+
+	// Get alert ID from params
+	alertID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return nil, c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid alert ID",
+		})
+	}
+
+	// Get the request context
+	ctx := c.UserContext()
+
+	// Get alert
+	alert, err := store.GetAlertByID(ctx, alertID)
+	if err != nil {
+		return nil, c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to retrieve alert: " + err.Error(),
+		})
+	}
+	if alert == nil {
+		return nil, c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Alert not found",
+		})
+	}
+
+	// Check the user may use the alert
+	ok, err := allowed(ctx, alert, userID)
+	if err != nil {
+		return nil, c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to check alert access: " + err.Error(),
+		})
+	}
+	if !ok {
+		return nil, c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Alert not found",
+		})
+	}
+
+	return alert, nil
+}
@@ -0,0 +1,65 @@
+package api
+
+import (
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/zucced/goquery/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// GetAuditLogHandler handles listing audit entries, newest first
+func GetAuditLogHandler(store models.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get filters from query
+		filter := models.AuditFilter{
+			Method:     strings.ToUpper(c.Query("method")),
+			PathPrefix: c.Query("path"),
+			Limit:      c.QueryInt("limit", 50),
+		}
+		if filter.Limit < 1 || filter.Limit > 200 {
+			filter.Limit = 50
+		}
+
+		if userID := c.Query("user_id"); userID != "" {
+			id, err := primitive.ObjectIDFromHex(userID)
+			if err != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": "Invalid user ID",
+				})
+			}
+			filter.UserID = id
+		}
+
+		for param, bound := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+			value := c.Query(param)
+			if value == "" {
+				continue
+			}
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": param + " must be an RFC 3339 timestamp",
+				})
+			}
+			*bound = t
+		}
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Get entries
+		entries, err := store.GetAuditEntries(ctx, filter)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve audit log: " + err.Error(),
+			})
+		}
+
+		// Return response
+		return c.JSON(fiber.Map{
+			"entries": entries,
+		})
+	}
+}
@@ -2,10 +2,11 @@ package api
 
 import (
 	"context"
-	"time"
+	"log"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/zucced/goquery/config"
+	"github.com/zucced/goquery/demo"
 	"github.com/zucced/goquery/middleware"
 	"github.com/zucced/goquery/models"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -13,15 +14,21 @@ import (
 
 // SignupRequest represents the request body for signup
 type SignupRequest struct {
-	Email    string `json:"email"`
-	Password string `json:"password"`
-	Name     string `json:"name"`
+	Email    string `json:"email" validate:"required,email,max=254"`
+	Password string `json:"password" validate:"required,max=72"`
+	Name     string `json:"name" validate:"max=100"`
 }
 
 // LoginRequest represents the request body for login
 type LoginRequest struct {
-	Email    string `json:"email"`
-	Password string `json:"password"`
+	Email    string `json:"email" validate:"required"`
+	Password string `json:"password" validate:"required"`
+}
+
+// ChangePasswordRequest represents the request body for changing the password
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" validate:"required"`
+	NewPassword     string `json:"new_password" validate:"required,min=8,max=72"`
 }
 
 // AuthResponse represents the response for authentication endpoints
@@ -30,38 +37,36 @@ type AuthResponse struct {
 	User  *models.User `json:"user"`
 }
 
-// SignupHandler handles user registration
-func SignupHandler(cfg *config.Config) fiber.Handler {
+// SignupHandler handles user registration. With sample data on, new users
+// start with the sample database, example queries and a starter dashboard.
+func SignupHandler(store models.Store, cfg *config.Config, provisioner *demo.Provisioner) fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		// Parse request body
+		// Parse and validate request body
 		var req SignupRequest
-		if err := c.BodyParser(&req); err != nil {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": "Invalid request body",
-			})
-		}
-
-		// Validate required fields
-		if req.Email == "" || req.Password == "" {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": "Email and password are required",
-			})
+		if invalid := parseRequest(c, &req); invalid != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(invalid)
 		}
 
-		// Create context with timeout
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
+		// Get the request context
+		ctx := c.UserContext()
 
 		// Create user
-		user, err := models.CreateUser(ctx, req.Email, req.Password, req.Name)
+		user, err := store.CreateUser(ctx, req.Email, req.Password, req.Name)
 		if err != nil {
 			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 				"error": err.Error(),
 			})
 		}
 
-		// Generate JWT token
-		token, err := middleware.GenerateToken(user.ID, cfg)
+		// Give new users something to look at
+		if cfg.SampleData && provisioner != nil {
+			if _, err := provisioner.Onboard(ctx, user.ID); err != nil {
+				log.Printf("Failed to add sample data for %s: %v", user.Email, err)
+			}
+		}
+
+		// Start a session and generate its JWT token
+		token, err := issueToken(ctx, store, c, cfg, user.ID)
 		if err != nil {
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 				"error": "Failed to generate token",
@@ -77,29 +82,19 @@ func SignupHandler(cfg *config.Config) fiber.Handler {
 }
 
 // LoginHandler handles user login
-func LoginHandler(cfg *config.Config) fiber.Handler {
+func LoginHandler(store models.Store, cfg *config.Config) fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		// Parse request body
+		// Parse and validate request body
 		var req LoginRequest
-		if err := c.BodyParser(&req); err != nil {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": "Invalid request body",
-			})
-		}
-
-		// Validate required fields
-		if req.Email == "" || req.Password == "" {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": "Email and password are required",
-			})
+		if invalid := parseRequest(c, &req); invalid != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(invalid)
 		}
 
-		// Create context with timeout
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
+		// Get the request context
+		ctx := c.UserContext()
 
 		// Get user by email
-		user, err := models.GetUserByEmail(ctx, req.Email)
+		user, err := store.GetUserByEmail(ctx, req.Email)
 		if err != nil || user == nil {
 			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
 				"error": "Invalid email or password",
@@ -113,8 +108,21 @@ func LoginHandler(cfg *config.Config) fiber.Handler {
 			})
 		}
 
-		// Generate JWT token
-		token, err := middleware.GenerateToken(user.ID, cfg)
+		// Organizations can require their members to sign in through SSO
+		requiresSSO, err := store.RequiresSSO(ctx, user)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to check sign in method: " + err.Error(),
+			})
+		}
+		if requiresSSO {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "Your organization requires signing in with SSO",
+			})
+		}
+
+		// Start a session and generate its JWT token
+		token, err := issueToken(ctx, store, c, cfg, user.ID)
 		if err != nil {
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 				"error": "Failed to generate token",
@@ -130,17 +138,16 @@ func LoginHandler(cfg *config.Config) fiber.Handler {
 }
 
 // MeHandler returns the current authenticated user
-func MeHandler() fiber.Handler {
+func MeHandler(store models.Store) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		// Get user ID from context
 		userID := c.Locals("user_id").(primitive.ObjectID)
 
-		// Create context with timeout
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
+		// Get the request context
+		ctx := c.UserContext()
 
 		// Get user by ID
-		user, err := models.GetUserByID(ctx, userID)
+		user, err := store.GetUserByID(ctx, userID)
 		if err != nil || user == nil {
 			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
 				"error": "User not found",
@@ -151,3 +158,68 @@ func MeHandler() fiber.Handler {
 		return c.JSON(user)
 	}
 }
+
+// ChangePasswordHandler handles changing the current user's password. Other
+// devices are signed out, the one making the request stays signed in.
+func ChangePasswordHandler(store models.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID and session ID from context
+		userID := c.Locals("user_id").(primitive.ObjectID)
+		sessionID := c.Locals("session_id").(primitive.ObjectID)
+
+		// Parse and validate request body
+		var req ChangePasswordRequest
+		if invalid := parseRequest(c, &req); invalid != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(invalid)
+		}
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Get user by ID
+		user, err := store.GetUserByID(ctx, userID)
+		if err != nil || user == nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "User not found",
+			})
+		}
+
+		// Verify current password
+		if !models.VerifyPassword(user.PasswordHash, req.CurrentPassword) {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "Current password is incorrect",
+			})
+		}
+
+		// Update password
+		if err := store.UpdatePassword(ctx, userID, req.NewPassword); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to change password: " + err.Error(),
+			})
+		}
+
+		// Sign out every other device that used the old password
+		if _, err := store.RevokeUserSessions(ctx, userID, sessionID); err != nil {
+			log.Printf("Failed to revoke sessions for user %s: %v", userID.Hex(), err)
+		}
+
+		// Outstanding reset links were issued for the old password
+		if err := store.InvalidatePasswordResetTokens(ctx, userID); err != nil {
+			log.Printf("Failed to invalidate password reset tokens for user %s: %v", userID.Hex(), err)
+		}
+
+		// Return response
+		return c.JSON(fiber.Map{
+			"message": "Password changed successfully",
+		})
+	}
+}
+
+// issueToken starts a session for the requesting device and generates its token
+func issueToken(ctx context.Context, store models.Store, c *fiber.Ctx, cfg *config.Config, userID primitive.ObjectID) (string, error) {
+	session, err := store.CreateSession(ctx, userID, c.Get(fiber.HeaderUserAgent), c.IP(), cfg.JWTExpiry)
+	if err != nil {
+		return "", err
+	}
+	return middleware.GenerateToken(userID, session.ID, cfg)
+}
@@ -0,0 +1,358 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/zucced/goquery/cache"
+	"github.com/zucced/goquery/limiter"
+	"github.com/zucced/goquery/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// CardDataResponse represents a card's cached data along with freshness metadata
+type CardDataResponse struct {
+	*models.CardData
+	Source          string  `json:"source"`
+	RefreshInterval int     `json:"refresh_interval,omitempty"`
+	AgeSeconds      float64 `json:"age_seconds"`
+	Stale           bool    `json:"stale"`
+
+	Metric *models.MetricSummary `json:"metric,omitempty"` // Value, change and threshold status for metric cards
+	Chart  *CardChart            `json:"chart,omitempty"`  // Series of chart cards with a transform
+}
+
+// CardChart is a chart card's data reshaped by its transform, or why it
+// couldn't be
+type CardChart struct {
+	*models.TransformedChart
+	Error string `json:"error,omitempty"`
+}
+
+// variableParamPrefix marks query parameters that carry dashboard variable values
+const variableParamPrefix = "var."
+
+// GetCardDataHandler handles retrieving the data for a dashboard card. Without
+// variable values the cached data is returned; when values are supplied as
+// var.<name> query parameters the card's query is run with them, and repeated
+// runs with the same values are served from resultCache. Chart dates are
+// bucketed in the tz parameter's timezone, falling back to the user's.
+func GetCardDataHandler(store models.Store, execLimiter *limiter.ExecutionLimiter, resultCache cache.Cache) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from context
+		userID := c.Locals("user_id").(primitive.ObjectID)
+
+		// Get dashboard ID and card ID from params
+		dashboardID, err := primitive.ObjectIDFromHex(c.Params("id"))
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid dashboard ID",
+			})
+		}
+
+		cardID, err := primitive.ObjectIDFromHex(c.Params("cardId"))
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid card ID",
+			})
+		}
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Get dashboard
+		dashboard, err := store.GetDashboardByID(ctx, dashboardID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve dashboard: " + err.Error(),
+			})
+		}
+
+		// Check if dashboard exists
+		if dashboard == nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Dashboard not found",
+			})
+		}
+
+		// Check if user can view dashboard
+		if !dashboard.CanView(userID) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "You don't have permission to access this dashboard",
+			})
+		}
+
+		// Bucket chart dates in the requested timezone, falling back to the user's
+		location, err := chartLocation(ctx, store, c.Query("tz"), userID)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+
+		return serveCardData(ctx, store, c, execLimiter, resultCache, dashboard, cardID, location)
+	}
+}
+
+// serveCardData writes the data for a card on an already authorized dashboard
+func serveCardData(ctx context.Context, store models.Store, c *fiber.Ctx, execLimiter *limiter.ExecutionLimiter, resultCache cache.Cache, dashboard *models.Dashboard, cardID primitive.ObjectID, location *time.Location) error {
+	// Find the card
+	card := findCard(dashboard, cardID)
+	if card == nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Card not found in dashboard",
+		})
+	}
+
+	if card.QueryID.IsZero() {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Card has no query",
+		})
+	}
+
+	// Collect variable values from query parameters
+	supplied := suppliedVariables(c)
+	if len(supplied) > 0 {
+		values, err := models.ResolveVariableValues(dashboard.Variables, supplied)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+
+		query, err := store.GetQueryByID(ctx, card.QueryID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve query: " + err.Error(),
+			})
+		}
+
+		// Queries that don't use variables are served from the cache
+		if query != nil && models.HasQueryVariables(query.GeneratedSQL) {
+			return runCardWithVariables(c, store, execLimiter, resultCache, dashboard, card, query, values, location)
+		}
+	}
+
+	// Resolve the card data from the cache or the query's last results
+	masker := store.NewResultMasker(requestViewer(c))
+	response, err := resolveCardData(ctx, store, masker, execLimiter, dashboard, card, location)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to retrieve card data: " + err.Error(),
+		})
+	}
+
+	if response == nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "No data available for this card",
+		})
+	}
+
+	// Return response
+	return c.JSON(response)
+}
+
+// suppliedVariables collects the dashboard variable values passed as var.<name>
+// query parameters
+func suppliedVariables(c *fiber.Ctx) map[string]string {
+	supplied := make(map[string]string)
+	for key, value := range c.Queries() {
+		if strings.HasPrefix(key, variableParamPrefix) {
+			supplied[strings.TrimPrefix(key, variableParamPrefix)] = value
+		}
+	}
+	return supplied
+}
+
+// runCardWithVariables runs a card's query with the given variable values and
+// writes the live result
+func runCardWithVariables(c *fiber.Ctx, store models.Store, execLimiter *limiter.ExecutionLimiter, resultCache cache.Cache, dashboard *models.Dashboard, card *models.DashboardCard, query *models.Query, values map[string]interface{}, location *time.Location) error {
+	// Get the request context
+	ctx := c.UserContext()
+
+	data, err := runCardQueryCached(ctx, store, execLimiter, resultCache, dashboard, card, query, values)
+	if data == nil {
+		if errors.Is(err, limiter.ErrLimitExceeded) {
+			return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to run card query: " + err.Error(),
+		})
+	}
+
+	// Mask the results the user may not see
+	if err := store.NewResultMasker(requestViewer(c)).MaskCardData(ctx, data); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to mask results: " + err.Error(),
+		})
+	}
+
+	// Failed executions are reported through the data's status and error
+	return c.JSON(&CardDataResponse{
+		CardData:        data,
+		Source:          "live",
+		RefreshInterval: card.RefreshInterval,
+		Metric:          metricSummary(card, data),
+		Chart:           cardChart(card, data, location),
+	})
+}
+
+// runCardQueryCached runs a card's query with variable values like the store's
+// RunCardQuery, returning the result of an identical earlier run while it is in
+// the result cache. Only successful runs are cached.
+func runCardQueryCached(ctx context.Context, store models.Store, execLimiter *limiter.ExecutionLimiter, resultCache cache.Cache, dashboard *models.Dashboard, card *models.DashboardCard, query *models.Query, values map[string]interface{}) (*models.CardData, error) {
+	if resultCache == nil {
+		return store.RunCardQuery(ctx, execLimiter, dashboard, card, values)
+	}
+
+	key, err := cardResultKey(dashboard, card, query, values)
+	if err != nil {
+		return store.RunCardQuery(ctx, execLimiter, dashboard, card, values)
+	}
+
+	if cached, ok := resultCache.Get(ctx, key); ok {
+		// Numbers are kept as written so large integers don't lose precision
+		decoder := json.NewDecoder(bytes.NewReader(cached))
+		decoder.UseNumber()
+		var data models.CardData
+		if err := decoder.Decode(&data); err == nil {
+			return &data, nil
+		}
+	}
+
+	data, err := store.RunCardQuery(ctx, execLimiter, dashboard, card, values)
+	if err == nil && data != nil {
+		if encoded, err := json.Marshal(data); err == nil {
+			resultCache.Set(ctx, key, encoded)
+		}
+	}
+	return data, err
+}
+
+// cardResultKey identifies a run of a card's query with variable values. It
+// changes with the query text and connection, so edited queries aren't served
+// stale results.
+func cardResultKey(dashboard *models.Dashboard, card *models.DashboardCard, query *models.Query, values map[string]interface{}) (string, error) {
+	// Maps are encoded with sorted keys, so equal values give equal keys
+	encodedValues, err := json.Marshal(values)
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha256.New()
+	for _, part := range []string{dashboard.ID.Hex(), card.ID.Hex(), query.ID.Hex(), query.DatabaseID.Hex(), query.GeneratedSQL, string(encodedValues)} {
+		hash.Write([]byte(part))
+		hash.Write([]byte{0})
+	}
+	return "card:" + hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// metricSummary summarizes a metric card's data, or returns nil for other cards.
+// The previous value is only known for data cached by background refreshes.
+func metricSummary(card *models.DashboardCard, data *models.CardData) *models.MetricSummary {
+	if card.Type != models.CardTypeMetric || card.Metric == nil {
+		return nil
+	}
+
+	value := data.Value
+	if value == nil {
+		value = card.Metric.ExtractMetricValue(data.Results)
+	}
+
+	return card.Metric.Summarize(value, data.PreviousValue)
+}
+
+// cardChart reshapes a chart card's data by its transform, or returns nil for
+// cards without one. Dates are bucketed in location.
+func cardChart(card *models.DashboardCard, data *models.CardData, location *time.Location) *CardChart {
+	if card.Type != models.CardTypeChart || card.Transform == nil {
+		return nil
+	}
+
+	chart, err := card.Transform.Apply(data.Results, location)
+	if err != nil {
+		return &CardChart{Error: err.Error()}
+	}
+	return &CardChart{TransformedChart: chart}
+}
+
+// resolveCardData returns the cached snapshot for a card, falling back to the
+// last stored results of the underlying query when nothing is cached yet.
+// Cards of materialized queries are brought up to their latest refresh first.
+// The results are masked for the viewer of the masker.
+func resolveCardData(ctx context.Context, store models.Store, masker *models.ResultMasker, execLimiter *limiter.ExecutionLimiter, dashboard *models.Dashboard, card *models.DashboardCard, location *time.Location) (*CardDataResponse, error) {
+	data, err := store.GetCardData(ctx, dashboard.ID, card.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	materialization, err := store.GetQueryMaterialization(ctx, card.QueryID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Copying the refreshed results doesn't run the query
+	refreshedAt := materialization != nil && materialization.RefreshedAt != nil
+	if refreshedAt && (data == nil || data.RefreshedAt.Before(*materialization.RefreshedAt)) {
+		if refreshed, err := store.RefreshCardData(ctx, execLimiter, dashboard, card); err == nil {
+			data = refreshed
+		}
+	}
+
+	source := "cache"
+	if data == nil || data.RefreshedAt.IsZero() {
+		query, err := store.GetQueryByID(ctx, card.QueryID)
+		if err != nil {
+			return nil, err
+		}
+		if query == nil {
+			return nil, nil
+		}
+
+		source = "query"
+		data = &models.CardData{
+			DashboardID:   dashboard.ID,
+			CardID:        card.ID,
+			QueryID:       query.ID,
+			Status:        query.Status,
+			Columns:       query.Columns,
+			Results:       query.Results,
+			Error:         query.Error,
+			ExecutionTime: query.ExecutionTime,
+			RefreshedAt:   query.UpdatedAt,
+			LastAttemptAt: query.UpdatedAt,
+		}
+	}
+
+	if err := masker.MaskCardData(ctx, data); err != nil {
+		return nil, err
+	}
+
+	age := time.Since(data.RefreshedAt)
+	stale := card.RefreshInterval > 0 && age > time.Duration(card.RefreshInterval)*time.Second
+
+	// Materialized results are as fresh as the query's schedule makes them
+	if materialization != nil {
+		source = "materialized"
+		stale = false
+	}
+
+	return &CardDataResponse{
+		CardData:        data,
+		Source:          source,
+		RefreshInterval: card.RefreshInterval,
+		AgeSeconds:      age.Seconds(),
+		Stale:           stale,
+		Metric:          metricSummary(card, data),
+		Chart:           cardChart(card, data, location),
+	}, nil
+}
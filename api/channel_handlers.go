@@ -0,0 +1,406 @@
+package api
+
+import (
+	"context"
+	"net/mail"
+	"net/url"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/zucced/goquery/config"
+	"github.com/zucced/goquery/models"
+	"github.com/zucced/goquery/netguard"
+	"github.com/zucced/goquery/notify"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// NotificationChannelRequest represents the request body for creating or
+// updating a notification channel. The type can't be changed once created.
+type NotificationChannelRequest struct {
+	Name        string   `json:"name" validate:"notblank,max=200"`
+	Type        string   `json:"type" validate:"oneof=email slack webhook in_app"`
+	Emails      []string `json:"emails" validate:"max=50,dive,notblank"`
+	URL         string   `json:"url" validate:"max=2048"`
+	JobFailures bool     `json:"job_failures"`
+}
+
+// validate normalizes a channel request for a channel of the given type,
+// returning an error message if its destination is missing, invalid or an
+// address the server must not reach
+func (req *NotificationChannelRequest) validate(ctx context.Context, channelType string) string {
+	req.Name = strings.TrimSpace(req.Name)
+	req.URL = strings.TrimSpace(req.URL)
+
+	emails := make([]string, 0, len(req.Emails))
+	for _, email := range req.Emails {
+		addr, err := mail.ParseAddress(strings.TrimSpace(email))
+		if err != nil {
+			return "Invalid email: " + email
+		}
+		emails = append(emails, addr.Address)
+	}
+	req.Emails = emails
+
+	switch channelType {
+	case models.ChannelTypeEmail:
+		if len(req.Emails) == 0 {
+			return "emails are required for email channels"
+		}
+		req.URL = ""
+	case models.ChannelTypeSlack:
+		parsed, err := url.Parse(req.URL)
+		if err != nil || parsed.Scheme != "https" || parsed.Host == "" {
+			return "url must be the https URL of a Slack incoming webhook"
+		}
+		if err := netguard.CheckURL(ctx, req.URL); err != nil {
+			return err.Error()
+		}
+		req.Emails = nil
+	case models.ChannelTypeWebhook:
+		if err := netguard.CheckURL(ctx, req.URL); err != nil {
+			return err.Error()
+		}
+		req.Emails = nil
+	default:
+		req.Emails = nil
+		req.URL = ""
+	}
+
+	return ""
+}
+
+// apply sets the channel's settings from the request
+func (req *NotificationChannelRequest) apply(channel *models.NotificationChannel) {
+	channel.Name = req.Name
+	channel.Emails = req.Emails
+	channel.JobFailures = req.JobFailures
+	if channel.Type == models.ChannelTypeSlack {
+		channel.SlackURL = req.URL
+	} else {
+		channel.URL = req.URL
+	}
+}
+
+// NotificationChannelCreatedResponse is a new channel together with its
+// signing secret for generic webhook channels, which isn't returned again
+type NotificationChannelCreatedResponse struct {
+	*models.NotificationChannel
+	Secret string `json:"secret,omitempty"`
+}
+
+// CreateNotificationChannelHandler handles creating a notification channel
+// for the current user
+func CreateNotificationChannelHandler(store models.Store, cfg *config.Config) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from context
+		userID := c.Locals("user_id").(primitive.ObjectID)
+
+		// Parse and validate request body
+		var req NotificationChannelRequest
+		if invalid := parseRequest(c, &req); invalid != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(invalid)
+		}
+
+		// Validate request
+		if msg := req.validate(c.UserContext(), req.Type); msg != "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": msg,
+			})
+		}
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Only administrators decide where job failures go
+		if req.JobFailures {
+			admin, err := isAdmin(ctx, store, cfg, userID)
+			if err != nil {
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+					"error": "Failed to retrieve user: " + err.Error(),
+				})
+			}
+			if !admin {
+				return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+					"error": "Only administrators can send job failures to a channel",
+				})
+			}
+		}
+
+		// Create channel
+		channel := &models.NotificationChannel{
+			UserID: userID,
+			Type:   req.Type,
+		}
+		req.apply(channel)
+
+		channel, err := store.CreateNotificationChannel(ctx, channel)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to create notification channel: " + err.Error(),
+			})
+		}
+
+		// Return response
+		return c.Status(fiber.StatusCreated).JSON(NotificationChannelCreatedResponse{
+			NotificationChannel: channel,
+			Secret:              channel.Secret,
+		})
+	}
+}
+
+// GetNotificationChannelsHandler handles listing the notification channels
+// of the current user
+func GetNotificationChannelsHandler(store models.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from context
+		userID := c.Locals("user_id").(primitive.ObjectID)
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Get channels
+		channels, err := store.GetNotificationChannelsByUserID(ctx, userID, nil)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve notification channels: " + err.Error(),
+			})
+		}
+
+		// Return response
+		return c.JSON(fiber.Map{
+			"channels": channels,
+		})
+	}
+}
+
+// GetNotificationChannelHandler handles retrieving a single notification channel
+func GetNotificationChannelHandler(store models.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		channel, err := loadNotificationChannel(c, store)
+		if channel == nil {
+			return err
+		}
+
+		// Return response
+		return c.JSON(channel)
+	}
+}
+
+// UpdateNotificationChannelHandler handles updating a notification channel's
+// name, destination and whether it receives job failures
+func UpdateNotificationChannelHandler(store models.Store, cfg *config.Config) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Parse and validate request body
+		var req NotificationChannelRequest
+		if invalid := parseRequest(c, &req, "Type"); invalid != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(invalid)
+		}
+
+		channel, err := loadNotificationChannel(c, store)
+		if channel == nil {
+			return err
+		}
+
+		// Validate request
+		if msg := req.validate(c.UserContext(), channel.Type); msg != "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": msg,
+			})
+		}
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Only administrators decide where job failures go
+		if req.JobFailures != channel.JobFailures {
+			admin, err := isAdmin(ctx, store, cfg, channel.UserID)
+			if err != nil {
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+					"error": "Failed to retrieve user: " + err.Error(),
+				})
+			}
+			if !admin {
+				return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+					"error": "Only administrators can send job failures to a channel",
+				})
+			}
+		}
+
+		// Update fields
+		req.apply(channel)
+
+		// Save channel
+		if err := store.UpdateNotificationChannel(ctx, channel); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to update notification channel: " + err.Error(),
+			})
+		}
+
+		// Return response
+		return c.JSON(channel)
+	}
+}
+
+// DeleteNotificationChannelHandler handles deleting a notification channel
+// and its delivery log. Alerts and reports that refer to it skip it.
+func DeleteNotificationChannelHandler(store models.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		channel, err := loadNotificationChannel(c, store)
+		if channel == nil {
+			return err
+		}
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Delete channel
+		if err := store.DeleteNotificationChannel(ctx, channel.ID); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to delete notification channel: " + err.Error(),
+			})
+		}
+
+		// Return response
+		return c.JSON(fiber.Map{
+			"message": "Notification channel deleted successfully",
+		})
+	}
+}
+
+// TestNotificationChannelHandler handles sending a test notification
+// through a channel
+func TestNotificationChannelHandler(store models.Store, sender *notify.Sender) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		channel, err := loadNotificationChannel(c, store)
+		if channel == nil {
+			return err
+		}
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Queue test notification
+		delivery, err := sender.Test(ctx, channel)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to queue test notification: " + err.Error(),
+			})
+		}
+
+		// Return response
+		return c.Status(fiber.StatusAccepted).JSON(delivery)
+	}
+}
+
+// GetChannelDeliveriesHandler handles listing the delivery log of a
+// notification channel
+func GetChannelDeliveriesHandler(store models.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get limit from query
+		limit := c.QueryInt("limit", 50)
+		if limit < 1 || limit > 200 {
+			limit = 50
+		}
+
+		channel, err := loadNotificationChannel(c, store)
+		if channel == nil {
+			return err
+		}
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Get deliveries
+		deliveries, err := store.GetChannelDeliveries(ctx, channel.ID, limit)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve channel deliveries: " + err.Error(),
+			})
+		}
+
+		// Return response
+		return c.JSON(deliveries)
+	}
+}
+
+// loadNotificationChannel resolves the channel in the request path and checks the user owns it.
+// When the channel is nil the returned error is the response already written.
+func loadNotificationChannel(c *fiber.Ctx, store models.Store) (*models.NotificationChannel, error) {
+	// Get user ID from context
+	userID := c.Locals("user_id").(primitive.ObjectID)
+
+	// Get channel ID from params
+	channelID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return nil, c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid channel ID",
+		})
+	}
+
+	// Get the request context
+	ctx := c.UserContext()
+
+	// Get channel
+	channel, err := store.GetNotificationChannelByID(ctx, channelID)
+	if err != nil {
+		return nil, c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to retrieve notification channel: " + err.Error(),
+		})
+	}
+
+	// Check if channel exists and belongs to user
+	if channel == nil || channel.UserID != userID {
+		return nil, c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Notification channel not found",
+		})
+	}
+
+	return channel, nil
+}
+
+// isAdmin reports whether a user is an administrator
+func isAdmin(ctx context.Context, store models.Store, cfg *config.Config, userID primitive.ObjectID) (bool, error) {
+	user, err := store.GetUserByID(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	return user != nil && cfg.IsAdmin(user.Email), nil
+}
+
+// ownedChannelIDs parses the notification channel IDs of a request and
+// checks the user owns each channel, returning a message for the first one
+// that isn't found
+func ownedChannelIDs(ctx context.Context, store models.Store, userID primitive.ObjectID, raw []string) ([]primitive.ObjectID, string, error) {
+	if len(raw) == 0 {
+		return nil, "", nil
+	}
+
+	ids := make([]primitive.ObjectID, 0, len(raw))
+	seen := make(map[primitive.ObjectID]bool, len(raw))
+	for _, value := range raw {
+		id, err := primitive.ObjectIDFromHex(value)
+		if err != nil {
+			return nil, "Invalid channel ID: " + value, nil
+		}
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+
+	channels, err := store.GetNotificationChannelsByUserID(ctx, userID, ids)
+	if err != nil {
+		return nil, "", err
+	}
+	found := make(map[primitive.ObjectID]bool, len(channels))
+	for _, channel := range channels {
+		found[channel.ID] = true
+	}
+	for _, id := range ids {
+		if !found[id] {
+			return nil, "Notification channel not found: " + id.Hex(), nil
+		}
+	}
+
+	return ids, "", nil
+}
@@ -0,0 +1,107 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/zucced/goquery/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// GetChartDataHandler handles aggregating a query's stored results into a chart series
+func GetChartDataHandler(store models.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from context
+		userID := c.Locals("user_id").(primitive.ObjectID)
+
+		// Get query ID from params
+		queryID, err := primitive.ObjectIDFromHex(c.Params("id"))
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid query ID",
+			})
+		}
+
+		// Get chart parameters from query
+		x := c.Query("x")
+		y := c.Query("y")
+		agg := models.ChartAggregation(c.Query("agg", string(models.ChartAggregationSum)))
+		bucket := models.DateBucket(c.Query("bucket"))
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Get query
+		query, err := store.GetQueryByID(ctx, queryID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve query: " + err.Error(),
+			})
+		}
+
+		if query == nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Query not found",
+			})
+		}
+
+		// Check if user can access query
+		allowed, err := store.CanAccessQuery(ctx, query, userID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to check query access: " + err.Error(),
+			})
+		}
+		if !allowed {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "You don't have permission to access this query",
+			})
+		}
+
+		// Mask the results the user may not see
+		if err := maskQuery(c, store, query); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to mask results: " + err.Error(),
+			})
+		}
+
+		// Bucket dates in the requested timezone, falling back to the user's
+		location, err := chartLocation(ctx, store, c.Query("tz"), userID)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+
+		// Aggregate the stored results
+		series, err := models.BuildChartSeries(query.Results, x, y, agg, bucket, location)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+
+		// Return response
+		return c.JSON(series)
+	}
+}
+
+// chartLocation resolves the timezone dates are bucketed in. An explicit
+// timezone wins over the user's preference.
+func chartLocation(ctx context.Context, store models.Store, timezone string, userID primitive.ObjectID) (*time.Location, error) {
+	if timezone != "" {
+		location, err := time.LoadLocation(timezone)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timezone: %s", timezone)
+		}
+		return location, nil
+	}
+
+	preferences, err := store.GetUserPreferences(ctx, userID)
+	if err != nil {
+		return time.UTC, nil
+	}
+	return preferences.Location(), nil
+}
@@ -0,0 +1,115 @@
+package api
+
+import (
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/zucced/goquery/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ChartTransformRequest represents the request body for reshaping a query's
+// results into chart series. Dates are bucketed in the user's timezone
+// unless one is given.
+type ChartTransformRequest struct {
+	X          string `json:"x" validate:"notblank,max=200"`
+	Y          string `json:"y" validate:"required_unless=Agg count,max=200"`
+	Agg        string `json:"agg" validate:"oneof=sum avg count min max"`
+	Bucket     string `json:"bucket" validate:"omitempty,oneof=day week month year"`
+	Series     string `json:"series" validate:"max=200"`
+	TopN       int    `json:"top_n" validate:"min=0,max=50"`
+	Cumulative bool   `json:"cumulative"`
+	Timezone   string `json:"timezone" validate:"omitempty,timezone"`
+}
+
+// transform returns the chart transform the request describes
+func (req *ChartTransformRequest) transform() *models.ChartTransform {
+	return &models.ChartTransform{
+		X:          strings.TrimSpace(req.X),
+		Y:          strings.TrimSpace(req.Y),
+		Agg:        models.ChartAggregation(req.Agg),
+		Bucket:     models.DateBucket(req.Bucket),
+		Series:     strings.TrimSpace(req.Series),
+		TopN:       req.TopN,
+		Cumulative: req.Cumulative,
+	}
+}
+
+// TransformQueryHandler handles reshaping a query's stored results into
+// chart series, without running the query again
+func TransformQueryHandler(store models.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from context
+		userID := c.Locals("user_id").(primitive.ObjectID)
+
+		// Get query ID from params
+		queryID, err := primitive.ObjectIDFromHex(c.Params("id"))
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid query ID",
+			})
+		}
+
+		// Parse and validate request body
+		var req ChartTransformRequest
+		if invalid := parseRequest(c, &req); invalid != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(invalid)
+		}
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Get query
+		query, err := store.GetQueryByID(ctx, queryID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve query: " + err.Error(),
+			})
+		}
+
+		if query == nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Query not found",
+			})
+		}
+
+		// Check if user can access query
+		allowed, err := store.CanAccessQuery(ctx, query, userID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to check query access: " + err.Error(),
+			})
+		}
+		if !allowed {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "You don't have permission to access this query",
+			})
+		}
+
+		// Mask the results the user may not see
+		if err := maskQuery(c, store, query); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to mask results: " + err.Error(),
+			})
+		}
+
+		// Bucket dates in the requested timezone, falling back to the user's
+		location, err := chartLocation(ctx, store, req.Timezone, userID)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+
+		// Reshape the stored results
+		chart, err := req.transform().Apply(query.Results, location)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+
+		// Return response
+		return c.JSON(chart)
+	}
+}
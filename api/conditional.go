@@ -0,0 +1,56 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// sendConditionalJSON responds with body as JSON tagged with an ETag of its
+// contents, so clients polling a large document can revalidate it with
+// If-None-Match and get 304 Not Modified while it is unchanged. The tag is
+// computed from the response rather than modified alone because stars, roles
+// and schema refresh statuses change the response without touching it.
+func sendConditionalJSON(c *fiber.Ctx, body interface{}, modified time.Time) error {
+	data, err := c.App().Config().JSONEncoder(body)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to encode response: " + err.Error(),
+		})
+	}
+
+	// The tag is weak because compressed and uncompressed responses share it
+	sum := sha256.Sum256(data)
+	etag := `W/"` + hex.EncodeToString(sum[:16]) + `"`
+
+	// Responses depend on the requesting user, so they may only be cached
+	// by the client and must be revalidated before each use
+	c.Set(fiber.HeaderETag, etag)
+	c.Set(fiber.HeaderCacheControl, "private, no-cache")
+	if !modified.IsZero() {
+		c.Set(fiber.HeaderLastModified, modified.UTC().Format(http.TimeFormat))
+	}
+
+	if etagMatches(c.Get(fiber.HeaderIfNoneMatch), etag) {
+		return c.SendStatus(fiber.StatusNotModified)
+	}
+
+	c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	return c.Send(data)
+}
+
+// etagMatches reports whether an If-None-Match header lists the ETag, using
+// the weak comparison RFC 9110 requires for If-None-Match
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
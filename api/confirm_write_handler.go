@@ -0,0 +1,179 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/zucced/goquery/alerts"
+	"github.com/zucced/goquery/features"
+	"github.com/zucced/goquery/limiter"
+	"github.com/zucced/goquery/models"
+	"github.com/zucced/goquery/realtime"
+	"github.com/zucced/goquery/webhooks"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ConfirmWriteHandler handles executing a query the user has reviewed, either
+// a write or a read against a connection that requires confirmation
+func ConfirmWriteHandler(store models.Store, execLimiter *limiter.ExecutionLimiter, gateway *realtime.Gateway, hooks *webhooks.Dispatcher, flags *features.Service) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from context
+		userID := c.Locals("user_id").(primitive.ObjectID)
+
+		// Get query ID from params
+		queryID, err := primitive.ObjectIDFromHex(c.Params("id"))
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid query ID",
+			})
+		}
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Get the pending query
+		query, err := store.GetQueryByID(ctx, queryID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve query: " + err.Error(),
+			})
+		}
+
+		if query == nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Query not found",
+			})
+		}
+
+		// Check if query belongs to user
+		if query.UserID != userID {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "You don't have permission to access this query",
+			})
+		}
+
+		// Only queries waiting for confirmation can be confirmed
+		if query.Status != models.QueryStatusAwaitingConfirmation {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error": "Query is not awaiting confirmation",
+			})
+		}
+
+		// Get the database
+		db, err := store.GetDatabaseByID(ctx, query.DatabaseID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve database: " + err.Error(),
+			})
+		}
+
+		if db == nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Database not found",
+			})
+		}
+
+		// Check the user can still access the database, sharing may have
+		// been revoked since the query was saved
+		allowed, err := store.CanAccessDatabase(ctx, db, userID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to check database access: " + err.Error(),
+			})
+		}
+		if !allowed {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "You do not have permission to access this database",
+			})
+		}
+
+		// Connections may have started requiring approval since the query
+		// was held
+		if db.RequiresApproval() {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error": "Queries against this database must be approved, rerun the query to request approval",
+			})
+		}
+
+		// Writes may have been disabled, or the owner lost the connection, since
+		// the query was generated
+		if query.IsWrite {
+			blocked, err := writeQueriesBlocked(ctx, store, flags, db, userID)
+			if err != nil {
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+					"error": "Failed to check database access: " + err.Error(),
+				})
+			}
+			if blocked != "" {
+				return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+					"error": blocked,
+				})
+			}
+		}
+
+		// Wait for a free execution slot for this user and database
+		release, err := execLimiter.Acquire(ctx, userID.Hex(), db.ID.Hex())
+		if err != nil {
+			return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		defer release()
+
+		// Execute the confirmed query
+		return runHeldQuery(c, ctx, store, gateway, hooks, db, query)
+	}
+}
+
+// runHeldQuery executes a query held for confirmation or approval and
+// responds with it. The caller holds an execution slot for the query's owner.
+func runHeldQuery(c *fiber.Ctx, ctx context.Context, store models.Store, gateway *realtime.Gateway, hooks *webhooks.Dispatcher, db *models.Database, query *models.Query) error {
+	// Update query status
+	query.Status = models.QueryStatusRunning
+	if err := saveQueryStatus(ctx, store, gateway, hooks, query); err != nil {
+		fmt.Printf("Failed to update query status to running: %v\n", err)
+	}
+	defer models.TrackRunningQuery(query.ID)()
+
+	// Execute the held query
+	fmt.Printf("[%s] Executing held query %s\n", time.Now().Format(time.RFC3339), query.ID.Hex())
+	execute := models.ExecuteQuery
+	if query.IsWrite {
+		execute = models.ExecuteWriteQuery
+	}
+	executionStartTime := time.Now()
+	results, columns, executionTime, err := execute(ctx, db, query.GeneratedSQL)
+	recordExecution(ctx, store, db, query.UserID, executionStartTime, err)
+	alerts.Evaluate(ctx, store, alerts.SourceManual, query.ID, results, err)
+	if err != nil {
+		// Update query with error
+		query.Status = models.QueryStatusFailed
+		query.Error = "Failed to execute query: " + err.Error()
+		saveQueryStatus(ctx, store, gateway, hooks, query)
+
+		maskQuery(c, store, query)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": query.Error,
+			"query": query,
+		})
+	}
+
+	// Update query with results
+	query.Status = models.QueryStatusCompleted
+	query.Results = limitResults(ctx, store, query.UserID, results)
+	query.Columns = columns
+	query.ExecutionTime = executionTime
+	query.Error = ""
+
+	// Save updated query
+	if err := saveQueryStatus(ctx, store, gateway, hooks, query); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to update query: " + err.Error(),
+		})
+	}
+
+	// Return response
+	return sendQuery(c, store, query)
+}
@@ -0,0 +1,534 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/zucced/goquery/config"
+	"github.com/zucced/goquery/mailer"
+	"github.com/zucced/goquery/models"
+	"github.com/zucced/goquery/realtime"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// DashboardCommentRequest represents the request body for commenting on a
+// dashboard. A comment starts a thread, optionally about one of the
+// dashboard's cards, or replies to the thread of its parent.
+type DashboardCommentRequest struct {
+	Body     string `json:"body" validate:"notblank,max=5000"`
+	CardID   string `json:"card_id" validate:"omitempty,objectid"`
+	ParentID string `json:"parent_id" validate:"omitempty,objectid"`
+}
+
+// DashboardCommentUpdateRequest represents the request body for editing a
+// dashboard comment
+type DashboardCommentUpdateRequest struct {
+	Body string `json:"body" validate:"notblank,max=5000"`
+}
+
+// GetDashboardCommentsHandler handles listing the comment threads on a
+// dashboard with their replies, newest first, with pagination
+func GetDashboardCommentsHandler(store models.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get pagination parameters from query
+		page, err := strconv.ParseInt(c.Query("page", "1"), 10, 64)
+		if err != nil || page < 1 {
+			page = 1
+		}
+
+		limit, err := strconv.ParseInt(c.Query("limit", "20"), 10, 64)
+		if err != nil || limit < 1 || limit > 100 {
+			limit = 20
+		}
+
+		// Only list the threads about a card, or in a state, when asked
+		var filter models.DashboardCommentFilter
+		if raw := c.Query("card_id"); raw != "" {
+			filter.CardID, err = primitive.ObjectIDFromHex(raw)
+			if err != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": "Invalid card ID",
+				})
+			}
+		}
+		if raw := c.Query("resolved"); raw != "" {
+			resolved, err := strconv.ParseBool(raw)
+			if err != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": "resolved must be true or false",
+				})
+			}
+			filter.Resolved = &resolved
+		}
+
+		dashboard, err := loadCommentedDashboard(c, store)
+		if dashboard == nil {
+			return err
+		}
+
+		// Get threads
+		threads, totalCount, err := store.GetDashboardCommentThreads(c.UserContext(), dashboard.ID, filter, page, limit)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve comments: " + err.Error(),
+			})
+		}
+
+		// Calculate pagination metadata
+		totalPages := (totalCount + limit - 1) / limit
+
+		// Return response with pagination metadata
+		return c.JSON(fiber.Map{
+			"comments": threads,
+			"pagination": fiber.Map{
+				"total": totalCount,
+				"page":  page,
+				"limit": limit,
+				"pages": totalPages,
+			},
+		})
+	}
+}
+
+// CreateDashboardCommentHandler handles commenting on a dashboard, which
+// everyone who can view it can do. Mentioned users who can view it are
+// notified.
+func CreateDashboardCommentHandler(store models.Store, cfg *config.Config, hub *realtime.Hub, gateway *realtime.Gateway, mailQueue mailer.Mailer) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from context
+		userID := c.Locals("user_id").(primitive.ObjectID)
+
+		// Parse and validate request body
+		var req DashboardCommentRequest
+		if invalid := parseRequest(c, &req); invalid != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(invalid)
+		}
+
+		dashboard, err := loadCommentedDashboard(c, store)
+		if dashboard == nil {
+			return err
+		}
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		comment := &models.DashboardComment{
+			DashboardID: dashboard.ID,
+			UserID:      userID,
+			Body:        strings.TrimSpace(req.Body),
+		}
+
+		// Replies join the thread of their parent and are about its card
+		if req.ParentID != "" {
+			if req.CardID != "" {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": "A card can only be given when starting a thread",
+				})
+			}
+
+			parentID, _ := primitive.ObjectIDFromHex(req.ParentID)
+			parent, err := store.GetDashboardComment(ctx, dashboard.ID, parentID)
+			if err != nil {
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+					"error": "Failed to retrieve comment: " + err.Error(),
+				})
+			}
+			if parent == nil {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": "The parent comment doesn't belong to this dashboard",
+				})
+			}
+			comment.ParentID = parent.ID
+			if !parent.IsThread() {
+				comment.ParentID = parent.ParentID
+			}
+			comment.CardID = parent.CardID
+		}
+
+		// The card must be one of this dashboard's
+		if req.CardID != "" {
+			cardID, _ := primitive.ObjectIDFromHex(req.CardID)
+			if dashboardCard(dashboard, cardID) == nil {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": "The card doesn't belong to this dashboard",
+				})
+			}
+			comment.CardID = cardID
+		}
+
+		// Get the author
+		user, err := store.GetUserByID(ctx, userID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve user: " + err.Error(),
+			})
+		}
+		if user != nil {
+			comment.AuthorName = user.Name
+			comment.AuthorEmail = user.Email
+		}
+
+		// Find who the comment mentions
+		mentioned, err := resolveMentions(ctx, store, dashboard, comment)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve mentioned users: " + err.Error(),
+			})
+		}
+		if mentioned == nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": fmt.Sprintf("A comment can mention at most %d people", models.MaxCommentMentions),
+			})
+		}
+		for _, mention := range mentioned {
+			comment.Mentions = append(comment.Mentions, mention.ID)
+		}
+
+		// Save comment
+		if err := store.CreateDashboardComment(ctx, comment); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to create comment: " + err.Error(),
+			})
+		}
+
+		// Tell the mentioned users and the dashboard's other viewers
+		notifyMentions(ctx, store, cfg, gateway, mailQueue, dashboard, comment, mentioned)
+		publishDashboardEvent(hub, c, realtime.EventCommentAdded, dashboard.ID, comment)
+
+		// Return response
+		return c.Status(fiber.StatusCreated).JSON(comment)
+	}
+}
+
+// UpdateDashboardCommentHandler handles editing a dashboard comment, which
+// only its author can do. Users it newly mentions are notified.
+func UpdateDashboardCommentHandler(store models.Store, cfg *config.Config, hub *realtime.Hub, gateway *realtime.Gateway, mailQueue mailer.Mailer) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from context
+		userID := c.Locals("user_id").(primitive.ObjectID)
+
+		// Parse and validate request body
+		var req DashboardCommentUpdateRequest
+		if invalid := parseRequest(c, &req); invalid != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(invalid)
+		}
+
+		dashboard, comment, err := loadDashboardComment(c, store)
+		if comment == nil {
+			return err
+		}
+
+		if comment.UserID != userID {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "Only the author can edit a comment",
+			})
+		}
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Find who the edited comment mentions, and who wasn't before
+		previous := make(map[primitive.ObjectID]bool, len(comment.Mentions))
+		for _, id := range comment.Mentions {
+			previous[id] = true
+		}
+
+		edited := *comment
+		edited.Body = strings.TrimSpace(req.Body)
+		mentioned, err := resolveMentions(ctx, store, dashboard, &edited)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve mentioned users: " + err.Error(),
+			})
+		}
+		if mentioned == nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": fmt.Sprintf("A comment can mention at most %d people", models.MaxCommentMentions),
+			})
+		}
+
+		mentions := []primitive.ObjectID{}
+		newlyMentioned := []*models.User{}
+		for _, mention := range mentioned {
+			mentions = append(mentions, mention.ID)
+			if !previous[mention.ID] {
+				newlyMentioned = append(newlyMentioned, mention)
+			}
+		}
+
+		// Update comment
+		if err := store.UpdateDashboardCommentBody(ctx, comment, edited.Body, mentions); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to update comment: " + err.Error(),
+			})
+		}
+
+		// Tell the newly mentioned users and the dashboard's other viewers
+		notifyMentions(ctx, store, cfg, gateway, mailQueue, dashboard, comment, newlyMentioned)
+		publishDashboardEvent(hub, c, realtime.EventCommentUpdated, dashboard.ID, comment)
+
+		// Return response
+		return c.JSON(comment)
+	}
+}
+
+// ResolveDashboardCommentHandler handles marking a comment thread resolved
+func ResolveDashboardCommentHandler(store models.Store, hub *realtime.Hub) fiber.Handler {
+	return setDashboardCommentResolvedHandler(store, hub, true)
+}
+
+// ReopenDashboardCommentHandler handles reopening a resolved comment thread
+func ReopenDashboardCommentHandler(store models.Store, hub *realtime.Hub) fiber.Handler {
+	return setDashboardCommentResolvedHandler(store, hub, false)
+}
+
+// setDashboardCommentResolvedHandler resolves or reopens a thread, which the
+// thread's author and everyone who can edit the dashboard can do
+func setDashboardCommentResolvedHandler(store models.Store, hub *realtime.Hub, resolved bool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from context
+		userID := c.Locals("user_id").(primitive.ObjectID)
+
+		dashboard, comment, err := loadDashboardComment(c, store)
+		if comment == nil {
+			return err
+		}
+
+		if !comment.IsThread() {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Only threads can be resolved, not their replies",
+			})
+		}
+
+		if comment.UserID != userID && !dashboard.CanEdit(userID) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "Only the thread's author or the dashboard's editors can resolve a thread",
+			})
+		}
+
+		// Update thread
+		if err := store.SetDashboardCommentResolved(c.UserContext(), comment, userID, resolved); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to update comment: " + err.Error(),
+			})
+		}
+
+		// Tell the dashboard's other viewers
+		publishDashboardEvent(hub, c, realtime.EventCommentUpdated, dashboard.ID, comment)
+
+		// Return response
+		return c.JSON(comment)
+	}
+}
+
+// DeleteDashboardCommentHandler handles deleting a dashboard comment, with
+// its replies when it starts a thread. Its author and everyone who can edit
+// the dashboard can do it.
+func DeleteDashboardCommentHandler(store models.Store, hub *realtime.Hub) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from context
+		userID := c.Locals("user_id").(primitive.ObjectID)
+
+		dashboard, comment, err := loadDashboardComment(c, store)
+		if comment == nil {
+			return err
+		}
+
+		if comment.UserID != userID && !dashboard.CanEdit(userID) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "Only the author or the dashboard's editors can delete a comment",
+			})
+		}
+
+		// Delete comment
+		if err := store.DeleteDashboardComment(c.UserContext(), comment); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to delete comment: " + err.Error(),
+			})
+		}
+
+		// Tell the dashboard's other viewers
+		publishDashboardEvent(hub, c, realtime.EventCommentDeleted, dashboard.ID, fiber.Map{
+			"id":        comment.ID,
+			"parent_id": comment.ParentID,
+		})
+
+		// Return response
+		return c.JSON(fiber.Map{
+			"message": "Comment deleted successfully",
+		})
+	}
+}
+
+// resolveMentions looks up the users a comment mentions who can view the
+// dashboard, leaving out its author and addresses without an account. It
+// returns nil when the comment mentions too many people.
+func resolveMentions(ctx context.Context, store models.Store, dashboard *models.Dashboard, comment *models.DashboardComment) ([]*models.User, error) {
+	emails := models.ParseMentions(comment.Body)
+	if len(emails) > models.MaxCommentMentions {
+		return nil, nil
+	}
+
+	mentioned := []*models.User{}
+	seen := map[primitive.ObjectID]bool{}
+	for _, email := range emails {
+		user, err := store.GetUserByEmail(ctx, email)
+		if err != nil {
+			return nil, err
+		}
+		if user == nil || user.ID == comment.UserID || seen[user.ID] || !dashboard.CanView(user.ID) {
+			continue
+		}
+		seen[user.ID] = true
+		mentioned = append(mentioned, user)
+	}
+	return mentioned, nil
+}
+
+// notifyMentions tells users they were mentioned in a comment, in their
+// in-app inbox, through the gateway and by email
+func notifyMentions(ctx context.Context, store models.Store, cfg *config.Config, gateway *realtime.Gateway, mailQueue mailer.Mailer, dashboard *models.Dashboard, comment *models.DashboardComment, users []*models.User) {
+	if len(users) == 0 {
+		return
+	}
+
+	author := comment.AuthorName
+	if author == "" {
+		author = comment.AuthorEmail
+	}
+	cardTitle := ""
+	if card := dashboardCard(dashboard, comment.CardID); card != nil {
+		cardTitle = card.Title
+	}
+	title := fmt.Sprintf("%s mentioned you on %s", author, dashboard.Name)
+	link := cfg.FrontendURL + "/dashboards/" + dashboard.ID.Hex()
+
+	for _, user := range users {
+		if err := store.CreateNotification(ctx, &models.Notification{
+			UserID: user.ID,
+			Source: models.NotificationSourceMention,
+			Title:  title,
+			Body:   comment.Body,
+			Link:   link,
+		}); err != nil {
+			log.Printf("Failed to add mention notification for %s: %v", user.ID.Hex(), err)
+		}
+
+		if gateway != nil {
+			gateway.Notify(user.ID, "comment_mention", fiber.Map{
+				"dashboard_id": dashboard.ID,
+				"name":         dashboard.Name,
+				"comment_id":   comment.ID,
+				"parent_id":    comment.ParentID,
+				"card_id":      comment.CardID,
+				"author_id":    comment.UserID,
+			})
+		}
+
+		msg, err := mailer.Render(mailer.TemplateCommentMention, []string{user.Email}, fiber.Map{
+			"Author":    author,
+			"Dashboard": dashboard.Name,
+			"Card":      cardTitle,
+			"Body":      comment.Body,
+			"Link":      link,
+		})
+		if err == nil {
+			err = mailQueue.Send(ctx, msg)
+		}
+		if err != nil && !errors.Is(err, mailer.ErrNotConfigured) {
+			log.Printf("Failed to send mention email to %s: %v", user.Email, err)
+		}
+	}
+}
+
+// dashboardCard returns the card of a dashboard with the given ID, or nil
+func dashboardCard(dashboard *models.Dashboard, cardID primitive.ObjectID) *models.DashboardCard {
+	if cardID.IsZero() {
+		return nil
+	}
+	for i := range dashboard.Cards {
+		if dashboard.Cards[i].ID == cardID {
+			return &dashboard.Cards[i]
+		}
+	}
+	return nil
+}
+
+// loadCommentedDashboard resolves the dashboard in the request path and
+// checks the user can view it, and so comment on it. When the dashboard is
+// nil the returned error is the response already written.
+func loadCommentedDashboard(c *fiber.Ctx, store models.Store) (*models.Dashboard, error) {
+	// Get user ID from context
+	userID := c.Locals("user_id").(primitive.ObjectID)
+
+	// Get dashboard ID from params
+	dashboardID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return nil, c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid dashboard ID",
+		})
+	}
+
+	// Get dashboard
+	dashboard, err := store.GetDashboardByID(c.UserContext(), dashboardID)
+	if err != nil {
+		return nil, c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to retrieve dashboard: " + err.Error(),
+		})
+	}
+
+	// Check if dashboard exists
+	if dashboard == nil {
+		return nil, c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Dashboard not found",
+		})
+	}
+
+	// Check if user can view dashboard
+	if !dashboard.CanView(userID) {
+		return nil, c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "You don't have permission to access this dashboard",
+		})
+	}
+
+	return dashboard, nil
+}
+
+// loadDashboardComment resolves the dashboard and comment in the request
+// path. When the comment is nil the returned error is the response already
+// written.
+func loadDashboardComment(c *fiber.Ctx, store models.Store) (*models.Dashboard, *models.DashboardComment, error) {
+	// Get comment ID from params
+	commentID, err := primitive.ObjectIDFromHex(c.Params("commentId"))
+	if err != nil {
+		return nil, nil, c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid comment ID",
+		})
+	}
+
+	dashboard, err := loadCommentedDashboard(c, store)
+	if dashboard == nil {
+		return nil, nil, err
+	}
+
+	// Get comment
+	comment, err := store.GetDashboardComment(c.UserContext(), dashboard.ID, commentID)
+	if err != nil {
+		return nil, nil, c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to retrieve comment: " + err.Error(),
+		})
+	}
+
+	if comment == nil {
+		return nil, nil, c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Comment not found",
+		})
+	}
+
+	return dashboard, comment, nil
+}
@@ -0,0 +1,309 @@
+package api
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/zucced/goquery/alerts"
+	"github.com/zucced/goquery/cache"
+	"github.com/zucced/goquery/limiter"
+	"github.com/zucced/goquery/models"
+	"github.com/zucced/goquery/realtime"
+	"github.com/zucced/goquery/webhooks"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// dashboardDataWorkers caps how many cards of one dashboard load are resolved at once
+const dashboardDataWorkers = 4
+
+// CardDataResult is the outcome of loading a single card in a batch request
+type CardDataResult struct {
+	CardID primitive.ObjectID `json:"card_id"`
+	Data   *CardDataResponse  `json:"data,omitempty"`
+	Error  string             `json:"error,omitempty"`
+}
+
+// GetDashboardDataHandler handles loading the data of every card on a dashboard
+// in one request. Fresh cached data is returned as is, stale cards are refreshed
+// concurrently, and var.<name> query parameters run variable cards live.
+func GetDashboardDataHandler(store models.Store, execLimiter *limiter.ExecutionLimiter, resultCache cache.Cache) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from context
+		userID := c.Locals("user_id").(primitive.ObjectID)
+
+		// Get dashboard ID from params
+		dashboardID, err := primitive.ObjectIDFromHex(c.Params("id"))
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid dashboard ID",
+			})
+		}
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Get dashboard
+		dashboard, err := store.GetDashboardByID(ctx, dashboardID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve dashboard: " + err.Error(),
+			})
+		}
+
+		// Check if dashboard exists
+		if dashboard == nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Dashboard not found",
+			})
+		}
+
+		// Check if user can view dashboard
+		if !dashboard.CanView(userID) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "You don't have permission to access this dashboard",
+			})
+		}
+
+		// Resolve variable values when any are supplied
+		var values map[string]interface{}
+		if supplied := suppliedVariables(c); len(supplied) > 0 {
+			values, err = models.ResolveVariableValues(dashboard.Variables, supplied)
+			if err != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": err.Error(),
+				})
+			}
+		}
+
+		// Bucket chart dates in the requested timezone, falling back to the user's
+		location, err := chartLocation(ctx, store, c.Query("tz"), userID)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+
+		// Load cards with a bounded number of workers
+		masker := store.NewResultMasker(userID)
+		results := mapQueryCards(dashboard, func(card *models.DashboardCard) CardDataResult {
+			return loadBatchCardData(ctx, store, masker, execLimiter, resultCache, dashboard, card, values, location)
+		})
+
+		// Return response
+		return c.JSON(fiber.Map{
+			"dashboard_id": dashboard.ID,
+			"cards":        results,
+		})
+	}
+}
+
+// RefreshDashboardHandler handles rerunning the query behind every card of a
+// dashboard and updating the cached card data
+func RefreshDashboardHandler(store models.Store, execLimiter *limiter.ExecutionLimiter, hub *realtime.Hub, hooks *webhooks.Dispatcher) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from context
+		userID := c.Locals("user_id").(primitive.ObjectID)
+
+		// Get dashboard ID from params
+		dashboardID, err := primitive.ObjectIDFromHex(c.Params("id"))
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid dashboard ID",
+			})
+		}
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Get dashboard
+		dashboard, err := store.GetDashboardByID(ctx, dashboardID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve dashboard: " + err.Error(),
+			})
+		}
+
+		// Check if dashboard exists
+		if dashboard == nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Dashboard not found",
+			})
+		}
+
+		// Check if user can view dashboard
+		if !dashboard.CanView(userID) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "You don't have permission to access this dashboard",
+			})
+		}
+
+		// Bucket chart dates in the requested timezone, falling back to the user's
+		location, err := chartLocation(ctx, store, c.Query("tz"), userID)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+
+		// Refresh cards with a bounded number of workers
+		masker := store.NewResultMasker(userID)
+		results := mapQueryCards(dashboard, func(card *models.DashboardCard) CardDataResult {
+			result := CardDataResult{CardID: card.ID}
+
+			data, err := store.RefreshCardData(ctx, execLimiter, dashboard, card)
+			alerts.EvaluateCard(ctx, store, alerts.SourceManual, card, data, err)
+			if err != nil {
+				result.Error = err.Error()
+			} else {
+				hooks.AlertFired(dashboard, card, data)
+			}
+			if data != nil {
+				if err := masker.MaskCardData(ctx, data); err != nil {
+					result.Error = "Failed to mask results: " + err.Error()
+					return result
+				}
+				result.Data = &CardDataResponse{
+					CardData:        data,
+					Source:          "live",
+					RefreshInterval: card.RefreshInterval,
+					Metric:          metricSummary(card, data),
+					Chart:           cardChart(card, data, location),
+				}
+			}
+			return result
+		})
+
+		refreshed := []primitive.ObjectID{}
+		failed := 0
+		for _, result := range results {
+			if result.Error != "" {
+				failed++
+			} else {
+				refreshed = append(refreshed, result.CardID)
+			}
+		}
+
+		// Let other viewers know fresh data is available
+		if len(refreshed) > 0 {
+			publishDashboardEvent(hub, c, realtime.EventCardsRefreshed, dashboardID, fiber.Map{
+				"card_ids": refreshed,
+			})
+		}
+
+		// Return response
+		return c.JSON(fiber.Map{
+			"dashboard_id": dashboard.ID,
+			"refreshed":    len(refreshed),
+			"failed":       failed,
+			"cards":        results,
+		})
+	}
+}
+
+// mapQueryCards calls fn for every card of a dashboard that has a query, running
+// at most dashboardDataWorkers calls at once, and returns the results in card order
+func mapQueryCards(dashboard *models.Dashboard, fn func(card *models.DashboardCard) CardDataResult) []CardDataResult {
+	var cards []*models.DashboardCard
+	for i := range dashboard.Cards {
+		if !dashboard.Cards[i].QueryID.IsZero() {
+			cards = append(cards, &dashboard.Cards[i])
+		}
+	}
+
+	results := make([]CardDataResult, len(cards))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < dashboardDataWorkers && w < len(cards); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = fn(cards[i])
+			}
+		}()
+	}
+	for i := range cards {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	return results
+}
+
+// loadBatchCardData resolves a single card for a batch load. Stale cached data
+// is refreshed, and kept with an error when the refresh fails. The results are
+// masked for the viewer of the masker.
+func loadBatchCardData(ctx context.Context, store models.Store, masker *models.ResultMasker, execLimiter *limiter.ExecutionLimiter, resultCache cache.Cache, dashboard *models.Dashboard, card *models.DashboardCard, values map[string]interface{}, location *time.Location) CardDataResult {
+	result := CardDataResult{CardID: card.ID}
+
+	// Cards whose query uses variables run live when values are supplied
+	if values != nil {
+		query, err := store.GetQueryByID(ctx, card.QueryID)
+		if err != nil {
+			result.Error = "Failed to retrieve query: " + err.Error()
+			return result
+		}
+
+		if query != nil && models.HasQueryVariables(query.GeneratedSQL) {
+			data, err := runCardQueryCached(ctx, store, execLimiter, resultCache, dashboard, card, query, values)
+			if data == nil {
+				result.Error = "Failed to run card query: " + err.Error()
+				return result
+			}
+			if err := masker.MaskCardData(ctx, data); err != nil {
+				result.Error = "Failed to mask results: " + err.Error()
+				return result
+			}
+
+			result.Data = &CardDataResponse{
+				CardData:        data,
+				Source:          "live",
+				RefreshInterval: card.RefreshInterval,
+				Metric:          metricSummary(card, data),
+				Chart:           cardChart(card, data, location),
+			}
+			return result
+		}
+	}
+
+	// Resolve the card data from the cache or the query's last results
+	response, err := resolveCardData(ctx, store, masker, execLimiter, dashboard, card, location)
+	if err != nil {
+		result.Error = "Failed to retrieve card data: " + err.Error()
+		return result
+	}
+
+	if response == nil {
+		result.Error = "No data available for this card"
+		return result
+	}
+
+	result.Data = response
+	if !response.Stale {
+		return result
+	}
+
+	// Refresh stale cards
+	data, err := store.RefreshCardData(ctx, execLimiter, dashboard, card)
+	alerts.EvaluateCard(ctx, store, alerts.SourceManual, card, data, err)
+	if err != nil {
+		result.Error = "Failed to refresh card: " + err.Error()
+		return result
+	}
+	if err := masker.MaskCardData(ctx, data); err != nil {
+		result.Error = "Failed to mask results: " + err.Error()
+		return result
+	}
+
+	result.Data = &CardDataResponse{
+		CardData:        data,
+		Source:          "live",
+		RefreshInterval: card.RefreshInterval,
+		Metric:          metricSummary(card, data),
+		Chart:           cardChart(card, data, location),
+	}
+	return result
+}
@@ -1,60 +1,150 @@
 package api
 
 import (
-	"context"
-	"time"
+	"fmt"
+	"log"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/zucced/goquery/models"
+	"github.com/zucced/goquery/realtime"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 // DashboardRequest represents the request body for dashboard operations
 type DashboardRequest struct {
-	Name        string `json:"name"`
-	Description string `json:"description"`
-	IsDefault   bool   `json:"is_default"`
+	Name        string                     `json:"name" validate:"notblank,max=100"`
+	Description string                     `json:"description" validate:"max=1000"`
+	IsDefault   bool                       `json:"is_default"`
+	Variables   []models.DashboardVariable `json:"variables"`
 }
 
 // DashboardCardRequest represents the request body for dashboard card operations
 type DashboardCardRequest struct {
-	Title     string             `json:"title"`
-	Type      models.CardType    `json:"type"`
-	QueryID   string             `json:"query_id,omitempty"`
-	ChartType models.ChartType   `json:"chart_type,omitempty"`
-	Position  models.CardPosition `json:"position"`
+	Title           string                 `json:"title" validate:"notblank,max=200"`
+	Type            models.CardType        `json:"type" validate:"omitempty,oneof=query chart text embed metric"`
+	QueryID         string                 `json:"query_id,omitempty" validate:"omitempty,objectid"`
+	ChartType       models.ChartType       `json:"chart_type,omitempty" validate:"omitempty,oneof=table bar line pie area"`
+	Position        models.CardPosition    `json:"position"`
+	RefreshInterval int                    `json:"refresh_interval,omitempty"`
+	Content         string                 `json:"content,omitempty"`
+	URL             string                 `json:"url,omitempty"`
+	EmbedMode       models.EmbedMode       `json:"embed_mode,omitempty" validate:"omitempty,oneof=iframe image"`
+	Metric          *models.MetricConfig   `json:"metric,omitempty"`
+	Options         *models.CardOptions    `json:"options,omitempty"`
+	Transform       *models.ChartTransform `json:"transform,omitempty"`
 }
 
 // CardPositionRequest represents the request body for updating card positions
 type CardPositionRequest struct {
-	CardID   string             `json:"id"`
+	CardID   string              `json:"id" validate:"required,objectid"`
 	Position models.CardPosition `json:"position"`
 }
 
+// validate checks a card request and sanitizes its content, returning an error message if invalid
+func (req *DashboardCardRequest) validate() string {
+	if req.RefreshInterval != 0 && req.RefreshInterval < models.MinCardRefreshInterval {
+		return fmt.Sprintf("Refresh interval must be 0 or at least %d seconds", models.MinCardRefreshInterval)
+	}
+
+	switch req.Type {
+	case models.CardTypeText:
+		if len(req.Content) > models.MaxMarkdownLength {
+			return fmt.Sprintf("Content must be at most %d characters", models.MaxMarkdownLength)
+		}
+
+		// Text cards have no query to run
+		req.Content = models.SanitizeMarkdown(req.Content)
+		req.QueryID = ""
+		req.RefreshInterval = 0
+		req.URL = ""
+		req.EmbedMode = ""
+		req.Metric = nil
+		req.Options = nil
+		req.Transform = nil
+	case models.CardTypeEmbed:
+		url, err := models.ValidateEmbedURL(req.URL)
+		if err != nil {
+			return err.Error()
+		}
+
+		if req.EmbedMode == "" {
+			req.EmbedMode = models.EmbedModeIframe
+		}
+
+		// Embed cards have no query to run
+		req.URL = url
+		req.QueryID = ""
+		req.RefreshInterval = 0
+		req.Content = ""
+		req.Metric = nil
+		req.Options = nil
+		req.Transform = nil
+	case models.CardTypeMetric:
+		if req.Metric == nil {
+			req.Metric = &models.MetricConfig{}
+		}
+		if err := req.Metric.Validate(); err != nil {
+			return err.Error()
+		}
+
+		req.Content = ""
+		req.URL = ""
+		req.EmbedMode = ""
+		req.Transform = nil
+	default:
+		req.Content = ""
+		req.URL = ""
+		req.EmbedMode = ""
+		req.Metric = nil
+		if req.Type != models.CardTypeChart {
+			req.Transform = nil
+		}
+	}
+
+	if req.Transform != nil {
+		if err := req.Transform.Validate(); err != nil {
+			return "Invalid transform: " + err.Error()
+		}
+	}
+
+	if req.Options != nil {
+		if err := req.Options.Validate(req.Type, req.ChartType); err != nil {
+			return err.Error()
+		}
+	}
+
+	return ""
+}
+
+// cardCanUseQuery reports whether a query can back a card added by userID
+func cardCanUseQuery(dashboard *models.Dashboard, query *models.Query, userID primitive.ObjectID) bool {
+	if query.UserID == userID || query.UserID == dashboard.UserID {
+		return true
+	}
+	return !dashboard.OrgID.IsZero() && query.OrgID == dashboard.OrgID
+}
+
 // CreateDashboardHandler handles creating a new dashboard
-func CreateDashboardHandler() fiber.Handler {
+func CreateDashboardHandler(store models.Store) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		// Get user ID from context
 		userID := c.Locals("user_id").(primitive.ObjectID)
 
-		// Parse request body
+		// Parse and validate request body
 		var req DashboardRequest
-		if err := c.BodyParser(&req); err != nil {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": "Invalid request body",
-			})
+		if invalid := parseRequest(c, &req); invalid != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(invalid)
 		}
 
-		// Validate required fields
-		if req.Name == "" {
+		// Validate variables
+		if err := models.ValidateDashboardVariables(req.Variables); err != nil {
 			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": "Name is required",
+				"error": err.Error(),
 			})
 		}
 
-		// Create context with timeout
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
+		// Get the request context
+		ctx := c.UserContext()
 
 		// Create dashboard
 		dashboard := &models.Dashboard{
@@ -63,39 +153,61 @@ func CreateDashboardHandler() fiber.Handler {
 			Description: req.Description,
 			IsDefault:   req.IsDefault,
 			Cards:       []models.DashboardCard{},
+			Variables:   req.Variables,
 		}
+		dashboard.OrgID, dashboard.WorkspaceID = workspaceScope(c)
 
 		// Save dashboard
-		dashboard, err := models.CreateDashboard(ctx, dashboard)
+		dashboard, err := store.CreateDashboard(ctx, dashboard)
 		if err != nil {
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 				"error": "Failed to create dashboard: " + err.Error(),
 			})
 		}
 
+		// Only one dashboard can be the default
+		if dashboard.IsDefault {
+			if err := store.SetDefaultDashboard(ctx, userID, dashboard.ID); err != nil {
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+					"error": "Failed to set default dashboard: " + err.Error(),
+				})
+			}
+		}
+
 		// Return response
 		return c.JSON(dashboard)
 	}
 }
 
 // GetDashboardsHandler handles retrieving all dashboards for a user
-func GetDashboardsHandler() fiber.Handler {
+func GetDashboardsHandler(store models.Store) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		// Get user ID from context
 		userID := c.Locals("user_id").(primitive.ObjectID)
 
-		// Create context with timeout
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Get filter from query
+		filter := models.DashboardFilter{
+			Starred:  c.QueryBool("starred"),
+			Archived: c.QueryBool("archived"),
+		}
+		_, filter.WorkspaceID = workspaceScope(c)
 
 		// Get dashboards
-		dashboards, err := models.GetDashboardsByUserID(ctx, userID)
+		dashboards, err := store.GetDashboardsByUserID(ctx, userID, filter)
 		if err != nil {
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 				"error": "Failed to retrieve dashboards: " + err.Error(),
 			})
 		}
 
+		for _, dashboard := range dashboards {
+			dashboard.Starred = dashboard.IsStarredBy(userID)
+			dashboard.Role = dashboard.RoleFor(userID)
+		}
+
 		// Return response
 		return c.JSON(fiber.Map{
 			"dashboards": dashboards,
@@ -104,7 +216,7 @@ func GetDashboardsHandler() fiber.Handler {
 }
 
 // GetDashboardHandler handles retrieving a single dashboard
-func GetDashboardHandler() fiber.Handler {
+func GetDashboardHandler(store models.Store) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		// Get user ID from context
 		userID := c.Locals("user_id").(primitive.ObjectID)
@@ -117,12 +229,11 @@ func GetDashboardHandler() fiber.Handler {
 			})
 		}
 
-		// Create context with timeout
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
+		// Get the request context
+		ctx := c.UserContext()
 
 		// Get dashboard
-		dashboard, err := models.GetDashboardByID(ctx, dashboardID)
+		dashboard, err := store.GetDashboardByID(ctx, dashboardID)
 		if err != nil {
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 				"error": "Failed to retrieve dashboard: " + err.Error(),
@@ -136,20 +247,22 @@ func GetDashboardHandler() fiber.Handler {
 			})
 		}
 
-		// Check if dashboard belongs to user
-		if dashboard.UserID != userID {
+		// Check if user can view dashboard
+		if !dashboard.CanView(userID) {
 			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
 				"error": "You don't have permission to access this dashboard",
 			})
 		}
 
 		// Return response
-		return c.JSON(dashboard)
+		dashboard.Starred = dashboard.IsStarredBy(userID)
+		dashboard.Role = dashboard.RoleFor(userID)
+		return sendConditionalJSON(c, dashboard, dashboard.UpdatedAt)
 	}
 }
 
 // UpdateDashboardHandler handles updating a dashboard
-func UpdateDashboardHandler() fiber.Handler {
+func UpdateDashboardHandler(store models.Store, hub *realtime.Hub) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		// Get user ID from context
 		userID := c.Locals("user_id").(primitive.ObjectID)
@@ -162,20 +275,24 @@ func UpdateDashboardHandler() fiber.Handler {
 			})
 		}
 
-		// Parse request body
+		// Parse and validate request body
 		var req DashboardRequest
-		if err := c.BodyParser(&req); err != nil {
+		if invalid := parseRequest(c, &req); invalid != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(invalid)
+		}
+
+		// Validate variables
+		if err := models.ValidateDashboardVariables(req.Variables); err != nil {
 			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": "Invalid request body",
+				"error": err.Error(),
 			})
 		}
 
-		// Create context with timeout
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
+		// Get the request context
+		ctx := c.UserContext()
 
 		// Get dashboard
-		dashboard, err := models.GetDashboardByID(ctx, dashboardID)
+		dashboard, err := store.GetDashboardByID(ctx, dashboardID)
 		if err != nil {
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 				"error": "Failed to retrieve dashboard: " + err.Error(),
@@ -189,8 +306,8 @@ func UpdateDashboardHandler() fiber.Handler {
 			})
 		}
 
-		// Check if dashboard belongs to user
-		if dashboard.UserID != userID {
+		// Check if user can edit dashboard
+		if !dashboard.CanEdit(userID) {
 			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
 				"error": "You don't have permission to update this dashboard",
 			})
@@ -199,22 +316,46 @@ func UpdateDashboardHandler() fiber.Handler {
 		// Update dashboard
 		dashboard.Name = req.Name
 		dashboard.Description = req.Description
-		dashboard.IsDefault = req.IsDefault
+		if dashboard.RoleFor(userID) == models.DashboardRoleOwner {
+			dashboard.IsDefault = req.IsDefault
+		}
+		if req.Variables != nil {
+			dashboard.Variables = req.Variables
+		}
 
 		// Save dashboard
-		if err := models.UpdateDashboard(ctx, dashboard); err != nil {
+		if err := store.UpdateDashboard(ctx, dashboard); err != nil {
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 				"error": "Failed to update dashboard: " + err.Error(),
 			})
 		}
 
+		// Only one dashboard can be the default
+		if dashboard.IsDefault && dashboard.UserID == userID {
+			if err := store.SetDefaultDashboard(ctx, userID, dashboard.ID); err != nil {
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+					"error": "Failed to set default dashboard: " + err.Error(),
+				})
+			}
+		}
+
+		// Notify other viewers
+		publishDashboardEvent(hub, c, realtime.EventDashboardUpdated, dashboardID, fiber.Map{
+			"name":        dashboard.Name,
+			"description": dashboard.Description,
+			"variables":   dashboard.Variables,
+			"updated_at":  dashboard.UpdatedAt,
+		})
+
 		// Return response
+		dashboard.Starred = dashboard.IsStarredBy(userID)
+		dashboard.Role = dashboard.RoleFor(userID)
 		return c.JSON(dashboard)
 	}
 }
 
 // DeleteDashboardHandler handles deleting a dashboard
-func DeleteDashboardHandler() fiber.Handler {
+func DeleteDashboardHandler(store models.Store, hub *realtime.Hub) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		// Get user ID from context
 		userID := c.Locals("user_id").(primitive.ObjectID)
@@ -227,12 +368,11 @@ func DeleteDashboardHandler() fiber.Handler {
 			})
 		}
 
-		// Create context with timeout
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
+		// Get the request context
+		ctx := c.UserContext()
 
 		// Get dashboard
-		dashboard, err := models.GetDashboardByID(ctx, dashboardID)
+		dashboard, err := store.GetDashboardByID(ctx, dashboardID)
 		if err != nil {
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 				"error": "Failed to retrieve dashboard: " + err.Error(),
@@ -254,21 +394,24 @@ func DeleteDashboardHandler() fiber.Handler {
 		}
 
 		// Delete dashboard
-		if err := models.DeleteDashboard(ctx, dashboardID); err != nil {
+		if err := store.DeleteDashboard(ctx, dashboardID); err != nil {
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 				"error": "Failed to delete dashboard: " + err.Error(),
 			})
 		}
 
+		// Notify other viewers
+		publishDashboardEvent(hub, c, realtime.EventDashboardDeleted, dashboardID, nil)
+
 		// Return response
 		return c.JSON(fiber.Map{
-			"message": "Dashboard deleted successfully",
+			"message": "Dashboard moved to trash",
 		})
 	}
 }
 
 // AddCardHandler handles adding a card to a dashboard
-func AddCardHandler() fiber.Handler {
+func AddCardHandler(store models.Store, hub *realtime.Hub) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		// Get user ID from context
 		userID := c.Locals("user_id").(primitive.ObjectID)
@@ -281,27 +424,23 @@ func AddCardHandler() fiber.Handler {
 			})
 		}
 
-		// Parse request body
+		// Parse and validate request body
 		var req DashboardCardRequest
-		if err := c.BodyParser(&req); err != nil {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": "Invalid request body",
-			})
+		if invalid := parseRequest(c, &req); invalid != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(invalid)
 		}
 
-		// Validate required fields
-		if req.Title == "" {
+		if msg := req.validate(); msg != "" {
 			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": "Title is required",
+				"error": msg,
 			})
 		}
 
-		// Create context with timeout
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
+		// Get the request context
+		ctx := c.UserContext()
 
 		// Get dashboard
-		dashboard, err := models.GetDashboardByID(ctx, dashboardID)
+		dashboard, err := store.GetDashboardByID(ctx, dashboardID)
 		if err != nil {
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 				"error": "Failed to retrieve dashboard: " + err.Error(),
@@ -315,8 +454,8 @@ func AddCardHandler() fiber.Handler {
 			})
 		}
 
-		// Check if dashboard belongs to user
-		if dashboard.UserID != userID {
+		// Check if user can edit dashboard
+		if !dashboard.CanEdit(userID) {
 			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
 				"error": "You don't have permission to modify this dashboard",
 			})
@@ -324,10 +463,17 @@ func AddCardHandler() fiber.Handler {
 
 		// Create card
 		card := &models.DashboardCard{
-			Title:     req.Title,
-			Type:      req.Type,
-			Position:  req.Position,
-			ChartType: req.ChartType,
+			Title:           req.Title,
+			Type:            req.Type,
+			Position:        req.Position,
+			ChartType:       req.ChartType,
+			RefreshInterval: req.RefreshInterval,
+			Content:         req.Content,
+			URL:             req.URL,
+			EmbedMode:       req.EmbedMode,
+			Metric:          req.Metric,
+			Options:         req.Options,
+			Transform:       req.Transform,
 		}
 
 		// Set query ID if provided
@@ -338,23 +484,39 @@ func AddCardHandler() fiber.Handler {
 					"error": "Invalid query ID",
 				})
 			}
+
+			// Cards can only use queries of the editor, the dashboard owner or the dashboard's organization
+			query, err := store.GetQueryByID(ctx, queryID)
+			if err != nil {
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+					"error": "Failed to retrieve query: " + err.Error(),
+				})
+			}
+			if query == nil || !cardCanUseQuery(dashboard, query, userID) {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": "Query not found",
+				})
+			}
 			card.QueryID = queryID
 		}
 
 		// Add card to dashboard
-		if err := models.AddCardToDashboard(ctx, dashboardID, card); err != nil {
+		if err := store.AddCardToDashboard(ctx, dashboardID, card); err != nil {
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 				"error": "Failed to add card to dashboard: " + err.Error(),
 			})
 		}
 
+		// Notify other viewers
+		publishDashboardEvent(hub, c, realtime.EventCardAdded, dashboardID, card)
+
 		// Return response
 		return c.JSON(card)
 	}
 }
 
 // UpdateCardHandler handles updating a card in a dashboard
-func UpdateCardHandler() fiber.Handler {
+func UpdateCardHandler(store models.Store, hub *realtime.Hub) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		// Get user ID from context
 		userID := c.Locals("user_id").(primitive.ObjectID)
@@ -374,20 +536,23 @@ func UpdateCardHandler() fiber.Handler {
 			})
 		}
 
-		// Parse request body
+		// Parse and validate request body
 		var req DashboardCardRequest
-		if err := c.BodyParser(&req); err != nil {
+		if invalid := parseRequest(c, &req); invalid != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(invalid)
+		}
+
+		if msg := req.validate(); msg != "" {
 			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": "Invalid request body",
+				"error": msg,
 			})
 		}
 
-		// Create context with timeout
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
+		// Get the request context
+		ctx := c.UserContext()
 
 		// Get dashboard
-		dashboard, err := models.GetDashboardByID(ctx, dashboardID)
+		dashboard, err := store.GetDashboardByID(ctx, dashboardID)
 		if err != nil {
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 				"error": "Failed to retrieve dashboard: " + err.Error(),
@@ -401,8 +566,8 @@ func UpdateCardHandler() fiber.Handler {
 			})
 		}
 
-		// Check if dashboard belongs to user
-		if dashboard.UserID != userID {
+		// Check if user can edit dashboard
+		if !dashboard.CanEdit(userID) {
 			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
 				"error": "You don't have permission to modify this dashboard",
 			})
@@ -425,10 +590,17 @@ func UpdateCardHandler() fiber.Handler {
 
 		// Prepare updates
 		updates := map[string]interface{}{
-			"title":      req.Title,
-			"type":       req.Type,
-			"position":   req.Position,
-			"chart_type": req.ChartType,
+			"title":            req.Title,
+			"type":             req.Type,
+			"position":         req.Position,
+			"chart_type":       req.ChartType,
+			"refresh_interval": req.RefreshInterval,
+			"content":          req.Content,
+			"url":              req.URL,
+			"embed_mode":       req.EmbedMode,
+			"metric":           req.Metric,
+			"options":          req.Options,
+			"transform":        req.Transform,
 		}
 
 		// Set query ID if provided
@@ -439,18 +611,33 @@ func UpdateCardHandler() fiber.Handler {
 					"error": "Invalid query ID",
 				})
 			}
+
+			// Cards can only use queries of the editor, the dashboard owner or the dashboard's organization
+			query, err := store.GetQueryByID(ctx, queryID)
+			if err != nil {
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+					"error": "Failed to retrieve query: " + err.Error(),
+				})
+			}
+			if query == nil || !cardCanUseQuery(dashboard, query, userID) {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": "Query not found",
+				})
+			}
 			updates["query_id"] = queryID
+		} else if req.Type == models.CardTypeText || req.Type == models.CardTypeEmbed {
+			updates["query_id"] = primitive.NilObjectID
 		}
 
 		// Update card
-		if err := models.UpdateDashboardCard(ctx, dashboardID, cardID, updates); err != nil {
+		if err := store.UpdateDashboardCard(ctx, dashboardID, cardID, updates); err != nil {
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 				"error": "Failed to update card: " + err.Error(),
 			})
 		}
 
 		// Get updated dashboard
-		updatedDashboard, err := models.GetDashboardByID(ctx, dashboardID)
+		updatedDashboard, err := store.GetDashboardByID(ctx, dashboardID)
 		if err != nil {
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 				"error": "Failed to retrieve updated dashboard: " + err.Error(),
@@ -466,13 +653,18 @@ func UpdateCardHandler() fiber.Handler {
 			}
 		}
 
+		// Notify other viewers
+		if updatedCard != nil {
+			publishDashboardEvent(hub, c, realtime.EventCardUpdated, dashboardID, updatedCard)
+		}
+
 		// Return response
 		return c.JSON(updatedCard)
 	}
 }
 
 // DeleteCardHandler handles deleting a card from a dashboard
-func DeleteCardHandler() fiber.Handler {
+func DeleteCardHandler(store models.Store, hub *realtime.Hub) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		// Get user ID from context
 		userID := c.Locals("user_id").(primitive.ObjectID)
@@ -492,12 +684,11 @@ func DeleteCardHandler() fiber.Handler {
 			})
 		}
 
-		// Create context with timeout
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
+		// Get the request context
+		ctx := c.UserContext()
 
 		// Get dashboard
-		dashboard, err := models.GetDashboardByID(ctx, dashboardID)
+		dashboard, err := store.GetDashboardByID(ctx, dashboardID)
 		if err != nil {
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 				"error": "Failed to retrieve dashboard: " + err.Error(),
@@ -511,8 +702,8 @@ func DeleteCardHandler() fiber.Handler {
 			})
 		}
 
-		// Check if dashboard belongs to user
-		if dashboard.UserID != userID {
+		// Check if user can edit dashboard
+		if !dashboard.CanEdit(userID) {
 			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
 				"error": "You don't have permission to modify this dashboard",
 			})
@@ -534,12 +725,22 @@ func DeleteCardHandler() fiber.Handler {
 		}
 
 		// Delete card
-		if err := models.DeleteDashboardCard(ctx, dashboardID, cardID); err != nil {
+		if err := store.DeleteDashboardCard(ctx, dashboardID, cardID); err != nil {
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 				"error": "Failed to delete card: " + err.Error(),
 			})
 		}
 
+		// Drop any cached data for the card
+		if err := store.DeleteCardData(ctx, dashboardID, cardID); err != nil {
+			log.Printf("Failed to delete cached data for card %s: %v", cardID.Hex(), err)
+		}
+
+		// Notify other viewers
+		publishDashboardEvent(hub, c, realtime.EventCardDeleted, dashboardID, fiber.Map{
+			"card_id": cardID,
+		})
+
 		// Return response
 		return c.JSON(fiber.Map{
 			"message": "Card deleted successfully",
@@ -548,7 +749,7 @@ func DeleteCardHandler() fiber.Handler {
 }
 
 // UpdateCardPositionsHandler handles updating the positions of multiple cards in a dashboard
-func UpdateCardPositionsHandler() fiber.Handler {
+func UpdateCardPositionsHandler(store models.Store, hub *realtime.Hub) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		// Get user ID from context
 		userID := c.Locals("user_id").(primitive.ObjectID)
@@ -561,20 +762,17 @@ func UpdateCardPositionsHandler() fiber.Handler {
 			})
 		}
 
-		// Parse request body
+		// Parse and validate request body
 		var req []CardPositionRequest
-		if err := c.BodyParser(&req); err != nil {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": "Invalid request body",
-			})
+		if invalid := parseRequest(c, &req); invalid != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(invalid)
 		}
 
-		// Create context with timeout
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
+		// Get the request context
+		ctx := c.UserContext()
 
 		// Get dashboard
-		dashboard, err := models.GetDashboardByID(ctx, dashboardID)
+		dashboard, err := store.GetDashboardByID(ctx, dashboardID)
 		if err != nil {
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 				"error": "Failed to retrieve dashboard: " + err.Error(),
@@ -588,8 +786,8 @@ func UpdateCardPositionsHandler() fiber.Handler {
 			})
 		}
 
-		// Check if dashboard belongs to user
-		if dashboard.UserID != userID {
+		// Check if user can edit dashboard
+		if !dashboard.CanEdit(userID) {
 			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
 				"error": "You don't have permission to modify this dashboard",
 			})
@@ -608,21 +806,269 @@ func UpdateCardPositionsHandler() fiber.Handler {
 		}
 
 		// Update card positions
-		if err := models.UpdateCardPositions(ctx, dashboardID, cardPositions); err != nil {
+		if err := store.UpdateCardPositions(ctx, dashboardID, cardPositions); err != nil {
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 				"error": "Failed to update card positions: " + err.Error(),
 			})
 		}
 
 		// Get updated dashboard
-		updatedDashboard, err := models.GetDashboardByID(ctx, dashboardID)
+		updatedDashboard, err := store.GetDashboardByID(ctx, dashboardID)
 		if err != nil {
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 				"error": "Failed to retrieve updated dashboard: " + err.Error(),
 			})
 		}
 
+		// Notify other viewers
+		positions := make([]fiber.Map, 0, len(updatedDashboard.Cards))
+		for _, card := range updatedDashboard.Cards {
+			positions = append(positions, fiber.Map{
+				"card_id":  card.ID,
+				"position": card.Position,
+			})
+		}
+		publishDashboardEvent(hub, c, realtime.EventCardsMoved, dashboardID, positions)
+
+		// Return response
+		updatedDashboard.Starred = updatedDashboard.IsStarredBy(userID)
+		updatedDashboard.Role = updatedDashboard.RoleFor(userID)
+		return c.JSON(updatedDashboard)
+	}
+}
+
+// StarDashboardHandler handles adding a dashboard to the user's favorites
+func StarDashboardHandler(store models.Store) fiber.Handler {
+	return setDashboardStarHandler(store, true)
+}
+
+// UnstarDashboardHandler handles removing a dashboard from the user's favorites
+func UnstarDashboardHandler(store models.Store) fiber.Handler {
+	return setDashboardStarHandler(store, false)
+}
+
+// setDashboardStarHandler stars or unstars a dashboard for the current user
+func setDashboardStarHandler(store models.Store, starred bool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from context
+		userID := c.Locals("user_id").(primitive.ObjectID)
+
+		// Get dashboard ID from params
+		dashboardID, err := primitive.ObjectIDFromHex(c.Params("id"))
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid dashboard ID",
+			})
+		}
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Get dashboard
+		dashboard, err := store.GetDashboardByID(ctx, dashboardID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve dashboard: " + err.Error(),
+			})
+		}
+
+		// Check if dashboard exists
+		if dashboard == nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Dashboard not found",
+			})
+		}
+
+		// Check if user can view dashboard
+		if !dashboard.CanView(userID) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "You don't have permission to access this dashboard",
+			})
+		}
+
+		// Update favorites
+		if starred {
+			err = store.StarDashboard(ctx, dashboardID, userID)
+		} else {
+			err = store.UnstarDashboard(ctx, dashboardID, userID)
+		}
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to update favorites: " + err.Error(),
+			})
+		}
+
 		// Return response
+		return c.JSON(fiber.Map{
+			"id":      dashboardID,
+			"starred": starred,
+		})
+	}
+}
+
+// ArchiveDashboardHandler handles archiving a dashboard
+func ArchiveDashboardHandler(store models.Store) fiber.Handler {
+	return setDashboardArchivedHandler(store, true)
+}
+
+// UnarchiveDashboardHandler handles restoring an archived dashboard to the main list
+func UnarchiveDashboardHandler(store models.Store) fiber.Handler {
+	return setDashboardArchivedHandler(store, false)
+}
+
+// setDashboardArchivedHandler archives or unarchives a dashboard
+func setDashboardArchivedHandler(store models.Store, archived bool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from context
+		userID := c.Locals("user_id").(primitive.ObjectID)
+
+		// Get dashboard ID from params
+		dashboardID, err := primitive.ObjectIDFromHex(c.Params("id"))
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid dashboard ID",
+			})
+		}
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Get dashboard
+		dashboard, err := store.GetDashboardByID(ctx, dashboardID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve dashboard: " + err.Error(),
+			})
+		}
+
+		// Check if dashboard exists
+		if dashboard == nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Dashboard not found",
+			})
+		}
+
+		// Check if dashboard belongs to user
+		if dashboard.UserID != userID {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "You don't have permission to archive this dashboard",
+			})
+		}
+
+		// Update archive state
+		if archived {
+			err = store.ArchiveDashboard(ctx, dashboardID)
+		} else {
+			err = store.UnarchiveDashboard(ctx, dashboardID)
+		}
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to update dashboard: " + err.Error(),
+			})
+		}
+
+		// Get updated dashboard
+		updatedDashboard, err := store.GetDashboardByID(ctx, dashboardID)
+		if err != nil || updatedDashboard == nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve updated dashboard",
+			})
+		}
+
+		// Return response
+		updatedDashboard.Starred = updatedDashboard.IsStarredBy(userID)
+		updatedDashboard.Role = updatedDashboard.RoleFor(userID)
 		return c.JSON(updatedDashboard)
 	}
 }
+
+// SetDefaultDashboardHandler handles making a dashboard the user's only default dashboard
+func SetDefaultDashboardHandler(store models.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from context
+		userID := c.Locals("user_id").(primitive.ObjectID)
+
+		// Get dashboard ID from params
+		dashboardID, err := primitive.ObjectIDFromHex(c.Params("id"))
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid dashboard ID",
+			})
+		}
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Get dashboard
+		dashboard, err := store.GetDashboardByID(ctx, dashboardID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve dashboard: " + err.Error(),
+			})
+		}
+
+		// Check if dashboard exists
+		if dashboard == nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Dashboard not found",
+			})
+		}
+
+		// Check if dashboard belongs to user
+		if dashboard.UserID != userID {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "Only the owner can make this dashboard their default",
+			})
+		}
+
+		// Archived dashboards can't be the default
+		if dashboard.ArchivedAt != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Archived dashboards can't be the default",
+			})
+		}
+
+		// Set default dashboard
+		if err := store.SetDefaultDashboard(ctx, userID, dashboardID); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to set default dashboard: " + err.Error(),
+			})
+		}
+
+		// Return response
+		dashboard.IsDefault = true
+		dashboard.Starred = dashboard.IsStarredBy(userID)
+		dashboard.Role = dashboard.RoleFor(userID)
+		return c.JSON(dashboard)
+	}
+}
+
+// GetDefaultDashboardHandler handles retrieving the user's default dashboard
+func GetDefaultDashboardHandler(store models.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from context
+		userID := c.Locals("user_id").(primitive.ObjectID)
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Get default dashboard
+		dashboard, err := store.GetDefaultDashboard(ctx, userID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve default dashboard: " + err.Error(),
+			})
+		}
+
+		if dashboard == nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "No default dashboard",
+			})
+		}
+
+		// Return response
+		dashboard.Starred = dashboard.IsStarredBy(userID)
+		dashboard.Role = dashboard.RoleFor(userID)
+		return sendConditionalJSON(c, dashboard, dashboard.UpdatedAt)
+	}
+}
@@ -0,0 +1,158 @@
+package api
+
+import (
+	"time"
+
+	"github.com/gofiber/contrib/websocket"
+	"github.com/gofiber/fiber/v2"
+	"github.com/zucced/goquery/models"
+	"github.com/zucced/goquery/realtime"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ConnectionIDHeader lets clients tag their own changes so the live channel
+// doesn't echo them back to the connection that made them
+const ConnectionIDHeader = "X-Connection-ID"
+
+const (
+	// liveWriteTimeout bounds how long a single message write may take
+	liveWriteTimeout = 10 * time.Second
+	// livePingInterval is how often idle connections are pinged to keep them open
+	livePingInterval = 30 * time.Second
+)
+
+// DashboardLiveHandler handles the WebSocket channel that streams changes to a
+// dashboard's cards and layout to everyone viewing it
+func DashboardLiveHandler(store models.Store, hub *realtime.Hub) fiber.Handler {
+	upgrade := websocket.New(func(conn *websocket.Conn) {
+		serveDashboardLive(hub, conn)
+	})
+
+	return func(c *fiber.Ctx) error {
+		// Only accept WebSocket upgrades
+		if !websocket.IsWebSocketUpgrade(c) {
+			return c.Status(fiber.StatusUpgradeRequired).JSON(fiber.Map{
+				"error": "WebSocket upgrade required",
+			})
+		}
+
+		// Get user ID from context
+		userID := c.Locals("user_id").(primitive.ObjectID)
+
+		// Get dashboard ID from params
+		dashboardID, err := primitive.ObjectIDFromHex(c.Params("id"))
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid dashboard ID",
+			})
+		}
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Get dashboard
+		dashboard, err := store.GetDashboardByID(ctx, dashboardID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve dashboard: " + err.Error(),
+			})
+		}
+
+		// Check if dashboard exists
+		if dashboard == nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Dashboard not found",
+			})
+		}
+
+		// Check if user can view dashboard
+		if !dashboard.CanView(userID) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "You don't have permission to access this dashboard",
+			})
+		}
+
+		c.Locals("dashboard_id", dashboardID)
+		return upgrade(c)
+	}
+}
+
+// serveDashboardLive relays hub events to a WebSocket connection until either
+// side goes away
+func serveDashboardLive(hub *realtime.Hub, conn *websocket.Conn) {
+	userID := conn.Locals("user_id").(primitive.ObjectID)
+	dashboardID := conn.Locals("dashboard_id").(primitive.ObjectID)
+
+	sub := hub.Subscribe(dashboardID, userID)
+
+	// Tell the client which connection ID to send with its own changes
+	conn.SetWriteDeadline(time.Now().Add(liveWriteTimeout))
+	if err := conn.WriteJSON(fiber.Map{
+		"type":          "connected",
+		"dashboard_id":  dashboardID,
+		"connection_id": sub.ID,
+		"viewers":       hub.Viewers(dashboardID),
+	}); err != nil {
+		hub.Unsubscribe(sub)
+		return
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		writeDashboardEvents(conn, sub)
+	}()
+
+	// Incoming messages are ignored; reading detects when the client disconnects
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			break
+		}
+	}
+
+	// Stop the writer before the connection is released
+	hub.Unsubscribe(sub)
+	<-done
+}
+
+// writeDashboardEvents writes a subscription's events to the connection and
+// keeps it alive with pings. It closes the connection once the subscription ends.
+func writeDashboardEvents(conn *websocket.Conn, sub *realtime.Subscription) {
+	ticker := time.NewTicker(livePingInterval)
+	defer ticker.Stop()
+	defer conn.Close()
+
+	for {
+		select {
+		case event, ok := <-sub.Events:
+			if !ok {
+				return
+			}
+			conn.SetWriteDeadline(time.Now().Add(liveWriteTimeout))
+			if err := conn.WriteJSON(event); err != nil {
+				return
+			}
+		case <-ticker.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(liveWriteTimeout)); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// publishDashboardEvent notifies the other viewers of a dashboard about a change
+// made by the current request
+func publishDashboardEvent(hub *realtime.Hub, c *fiber.Ctx, eventType realtime.EventType, dashboardID primitive.ObjectID, data interface{}) {
+	if hub == nil {
+		return
+	}
+
+	userID, _ := c.Locals("user_id").(primitive.ObjectID)
+	hub.Publish(realtime.Event{
+		Type:         eventType,
+		DashboardID:  dashboardID,
+		UserID:       userID,
+		ConnectionID: c.Get(ConnectionIDHeader),
+		Data:         data,
+	})
+}
@@ -0,0 +1,351 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/mail"
+	"net/url"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/zucced/goquery/config"
+	"github.com/zucced/goquery/mailer"
+	"github.com/zucced/goquery/models"
+	"github.com/zucced/goquery/realtime"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ShareDashboardRequest represents the request body for sharing a dashboard
+type ShareDashboardRequest struct {
+	Email string               `json:"email" validate:"notblank"`
+	Role  models.DashboardRole `json:"role" validate:"omitempty,oneof=viewer editor"`
+}
+
+// AcceptDashboardInviteRequest represents the request body for accepting a
+// dashboard invite
+type AcceptDashboardInviteRequest struct {
+	Token string `json:"token" validate:"required"`
+}
+
+// GetCollaboratorsHandler handles listing the users a dashboard is shared with
+func GetCollaboratorsHandler(store models.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from context
+		userID := c.Locals("user_id").(primitive.ObjectID)
+
+		// Get dashboard ID from params
+		dashboardID, err := primitive.ObjectIDFromHex(c.Params("id"))
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid dashboard ID",
+			})
+		}
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Get dashboard
+		dashboard, err := store.GetDashboardByID(ctx, dashboardID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve dashboard: " + err.Error(),
+			})
+		}
+
+		// Check if dashboard exists
+		if dashboard == nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Dashboard not found",
+			})
+		}
+
+		// Check if user can view dashboard
+		if !dashboard.CanView(userID) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "You don't have permission to access this dashboard",
+			})
+		}
+
+		collaborators := dashboard.Collaborators
+		if collaborators == nil {
+			collaborators = []models.DashboardCollaborator{}
+		}
+
+		// Return response
+		return c.JSON(fiber.Map{
+			"owner_id":      dashboard.UserID,
+			"collaborators": collaborators,
+		})
+	}
+}
+
+// ShareDashboardHandler handles sharing a dashboard with a user by email.
+// People without an account are emailed an invite.
+func ShareDashboardHandler(store models.Store, cfg *config.Config, gateway *realtime.Gateway, mailQueue mailer.Mailer) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from context
+		userID := c.Locals("user_id").(primitive.ObjectID)
+
+		// Get dashboard ID from params
+		dashboardID, err := primitive.ObjectIDFromHex(c.Params("id"))
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid dashboard ID",
+			})
+		}
+
+		// Parse and validate request body
+		var req ShareDashboardRequest
+		if invalid := parseRequest(c, &req); invalid != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(invalid)
+		}
+
+		// Validate request
+		addr, err := mail.ParseAddress(strings.TrimSpace(req.Email))
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "A valid email is required",
+			})
+		}
+
+		if req.Role == "" {
+			req.Role = models.DashboardRoleViewer
+		}
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Get dashboard
+		dashboard, err := store.GetDashboardByID(ctx, dashboardID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve dashboard: " + err.Error(),
+			})
+		}
+
+		// Check if dashboard exists
+		if dashboard == nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Dashboard not found",
+			})
+		}
+
+		// Only the owner can manage sharing
+		if dashboard.UserID != userID {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "You don't have permission to share this dashboard",
+			})
+		}
+
+		// Link the collaborator to an existing account when there is one
+		collaborator := &models.DashboardCollaborator{
+			Email: addr.Address,
+			Role:  req.Role,
+		}
+
+		user, err := store.GetUserByEmail(ctx, addr.Address)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to look up user: " + err.Error(),
+			})
+		}
+		if user != nil {
+			if user.ID == dashboard.UserID {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": "You already own this dashboard",
+				})
+			}
+			collaborator.UserID = user.ID
+		}
+
+		// People without an account accept the invite with a token mailed to
+		// the email, so signing up with someone else's email doesn't grant access
+		var inviteToken string
+		if collaborator.UserID.IsZero() {
+			inviteToken, err = collaborator.NewInviteToken()
+			if err != nil {
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+					"error": "Failed to create invite",
+				})
+			}
+		}
+
+		// Share dashboard
+		if err := store.ShareDashboard(ctx, dashboardID, collaborator); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to share dashboard: " + err.Error(),
+			})
+		}
+
+		// Tell the collaborator when they have an account
+		if gateway != nil && !collaborator.UserID.IsZero() {
+			gateway.Notify(collaborator.UserID, "dashboard_shared", fiber.Map{
+				"dashboard_id": dashboardID,
+				"name":         dashboard.Name,
+				"role":         collaborator.Role,
+				"shared_by":    userID,
+			})
+		}
+
+		// Invite them by email when they don't
+		if collaborator.UserID.IsZero() {
+			sendDashboardInvite(ctx, store, cfg, mailQueue, dashboard, collaborator, inviteToken, userID)
+		}
+
+		// Return the updated collaborator list
+		updatedDashboard, err := store.GetDashboardByID(ctx, dashboardID)
+		if err != nil || updatedDashboard == nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve updated dashboard",
+			})
+		}
+
+		return c.JSON(fiber.Map{
+			"owner_id":      updatedDashboard.UserID,
+			"collaborators": updatedDashboard.Collaborators,
+		})
+	}
+}
+
+// sendDashboardInvite queues the email inviting someone without an account to
+// a dashboard shared with them, with the link that accepts the invite.
+// Failures are logged, the dashboard is shared either way.
+func sendDashboardInvite(ctx context.Context, store models.Store, cfg *config.Config, mailQueue mailer.Mailer, dashboard *models.Dashboard, collaborator *models.DashboardCollaborator, token string, userID primitive.ObjectID) {
+	invitedBy := "Someone"
+	if owner, err := store.GetUserByID(ctx, userID); err == nil && owner != nil {
+		invitedBy = owner.Name
+		if invitedBy == "" {
+			invitedBy = owner.Email
+		}
+	}
+
+	msg, err := mailer.Render(mailer.TemplateDashboardInvite, []string{collaborator.Email}, fiber.Map{
+		"InvitedBy": invitedBy,
+		"Dashboard": dashboard.Name,
+		"Role":      collaborator.Role,
+		"Link":      cfg.FrontendURL + "/invites/accept?token=" + url.QueryEscape(token),
+	})
+	if err == nil {
+		err = mailQueue.Send(ctx, msg)
+	}
+	if err != nil && !errors.Is(err, mailer.ErrNotConfigured) {
+		log.Printf("Failed to send dashboard invite to %s: %v", collaborator.Email, err)
+	}
+}
+
+// AcceptDashboardInviteHandler handles accepting a dashboard invite with the
+// token from its email, which shares the dashboard with the signed in user
+func AcceptDashboardInviteHandler(store models.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from context
+		userID := c.Locals("user_id").(primitive.ObjectID)
+
+		// Parse and validate request body
+		var req AcceptDashboardInviteRequest
+		if invalid := parseRequest(c, &req); invalid != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(invalid)
+		}
+
+		// Accept the invite
+		dashboardID, err := store.AcceptDashboardInvite(c.UserContext(), userID, req.Token)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to accept invite: " + err.Error(),
+			})
+		}
+		if dashboardID.IsZero() {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Invite not found or already accepted",
+			})
+		}
+
+		// Return response
+		return c.JSON(fiber.Map{
+			"dashboard_id": dashboardID,
+		})
+	}
+}
+
+// UnshareDashboardHandler handles removing a collaborator from a dashboard.
+// Collaborators can also remove themselves.
+func UnshareDashboardHandler(store models.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from context
+		userID := c.Locals("user_id").(primitive.ObjectID)
+
+		// Get dashboard ID and collaborator ID from params
+		dashboardID, err := primitive.ObjectIDFromHex(c.Params("id"))
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid dashboard ID",
+			})
+		}
+
+		collaboratorID, err := primitive.ObjectIDFromHex(c.Params("collaboratorId"))
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid collaborator ID",
+			})
+		}
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Get dashboard
+		dashboard, err := store.GetDashboardByID(ctx, dashboardID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve dashboard: " + err.Error(),
+			})
+		}
+
+		// Check if dashboard exists
+		if dashboard == nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Dashboard not found",
+			})
+		}
+
+		// Find the collaborator
+		var collaborator *models.DashboardCollaborator
+		for i := range dashboard.Collaborators {
+			if dashboard.Collaborators[i].ID == collaboratorID {
+				collaborator = &dashboard.Collaborators[i]
+				break
+			}
+		}
+
+		if collaborator == nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Collaborator not found",
+			})
+		}
+
+		// Only the owner or the collaborator themselves can remove access
+		if dashboard.UserID != userID && collaborator.UserID != userID {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "You don't have permission to manage sharing for this dashboard",
+			})
+		}
+
+		// Remove collaborator
+		if err := store.UnshareDashboard(ctx, dashboardID, collaboratorID); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to remove collaborator: " + err.Error(),
+			})
+		}
+
+		// Drop the dashboard from the removed user's favorites
+		if !collaborator.UserID.IsZero() {
+			if err := store.UnstarDashboard(ctx, dashboardID, collaborator.UserID); err != nil {
+				log.Printf("Failed to unstar dashboard %s for removed collaborator: %v", dashboardID.Hex(), err)
+			}
+		}
+
+		// Return response
+		return c.JSON(fiber.Map{
+			"message": "Collaborator removed successfully",
+		})
+	}
+}
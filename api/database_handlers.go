@@ -2,116 +2,280 @@ package api
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/zucced/goquery/config"
 	"github.com/zucced/goquery/models"
+	"github.com/zucced/goquery/workers"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 // DatabaseRequest represents the request body for database operations
 type DatabaseRequest struct {
-	Name          string `json:"name"`
-	Type          string `json:"type"`
-	Host          string `json:"host"`
-	Port          string `json:"port"`
-	Username      string `json:"username"`
-	Password      string `json:"password"`
-	DatabaseName  string `json:"database"`
-	SSL           bool   `json:"ssl"`
-	ConnectionURI string `json:"connection_uri"`
+	Name                  string                    `json:"name" validate:"notblank,max=100"`
+	Type                  string                    `json:"type" validate:"required,oneof=postgresql mongodb"`
+	Host                  string                    `json:"host" validate:"required_without=ConnectionURI"`
+	Port                  string                    `json:"port" validate:"omitempty,port"`
+	Username              string                    `json:"username"`
+	Password              string                    `json:"password"`
+	DatabaseName          string                    `json:"database" validate:"required"`
+	SSL                   bool                      `json:"ssl"`
+	ConnectionURI         string                    `json:"connection_uri"`
+	AllowWrites           bool                      `json:"allow_writes"`
+	Environment           string                    `json:"environment"`             // production, staging or development
+	SafetyLevel           string                    `json:"safety_level"`            // Defaults by environment
+	SchemaRefreshInterval int                       `json:"schema_refresh_interval"` // Seconds, 0 to disable
+	IncludeTables         []string                  `json:"include_tables"`          // Glob patterns
+	ExcludeTables         []string                  `json:"exclude_tables"`          // Glob patterns
+	DiscoverFunctions     bool                      `json:"discover_functions"`
+	TLS                   *TLSRequest               `json:"tls"`
+	SSHTunnel             *SSHTunnelRequest         `json:"ssh_tunnel"`
+	ReadReplicas          []models.ReadReplica      `json:"read_replicas"`
+	Execution             *models.ExecutionSettings `json:"execution"`
+}
+
+// validateTablePatterns checks the include and exclude lists
+func (r *DatabaseRequest) validateTablePatterns() error {
+	if err := models.ValidateTablePatterns(r.IncludeTables); err != nil {
+		return err
+	}
+	return models.ValidateTablePatterns(r.ExcludeTables)
+}
+
+// safetyLevel returns the requested safety level, or the environment's default
+func (r *DatabaseRequest) safetyLevel() string {
+	if r.SafetyLevel == "" {
+		return models.DefaultSafetyLevel(r.Environment)
+	}
+	return r.SafetyLevel
+}
+
+// TLSRequest represents the TLS settings of a database connection
+type TLSRequest struct {
+	Mode       string `json:"mode" validate:"omitempty,oneof=verify-full verify-ca skip-verify"`
+	CACert     string `json:"ca_cert"`
+	ClientCert string `json:"client_cert"`
+	ClientKey  string `json:"client_key"`
+}
+
+// toModel converts the request to a TLS configuration. A blank client key is
+// kept from the existing configuration while the client certificate is
+// unchanged. A nil request falls back to the plain SSL flag.
+func (r *TLSRequest) toModel(existing *models.DatabaseTLS) (*models.DatabaseTLS, error) {
+	if r == nil {
+		return nil, nil
+	}
+
+	tlsConfig := &models.DatabaseTLS{
+		Mode:       r.Mode,
+		CACert:     r.CACert,
+		ClientCert: r.ClientCert,
+		ClientKey:  r.ClientKey,
+	}
+	if existing != nil && tlsConfig.ClientKey == "" && tlsConfig.ClientCert == existing.ClientCert {
+		tlsConfig.ClientKey = existing.ClientKey
+	}
+
+	if err := tlsConfig.Validate(); err != nil {
+		return nil, err
+	}
+	return tlsConfig, nil
+}
+
+// SSHTunnelRequest represents the bastion host settings of a database connection
+type SSHTunnelRequest struct {
+	Host       string `json:"host" validate:"required"`
+	Port       string `json:"port" validate:"omitempty,port"`
+	Username   string `json:"username"`
+	Password   string `json:"password"`
+	PrivateKey string `json:"private_key"`
+	Passphrase string `json:"passphrase"`
+	HostKey    string `json:"host_key"`
+}
+
+// toModel converts the request to a tunnel. Secrets left blank are kept from
+// the existing tunnel, mirroring how the database password is updated. A nil
+// request removes the tunnel.
+func (r *SSHTunnelRequest) toModel(existing *models.SSHTunnel) (*models.SSHTunnel, error) {
+	if r == nil {
+		return nil, nil
+	}
+
+	sshTunnel := &models.SSHTunnel{
+		Host:       r.Host,
+		Port:       r.Port,
+		Username:   r.Username,
+		Password:   r.Password,
+		PrivateKey: r.PrivateKey,
+		Passphrase: r.Passphrase,
+		HostKey:    r.HostKey,
+	}
+	if existing != nil {
+		// The pinned key stays while the tunnel points at the same host
+		if sshTunnel.HostKey == "" && sshTunnel.Host == existing.Host && sshTunnel.Port == existing.Port {
+			sshTunnel.HostKey = existing.HostKey
+		}
+		if sshTunnel.Password == "" {
+			sshTunnel.Password = existing.Password
+		}
+		if sshTunnel.PrivateKey == "" {
+			sshTunnel.PrivateKey = existing.PrivateKey
+			if sshTunnel.Passphrase == "" {
+				sshTunnel.Passphrase = existing.Passphrase
+			}
+		}
+	}
+
+	if err := sshTunnel.Validate(); err != nil {
+		return nil, err
+	}
+	return sshTunnel, nil
+}
+
+// toModel validates the settings struct tags can't check and converts the
+// request to a new database connection owned by userID
+func (r *DatabaseRequest) toModel(userID primitive.ObjectID) (*models.Database, error) {
+	if err := models.ValidateSchemaRefreshInterval(r.SchemaRefreshInterval); err != nil {
+		return nil, err
+	}
+	if err := r.validateTablePatterns(); err != nil {
+		return nil, err
+	}
+	if err := models.ValidateEnvironment(r.Environment, r.SafetyLevel); err != nil {
+		return nil, err
+	}
+	if err := models.ValidateReadReplicas(r.Type, r.ReadReplicas); err != nil {
+		return nil, err
+	}
+	if r.Execution != nil {
+		if err := r.Execution.Validate(); err != nil {
+			return nil, fmt.Errorf("Invalid execution settings: %v", err)
+		}
+	}
+
+	db := &models.Database{
+		UserID:        userID,
+		Name:          r.Name,
+		Type:          r.Type,
+		Host:          r.Host,
+		Port:          r.Port,
+		Username:      r.Username,
+		Password:      r.Password,
+		DatabaseName:  r.DatabaseName,
+		SSL:           r.SSL,
+		ConnectionURI: r.ConnectionURI,
+		AllowWrites:   r.AllowWrites,
+
+		SchemaRefreshInterval: r.SchemaRefreshInterval,
+		IncludeTables:         r.IncludeTables,
+		ExcludeTables:         r.ExcludeTables,
+		DiscoverFunctions:     r.DiscoverFunctions,
+		ReadReplicas:          r.ReadReplicas,
+		Environment:           r.Environment,
+		SafetyLevel:           r.safetyLevel(),
+		Execution:             r.Execution,
+	}
+
+	// Set up TLS and the ssh tunnel
+	tlsConfig, err := r.TLS.toModel(nil)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid TLS configuration: %v", err)
+	}
+	db.TLS = tlsConfig
+
+	sshTunnel, err := r.SSHTunnel.toModel(nil)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid SSH tunnel: %v", err)
+	}
+	db.SSHTunnel = sshTunnel
+
+	return db, nil
+}
+
+// secretsLocked reports whether a connection references server secrets and
+// the user isn't an admin, who alone can change or copy such connections
+func secretsLocked(ctx context.Context, store models.Store, cfg *config.Config, db *models.Database, userID primitive.ObjectID) (bool, error) {
+	if !db.SecretReferences {
+		return false, nil
+	}
+	admin, err := isAdmin(ctx, store, cfg, userID)
+	return !admin, err
+}
+
+// saveNewDatabase saves a database whose connection was just tested and
+// queues fetching its schema in the background
+func saveNewDatabase(ctx context.Context, store models.Store, refresher *workers.SchemaRefresher, db *models.Database) (*models.Database, error) {
+	now := time.Now()
+	db.LastConnected = &now
+	db.Schema = &models.Schema{Tables: []models.Table{}}
+	db.SchemaRefresh = &models.SchemaRefreshStatus{Status: models.SchemaRefreshPending}
+	createdDB, err := store.CreateDatabase(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	queueSchemaRefresh(refresher, createdDB)
+	return createdDB, nil
+}
+
+// checkPrivileges records what a connection's credentials are allowed to do,
+// logging a warning when they can do more than read
+func checkPrivileges(ctx context.Context, db *models.Database) {
+	db.Privileges = models.CheckPrivileges(ctx, db)
+	db.PrivilegeLevel = db.Privileges.Level
+	if db.Privileges.Warning != "" {
+		log.Printf("WARNING: connection %q of user %s has %s privileges: %s", db.Name, db.UserID.Hex(), db.PrivilegeLevel, db.Privileges.Warning)
+	}
 }
 
 // CreateDatabaseHandler handles creating a new database connection
-func CreateDatabaseHandler() fiber.Handler {
+func CreateDatabaseHandler(store models.Store, refresher *workers.SchemaRefresher) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		// Get user ID from context
 		userID := c.Locals("user_id").(primitive.ObjectID)
 
-		// Parse request body
+		// Parse and validate request body
 		var req DatabaseRequest
-		if err := c.BodyParser(&req); err != nil {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": "Invalid request body",
-			})
+		if invalid := parseRequest(c, &req); invalid != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(invalid)
 		}
 
-		// Validate required fields
-		if req.Name == "" || req.Type == "" || req.Host == "" || req.DatabaseName == "" {
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Create database
+		db, err := req.toModel(userID)
+		if err != nil {
 			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": "Name, type, host, and database name are required",
+				"error": err.Error(),
 			})
 		}
+		db.OrgID, db.WorkspaceID = workspaceScope(c)
 
-		// Create context with timeout for initial operations
-		// We'll create a separate context with longer timeout for schema operations
-		_, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-		defer cancel()
-
-		// Create database
-		db := &models.Database{
-			UserID:        userID,
-			Name:          req.Name,
-			Type:          req.Type,
-			Host:          req.Host,
-			Port:          req.Port,
-			Username:      req.Username,
-			Password:      req.Password,
-			DatabaseName:  req.DatabaseName,
-			SSL:           req.SSL,
-			ConnectionURI: req.ConnectionURI,
+		// Pin the ssh host key and test connection
+		if err := models.PinSSHHostKey(ctx, db); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Failed to connect to SSH tunnel: " + err.Error(),
+			})
 		}
-
-		// Test connection
-		if err := models.TestConnection(db); err != nil {
+		if err := models.TestConnection(ctx, db); err != nil {
 			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 				"error": "Failed to connect to database: " + err.Error(),
 			})
 		}
 
-		// Create a new context with a longer timeout for schema fetching
-		// We don't use the context directly here, but we create it to ensure the operation has enough time
-		_, schemaCancel := context.WithTimeout(context.Background(), 180*time.Second)
-		defer schemaCancel()
+		// Record what the credentials may do, warning when it is more than reading
+		checkPrivileges(ctx, db)
 
-		// Fetch schema
-		log.Printf("Fetching schema for database %s...", db.Name)
-		schema, err := models.FetchDatabaseSchema(db)
-
-		if err != nil {
-			// Log the error but don't fail the request
-			log.Printf("Failed to fetch schema: %v", err)
-			// Initialize with empty schema
-			db.Schema = &models.Schema{Tables: []models.Table{}}
-		} else {
-			log.Printf("Schema fetched successfully with %d tables", len(schema.Tables))
-			db.Schema = schema
-		}
-
-		// Fetch stats
-		log.Printf("Fetching stats for database %s...", db.Name)
-		stats, err := models.FetchDatabaseStats(db)
-		if err != nil {
-			// Log the error but don't fail the request
-			log.Printf("Failed to fetch stats: %v", err)
-		} else {
-			db.Stats = stats
-		}
-
-		// Update last connected time
-		now := time.Now()
-		db.LastConnected = &now
-
-		// Save database
-		log.Printf("Saving new database with schema containing %d tables...", len(db.Schema.Tables))
-		createdDB, err := models.CreateDatabase(context.Background(), db)
+		// Save the database, its schema is fetched in the background
+		createdDB, err := saveNewDatabase(ctx, store, refresher, db)
 		if err != nil {
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 				"error": "Failed to save database: " + err.Error(),
 			})
 		}
-		log.Printf("Database created successfully")
 
 		// Return response
 		return c.Status(fiber.StatusCreated).JSON(createdDB)
@@ -119,23 +283,34 @@ func CreateDatabaseHandler() fiber.Handler {
 }
 
 // GetDatabasesHandler handles retrieving all databases for a user
-func GetDatabasesHandler() fiber.Handler {
+func GetDatabasesHandler(store models.Store) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		// Get user ID from context
 		userID := c.Locals("user_id").(primitive.ObjectID)
 
-		// Create context with timeout
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
+		// Get the request context
+		ctx := c.UserContext()
 
-		// Get databases
-		databases, err := models.GetDatabasesByUserID(ctx, userID)
+		// Get databases in the selected workspace
+		_, workspaceID := workspaceScope(c)
+		databases, err := store.GetDatabasesByUserID(ctx, userID, workspaceID)
 		if err != nil {
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 				"error": "Failed to retrieve databases: " + err.Error(),
 			})
 		}
 
+		// Databases the user doesn't own are listed because they were shared
+		// with them, which only allows querying
+		for _, db := range databases {
+			access := models.DatabaseAccessQuery
+			if db.UserID == userID {
+				access = models.DatabaseAccessOwner
+			}
+			hideConnectionDetails(db, access)
+			db.Schema = db.VisibleSchema()
+		}
+
 		// Return response
 		return c.JSON(fiber.Map{
 			"databases": databases,
@@ -144,7 +319,7 @@ func GetDatabasesHandler() fiber.Handler {
 }
 
 // GetDatabaseHandler handles retrieving a single database
-func GetDatabaseHandler() fiber.Handler {
+func GetDatabaseHandler(store models.Store, refresher *workers.SchemaRefresher) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		// Get user ID from context
 		userID := c.Locals("user_id").(primitive.ObjectID)
@@ -157,12 +332,11 @@ func GetDatabaseHandler() fiber.Handler {
 			})
 		}
 
-		// Create context with timeout
-		ctx, cancel := context.WithTimeout(context.Background(), 120*time.Second)
-		defer cancel()
+		// Get the request context
+		ctx := c.UserContext()
 
 		// Get database
-		db, err := models.GetDatabaseByID(ctx, databaseID)
+		db, err := store.GetDatabaseByID(ctx, databaseID)
 		if err != nil {
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 				"error": "Failed to retrieve database: " + err.Error(),
@@ -176,73 +350,43 @@ func GetDatabaseHandler() fiber.Handler {
 			})
 		}
 
-		// Check if database belongs to user
-		if db.UserID != userID {
+		// Check if user can access database
+		access, err := store.ResolveDatabaseAccess(ctx, db, userID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to check database access: " + err.Error(),
+			})
+		}
+		if !access.CanQuery() {
 			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
 				"error": "You do not have permission to access this database",
 			})
 		}
 
-		// Check if refresh parameter is set
-		refresh := c.Query("refresh") == "true"
-		if refresh {
-			// Create a new context with a longer timeout for schema fetching
-			// We don't use the context directly here, but we create it to ensure the operation has enough time
-			_, schemaCancel := context.WithTimeout(context.Background(), 180*time.Second)
-			defer schemaCancel()
-
-			// Test connection
-			if err := models.TestConnection(db); err != nil {
-				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-					"error": "Failed to connect to database: " + err.Error(),
-				})
-			}
-
-			// Fetch schema
-			log.Printf("Fetching schema for database %s (%s)...", db.Name, db.ID.Hex())
-			schema, err := models.FetchDatabaseSchema(db)
-
-			if err != nil {
-				// Log the error but don't fail the request
-				log.Printf("Failed to fetch schema: %v", err)
-				// Initialize with empty schema
-				db.Schema = &models.Schema{Tables: []models.Table{}}
-			} else {
-				log.Printf("Schema fetched successfully with %d tables", len(schema.Tables))
-				db.Schema = schema
-			}
-
-			// Fetch stats
-			log.Printf("Fetching stats for database %s...", db.Name)
-			stats, err := models.FetchDatabaseStats(db)
-			if err != nil {
-				// Log the error but don't fail the request
-				log.Printf("Failed to fetch stats: %v", err)
-			} else {
-				db.Stats = stats
-			}
-
-			// Update last connected time
-			now := time.Now()
-			db.LastConnected = &now
-
-			// Save updated database
-			log.Printf("Saving updated database schema with %d tables...", len(db.Schema.Tables))
-			if err := models.UpdateDatabase(context.Background(), db); err != nil {
+		// Queue a background schema refresh when requested
+		if c.Query("refresh") == "true" {
+			if err := store.MarkSchemaRefreshPending(ctx, db.ID); err != nil {
 				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-					"error": "Failed to update database: " + err.Error(),
+					"error": "Failed to queue schema refresh: " + err.Error(),
 				})
 			}
-			log.Printf("Database schema updated successfully")
+			if db.SchemaRefresh == nil {
+				db.SchemaRefresh = &models.SchemaRefreshStatus{}
+			}
+			db.SchemaRefresh.Status = models.SchemaRefreshPending
+			db.SchemaRefresh.Error = ""
+			queueSchemaRefresh(refresher, db)
 		}
 
 		// Return response
-		return c.JSON(db)
+		hideConnectionDetails(db, access)
+		db.Schema = db.VisibleSchema()
+		return sendConditionalJSON(c, db, db.UpdatedAt)
 	}
 }
 
 // UpdateDatabaseHandler handles updating a database
-func UpdateDatabaseHandler() fiber.Handler {
+func UpdateDatabaseHandler(store models.Store, cfg *config.Config, refresher *workers.SchemaRefresher) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		// Get user ID from context
 		userID := c.Locals("user_id").(primitive.ObjectID)
@@ -255,20 +399,17 @@ func UpdateDatabaseHandler() fiber.Handler {
 			})
 		}
 
-		// Parse request body
+		// Parse and validate request body
 		var req DatabaseRequest
-		if err := c.BodyParser(&req); err != nil {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": "Invalid request body",
-			})
+		if invalid := parseRequest(c, &req); invalid != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(invalid)
 		}
 
-		// Create context with timeout
-		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second) // Increased timeout for schema fetching
-		defer cancel()
+		// Get the request context
+		ctx := c.UserContext()
 
 		// Get database
-		db, err := models.GetDatabaseByID(ctx, databaseID)
+		db, err := store.GetDatabaseByID(ctx, databaseID)
 		if err != nil {
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 				"error": "Failed to retrieve database: " + err.Error(),
@@ -282,13 +423,32 @@ func UpdateDatabaseHandler() fiber.Handler {
 			})
 		}
 
-		// Check if database belongs to user
-		if db.UserID != userID {
+		// Only the owner can change the connection
+		access, err := store.ResolveDatabaseAccess(ctx, db, userID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to check database access: " + err.Error(),
+			})
+		}
+		if !access.CanManage() {
 			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
 				"error": "You do not have permission to update this database",
 			})
 		}
 
+		// Pointing the connection elsewhere would send the secrets there
+		locked, err := secretsLocked(ctx, store, cfg, db, userID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve user: " + err.Error(),
+			})
+		}
+		if locked {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "Only admins can change connections that reference server secrets",
+			})
+		}
+
 		// Update database
 		db.Name = req.Name
 		db.Type = req.Type
@@ -301,54 +461,91 @@ func UpdateDatabaseHandler() fiber.Handler {
 		db.DatabaseName = req.DatabaseName
 		db.SSL = req.SSL
 		db.ConnectionURI = req.ConnectionURI
-
-		// Test connection
-		if err := models.TestConnection(db); err != nil {
+		db.AllowWrites = req.AllowWrites
+		if err := models.ValidateSchemaRefreshInterval(req.SchemaRefreshInterval); err != nil {
 			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": "Failed to connect to database: " + err.Error(),
+				"error": err.Error(),
 			})
 		}
+		if err := req.validateTablePatterns(); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		if err := models.ValidateEnvironment(req.Environment, req.SafetyLevel); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		if err := models.ValidateReadReplicas(req.Type, req.ReadReplicas); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		if req.Execution != nil {
+			if err := req.Execution.Validate(); err != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": "Invalid execution settings: " + err.Error(),
+				})
+			}
+		}
+		db.SchemaRefreshInterval = req.SchemaRefreshInterval
+		db.IncludeTables = req.IncludeTables
+		db.ExcludeTables = req.ExcludeTables
+		db.DiscoverFunctions = req.DiscoverFunctions
+		db.ReadReplicas = req.ReadReplicas
+		db.Environment = req.Environment
+		db.SafetyLevel = req.safetyLevel()
+		db.Execution = req.Execution
 
-		// Create a new context with a longer timeout for schema fetching
-		// We don't use the context directly here, but we create it to ensure the operation has enough time
-		_, schemaCancel := context.WithTimeout(context.Background(), 180*time.Second)
-		defer schemaCancel()
-
-		// Fetch schema
-		log.Printf("Fetching schema for database %s (%s)...", db.Name, db.ID.Hex())
-		schema, err := models.FetchDatabaseSchema(db)
+		tlsConfig, err := req.TLS.toModel(db.TLS)
 		if err != nil {
-			// Log the error but don't fail the request
-			log.Printf("Failed to fetch schema: %v", err)
-			// Initialize with empty schema
-			db.Schema = &models.Schema{Tables: []models.Table{}}
-		} else {
-			log.Printf("Schema fetched successfully with %d tables", len(schema.Tables))
-			db.Schema = schema
-		}
-
-		// Fetch stats
-		log.Printf("Fetching stats for database %s...", db.Name)
-		stats, err := models.FetchDatabaseStats(db)
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid TLS configuration: " + err.Error(),
+			})
+		}
+		db.TLS = tlsConfig
+
+		sshTunnel, err := req.SSHTunnel.toModel(db.SSHTunnel)
 		if err != nil {
-			// Log the error but don't fail the request
-			log.Printf("Failed to fetch stats: %v", err)
-		} else {
-			db.Stats = stats
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid SSH tunnel: " + err.Error(),
+			})
 		}
+		db.SSHTunnel = sshTunnel
 
-		// Update last connected time
+		// Pin the ssh host key and test connection
+		if err := models.PinSSHHostKey(ctx, db); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Failed to connect to SSH tunnel: " + err.Error(),
+			})
+		}
+		if err := models.TestConnection(ctx, db); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Failed to connect to database: " + err.Error(),
+			})
+		}
+
+		// Record what the credentials may do, warning when it is more than reading
+		checkPrivileges(ctx, db)
+
+		// Save the database and refresh its schema in the background
 		now := time.Now()
 		db.LastConnected = &now
-
-		// Save database
-		log.Printf("Saving updated database schema with %d tables...", len(db.Schema.Tables))
-		if err := models.UpdateDatabase(context.Background(), db); err != nil {
+		if err := store.UpdateDatabase(ctx, db); err != nil {
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 				"error": "Failed to update database: " + err.Error(),
 			})
 		}
-		log.Printf("Database schema updated successfully")
+		if err := store.MarkSchemaRefreshPending(ctx, db.ID); err != nil {
+			log.Printf("Failed to mark schema refresh pending for database %s: %v", db.ID.Hex(), err)
+		}
+		if db.SchemaRefresh == nil {
+			db.SchemaRefresh = &models.SchemaRefreshStatus{}
+		}
+		db.SchemaRefresh.Status = models.SchemaRefreshPending
+		db.SchemaRefresh.Error = ""
+		queueSchemaRefresh(refresher, db)
 
 		// Return response
 		return c.JSON(db)
@@ -356,7 +553,7 @@ func UpdateDatabaseHandler() fiber.Handler {
 }
 
 // DeleteDatabaseHandler handles deleting a database
-func DeleteDatabaseHandler() fiber.Handler {
+func DeleteDatabaseHandler(store models.Store) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		// Get user ID from context
 		userID := c.Locals("user_id").(primitive.ObjectID)
@@ -369,12 +566,11 @@ func DeleteDatabaseHandler() fiber.Handler {
 			})
 		}
 
-		// Create context with timeout
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
+		// Get the request context
+		ctx := c.UserContext()
 
 		// Get database
-		db, err := models.GetDatabaseByID(ctx, databaseID)
+		db, err := store.GetDatabaseByID(ctx, databaseID)
 		if err != nil {
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 				"error": "Failed to retrieve database: " + err.Error(),
@@ -388,15 +584,35 @@ func DeleteDatabaseHandler() fiber.Handler {
 			})
 		}
 
-		// Check if database belongs to user
-		if db.UserID != userID {
+		// Only the owner can delete the connection
+		access, err := store.ResolveDatabaseAccess(ctx, db, userID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to check database access: " + err.Error(),
+			})
+		}
+		if !access.CanManage() {
 			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
 				"error": "You do not have permission to delete this database",
 			})
 		}
 
+		// Find what the connection powers, refusing to break it unless forced
+		impact, err := store.GetDatabaseImpact(ctx, databaseID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to check what uses this database: " + err.Error(),
+			})
+		}
+		if !impact.Empty() && !c.QueryBool("force") {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error":      "This connection powers " + impact.Summary() + ", which will stop working when it is deleted. Pass force=true to delete it anyway",
+				"dependents": impact,
+			})
+		}
+
 		// Delete database
-		if err := models.DeleteDatabase(ctx, databaseID); err != nil {
+		if err := store.DeleteDatabase(ctx, databaseID); err != nil {
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 				"error": "Failed to delete database: " + err.Error(),
 			})
@@ -404,7 +620,8 @@ func DeleteDatabaseHandler() fiber.Handler {
 
 		// Return response
 		return c.JSON(fiber.Map{
-			"message": "Database deleted successfully",
+			"message":    "Database deleted successfully",
+			"dependents": impact,
 		})
 	}
 }
@@ -412,12 +629,10 @@ func DeleteDatabaseHandler() fiber.Handler {
 // TestConnectionHandler handles testing a database connection
 func TestConnectionHandler() fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		// Parse request body
+		// Parse and validate request body, the name is only needed to save the connection
 		var req DatabaseRequest
-		if err := c.BodyParser(&req); err != nil {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": "Invalid request body",
-			})
+		if invalid := parseRequest(c, &req, "Name"); invalid != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(invalid)
 		}
 
 		// Create database object
@@ -431,28 +646,71 @@ func TestConnectionHandler() fiber.Handler {
 			DatabaseName:  req.DatabaseName,
 			SSL:           req.SSL,
 			ConnectionURI: req.ConnectionURI,
+			ReadReplicas:  req.ReadReplicas,
+		}
+		if err := models.ValidateReadReplicas(req.Type, req.ReadReplicas); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+
+		tlsConfig, err := req.TLS.toModel(nil)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid TLS configuration: " + err.Error(),
+			})
 		}
+		db.TLS = tlsConfig
 
-		// Test connection
-		if err := models.TestConnection(db); err != nil {
+		sshTunnel, err := req.SSHTunnel.toModel(nil)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid SSH tunnel: " + err.Error(),
+			})
+		}
+		db.SSHTunnel = sshTunnel
+
+		// Check the ssh tunnel before the database behind it, pinning the
+		// host key it would be saved with
+		ctx := c.UserContext()
+		if err := models.PinSSHHostKey(ctx, db); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Failed to connect to SSH tunnel: " + err.Error(),
+			})
+		}
+		tunnelHealth := models.CheckSSHTunnel(ctx, db)
+		if tunnelHealth != nil && tunnelHealth.Status != "ok" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error":      "Failed to connect to SSH tunnel: " + tunnelHealth.Error,
+				"ssh_tunnel": tunnelHealth,
+			})
+		}
+
+		// Test connection, collecting diagnostics along the way
+		diagnostics, err := models.DiagnoseConnection(ctx, db)
+		if err != nil {
 			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 				"error": "Failed to connect to database: " + err.Error(),
 			})
 		}
 
-		// Try to fetch schema and stats for more comprehensive testing
 		response := fiber.Map{
-			"message": "Connection successful",
+			"diagnostics": diagnostics,
+		}
+		if tunnelHealth != nil {
+			response["ssh_tunnel"] = tunnelHealth
+		}
+		if err := diagnostics.Err(); err != nil {
+			response["error"] = "Failed to connect to database: " + err.Error()
+			return c.Status(fiber.StatusBadRequest).JSON(response)
 		}
 
-		// Create a new context with a longer timeout for schema fetching
-		// We don't use the context directly here, but we create it to ensure the operation has enough time
-		_, schemaCancel := context.WithTimeout(context.Background(), 180*time.Second)
-		defer schemaCancel()
+		// Try to fetch schema and stats for more comprehensive testing
+		response["message"] = "Connection successful"
 
 		// Fetch schema (but don't fail if it doesn't work)
 		log.Printf("Testing schema fetch for database %s...", db.Name)
-		schema, err := models.FetchDatabaseSchema(db)
+		schema, err := models.FetchDatabaseSchema(ctx, db)
 		if err == nil && schema != nil {
 			log.Printf("Schema test successful, found %d tables", len(schema.Tables))
 			response["table_count"] = len(schema.Tables)
@@ -462,7 +720,7 @@ func TestConnectionHandler() fiber.Handler {
 
 		// Fetch stats (but don't fail if it doesn't work)
 		log.Printf("Testing stats fetch for database %s...", db.Name)
-		stats, err := models.FetchDatabaseStats(db)
+		stats, err := models.FetchDatabaseStats(ctx, db)
 		if err == nil && stats != nil {
 			response["database_size"] = stats.Size
 		} else if err != nil {
@@ -473,3 +731,94 @@ func TestConnectionHandler() fiber.Handler {
 		return c.JSON(response)
 	}
 }
+
+// TestSavedConnectionHandler handles re-testing a saved database connection,
+// pinning the ssh host key of a tunnel saved before keys were pinned
+func TestSavedConnectionHandler(store models.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from context
+		userID := c.Locals("user_id").(primitive.ObjectID)
+
+		// Get database ID from params
+		databaseID, err := primitive.ObjectIDFromHex(c.Params("id"))
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid database ID",
+			})
+		}
+
+		// Get database
+		ctx := c.UserContext()
+		db, err := store.GetDatabaseByID(ctx, databaseID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve database: " + err.Error(),
+			})
+		}
+		if db == nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Database not found",
+			})
+		}
+
+		// Only the owner decides which host key to trust
+		access, err := store.ResolveDatabaseAccess(ctx, db, userID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to check database access: " + err.Error(),
+			})
+		}
+		if !access.CanManage() {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "You do not have permission to test this database",
+			})
+		}
+
+		// Pin the ssh host key if it isn't yet, then test the connection
+		pinning := db.SSHTunnel != nil && db.SSHTunnel.HostKey == ""
+		if err := models.PinSSHHostKey(ctx, db); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Failed to connect to SSH tunnel: " + err.Error(),
+			})
+		}
+		if err := models.TestConnection(ctx, db); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Failed to connect to database: " + err.Error(),
+			})
+		}
+
+		// Save the host key now that the connection is known to work
+		if pinning {
+			if err := store.SetSSHHostKey(ctx, db.ID, db.SSHTunnel.HostKey); err != nil {
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+					"error": "Failed to save the ssh host key: " + err.Error(),
+				})
+			}
+		}
+
+		// Return response
+		response := fiber.Map{
+			"message": "Connection successful",
+		}
+		if db.SSHTunnel != nil {
+			response["host_key"] = db.SSHTunnel.HostKey
+		}
+		return c.JSON(response)
+	}
+}
+
+// queueSchemaRefresh queues a background schema refresh for a database
+func queueSchemaRefresh(refresher *workers.SchemaRefresher, db *models.Database) {
+	if !refresher.Refresh(db.ID) {
+		log.Printf("Schema refresh queue is full, skipping refresh of database %s", db.ID.Hex())
+	}
+}
+
+// hideConnectionDetails records the user's access on the database and removes
+// its credentials unless the user can manage it
+func hideConnectionDetails(db *models.Database, access models.DatabaseAccess) {
+	db.Access = access
+	if !access.CanManage() {
+		db.RedactCredentials()
+	}
+}
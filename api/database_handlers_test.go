@@ -0,0 +1,76 @@
+package api
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/zucced/goquery/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// fakeDatabaseStore serves one database. The methods it doesn't override
+// panic through the nil Store it embeds, so a handler reaching past the ones
+// a test expects fails loudly.
+type fakeDatabaseStore struct {
+	models.Store
+	db      *models.Database
+	access  models.DatabaseAccess
+	deleted []primitive.ObjectID
+}
+
+func (s *fakeDatabaseStore) GetDatabaseByID(ctx context.Context, id primitive.ObjectID) (*models.Database, error) {
+	if s.db == nil || s.db.ID != id {
+		return nil, nil
+	}
+	return s.db, nil
+}
+
+func (s *fakeDatabaseStore) ResolveDatabaseAccess(ctx context.Context, db *models.Database, userID primitive.ObjectID) (models.DatabaseAccess, error) {
+	return s.access, nil
+}
+
+func (s *fakeDatabaseStore) DeleteDatabase(ctx context.Context, id primitive.ObjectID) error {
+	s.deleted = append(s.deleted, id)
+	return nil
+}
+
+func TestDeleteDatabaseHandlerWithFakeStore(t *testing.T) {
+	db := &models.Database{ID: primitive.NewObjectID(), Name: "Analytics"}
+
+	tests := []struct {
+		name   string
+		id     string
+		access models.DatabaseAccess
+		want   int
+	}{
+		{"invalid id", "not-an-id", models.DatabaseAccessOwner, fiber.StatusBadRequest},
+		{"not found", primitive.NewObjectID().Hex(), models.DatabaseAccessOwner, fiber.StatusNotFound},
+		{"not the owner", db.ID.Hex(), models.DatabaseAccessQuery, fiber.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &fakeDatabaseStore{db: db, access: tt.access}
+
+			app := fiber.New()
+			app.Use(func(c *fiber.Ctx) error {
+				c.Locals("user_id", primitive.NewObjectID())
+				return c.Next()
+			})
+			app.Delete("/databases/:id", DeleteDatabaseHandler(store))
+
+			resp, err := app.Test(httptest.NewRequest("DELETE", "/databases/"+tt.id, nil))
+			if err != nil {
+				t.Fatalf("app.Test() error = %v", err)
+			}
+			if resp.StatusCode != tt.want {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.want)
+			}
+			if len(store.deleted) != 0 {
+				t.Errorf("deleted %v, want nothing deleted", store.deleted)
+			}
+		})
+	}
+}
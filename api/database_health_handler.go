@@ -0,0 +1,135 @@
+package api
+
+import (
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/zucced/goquery/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// defaultHealthWindow is how far back executions are summarized by default
+const defaultHealthWindow = 24 * time.Hour
+
+// connectionHealthUnknown is the status of connections the monitor hasn't
+// checked yet
+const connectionHealthUnknown = "unknown"
+
+// DatabaseHealth summarizes whether a connection is usable
+type DatabaseHealth struct {
+	ID              primitive.ObjectID     `json:"id"`
+	Name            string                 `json:"name"`
+	Type            string                 `json:"type"`
+	Environment     string                 `json:"environment,omitempty"`
+	Status          string                 `json:"status"` // From the connection monitor, unknown until it checked the connection
+	Usable          bool                   `json:"usable"`
+	LastConnectedAt *time.Time             `json:"last_connected_at,omitempty"` // Last time a connection succeeded
+	CheckedAt       *time.Time             `json:"checked_at,omitempty"`
+	LatencyMs       int64                  `json:"latency_ms,omitempty"` // Of the latest connection test
+	LastError       string                 `json:"last_error,omitempty"`
+	Executions      *models.ExecutionStats `json:"executions"`
+}
+
+// GetDatabasesHealthHandler handles reporting the health of all of the user's
+// connections: the connection monitor's latest status, the last successful
+// connection and how queries against them went within a window
+func GetDatabasesHealthHandler(store models.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from context
+		userID := c.Locals("user_id").(primitive.ObjectID)
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Parse the window executions are summarized over
+		window := defaultHealthWindow
+		if value := c.Query("window"); value != "" {
+			parsed, err := time.ParseDuration(value)
+			if err != nil || parsed <= 0 {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": "Invalid window, expected a duration such as 24h",
+				})
+			}
+			window = min(parsed, models.ExecutionMetricRetention)
+		}
+
+		// Get databases in the selected workspace
+		_, workspaceID := workspaceScope(c)
+		databases, err := store.GetDatabasesByUserID(ctx, userID, workspaceID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve databases: " + err.Error(),
+			})
+		}
+
+		// Summarize the executions against them
+		databaseIDs := make([]primitive.ObjectID, len(databases))
+		for i, db := range databases {
+			databaseIDs[i] = db.ID
+		}
+		stats, err := store.GetExecutionStats(ctx, databaseIDs, time.Now().Add(-window))
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve execution metrics: " + err.Error(),
+			})
+		}
+
+		health := make([]*DatabaseHealth, len(databases))
+		for i, db := range databases {
+			health[i] = databaseHealth(db, stats[db.ID])
+		}
+
+		// Return response
+		return c.JSON(fiber.Map{
+			"databases": health,
+			"window":    window.String(),
+		})
+	}
+}
+
+// databaseHealth combines a connection's monitor status with its execution
+// stats
+func databaseHealth(db *models.Database, stats *models.ExecutionStats) *DatabaseHealth {
+	if stats == nil {
+		stats = &models.ExecutionStats{}
+	}
+
+	health := &DatabaseHealth{
+		ID:              db.ID,
+		Name:            db.Name,
+		Type:            db.Type,
+		Environment:     db.Environment,
+		Status:          connectionHealthUnknown,
+		LastConnectedAt: db.LastConnected,
+		Executions:      stats,
+	}
+
+	if db.Health != nil {
+		checkedAt := db.Health.CheckedAt
+		health.Status = db.Health.Status
+		health.CheckedAt = &checkedAt
+		health.LatencyMs = db.Health.LatencyMs
+		health.LastError = db.Health.LastError
+		health.LastConnectedAt = latest(health.LastConnectedAt, db.Health.LastHealthyAt)
+	}
+	health.LastConnectedAt = latest(health.LastConnectedAt, stats.LastSucceededAt)
+
+	// Connections that haven't been checked yet are usable if queries
+	// against them have been succeeding
+	switch health.Status {
+	case models.ConnectionHealthy, models.ConnectionDegraded:
+		health.Usable = true
+	case connectionHealthUnknown:
+		health.Usable = stats.Executions == 0 || stats.Failures < stats.Executions
+	}
+
+	return health
+}
+
+// latest returns the later of two optional times
+func latest(a, b *time.Time) *time.Time {
+	if a == nil || (b != nil && b.After(*a)) {
+		return b
+	}
+	return a
+}
@@ -0,0 +1,293 @@
+package api
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/zucced/goquery/config"
+	"github.com/zucced/goquery/models"
+	"github.com/zucced/goquery/workers"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"gopkg.in/yaml.v3"
+)
+
+// DatabaseImportRequest represents the request body for importing database
+// connections, as JSON or YAML. Each connection is described like the body of
+// POST /api/databases.
+type DatabaseImportRequest struct {
+	Connections []DatabaseRequest `json:"connections" validate:"min=1,max=100"`
+	DryRun      bool              `json:"dry_run"` // Validate and test the connections without saving them
+}
+
+// Statuses of imported connections
+const (
+	ImportCreated = "created"
+	ImportValid   = "valid"   // Would be created, in a dry run
+	ImportSkipped = "skipped" // A connection with the same name already exists
+	ImportInvalid = "invalid"
+	ImportFailed  = "failed" // Couldn't connect or be saved
+)
+
+// DatabaseImportResult is the outcome of importing one connection
+type DatabaseImportResult struct {
+	Index          int                   `json:"index"`
+	Name           string                `json:"name"`
+	Status         string                `json:"status"`
+	DatabaseID     primitive.ObjectID    `json:"database_id,omitempty"`
+	PrivilegeLevel models.PrivilegeLevel `json:"privilege_level,omitempty"`
+	Warning        string                `json:"warning,omitempty"` // The credentials can do more than read
+	Error          string                `json:"error,omitempty"`
+	Fields         []FieldError          `json:"fields,omitempty"`
+}
+
+// importConcurrency caps how many connections of an import are tested at once
+const importConcurrency = 4
+
+// ImportDatabasesHandler handles creating many database connections at once,
+// e.g. from a file kept in version control. Every connection is validated and
+// tested, and the result of each is reported. Connections named like one the
+// user already owns are skipped, so importing the same file again only adds
+// the new ones.
+func ImportDatabasesHandler(store models.Store, cfg *config.Config, refresher *workers.SchemaRefresher) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from context
+		userID := c.Locals("user_id").(primitive.ObjectID)
+
+		// Parse and validate request body
+		var req DatabaseImportRequest
+		if err := parseImportBody(c, &req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid request body: " + err.Error(),
+			})
+		}
+		if invalid := validateRequest(&req); invalid != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(invalid)
+		}
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Only admins can reference the server's secrets
+		admin, err := isAdmin(ctx, store, cfg, userID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve user: " + err.Error(),
+			})
+		}
+
+		// Connections are matched to existing ones by name
+		orgID, workspaceID := workspaceScope(c)
+		existing, err := store.GetDatabasesByUserID(ctx, userID, workspaceID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve databases: " + err.Error(),
+			})
+		}
+		taken := make(map[string]bool, len(existing))
+		for _, db := range existing {
+			if db.UserID == userID {
+				taken[strings.ToLower(strings.TrimSpace(db.Name))] = true
+			}
+		}
+
+		// Validate every connection before testing any
+		results := make([]*DatabaseImportResult, len(req.Connections))
+		databases := make([]*models.Database, len(req.Connections))
+		listed := make(map[string]bool, len(req.Connections))
+		for i := range req.Connections {
+			entry := &req.Connections[i]
+			result := &DatabaseImportResult{Index: i, Name: entry.Name}
+			results[i] = result
+
+			if invalid := validateRequest(entry); invalid != nil {
+				result.Status, result.Error, result.Fields = ImportInvalid, invalid.Error, invalid.Fields
+				continue
+			}
+
+			name := strings.ToLower(strings.TrimSpace(entry.Name))
+			if listed[name] {
+				result.Status, result.Error = ImportInvalid, "Another connection in the import has this name"
+				continue
+			}
+			listed[name] = true
+			if taken[name] {
+				result.Status, result.Error = ImportSkipped, "A connection with this name already exists"
+				continue
+			}
+
+			// Validate and test with the secrets' values, but only store the
+			// references to them
+			resolved, referenced, err := resolveSecretReferences(entry)
+			if err != nil {
+				result.Status, result.Error = ImportInvalid, err.Error()
+				continue
+			}
+			if referenced && !admin {
+				result.Status, result.Error = ImportInvalid, "Only admins can reference server secrets"
+				continue
+			}
+
+			db, err := resolved.toModel(userID)
+			if err != nil {
+				result.Status, result.Error = ImportInvalid, err.Error()
+				continue
+			}
+			if referenced {
+				keepSecretReferences(db, entry)
+			}
+			db.OrgID, db.WorkspaceID = orgID, workspaceID
+			databases[i] = db
+		}
+
+		// Test the connections and their privileges a few at a time
+		var wg sync.WaitGroup
+		slots := make(chan struct{}, importConcurrency)
+		for i, db := range databases {
+			if db == nil {
+				continue
+			}
+			wg.Add(1)
+			go func(result *DatabaseImportResult, db *models.Database) {
+				defer wg.Done()
+				slots <- struct{}{}
+				defer func() { <-slots }()
+
+				if err := models.PinSSHHostKey(ctx, db); err != nil {
+					result.Status, result.Error = ImportFailed, "Failed to connect to SSH tunnel: "+err.Error()
+					return
+				}
+				if err := models.TestConnection(ctx, db); err != nil {
+					result.Status, result.Error = ImportFailed, "Failed to connect to database: "+err.Error()
+					return
+				}
+				checkPrivileges(ctx, db)
+				result.PrivilegeLevel, result.Warning = db.PrivilegeLevel, db.Privileges.Warning
+			}(results[i], db)
+		}
+		wg.Wait()
+
+		// Save the connections that work, in the order they were listed
+		counts := map[string]int{}
+		for i, db := range databases {
+			result := results[i]
+			if db != nil && result.Status == "" {
+				if req.DryRun {
+					result.Status = ImportValid
+				} else if created, err := saveNewDatabase(ctx, store, refresher, db); err != nil {
+					result.Status, result.Error = ImportFailed, "Failed to save database: "+err.Error()
+				} else {
+					result.Status, result.DatabaseID = ImportCreated, created.ID
+				}
+			}
+			counts[result.Status]++
+		}
+
+		// Return response
+		return c.JSON(fiber.Map{
+			"results": results,
+			"created": counts[ImportCreated],
+			"valid":   counts[ImportValid],
+			"skipped": counts[ImportSkipped],
+			"failed":  counts[ImportInvalid] + counts[ImportFailed],
+			"dry_run": req.DryRun,
+		})
+	}
+}
+
+// parseImportBody parses an import as YAML when the content type says so and
+// as JSON otherwise. YAML is read through its JSON form so both use the same
+// field names, with ports written as numbers turned into strings.
+func parseImportBody(c *fiber.Ctx, req *DatabaseImportRequest) error {
+	body := c.Body()
+	contentType := strings.ToLower(c.Get(fiber.HeaderContentType))
+	if !strings.Contains(contentType, "yaml") {
+		return json.Unmarshal(body, req)
+	}
+
+	var value interface{}
+	if err := yaml.Unmarshal(body, &value); err != nil {
+		return err
+	}
+	data, err := json.Marshal(stringifyPorts(value))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, req)
+}
+
+// stringifyPorts turns port numbers in a decoded YAML document into the
+// strings connections store them as
+func stringifyPorts(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, field := range v {
+			if port, ok := field.(int); ok && key == "port" {
+				v[key] = strconv.Itoa(port)
+				continue
+			}
+			v[key] = stringifyPorts(field)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = stringifyPorts(item)
+		}
+	}
+	return value
+}
+
+// secretFields returns the credentials of a request that can reference
+// secrets, in the order of the connection's SecretFields
+func (r *DatabaseRequest) secretFields() []*string {
+	fields := []*string{&r.Username, &r.Password, &r.ConnectionURI}
+	if r.TLS != nil {
+		fields = append(fields, &r.TLS.CACert, &r.TLS.ClientCert, &r.TLS.ClientKey)
+	}
+	if r.SSHTunnel != nil {
+		fields = append(fields, &r.SSHTunnel.Username, &r.SSHTunnel.Password, &r.SSHTunnel.PrivateKey, &r.SSHTunnel.Passphrase)
+	}
+	return fields
+}
+
+// resolveSecretReferences returns a copy of a request with the credentials
+// written as ${secret:NAME} replaced by the secrets' values, for validating
+// and testing it, and whether it referenced any
+func resolveSecretReferences(req *DatabaseRequest) (*DatabaseRequest, bool, error) {
+	resolved := *req
+	if req.TLS != nil {
+		tls := *req.TLS
+		resolved.TLS = &tls
+	}
+	if req.SSHTunnel != nil {
+		sshTunnel := *req.SSHTunnel
+		resolved.SSHTunnel = &sshTunnel
+	}
+
+	referenced := false
+	for _, field := range resolved.secretFields() {
+		if models.SecretReference(*field) == "" {
+			continue
+		}
+		value, err := models.ResolveSecret(*field)
+		if err != nil {
+			return nil, false, err
+		}
+		*field, referenced = value, true
+	}
+	return &resolved, referenced, nil
+}
+
+// keepSecretReferences puts the references of a request back in place of the
+// values they were resolved to, so the connection only stores the references
+// and resolves them when connecting
+func keepSecretReferences(db *models.Database, req *DatabaseRequest) {
+	fields := db.SecretFields()
+	for i, field := range req.secretFields() {
+		if i < len(fields) && models.SecretReference(*field) != "" {
+			*fields[i] = strings.TrimSpace(*field)
+		}
+	}
+	db.SecretReferences = true
+}
@@ -1,9 +1,7 @@
 package api
 
 import (
-	"context"
 	"strconv"
-	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/zucced/goquery/models"
@@ -11,7 +9,7 @@ import (
 )
 
 // GetDatabaseQueriesHandler handles retrieving queries for a specific database with pagination
-func GetDatabaseQueriesHandler() fiber.Handler {
+func GetDatabaseQueriesHandler(store models.Store) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		// Get user ID from context
 		userID := c.Locals("user_id").(primitive.ObjectID)
@@ -39,12 +37,11 @@ func GetDatabaseQueriesHandler() fiber.Handler {
 			limit = 10
 		}
 
-		// Create context with timeout
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
+		// Get the request context
+		ctx := c.UserContext()
 
-		// Get database to check ownership
-		db, err := models.GetDatabaseByID(ctx, databaseID)
+		// Get database to check access
+		db, err := store.GetDatabaseByID(ctx, databaseID)
 		if err != nil {
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 				"error": "Failed to retrieve database: " + err.Error(),
@@ -57,21 +54,34 @@ func GetDatabaseQueriesHandler() fiber.Handler {
 			})
 		}
 
-		// Check if database belongs to user
-		if db.UserID != userID {
+		// Check if user can access database
+		allowed, err := store.CanAccessDatabase(ctx, db, userID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to check database access: " + err.Error(),
+			})
+		}
+		if !allowed {
 			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
 				"error": "You don't have permission to access this database",
 			})
 		}
 
 		// Get queries for the database with pagination
-		queries, totalCount, err := models.GetQueriesByDatabaseID(ctx, databaseID, page, limit)
+		queries, totalCount, err := store.GetQueriesByDatabaseID(ctx, databaseID, userID, page, limit)
 		if err != nil {
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 				"error": "Failed to retrieve queries: " + err.Error(),
 			})
 		}
 
+		// Mask the results the user may not see
+		if err := maskQueries(c, store, queries); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to mask results: " + err.Error(),
+			})
+		}
+
 		// Calculate pagination metadata
 		totalPages := (totalCount + limit - 1) / limit // Ceiling division
 
@@ -0,0 +1,68 @@
+package api
+
+import (
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/zucced/goquery/config"
+	"github.com/zucced/goquery/demo"
+	"github.com/zucced/goquery/limiter"
+	"github.com/zucced/goquery/middleware"
+	"github.com/zucced/goquery/models"
+)
+
+// DemoResponse represents the response for starting a demo
+type DemoResponse struct {
+	Token     string           `json:"token"`
+	User      *models.User     `json:"user"`
+	Database  *models.Database `json:"database"`
+	ExpiresAt time.Time        `json:"expires_at"`
+}
+
+// StartDemoHandler handles starting an anonymous, read-only demo session bound
+// to the sample database
+func StartDemoHandler(store models.Store, cfg *config.Config, provisioner *demo.Provisioner, rateLimiter *limiter.RateLimiter) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Limit requests per client
+		if !rateLimiter.Allow("demo:" + c.IP()) {
+			return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+				"error": "Too many demo sessions, please try again later",
+			})
+		}
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Create demo user and sample database connection
+		user, db, err := provisioner.Provision(ctx)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to start demo: " + err.Error(),
+			})
+		}
+
+		// Start a read-only session that ends with the demo
+		session, err := store.CreateDemoSession(ctx, user.ID, c.Get(fiber.HeaderUserAgent), c.IP(), provisioner.Duration())
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to start demo: " + err.Error(),
+			})
+		}
+
+		// Generate JWT token
+		token, err := middleware.GenerateToken(user.ID, session.ID, cfg)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to generate token",
+			})
+		}
+
+		// Return response
+		return c.Status(fiber.StatusCreated).JSON(DemoResponse{
+			Token:     token,
+			User:      user,
+			Database:  db,
+			ExpiresAt: session.ExpiresAt,
+		})
+	}
+}
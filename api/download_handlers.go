@@ -0,0 +1,178 @@
+package api
+
+import (
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/zucced/goquery/config"
+	"github.com/zucced/goquery/export"
+	"github.com/zucced/goquery/middleware"
+	"github.com/zucced/goquery/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// defaultDownloadExpiry is how long download links last when no expiry is requested
+	defaultDownloadExpiry = 24 * time.Hour
+	// maxDownloadExpiry is the longest lifetime a download link can be issued for
+	maxDownloadExpiry = 7 * 24 * time.Hour
+)
+
+// DownloadLinkRequest represents the request body for issuing a download link
+type DownloadLinkRequest struct {
+	Format    string `json:"format,omitempty" validate:"omitempty,oneof=pdf png"`
+	ExpiresIn int    `json:"expires_in,omitempty"` // Seconds
+}
+
+// CreateSnapshotDownloadLinkHandler handles issuing a signed, single-use URL
+// that downloads a snapshot export without an Authorization header
+func CreateSnapshotDownloadLinkHandler(store models.Store, cfg *config.Config) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from context
+		userID := c.Locals("user_id").(primitive.ObjectID)
+
+		// Parse request body, which is optional
+		var req DownloadLinkRequest
+		if len(c.Body()) > 0 {
+			if invalid := parseRequest(c, &req); invalid != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(invalid)
+			}
+		}
+
+		// Validate expiry
+		expiry := defaultDownloadExpiry
+		if req.ExpiresIn != 0 {
+			expiry = time.Duration(req.ExpiresIn) * time.Second
+			if expiry <= 0 || expiry > maxDownloadExpiry {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": "Expiry must be between 1 second and 7 days",
+				})
+			}
+		}
+
+		format := export.FormatPDF
+		if req.Format != "" {
+			format = export.Format(req.Format)
+		}
+
+		snapshot, err := loadSnapshot(c, store)
+		if snapshot == nil {
+			return err
+		}
+
+		// Save the link, the token only carries its ID
+		link := &models.DownloadLink{
+			UserID:      userID,
+			Artifact:    models.DownloadArtifactSnapshot,
+			DashboardID: snapshot.DashboardID,
+			SnapshotID:  snapshot.ID,
+			Format:      string(format),
+			ExpiresAt:   time.Now().Add(expiry),
+		}
+		if err := store.CreateDownloadLink(c.UserContext(), link); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to create download link: " + err.Error(),
+			})
+		}
+
+		// Generate token
+		token, err := middleware.GenerateDownloadToken(userID, link.ID, link.ExpiresAt, cfg)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to generate download token: " + err.Error(),
+			})
+		}
+
+		// Return response
+		return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+			"url":        cfg.PublicURL + "/api/downloads/" + token,
+			"expires_at": link.ExpiresAt,
+		})
+	}
+}
+
+// DownloadHandler handles downloading an export with a signed download link.
+// It's public, the token in the path is checked instead of a session, and the
+// link stops working once the export is downloaded.
+func DownloadHandler(store models.Store, cfg *config.Config) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Links are single-use, so nothing on the way may keep the file
+		c.Set(fiber.HeaderCacheControl, "no-store")
+
+		// Verify the token
+		userID, linkID, err := middleware.ParseDownloadToken(c.Params("token"), cfg)
+		if err != nil {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "Invalid or expired download link",
+			})
+		}
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Get the link
+		link, err := store.GetDownloadLink(ctx, linkID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve download link: " + err.Error(),
+			})
+		}
+
+		if link == nil || link.UserID != userID {
+			return c.Status(fiber.StatusGone).JSON(fiber.Map{
+				"error": "This download link has expired or was already used",
+			})
+		}
+
+		// Get snapshot
+		snapshot, err := store.GetSnapshotByID(ctx, link.SnapshotID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve snapshot: " + err.Error(),
+			})
+		}
+
+		if snapshot == nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Snapshot not found",
+			})
+		}
+
+		// The user may have lost access since issuing the link
+		allowed, err := canViewSnapshot(ctx, store, snapshot, userID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve dashboard: " + err.Error(),
+			})
+		}
+
+		if !allowed {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "You no longer have permission to access this snapshot",
+			})
+		}
+
+		// Use up the link, only one of concurrent downloads gets the file
+		consumed, err := store.ConsumeDownloadLink(ctx, link.ID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to use download link: " + err.Error(),
+			})
+		}
+
+		if !consumed {
+			return c.Status(fiber.StatusGone).JSON(fiber.Map{
+				"error": "This download link has expired or was already used",
+			})
+		}
+
+		// Mask the results the user may not see
+		if err := store.NewResultMasker(userID).MaskSnapshot(ctx, snapshot); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to mask results: " + err.Error(),
+			})
+		}
+
+		return sendSnapshotExport(c, snapshot, export.Format(link.Format))
+	}
+}
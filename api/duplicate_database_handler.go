@@ -0,0 +1,184 @@
+package api
+
+import (
+	"errors"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/zucced/goquery/config"
+	"github.com/zucced/goquery/models"
+	"github.com/zucced/goquery/workers"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// DuplicateDatabaseRequest represents the settings that differ from the
+// database being duplicated. Blank fields keep the original's value, so a
+// blank password reuses the stored credentials.
+type DuplicateDatabaseRequest struct {
+	Name          string `json:"name" validate:"max=100"`
+	Host          string `json:"host"`
+	Port          string `json:"port" validate:"omitempty,port"`
+	Username      string `json:"username"`
+	Password      string `json:"password"`
+	DatabaseName  string `json:"database"`
+	ConnectionURI string `json:"connection_uri"`
+	Environment   string `json:"environment"`
+	SafetyLevel   string `json:"safety_level"` // Defaults by environment when the environment changes
+}
+
+// apply overrides the duplicated settings with the requested ones. A copy
+// connecting through a URI ignores the host, port and credentials, so
+// overriding those without a new URI is refused rather than silently dropped.
+func (r *DuplicateDatabaseRequest) apply(db *models.Database) error {
+	if db.ConnectionURI != "" && r.ConnectionURI == "" &&
+		(r.Host != "" || r.Port != "" || r.Username != "" || r.Password != "") {
+		return errors.New("this connection uses a connection URI, change connection_uri instead of the host, port or credentials")
+	}
+
+	if r.Name != "" {
+		db.Name = r.Name
+	} else {
+		db.Name += " (copy)"
+	}
+	if r.Host != "" {
+		db.Host = r.Host
+	}
+	if r.Port != "" {
+		db.Port = r.Port
+	}
+	if r.Username != "" {
+		db.Username = r.Username
+	}
+	if r.Password != "" {
+		db.Password = r.Password
+	}
+	if r.DatabaseName != "" {
+		db.DatabaseName = r.DatabaseName
+	}
+	if r.ConnectionURI != "" {
+		db.ConnectionURI = r.ConnectionURI
+	}
+	if r.Environment != "" {
+		db.Environment = r.Environment
+		db.SafetyLevel = models.DefaultSafetyLevel(r.Environment)
+	}
+	if r.SafetyLevel != "" {
+		db.SafetyLevel = r.SafetyLevel
+	}
+	return nil
+}
+
+// DuplicateDatabaseHandler handles copying a database connection, so variants
+// of the same database can be set up without retyping its settings
+func DuplicateDatabaseHandler(store models.Store, cfg *config.Config, refresher *workers.SchemaRefresher) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from context
+		userID := c.Locals("user_id").(primitive.ObjectID)
+
+		// Get database ID from params
+		databaseID, err := primitive.ObjectIDFromHex(c.Params("id"))
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid database ID",
+			})
+		}
+
+		// Parse request body, every field is optional
+		var req DuplicateDatabaseRequest
+		if len(c.Body()) > 0 {
+			if invalid := parseRequest(c, &req); invalid != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(invalid)
+			}
+		}
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Get database
+		db, err := store.GetDatabaseByID(ctx, databaseID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve database: " + err.Error(),
+			})
+		}
+
+		// Check if database exists
+		if db == nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Database not found",
+			})
+		}
+
+		// Only the owner may duplicate, since the copy reuses the credentials
+		access, err := store.ResolveDatabaseAccess(ctx, db, userID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to check database access: " + err.Error(),
+			})
+		}
+		if !access.CanManage() {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "You do not have permission to duplicate this database",
+			})
+		}
+
+		// A copy pointed elsewhere would send the secrets there
+		locked, err := secretsLocked(ctx, store, cfg, db, userID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve user: " + err.Error(),
+			})
+		}
+		if locked {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "Only admins can duplicate connections that reference server secrets",
+			})
+		}
+
+		duplicate := db.Duplicate()
+		if err := req.apply(duplicate); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+
+		// Validated once applied, since whether a safety level is allowed
+		// depends on the environment the copy ends up with
+		if err := models.ValidateEnvironment(duplicate.Environment, duplicate.SafetyLevel); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+
+		// Pin the ssh host key and test connection
+		if err := models.PinSSHHostKey(ctx, duplicate); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Failed to connect to SSH tunnel: " + err.Error(),
+			})
+		}
+		if err := models.TestConnection(ctx, duplicate); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Failed to connect to database: " + err.Error(),
+			})
+		}
+
+		// Record what the credentials may do, warning when it is more than reading
+		checkPrivileges(ctx, duplicate)
+
+		// Save the copy, its schema is fetched in the background
+		now := time.Now()
+		duplicate.LastConnected = &now
+		duplicate.Schema = &models.Schema{Tables: []models.Table{}}
+		duplicate.SchemaRefresh = &models.SchemaRefreshStatus{Status: models.SchemaRefreshPending}
+		createdDB, err := store.CreateDatabase(ctx, duplicate)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to save database: " + err.Error(),
+			})
+		}
+		queueSchemaRefresh(refresher, createdDB)
+
+		// Return response
+		return c.Status(fiber.StatusCreated).JSON(createdDB)
+	}
+}
@@ -0,0 +1,88 @@
+package api
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/zucced/goquery/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// DuplicateQueryRequest represents the optional request body for duplicating a query
+type DuplicateQueryRequest struct {
+	Name string `json:"name,omitempty" validate:"max=200"`
+}
+
+// DuplicateQueryHandler handles copying an existing query into a new one
+func DuplicateQueryHandler(store models.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from context
+		userID := c.Locals("user_id").(primitive.ObjectID)
+
+		// Get query ID from params
+		queryID, err := primitive.ObjectIDFromHex(c.Params("id"))
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid query ID",
+			})
+		}
+
+		// Parse optional request body
+		var req DuplicateQueryRequest
+		if len(c.Body()) > 0 {
+			if invalid := parseRequest(c, &req); invalid != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(invalid)
+			}
+		}
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Get the original query
+		original, err := store.GetQueryByID(ctx, queryID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve query: " + err.Error(),
+			})
+		}
+
+		if original == nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Query not found",
+			})
+		}
+
+		// Check if query belongs to user
+		if original.UserID != userID {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "You don't have permission to access this query",
+			})
+		}
+
+		// Copy the query definition, leaving results behind
+		name := req.Name
+		if name == "" {
+			name = original.Name + " (copy)"
+		}
+
+		duplicate := &models.Query{
+			UserID:       userID,
+			DatabaseID:   original.DatabaseID,
+			Sources:      append([]models.FederatedSource(nil), original.Sources...),
+			Name:         name,
+			NaturalQuery: original.NaturalQuery,
+			GeneratedSQL: original.GeneratedSQL,
+			Tags:         append([]string(nil), original.Tags...),
+		}
+		duplicate.OrgID, duplicate.WorkspaceID = workspaceScope(c)
+
+		// Save the new query
+		duplicate, err = store.CreateQuery(ctx, duplicate)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to duplicate query: " + err.Error(),
+			})
+		}
+
+		// Return response
+		return c.Status(fiber.StatusCreated).JSON(duplicate)
+	}
+}
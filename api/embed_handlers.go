@@ -0,0 +1,231 @@
+package api
+
+import (
+	"context"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/zucced/goquery/cache"
+	"github.com/zucced/goquery/config"
+	"github.com/zucced/goquery/limiter"
+	"github.com/zucced/goquery/middleware"
+	"github.com/zucced/goquery/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// defaultEmbedExpiry is how long embed tokens last when no expiry is requested
+	defaultEmbedExpiry = 24 * time.Hour
+	// maxEmbedExpiry is the longest lifetime an embed token can be issued for
+	maxEmbedExpiry = 365 * 24 * time.Hour
+)
+
+// EmbedTokenRequest represents the request body for issuing an embed token
+type EmbedTokenRequest struct {
+	CardID    string `json:"card_id,omitempty" validate:"omitempty,objectid"`
+	ExpiresIn int    `json:"expires_in,omitempty"` // Seconds
+}
+
+// CreateEmbedTokenHandler handles issuing an embed token for a dashboard or one of its cards
+func CreateEmbedTokenHandler(store models.Store, cfg *config.Config) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from context
+		userID := c.Locals("user_id").(primitive.ObjectID)
+
+		// Get dashboard ID from params
+		dashboardID, err := primitive.ObjectIDFromHex(c.Params("id"))
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid dashboard ID",
+			})
+		}
+
+		// Parse request body, which is optional
+		var req EmbedTokenRequest
+		if len(c.Body()) > 0 {
+			if invalid := parseRequest(c, &req); invalid != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(invalid)
+			}
+		}
+
+		// Validate expiry
+		expiry := defaultEmbedExpiry
+		if req.ExpiresIn != 0 {
+			expiry = time.Duration(req.ExpiresIn) * time.Second
+			if expiry <= 0 || expiry > maxEmbedExpiry {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": "Expiry must be between 1 second and 365 days",
+				})
+			}
+		}
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Get dashboard
+		dashboard, err := store.GetDashboardByID(ctx, dashboardID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve dashboard: " + err.Error(),
+			})
+		}
+
+		// Check if dashboard exists
+		if dashboard == nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Dashboard not found",
+			})
+		}
+
+		// Check if dashboard belongs to user
+		if dashboard.UserID != userID {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "You don't have permission to embed this dashboard",
+			})
+		}
+
+		// Check the card when embedding a single card
+		cardID := primitive.NilObjectID
+		if req.CardID != "" {
+			cardID, err = primitive.ObjectIDFromHex(req.CardID)
+			if err != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": "Invalid card ID",
+				})
+			}
+
+			if findCard(dashboard, cardID) == nil {
+				return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+					"error": "Card not found in dashboard",
+				})
+			}
+		}
+
+		// Generate token
+		token, expiresAt, err := middleware.GenerateEmbedToken(userID, dashboardID, cardID, expiry, cfg)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to generate embed token: " + err.Error(),
+			})
+		}
+
+		// Return response
+		return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+			"token":      token,
+			"expires_at": expiresAt,
+		})
+	}
+}
+
+// GetEmbeddedDashboardHandler handles retrieving the dashboard layout behind an embed token
+func GetEmbeddedDashboardHandler(store models.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		scope := c.Locals("embed_scope").(*middleware.EmbedScope)
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		dashboard, err := loadEmbeddedDashboard(ctx, store, c, scope)
+		if dashboard == nil {
+			return err
+		}
+
+		// Only expose the cards the token grants access to
+		cards := []models.DashboardCard{}
+		for _, card := range dashboard.Cards {
+			if scope.AllowsCard(card.ID) {
+				cards = append(cards, card)
+			}
+		}
+
+		// Return response
+		return c.JSON(fiber.Map{
+			"id":          dashboard.ID,
+			"name":        dashboard.Name,
+			"description": dashboard.Description,
+			"cards":       cards,
+			"variables":   dashboard.Variables,
+		})
+	}
+}
+
+// GetEmbeddedCardDataHandler handles retrieving card data through an embed token
+func GetEmbeddedCardDataHandler(store models.Store, execLimiter *limiter.ExecutionLimiter, resultCache cache.Cache) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		scope := c.Locals("embed_scope").(*middleware.EmbedScope)
+
+		// Get card ID from params
+		cardID, err := primitive.ObjectIDFromHex(c.Params("cardId"))
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid card ID",
+			})
+		}
+
+		// Check the token covers this card
+		if !scope.AllowsCard(cardID) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "This embed token doesn't grant access to this card",
+			})
+		}
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		dashboard, err := loadEmbeddedDashboard(ctx, store, c, scope)
+		if dashboard == nil {
+			return err
+		}
+
+		// Bucket chart dates in the requested timezone, embeds have no user to fall back to
+		location := time.UTC
+		if tz := c.Query("tz"); tz != "" {
+			location, err = chartLocation(ctx, store, tz, primitive.NilObjectID)
+			if err != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": err.Error(),
+				})
+			}
+		}
+
+		return serveCardData(ctx, store, c, execLimiter, resultCache, dashboard, cardID, location)
+	}
+}
+
+// loadEmbeddedDashboard retrieves the dashboard an embed token is scoped to.
+// When the dashboard is nil the returned error is the response already written.
+func loadEmbeddedDashboard(ctx context.Context, store models.Store, c *fiber.Ctx, scope *middleware.EmbedScope) (*models.Dashboard, error) {
+	// Get dashboard
+	dashboard, err := store.GetDashboardByID(ctx, scope.DashboardID)
+	if err != nil {
+		return nil, c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to retrieve dashboard: " + err.Error(),
+		})
+	}
+
+	// Tokens stop working once the dashboard is deleted or changes owner
+	if dashboard == nil || dashboard.UserID != scope.UserID {
+		return nil, c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Dashboard not found",
+		})
+	}
+
+	// The embedded card must still exist
+	if !scope.CardID.IsZero() && findCard(dashboard, scope.CardID) == nil {
+		return nil, c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Card not found in dashboard",
+		})
+	}
+
+	return dashboard, nil
+}
+
+// findCard returns the card with the given ID on a dashboard
+func findCard(dashboard *models.Dashboard, cardID primitive.ObjectID) *models.DashboardCard {
+	for i := range dashboard.Cards {
+		if dashboard.Cards[i].ID == cardID {
+			return &dashboard.Cards[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/zucced/goquery/models"
+	"github.com/zucced/goquery/realtime"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// eventsHeartbeatInterval is how often idle event streams get a comment,
+	// which keeps proxies from closing them
+	eventsHeartbeatInterval = 15 * time.Second
+	// eventsRetry is how long browsers wait before reconnecting, in milliseconds
+	eventsRetry = 3000
+)
+
+// defaultEventChannels are streamed when a client doesn't choose channels
+var defaultEventChannels = []string{realtime.ChannelQueries, realtime.ChannelNotifications}
+
+// EventsHandler handles the Server-Sent Events stream of the gateway's
+// channels, for clients that can't open WebSockets. Reconnecting clients
+// send Last-Event-ID and receive the messages they missed.
+func EventsHandler(store models.Store, gateway *realtime.Gateway) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from context
+		userID := c.Locals("user_id").(primitive.ObjectID)
+
+		// Parse the channels to stream
+		channels := defaultEventChannels
+		if c.Query("channels") != "" {
+			channels = nil
+			for _, channel := range strings.Split(c.Query("channels"), ",") {
+				if channel = strings.TrimSpace(channel); channel != "" {
+					channels = append(channels, channel)
+				}
+			}
+		}
+
+		// Check if user can see every channel
+		for _, channel := range channels {
+			if err := authorizeChannel(store, userID, channel); err != "" {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": channel + ": " + err,
+				})
+			}
+		}
+
+		// Browsers send the last ID they received when reconnecting
+		lastEventID := c.Get("Last-Event-ID", c.Query("last_event_id"))
+		var lastID uint64
+		if lastEventID != "" {
+			var err error
+			lastID, err = strconv.ParseUint(lastEventID, 10, 64)
+			if err != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": "Invalid Last-Event-ID",
+				})
+			}
+		}
+
+		// Limit the connections of each user
+		if gateway.Connections(userID) >= maxGatewayConnections {
+			return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+				"error": "Too many open connections",
+			})
+		}
+
+		client := gateway.Connect(userID)
+		if lastEventID != "" {
+			gateway.Resume(client, channels, lastID)
+		} else {
+			for _, channel := range channels {
+				gateway.Subscribe(client, channel)
+			}
+		}
+
+		c.Set(fiber.HeaderContentType, "text/event-stream")
+		c.Set(fiber.HeaderCacheControl, "no-cache")
+		c.Set(fiber.HeaderConnection, "keep-alive")
+		// Keep nginx from buffering the stream
+		c.Set("X-Accel-Buffering", "no")
+
+		c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+			defer gateway.Disconnect(client)
+			writeEvents(w, client)
+		})
+		return nil
+	}
+}
+
+// writeEvents writes a connection's messages as Server-Sent Events, with a
+// heartbeat while idle, until the gateway drops it or the client goes away
+func writeEvents(w *bufio.Writer, client *realtime.Client) {
+	ticker := time.NewTicker(eventsHeartbeatInterval)
+	defer ticker.Stop()
+
+	// Tell the client how long to wait before reconnecting, and which
+	// connection ID to send with its own changes
+	fmt.Fprintf(w, "retry: %d\n\n", eventsRetry)
+	connected := realtime.Message{Type: "connected", Data: fiber.Map{"connection_id": client.ID}, At: time.Now()}
+	if writeEvent(w, connected) != nil {
+		return
+	}
+
+	for {
+		select {
+		case msg, ok := <-client.Messages:
+			if !ok {
+				return
+			}
+			if writeEvent(w, msg) != nil {
+				return
+			}
+		case <-ticker.C:
+			// Comments are ignored by clients, and failing to write one
+			// detects that the client went away
+			w.WriteString(": heartbeat\n\n")
+			if w.Flush() != nil {
+				return
+			}
+		}
+	}
+}
+
+// writeEvent writes a message as a single event and flushes it. The ID lets
+// the client resume after it.
+func writeEvent(w *bufio.Writer, msg realtime.Message) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	if msg.ID != 0 {
+		fmt.Fprintf(w, "id: %d\n", msg.ID)
+	}
+	fmt.Fprintf(w, "data: %s\n\n", data)
+	return w.Flush()
+}
@@ -0,0 +1,139 @@
+package api
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/zucced/goquery/features"
+	"github.com/zucced/goquery/middleware"
+	"github.com/zucced/goquery/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// FeatureFlagRequest represents the request body for setting a feature flag.
+// Without enabled the flag falls back to the server's configuration.
+type FeatureFlagRequest struct {
+	Enabled   *bool                    `json:"enabled"`
+	Overrides []FeatureOverrideRequest `json:"overrides" validate:"max=500,dive"`
+}
+
+// FeatureOverrideRequest turns a feature on or off for one organization
+type FeatureOverrideRequest struct {
+	OrgID   string `json:"org_id" validate:"required,objectid"`
+	Enabled bool   `json:"enabled"`
+}
+
+// FeatureFlagResponse describes a feature flag and where its value comes from
+type FeatureFlagResponse struct {
+	features.Flag
+	// Configured is the value the flag has without a stored value
+	Configured bool                `json:"configured"`
+	Stored     *models.FeatureFlag `json:"stored,omitempty"`
+}
+
+// GetFeaturesHandler handles listing the features that are on for the current
+// user, in the organization of the selected workspace if any
+func GetFeaturesHandler(flags *features.Service) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var orgID primitive.ObjectID
+		if workspace := middleware.CurrentWorkspace(c); workspace != nil {
+			orgID = workspace.OrgID
+		}
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Return response
+		return c.JSON(fiber.Map{
+			"features": flags.EnabledFlags(ctx, orgID),
+		})
+	}
+}
+
+// GetFeatureFlagsHandler handles listing every feature flag with its stored
+// value and overrides
+func GetFeatureFlagsHandler(store models.Store, flags *features.Service) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Get stored flags
+		stored, err := store.GetFeatureFlags(ctx)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve feature flags: " + err.Error(),
+			})
+		}
+
+		byKey := make(map[string]*models.FeatureFlag, len(stored))
+		for _, flag := range stored {
+			byKey[flag.Key] = flag
+		}
+
+		response := make([]FeatureFlagResponse, 0, len(features.Flags))
+		for _, flag := range features.Flags {
+			response = append(response, FeatureFlagResponse{
+				Flag:       flag,
+				Configured: flags.Default(flag.Key),
+				Stored:     byKey[flag.Key],
+			})
+		}
+
+		// Return response
+		return c.JSON(fiber.Map{
+			"flags": response,
+		})
+	}
+}
+
+// UpdateFeatureFlagHandler handles setting a feature flag for every
+// organization and overriding it for single ones
+func UpdateFeatureFlagHandler(flags *features.Service) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from context
+		userID := c.Locals("user_id").(primitive.ObjectID)
+
+		// Check if the flag exists
+		key := c.Params("key")
+		if _, ok := features.Lookup(key); !ok {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Feature flag not found",
+			})
+		}
+
+		// Parse request
+		var req FeatureFlagRequest
+		if invalid := parseRequest(c, &req); invalid != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(invalid)
+		}
+
+		flag := &models.FeatureFlag{
+			Key:       key,
+			Enabled:   req.Enabled,
+			Overrides: make([]models.FeatureFlagOverride, 0, len(req.Overrides)),
+			UpdatedBy: userID,
+		}
+		seen := make(map[primitive.ObjectID]bool, len(req.Overrides))
+		for _, override := range req.Overrides {
+			orgID, _ := primitive.ObjectIDFromHex(override.OrgID)
+			if seen[orgID] {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": "Organization " + override.OrgID + " is overridden more than once",
+				})
+			}
+			seen[orgID] = true
+			flag.Overrides = append(flag.Overrides, models.FeatureFlagOverride{OrgID: orgID, Enabled: override.Enabled})
+		}
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Save flag
+		if err := flags.Save(ctx, flag); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to update feature flag: " + err.Error(),
+			})
+		}
+
+		// Return response
+		return c.JSON(flag)
+	}
+}
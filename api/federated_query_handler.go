@@ -0,0 +1,237 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/zucced/goquery/ai"
+	"github.com/zucced/goquery/alerts"
+	"github.com/zucced/goquery/config"
+	"github.com/zucced/goquery/limiter"
+	"github.com/zucced/goquery/models"
+	"github.com/zucced/goquery/realtime"
+	"github.com/zucced/goquery/webhooks"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// FederatedQueryRequest represents the request body for asking a question
+// across the results of saved queries, which may come from different
+// databases
+type FederatedQueryRequest struct {
+	Query   string                   `json:"query" validate:"notblank"`
+	Name    string                   `json:"name,omitempty" validate:"max=200"`
+	Tags    []string                 `json:"tags,omitempty"`
+	Sources []FederatedSourceRequest `json:"sources" validate:"min=2,max=5,dive"`
+}
+
+// FederatedSourceRequest names a saved query whose results are read as a
+// table. The table is named after the query unless a name is given.
+type FederatedSourceRequest struct {
+	QueryID string `json:"query_id" validate:"required,objectid"`
+	Table   string `json:"table,omitempty" validate:"max=63"`
+}
+
+// CreateFederatedQueryHandler handles creating a federated query: the stored
+// results of its sources are loaded into an embedded DuckDB instance and the
+// AI generates a query joining them
+func CreateFederatedQueryHandler(store models.Store, cfg *config.Config, execLimiter *limiter.ExecutionLimiter, gateway *realtime.Gateway, hooks *webhooks.Dispatcher) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from context
+		userID := c.Locals("user_id").(primitive.ObjectID)
+
+		// Parse and validate request body
+		var req FederatedQueryRequest
+		if invalid := parseRequest(c, &req); invalid != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(invalid)
+		}
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Explicit table names are claimed first so derived ones don't take them
+		taken := make(map[string]bool, len(req.Sources))
+		for _, source := range req.Sources {
+			table := strings.TrimSpace(source.Table)
+			if table == "" {
+				continue
+			}
+			if err := models.ValidateFederatedTable(table); err != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": err.Error(),
+				})
+			}
+			if taken[table] {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": fmt.Sprintf("Table name %q is used by more than one source", table),
+				})
+			}
+			taken[table] = true
+		}
+
+		// Load every source and check the user can read its results, which
+		// are read as the user may see them
+		sources := make([]models.FederatedSource, len(req.Sources))
+		tables := make([]models.FederatedTable, len(req.Sources))
+		masker := store.NewResultMasker(userID)
+		for i, sourceReq := range req.Sources {
+			queryID, _ := primitive.ObjectIDFromHex(sourceReq.QueryID)
+			source, err := store.GetQueryByID(ctx, queryID)
+			if err != nil {
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+					"error": "Failed to retrieve query: " + err.Error(),
+				})
+			}
+
+			if source == nil {
+				return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+					"error": fmt.Sprintf("Source query %s not found", sourceReq.QueryID),
+				})
+			}
+
+			allowed, err := store.CanAccessQuery(ctx, source, userID)
+			if err != nil {
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+					"error": "Failed to check query access: " + err.Error(),
+				})
+			}
+			if !allowed {
+				return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+					"error": fmt.Sprintf("You don't have permission to access source query %s", sourceReq.QueryID),
+				})
+			}
+
+			if source.Status != models.QueryStatusCompleted {
+				return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+					"error": fmt.Sprintf("Source query %s has no results, run it first", sourceReq.QueryID),
+				})
+			}
+
+			table := strings.TrimSpace(sourceReq.Table)
+			if table == "" {
+				table = models.FederatedTableName(source.Name, i, taken)
+			}
+			rows, err := masker.Mask(ctx, source, source.Results)
+			if err != nil {
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+					"error": "Failed to mask results: " + err.Error(),
+				})
+			}
+			sources[i] = models.FederatedSource{QueryID: source.ID, Table: table}
+			tables[i] = models.NewFederatedTable(table, source.Columns, rows)
+		}
+
+		// Create query with initial values
+		query := &models.Query{
+			UserID:       userID,
+			Sources:      sources,
+			NaturalQuery: req.Query,
+			Status:       models.QueryStatusRunning,
+			Tags:         models.NormalizeTags(req.Tags),
+		}
+		query.OrgID, query.WorkspaceID = workspaceScope(c)
+
+		query.Name = req.Name
+		if query.Name == "" {
+			query.Name = "Query"
+		}
+
+		// Save query to database
+		query, err := store.CreateQuery(ctx, query)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to create query: " + err.Error(),
+			})
+		}
+		defer models.TrackRunningQuery(query.ID)()
+		publishQueryStatus(gateway, query)
+
+		// Generate a query over the loaded tables
+		generatedQuery, err := ai.GenerateFederatedSQL(ctx, req.Query, tables, cfg)
+		if err != nil {
+			// Update query with error
+			query.Status = models.QueryStatusFailed
+			query.Error = "Failed to generate query: " + err.Error()
+			saveQueryStatus(ctx, store, gateway, hooks, query)
+
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": query.Error,
+				"query": query,
+			})
+		}
+		query.GeneratedSQL = generatedQuery
+
+		return runFederatedQuery(c, ctx, store, execLimiter, gateway, hooks, query, tables)
+	}
+}
+
+// rerunFederatedQuery runs a federated query again over the current results
+// of its sources
+func rerunFederatedQuery(c *fiber.Ctx, ctx context.Context, store models.Store, execLimiter *limiter.ExecutionLimiter, gateway *realtime.Gateway, hooks *webhooks.Dispatcher, query *models.Query) error {
+	tables, err := store.LoadFederatedTables(ctx, query.Sources, query.UserID)
+	if err != nil {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": "Failed to load the source queries: " + err.Error(),
+		})
+	}
+
+	// Update query status
+	query.Status = models.QueryStatusRunning
+	query.UpdatedAt = time.Now()
+	query.Error = "" // Clear any previous errors
+	if err := saveQueryStatus(ctx, store, gateway, hooks, query); err != nil {
+		fmt.Printf("Failed to update query status to running: %v\n", err)
+		// Continue anyway
+	}
+	defer models.TrackRunningQuery(query.ID)()
+
+	return runFederatedQuery(c, ctx, store, execLimiter, gateway, hooks, query, tables)
+}
+
+// runFederatedQuery executes a federated query's generated SQL over its
+// loaded tables and saves the results
+func runFederatedQuery(c *fiber.Ctx, ctx context.Context, store models.Store, execLimiter *limiter.ExecutionLimiter, gateway *realtime.Gateway, hooks *webhooks.Dispatcher, query *models.Query, tables []models.FederatedTable) error {
+	// Federated queries all run in this process, so they share a slot per user
+	release, err := execLimiter.Acquire(ctx, query.UserID.Hex(), models.FederationLimiterKey)
+	if err != nil {
+		return rejectBusyQuery(c, ctx, store, gateway, hooks, query, err)
+	}
+	defer release()
+
+	fmt.Printf("[%s] Starting federated query execution\n", time.Now().Format(time.RFC3339))
+	executionStartTime := time.Now()
+	results, columns, executionTime, err := models.ExecuteFederatedQuery(ctx, tables, query.GeneratedSQL)
+	alerts.Evaluate(ctx, store, alerts.SourceManual, query.ID, results, err)
+	fmt.Printf("[%s] Federated query execution completed in %s\n", time.Now().Format(time.RFC3339), time.Since(executionStartTime))
+	if err != nil {
+		// Update query with error
+		query.Status = models.QueryStatusFailed
+		query.Error = "Failed to execute query: " + err.Error()
+		saveQueryStatus(ctx, store, gateway, hooks, query)
+
+		maskQuery(c, store, query)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": query.Error,
+			"query": query,
+		})
+	}
+
+	// Update query with results
+	query.Status = models.QueryStatusCompleted
+	query.Results = limitResults(ctx, store, query.UserID, results)
+	query.Columns = columns
+	query.ExecutionTime = executionTime
+	query.Error = "" // Clear any previous errors
+
+	// Save updated query
+	if err := saveQueryStatus(ctx, store, gateway, hooks, query); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to update query: " + err.Error(),
+		})
+	}
+
+	// Return response
+	return sendQuery(c, store, query)
+}
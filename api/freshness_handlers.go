@@ -0,0 +1,302 @@
+package api
+
+import (
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/zucced/goquery/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// FreshnessCheckRequest represents the request body for creating or updating
+// a freshness check
+type FreshnessCheckRequest struct {
+	Table      string   `json:"table" validate:"notblank,max=200"`
+	Column     string   `json:"column" validate:"notblank,max=200"`
+	MaxAge     int      `json:"max_age" validate:"min=60,max=2592000"`
+	Interval   int      `json:"interval" validate:"omitempty,min=60,max=86400"`
+	ChannelIDs []string `json:"channel_ids" validate:"max=20,dive,objectid"`
+	Enabled    *bool    `json:"enabled"`
+}
+
+// validate normalizes a freshness check request
+func (req *FreshnessCheckRequest) validate() {
+	req.Table = strings.TrimSpace(req.Table)
+	req.Column = strings.TrimSpace(req.Column)
+	if req.Interval == 0 {
+		req.Interval = int(models.DefaultFreshnessInterval.Seconds())
+	}
+}
+
+// CreateFreshnessCheckHandler handles declaring how recent the data in a
+// table of a database is expected to be
+func CreateFreshnessCheckHandler(store models.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from context
+		userID := c.Locals("user_id").(primitive.ObjectID)
+
+		// Parse and validate request body
+		var req FreshnessCheckRequest
+		if invalid := parseRequest(c, &req); invalid != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(invalid)
+		}
+		req.validate()
+
+		db, err := loadFreshnessDatabase(c, store, true)
+		if db == nil {
+			return err
+		}
+
+		// Check the table can be probed
+		if err := db.ValidateFreshnessTarget(req.Table, req.Column); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Check the notification channels belong to the user
+		channelIDs, msg, err := ownedChannelIDs(ctx, store, userID, req.ChannelIDs)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve notification channels: " + err.Error(),
+			})
+		}
+		if msg != "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": msg,
+			})
+		}
+
+		enabled := true
+		if req.Enabled != nil {
+			enabled = *req.Enabled
+		}
+
+		// Create check
+		check, err := store.CreateFreshnessCheck(ctx, &models.FreshnessCheck{
+			DatabaseID: db.ID,
+			UserID:     userID,
+			Table:      req.Table,
+			Column:     req.Column,
+			MaxAge:     req.MaxAge,
+			Interval:   req.Interval,
+			ChannelIDs: channelIDs,
+			Enabled:    enabled,
+		})
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to create freshness check: " + err.Error(),
+			})
+		}
+
+		// Return response
+		return c.Status(fiber.StatusCreated).JSON(check)
+	}
+}
+
+// GetFreshnessChecksHandler handles listing the freshness checks of a
+// database with their latest state
+func GetFreshnessChecksHandler(store models.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		db, err := loadFreshnessDatabase(c, store, false)
+		if db == nil {
+			return err
+		}
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Get checks
+		checks, err := store.GetFreshnessChecksByDatabaseID(ctx, db.ID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve freshness checks: " + err.Error(),
+			})
+		}
+
+		// Return response
+		return c.JSON(fiber.Map{
+			"checks": checks,
+		})
+	}
+}
+
+// UpdateFreshnessCheckHandler handles updating a freshness check
+func UpdateFreshnessCheckHandler(store models.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Parse and validate request body
+		var req FreshnessCheckRequest
+		if invalid := parseRequest(c, &req); invalid != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(invalid)
+		}
+		req.validate()
+
+		db, err := loadFreshnessDatabase(c, store, true)
+		if db == nil {
+			return err
+		}
+
+		check, err := loadFreshnessCheck(c, store, db)
+		if check == nil {
+			return err
+		}
+
+		// Check the table can be probed
+		if err := db.ValidateFreshnessTarget(req.Table, req.Column); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Channels notify the check's owner, so they must be theirs
+		channelIDs, msg, err := ownedChannelIDs(ctx, store, check.UserID, req.ChannelIDs)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve notification channels: " + err.Error(),
+			})
+		}
+		if msg != "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": msg,
+			})
+		}
+
+		// Update fields
+		targetChanged := req.Table != check.Table || req.Column != check.Column || req.MaxAge != check.MaxAge
+		check.Table = req.Table
+		check.Column = req.Column
+		check.MaxAge = req.MaxAge
+		check.Interval = req.Interval
+		check.ChannelIDs = channelIDs
+		if req.Enabled != nil {
+			check.Enabled = *req.Enabled
+		}
+
+		// Save check
+		if err := store.UpdateFreshnessCheck(ctx, check, targetChanged); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to update freshness check: " + err.Error(),
+			})
+		}
+
+		// Return response
+		return c.JSON(check)
+	}
+}
+
+// DeleteFreshnessCheckHandler handles deleting a freshness check
+func DeleteFreshnessCheckHandler(store models.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		db, err := loadFreshnessDatabase(c, store, true)
+		if db == nil {
+			return err
+		}
+
+		check, err := loadFreshnessCheck(c, store, db)
+		if check == nil {
+			return err
+		}
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Delete check
+		if err := store.DeleteFreshnessCheck(ctx, check.ID); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to delete freshness check: " + err.Error(),
+			})
+		}
+
+		// Return response
+		return c.JSON(fiber.Map{
+			"message": "Freshness check deleted successfully",
+		})
+	}
+}
+
+// loadFreshnessDatabase resolves the database in the request path and checks
+// the user can query it, or manage it when manage is set. When the database
+// is nil the returned error is the response already written.
+func loadFreshnessDatabase(c *fiber.Ctx, store models.Store, manage bool) (*models.Database, error) {
+	// Get user ID from context
+	userID := c.Locals("user_id").(primitive.ObjectID)
+
+	// Get database ID from params
+	databaseID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return nil, c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid database ID",
+		})
+	}
+
+	// Get the request context
+	ctx := c.UserContext()
+
+	// Get database
+	db, err := store.GetDatabaseByID(ctx, databaseID)
+	if err != nil {
+		return nil, c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to retrieve database: " + err.Error(),
+		})
+	}
+
+	if db == nil {
+		return nil, c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Database not found",
+		})
+	}
+
+	// Anyone who can query the database sees its checks, only the owner changes them
+	access, err := store.ResolveDatabaseAccess(ctx, db, userID)
+	if err != nil {
+		return nil, c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to check database access: " + err.Error(),
+		})
+	}
+	if !access.CanQuery() || (manage && !access.CanManage()) {
+		return nil, c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "You do not have permission to manage freshness checks on this database",
+		})
+	}
+
+	return db, nil
+}
+
+// loadFreshnessCheck resolves the freshness check in the request path and
+// checks it is on db. When the check is nil the returned error is the
+// response already written.
+func loadFreshnessCheck(c *fiber.Ctx, store models.Store, db *models.Database) (*models.FreshnessCheck, error) {
+	// Get check ID from params
+	checkID, err := primitive.ObjectIDFromHex(c.Params("checkId"))
+	if err != nil {
+		return nil, c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid freshness check ID",
+		})
+	}
+
+	// Get the request context
+	ctx := c.UserContext()
+
+	// Get check
+	check, err := store.GetFreshnessCheckByID(ctx, checkID)
+	if err != nil {
+		return nil, c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to retrieve freshness check: " + err.Error(),
+		})
+	}
+
+	// Check if check exists and is on the database
+	if check == nil || check.DatabaseID != db.ID {
+		return nil, c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Freshness check not found",
+		})
+	}
+
+	return check, nil
+}
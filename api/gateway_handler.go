@@ -0,0 +1,199 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/gofiber/contrib/websocket"
+	"github.com/gofiber/fiber/v2"
+	"github.com/zucced/goquery/models"
+	"github.com/zucced/goquery/realtime"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// maxGatewayConnections is the number of gateway connections a user may keep
+// open at once, e.g. across browser tabs
+const maxGatewayConnections = 10
+
+// GatewayCommand is sent by clients to choose the channels they receive
+type GatewayCommand struct {
+	Action  string `json:"action"`
+	Channel string `json:"channel"`
+}
+
+// GatewayHandler handles the WebSocket connection that multiplexes the
+// server-push channels of a user: their queries' status, dashboard changes,
+// schema refresh progress and notifications
+func GatewayHandler(store models.Store, gateway *realtime.Gateway) fiber.Handler {
+	upgrade := websocket.New(func(conn *websocket.Conn) {
+		serveGateway(store, gateway, conn)
+	})
+
+	return func(c *fiber.Ctx) error {
+		// Only accept WebSocket upgrades
+		if !websocket.IsWebSocketUpgrade(c) {
+			return c.Status(fiber.StatusUpgradeRequired).JSON(fiber.Map{
+				"error": "WebSocket upgrade required",
+			})
+		}
+
+		// Get user ID from context
+		userID := c.Locals("user_id").(primitive.ObjectID)
+
+		// Limit the connections of each user
+		if gateway.Connections(userID) >= maxGatewayConnections {
+			return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+				"error": "Too many open connections",
+			})
+		}
+
+		return upgrade(c)
+	}
+}
+
+// serveGateway registers a connection with the gateway and handles its
+// subscription commands until either side goes away
+func serveGateway(store models.Store, gateway *realtime.Gateway, conn *websocket.Conn) {
+	userID := conn.Locals("user_id").(primitive.ObjectID)
+
+	client := gateway.Connect(userID)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		writeGatewayMessages(conn, client)
+	}()
+
+	// Tell the client which connection ID to send with its own changes.
+	// Replies go through the gateway so only the writer writes to the connection.
+	gateway.Reply(client, realtime.Message{
+		Type: "connected",
+		Data: fiber.Map{"connection_id": client.ID},
+	})
+
+	for {
+		var cmd GatewayCommand
+		if err := conn.ReadJSON(&cmd); err != nil {
+			if !isMalformedCommand(err) {
+				break
+			}
+			gateway.Reply(client, realtime.Message{Type: "error", Data: fiber.Map{"error": "Invalid message"}})
+			continue
+		}
+		handleGatewayCommand(store, gateway, client, cmd)
+	}
+
+	// Stop the writer before the connection is released
+	gateway.Disconnect(client)
+	<-done
+}
+
+// handleGatewayCommand subscribes or unsubscribes a connection and replies
+// with the outcome
+func handleGatewayCommand(store models.Store, gateway *realtime.Gateway, client *realtime.Client, cmd GatewayCommand) {
+	reply := realtime.Message{Channel: cmd.Channel}
+
+	switch cmd.Action {
+	case "subscribe":
+		if err := authorizeChannel(store, client.UserID, cmd.Channel); err != "" {
+			reply.Type = "error"
+			reply.Data = fiber.Map{"error": err}
+			break
+		}
+		gateway.Subscribe(client, cmd.Channel)
+		reply.Type = "subscribed"
+	case "unsubscribe":
+		gateway.Unsubscribe(client, cmd.Channel)
+		reply.Type = "unsubscribed"
+	default:
+		reply.Type = "error"
+		reply.Data = fiber.Map{"error": "action must be one of: subscribe, unsubscribe"}
+	}
+
+	gateway.Reply(client, reply)
+}
+
+// authorizeChannel checks that a user may subscribe to a channel, returning
+// why not when they can't
+func authorizeChannel(store models.Store, userID primitive.ObjectID, channel string) string {
+	kind, id, err := realtime.ParseChannel(channel)
+	if err != nil {
+		return "Invalid channel"
+	}
+
+	// Create context with timeout
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	switch kind {
+	case realtime.ChannelQueries, realtime.ChannelNotifications:
+		if !id.IsZero() {
+			return "Invalid channel"
+		}
+		return ""
+	case realtime.ChannelDashboard:
+		dashboard, err := store.GetDashboardByID(ctx, id)
+		if err != nil {
+			return "Failed to retrieve dashboard"
+		}
+		if dashboard == nil || !dashboard.CanView(userID) {
+			return "Dashboard not found"
+		}
+		return ""
+	case realtime.ChannelSchema:
+		db, err := store.GetDatabaseByID(ctx, id)
+		if err != nil {
+			return "Failed to retrieve database"
+		}
+		if db == nil {
+			return "Database not found"
+		}
+		access, err := store.ResolveDatabaseAccess(ctx, db, userID)
+		if err != nil {
+			return "Failed to check database access"
+		}
+		if !access.CanQuery() {
+			return "Database not found"
+		}
+		return ""
+	default:
+		return "Unknown channel"
+	}
+}
+
+// isMalformedCommand reports whether a read failed because the message wasn't
+// a valid command rather than because the connection broke
+func isMalformedCommand(err error) bool {
+	switch err.(type) {
+	case *json.SyntaxError, *json.UnmarshalTypeError:
+		return true
+	default:
+		return false
+	}
+}
+
+// writeGatewayMessages writes a connection's messages and keeps it alive with
+// pings. It closes the connection once the gateway drops it.
+func writeGatewayMessages(conn *websocket.Conn, client *realtime.Client) {
+	ticker := time.NewTicker(livePingInterval)
+	defer ticker.Stop()
+	defer conn.Close()
+
+	for {
+		select {
+		case msg, ok := <-client.Messages:
+			if !ok {
+				return
+			}
+			conn.SetWriteDeadline(time.Now().Add(liveWriteTimeout))
+			if err := conn.WriteJSON(msg); err != nil {
+				return
+			}
+		case <-ticker.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(liveWriteTimeout)); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,406 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/url"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/zucced/goquery/config"
+	"github.com/zucced/goquery/models"
+	"github.com/zucced/goquery/sheets"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/oauth2"
+)
+
+// maxSheetTitleLength caps the title of spreadsheets named after a query
+const maxSheetTitleLength = 100
+
+// SheetExportRequest represents the request body for exporting a query's
+// results to Google Sheets. The last spreadsheet is updated unless
+// new_spreadsheet is set, and a cron expression schedules the export.
+type SheetExportRequest struct {
+	Title          string `json:"title" validate:"max=200"`
+	NewSpreadsheet bool   `json:"new_spreadsheet"`
+	Cron           string `json:"cron" validate:"omitempty,cron"`
+	Timezone       string `json:"timezone" validate:"omitempty,timezone"`
+}
+
+// SheetExportScheduleRequest represents the request body for scheduling a
+// query's export. An empty cron expression leaves it on demand only.
+type SheetExportScheduleRequest struct {
+	Cron     string `json:"cron" validate:"omitempty,cron"`
+	Timezone string `json:"timezone" validate:"omitempty,timezone"`
+}
+
+// GetGoogleIntegrationHandler handles retrieving whether the user connected
+// a Google account
+func GetGoogleIntegrationHandler(store models.Store, googleConf *oauth2.Config) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from context
+		userID := c.Locals("user_id").(primitive.ObjectID)
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Get connection
+		conn, err := store.GetGoogleConnection(ctx, userID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve Google connection: " + err.Error(),
+			})
+		}
+
+		// Return response
+		return c.JSON(fiber.Map{
+			"enabled":    googleConf != nil,
+			"connected":  conn != nil,
+			"connection": conn,
+		})
+	}
+}
+
+// ConnectGoogleHandler handles starting to connect the user's Google account.
+// The frontend sends the browser to the returned URL.
+func ConnectGoogleHandler(cfg *config.Config, googleConf *oauth2.Config) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from context
+		userID := c.Locals("user_id").(primitive.ObjectID)
+
+		if googleConf == nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Exporting to Google Sheets is not enabled",
+			})
+		}
+
+		// Sign the state so the callback can't be forged
+		state, err := sheets.NewState(userID, cfg.JWTSecret)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to start connecting: " + err.Error(),
+			})
+		}
+
+		// Return response
+		return c.JSON(fiber.Map{
+			"url": sheets.AuthCodeURL(googleConf, state),
+		})
+	}
+}
+
+// GoogleCallbackHandler handles Google sending the user back after they
+// allowed access, then sends them to the frontend
+func GoogleCallbackHandler(store models.Store, cfg *config.Config, googleConf *oauth2.Config) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if googleConf == nil {
+			return googleFrontendRedirect(c, cfg, url.Values{"error": {"Exporting to Google Sheets is not enabled"}})
+		}
+		if providerErr := c.Query("error"); providerErr != "" {
+			return googleFrontendRedirect(c, cfg, url.Values{"error": {providerErr}})
+		}
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Verify the state
+		userID, err := sheets.ParseState(c.Query("state"), cfg.JWTSecret)
+		if err != nil {
+			return googleFrontendRedirect(c, cfg, url.Values{"error": {"Connecting expired, please try again"}})
+		}
+
+		// Redeem the code for the user's token
+		conn, err := sheets.Exchange(ctx, googleConf, userID, c.Query("code"))
+		if err != nil {
+			log.Printf("Connecting Google failed for user %s: %v", userID.Hex(), err)
+			return googleFrontendRedirect(c, cfg, url.Values{"error": {"Connecting your Google account failed"}})
+		}
+
+		// Save connection
+		if err := store.SaveGoogleConnection(ctx, conn); err != nil {
+			return googleFrontendRedirect(c, cfg, url.Values{"error": {"Failed to save Google connection"}})
+		}
+
+		return googleFrontendRedirect(c, cfg, url.Values{"connected": {"true"}})
+	}
+}
+
+// DisconnectGoogleHandler handles removing the user's Google connection and
+// revoking its access. Exports are kept and fail until they connect again.
+func DisconnectGoogleHandler(store models.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from context
+		userID := c.Locals("user_id").(primitive.ObjectID)
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Get connection
+		conn, err := store.GetGoogleConnection(ctx, userID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve Google connection: " + err.Error(),
+			})
+		}
+
+		if conn == nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "No Google account is connected",
+			})
+		}
+
+		// Revoke access at Google, the connection is removed either way
+		if err := sheets.Revoke(ctx, conn); err != nil {
+			log.Printf("Failed to revoke Google access of user %s: %v", userID.Hex(), err)
+		}
+
+		// Remove connection
+		if err := store.DeleteGoogleConnection(ctx, userID); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to remove Google connection: " + err.Error(),
+			})
+		}
+
+		// Return response
+		return c.JSON(fiber.Map{
+			"message": "Google account disconnected successfully",
+		})
+	}
+}
+
+// ExportQueryToSheetsHandler handles writing a query's stored results to a
+// Google spreadsheet, creating it on the first export
+func ExportQueryToSheetsHandler(store models.Store, googleConf *oauth2.Config) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from context
+		userID := c.Locals("user_id").(primitive.ObjectID)
+
+		if googleConf == nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Exporting to Google Sheets is not enabled",
+			})
+		}
+
+		// Parse and validate request body, which is optional
+		var req SheetExportRequest
+		if len(c.Body()) > 0 {
+			if invalid := parseRequest(c, &req); invalid != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(invalid)
+			}
+		}
+
+		query, err := loadAccessibleQuery(c, store)
+		if query == nil {
+			return err
+		}
+
+		if query.Status != models.QueryStatusCompleted {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error": "The query has no results to export",
+			})
+		}
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Check the user connected a Google account
+		conn, err := store.GetGoogleConnection(ctx, userID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve Google connection: " + err.Error(),
+			})
+		}
+		if conn == nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": sheets.ErrNotConnected.Error(),
+			})
+		}
+
+		// Get the user's export of this query, or start one
+		export, err := store.GetSheetExport(ctx, query.ID, userID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve export: " + err.Error(),
+			})
+		}
+		if export == nil {
+			export = &models.SheetExport{
+				QueryID: query.ID,
+				UserID:  userID,
+				Title:   sheetTitle(query),
+			}
+		}
+
+		if title := strings.TrimSpace(req.Title); title != "" {
+			export.Title = title
+		}
+		if req.NewSpreadsheet {
+			export.SpreadsheetID = ""
+			export.SpreadsheetURL = ""
+		}
+
+		// Schedules run in the user's timezone unless one is given
+		if req.Cron != "" {
+			export.Cron = strings.TrimSpace(req.Cron)
+			export.Timezone = req.Timezone
+			if export.Timezone == "" {
+				export.Timezone = preferredTimezone(ctx, store, userID)
+			}
+		}
+
+		// Save export
+		if err := store.SaveSheetExport(ctx, export); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to save export: " + err.Error(),
+			})
+		}
+
+		// Write the stored results
+		if err := sheets.Export(ctx, store, googleConf, export, query.Columns, query.Results); err != nil {
+			if errors.Is(err, sheets.ErrNotConnected) || errors.Is(err, sheets.ErrUnauthorized) {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": err.Error(),
+				})
+			}
+			return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{
+				"error": "Failed to export to Google Sheets: " + err.Error(),
+			})
+		}
+
+		// Return response
+		return c.JSON(export)
+	}
+}
+
+// GetSheetExportHandler handles retrieving the user's export of a query
+func GetSheetExportHandler(store models.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		export, err := loadSheetExport(c, store)
+		if export == nil {
+			return err
+		}
+
+		// Return response
+		return c.JSON(export)
+	}
+}
+
+// UpdateSheetExportScheduleHandler handles scheduling or unscheduling the
+// user's export of a query
+func UpdateSheetExportScheduleHandler(store models.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from context
+		userID := c.Locals("user_id").(primitive.ObjectID)
+
+		// Parse and validate request body
+		var req SheetExportScheduleRequest
+		if invalid := parseRequest(c, &req); invalid != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(invalid)
+		}
+
+		export, err := loadSheetExport(c, store)
+		if export == nil {
+			return err
+		}
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Schedules run in the user's timezone unless one is given
+		export.Cron = strings.TrimSpace(req.Cron)
+		export.Timezone = ""
+		if export.Cron != "" {
+			export.Timezone = req.Timezone
+			if export.Timezone == "" {
+				export.Timezone = preferredTimezone(ctx, store, userID)
+			}
+		}
+
+		// Save export
+		if err := store.SaveSheetExport(ctx, export); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to save export: " + err.Error(),
+			})
+		}
+
+		// Return response
+		return c.JSON(export)
+	}
+}
+
+// DeleteSheetExportHandler handles removing the user's export of a query.
+// The spreadsheet stays in their Google account.
+func DeleteSheetExportHandler(store models.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		export, err := loadSheetExport(c, store)
+		if export == nil {
+			return err
+		}
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Remove export
+		if err := store.DeleteSheetExport(ctx, export.QueryID, export.UserID); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to delete export: " + err.Error(),
+			})
+		}
+
+		// Return response
+		return c.JSON(fiber.Map{
+			"message": "Export deleted successfully",
+		})
+	}
+}
+
+// loadSheetExport resolves the user's export of the query in the request
+// path. When the export is nil the returned error is the response already
+// written.
+func loadSheetExport(c *fiber.Ctx, store models.Store) (*models.SheetExport, error) {
+	// Get user ID from context
+	userID := c.Locals("user_id").(primitive.ObjectID)
+
+	query, err := loadAccessibleQuery(c, store)
+	if query == nil {
+		return nil, err
+	}
+
+	// Get export
+	export, err := store.GetSheetExport(c.UserContext(), query.ID, userID)
+	if err != nil {
+		return nil, c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to retrieve export: " + err.Error(),
+		})
+	}
+
+	if export == nil {
+		return nil, c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "The query hasn't been exported to Google Sheets",
+		})
+	}
+
+	return export, nil
+}
+
+// sheetTitle names a query's spreadsheet after the query, or its question
+// when it has no name
+func sheetTitle(query *models.Query) string {
+	title := strings.TrimSpace(query.Name)
+	if title == "" {
+		title = strings.Join(strings.Fields(query.NaturalQuery), " ")
+	}
+	if runes := []rune(title); len(runes) > maxSheetTitleLength {
+		title = string(runes[:maxSheetTitleLength])
+	}
+	if title == "" {
+		title = "Query results"
+	}
+	return title
+}
+
+// googleFrontendRedirect sends the browser back to the frontend with the
+// result of connecting a Google account
+func googleFrontendRedirect(c *fiber.Ctx, cfg *config.Config, values url.Values) error {
+	return c.Redirect(cfg.FrontendURL+"/integrations/google#"+values.Encode(), fiber.StatusFound)
+}
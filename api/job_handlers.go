@@ -0,0 +1,129 @@
+package api
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/zucced/goquery/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// GetJobsHandler handles listing background jobs, most recently updated first
+func GetJobsHandler(store models.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get filters from query
+		status := models.JobStatus(c.Query("status"))
+		switch status {
+		case "", models.JobPending, models.JobRunning, models.JobSucceeded, models.JobDead:
+		default:
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "status must be one of: pending, running, succeeded, dead",
+			})
+		}
+
+		limit := c.QueryInt("limit", 50)
+		if limit < 1 || limit > 200 {
+			limit = 50
+		}
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Get jobs
+		jobs, err := store.GetJobs(ctx, status, c.Query("type"), limit)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve jobs: " + err.Error(),
+			})
+		}
+
+		// Return response
+		return c.JSON(fiber.Map{
+			"jobs": jobs,
+		})
+	}
+}
+
+// GetJobHandler handles getting a single background job
+func GetJobHandler(store models.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get job ID from params
+		jobID, err := primitive.ObjectIDFromHex(c.Params("id"))
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid job ID",
+			})
+		}
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Get job
+		job, err := store.GetJobByID(ctx, jobID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve job: " + err.Error(),
+			})
+		}
+
+		// Check if job exists
+		if job == nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Job not found",
+			})
+		}
+
+		// Return response
+		return c.JSON(job)
+	}
+}
+
+// RetryJobHandler handles giving a dead job a fresh set of attempts
+func RetryJobHandler(store models.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get job ID from params
+		jobID, err := primitive.ObjectIDFromHex(c.Params("id"))
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid job ID",
+			})
+		}
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Retry job
+		job, err := store.RetryJob(ctx, jobID)
+		if errors.Is(err, models.ErrJobKeyActive) {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error": "Another job with the same key is pending or running",
+			})
+		}
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retry job: " + err.Error(),
+			})
+		}
+
+		// Only dead jobs can be retried
+		if job == nil {
+			existing, err := store.GetJobByID(ctx, jobID)
+			if err != nil {
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+					"error": "Failed to retrieve job: " + err.Error(),
+				})
+			}
+			if existing == nil {
+				return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+					"error": "Job not found",
+				})
+			}
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error": "Only dead jobs can be retried, this job is " + string(existing.Status),
+			})
+		}
+
+		// Return response
+		return c.JSON(job)
+	}
+}
@@ -0,0 +1,70 @@
+package api
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/zucced/goquery/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// lineageFocus maps the query parameters that narrow the lineage to the kind
+// of node they name
+var lineageFocus = []struct {
+	param string
+	kind  string
+}{
+	{"database_id", models.LineageDatabase},
+	{"query_id", models.LineageQuery},
+	{"dashboard_id", models.LineageDashboard},
+}
+
+// GetLineageHandler handles retrieving the dependency graph of the user's
+// connections, queries, dashboard cards, report schedules and alerts. A
+// database_id, query_id or dashboard_id narrows it to what that resource
+// depends on and what depends on it.
+func GetLineageHandler(store models.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from context
+		userID := c.Locals("user_id").(primitive.ObjectID)
+
+		// Find the node to narrow the graph to, if any
+		focus := ""
+		for _, f := range lineageFocus {
+			raw := c.Query(f.param)
+			if raw == "" {
+				continue
+			}
+			if focus != "" {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": "Only one of database_id, query_id and dashboard_id can be given",
+				})
+			}
+			id, err := primitive.ObjectIDFromHex(raw)
+			if err != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": "Invalid " + f.param,
+				})
+			}
+			focus = models.LineageNodeID(f.kind, id)
+		}
+
+		// Get lineage
+		lineage, err := store.GetLineage(c.UserContext(), userID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve lineage: " + err.Error(),
+			})
+		}
+
+		if focus != "" {
+			lineage = lineage.Around(focus)
+			if lineage == nil {
+				return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+					"error": "Resource not found in your lineage",
+				})
+			}
+		}
+
+		// Return response
+		return c.JSON(lineage)
+	}
+}
@@ -0,0 +1,167 @@
+package api
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/zucced/goquery/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// DatabaseMaskingRequest represents the request body for replacing the
+// masking rules of a database
+type DatabaseMaskingRequest struct {
+	Rules         []models.MaskingRule `json:"rules"`
+	UnmaskedUsers []string             `json:"unmasked_users" validate:"max=100,dive,objectid"` // Members who see sensitive columns unmasked, besides the owner
+}
+
+// SetDatabaseMaskingHandler handles replacing the sensitive columns of a
+// database, which are masked in the results everyone but its owner and the
+// unmasked members see
+func SetDatabaseMaskingHandler(store models.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from context
+		userID := c.Locals("user_id").(primitive.ObjectID)
+
+		// Get database ID from params
+		databaseID, err := primitive.ObjectIDFromHex(c.Params("id"))
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid database ID",
+			})
+		}
+
+		// Parse and validate request body
+		var req DatabaseMaskingRequest
+		if invalid := parseRequest(c, &req); invalid != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(invalid)
+		}
+
+		if err := models.ValidateMaskingRules(req.Rules); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Get database
+		db, err := store.GetDatabaseByID(ctx, databaseID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve database: " + err.Error(),
+			})
+		}
+
+		if db == nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Database not found",
+			})
+		}
+
+		// Only the owner can change what is masked
+		access, err := store.ResolveDatabaseAccess(ctx, db, userID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to check database access: " + err.Error(),
+			})
+		}
+		if !access.CanManage() {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "You do not have permission to change the masking rules of this database",
+			})
+		}
+
+		// Unmasked users must be members of the organization it is shared with
+		unmasked := make([]primitive.ObjectID, 0, len(req.UnmaskedUsers))
+		seen := make(map[primitive.ObjectID]bool, len(req.UnmaskedUsers))
+		for _, memberHex := range req.UnmaskedUsers {
+			memberID, _ := primitive.ObjectIDFromHex(memberHex)
+			if seen[memberID] || memberID == db.UserID {
+				continue
+			}
+			seen[memberID] = true
+
+			if db.OrgID.IsZero() {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": "Only databases shared with an organization can have unmasked users",
+				})
+			}
+			member, err := store.IsOrganizationMember(ctx, db.OrgID, memberID)
+			if err != nil {
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+					"error": "Failed to check organization membership: " + err.Error(),
+				})
+			}
+			if !member {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": "User " + memberHex + " is not a member of the organization",
+				})
+			}
+			unmasked = append(unmasked, memberID)
+		}
+
+		// Update masking rules
+		if req.Rules == nil {
+			req.Rules = []models.MaskingRule{}
+		}
+		if err := store.SetDatabaseMasking(ctx, db, req.Rules, unmasked); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to update masking rules: " + err.Error(),
+			})
+		}
+
+		// Return response
+		return c.JSON(fiber.Map{
+			"rules":          db.MaskingRules,
+			"unmasked_users": db.UnmaskedUsers,
+		})
+	}
+}
+
+// requestViewer returns the user a response is for, the nil ID for anonymous
+// viewers of embeds and download links
+func requestViewer(c *fiber.Ctx) primitive.ObjectID {
+	viewer, _ := c.Locals("user_id").(primitive.ObjectID)
+	return viewer
+}
+
+// maskQuery masks the results of a query as the requesting user may see
+// them. Results that can't be masked are left out. The query must not be
+// saved afterwards.
+func maskQuery(c *fiber.Ctx, store models.Store, query *models.Query) error {
+	err := store.NewResultMasker(requestViewer(c)).MaskQuery(c.UserContext(), query)
+	if err != nil {
+		query.Results = nil
+	}
+	return err
+}
+
+// maskQueries masks the results of queries as the requesting user may see
+// them, loading the rules of each connection once. The queries must not be
+// saved afterwards.
+func maskQueries(c *fiber.Ctx, store models.Store, queries []*models.Query) error {
+	masker := store.NewResultMasker(requestViewer(c))
+	for _, query := range queries {
+		if err := masker.MaskQuery(c.UserContext(), query); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// maskCommentRows masks the rows comments were made on as the requesting user
+// may see them. The comments must not be saved afterwards.
+func maskCommentRows(c *fiber.Ctx, store models.Store, query *models.Query, comments ...*models.QueryComment) error {
+	masker := store.NewResultMasker(requestViewer(c))
+	for _, comment := range comments {
+		if comment.RowValues == nil {
+			continue
+		}
+		rows, err := masker.Mask(c.UserContext(), query, []models.QueryResult{comment.RowValues})
+		if err != nil {
+			return err
+		}
+		comment.RowValues = rows[0]
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+package api
+
+import (
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/zucced/goquery/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MaterializationRequest represents the request body for materializing a query
+type MaterializationRequest struct {
+	Cron     string `json:"cron" validate:"notblank,cron"`
+	Timezone string `json:"timezone" validate:"omitempty,timezone"`
+}
+
+// MaterializeQueryHandler handles refreshing a query's results on a schedule,
+// so dashboards and API reads serve them without running the query. Only the
+// query's owner can do it, its results are refreshed as them.
+func MaterializeQueryHandler(store models.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from context
+		userID := c.Locals("user_id").(primitive.ObjectID)
+
+		// Parse and validate request body
+		var req MaterializationRequest
+		if invalid := parseRequest(c, &req); invalid != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(invalid)
+		}
+
+		query, err := loadOwnedQuery(c, store)
+		if query == nil {
+			return err
+		}
+
+		if err := query.CanMaterialize(); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Connections that confirm every query can't run one on a schedule
+		if !query.Federated() {
+			db, err := store.GetDatabaseByID(ctx, query.DatabaseID)
+			if err != nil {
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+					"error": "Failed to retrieve database: " + err.Error(),
+				})
+			}
+
+			if db == nil {
+				return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+					"error": "Database not found",
+				})
+			}
+
+			if db.RequiresConfirmation() {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": "Queries against this database need confirmation and can't be materialized",
+				})
+			}
+		}
+
+		// Schedules run in the user's timezone unless one is given
+		timezone := req.Timezone
+		if timezone == "" {
+			timezone = preferredTimezone(ctx, store, userID)
+		}
+
+		// Save materialization
+		if err := store.SetQueryMaterialization(ctx, query, strings.TrimSpace(req.Cron), timezone); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to materialize query: " + err.Error(),
+			})
+		}
+
+		// Return response
+		return c.JSON(query.Materialization)
+	}
+}
+
+// UnmaterializeQueryHandler handles no longer refreshing a query's results on
+// a schedule. The results it has are kept.
+func UnmaterializeQueryHandler(store models.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		query, err := loadOwnedQuery(c, store)
+		if query == nil {
+			return err
+		}
+
+		if !query.Materialized() {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Query is not materialized",
+			})
+		}
+
+		// Remove materialization
+		if err := store.UnmaterializeQuery(c.UserContext(), query.ID); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to unmaterialize query: " + err.Error(),
+			})
+		}
+
+		// Return response
+		return c.JSON(fiber.Map{
+			"message": "Query unmaterialized successfully",
+		})
+	}
+}
+
+// loadOwnedQuery resolves the query in the request path and checks it
+// belongs to the user. When the query is nil the returned error is the
+// response already written.
+func loadOwnedQuery(c *fiber.Ctx, store models.Store) (*models.Query, error) {
+	// Get user ID from context
+	userID := c.Locals("user_id").(primitive.ObjectID)
+
+	// Get query ID from params
+	queryID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return nil, c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid query ID",
+		})
+	}
+
+	// Get query
+	query, err := store.GetQueryByID(c.UserContext(), queryID)
+	if err != nil {
+		return nil, c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to retrieve query: " + err.Error(),
+		})
+	}
+
+	if query == nil {
+		return nil, c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Query not found",
+		})
+	}
+
+	// Check if query belongs to user
+	if query.UserID != userID {
+		return nil, c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "You don't have permission to access this query",
+		})
+	}
+
+	return query, nil
+}
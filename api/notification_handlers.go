@@ -0,0 +1,91 @@
+package api
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/zucced/goquery/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MarkNotificationsReadRequest represents the request body for marking
+// in-app notifications read. Without IDs every notification is marked.
+type MarkNotificationsReadRequest struct {
+	IDs []string `json:"ids" validate:"max=200,dive,objectid"`
+}
+
+// GetNotificationsHandler handles listing the current user's in-app
+// notifications, newest first, with the number still unread
+func GetNotificationsHandler(store models.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from context
+		userID := c.Locals("user_id").(primitive.ObjectID)
+
+		// Get limit from query
+		limit := c.QueryInt("limit", 50)
+		if limit < 1 || limit > 200 {
+			limit = 50
+		}
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Get notifications
+		notifications, err := store.GetNotifications(ctx, userID, c.QueryBool("unread"), limit)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve notifications: " + err.Error(),
+			})
+		}
+
+		unread, err := store.CountUnreadNotifications(ctx, userID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to count unread notifications: " + err.Error(),
+			})
+		}
+
+		// Return response
+		return c.JSON(fiber.Map{
+			"notifications": notifications,
+			"unread":        unread,
+		})
+	}
+}
+
+// MarkNotificationsReadHandler handles marking the current user's in-app
+// notifications read
+func MarkNotificationsReadHandler(store models.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from context
+		userID := c.Locals("user_id").(primitive.ObjectID)
+
+		// Parse and validate request body
+		var req MarkNotificationsReadRequest
+		if len(c.Body()) > 0 {
+			if invalid := parseRequest(c, &req); invalid != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(invalid)
+			}
+		}
+
+		ids := make([]primitive.ObjectID, 0, len(req.IDs))
+		for _, value := range req.IDs {
+			id, _ := primitive.ObjectIDFromHex(value)
+			ids = append(ids, id)
+		}
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Mark notifications read
+		marked, err := store.MarkNotificationsRead(ctx, userID, ids)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to mark notifications read: " + err.Error(),
+			})
+		}
+
+		// Return response
+		return c.JSON(fiber.Map{
+			"marked": marked,
+		})
+	}
+}
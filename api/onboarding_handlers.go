@@ -0,0 +1,34 @@
+package api
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/zucced/goquery/demo"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// AddSampleDataHandler handles giving the user the sample database, example
+// queries and a starter dashboard, e.g. after signing up before it was on
+func AddSampleDataHandler(provisioner *demo.Provisioner) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from context
+		userID := c.Locals("user_id").(primitive.ObjectID)
+
+		// Add sample data
+		onboarding, err := provisioner.Onboard(c.UserContext(), userID)
+		if err != nil {
+			if errors.Is(err, demo.ErrAlreadyOnboarded) {
+				return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+					"error": "You already have the sample database",
+				})
+			}
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to add sample data: " + err.Error(),
+			})
+		}
+
+		// Return response
+		return c.Status(fiber.StatusCreated).JSON(onboarding)
+	}
+}
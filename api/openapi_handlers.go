@@ -0,0 +1,287 @@
+package api
+
+import (
+	"html/template"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/zucced/goquery/config"
+	"github.com/zucced/goquery/demo"
+	"github.com/zucced/goquery/models"
+	"github.com/zucced/goquery/openapi"
+	"github.com/zucced/goquery/tunnel"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// pagination is the page information of paginated lists
+var pagination = openapi.Object{"total": int64(0), "page": 0, "limit": 0, "pages": 0}
+
+// message is the response of endpoints that only confirm they succeeded
+var message = openapi.Object{"message": ""}
+
+// organizationAssignment is the response of sharing a resource with an organization
+var organizationAssignment = openapi.Object{
+	"id":           primitive.ObjectID{},
+	"org_id":       primitive.ObjectID{},
+	"workspace_id": primitive.ObjectID{},
+}
+
+// DescribeRoutes describes the request and response bodies of the API's
+// routes, which are documented at /api/openapi.json
+func DescribeRoutes(spec *openapi.Spec) {
+	// Auth
+	spec.Describe("POST", "/api/auth/signup", openapi.Operation{Summary: "Create an account", Security: openapi.SecurityNone, Request: SignupRequest{}, Response: AuthResponse{}, Status: fiber.StatusCreated})
+	spec.Describe("POST", "/api/auth/login", openapi.Operation{Summary: "Log in with an email and password", Security: openapi.SecurityNone, Request: LoginRequest{}, Response: AuthResponse{}})
+	spec.Describe("GET", "/api/auth/me", openapi.Operation{Summary: "Get the current user", Response: models.User{}})
+	spec.Describe("GET", "/api/auth/me/preferences", openapi.Operation{Summary: "Get the current user's preferences", Response: models.UserPreferences{}})
+	spec.Describe("PUT", "/api/auth/me/preferences", openapi.Operation{Summary: "Update the current user's preferences", Request: PreferencesRequest{}, Response: models.UserPreferences{}})
+	spec.Describe("POST", "/api/auth/logout", openapi.Operation{Summary: "Revoke the current session", Response: message})
+	spec.Describe("POST", "/api/auth/change-password", openapi.Operation{Summary: "Change the current user's password", Request: ChangePasswordRequest{}, Response: message})
+	spec.Describe("GET", "/api/auth/sessions", openapi.Operation{Summary: "List active sessions", Response: openapi.Object{"sessions": []models.Session{}}})
+	spec.Describe("DELETE", "/api/auth/sessions", openapi.Operation{Summary: "Revoke all sessions", Query: []string{"keep_current"}, Response: openapi.Object{"message": "", "revoked": 0}})
+	spec.Describe("DELETE", "/api/auth/sessions/:id", openapi.Operation{Summary: "Revoke a session", Response: message})
+	spec.Describe("POST", "/api/auth/forgot-password", openapi.Operation{Summary: "Email a password reset link", Security: openapi.SecurityNone, Request: ForgotPasswordRequest{}, Response: message})
+	spec.Describe("POST", "/api/auth/reset-password", openapi.Operation{Summary: "Reset a password with a reset token", Security: openapi.SecurityNone, Request: ResetPasswordRequest{}, Response: message})
+	spec.Describe("POST", "/api/auth/demo", openapi.Operation{Summary: "Start a demo session", Security: openapi.SecurityNone, Response: DemoResponse{}, Status: fiber.StatusCreated})
+	spec.Describe("POST", "/api/onboarding/sample-data", openapi.Operation{Summary: "Add the sample database, example queries and a starter dashboard to your account", Response: demo.Onboarding{}, Status: fiber.StatusCreated})
+	spec.Describe("POST", "/api/auth/sso/lookup", openapi.Operation{Summary: "Find the single sign-on organization of an email", Security: openapi.SecurityNone, Request: SSOLookupRequest{}, Response: openapi.Object{"sso": false, "enforced": false, "org_id": primitive.ObjectID{}, "login_url": ""}})
+	spec.Describe("GET", "/api/auth/sso/:orgId/login", openapi.Operation{Summary: "Redirect to an organization's identity provider", Security: openapi.SecurityNone, Status: fiber.StatusFound})
+	spec.Describe("GET", "/api/auth/sso/:orgId/callback", openapi.Operation{Summary: "Complete single sign-on and redirect to the frontend", Security: openapi.SecurityNone, Query: []string{"code", "state", "error"}, Status: fiber.StatusFound})
+
+	// Databases
+	spec.Describe("POST", "/api/databases", openapi.Operation{Summary: "Add a database connection", Request: DatabaseRequest{}, Response: models.Database{}, Status: fiber.StatusCreated})
+	spec.Describe("GET", "/api/databases", openapi.Operation{Summary: "List database connections", Response: openapi.Object{"databases": []models.Database{}}})
+	spec.Describe("GET", "/api/databases/health", openapi.Operation{Summary: "Summarize the health and query error rates of database connections", Query: []string{"window"}, Response: openapi.Object{"databases": []DatabaseHealth{}, "window": ""}})
+	spec.Describe("POST", "/api/databases/import", openapi.Operation{Summary: "Validate, test and add many database connections from JSON or YAML, resolving secret references", Request: DatabaseImportRequest{}, Response: openapi.Object{"results": []DatabaseImportResult{}, "created": 0, "valid": 0, "skipped": 0, "failed": 0, "dry_run": false}})
+	spec.Describe("GET", "/api/databases/:id", openapi.Operation{Summary: "Get a database connection and its schema", Query: []string{"refresh"}, Response: models.Database{}})
+	spec.Describe("DELETE", "/api/databases/:id", openapi.Operation{Summary: "Delete a database connection and report what it powered. Refused while it powers queries, dashboards, schedules or alerts, unless forced", Query: []string{"force"}, Response: openapi.Object{"message": "", "dependents": models.LineageImpact{}}})
+	spec.Describe("POST", "/api/databases/:id/duplicate", openapi.Operation{Summary: "Copy a database connection", Request: DuplicateDatabaseRequest{}, Response: models.Database{}, Status: fiber.StatusCreated})
+	spec.Describe("POST", "/api/databases/test-connection", openapi.Operation{Summary: "Test a connection and diagnose problems", Request: DatabaseRequest{}, Response: openapi.Object{"message": "", "diagnostics": models.ConnectionDiagnostics{}, "ssh_tunnel": tunnel.Health{}, "table_count": 0}})
+	spec.Describe("POST", "/api/databases/:id/test-connection", openapi.Operation{Summary: "Test a saved connection, pinning the ssh host key of a tunnel that has none", Response: openapi.Object{"message": "", "host_key": ""}})
+	spec.Describe("GET", "/api/databases/:id/queries", openapi.Operation{Summary: "List the queries run against a database", Query: []string{"page", "limit"}, Response: openapi.Object{"queries": []models.Query{}, "pagination": pagination}})
+	spec.Describe("GET", "/api/databases/:id/suggestions", openapi.Operation{Summary: "Suggest questions to ask a database, from earlier questions and its schema", Query: []string{"prefix", "limit"}, Response: openapi.Object{"suggestions": []models.QuestionSuggestion{}}})
+	spec.Describe("PUT", "/api/databases/:id/organization", openapi.Operation{Summary: "Share a database with an organization", Request: OrganizationAssignmentRequest{}, Response: organizationAssignment})
+	spec.Describe("PUT", "/api/databases/:id/members", openapi.Operation{Summary: "Limit which organization members can query a database", Request: DatabaseMembersRequest{}, Response: openapi.Object{"id": primitive.ObjectID{}, "org_id": primitive.ObjectID{}, "shared_with": []primitive.ObjectID{}}})
+	spec.Describe("PUT", "/api/databases/:id/schema/annotations", openapi.Operation{Summary: "Replace the column annotations of a database", Request: SchemaAnnotationsRequest{}, Response: SchemaAnnotationsRequest{}})
+	spec.Describe("PUT", "/api/databases/:id/masking", openapi.Operation{Summary: "Replace the sensitive columns of a database and who sees them unmasked", Request: DatabaseMaskingRequest{}, Response: openapi.Object{"rules": []models.MaskingRule{}, "unmasked_users": []primitive.ObjectID{}}})
+	spec.Describe("POST", "/api/databases/:id/tables/:table/profile", openapi.Operation{Summary: "Compute the column statistics of a table from a sample of its rows", Query: []string{"refresh"}, Response: models.TableProfile{}})
+	spec.Describe("GET", "/api/databases/:id/freshness", openapi.Operation{Summary: "List the freshness checks of a database", Response: openapi.Object{"checks": []models.FreshnessCheck{}}})
+	spec.Describe("POST", "/api/databases/:id/freshness", openapi.Operation{Summary: "Declare how recent the data in a table should be", Request: FreshnessCheckRequest{}, Response: models.FreshnessCheck{}, Status: fiber.StatusCreated})
+	spec.Describe("PUT", "/api/databases/:id/freshness/:checkId", openapi.Operation{Summary: "Update a freshness check", Request: FreshnessCheckRequest{}, Response: models.FreshnessCheck{}})
+	spec.Describe("DELETE", "/api/databases/:id/freshness/:checkId", openapi.Operation{Summary: "Delete a freshness check", Response: message})
+
+	// Queries
+	spec.Describe("POST", "/api/queries", openapi.Operation{Summary: "Generate and run a query from a natural language question", Request: QueryRequest{}, Response: models.Query{}})
+	spec.Describe("POST", "/api/queries/federated", openapi.Operation{Summary: "Ask a question across the results of saved queries, which may come from different databases", Request: FederatedQueryRequest{}, Response: models.Query{}})
+	spec.Describe("GET", "/api/queries", openapi.Operation{Summary: "List queries", Query: []string{"page", "limit", "search", "tag"}, Response: openapi.Object{"queries": []models.Query{}, "pagination": pagination}})
+	spec.Describe("GET", "/api/queries/:id", openapi.Operation{Summary: "Get a query", Response: models.Query{}})
+	spec.Describe("PUT", "/api/queries/:id", openapi.Operation{Summary: "Update a query", Request: QueryRequest{}, Response: models.Query{}})
+	spec.Describe("DELETE", "/api/queries/:id", openapi.Operation{Summary: "Move a query to the trash", Response: message})
+	spec.Describe("POST", "/api/queries/:id/rerun", openapi.Operation{Summary: "Run a query again", Response: models.Query{}})
+	spec.Describe("PUT", "/api/queries/:id/tags", openapi.Operation{Summary: "Replace the tags of a query", Request: QueryTagsRequest{}, Response: models.Query{}})
+	spec.Describe("POST", "/api/queries/:id/duplicate", openapi.Operation{Summary: "Copy a query", Request: DuplicateQueryRequest{}, Response: models.Query{}, Status: fiber.StatusCreated})
+	spec.Describe("POST", "/api/queries/:id/restore", openapi.Operation{Summary: "Restore a query from the trash", Response: models.Query{}})
+	spec.Describe("POST", "/api/queries/:id/confirm-write", openapi.Operation{Summary: "Confirm and run a query held for confirmation, such as one that modifies data", Response: models.Query{}})
+	spec.Describe("GET", "/api/queries/approvals", openapi.Operation{Summary: "List the queries waiting for your approval", Response: openapi.Object{"queries": []models.Query{}}})
+	spec.Describe("POST", "/api/queries/:id/approve", openapi.Operation{Summary: "Approve and run a query against a production database", Response: models.Query{}})
+	spec.Describe("POST", "/api/queries/:id/reject", openapi.Operation{Summary: "Reject a query waiting for approval", Request: RejectQueryRequest{}, Response: models.Query{}})
+	spec.Describe("GET", "/api/queries/:id/chart-data", openapi.Operation{Summary: "Aggregate a query's results into a chart series", Query: []string{"x", "y", "agg", "bucket", "tz"}, Response: models.ChartSeries{}})
+	spec.Describe("POST", "/api/queries/:id/pivot", openapi.Operation{Summary: "Compute a pivot table over a query's stored results", Request: PivotRequest{}, Response: models.PivotTable{}})
+	spec.Describe("POST", "/api/queries/:id/transform", openapi.Operation{Summary: "Reshape a query's stored results into chart series", Request: ChartTransformRequest{}, Response: models.TransformedChart{}})
+	spec.Describe("GET", "/api/queries/:id/export/google-sheets", openapi.Operation{Summary: "Get your Google Sheets export of a query", Response: models.SheetExport{}})
+	spec.Describe("POST", "/api/queries/:id/export/google-sheets", openapi.Operation{Summary: "Write a query's results to a Google spreadsheet", Request: SheetExportRequest{}, Response: models.SheetExport{}})
+	spec.Describe("PUT", "/api/queries/:id/export/google-sheets", openapi.Operation{Summary: "Schedule or unschedule your Google Sheets export of a query", Request: SheetExportScheduleRequest{}, Response: models.SheetExport{}})
+	spec.Describe("DELETE", "/api/queries/:id/export/google-sheets", openapi.Operation{Summary: "Delete your Google Sheets export of a query", Response: message})
+	spec.Describe("GET", "/api/queries/:id/runs", openapi.Operation{Summary: "List the scheduled runs of a query", Query: []string{"page", "limit"}, Response: openapi.Object{"runs": []models.QueryRun{}, "pagination": pagination}})
+	spec.Describe("PUT", "/api/queries/:id/materialization", openapi.Operation{Summary: "Refresh a query's results on a schedule and serve them to dashboards", Request: MaterializationRequest{}, Response: models.Materialization{}})
+	spec.Describe("DELETE", "/api/queries/:id/materialization", openapi.Operation{Summary: "Stop refreshing a query's results on a schedule", Response: message})
+	spec.Describe("GET", "/api/queries/:id/comments", openapi.Operation{Summary: "List the comments on a query", Query: []string{"page", "limit", "run_id"}, Response: openapi.Object{"comments": []models.QueryComment{}, "pagination": pagination}})
+	spec.Describe("POST", "/api/queries/:id/comments", openapi.Operation{Summary: "Comment on a query, one of its runs or a row of its results", Request: QueryCommentRequest{}, Response: models.QueryComment{}, Status: fiber.StatusCreated})
+	spec.Describe("PUT", "/api/queries/:id/comments/:commentId", openapi.Operation{Summary: "Edit your comment on a query", Request: QueryCommentUpdateRequest{}, Response: models.QueryComment{}})
+	spec.Describe("DELETE", "/api/queries/:id/comments/:commentId", openapi.Operation{Summary: "Delete a comment on a query", Response: message})
+	spec.Describe("PUT", "/api/queries/:id/organization", openapi.Operation{Summary: "Share a query with an organization", Request: OrganizationAssignmentRequest{}, Response: organizationAssignment})
+
+	// Dashboards
+	spec.Describe("POST", "/api/dashboards", openapi.Operation{Summary: "Create a dashboard", Request: DashboardRequest{}, Response: models.Dashboard{}})
+	spec.Describe("GET", "/api/dashboards", openapi.Operation{Summary: "List dashboards", Query: []string{"starred", "archived"}, Response: openapi.Object{"dashboards": []models.Dashboard{}}})
+	spec.Describe("GET", "/api/dashboards/default", openapi.Operation{Summary: "Get the default dashboard", Response: models.Dashboard{}})
+	spec.Describe("GET", "/api/dashboards/:id", openapi.Operation{Summary: "Get a dashboard", Response: models.Dashboard{}})
+	spec.Describe("PUT", "/api/dashboards/:id", openapi.Operation{Summary: "Update a dashboard", Request: DashboardRequest{}, Response: models.Dashboard{}})
+	spec.Describe("DELETE", "/api/dashboards/:id", openapi.Operation{Summary: "Move a dashboard to the trash", Response: message})
+	spec.Describe("POST", "/api/dashboards/:id/cards", openapi.Operation{Summary: "Add a card to a dashboard", Request: DashboardCardRequest{}, Response: models.DashboardCard{}})
+	spec.Describe("PUT", "/api/dashboards/:id/cards/:cardId", openapi.Operation{Summary: "Update a card", Request: DashboardCardRequest{}, Response: models.DashboardCard{}})
+	spec.Describe("DELETE", "/api/dashboards/:id/cards/:cardId", openapi.Operation{Summary: "Remove a card", Response: message})
+	spec.Describe("GET", "/api/dashboards/:id/data", openapi.Operation{Summary: "Get the data of every card on a dashboard", Response: openapi.Object{"dashboard_id": primitive.ObjectID{}, "cards": []CardDataResult{}}})
+	spec.Describe("POST", "/api/dashboards/:id/refresh", openapi.Operation{Summary: "Rerun the queries of every card on a dashboard", Response: openapi.Object{"dashboard_id": primitive.ObjectID{}, "refreshed": 0, "failed": 0, "cards": []CardDataResult{}}})
+	spec.Describe("GET", "/api/dashboards/:id/cards/:cardId/data", openapi.Operation{Summary: "Get the data of a card", Response: CardDataResponse{}})
+	spec.Describe("PUT", "/api/dashboards/:id/cards", openapi.Operation{Summary: "Move cards on a dashboard", Request: []CardPositionRequest{}, Response: models.Dashboard{}})
+	spec.Describe("GET", "/api/dashboards/:id/live", openapi.Operation{Summary: "Stream dashboard changes over a WebSocket", Query: []string{"token"}, Status: fiber.StatusSwitchingProtocols})
+	spec.Describe("POST", "/api/dashboards/:id/restore", openapi.Operation{Summary: "Restore a dashboard from the trash", Response: models.Dashboard{}})
+	spec.Describe("POST", "/api/dashboards/:id/star", openapi.Operation{OperationID: "starDashboard", Summary: "Star a dashboard", Response: openapi.Object{"id": primitive.ObjectID{}, "starred": false}})
+	spec.Describe("DELETE", "/api/dashboards/:id/star", openapi.Operation{OperationID: "unstarDashboard", Summary: "Unstar a dashboard", Response: openapi.Object{"id": primitive.ObjectID{}, "starred": false}})
+	spec.Describe("POST", "/api/dashboards/:id/set-default", openapi.Operation{Summary: "Make a dashboard the default", Response: models.Dashboard{}})
+	spec.Describe("POST", "/api/dashboards/:id/archive", openapi.Operation{OperationID: "archiveDashboard", Summary: "Archive a dashboard", Response: models.Dashboard{}})
+	spec.Describe("POST", "/api/dashboards/:id/unarchive", openapi.Operation{OperationID: "unarchiveDashboard", Summary: "Unarchive a dashboard", Response: models.Dashboard{}})
+	spec.Describe("POST", "/api/dashboards/:id/snapshot", openapi.Operation{Summary: "Capture a snapshot of a dashboard", Request: SnapshotRequest{}, Response: models.DashboardSnapshot{}, Status: fiber.StatusCreated})
+	spec.Describe("GET", "/api/dashboards/:id/snapshots", openapi.Operation{Summary: "List the snapshots of a dashboard", Response: []models.DashboardSnapshot{}})
+	spec.Describe("GET", "/api/dashboards/:id/snapshots/:snapshotId", openapi.Operation{Summary: "Get a snapshot", Response: models.DashboardSnapshot{}})
+	spec.Describe("GET", "/api/dashboards/:id/snapshots/:snapshotId/export", openapi.Operation{Summary: "Export a snapshot as a PDF or PNG", Query: []string{"format"}, ContentType: "application/octet-stream"})
+	spec.Describe("POST", "/api/dashboards/:id/snapshots/:snapshotId/export/link", openapi.Operation{Summary: "Get a signed, single-use link that downloads a snapshot export", Request: DownloadLinkRequest{}, Response: openapi.Object{"url": "", "expires_at": time.Time{}}, Status: fiber.StatusCreated})
+	spec.Describe("POST", "/api/dashboards/:id/reports", openapi.Operation{Summary: "Schedule an emailed report of a dashboard", Request: ReportScheduleRequest{}, Response: models.ReportSchedule{}, Status: fiber.StatusCreated})
+	spec.Describe("GET", "/api/dashboards/:id/reports", openapi.Operation{Summary: "List the report schedules of a dashboard", Response: []models.ReportSchedule{}})
+	spec.Describe("PUT", "/api/dashboards/:id/reports/:reportId", openapi.Operation{Summary: "Update a report schedule", Request: ReportScheduleRequest{}, Response: models.ReportSchedule{}})
+	spec.Describe("DELETE", "/api/dashboards/:id/reports/:reportId", openapi.Operation{Summary: "Delete a report schedule", Response: message})
+	spec.Describe("GET", "/api/dashboards/:id/reports/:reportId/deliveries", openapi.Operation{Summary: "List the deliveries of a report schedule", Query: []string{"limit"}, Response: []models.ReportDelivery{}})
+	spec.Describe("POST", "/api/dashboards/:id/embed", openapi.Operation{Summary: "Issue an embed token for a dashboard or card", Request: EmbedTokenRequest{}, Response: openapi.Object{"token": "", "expires_at": time.Time{}}, Status: fiber.StatusCreated})
+	spec.Describe("GET", "/api/dashboards/:id/collaborators", openapi.Operation{Summary: "List the users a dashboard is shared with", Response: openapi.Object{"owner_id": primitive.ObjectID{}, "collaborators": []models.DashboardCollaborator{}}})
+	spec.Describe("POST", "/api/dashboards/:id/collaborators", openapi.Operation{Summary: "Share a dashboard with a user", Request: ShareDashboardRequest{}, Response: openapi.Object{"owner_id": primitive.ObjectID{}, "collaborators": []models.DashboardCollaborator{}}})
+	spec.Describe("POST", "/api/dashboards/invites/accept", openapi.Operation{Summary: "Accept a dashboard invite with the token from its email", Request: AcceptDashboardInviteRequest{}, Response: openapi.Object{"dashboard_id": primitive.ObjectID{}}})
+	spec.Describe("DELETE", "/api/dashboards/:id/collaborators/:collaboratorId", openapi.Operation{Summary: "Stop sharing a dashboard with a user", Response: message})
+	spec.Describe("GET", "/api/dashboards/:id/comments", openapi.Operation{Summary: "List the comment threads on a dashboard with their replies", Query: []string{"page", "limit", "card_id", "resolved"}, Response: openapi.Object{"comments": []models.DashboardComment{}, "pagination": pagination}})
+	spec.Describe("POST", "/api/dashboards/:id/comments", openapi.Operation{Summary: "Comment on a dashboard or one of its cards, or reply to a thread", Request: DashboardCommentRequest{}, Response: models.DashboardComment{}, Status: fiber.StatusCreated})
+	spec.Describe("PUT", "/api/dashboards/:id/comments/:commentId", openapi.Operation{Summary: "Edit your comment on a dashboard", Request: DashboardCommentUpdateRequest{}, Response: models.DashboardComment{}})
+	spec.Describe("DELETE", "/api/dashboards/:id/comments/:commentId", openapi.Operation{Summary: "Delete a comment on a dashboard, with its replies", Response: message})
+	spec.Describe("POST", "/api/dashboards/:id/comments/:commentId/resolve", openapi.Operation{Summary: "Resolve a comment thread on a dashboard", Response: models.DashboardComment{}})
+	spec.Describe("POST", "/api/dashboards/:id/comments/:commentId/reopen", openapi.Operation{Summary: "Reopen a resolved comment thread on a dashboard", Response: models.DashboardComment{}})
+	spec.Describe("PUT", "/api/dashboards/:id/organization", openapi.Operation{Summary: "Share a dashboard with an organization", Request: OrganizationAssignmentRequest{}, Response: organizationAssignment})
+
+	// Organizations
+	spec.Describe("POST", "/api/orgs", openapi.Operation{Summary: "Create an organization", Request: OrganizationRequest{}, Response: models.Organization{}, Status: fiber.StatusCreated})
+	spec.Describe("GET", "/api/orgs", openapi.Operation{Summary: "List organizations", Response: openapi.Object{"organizations": []models.Organization{}}})
+	spec.Describe("GET", "/api/orgs/:id", openapi.Operation{Summary: "Get an organization", Response: models.Organization{}})
+	spec.Describe("PUT", "/api/orgs/:id", openapi.Operation{Summary: "Rename an organization", Request: OrganizationRequest{}, Response: models.Organization{}})
+	spec.Describe("DELETE", "/api/orgs/:id", openapi.Operation{Summary: "Delete an organization", Response: message})
+	spec.Describe("POST", "/api/orgs/:id/members", openapi.Operation{Summary: "Add a member to an organization", Request: OrganizationMemberRequest{}, Response: models.Organization{}})
+	spec.Describe("PUT", "/api/orgs/:id/members/:userId", openapi.Operation{Summary: "Change a member's role", Request: OrganizationMemberRequest{}, Response: models.Organization{}})
+	spec.Describe("DELETE", "/api/orgs/:id/members/:userId", openapi.Operation{Summary: "Remove a member from an organization", Response: message})
+	spec.Describe("GET", "/api/orgs/:id/sso", openapi.Operation{Summary: "Get an organization's single sign-on configuration", Response: openapi.Object{"sso": models.SSOConfig{}, "redirect_uri": "", "verification_record": "", "unverified_domains": []string{}}})
+	spec.Describe("PUT", "/api/orgs/:id/sso", openapi.Operation{Summary: "Configure single sign-on for an organization", Request: SSOConfigRequest{}, Response: openapi.Object{"sso": models.SSOConfig{}, "redirect_uri": "", "verification_record": "", "unverified_domains": []string{}}})
+	spec.Describe("DELETE", "/api/orgs/:id/sso", openapi.Operation{Summary: "Remove an organization's single sign-on configuration", Response: message})
+	spec.Describe("POST", "/api/orgs/:id/sso/verify", openapi.Operation{Summary: "Verify an organization's single sign-on domains through DNS", Response: openapi.Object{"sso": models.SSOConfig{}, "redirect_uri": "", "verification_record": "", "unverified_domains": []string{}, "failures": map[string]string{}}})
+	spec.Describe("GET", "/api/orgs/:id/workspaces", openapi.Operation{Summary: "List an organization's workspaces", Response: openapi.Object{"workspaces": []models.Workspace{}}})
+	spec.Describe("POST", "/api/orgs/:id/workspaces", openapi.Operation{Summary: "Create a workspace", Request: WorkspaceRequest{}, Response: models.Workspace{}, Status: fiber.StatusCreated})
+	spec.Describe("PUT", "/api/orgs/:id/workspaces/:workspaceId", openapi.Operation{Summary: "Update a workspace", Request: WorkspaceRequest{}, Response: models.Workspace{}})
+	spec.Describe("DELETE", "/api/orgs/:id/workspaces/:workspaceId", openapi.Operation{Summary: "Delete a workspace", Response: message})
+	spec.Describe("GET", "/api/orgs/:id/templates", openapi.Operation{Summary: "List an organization's query templates", Response: openapi.Object{"templates": []models.QueryTemplate{}}})
+	spec.Describe("POST", "/api/orgs/:id/templates", openapi.Operation{Summary: "Publish a query template to an organization's library", Request: QueryTemplateRequest{}, Response: models.QueryTemplate{}, Status: fiber.StatusCreated})
+
+	// Query templates
+	spec.Describe("GET", "/api/templates/:id", openapi.Operation{Summary: "Get a query template", Response: models.QueryTemplate{}})
+	spec.Describe("PUT", "/api/templates/:id", openapi.Operation{Summary: "Edit a query template", Request: QueryTemplateRequest{}, Response: models.QueryTemplate{}})
+	spec.Describe("DELETE", "/api/templates/:id", openapi.Operation{Summary: "Remove a query template from its library", Response: message})
+	spec.Describe("POST", "/api/templates/:id/instantiate", openapi.Operation{Summary: "Create a query from a template on one of your connections", Request: InstantiateTemplateRequest{}, Response: models.Query{}, Status: fiber.StatusCreated})
+
+	// Webhooks
+	spec.Describe("POST", "/api/alerts", openapi.Operation{Summary: "Create an alert on a saved query's results", Request: AlertRequest{}, Response: models.Alert{}, Status: fiber.StatusCreated})
+	spec.Describe("GET", "/api/alerts", openapi.Operation{Summary: "List alerts", Query: []string{"query_id"}, Response: openapi.Object{"alerts": []models.Alert{}}})
+	spec.Describe("GET", "/api/alerts/:id", openapi.Operation{Summary: "Get an alert and its state", Response: models.Alert{}})
+	spec.Describe("PUT", "/api/alerts/:id", openapi.Operation{Summary: "Update an alert", Request: AlertRequest{}, Response: models.Alert{}})
+	spec.Describe("DELETE", "/api/alerts/:id", openapi.Operation{Summary: "Delete an alert with its samples and events", Response: message})
+	spec.Describe("GET", "/api/alerts/:id/samples", openapi.Operation{Summary: "List the values an anomaly rule learned and its baseline", Query: []string{"limit"}, Response: openapi.Object{"baseline": models.AnomalyBaseline{}, "samples": []models.AlertSample{}}})
+	spec.Describe("POST", "/api/alerts/:id/snooze", openapi.Operation{Summary: "Snooze an alert until a time or for a number of minutes", Request: SnoozeAlertRequest{}, Response: models.Alert{}})
+	spec.Describe("DELETE", "/api/alerts/:id/snooze", openapi.Operation{Summary: "Lift an alert's snooze", Response: models.Alert{}})
+	spec.Describe("GET", "/api/alerts/:id/events", openapi.Operation{Summary: "List an alert's triggers, resolves and acknowledgements", Query: []string{"page", "limit"}, Response: openapi.Object{"events": []models.AlertEvent{}, "pagination": pagination}})
+	spec.Describe("POST", "/api/alerts/:id/acknowledge", openapi.Operation{Summary: "Acknowledge a triggered alert", Request: AlertActionRequest{}, Response: models.Alert{}})
+	spec.Describe("POST", "/api/alerts/:id/resolve", openapi.Operation{Summary: "Resolve a triggered alert by hand", Request: AlertActionRequest{}, Response: models.Alert{}})
+	spec.Describe("POST", "/api/channels", openapi.Operation{Summary: "Create a notification channel, returning a webhook channel's signing secret once", Request: NotificationChannelRequest{}, Response: NotificationChannelCreatedResponse{}, Status: fiber.StatusCreated})
+	spec.Describe("GET", "/api/channels", openapi.Operation{Summary: "List notification channels", Response: openapi.Object{"channels": []models.NotificationChannel{}}})
+	spec.Describe("GET", "/api/channels/:id", openapi.Operation{Summary: "Get a notification channel", Response: models.NotificationChannel{}})
+	spec.Describe("PUT", "/api/channels/:id", openapi.Operation{Summary: "Update a notification channel", Request: NotificationChannelRequest{}, Response: models.NotificationChannel{}})
+	spec.Describe("DELETE", "/api/channels/:id", openapi.Operation{Summary: "Delete a notification channel and its deliveries", Response: message})
+	spec.Describe("POST", "/api/channels/:id/test", openapi.Operation{Summary: "Send a test notification through a channel", Response: models.ChannelDelivery{}, Status: fiber.StatusAccepted})
+	spec.Describe("GET", "/api/channels/:id/deliveries", openapi.Operation{Summary: "List the deliveries of a notification channel", Query: []string{"limit"}, Response: []models.ChannelDelivery{}})
+	spec.Describe("GET", "/api/notifications", openapi.Operation{Summary: "List in-app notifications and the number unread", Query: []string{"unread", "limit"}, Response: openapi.Object{"notifications": []models.Notification{}, "unread": int64(0)}})
+	spec.Describe("POST", "/api/notifications/read", openapi.Operation{Summary: "Mark in-app notifications read, all of them without IDs", Request: MarkNotificationsReadRequest{}, Response: openapi.Object{"marked": int64(0)}})
+	spec.Describe("POST", "/api/webhooks", openapi.Operation{Summary: "Create a webhook, returning its signing secret once", Request: WebhookRequest{}, Response: WebhookCreatedResponse{}, Status: fiber.StatusCreated})
+	spec.Describe("GET", "/api/webhooks", openapi.Operation{Summary: "List webhooks and the events they can subscribe to", Response: openapi.Object{"webhooks": []models.Webhook{}, "events": []string{}}})
+	spec.Describe("GET", "/api/webhooks/:id", openapi.Operation{Summary: "Get a webhook", Response: models.Webhook{}})
+	spec.Describe("PUT", "/api/webhooks/:id", openapi.Operation{Summary: "Update a webhook", Request: WebhookRequest{}, Response: models.Webhook{}})
+	spec.Describe("DELETE", "/api/webhooks/:id", openapi.Operation{Summary: "Delete a webhook and its deliveries", Response: message})
+	spec.Describe("POST", "/api/webhooks/:id/test", openapi.Operation{Summary: "Send a ping event to a webhook", Response: models.WebhookDelivery{}, Status: fiber.StatusAccepted})
+	spec.Describe("GET", "/api/webhooks/:id/deliveries", openapi.Operation{Summary: "List the deliveries of a webhook", Query: []string{"limit"}, Response: []models.WebhookDelivery{}})
+
+	// Integrations
+	spec.Describe("GET", "/api/integrations/google", openapi.Operation{Summary: "Get whether you connected a Google account", Response: openapi.Object{"enabled": false, "connected": false, "connection": models.GoogleConnection{}}})
+	spec.Describe("POST", "/api/integrations/google/connect", openapi.Operation{Summary: "Start connecting a Google account", Response: openapi.Object{"url": ""}})
+	spec.Describe("GET", "/api/integrations/google/callback", openapi.Operation{Summary: "Complete connecting a Google account and redirect to the frontend", Security: openapi.SecurityNone, Query: []string{"code", "state", "error"}, Status: fiber.StatusFound})
+	spec.Describe("DELETE", "/api/integrations/google", openapi.Operation{Summary: "Disconnect your Google account", Response: message})
+
+	// Trash
+	spec.Describe("GET", "/api/trash", openapi.Operation{Summary: "List trashed queries and dashboards", Response: openapi.Object{"queries": []models.Query{}, "dashboards": []models.Dashboard{}}})
+
+	// Lineage
+	spec.Describe("GET", "/api/lineage", openapi.Operation{Summary: "Get the dependency graph of your connections, queries, dashboards, schedules and alerts", Query: []string{"database_id", "query_id", "dashboard_id"}, Response: models.Lineage{}})
+
+	// Search
+	spec.Describe("GET", "/api/search", openapi.Operation{Summary: "Search your queries, dashboards, tables and columns, best matches first", Query: []string{"q", "limit"}, Response: openapi.Object{"results": []models.SearchResult{}}})
+
+	// Features
+	spec.Describe("GET", "/api/features", openapi.Operation{Summary: "List the features that are on for the current user", Response: openapi.Object{"features": []string{}}})
+
+	// Admin
+	spec.Describe("GET", "/api/admin/jobs", openapi.Operation{Summary: "List background jobs", Query: []string{"status", "type", "limit"}, Response: openapi.Object{"jobs": []models.Job{}}})
+	spec.Describe("GET", "/api/admin/jobs/:id", openapi.Operation{Summary: "Get a background job", Response: models.Job{}})
+	spec.Describe("POST", "/api/admin/jobs/:id/retry", openapi.Operation{Summary: "Retry a dead background job", Response: models.Job{}})
+	spec.Describe("GET", "/api/admin/features", openapi.Operation{Summary: "List feature flags", Response: openapi.Object{"flags": []FeatureFlagResponse{}}})
+	spec.Describe("PUT", "/api/admin/features/:key", openapi.Operation{Summary: "Set a feature flag and its organization overrides", Request: FeatureFlagRequest{}, Response: models.FeatureFlag{}})
+	spec.Describe("GET", "/api/admin/audit", openapi.Operation{Summary: "List audited requests", Query: []string{"user_id", "method", "path", "since", "until", "limit"}, Response: openapi.Object{"entries": []models.AuditEntry{}}})
+	spec.Describe("GET", "/api/admin/origins", openapi.Operation{Summary: "List the origins browsers may call the API from", Response: openapi.Object{"configured": []string{}, "origins": []models.AllowedOrigin{}}})
+	spec.Describe("POST", "/api/admin/origins", openapi.Operation{Summary: "Allow an origin", Request: AllowedOriginRequest{}, Response: models.AllowedOrigin{}, Status: fiber.StatusCreated})
+	spec.Describe("DELETE", "/api/admin/origins", openapi.Operation{Summary: "Stop allowing an origin added at runtime", Query: []string{"origin"}, Response: openapi.Object{"message": ""}})
+
+	// Embeds
+	spec.Describe("GET", "/api/embed/dashboard", openapi.Operation{Summary: "Get an embedded dashboard", Security: openapi.SecurityEmbed, Response: openapi.Object{"id": primitive.ObjectID{}, "name": "", "description": "", "cards": []models.DashboardCard{}, "variables": []models.DashboardVariable{}}})
+	spec.Describe("GET", "/api/embed/cards/:cardId/data", openapi.Operation{Summary: "Get the data of an embedded card", Security: openapi.SecurityEmbed, Response: CardDataResponse{}})
+
+	// Downloads
+	spec.Describe("GET", "/api/downloads/:token", openapi.Operation{Summary: "Download an export with a signed, single-use link", Security: openapi.SecurityNone, ContentType: "application/octet-stream"})
+
+	// Gateway
+	spec.Describe("GET", "/ws", openapi.Operation{Summary: "Receive query, dashboard, schema and notification updates over a WebSocket", Query: []string{"token"}, Status: fiber.StatusSwitchingProtocols})
+	spec.Describe("GET", "/events", openapi.Operation{Summary: "Receive the gateway's updates as Server-Sent Events", Query: []string{"token", "channels", "last_event_id"}})
+
+	spec.Describe("GET", "/health", openapi.Operation{Summary: "Check the server is up", Security: openapi.SecurityNone, Response: openapi.Object{"status": ""}})
+}
+
+// OpenAPIHandler serves the OpenAPI document of the API
+func OpenAPIHandler(doc *openapi.Document) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		return c.JSON(doc)
+	}
+}
+
+// swaggerUIPage renders the OpenAPI document with Swagger UI. The integrity
+// attributes make the browser refuse assets that don't match their hashes.
+var swaggerUIPage = template.Must(template.New("swagger-ui").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+	<meta charset="utf-8">
+	<title>GoQuery API</title>
+	<link rel="stylesheet" href="{{.URL}}/swagger-ui.css"{{with .CSSIntegrity}} integrity="{{.}}"{{end}} crossorigin="anonymous" referrerpolicy="no-referrer">
+</head>
+<body>
+	<div id="swagger-ui"></div>
+	<script src="{{.URL}}/swagger-ui-bundle.js"{{with .JSIntegrity}} integrity="{{.}}"{{end}} crossorigin="anonymous" referrerpolicy="no-referrer"></script>
+	<script>
+		window.ui = SwaggerUIBundle({ url: "/api/openapi.json", dom_id: "#swagger-ui" });
+	</script>
+</body>
+</html>`))
+
+// SwaggerUIHandler serves a page for browsing and trying out the API, loading
+// Swagger UI from cfg.SwaggerUIURL
+func SwaggerUIHandler(cfg *config.Config) fiber.Handler {
+	var page strings.Builder
+	swaggerUIPage.Execute(&page, struct {
+		URL          string
+		CSSIntegrity string
+		JSIntegrity  string
+	}{cfg.SwaggerUIURL, cfg.SwaggerUICSSIntegrity, cfg.SwaggerUIJSIntegrity})
+	html := page.String()
+
+	return func(c *fiber.Ctx) error {
+		c.Type("html")
+		return c.SendString(html)
+	}
+}
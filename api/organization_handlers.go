@@ -0,0 +1,795 @@
+package api
+
+import (
+	"context"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/zucced/goquery/models"
+	"github.com/zucced/goquery/realtime"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// OrganizationRequest represents the request body for organization operations
+type OrganizationRequest struct {
+	Name string `json:"name" validate:"notblank,max=100"`
+}
+
+// OrganizationMemberRequest represents the request body for adding or updating a member
+type OrganizationMemberRequest struct {
+	Email string         `json:"email,omitempty"`
+	Role  models.OrgRole `json:"role" validate:"omitempty,oneof=member approver admin"`
+}
+
+// OrganizationAssignmentRequest represents the request body for sharing a
+// resource with an organization and optionally one of its workspaces. An empty
+// org ID and workspace ID makes it private again.
+type OrganizationAssignmentRequest struct {
+	OrgID       string `json:"org_id" validate:"omitempty,objectid"`
+	WorkspaceID string `json:"workspace_id,omitempty" validate:"omitempty,objectid"`
+}
+
+// DatabaseMembersRequest represents the request body for limiting which
+// members of its organization a database is shared with. An empty list shares
+// it with every member.
+type DatabaseMembersRequest struct {
+	UserIDs []string `json:"user_ids" validate:"dive,objectid"`
+}
+
+// resourceScope is the organization and workspace a resource is shared with
+type resourceScope struct {
+	OrgID       primitive.ObjectID
+	WorkspaceID primitive.ObjectID
+}
+
+// CreateOrganizationHandler handles creating an organization owned by the current user
+func CreateOrganizationHandler(store models.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from context
+		userID := c.Locals("user_id").(primitive.ObjectID)
+
+		// Parse and validate request body
+		var req OrganizationRequest
+		if invalid := parseRequest(c, &req); invalid != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(invalid)
+		}
+
+		name := strings.TrimSpace(req.Name)
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Get user
+		user, err := store.GetUserByID(ctx, userID)
+		if err != nil || user == nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "User not found",
+			})
+		}
+
+		// Create organization
+		org, err := store.CreateOrganization(ctx, name, user)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to create organization: " + err.Error(),
+			})
+		}
+
+		// Return response
+		return c.Status(fiber.StatusCreated).JSON(org)
+	}
+}
+
+// GetOrganizationsHandler handles listing the organizations the user belongs to
+func GetOrganizationsHandler(store models.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from context
+		userID := c.Locals("user_id").(primitive.ObjectID)
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Get organizations
+		organizations, err := store.GetOrganizationsByUserID(ctx, userID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve organizations: " + err.Error(),
+			})
+		}
+
+		// Return response
+		return c.JSON(fiber.Map{
+			"organizations": organizations,
+		})
+	}
+}
+
+// GetOrganizationHandler handles retrieving a single organization
+func GetOrganizationHandler(store models.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		org, err := loadOrganization(c, store)
+		if org == nil {
+			return err
+		}
+
+		// Return response
+		return c.JSON(org)
+	}
+}
+
+// UpdateOrganizationHandler handles renaming an organization
+func UpdateOrganizationHandler(store models.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from context
+		userID := c.Locals("user_id").(primitive.ObjectID)
+
+		// Parse and validate request body
+		var req OrganizationRequest
+		if invalid := parseRequest(c, &req); invalid != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(invalid)
+		}
+
+		name := strings.TrimSpace(req.Name)
+
+		org, err := loadOrganization(c, store)
+		if org == nil {
+			return err
+		}
+
+		// Check if user can manage organization
+		if !org.CanManage(userID) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "You don't have permission to manage this organization",
+			})
+		}
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Save organization
+		org.Name = name
+		if err := store.UpdateOrganization(ctx, org); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to update organization: " + err.Error(),
+			})
+		}
+
+		// Return response
+		return c.JSON(org)
+	}
+}
+
+// DeleteOrganizationHandler handles deleting an organization
+func DeleteOrganizationHandler(store models.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from context
+		userID := c.Locals("user_id").(primitive.ObjectID)
+
+		org, err := loadOrganization(c, store)
+		if org == nil {
+			return err
+		}
+
+		// Only the owner can delete the organization
+		if org.RoleFor(userID) != models.OrgRoleOwner {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "Only the owner can delete this organization",
+			})
+		}
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Delete organization
+		if err := store.DeleteOrganization(ctx, org.ID); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to delete organization: " + err.Error(),
+			})
+		}
+
+		// Return response
+		return c.JSON(fiber.Map{
+			"message": "Organization deleted successfully",
+		})
+	}
+}
+
+// AddOrganizationMemberHandler handles adding a registered user to an organization by email
+func AddOrganizationMemberHandler(store models.Store, gateway *realtime.Gateway) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from context
+		userID := c.Locals("user_id").(primitive.ObjectID)
+
+		// Parse and validate request body
+		var req OrganizationMemberRequest
+		if invalid := parseRequest(c, &req); invalid != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(invalid)
+		}
+
+		email := strings.TrimSpace(req.Email)
+		if email == "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Email is required",
+			})
+		}
+
+		if req.Role == "" {
+			req.Role = models.OrgRoleMember
+		}
+
+		org, err := loadOrganization(c, store)
+		if org == nil {
+			return err
+		}
+
+		// Check if user can manage organization
+		if !org.CanManage(userID) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "You don't have permission to manage this organization",
+			})
+		}
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Get the user to add
+		user, err := store.GetUserByEmail(ctx, email)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to look up user: " + err.Error(),
+			})
+		}
+		if user == nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "No user found with this email",
+			})
+		}
+
+		if org.RoleFor(user.ID) != models.OrgRoleNone {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error": "User is already a member of this organization",
+			})
+		}
+
+		// Add member
+		if err := store.AddOrganizationMember(ctx, org.ID, models.OrganizationMember{
+			UserID: user.ID,
+			Email:  user.Email,
+			Name:   user.Name,
+			Role:   req.Role,
+		}); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to add member: " + err.Error(),
+			})
+		}
+
+		// Tell the new member
+		if gateway != nil {
+			gateway.Notify(user.ID, "organization_joined", fiber.Map{
+				"organization_id": org.ID,
+				"name":            org.Name,
+				"role":            req.Role,
+			})
+		}
+
+		return respondWithOrganization(ctx, store, c, org.ID)
+	}
+}
+
+// UpdateOrganizationMemberHandler handles changing a member's role
+func UpdateOrganizationMemberHandler(store models.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from context
+		userID := c.Locals("user_id").(primitive.ObjectID)
+
+		memberID, err := primitive.ObjectIDFromHex(c.Params("userId"))
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid user ID",
+			})
+		}
+
+		// Parse and validate request body
+		var req OrganizationMemberRequest
+		if invalid := parseRequest(c, &req); invalid != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(invalid)
+		}
+
+		if req.Role == "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Role is required",
+			})
+		}
+
+		org, err := loadOrganization(c, store)
+		if org == nil {
+			return err
+		}
+
+		// Check if user can manage organization
+		if !org.CanManage(userID) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "You don't have permission to manage this organization",
+			})
+		}
+
+		switch org.RoleFor(memberID) {
+		case models.OrgRoleNone:
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Member not found",
+			})
+		case models.OrgRoleOwner:
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "The owner's role can't be changed",
+			})
+		}
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Update member role
+		if err := store.UpdateOrganizationMemberRole(ctx, org.ID, memberID, req.Role); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to update member: " + err.Error(),
+			})
+		}
+
+		return respondWithOrganization(ctx, store, c, org.ID)
+	}
+}
+
+// RemoveOrganizationMemberHandler handles removing a member from an organization.
+// Members can also remove themselves to leave it.
+func RemoveOrganizationMemberHandler(store models.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from context
+		userID := c.Locals("user_id").(primitive.ObjectID)
+
+		memberID, err := primitive.ObjectIDFromHex(c.Params("userId"))
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid user ID",
+			})
+		}
+
+		org, err := loadOrganization(c, store)
+		if org == nil {
+			return err
+		}
+
+		// Only managers can remove other members
+		if memberID != userID && !org.CanManage(userID) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "You don't have permission to manage this organization",
+			})
+		}
+
+		switch org.RoleFor(memberID) {
+		case models.OrgRoleNone:
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Member not found",
+			})
+		case models.OrgRoleOwner:
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "The owner can't leave the organization, delete it instead",
+			})
+		}
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Remove member
+		if err := store.RemoveOrganizationMember(ctx, org.ID, memberID); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to remove member: " + err.Error(),
+			})
+		}
+
+		// Return response
+		return c.JSON(fiber.Map{
+			"message": "Member removed successfully",
+		})
+	}
+}
+
+// SetDatabaseOrganizationHandler handles sharing a database connection with an organization
+func SetDatabaseOrganizationHandler(store models.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from context
+		userID := c.Locals("user_id").(primitive.ObjectID)
+
+		// Get database ID from params
+		databaseID, err := primitive.ObjectIDFromHex(c.Params("id"))
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid database ID",
+			})
+		}
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Get database
+		db, err := store.GetDatabaseByID(ctx, databaseID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve database: " + err.Error(),
+			})
+		}
+
+		if db == nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Database not found",
+			})
+		}
+
+		// Only the owner can change who the database is shared with
+		access, err := store.ResolveDatabaseAccess(ctx, db, userID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to check database access: " + err.Error(),
+			})
+		}
+		if !access.CanManage() {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "You do not have permission to share this database",
+			})
+		}
+
+		scope, err := parseOrganizationAssignment(ctx, store, c, userID)
+		if scope == nil {
+			return err
+		}
+
+		// Update organization
+		if err := store.SetDatabaseOrganization(ctx, databaseID, scope.OrgID, scope.WorkspaceID); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to update database: " + err.Error(),
+			})
+		}
+
+		// Return response
+		return c.JSON(fiber.Map{
+			"id":           databaseID,
+			"org_id":       scope.OrgID,
+			"workspace_id": scope.WorkspaceID,
+		})
+	}
+}
+
+// SetDatabaseMembersHandler handles sharing a database with specific members of
+// its organization. Members can query the database but never see its credentials.
+func SetDatabaseMembersHandler(store models.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from context
+		userID := c.Locals("user_id").(primitive.ObjectID)
+
+		// Get database ID from params
+		databaseID, err := primitive.ObjectIDFromHex(c.Params("id"))
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid database ID",
+			})
+		}
+
+		// Parse and validate request body
+		var req DatabaseMembersRequest
+		if invalid := parseRequest(c, &req); invalid != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(invalid)
+		}
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Get database
+		db, err := store.GetDatabaseByID(ctx, databaseID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve database: " + err.Error(),
+			})
+		}
+
+		if db == nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Database not found",
+			})
+		}
+
+		// Only the owner can change who the database is shared with
+		access, err := store.ResolveDatabaseAccess(ctx, db, userID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to check database access: " + err.Error(),
+			})
+		}
+		if !access.CanManage() {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "You do not have permission to share this database",
+			})
+		}
+
+		if db.OrgID.IsZero() {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Database must be shared with an organization first",
+			})
+		}
+
+		org, err := store.GetOrganizationByID(ctx, db.OrgID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve organization: " + err.Error(),
+			})
+		}
+		if org == nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Organization not found",
+			})
+		}
+
+		// Every member must belong to the database's organization
+		memberIDs := make([]primitive.ObjectID, 0, len(req.UserIDs))
+		seen := make(map[primitive.ObjectID]bool)
+		for _, id := range req.UserIDs {
+			memberID, err := primitive.ObjectIDFromHex(id)
+			if err != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": "Invalid user ID: " + id,
+				})
+			}
+			if org.RoleFor(memberID) == models.OrgRoleNone {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": "User is not a member of the organization: " + id,
+				})
+			}
+			if !seen[memberID] {
+				seen[memberID] = true
+				memberIDs = append(memberIDs, memberID)
+			}
+		}
+
+		// Update members
+		if err := store.SetDatabaseMembers(ctx, databaseID, memberIDs); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to update database: " + err.Error(),
+			})
+		}
+
+		// Return response
+		return c.JSON(fiber.Map{
+			"id":          databaseID,
+			"org_id":      db.OrgID,
+			"shared_with": memberIDs,
+		})
+	}
+}
+
+// SetQueryOrganizationHandler handles sharing a query with an organization
+func SetQueryOrganizationHandler(store models.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from context
+		userID := c.Locals("user_id").(primitive.ObjectID)
+
+		// Get query ID from params
+		queryID, err := primitive.ObjectIDFromHex(c.Params("id"))
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid query ID",
+			})
+		}
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Get query
+		query, err := store.GetQueryByID(ctx, queryID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve query: " + err.Error(),
+			})
+		}
+
+		if query == nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Query not found",
+			})
+		}
+
+		// Only the owner can change who the query is shared with
+		if query.UserID != userID {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "You don't have permission to share this query",
+			})
+		}
+
+		scope, err := parseOrganizationAssignment(ctx, store, c, userID)
+		if scope == nil {
+			return err
+		}
+
+		// Update organization
+		if err := store.SetQueryOrganization(ctx, queryID, scope.OrgID, scope.WorkspaceID); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to update query: " + err.Error(),
+			})
+		}
+
+		// Return response
+		return c.JSON(fiber.Map{
+			"id":           queryID,
+			"org_id":       scope.OrgID,
+			"workspace_id": scope.WorkspaceID,
+		})
+	}
+}
+
+// SetDashboardOrganizationHandler handles sharing a dashboard with an organization
+func SetDashboardOrganizationHandler(store models.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from context
+		userID := c.Locals("user_id").(primitive.ObjectID)
+
+		// Get dashboard ID from params
+		dashboardID, err := primitive.ObjectIDFromHex(c.Params("id"))
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid dashboard ID",
+			})
+		}
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Get dashboard
+		dashboard, err := store.GetDashboardByID(ctx, dashboardID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve dashboard: " + err.Error(),
+			})
+		}
+
+		if dashboard == nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Dashboard not found",
+			})
+		}
+
+		// Only the owner can change who the dashboard is shared with
+		if dashboard.UserID != userID {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "You don't have permission to share this dashboard",
+			})
+		}
+
+		scope, err := parseOrganizationAssignment(ctx, store, c, userID)
+		if scope == nil {
+			return err
+		}
+
+		// Update organization
+		if err := store.SetDashboardOrganization(ctx, dashboardID, scope.OrgID, scope.WorkspaceID); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to update dashboard: " + err.Error(),
+			})
+		}
+
+		// Return response
+		return c.JSON(fiber.Map{
+			"id":           dashboardID,
+			"org_id":       scope.OrgID,
+			"workspace_id": scope.WorkspaceID,
+		})
+	}
+}
+
+// loadOrganization resolves the organization in the request path and checks the
+// user is a member. When the organization is nil the returned error is the
+// response already written.
+func loadOrganization(c *fiber.Ctx, store models.Store) (*models.Organization, error) {
+	// Get user ID from context
+	userID := c.Locals("user_id").(primitive.ObjectID)
+
+	// Get organization ID from params
+	orgID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return nil, c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid organization ID",
+		})
+	}
+
+	// Get the request context
+	ctx := c.UserContext()
+
+	// Get organization
+	org, err := store.GetOrganizationByID(ctx, orgID)
+	if err != nil {
+		return nil, c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to retrieve organization: " + err.Error(),
+		})
+	}
+
+	// Non-members can't tell whether the organization exists
+	if org == nil || org.RoleFor(userID) == models.OrgRoleNone {
+		return nil, c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Organization not found",
+		})
+	}
+
+	return org, nil
+}
+
+// parseOrganizationAssignment reads the organization and workspace a resource
+// should be shared with and checks the user belongs to the organization. The
+// organization may be omitted when a workspace is given. A zero scope means the
+// resource becomes private. When the scope is nil the returned error is the
+// response already written.
+func parseOrganizationAssignment(ctx context.Context, store models.Store, c *fiber.Ctx, userID primitive.ObjectID) (*resourceScope, error) {
+	// Parse and validate request body
+	var req OrganizationAssignmentRequest
+	if invalid := parseRequest(c, &req); invalid != nil {
+		return nil, c.Status(fiber.StatusBadRequest).JSON(invalid)
+	}
+
+	scope := &resourceScope{}
+	if req.OrgID != "" {
+		orgID, err := primitive.ObjectIDFromHex(req.OrgID)
+		if err != nil {
+			return nil, c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid organization ID",
+			})
+		}
+		scope.OrgID = orgID
+	}
+
+	if req.WorkspaceID != "" {
+		workspaceID, err := primitive.ObjectIDFromHex(req.WorkspaceID)
+		if err != nil {
+			return nil, c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid workspace ID",
+			})
+		}
+
+		workspace, err := store.GetWorkspaceByID(ctx, workspaceID)
+		if err != nil {
+			return nil, c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve workspace: " + err.Error(),
+			})
+		}
+		if workspace == nil || (!scope.OrgID.IsZero() && workspace.OrgID != scope.OrgID) {
+			return nil, c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Workspace not found",
+			})
+		}
+
+		scope.OrgID = workspace.OrgID
+		scope.WorkspaceID = workspace.ID
+	}
+
+	if scope.OrgID.IsZero() {
+		return scope, nil
+	}
+
+	member, err := store.IsOrganizationMember(ctx, scope.OrgID, userID)
+	if err != nil {
+		return nil, c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to check organization membership: " + err.Error(),
+		})
+	}
+	if !member {
+		return nil, c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Organization not found",
+		})
+	}
+
+	return scope, nil
+}
+
+// respondWithOrganization writes the current state of an organization
+func respondWithOrganization(ctx context.Context, store models.Store, c *fiber.Ctx, orgID primitive.ObjectID) error {
+	org, err := store.GetOrganizationByID(ctx, orgID)
+	if err != nil || org == nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to retrieve updated organization",
+		})
+	}
+	return c.JSON(org)
+}
@@ -0,0 +1,114 @@
+package api
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/zucced/goquery/config"
+	"github.com/zucced/goquery/models"
+	"github.com/zucced/goquery/origins"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// AllowedOriginRequest represents the request body for allowing an origin
+type AllowedOriginRequest struct {
+	Origin string `json:"origin" validate:"required,max=300"`
+	Note   string `json:"note" validate:"max=200"`
+}
+
+// GetAllowedOriginsHandler handles listing the origins browsers may call the
+// API from, configured and added at runtime
+func GetAllowedOriginsHandler(store models.Store, allowlist *origins.Allowlist) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Get stored origins
+		stored, err := store.GetAllowedOrigins(ctx)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve allowed origins: " + err.Error(),
+			})
+		}
+
+		// Return response
+		return c.JSON(fiber.Map{
+			"configured": allowlist.Configured(),
+			"origins":    stored,
+		})
+	}
+}
+
+// AddAllowedOriginHandler handles allowing an origin at runtime
+func AddAllowedOriginHandler(allowlist *origins.Allowlist) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from context
+		userID := c.Locals("user_id").(primitive.ObjectID)
+
+		// Parse request
+		var req AllowedOriginRequest
+		if invalid := parseRequest(c, &req); invalid != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(invalid)
+		}
+
+		// Check the origin
+		origin, err := allowlist.Normalize(req.Origin)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid origin: " + err.Error(),
+			})
+		}
+
+		allowed := &models.AllowedOrigin{
+			Origin:    origin,
+			Note:      req.Note,
+			CreatedBy: userID,
+		}
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Save origin
+		if err := allowlist.Add(ctx, allowed); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to allow origin: " + err.Error(),
+			})
+		}
+
+		// Return response
+		return c.Status(fiber.StatusCreated).JSON(allowed)
+	}
+}
+
+// RemoveAllowedOriginHandler handles no longer allowing an origin added at
+// runtime. Configured origins can only be removed from ALLOW_ORIGINS.
+func RemoveAllowedOriginHandler(allowlist *origins.Allowlist) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get origin from query, as stored
+		origin, err := config.NormalizeOrigin(c.Query("origin"))
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid origin: " + err.Error(),
+			})
+		}
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Delete origin
+		deleted, err := allowlist.Remove(ctx, origin)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to remove origin: " + err.Error(),
+			})
+		}
+		if !deleted {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Origin not found",
+			})
+		}
+
+		// Return response
+		return c.JSON(fiber.Map{
+			"message": "Origin removed successfully",
+		})
+	}
+}
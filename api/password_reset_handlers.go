@@ -0,0 +1,186 @@
+package api
+
+import (
+	"context"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/zucced/goquery/config"
+	"github.com/zucced/goquery/limiter"
+	"github.com/zucced/goquery/mailer"
+	"github.com/zucced/goquery/models"
+	"github.com/zucced/goquery/workers"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ForgotPasswordRequest represents the request body for requesting a password reset
+type ForgotPasswordRequest struct {
+	Email string `json:"email" validate:"notblank"`
+}
+
+// ResetPasswordRequest represents the request body for setting a new password
+type ResetPasswordRequest struct {
+	Token    string `json:"token" validate:"required"`
+	Password string `json:"password" validate:"required,min=8,max=72"`
+}
+
+// maxPasswordResetsPerHour caps the reset emails sent to a single account
+const maxPasswordResetsPerHour = 3
+
+// passwordResetTimeout bounds looking up the account and sending its reset
+// email, which happen after the request is answered
+const passwordResetTimeout = time.Minute
+
+// forgotPasswordMessage is returned whether or not the email has an account,
+// so the endpoint can't be used to find registered addresses
+const forgotPasswordMessage = "If an account exists for this email, a password reset link has been sent"
+
+// ForgotPasswordHandler handles issuing a password reset token and emailing it to the user
+func ForgotPasswordHandler(store models.Store, cfg *config.Config, mail mailer.Mailer, rateLimiter *limiter.RateLimiter) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Limit requests per client
+		if !rateLimiter.Allow("forgot:" + c.IP()) {
+			return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+				"error": "Too many password reset requests, please try again later",
+			})
+		}
+
+		// Parse and validate request body
+		var req ForgotPasswordRequest
+		if invalid := parseRequest(c, &req); invalid != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(invalid)
+		}
+
+		// Look up the account and send the email in the background, so
+		// the response takes as long whether or not the email has an account.
+		// Shutting down waits for it like a worker job.
+		email := strings.TrimSpace(req.Email)
+		ctx := context.WithoutCancel(c.UserContext())
+		workers.Go(func() {
+			sendPasswordReset(ctx, store, cfg, mail, email)
+		})
+
+		// Return response
+		return c.JSON(fiber.Map{"message": forgotPasswordMessage})
+	}
+}
+
+// sendPasswordReset issues a reset token for the account of an email and
+// emails its link. Nothing is sent when there's no account or it had too many
+// resets in the last hour. Failures are only logged, since the request they
+// came from has already been answered.
+func sendPasswordReset(ctx context.Context, store models.Store, cfg *config.Config, mail mailer.Mailer, email string) {
+	ctx, cancel := context.WithTimeout(ctx, passwordResetTimeout)
+	defer cancel()
+
+	// Get user by email
+	user, err := store.GetUserByEmail(ctx, email)
+	if err != nil {
+		log.Printf("Failed to look up user for password reset: %v", err)
+		return
+	}
+	if user == nil {
+		return
+	}
+
+	// Limit reset emails per account
+	count, err := store.CountPasswordResetsSince(ctx, user.ID, time.Now().Add(-time.Hour))
+	if err != nil {
+		log.Printf("Failed to count password resets of %s: %v", user.Email, err)
+		return
+	}
+	if count >= maxPasswordResetsPerHour {
+		return
+	}
+
+	// Issue token
+	token, err := store.CreatePasswordResetToken(ctx, user.ID, cfg.PasswordResetExpiry)
+	if err != nil {
+		log.Printf("Failed to create password reset token for %s: %v", user.Email, err)
+		return
+	}
+
+	// Email the reset link. It is sent right away rather than queued, so
+	// the token isn't stored anywhere but in its hashed form.
+	link := cfg.FrontendURL + "/reset-password?token=" + url.QueryEscape(token)
+	msg, err := passwordResetMessage(user, link, cfg.PasswordResetExpiry)
+	if err == nil {
+		err = mail.Send(ctx, msg)
+	}
+	if err != nil {
+		log.Printf("Failed to send password reset email to %s: %v", user.Email, err)
+	}
+}
+
+// ResetPasswordHandler handles setting a new password with a reset token
+func ResetPasswordHandler(store models.Store, rateLimiter *limiter.RateLimiter) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Limit requests per client
+		if !rateLimiter.Allow("reset:" + c.IP()) {
+			return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+				"error": "Too many password reset attempts, please try again later",
+			})
+		}
+
+		// Parse and validate request body
+		var req ResetPasswordRequest
+		if invalid := parseRequest(c, &req); invalid != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(invalid)
+		}
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Use up the token
+		reset, err := store.ConsumePasswordResetToken(ctx, req.Token)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to reset password: " + err.Error(),
+			})
+		}
+		if reset == nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid or expired reset token",
+			})
+		}
+
+		// Update password
+		if err := store.UpdatePassword(ctx, reset.UserID, req.Password); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to reset password: " + err.Error(),
+			})
+		}
+
+		// Any other outstanding links stop working once the password has changed
+		if err := store.InvalidatePasswordResetTokens(ctx, reset.UserID); err != nil {
+			log.Printf("Failed to invalidate password reset tokens for user %s: %v", reset.UserID.Hex(), err)
+		}
+
+		// Sign out every device that used the old password
+		if _, err := store.RevokeUserSessions(ctx, reset.UserID, primitive.NilObjectID); err != nil {
+			log.Printf("Failed to revoke sessions for user %s: %v", reset.UserID.Hex(), err)
+		}
+
+		// Return response
+		return c.JSON(fiber.Map{
+			"message": "Password has been reset",
+		})
+	}
+}
+
+// passwordResetMessage builds the email containing a password reset link
+func passwordResetMessage(user *models.User, link string, expiry time.Duration) (*mailer.Message, error) {
+	name := user.Name
+	if name == "" {
+		name = user.Email
+	}
+
+	return mailer.Render(mailer.TemplatePasswordReset, []string{user.Email}, fiber.Map{
+		"Name":   name,
+		"Link":   link,
+		"Expiry": expiry,
+	})
+}
@@ -0,0 +1,138 @@
+package api
+
+import (
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/zucced/goquery/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// PivotDimensionRequest represents a column the pivot groups by, dates
+// optionally by a bucket
+type PivotDimensionRequest struct {
+	Column string `json:"column" validate:"notblank,max=200"`
+	Bucket string `json:"bucket" validate:"omitempty,oneof=day week month year"`
+}
+
+// PivotValueRequest represents a column aggregated in each cell of the pivot
+type PivotValueRequest struct {
+	Column string `json:"column" validate:"required_unless=Agg count,max=200"`
+	Agg    string `json:"agg" validate:"oneof=sum avg count min max"`
+}
+
+// PivotRequest represents the request body for pivoting a query's results.
+// Dates are bucketed in the user's timezone unless one is given.
+type PivotRequest struct {
+	Rows     []PivotDimensionRequest `json:"rows" validate:"max=3,dive"`
+	Columns  []PivotDimensionRequest `json:"columns" validate:"max=3,dive"`
+	Values   []PivotValueRequest     `json:"values" validate:"min=1,max=10,dive"`
+	Timezone string                  `json:"timezone" validate:"omitempty,timezone"`
+}
+
+// definition returns the pivot definition the request describes
+func (req *PivotRequest) definition() models.PivotDefinition {
+	dimensions := func(requested []PivotDimensionRequest) []models.PivotDimension {
+		dimensions := make([]models.PivotDimension, len(requested))
+		for i, dimension := range requested {
+			dimensions[i] = models.PivotDimension{
+				Column: strings.TrimSpace(dimension.Column),
+				Bucket: models.DateBucket(dimension.Bucket),
+			}
+		}
+		return dimensions
+	}
+
+	values := make([]models.PivotValue, len(req.Values))
+	for i, value := range req.Values {
+		values[i] = models.PivotValue{
+			Column:      strings.TrimSpace(value.Column),
+			Aggregation: models.ChartAggregation(value.Agg),
+		}
+	}
+
+	return models.PivotDefinition{
+		Rows:    dimensions(req.Rows),
+		Columns: dimensions(req.Columns),
+		Values:  values,
+	}
+}
+
+// PivotQueryHandler handles computing a pivot table over a query's stored
+// results, without running the query again
+func PivotQueryHandler(store models.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from context
+		userID := c.Locals("user_id").(primitive.ObjectID)
+
+		// Get query ID from params
+		queryID, err := primitive.ObjectIDFromHex(c.Params("id"))
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid query ID",
+			})
+		}
+
+		// Parse and validate request body
+		var req PivotRequest
+		if invalid := parseRequest(c, &req); invalid != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(invalid)
+		}
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Get query
+		query, err := store.GetQueryByID(ctx, queryID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve query: " + err.Error(),
+			})
+		}
+
+		if query == nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Query not found",
+			})
+		}
+
+		// Check if user can access query
+		allowed, err := store.CanAccessQuery(ctx, query, userID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to check query access: " + err.Error(),
+			})
+		}
+		if !allowed {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "You don't have permission to access this query",
+			})
+		}
+
+		// Mask the results the user may not see
+		if err := maskQuery(c, store, query); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to mask results: " + err.Error(),
+			})
+		}
+
+		// Bucket dates in the requested timezone, falling back to the user's
+		location, err := chartLocation(ctx, store, req.Timezone, userID)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+
+		// Pivot the stored results
+		pivot, err := models.BuildPivot(query.Results, req.definition(), location)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+
+		// Return response
+		return c.JSON(pivot)
+	}
+}
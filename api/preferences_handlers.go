@@ -0,0 +1,110 @@
+package api
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/zucced/goquery/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// PreferencesRequest represents the request body for updating user preferences
+type PreferencesRequest struct {
+	Timezone           string `json:"timezone" validate:"omitempty,timezone"`
+	Locale             string `json:"locale"`
+	DefaultDatabaseID  string `json:"default_database_id" validate:"omitempty,objectid"`
+	DefaultResultLimit int    `json:"default_result_limit"`
+}
+
+// GetPreferencesHandler handles retrieving the current user's preferences
+func GetPreferencesHandler(store models.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from context
+		userID := c.Locals("user_id").(primitive.ObjectID)
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Get preferences
+		preferences, err := store.GetUserPreferences(ctx, userID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve preferences: " + err.Error(),
+			})
+		}
+
+		// Return response
+		return c.JSON(preferences)
+	}
+}
+
+// UpdatePreferencesHandler handles replacing the current user's preferences
+func UpdatePreferencesHandler(store models.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from context
+		userID := c.Locals("user_id").(primitive.ObjectID)
+
+		// Parse and validate request body
+		var req PreferencesRequest
+		if invalid := parseRequest(c, &req); invalid != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(invalid)
+		}
+
+		preferences := &models.UserPreferences{
+			Timezone:           req.Timezone,
+			Locale:             req.Locale,
+			DefaultResultLimit: req.DefaultResultLimit,
+		}
+
+		// Validate preferences
+		if err := preferences.Validate(); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// The default database must be one the user can query
+		if req.DefaultDatabaseID != "" {
+			databaseID, err := primitive.ObjectIDFromHex(req.DefaultDatabaseID)
+			if err != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": "Invalid database ID",
+				})
+			}
+
+			db, err := store.GetDatabaseByID(ctx, databaseID)
+			if err != nil {
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+					"error": "Failed to retrieve database: " + err.Error(),
+				})
+			}
+
+			allowed := false
+			if db != nil {
+				if allowed, err = store.CanAccessDatabase(ctx, db, userID); err != nil {
+					return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+						"error": "Failed to check database access: " + err.Error(),
+					})
+				}
+			}
+			if !allowed {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": "Database not found",
+				})
+			}
+
+			preferences.DefaultDatabaseID = databaseID
+		}
+
+		// Save preferences
+		if err := store.UpdateUserPreferences(ctx, userID, preferences); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to update preferences: " + err.Error(),
+			})
+		}
+
+		// Return response
+		return c.JSON(preferences)
+	}
+}
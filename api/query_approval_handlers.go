@@ -0,0 +1,351 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/zucced/goquery/config"
+	"github.com/zucced/goquery/features"
+	"github.com/zucced/goquery/limiter"
+	"github.com/zucced/goquery/mailer"
+	"github.com/zucced/goquery/models"
+	"github.com/zucced/goquery/realtime"
+	"github.com/zucced/goquery/webhooks"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// RejectQueryRequest represents the request body for rejecting a query
+type RejectQueryRequest struct {
+	Reason string `json:"reason" validate:"max=1000"`
+}
+
+// requestApproval parks a query until an approver of the connection's
+// organization approves it, and tells the approvers about it
+func requestApproval(c *fiber.Ctx, ctx context.Context, store models.Store, cfg *config.Config, gateway *realtime.Gateway, hooks *webhooks.Dispatcher, mailQueue mailer.Mailer, db *models.Database, query *models.Query) error {
+	// Approvers are members of the organization the connection is shared with
+	var org *models.Organization
+	if !db.OrgID.IsZero() {
+		var err error
+		org, err = store.GetOrganizationByID(ctx, db.OrgID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve organization: " + err.Error(),
+			})
+		}
+	}
+
+	if org == nil {
+		query.Status = models.QueryStatusFailed
+		query.Error = "Queries against this database must be approved, but it isn't shared with an organization that has approvers"
+		saveQueryStatus(ctx, store, gateway, hooks, query)
+
+		maskQuery(c, store, query)
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": query.Error,
+			"query": query,
+		})
+	}
+
+	query.Status = models.QueryStatusPendingApproval
+	query.Error = ""
+	query.Approval = &models.QueryApproval{
+		OrgID:       org.ID,
+		SQL:         query.GeneratedSQL,
+		RequestedAt: time.Now(),
+	}
+	if err := saveQueryStatus(ctx, store, gateway, hooks, query); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to update query: " + err.Error(),
+		})
+	}
+
+	notifyApprovers(ctx, store, cfg, gateway, mailQueue, org, db, query)
+
+	maskQuery(c, store, query)
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
+		"message":     "Queries against " + describeEnvironment(db) + " must be approved before they run",
+		"environment": db.Environment,
+		"query":       query,
+	})
+}
+
+// notifyApprovers tells the approvers of an organization that a query waits
+// for them, through the gateway and by email
+func notifyApprovers(ctx context.Context, store models.Store, cfg *config.Config, gateway *realtime.Gateway, mailQueue mailer.Mailer, org *models.Organization, db *models.Database, query *models.Query) {
+	requestedBy := "Someone"
+	if requester, err := store.GetUserByID(ctx, query.UserID); err == nil && requester != nil {
+		requestedBy = requester.Name
+		if requestedBy == "" {
+			requestedBy = requester.Email
+		}
+	}
+
+	link := cfg.FrontendURL + "/queries/" + query.ID.Hex()
+	for _, approver := range org.Approvers(query.UserID) {
+		if gateway != nil {
+			gateway.Notify(approver.UserID, "query_approval_requested", fiber.Map{
+				"query_id":     query.ID,
+				"name":         query.Name,
+				"database_id":  db.ID,
+				"database":     db.Name,
+				"requested_by": query.UserID,
+			})
+		}
+
+		msg, err := mailer.Render(mailer.TemplateQueryApproval, []string{approver.Email}, fiber.Map{
+			"RequestedBy": requestedBy,
+			"Query":       query.Name,
+			"Database":    db.Name,
+			"SQL":         query.GeneratedSQL,
+			"Link":        link,
+		})
+		if err == nil {
+			err = mailQueue.Send(ctx, msg)
+		}
+		if err != nil && !errors.Is(err, mailer.ErrNotConfigured) {
+			log.Printf("Failed to send approval request to %s: %v", approver.Email, err)
+		}
+	}
+}
+
+// GetPendingApprovalsHandler handles listing the queries waiting for the
+// user's approval, across the organizations they approve queries for
+func GetPendingApprovalsHandler(store models.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from context
+		userID := c.Locals("user_id").(primitive.ObjectID)
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Find the organizations the user approves queries for
+		orgs, err := store.GetOrganizationsByUserID(ctx, userID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve organizations: " + err.Error(),
+			})
+		}
+
+		orgIDs := []primitive.ObjectID{}
+		for _, org := range orgs {
+			if org.CanApprove(userID) {
+				orgIDs = append(orgIDs, org.ID)
+			}
+		}
+
+		// Get pending queries, without their results
+		queries, err := store.GetPendingApprovals(ctx, orgIDs, userID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve pending approvals: " + err.Error(),
+			})
+		}
+
+		// Return response
+		return c.JSON(fiber.Map{
+			"queries": queries,
+		})
+	}
+}
+
+// ApproveQueryHandler handles approving a query waiting for approval, which
+// then runs right away for its owner
+func ApproveQueryHandler(store models.Store, execLimiter *limiter.ExecutionLimiter, gateway *realtime.Gateway, hooks *webhooks.Dispatcher, flags *features.Service) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from context
+		userID := c.Locals("user_id").(primitive.ObjectID)
+
+		query, db, err := loadQueryForApproval(c, store)
+		if query == nil {
+			return err
+		}
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Writes may have been disabled, or the owner lost the connection, since
+		// the query was generated
+		if query.IsWrite {
+			blocked, err := writeQueriesBlocked(ctx, store, flags, db, query.UserID)
+			if err != nil {
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+					"error": "Failed to check database access: " + err.Error(),
+				})
+			}
+			if blocked != "" {
+				return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+					"error": blocked,
+				})
+			}
+		}
+
+		// Wait for a free execution slot for the owner and database
+		release, err := execLimiter.Acquire(ctx, query.UserID.Hex(), db.ID.Hex())
+		if err != nil {
+			return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		defer release()
+
+		// Record the decision, unless another approver was first
+		decided, err := store.DecideQueryApproval(ctx, query, userID, true, "")
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to approve query: " + err.Error(),
+			})
+		}
+		if !decided {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error": "Query is no longer awaiting approval",
+			})
+		}
+		notifyQueryReviewed(gateway, query)
+
+		// Execute the approved query
+		return runHeldQuery(c, ctx, store, gateway, hooks, db, query)
+	}
+}
+
+// RejectQueryHandler handles rejecting a query waiting for approval, which
+// fails it with the reason given
+func RejectQueryHandler(store models.Store, gateway *realtime.Gateway, hooks *webhooks.Dispatcher) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from context
+		userID := c.Locals("user_id").(primitive.ObjectID)
+
+		// Parse and validate request body, the reason is optional
+		var req RejectQueryRequest
+		if len(c.Body()) > 0 {
+			if invalid := parseRequest(c, &req); invalid != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(invalid)
+			}
+		}
+
+		query, _, err := loadQueryForApproval(c, store)
+		if query == nil {
+			return err
+		}
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Record the decision, unless another approver was first
+		decided, err := store.DecideQueryApproval(ctx, query, userID, false, req.Reason)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to reject query: " + err.Error(),
+			})
+		}
+		if !decided {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error": "Query is no longer awaiting approval",
+			})
+		}
+		publishQueryStatus(gateway, query)
+		hooks.QueryFinished(query)
+		notifyQueryReviewed(gateway, query)
+
+		// Return response
+		return sendQuery(c, store, query)
+	}
+}
+
+// loadQueryForApproval resolves the query in the request path and checks it
+// waits for approval and the user can approve it. When the query is nil the
+// returned error is the response already written.
+func loadQueryForApproval(c *fiber.Ctx, store models.Store) (*models.Query, *models.Database, error) {
+	// Get user ID from context
+	userID := c.Locals("user_id").(primitive.ObjectID)
+
+	// Get query ID from params
+	queryID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return nil, nil, c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid query ID",
+		})
+	}
+
+	// Get the request context
+	ctx := c.UserContext()
+
+	// Get the pending query
+	query, err := store.GetQueryByID(ctx, queryID)
+	if err != nil {
+		return nil, nil, c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to retrieve query: " + err.Error(),
+		})
+	}
+
+	if query == nil {
+		return nil, nil, c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Query not found",
+		})
+	}
+
+	// Only queries waiting for approval can be approved or rejected
+	if query.Status != models.QueryStatusPendingApproval || query.Approval == nil {
+		return nil, nil, c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": "Query is not awaiting approval",
+		})
+	}
+
+	// Nobody approves their own queries
+	if query.UserID == userID {
+		return nil, nil, c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "You can't approve or reject your own query",
+		})
+	}
+
+	// Get the database
+	db, err := store.GetDatabaseByID(ctx, query.DatabaseID)
+	if err != nil {
+		return nil, nil, c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to retrieve database: " + err.Error(),
+		})
+	}
+
+	if db == nil {
+		return nil, nil, c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Database not found",
+		})
+	}
+
+	// The approvers are those of the organization the connection is shared
+	// with now, which may differ from when approval was requested
+	var org *models.Organization
+	if !db.OrgID.IsZero() {
+		org, err = store.GetOrganizationByID(ctx, db.OrgID)
+		if err != nil {
+			return nil, nil, c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve organization: " + err.Error(),
+			})
+		}
+	}
+
+	if org == nil || !org.CanApprove(userID) {
+		return nil, nil, c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "You don't have permission to approve queries against this database",
+		})
+	}
+
+	return query, db, nil
+}
+
+// notifyQueryReviewed tells the owner of a query an approver decided on it
+func notifyQueryReviewed(gateway *realtime.Gateway, query *models.Query) {
+	if gateway == nil {
+		return
+	}
+
+	gateway.Notify(query.UserID, "query_reviewed", fiber.Map{
+		"query_id":    query.ID,
+		"name":        query.Name,
+		"decision":    query.Approval.Decision,
+		"reason":      query.Approval.Reason,
+		"reviewer_id": query.Approval.ReviewerID,
+	})
+}
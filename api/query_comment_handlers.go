@@ -0,0 +1,287 @@
+package api
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/zucced/goquery/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// QueryCommentRequest represents the request body for commenting on a query.
+// A comment can be about one of the query's scheduled runs, and about a row
+// of its results by index, optionally a single column of it.
+type QueryCommentRequest struct {
+	Body   string `json:"body" validate:"notblank,max=5000"`
+	RunID  string `json:"run_id" validate:"omitempty,objectid"`
+	Row    *int   `json:"row" validate:"omitnil,min=0"`
+	Column string `json:"column" validate:"max=200"`
+}
+
+// QueryCommentUpdateRequest represents the request body for editing a comment
+type QueryCommentUpdateRequest struct {
+	Body string `json:"body" validate:"notblank,max=5000"`
+}
+
+// GetQueryCommentsHandler handles listing the comments on a query, oldest
+// first, with pagination
+func GetQueryCommentsHandler(store models.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get pagination parameters from query
+		page, err := strconv.ParseInt(c.Query("page", "1"), 10, 64)
+		if err != nil || page < 1 {
+			page = 1
+		}
+
+		limit, err := strconv.ParseInt(c.Query("limit", "50"), 10, 64)
+		if err != nil || limit < 1 || limit > 100 {
+			limit = 50
+		}
+
+		// Only list the comments about a run, when one is given
+		var runID primitive.ObjectID
+		if raw := c.Query("run_id"); raw != "" {
+			runID, err = primitive.ObjectIDFromHex(raw)
+			if err != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": "Invalid run ID",
+				})
+			}
+		}
+
+		query, err := loadAccessibleQuery(c, store)
+		if query == nil {
+			return err
+		}
+
+		// Get comments
+		comments, totalCount, err := store.GetQueryComments(c.UserContext(), query.ID, runID, page, limit)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve comments: " + err.Error(),
+			})
+		}
+
+		// Mask the rows the user may not see
+		if err := maskCommentRows(c, store, query, comments...); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to mask results: " + err.Error(),
+			})
+		}
+
+		// Calculate pagination metadata
+		totalPages := (totalCount + limit - 1) / limit
+
+		// Return response with pagination metadata
+		return c.JSON(fiber.Map{
+			"comments": comments,
+			"pagination": fiber.Map{
+				"total": totalCount,
+				"page":  page,
+				"limit": limit,
+				"pages": totalPages,
+			},
+		})
+	}
+}
+
+// CreateQueryCommentHandler handles commenting on a query
+func CreateQueryCommentHandler(store models.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from context
+		userID := c.Locals("user_id").(primitive.ObjectID)
+
+		// Parse and validate request body
+		var req QueryCommentRequest
+		if invalid := parseRequest(c, &req); invalid != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(invalid)
+		}
+
+		query, err := loadAccessibleQuery(c, store)
+		if query == nil {
+			return err
+		}
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		comment := &models.QueryComment{
+			QueryID: query.ID,
+			UserID:  userID,
+			Body:    strings.TrimSpace(req.Body),
+		}
+
+		// The run must be one of this query's
+		if req.RunID != "" {
+			runID, _ := primitive.ObjectIDFromHex(req.RunID)
+			run, err := store.GetQueryRun(ctx, query.ID, runID)
+			if err != nil {
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+					"error": "Failed to retrieve query run: " + err.Error(),
+				})
+			}
+			if run == nil {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": "The run doesn't belong to this query",
+				})
+			}
+			comment.RunID = run.ID
+		}
+
+		// Keep a copy of the row, the results change when the query is rerun
+		column := strings.TrimSpace(req.Column)
+		if req.Row != nil {
+			if *req.Row >= len(query.Results) {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": "The query's results have no row " + strconv.Itoa(*req.Row),
+				})
+			}
+			row := query.Results[*req.Row]
+			if column != "" {
+				if _, ok := row[column]; !ok {
+					return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+						"error": "The row has no column " + strconv.Quote(column),
+					})
+				}
+			}
+			comment.Row = req.Row
+			comment.Column = column
+			comment.RowValues = row
+		} else if column != "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "A column can only be given with a row",
+			})
+		}
+
+		// Get the author
+		user, err := store.GetUserByID(ctx, userID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve user: " + err.Error(),
+			})
+		}
+		if user != nil {
+			comment.AuthorName = user.Name
+			comment.AuthorEmail = user.Email
+		}
+
+		// Save comment
+		if err := store.CreateQueryComment(ctx, comment); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to create comment: " + err.Error(),
+			})
+		}
+
+		// Return response
+		return c.Status(fiber.StatusCreated).JSON(comment)
+	}
+}
+
+// UpdateQueryCommentHandler handles editing a comment, which only its author
+// can do
+func UpdateQueryCommentHandler(store models.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from context
+		userID := c.Locals("user_id").(primitive.ObjectID)
+
+		// Parse and validate request body
+		var req QueryCommentUpdateRequest
+		if invalid := parseRequest(c, &req); invalid != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(invalid)
+		}
+
+		query, comment, err := loadQueryComment(c, store)
+		if comment == nil {
+			return err
+		}
+
+		if comment.UserID != userID {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "Only the author can edit a comment",
+			})
+		}
+
+		// Update comment
+		if err := store.UpdateQueryCommentBody(c.UserContext(), comment, strings.TrimSpace(req.Body)); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to update comment: " + err.Error(),
+			})
+		}
+
+		// Mask the row the user may not see
+		if err := maskCommentRows(c, store, query, comment); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to mask results: " + err.Error(),
+			})
+		}
+
+		// Return response
+		return c.JSON(comment)
+	}
+}
+
+// DeleteQueryCommentHandler handles deleting a comment, which its author and
+// the query's owner can do
+func DeleteQueryCommentHandler(store models.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from context
+		userID := c.Locals("user_id").(primitive.ObjectID)
+
+		query, comment, err := loadQueryComment(c, store)
+		if comment == nil {
+			return err
+		}
+
+		if comment.UserID != userID && query.UserID != userID {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "Only the author or the query's owner can delete a comment",
+			})
+		}
+
+		// Delete comment
+		if err := store.DeleteQueryComment(c.UserContext(), comment.ID); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to delete comment: " + err.Error(),
+			})
+		}
+
+		// Return response
+		return c.JSON(fiber.Map{
+			"message": "Comment deleted successfully",
+		})
+	}
+}
+
+// loadQueryComment resolves the query and comment in the request path. When
+// the comment is nil the returned error is the response already written.
+func loadQueryComment(c *fiber.Ctx, store models.Store) (*models.Query, *models.QueryComment, error) {
+	// Get comment ID from params
+	commentID, err := primitive.ObjectIDFromHex(c.Params("commentId"))
+	if err != nil {
+		return nil, nil, c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid comment ID",
+		})
+	}
+
+	query, err := loadAccessibleQuery(c, store)
+	if query == nil {
+		return nil, nil, err
+	}
+
+	// Get comment
+	comment, err := store.GetQueryComment(c.UserContext(), query.ID, commentID)
+	if err != nil {
+		return nil, nil, c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to retrieve comment: " + err.Error(),
+		})
+	}
+
+	if comment == nil {
+		return nil, nil, c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Comment not found",
+		})
+	}
+
+	return query, comment, nil
+}
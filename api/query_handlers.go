@@ -8,32 +8,58 @@ import (
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/zucced/goquery/ai"
+	"github.com/zucced/goquery/alerts"
 	"github.com/zucced/goquery/config"
+	"github.com/zucced/goquery/features"
+	"github.com/zucced/goquery/limiter"
+	"github.com/zucced/goquery/mailer"
 	"github.com/zucced/goquery/models"
+	"github.com/zucced/goquery/realtime"
+	"github.com/zucced/goquery/webhooks"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 // QueryRequest represents the request body for query operations
 type QueryRequest struct {
-	DatabaseID string `json:"database_id"`
-	Query      string `json:"query"`
-	Name       string `json:"name,omitempty"`
+	DatabaseID string   `json:"database_id" validate:"omitempty,objectid"`
+	Query      string   `json:"query"`
+	Name       string   `json:"name,omitempty" validate:"max=200"`
+	Tags       []string `json:"tags,omitempty"`
+}
+
+// QueryTagsRequest represents the request body for setting query tags
+type QueryTagsRequest struct {
+	Tags []string `json:"tags"`
 }
 
 // CreateQueryHandler handles creating and executing a new query
-func CreateQueryHandler(cfg *config.Config) fiber.Handler {
+func CreateQueryHandler(store models.Store, cfg *config.Config, execLimiter *limiter.ExecutionLimiter, gateway *realtime.Gateway, hooks *webhooks.Dispatcher, mailQueue mailer.Mailer, flags *features.Service) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		// Get user ID from context
 		userID := c.Locals("user_id").(primitive.ObjectID)
 
-		// Parse request body
+		// Parse and validate request body
 		var req QueryRequest
-		if err := c.BodyParser(&req); err != nil {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": "Invalid request body",
+		if invalid := parseRequest(c, &req); invalid != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(invalid)
+		}
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Get user preferences
+		preferences, err := store.GetUserPreferences(ctx, userID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve preferences: " + err.Error(),
 			})
 		}
 
+		// Fall back to the user's default database
+		if req.DatabaseID == "" && !preferences.DefaultDatabaseID.IsZero() {
+			req.DatabaseID = preferences.DefaultDatabaseID.Hex()
+		}
+
 		// Validate required fields
 		if req.DatabaseID == "" || req.Query == "" {
 			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
@@ -49,12 +75,8 @@ func CreateQueryHandler(cfg *config.Config) fiber.Handler {
 			})
 		}
 
-		// Create context with timeout
-		ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
-		defer cancel()
-
 		// Get database
-		db, err := models.GetDatabaseByID(ctx, databaseID)
+		db, err := store.GetDatabaseByID(ctx, databaseID)
 		if err != nil {
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 				"error": "Failed to retrieve database: " + err.Error(),
@@ -67,13 +89,28 @@ func CreateQueryHandler(cfg *config.Config) fiber.Handler {
 			})
 		}
 
+		// Check if user can access database
+		allowed, err := store.CanAccessDatabase(ctx, db, userID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to check database access: " + err.Error(),
+			})
+		}
+		if !allowed {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "You do not have permission to access this database",
+			})
+		}
+
 		// Create query with initial values
 		query := &models.Query{
 			UserID:       userID,
 			DatabaseID:   databaseID,
 			NaturalQuery: req.Query,
 			Status:       models.QueryStatusRunning,
+			Tags:         models.NormalizeTags(req.Tags),
 		}
+		query.OrgID, query.WorkspaceID = workspaceScope(c)
 
 		// If name is not provided, use a default name initially
 		if req.Name == "" {
@@ -84,19 +121,21 @@ func CreateQueryHandler(cfg *config.Config) fiber.Handler {
 		}
 
 		// Save query to database
-		query, err = models.CreateQuery(ctx, query)
+		query, err = store.CreateQuery(ctx, query)
 		if err != nil {
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 				"error": "Failed to create query: " + err.Error(),
 			})
 		}
+		defer models.TrackRunningQuery(query.ID)()
+		publishQueryStatus(gateway, query)
 
 		// Generate query using OpenRouter Gemini based on database type
 		fmt.Printf("[%s] Starting query generation for database type: %s\n", time.Now().Format(time.RFC3339), db.Type)
 
 		// First find the matching table to save tokens
 		fmt.Printf("[%s] Finding matching table for query\n", time.Now().Format(time.RFC3339))
-		matchingTable, err := ai.FindMatchingSchemaTable(req.Query, db, cfg)
+		matchingTable, err := ai.FindMatchingSchemaTable(ctx, req.Query, db, cfg)
 		if err != nil {
 			fmt.Printf("[%s] Error finding matching table: %v, falling back to full schema\n", time.Now().Format(time.RFC3339), err)
 			// If we can't find a matching table, use the full schema
@@ -105,13 +144,24 @@ func CreateQueryHandler(cfg *config.Config) fiber.Handler {
 			fmt.Printf("[%s] Found matching table: %s\n", time.Now().Format(time.RFC3339), matchingTable)
 		}
 
+		// Column statistics of profiled tables help the model pick values,
+		// without the values of columns the user sees masked
+		if profiles, err := store.GetTableProfiles(ctx, db.ID); err != nil {
+			fmt.Printf("[%s] Error loading table profiles: %v\n", time.Now().Format(time.RFC3339), err)
+		} else {
+			for table, profile := range profiles {
+				profiles[table] = db.MaskProfile(profile, userID)
+			}
+			db.Profiles = profiles
+		}
+
 		// Generate the query using only the matching table's schema
-		generatedQuery, err := ai.GenerateSQL(req.Query, db, cfg, matchingTable)
+		generatedQuery, err := ai.GenerateSQL(ctx, req.Query, db, cfg, matchingTable)
 		if err != nil {
 			// Update query with error
 			query.Status = models.QueryStatusFailed
 			query.Error = "Failed to generate query: " + err.Error()
-			models.UpdateQuery(ctx, query)
+			saveQueryStatus(ctx, store, gateway, hooks, query)
 
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 				"error": query.Error,
@@ -123,16 +173,35 @@ func CreateQueryHandler(cfg *config.Config) fiber.Handler {
 		query.GeneratedSQL = generatedQuery
 		fmt.Printf("Generated query: %s\n", generatedQuery)
 
+		// Write queries are never executed without an explicit confirmation
+		if models.IsWriteQuery(db.Type, generatedQuery) {
+			return holdWriteQuery(c, ctx, store, cfg, gateway, hooks, mailQueue, flags, db, query)
+		}
+
+		// Connections that require it confirm or approve reads as well
+		if db.RequiresConfirmation() {
+			return holdQuery(c, ctx, store, cfg, gateway, hooks, mailQueue, db, query)
+		}
+
+		// Wait for a free execution slot for this user and database
+		release, err := execLimiter.Acquire(ctx, userID.Hex(), databaseID.Hex())
+		if err != nil {
+			return rejectBusyQuery(c, ctx, store, gateway, hooks, query, err)
+		}
+		defer release()
+
 		// Execute the query based on database type
 		fmt.Printf("[%s] Starting query execution\n", time.Now().Format(time.RFC3339))
 		executionStartTime := time.Now()
-		results, executionTime, err := models.ExecuteQuery(db, generatedQuery)
+		results, columns, executionTime, err := models.ExecuteQuery(ctx, db, generatedQuery)
+		recordExecution(ctx, store, db, userID, executionStartTime, err)
+		alerts.Evaluate(ctx, store, alerts.SourceManual, query.ID, results, err)
 		fmt.Printf("[%s] Query execution completed in %s\n", time.Now().Format(time.RFC3339), time.Since(executionStartTime))
 		if err != nil {
 			// Update query with error
 			query.Status = models.QueryStatusFailed
 			query.Error = "Failed to execute query: " + err.Error()
-			models.UpdateQuery(ctx, query)
+			saveQueryStatus(ctx, store, gateway, hooks, query)
 
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 				"error": query.Error,
@@ -142,12 +211,13 @@ func CreateQueryHandler(cfg *config.Config) fiber.Handler {
 
 		// Update query with results
 		query.Status = models.QueryStatusCompleted
-		query.Results = results
+		query.Results = preferences.LimitResults(results)
+		query.Columns = columns
 		query.ExecutionTime = executionTime
 		query.Error = "" // Clear any previous errors
 
 		// Save updated query
-		err = models.UpdateQuery(ctx, query)
+		err = saveQueryStatus(ctx, store, gateway, hooks, query)
 		if err != nil {
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 				"error": "Failed to update query: " + err.Error(),
@@ -176,7 +246,7 @@ func CreateQueryHandler(cfg *config.Config) fiber.Handler {
 
 		// 		// Update the query with the generated title
 		// 		query.Name = generatedName
-		// 		err = models.UpdateQuery(bgCtx, query)
+		// 		err = store.UpdateQuery(bgCtx, query)
 		// 		if err != nil {
 		// 			fmt.Printf("[%s] Failed to update query with generated title: %v\n", time.Now().Format(time.RFC3339), err)
 		// 			return
@@ -190,12 +260,173 @@ func CreateQueryHandler(cfg *config.Config) fiber.Handler {
 		// }
 
 		// Return response
-		return c.JSON(query)
+		return sendQuery(c, store, query)
+	}
+}
+
+// holdWriteQuery parks a generated write query until the user confirms it, or
+// an approver approves it on connections that require approval, or fails it
+// when writes can't run on the database
+func holdWriteQuery(c *fiber.Ctx, ctx context.Context, store models.Store, cfg *config.Config, gateway *realtime.Gateway, hooks *webhooks.Dispatcher, mailQueue mailer.Mailer, flags *features.Service, db *models.Database, query *models.Query) error {
+	query.IsWrite = true
+
+	blocked, err := writeQueriesBlocked(ctx, store, flags, db, query.UserID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to check database access: " + err.Error(),
+		})
+	}
+	if blocked != "" {
+		query.Status = models.QueryStatusFailed
+		query.Error = blocked
+		saveQueryStatus(ctx, store, gateway, hooks, query)
+
+		maskQuery(c, store, query)
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": query.Error,
+			"query": query,
+		})
+	}
+
+	if db.RequiresApproval() {
+		return requestApproval(c, ctx, store, cfg, gateway, hooks, mailQueue, db, query)
+	}
+
+	query.Status = models.QueryStatusAwaitingConfirmation
+	query.Error = ""
+	if err := saveQueryStatus(ctx, store, gateway, hooks, query); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to update query: " + err.Error(),
+		})
+	}
+
+	maskQuery(c, store, query)
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
+		"message":     "This query modifies data and must be confirmed before it runs",
+		"environment": db.Environment,
+		"query":       query,
+	})
+}
+
+// writeQueriesBlocked returns why a user's write queries can't run on a
+// database, or an empty string when they can. Only those who can manage the
+// connection write, members it is shared with only read.
+func writeQueriesBlocked(ctx context.Context, store models.Store, flags *features.Service, db *models.Database, userID primitive.ObjectID) (string, error) {
+	if !db.AllowWrites {
+		return "Write operations are not allowed on this database", nil
+	}
+	if !flags.Enabled(ctx, features.WriteQueries, db.OrgID) {
+		return "Write operations are currently disabled", nil
+	}
+
+	access, err := store.ResolveDatabaseAccess(ctx, db, userID)
+	if err != nil {
+		return "", err
+	}
+	if !access.CanManage() {
+		return "Only the owner of this database can run write operations on it", nil
+	}
+	return "", nil
+}
+
+// holdQuery parks a read query until the user confirms it, or an approver
+// approves it, for connections whose safety level requires either
+func holdQuery(c *fiber.Ctx, ctx context.Context, store models.Store, cfg *config.Config, gateway *realtime.Gateway, hooks *webhooks.Dispatcher, mailQueue mailer.Mailer, db *models.Database, query *models.Query) error {
+	if db.RequiresApproval() {
+		return requestApproval(c, ctx, store, cfg, gateway, hooks, mailQueue, db, query)
+	}
+
+	query.Status = models.QueryStatusAwaitingConfirmation
+	query.Error = ""
+	if err := saveQueryStatus(ctx, store, gateway, hooks, query); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to update query: " + err.Error(),
+		})
+	}
+
+	maskQuery(c, store, query)
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
+		"message":     fmt.Sprintf("Queries against %s must be confirmed before they run", describeEnvironment(db)),
+		"environment": db.Environment,
+		"query":       query,
+	})
+}
+
+// describeEnvironment names a connection for confirmation messages
+func describeEnvironment(db *models.Database) string {
+	if db.Environment == "" {
+		return "this database"
 	}
+	return "this " + db.Environment + " database"
+}
+
+// rejectBusyQuery responds with 429 when no execution slot is available,
+// leaving the query pending so it can be rerun later
+func rejectBusyQuery(c *fiber.Ctx, ctx context.Context, store models.Store, gateway *realtime.Gateway, hooks *webhooks.Dispatcher, query *models.Query, err error) error {
+	query.Status = models.QueryStatusPending
+	query.Error = err.Error()
+	saveQueryStatus(ctx, store, gateway, hooks, query)
+
+	maskQuery(c, store, query)
+	return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+		"error": err.Error(),
+		"query": query,
+	})
+}
+
+// saveQueryStatus saves a query whose status changed and tells the owner's
+// gateway connections and webhooks about it
+func saveQueryStatus(ctx context.Context, store models.Store, gateway *realtime.Gateway, hooks *webhooks.Dispatcher, query *models.Query) error {
+	// The status is saved even when the request was cancelled, or the query
+	// would be left running
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+	defer cancel()
+
+	if err := store.UpdateQuery(ctx, query); err != nil {
+		return err
+	}
+	publishQueryStatus(gateway, query)
+	hooks.QueryFinished(query)
+	return nil
+}
+
+// recordExecution stores the metric of a query execution against a
+// connection. Executions cut short by the request ending say nothing about
+// the connection and are left out.
+func recordExecution(ctx context.Context, store models.Store, db *models.Database, userID primitive.ObjectID, start time.Time, err error) {
+	if ctx.Err() != nil {
+		return
+	}
+
+	metric := models.NewExecutionMetric(db, userID, models.ExecutionSourceQuery, start, err)
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+	defer cancel()
+	if err := store.RecordExecution(ctx, metric); err != nil {
+		fmt.Printf("Failed to record execution of query against %s: %v\n", db.ID.Hex(), err)
+	}
+}
+
+// publishQueryStatus sends a query's status on its owner's queries channel.
+// Results are left out, clients fetch them once the query completes.
+func publishQueryStatus(gateway *realtime.Gateway, query *models.Query) {
+	if gateway == nil {
+		return
+	}
+
+	gateway.SendToUser(query.UserID, realtime.Message{
+		Channel: realtime.ChannelQueries,
+		Type:    "query_status",
+		Data: fiber.Map{
+			"query_id": query.ID,
+			"name":     query.Name,
+			"status":   query.Status,
+			"error":    query.Error,
+		},
+	})
 }
 
 // GetQueriesHandler handles retrieving all queries for a user with pagination
-func GetQueriesHandler() fiber.Handler {
+func GetQueriesHandler(store models.Store) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		// Get user ID from context
 		userID := c.Locals("user_id").(primitive.ObjectID)
@@ -215,18 +446,31 @@ func GetQueriesHandler() fiber.Handler {
 			limit = 10
 		}
 
-		// Create context with timeout
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
+		// Get optional search and tag filters
+		filter := models.QueryFilter{
+			Search: c.Query("search"),
+			Tag:    c.Query("tag"),
+		}
+		_, filter.WorkspaceID = workspaceScope(c)
+
+		// Get the request context
+		ctx := c.UserContext()
 
 		// Get queries with pagination
-		queries, totalCount, err := models.GetQueriesByUserID(ctx, userID, page, limit)
+		queries, totalCount, err := store.GetQueriesByUserID(ctx, userID, page, limit, filter)
 		if err != nil {
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 				"error": "Failed to retrieve queries: " + err.Error(),
 			})
 		}
 
+		// Mask the results the user may not see
+		if err := maskQueries(c, store, queries); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to mask results: " + err.Error(),
+			})
+		}
+
 		// Calculate pagination metadata
 		totalPages := (totalCount + limit - 1) / limit // Ceiling division
 
@@ -244,7 +488,7 @@ func GetQueriesHandler() fiber.Handler {
 }
 
 // GetQueryHandler handles retrieving a single query
-func GetQueryHandler() fiber.Handler {
+func GetQueryHandler(store models.Store) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		// Get user ID from context
 		userID := c.Locals("user_id").(primitive.ObjectID)
@@ -257,12 +501,11 @@ func GetQueryHandler() fiber.Handler {
 			})
 		}
 
-		// Create context with timeout
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
+		// Get the request context
+		ctx := c.UserContext()
 
 		// Get query
-		query, err := models.GetQueryByID(ctx, queryID)
+		query, err := store.GetQueryByID(ctx, queryID)
 		if err != nil {
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 				"error": "Failed to retrieve query: " + err.Error(),
@@ -275,20 +518,26 @@ func GetQueryHandler() fiber.Handler {
 			})
 		}
 
-		// Check if query belongs to user
-		if query.UserID != userID {
+		// Check if user can access query
+		allowed, err := store.CanAccessQuery(ctx, query, userID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to check query access: " + err.Error(),
+			})
+		}
+		if !allowed {
 			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
 				"error": "You don't have permission to access this query",
 			})
 		}
 
 		// Return response
-		return c.JSON(query)
+		return sendQuery(c, store, query)
 	}
 }
 
 // UpdateQueryHandler handles updating a query
-func UpdateQueryHandler() fiber.Handler {
+func UpdateQueryHandler(store models.Store) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		// Get user ID from context
 		userID := c.Locals("user_id").(primitive.ObjectID)
@@ -301,20 +550,17 @@ func UpdateQueryHandler() fiber.Handler {
 			})
 		}
 
-		// Parse request body
+		// Parse and validate request body
 		var req QueryRequest
-		if err := c.BodyParser(&req); err != nil {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": "Invalid request body",
-			})
+		if invalid := parseRequest(c, &req); invalid != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(invalid)
 		}
 
-		// Create context with timeout
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
+		// Get the request context
+		ctx := c.UserContext()
 
 		// Get query to check ownership
-		query, err := models.GetQueryByID(ctx, queryID)
+		query, err := store.GetQueryByID(ctx, queryID)
 		if err != nil {
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 				"error": "Failed to retrieve query: " + err.Error(),
@@ -343,8 +589,12 @@ func UpdateQueryHandler() fiber.Handler {
 			query.NaturalQuery = req.Query
 		}
 
+		if req.Tags != nil {
+			query.Tags = models.NormalizeTags(req.Tags)
+		}
+
 		// Save updated query
-		err = models.UpdateQuery(ctx, query)
+		err = store.UpdateQuery(ctx, query)
 		if err != nil {
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 				"error": "Failed to update query: " + err.Error(),
@@ -352,12 +602,70 @@ func UpdateQueryHandler() fiber.Handler {
 		}
 
 		// Return response
-		return c.JSON(query)
+		return sendQuery(c, store, query)
+	}
+}
+
+// SetQueryTagsHandler handles replacing the tags on a query
+func SetQueryTagsHandler(store models.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from context
+		userID := c.Locals("user_id").(primitive.ObjectID)
+
+		// Get query ID from params
+		queryID, err := primitive.ObjectIDFromHex(c.Params("id"))
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid query ID",
+			})
+		}
+
+		// Parse and validate request body
+		var req QueryTagsRequest
+		if invalid := parseRequest(c, &req); invalid != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(invalid)
+		}
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Get query to check ownership
+		query, err := store.GetQueryByID(ctx, queryID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve query: " + err.Error(),
+			})
+		}
+
+		if query == nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Query not found",
+			})
+		}
+
+		// Check if query belongs to user
+		if query.UserID != userID {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "You don't have permission to update this query",
+			})
+		}
+
+		// Save tags
+		tags := models.NormalizeTags(req.Tags)
+		if err := store.SetQueryTags(ctx, queryID, tags); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to update tags: " + err.Error(),
+			})
+		}
+
+		// Return response
+		query.Tags = tags
+		return sendQuery(c, store, query)
 	}
 }
 
 // DeleteQueryHandler handles deleting a query
-func DeleteQueryHandler() fiber.Handler {
+func DeleteQueryHandler(store models.Store) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		// Get user ID from context
 		userID := c.Locals("user_id").(primitive.ObjectID)
@@ -370,12 +678,11 @@ func DeleteQueryHandler() fiber.Handler {
 			})
 		}
 
-		// Create context with timeout
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
+		// Get the request context
+		ctx := c.UserContext()
 
 		// Get query to check ownership
-		query, err := models.GetQueryByID(ctx, queryID)
+		query, err := store.GetQueryByID(ctx, queryID)
 		if err != nil {
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 				"error": "Failed to retrieve query: " + err.Error(),
@@ -396,7 +703,7 @@ func DeleteQueryHandler() fiber.Handler {
 		}
 
 		// Delete query
-		err = models.DeleteQuery(ctx, queryID)
+		err = store.DeleteQuery(ctx, queryID)
 		if err != nil {
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 				"error": "Failed to delete query: " + err.Error(),
@@ -405,7 +712,73 @@ func DeleteQueryHandler() fiber.Handler {
 
 		// Return response
 		return c.JSON(fiber.Map{
-			"message": "Query deleted successfully",
+			"message": "Query moved to trash",
 		})
 	}
 }
+
+// limitResults trims results to the user's default result limit, keeping them
+// all when the preferences can't be loaded
+func limitResults(ctx context.Context, store models.Store, userID primitive.ObjectID, results []models.QueryResult) []models.QueryResult {
+	preferences, err := store.GetUserPreferences(ctx, userID)
+	if err != nil {
+		return results
+	}
+	return preferences.LimitResults(results)
+}
+
+// loadAccessibleQuery resolves the query in the request path and checks the
+// user can access it, masking its results for the user, so it must not be
+// saved. When the query is nil the returned error is the response already
+// written.
+func loadAccessibleQuery(c *fiber.Ctx, store models.Store) (*models.Query, error) {
+	// Get user ID from context
+	userID := c.Locals("user_id").(primitive.ObjectID)
+
+	// Get query ID from params
+	queryID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return nil, c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid query ID",
+		})
+	}
+
+	// Get the request context
+	ctx := c.UserContext()
+
+	// Get query
+	query, err := store.GetQueryByID(ctx, queryID)
+	if err != nil {
+		return nil, c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to retrieve query: " + err.Error(),
+		})
+	}
+
+	if query == nil {
+		return nil, c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Query not found",
+		})
+	}
+
+	// Check if user can access query
+	allowed, err := store.CanAccessQuery(ctx, query, userID)
+	if err != nil {
+		return nil, c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to check query access: " + err.Error(),
+		})
+	}
+	if !allowed {
+		return nil, c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "You don't have permission to access this query",
+		})
+	}
+
+	// Mask the results the user may not see
+	if err := maskQuery(c, store, query); err != nil {
+		return nil, c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to mask results: " + err.Error(),
+		})
+	}
+
+	return query, nil
+}
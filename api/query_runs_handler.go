@@ -0,0 +1,89 @@
+package api
+
+import (
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/zucced/goquery/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// GetQueryRunsHandler handles listing the scheduled runs of a query, newest
+// first, with pagination
+func GetQueryRunsHandler(store models.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from context
+		userID := c.Locals("user_id").(primitive.ObjectID)
+
+		// Get query ID from params
+		queryID, err := primitive.ObjectIDFromHex(c.Params("id"))
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid query ID",
+			})
+		}
+
+		// Get pagination parameters from query
+		page, err := strconv.ParseInt(c.Query("page", "1"), 10, 64)
+		if err != nil || page < 1 {
+			page = 1
+		}
+
+		limit, err := strconv.ParseInt(c.Query("limit", "20"), 10, 64)
+		if err != nil || limit < 1 || limit > 100 {
+			limit = 20
+		}
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Get query
+		query, err := store.GetQueryByID(ctx, queryID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve query: " + err.Error(),
+			})
+		}
+
+		if query == nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Query not found",
+			})
+		}
+
+		// Check if user can access query
+		allowed, err := store.CanAccessQuery(ctx, query, userID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to check query access: " + err.Error(),
+			})
+		}
+		if !allowed {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "You don't have permission to access this query",
+			})
+		}
+
+		// Get runs
+		runs, totalCount, err := store.GetQueryRuns(ctx, query.ID, page, limit)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve query runs: " + err.Error(),
+			})
+		}
+
+		// Calculate pagination metadata
+		totalPages := (totalCount + limit - 1) / limit
+
+		// Return response with pagination metadata
+		return c.JSON(fiber.Map{
+			"runs": runs,
+			"pagination": fiber.Map{
+				"total": totalCount,
+				"page":  page,
+				"limit": limit,
+				"pages": totalPages,
+			},
+		})
+	}
+}
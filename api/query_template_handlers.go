@@ -0,0 +1,416 @@
+package api
+
+import (
+	"log"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/zucced/goquery/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// QueryTemplateRequest represents the request body for publishing or editing
+// a query template. The SQL and database type are taken from a saved query
+// when its ID is given.
+type QueryTemplateRequest struct {
+	QueryID      string                       `json:"query_id" validate:"omitempty,objectid"`
+	Name         string                       `json:"name" validate:"notblank,max=200"`
+	Description  string                       `json:"description" validate:"max=2000"`
+	DatabaseType string                       `json:"database_type" validate:"omitempty,oneof=postgresql mongodb"`
+	SQL          string                       `json:"sql" validate:"max=100000"`
+	Variables    []models.DashboardVariable   `json:"variables" validate:"max=20"`
+	Requirements []models.TemplateRequirement `json:"requirements" validate:"max=50"`
+}
+
+// InstantiateTemplateRequest represents the request body for creating a query
+// from a template. Variables without a value use their default.
+type InstantiateTemplateRequest struct {
+	DatabaseID string            `json:"database_id" validate:"notblank,objectid"`
+	Name       string            `json:"name" validate:"max=200"`
+	Values     map[string]string `json:"values"`
+}
+
+// GetQueryTemplatesHandler handles listing an organization's template library
+func GetQueryTemplatesHandler(store models.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		org, err := loadOrganization(c, store)
+		if org == nil {
+			return err
+		}
+
+		// Get templates
+		templates, err := store.GetQueryTemplatesByOrgID(c.UserContext(), org.ID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve templates: " + err.Error(),
+			})
+		}
+
+		// Return response
+		return c.JSON(fiber.Map{
+			"templates": templates,
+		})
+	}
+}
+
+// CreateQueryTemplateHandler handles publishing a query template to an
+// organization's library, which any member can do
+func CreateQueryTemplateHandler(store models.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from context
+		userID := c.Locals("user_id").(primitive.ObjectID)
+
+		// Parse and validate request body
+		var req QueryTemplateRequest
+		if invalid := parseRequest(c, &req); invalid != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(invalid)
+		}
+
+		org, err := loadOrganization(c, store)
+		if org == nil {
+			return err
+		}
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		template := &models.QueryTemplate{OrgID: org.ID, UserID: userID}
+		if ok, err := applyQueryTemplateRequest(c, store, userID, &req, template); !ok {
+			return err
+		}
+
+		// Get the author
+		user, err := store.GetUserByID(ctx, userID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve user: " + err.Error(),
+			})
+		}
+		if user != nil {
+			template.AuthorName = user.Name
+		}
+
+		// Save template
+		template, err = store.CreateQueryTemplate(ctx, template)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to create template: " + err.Error(),
+			})
+		}
+
+		// Return response
+		return c.Status(fiber.StatusCreated).JSON(template)
+	}
+}
+
+// GetQueryTemplateHandler handles retrieving a template from the library of
+// one of the user's organizations
+func GetQueryTemplateHandler(store models.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		template, _, err := loadQueryTemplate(c, store)
+		if template == nil {
+			return err
+		}
+
+		// Return response
+		return c.JSON(template)
+	}
+}
+
+// UpdateQueryTemplateHandler handles editing a template, which its author and
+// the organization's admins can do. Queries created from it don't change.
+func UpdateQueryTemplateHandler(store models.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from context
+		userID := c.Locals("user_id").(primitive.ObjectID)
+
+		// Parse and validate request body
+		var req QueryTemplateRequest
+		if invalid := parseRequest(c, &req); invalid != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(invalid)
+		}
+
+		template, org, err := loadQueryTemplate(c, store)
+		if template == nil {
+			return err
+		}
+
+		if template.UserID != userID && !org.CanManage(userID) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "Only the author or an organization admin can edit a template",
+			})
+		}
+
+		if ok, err := applyQueryTemplateRequest(c, store, userID, &req, template); !ok {
+			return err
+		}
+
+		// Update template
+		if err := store.UpdateQueryTemplate(c.UserContext(), template); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to update template: " + err.Error(),
+			})
+		}
+
+		// Return response
+		return c.JSON(template)
+	}
+}
+
+// DeleteQueryTemplateHandler handles removing a template from its library,
+// which its author and the organization's admins can do
+func DeleteQueryTemplateHandler(store models.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from context
+		userID := c.Locals("user_id").(primitive.ObjectID)
+
+		template, org, err := loadQueryTemplate(c, store)
+		if template == nil {
+			return err
+		}
+
+		if template.UserID != userID && !org.CanManage(userID) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "Only the author or an organization admin can delete a template",
+			})
+		}
+
+		// Delete template
+		if err := store.DeleteQueryTemplate(c.UserContext(), template.ID); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to delete template: " + err.Error(),
+			})
+		}
+
+		// Return response
+		return c.JSON(fiber.Map{
+			"message": "Template deleted successfully",
+		})
+	}
+}
+
+// InstantiateQueryTemplateHandler handles creating a query from a template on
+// one of the user's connections. The connection must be of the template's type
+// and have the tables and columns it requires. The query is saved with the
+// variable values written into it and runs like any other query.
+func InstantiateQueryTemplateHandler(store models.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from context
+		userID := c.Locals("user_id").(primitive.ObjectID)
+
+		// Parse and validate request body
+		var req InstantiateTemplateRequest
+		if invalid := parseRequest(c, &req); invalid != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(invalid)
+		}
+
+		template, _, err := loadQueryTemplate(c, store)
+		if template == nil {
+			return err
+		}
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Get database
+		databaseID, _ := primitive.ObjectIDFromHex(req.DatabaseID)
+		db, err := store.GetDatabaseByID(ctx, databaseID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve database: " + err.Error(),
+			})
+		}
+
+		if db == nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Database not found",
+			})
+		}
+
+		// Check if user can access database
+		allowed, err := store.CanAccessDatabase(ctx, db, userID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to check database access: " + err.Error(),
+			})
+		}
+		if !allowed {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "You do not have permission to access this database",
+			})
+		}
+
+		// The connection must be able to run the template
+		if db.Type != template.DatabaseType {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "This template is for " + template.DatabaseType + " databases",
+			})
+		}
+		if missing := template.MissingRequirements(db.Schema); len(missing) > 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error":   "The database's schema is missing " + strings.Join(missing, ", "),
+				"missing": missing,
+			})
+		}
+
+		// Write the variable values into the query
+		sql, err := template.Render(req.Values)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+
+		query := &models.Query{
+			UserID:       userID,
+			DatabaseID:   db.ID,
+			Name:         strings.TrimSpace(req.Name),
+			NaturalQuery: template.Description,
+			GeneratedSQL: sql,
+			TemplateID:   template.ID,
+		}
+		query.OrgID, query.WorkspaceID = workspaceScope(c)
+		if query.Name == "" {
+			query.Name = template.Name
+		}
+		if query.NaturalQuery == "" {
+			query.NaturalQuery = template.Name
+		}
+
+		// Save query
+		query, err = store.CreateQuery(ctx, query)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to create query: " + err.Error(),
+			})
+		}
+
+		if err := store.CountQueryTemplateUse(ctx, template.ID); err != nil {
+			log.Printf("Failed to count use of template %s: %v", template.ID.Hex(), err)
+		}
+
+		// Return response
+		return c.Status(fiber.StatusCreated).JSON(query)
+	}
+}
+
+// applyQueryTemplateRequest copies a publish or edit request into a template,
+// taking the SQL from a saved query the user can access when one is given.
+// When it returns false the error is the response already written.
+func applyQueryTemplateRequest(c *fiber.Ctx, store models.Store, userID primitive.ObjectID, req *QueryTemplateRequest, template *models.QueryTemplate) (bool, error) {
+	// Get the request context
+	ctx := c.UserContext()
+
+	sql, databaseType := req.SQL, req.DatabaseType
+	if req.QueryID != "" {
+		queryID, _ := primitive.ObjectIDFromHex(req.QueryID)
+		query, err := store.GetQueryByID(ctx, queryID)
+		if err != nil {
+			return false, c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve query: " + err.Error(),
+			})
+		}
+
+		allowed := false
+		if query != nil {
+			allowed, err = store.CanAccessQuery(ctx, query, userID)
+			if err != nil {
+				return false, c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+					"error": "Failed to check query access: " + err.Error(),
+				})
+			}
+		}
+		if !allowed {
+			return false, c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Query not found",
+			})
+		}
+
+		if query.Federated() {
+			return false, c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Federated queries can't be published as templates",
+			})
+		}
+
+		db, err := store.GetDatabaseByID(ctx, query.DatabaseID)
+		if err != nil {
+			return false, c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve database: " + err.Error(),
+			})
+		}
+		if db == nil {
+			return false, c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Database not found",
+			})
+		}
+
+		sql, databaseType = query.GeneratedSQL, db.Type
+	}
+
+	if strings.TrimSpace(sql) == "" || databaseType == "" {
+		return false, c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Either a query ID, or the SQL and database type are required",
+		})
+	}
+
+	template.Name = strings.TrimSpace(req.Name)
+	template.Description = strings.TrimSpace(req.Description)
+	template.DatabaseType = databaseType
+	template.SQL = sql
+	template.Variables = req.Variables
+	template.Requirements = req.Requirements
+
+	if err := template.Validate(); err != nil {
+		return false, c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return true, nil
+}
+
+// loadQueryTemplate resolves the template in the request path and its
+// organization, which the user must belong to. When the template is nil the
+// returned error is the response already written.
+func loadQueryTemplate(c *fiber.Ctx, store models.Store) (*models.QueryTemplate, *models.Organization, error) {
+	// Get user ID from context
+	userID := c.Locals("user_id").(primitive.ObjectID)
+
+	// Get template ID from params
+	templateID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return nil, nil, c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid template ID",
+		})
+	}
+
+	// Get the request context
+	ctx := c.UserContext()
+
+	// Get template
+	template, err := store.GetQueryTemplateByID(ctx, templateID)
+	if err != nil {
+		return nil, nil, c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to retrieve template: " + err.Error(),
+		})
+	}
+
+	var org *models.Organization
+	if template != nil {
+		org, err = store.GetOrganizationByID(ctx, template.OrgID)
+		if err != nil {
+			return nil, nil, c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve organization: " + err.Error(),
+			})
+		}
+	}
+
+	// Non-members can't tell whether the template exists
+	if template == nil || org == nil || org.RoleFor(userID) == models.OrgRoleNone {
+		return nil, nil, c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Template not found",
+		})
+	}
+
+	return template, org, nil
+}
@@ -0,0 +1,383 @@
+package api
+
+import (
+	"context"
+	"net/mail"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/zucced/goquery/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// maxReportRecipients caps the number of recipients on a report schedule
+// ReportScheduleRequest represents the request body for creating or updating a report schedule
+type ReportScheduleRequest struct {
+	Name          string   `json:"name" validate:"max=200"`
+	Cron          string   `json:"cron" validate:"notblank,cron"`
+	Timezone      string   `json:"timezone" validate:"omitempty,timezone"`
+	Recipients    []string `json:"recipients" validate:"max=50,dive,notblank"`
+	ChannelIDs    []string `json:"channel_ids" validate:"max=20,dive,objectid"`
+	IncludeCharts bool     `json:"include_charts"`
+	Enabled       *bool    `json:"enabled"`
+}
+
+// validate normalizes a report schedule request and its recipients, returning
+// an error message if a recipient isn't an email address or there is no one
+// to send the report to
+func (req *ReportScheduleRequest) validate() string {
+	req.Name = strings.TrimSpace(req.Name)
+	req.Cron = strings.TrimSpace(req.Cron)
+
+	recipients := make([]string, 0, len(req.Recipients))
+	for _, recipient := range req.Recipients {
+		addr, err := mail.ParseAddress(strings.TrimSpace(recipient))
+		if err != nil {
+			return "Invalid recipient: " + recipient
+		}
+		recipients = append(recipients, addr.Address)
+	}
+	req.Recipients = recipients
+
+	if len(req.Recipients) == 0 && len(req.ChannelIDs) == 0 {
+		return "recipients or channel_ids are required"
+	}
+
+	return ""
+}
+
+// CreateReportScheduleHandler handles creating a report schedule for a dashboard
+func CreateReportScheduleHandler(store models.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from context
+		userID := c.Locals("user_id").(primitive.ObjectID)
+
+		// Get dashboard ID from params
+		dashboardID, err := primitive.ObjectIDFromHex(c.Params("id"))
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid dashboard ID",
+			})
+		}
+
+		// Parse and validate request body
+		var req ReportScheduleRequest
+		if invalid := parseRequest(c, &req); invalid != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(invalid)
+		}
+
+		// Schedules run in the user's timezone unless one is given
+		if req.Timezone == "" {
+			req.Timezone = preferredTimezone(c.UserContext(), store, c.Locals("user_id").(primitive.ObjectID))
+		}
+
+		// Validate request
+		if msg := req.validate(); msg != "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": msg,
+			})
+		}
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Get dashboard
+		dashboard, err := store.GetDashboardByID(ctx, dashboardID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve dashboard: " + err.Error(),
+			})
+		}
+
+		// Check if dashboard exists
+		if dashboard == nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Dashboard not found",
+			})
+		}
+
+		// Check if dashboard belongs to user
+		if dashboard.UserID != userID {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "You don't have permission to schedule reports for this dashboard",
+			})
+		}
+
+		// Check the notification channels belong to the user
+		channelIDs, msg, err := ownedChannelIDs(ctx, store, userID, req.ChannelIDs)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve notification channels: " + err.Error(),
+			})
+		}
+		if msg != "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": msg,
+			})
+		}
+
+		if req.Name == "" {
+			req.Name = dashboard.Name
+		}
+
+		enabled := true
+		if req.Enabled != nil {
+			enabled = *req.Enabled
+		}
+
+		// Create schedule
+		schedule := &models.ReportSchedule{
+			DashboardID:   dashboardID,
+			UserID:        userID,
+			Name:          req.Name,
+			Cron:          req.Cron,
+			Timezone:      req.Timezone,
+			Recipients:    req.Recipients,
+			ChannelIDs:    channelIDs,
+			IncludeCharts: req.IncludeCharts,
+			Enabled:       enabled,
+		}
+
+		schedule, err = store.CreateReportSchedule(ctx, schedule)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to create report schedule: " + err.Error(),
+			})
+		}
+
+		// Return response
+		return c.Status(fiber.StatusCreated).JSON(schedule)
+	}
+}
+
+// GetReportSchedulesHandler handles listing the report schedules of a dashboard
+func GetReportSchedulesHandler(store models.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from context
+		userID := c.Locals("user_id").(primitive.ObjectID)
+
+		// Get dashboard ID from params
+		dashboardID, err := primitive.ObjectIDFromHex(c.Params("id"))
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid dashboard ID",
+			})
+		}
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Get dashboard
+		dashboard, err := store.GetDashboardByID(ctx, dashboardID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve dashboard: " + err.Error(),
+			})
+		}
+
+		// Check if dashboard exists
+		if dashboard == nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Dashboard not found",
+			})
+		}
+
+		// Check if dashboard belongs to user
+		if dashboard.UserID != userID {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "You don't have permission to access this dashboard",
+			})
+		}
+
+		// Get schedules
+		schedules, err := store.GetReportSchedulesByDashboardID(ctx, dashboardID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve report schedules: " + err.Error(),
+			})
+		}
+
+		// Return response
+		return c.JSON(schedules)
+	}
+}
+
+// UpdateReportScheduleHandler handles updating a report schedule
+func UpdateReportScheduleHandler(store models.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Parse and validate request body
+		var req ReportScheduleRequest
+		if invalid := parseRequest(c, &req); invalid != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(invalid)
+		}
+
+		// Schedules run in the user's timezone unless one is given
+		if req.Timezone == "" {
+			req.Timezone = preferredTimezone(c.UserContext(), store, c.Locals("user_id").(primitive.ObjectID))
+		}
+
+		// Validate request
+		if msg := req.validate(); msg != "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": msg,
+			})
+		}
+
+		schedule, err := loadReportSchedule(c, store)
+		if schedule == nil {
+			return err
+		}
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Check the notification channels belong to the user
+		channelIDs, msg, err := ownedChannelIDs(ctx, store, schedule.UserID, req.ChannelIDs)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve notification channels: " + err.Error(),
+			})
+		}
+		if msg != "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": msg,
+			})
+		}
+
+		// Update fields
+		if req.Name != "" {
+			schedule.Name = req.Name
+		}
+		schedule.Cron = req.Cron
+		schedule.Timezone = req.Timezone
+		schedule.Recipients = req.Recipients
+		schedule.ChannelIDs = channelIDs
+		schedule.IncludeCharts = req.IncludeCharts
+		if req.Enabled != nil {
+			schedule.Enabled = *req.Enabled
+		}
+
+		// Save schedule
+		if err := store.UpdateReportSchedule(ctx, schedule); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to update report schedule: " + err.Error(),
+			})
+		}
+
+		// Return response
+		return c.JSON(schedule)
+	}
+}
+
+// DeleteReportScheduleHandler handles deleting a report schedule
+func DeleteReportScheduleHandler(store models.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		schedule, err := loadReportSchedule(c, store)
+		if schedule == nil {
+			return err
+		}
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Delete schedule
+		if err := store.DeleteReportSchedule(ctx, schedule.ID); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to delete report schedule: " + err.Error(),
+			})
+		}
+
+		// Return response
+		return c.JSON(fiber.Map{
+			"message": "Report schedule deleted successfully",
+		})
+	}
+}
+
+// GetReportDeliveriesHandler handles listing the delivery history of a report schedule
+func GetReportDeliveriesHandler(store models.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get limit from query
+		limit := c.QueryInt("limit", 50)
+		if limit < 1 || limit > 200 {
+			limit = 50
+		}
+
+		schedule, err := loadReportSchedule(c, store)
+		if schedule == nil {
+			return err
+		}
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Get deliveries
+		deliveries, err := store.GetReportDeliveries(ctx, schedule.ID, limit)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve report deliveries: " + err.Error(),
+			})
+		}
+
+		// Return response
+		return c.JSON(deliveries)
+	}
+}
+
+// loadReportSchedule resolves the report schedule in the request path and checks the user owns it.
+// When the schedule is nil the returned error is the response already written.
+func loadReportSchedule(c *fiber.Ctx, store models.Store) (*models.ReportSchedule, error) {
+	// Get user ID from context
+	userID := c.Locals("user_id").(primitive.ObjectID)
+
+	// Get dashboard ID and schedule ID from params
+	dashboardID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return nil, c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid dashboard ID",
+		})
+	}
+
+	scheduleID, err := primitive.ObjectIDFromHex(c.Params("reportId"))
+	if err != nil {
+		return nil, c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid report schedule ID",
+		})
+	}
+
+	// Get the request context
+	ctx := c.UserContext()
+
+	// Get schedule
+	schedule, err := store.GetReportScheduleByID(ctx, scheduleID)
+	if err != nil {
+		return nil, c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to retrieve report schedule: " + err.Error(),
+		})
+	}
+
+	// Check if schedule exists on this dashboard
+	if schedule == nil || schedule.DashboardID != dashboardID {
+		return nil, c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Report schedule not found",
+		})
+	}
+
+	// Check if schedule belongs to user
+	if schedule.UserID != userID {
+		return nil, c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "You don't have permission to access this report schedule",
+		})
+	}
+
+	return schedule, nil
+}
+
+// preferredTimezone returns the timezone from a user's preferences, empty for UTC
+func preferredTimezone(ctx context.Context, store models.Store, userID primitive.ObjectID) string {
+	preferences, err := store.GetUserPreferences(ctx, userID)
+	if err != nil {
+		return ""
+	}
+	return preferences.Timezone
+}
@@ -1,17 +1,23 @@
 package api
 
 import (
-	"context"
 	"fmt"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/zucced/goquery/alerts"
+	"github.com/zucced/goquery/config"
+	"github.com/zucced/goquery/features"
+	"github.com/zucced/goquery/limiter"
+	"github.com/zucced/goquery/mailer"
 	"github.com/zucced/goquery/models"
+	"github.com/zucced/goquery/realtime"
+	"github.com/zucced/goquery/webhooks"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 // RerunQueryHandler handles rerunning an existing query
-func RerunQueryHandler() fiber.Handler {
+func RerunQueryHandler(store models.Store, cfg *config.Config, execLimiter *limiter.ExecutionLimiter, gateway *realtime.Gateway, hooks *webhooks.Dispatcher, mailQueue mailer.Mailer, flags *features.Service) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		// Get user ID from context
 		userID := c.Locals("user_id").(primitive.ObjectID)
@@ -24,12 +30,11 @@ func RerunQueryHandler() fiber.Handler {
 			})
 		}
 
-		// Create context with timeout
-		ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
-		defer cancel()
+		// Get the request context
+		ctx := c.UserContext()
 
 		// Get the existing query
-		query, err := models.GetQueryByID(ctx, queryID)
+		query, err := store.GetQueryByID(ctx, queryID)
 		if err != nil {
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 				"error": "Failed to retrieve query: " + err.Error(),
@@ -49,8 +54,13 @@ func RerunQueryHandler() fiber.Handler {
 			})
 		}
 
+		// Federated queries join the current results of their sources
+		if query.Federated() {
+			return rerunFederatedQuery(c, ctx, store, execLimiter, gateway, hooks, query)
+		}
+
 		// Get the database
-		db, err := models.GetDatabaseByID(ctx, query.DatabaseID)
+		db, err := store.GetDatabaseByID(ctx, query.DatabaseID)
 		if err != nil {
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 				"error": "Failed to retrieve database: " + err.Error(),
@@ -63,15 +73,47 @@ func RerunQueryHandler() fiber.Handler {
 			})
 		}
 
+		// Check the user can still access the database, sharing may have
+		// been revoked since the query was saved
+		allowed, err := store.CanAccessDatabase(ctx, db, userID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to check database access: " + err.Error(),
+			})
+		}
+		if !allowed {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "You do not have permission to access this database",
+			})
+		}
+
+		// Write queries need a fresh confirmation every time they run
+		if query.IsWrite || models.IsWriteQuery(db.Type, query.GeneratedSQL) {
+			return holdWriteQuery(c, ctx, store, cfg, gateway, hooks, mailQueue, flags, db, query)
+		}
+
+		// Connections that require it confirm or approve reads as well
+		if db.RequiresConfirmation() {
+			return holdQuery(c, ctx, store, cfg, gateway, hooks, mailQueue, db, query)
+		}
+
+		// Wait for a free execution slot for this user and database
+		release, err := execLimiter.Acquire(ctx, userID.Hex(), db.ID.Hex())
+		if err != nil {
+			return rejectBusyQuery(c, ctx, store, gateway, hooks, query, err)
+		}
+		defer release()
+
 		// Update query status
 		query.Status = models.QueryStatusRunning
 		query.UpdatedAt = time.Now()
 		query.Error = "" // Clear any previous errors
-		err = models.UpdateQuery(ctx, query)
+		err = saveQueryStatus(ctx, store, gateway, hooks, query)
 		if err != nil {
 			fmt.Printf("Failed to update query status to running: %v\n", err)
 			// Continue anyway
 		}
+		defer models.TrackRunningQuery(query.ID)()
 
 		// Log the query execution
 		fmt.Printf("[%s] Rerunning query for database type: %s\n", time.Now().Format(time.RFC3339), db.Type)
@@ -80,15 +122,18 @@ func RerunQueryHandler() fiber.Handler {
 		// Execute the query based on database type
 		fmt.Printf("[%s] Starting query execution\n", time.Now().Format(time.RFC3339))
 		executionStartTime := time.Now()
-		results, executionTime, err := models.ExecuteQuery(db, query.GeneratedSQL)
+		results, columns, executionTime, err := models.ExecuteQuery(ctx, db, query.GeneratedSQL)
+		recordExecution(ctx, store, db, userID, executionStartTime, err)
+		alerts.Evaluate(ctx, store, alerts.SourceManual, query.ID, results, err)
 		fmt.Printf("[%s] Query execution completed in %s\n", time.Now().Format(time.RFC3339), time.Since(executionStartTime))
 		if err != nil {
 			// Update query with error
 			query.Status = models.QueryStatusFailed
 			query.Error = "Failed to execute query: " + err.Error()
-			models.UpdateQuery(ctx, query)
+			saveQueryStatus(ctx, store, gateway, hooks, query)
 
 			fmt.Printf("Query execution failed: %v\n", err)
+			maskQuery(c, store, query)
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 				"error": query.Error,
 				"query": query,
@@ -97,12 +142,13 @@ func RerunQueryHandler() fiber.Handler {
 
 		// Update query with results
 		query.Status = models.QueryStatusCompleted
-		query.Results = results
+		query.Results = limitResults(ctx, store, userID, results)
+		query.Columns = columns
 		query.ExecutionTime = executionTime
 		query.Error = "" // Clear any previous errors
 
 		// Save updated query
-		err = models.UpdateQuery(ctx, query)
+		err = saveQueryStatus(ctx, store, gateway, hooks, query)
 		if err != nil {
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 				"error": "Failed to update query: " + err.Error(),
@@ -110,6 +156,6 @@ func RerunQueryHandler() fiber.Handler {
 		}
 
 		// Return response
-		return c.JSON(query)
+		return sendQuery(c, store, query)
 	}
 }
@@ -0,0 +1,87 @@
+package api
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/zucced/goquery/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// SchemaAnnotationsRequest represents the request body for replacing the
+// column annotations of a database
+type SchemaAnnotationsRequest struct {
+	Annotations []models.ColumnAnnotation `json:"annotations"`
+}
+
+// SetSchemaAnnotationsHandler handles replacing the column annotations of a
+// database, which are included in the schema given to the AI
+func SetSchemaAnnotationsHandler(store models.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from context
+		userID := c.Locals("user_id").(primitive.ObjectID)
+
+		// Get database ID from params
+		databaseID, err := primitive.ObjectIDFromHex(c.Params("id"))
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid database ID",
+			})
+		}
+
+		// Parse and validate request body
+		var req SchemaAnnotationsRequest
+		if invalid := parseRequest(c, &req); invalid != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(invalid)
+		}
+
+		if err := models.ValidateColumnAnnotations(req.Annotations); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Get database
+		db, err := store.GetDatabaseByID(ctx, databaseID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve database: " + err.Error(),
+			})
+		}
+
+		if db == nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Database not found",
+			})
+		}
+
+		// Only the owner can change the annotations
+		access, err := store.ResolveDatabaseAccess(ctx, db, userID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to check database access: " + err.Error(),
+			})
+		}
+		if !access.CanManage() {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "You do not have permission to annotate this database",
+			})
+		}
+
+		// Update annotations
+		if req.Annotations == nil {
+			req.Annotations = []models.ColumnAnnotation{}
+		}
+		if err := store.SetColumnAnnotations(ctx, databaseID, req.Annotations); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to update annotations: " + err.Error(),
+			})
+		}
+
+		// Return response
+		return c.JSON(fiber.Map{
+			"annotations": req.Annotations,
+		})
+	}
+}
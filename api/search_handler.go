@@ -0,0 +1,55 @@
+package api
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/zucced/goquery/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// maxSearchLength caps the length of a search
+const maxSearchLength = 200
+
+// SearchHandler handles searching the queries, dashboards, tables and columns
+// a user can access, ranking results of all kinds together
+func SearchHandler(store models.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from context
+		userID := c.Locals("user_id").(primitive.ObjectID)
+
+		// Get the search and result limit from query
+		text := strings.TrimSpace(c.Query("q"))
+		if text == "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "A search is required",
+			})
+		}
+		if len(text) > maxSearchLength {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "The search can be at most " + strconv.Itoa(maxSearchLength) + " characters",
+			})
+		}
+
+		limit, err := strconv.Atoi(c.Query("limit", "20"))
+		if err != nil || limit < 1 || limit > 50 {
+			limit = 20
+		}
+
+		_, workspaceID := workspaceScope(c)
+
+		// Search
+		results, err := store.Search(c.UserContext(), userID, workspaceID, text, limit)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to search: " + err.Error(),
+			})
+		}
+
+		// Return response
+		return c.JSON(fiber.Map{
+			"results": results,
+		})
+	}
+}
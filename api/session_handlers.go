@@ -0,0 +1,132 @@
+package api
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/zucced/goquery/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// GetSessionsHandler handles listing the devices the user is signed in on
+func GetSessionsHandler(store models.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID and session ID from context
+		userID := c.Locals("user_id").(primitive.ObjectID)
+		sessionID := c.Locals("session_id").(primitive.ObjectID)
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Get sessions
+		sessions, err := store.GetActiveSessionsByUserID(ctx, userID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve sessions: " + err.Error(),
+			})
+		}
+
+		for _, session := range sessions {
+			session.Current = session.ID == sessionID
+		}
+
+		// Return response
+		return c.JSON(fiber.Map{
+			"sessions": sessions,
+		})
+	}
+}
+
+// LogoutHandler handles revoking the token used for the request, so it stops
+// working before it expires
+func LogoutHandler(store models.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID and session ID from context
+		userID := c.Locals("user_id").(primitive.ObjectID)
+		sessionID := c.Locals("session_id").(primitive.ObjectID)
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Revoke session
+		if _, err := store.RevokeSession(ctx, sessionID, userID); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to log out: " + err.Error(),
+			})
+		}
+
+		// Return response
+		return c.JSON(fiber.Map{
+			"message": "Logged out successfully",
+		})
+	}
+}
+
+// RevokeSessionHandler handles signing out one of the user's devices, including
+// the current one
+func RevokeSessionHandler(store models.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from context
+		userID := c.Locals("user_id").(primitive.ObjectID)
+
+		// Get session ID from params
+		sessionID, err := primitive.ObjectIDFromHex(c.Params("id"))
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid session ID",
+			})
+		}
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Revoke session
+		revoked, err := store.RevokeSession(ctx, sessionID, userID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to revoke session: " + err.Error(),
+			})
+		}
+
+		if !revoked {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Session not found",
+			})
+		}
+
+		// Return response
+		return c.JSON(fiber.Map{
+			"message": "Session revoked successfully",
+		})
+	}
+}
+
+// RevokeSessionsHandler handles signing out everywhere. With ?keep_current=true
+// the device making the request stays signed in.
+func RevokeSessionsHandler(store models.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID and session ID from context
+		userID := c.Locals("user_id").(primitive.ObjectID)
+		sessionID := c.Locals("session_id").(primitive.ObjectID)
+
+		except := primitive.NilObjectID
+		if c.QueryBool("keep_current") {
+			except = sessionID
+		}
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Revoke sessions
+		revoked, err := store.RevokeUserSessions(ctx, userID, except)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to revoke sessions: " + err.Error(),
+			})
+		}
+
+		// Return response
+		return c.JSON(fiber.Map{
+			"message": "Sessions revoked successfully",
+			"revoked": revoked,
+		})
+	}
+}
@@ -0,0 +1,272 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/zucced/goquery/export"
+	"github.com/zucced/goquery/models"
+	"github.com/zucced/goquery/webhooks"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// SnapshotRequest represents the request body for creating a dashboard snapshot
+type SnapshotRequest struct {
+	Name string `json:"name" validate:"max=200"`
+}
+
+// CreateSnapshotHandler handles capturing a point-in-time snapshot of a dashboard
+func CreateSnapshotHandler(store models.Store, hooks *webhooks.Dispatcher) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from context
+		userID := c.Locals("user_id").(primitive.ObjectID)
+
+		// Get dashboard ID from params
+		dashboardID, err := primitive.ObjectIDFromHex(c.Params("id"))
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid dashboard ID",
+			})
+		}
+
+		// Parse request body, which is optional
+		var req SnapshotRequest
+		if len(c.Body()) > 0 {
+			if invalid := parseRequest(c, &req); invalid != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(invalid)
+			}
+		}
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Get dashboard
+		dashboard, err := store.GetDashboardByID(ctx, dashboardID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve dashboard: " + err.Error(),
+			})
+		}
+
+		// Check if dashboard exists
+		if dashboard == nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Dashboard not found",
+			})
+		}
+
+		// Check if user can edit dashboard
+		if !dashboard.CanEdit(userID) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "You don't have permission to snapshot this dashboard",
+			})
+		}
+
+		// Capture snapshot
+		snapshot, err := store.CaptureDashboardSnapshot(ctx, dashboard, strings.TrimSpace(req.Name))
+		if errors.Is(err, models.ErrSnapshotTooLarge) {
+			return c.Status(fiber.StatusRequestEntityTooLarge).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to create snapshot: " + err.Error(),
+			})
+		}
+		hooks.ExportReady(userID, snapshot, primitive.NilObjectID)
+
+		// Mask the results the user may not see
+		if err := store.NewResultMasker(userID).MaskSnapshot(ctx, snapshot); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to mask results: " + err.Error(),
+			})
+		}
+
+		// Return response
+		return c.Status(fiber.StatusCreated).JSON(snapshot)
+	}
+}
+
+// GetSnapshotsHandler handles listing the snapshots of a dashboard
+func GetSnapshotsHandler(store models.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from context
+		userID := c.Locals("user_id").(primitive.ObjectID)
+
+		// Get dashboard ID from params
+		dashboardID, err := primitive.ObjectIDFromHex(c.Params("id"))
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid dashboard ID",
+			})
+		}
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Get dashboard
+		dashboard, err := store.GetDashboardByID(ctx, dashboardID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve dashboard: " + err.Error(),
+			})
+		}
+
+		// Check if dashboard exists
+		if dashboard == nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Dashboard not found",
+			})
+		}
+
+		// Check if user can view dashboard
+		if !dashboard.CanView(userID) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "You don't have permission to access this dashboard",
+			})
+		}
+
+		// Get snapshots
+		snapshots, err := store.GetSnapshotsByDashboardID(ctx, dashboardID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve snapshots: " + err.Error(),
+			})
+		}
+
+		// Return response
+		return c.JSON(snapshots)
+	}
+}
+
+// GetSnapshotHandler handles retrieving a single dashboard snapshot
+func GetSnapshotHandler(store models.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		snapshot, err := loadSnapshot(c, store)
+		if snapshot == nil {
+			return err
+		}
+
+		// Return response
+		return c.JSON(snapshot)
+	}
+}
+
+// ExportSnapshotHandler handles rendering a dashboard snapshot to PDF or PNG
+func ExportSnapshotHandler(store models.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get export format from query
+		format := export.Format(strings.ToLower(c.Query("format", string(export.FormatPDF))))
+		if format != export.FormatPDF && format != export.FormatPNG {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Format must be pdf or png",
+			})
+		}
+
+		snapshot, err := loadSnapshot(c, store)
+		if snapshot == nil {
+			return err
+		}
+
+		return sendSnapshotExport(c, snapshot, format)
+	}
+}
+
+// sendSnapshotExport renders a snapshot and sends it as an attachment
+func sendSnapshotExport(c *fiber.Ctx, snapshot *models.DashboardSnapshot, format export.Format) error {
+	// Render snapshot
+	data, err := export.RenderSnapshot(snapshot, format)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to export snapshot: " + err.Error(),
+		})
+	}
+
+	// Return file
+	filename := fmt.Sprintf("snapshot-%s.%s", snapshot.CreatedAt.UTC().Format("2006-01-02-150405"), format)
+	c.Set(fiber.HeaderContentType, format.ContentType())
+	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", filename))
+	return c.Send(data)
+}
+
+// loadSnapshot resolves the snapshot in the request path and checks the user can view it,
+// masking its results for the user. When the snapshot is nil the returned error is the
+// response already written.
+func loadSnapshot(c *fiber.Ctx, store models.Store) (*models.DashboardSnapshot, error) {
+	// Get user ID from context
+	userID := c.Locals("user_id").(primitive.ObjectID)
+
+	// Get dashboard ID and snapshot ID from params
+	dashboardID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return nil, c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid dashboard ID",
+		})
+	}
+
+	snapshotID, err := primitive.ObjectIDFromHex(c.Params("snapshotId"))
+	if err != nil {
+		return nil, c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid snapshot ID",
+		})
+	}
+
+	// Get the request context
+	ctx := c.UserContext()
+
+	// Get snapshot
+	snapshot, err := store.GetSnapshotByID(ctx, snapshotID)
+	if err != nil {
+		return nil, c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to retrieve snapshot: " + err.Error(),
+		})
+	}
+
+	// Check if snapshot exists on this dashboard
+	if snapshot == nil || snapshot.DashboardID != dashboardID {
+		return nil, c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Snapshot not found",
+		})
+	}
+
+	// Check if snapshot belongs to user or the dashboard is shared with them
+	allowed, err := canViewSnapshot(ctx, store, snapshot, userID)
+	if err != nil {
+		return nil, c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to retrieve dashboard: " + err.Error(),
+		})
+	}
+
+	if !allowed {
+		return nil, c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "You don't have permission to access this snapshot",
+		})
+	}
+
+	// Mask the results the user may not see
+	if err := store.NewResultMasker(userID).MaskSnapshot(ctx, snapshot); err != nil {
+		return nil, c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to mask results: " + err.Error(),
+		})
+	}
+
+	return snapshot, nil
+}
+
+// canViewSnapshot reports whether a snapshot belongs to the user or its
+// dashboard is shared with them
+func canViewSnapshot(ctx context.Context, store models.Store, snapshot *models.DashboardSnapshot, userID primitive.ObjectID) (bool, error) {
+	if snapshot.UserID == userID {
+		return true, nil
+	}
+
+	dashboard, err := store.GetDashboardByID(ctx, snapshot.DashboardID)
+	if err != nil {
+		return false, err
+	}
+	return dashboard != nil && dashboard.CanView(userID), nil
+}
@@ -0,0 +1,514 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/zucced/goquery/config"
+	"github.com/zucced/goquery/models"
+	"github.com/zucced/goquery/sso"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ssoCookie holds the PKCE verifier of a sign in in the browser that started
+// it, so only that browser can complete it
+const ssoCookie = "goquery_sso"
+
+// SSOConfigRequest represents the request body for configuring an organization's
+// identity provider. An empty client secret keeps the saved one.
+type SSOConfigRequest struct {
+	Enabled      bool                       `json:"enabled"`
+	Enforced     bool                       `json:"enforced"`
+	Issuer       string                     `json:"issuer" validate:"notblank"`
+	ClientID     string                     `json:"client_id" validate:"notblank"`
+	ClientSecret string                     `json:"client_secret"`
+	Domains      []string                   `json:"domains"`
+	Attributes   models.SSOAttributeMapping `json:"attributes"`
+	DefaultRole  models.OrgRole             `json:"default_role" validate:"omitempty,oneof=member admin"`
+}
+
+// SSOLookupRequest represents the request body for finding the SSO provider of an email
+type SSOLookupRequest struct {
+	Email string `json:"email" validate:"notblank"`
+}
+
+// GetOrganizationSSOHandler handles retrieving an organization's SSO configuration
+func GetOrganizationSSOHandler(store models.Store, cfg *config.Config) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from context
+		userID := c.Locals("user_id").(primitive.ObjectID)
+
+		org, err := loadOrganization(c, store)
+		if org == nil {
+			return err
+		}
+
+		// Check if user can manage organization
+		if !org.CanManage(userID) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "You don't have permission to manage this organization",
+			})
+		}
+
+		// Return response
+		return c.JSON(ssoResponse(cfg, org.ID, org.SSO))
+	}
+}
+
+// UpdateOrganizationSSOHandler handles configuring an organization's identity provider
+func UpdateOrganizationSSOHandler(store models.Store, cfg *config.Config) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from context
+		userID := c.Locals("user_id").(primitive.ObjectID)
+
+		// Parse and validate request body
+		var req SSOConfigRequest
+		if invalid := parseRequest(c, &req); invalid != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(invalid)
+		}
+
+		org, err := loadOrganization(c, store)
+		if org == nil {
+			return err
+		}
+
+		// Check if user can manage organization
+		if !org.CanManage(userID) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "You don't have permission to manage this organization",
+			})
+		}
+
+		conf := &models.SSOConfig{
+			Enabled:      req.Enabled,
+			Enforced:     req.Enforced,
+			Issuer:       strings.TrimSpace(req.Issuer),
+			ClientID:     strings.TrimSpace(req.ClientID),
+			ClientSecret: req.ClientSecret,
+			Domains:      req.Domains,
+			Attributes:   req.Attributes,
+			DefaultRole:  req.DefaultRole,
+		}
+		if conf.ClientSecret == "" && org.SSO != nil {
+			conf.ClientSecret = org.SSO.ClientSecret
+		}
+
+		// Validate configuration
+		if err := conf.Validate(); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+
+		// Domains stay verified while they're listed, new ones must be verified
+		conf.KeepVerification(org.SSO)
+		if conf.VerificationToken == "" {
+			b := make([]byte, 16)
+			if _, err := rand.Read(b); err != nil {
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+					"error": "Failed to generate verification token",
+				})
+			}
+			conf.VerificationToken = hex.EncodeToString(b)
+		}
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// A domain can only sign in through one organization
+		claimed, err := store.SSODomainsClaimed(ctx, org.ID, conf.Domains)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to check SSO domains: " + err.Error(),
+			})
+		}
+		if len(claimed) > 0 {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error": "Domains already use another organization's SSO: " + strings.Join(claimed, ", "),
+			})
+		}
+
+		// Catch a wrong issuer now rather than at the next sign in
+		if conf.Enabled {
+			if err := sso.Discover(ctx, conf.Issuer); err != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": "Failed to discover identity provider: " + err.Error(),
+				})
+			}
+		}
+
+		// Save configuration
+		if err := store.SetOrganizationSSO(ctx, org.ID, conf); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to save SSO configuration: " + err.Error(),
+			})
+		}
+
+		// Return response
+		return c.JSON(ssoResponse(cfg, org.ID, conf))
+	}
+}
+
+// VerifyOrganizationSSODomainsHandler handles checking the DNS of an
+// organization's unverified SSO domains for its verification record. Users on
+// a domain only sign in through the provider once it's verified.
+func VerifyOrganizationSSODomainsHandler(store models.Store, cfg *config.Config) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from context
+		userID := c.Locals("user_id").(primitive.ObjectID)
+
+		org, err := loadOrganization(c, store)
+		if org == nil {
+			return err
+		}
+
+		// Check if user can manage organization
+		if !org.CanManage(userID) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "You don't have permission to manage this organization",
+			})
+		}
+
+		if org.SSO == nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "SSO is not configured for this organization",
+			})
+		}
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// A domain verified by another organization stays theirs
+		pending := org.SSO.UnverifiedDomains()
+		claimed, err := store.SSODomainsClaimed(ctx, org.ID, pending)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to check SSO domains: " + err.Error(),
+			})
+		}
+		taken := make(map[string]bool, len(claimed))
+		for _, domain := range claimed {
+			taken[domain] = true
+		}
+
+		// Look for the verification record on each domain
+		verified := []string{}
+		failures := fiber.Map{}
+		for _, domain := range pending {
+			if taken[domain] {
+				failures[domain] = "Another organization already verified this domain"
+				continue
+			}
+
+			found, err := sso.DomainHasRecord(ctx, domain, org.SSO.VerificationRecord())
+			switch {
+			case err != nil:
+				failures[domain] = "DNS lookup failed: " + err.Error()
+			case !found:
+				failures[domain] = "TXT record " + org.SSO.VerificationRecord() + " not found"
+			default:
+				verified = append(verified, domain)
+			}
+		}
+
+		// Save verified domains
+		if err := store.VerifySSODomains(ctx, org.ID, verified); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to save verified domains: " + err.Error(),
+			})
+		}
+		org.SSO.VerifiedDomains = append(org.SSO.VerifiedDomains, verified...)
+
+		// Return response
+		response := ssoResponse(cfg, org.ID, org.SSO)
+		response["failures"] = failures
+		return c.JSON(response)
+	}
+}
+
+// DeleteOrganizationSSOHandler handles removing an organization's identity provider
+func DeleteOrganizationSSOHandler(store models.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from context
+		userID := c.Locals("user_id").(primitive.ObjectID)
+
+		org, err := loadOrganization(c, store)
+		if org == nil {
+			return err
+		}
+
+		// Check if user can manage organization
+		if !org.CanManage(userID) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "You don't have permission to manage this organization",
+			})
+		}
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Remove configuration
+		if err := store.SetOrganizationSSO(ctx, org.ID, nil); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to remove SSO configuration: " + err.Error(),
+			})
+		}
+
+		// Return response
+		return c.JSON(fiber.Map{
+			"message": "SSO configuration removed successfully",
+		})
+	}
+}
+
+// SSOLookupHandler handles finding whether an email signs in through SSO, so the
+// login page can send the user to their identity provider
+func SSOLookupHandler(store models.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Parse and validate request body
+		var req SSOLookupRequest
+		if invalid := parseRequest(c, &req); invalid != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(invalid)
+		}
+
+		domain := models.EmailDomain(req.Email)
+		if domain == "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "A valid email is required",
+			})
+		}
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Get organization
+		org, err := store.GetOrganizationBySSODomain(ctx, domain)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to look up SSO: " + err.Error(),
+			})
+		}
+
+		if org == nil {
+			return c.JSON(fiber.Map{
+				"sso": false,
+			})
+		}
+
+		// Return response
+		return c.JSON(fiber.Map{
+			"sso":       true,
+			"enforced":  org.SSO.Enforced,
+			"org_id":    org.ID,
+			"login_url": "/api/auth/sso/" + org.ID.Hex() + "/login",
+		})
+	}
+}
+
+// SSOLoginHandler handles sending the user to their organization's identity provider
+func SSOLoginHandler(store models.Store, cfg *config.Config) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get the request context
+		ctx := c.UserContext()
+
+		org, err := loadSSOOrganization(ctx, store, c)
+		if org == nil {
+			return err
+		}
+
+		// Sign the state so the callback can't be forged, and bind it to this
+		// browser so nobody can complete their sign in in someone else's
+		state, nonce, verifier, err := sso.NewState(org.ID, cfg.JWTSecret)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to start sign in: " + err.Error(),
+			})
+		}
+
+		authURL, err := sso.AuthCodeURL(ctx, org.SSO, ssoRedirectURI(cfg, org.ID), state, nonce, verifier)
+		if err != nil {
+			return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{
+				"error": "Failed to reach identity provider: " + err.Error(),
+			})
+		}
+
+		setSSOCookie(c, cfg, org.ID, verifier, time.Now().Add(sso.StateExpiry))
+		return c.Redirect(authURL, fiber.StatusFound)
+	}
+}
+
+// SSOCallbackHandler handles the identity provider sending the user back. Users
+// are created on their first sign in, then sent to the frontend with a token.
+func SSOCallbackHandler(store models.Store, cfg *config.Config) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if providerErr := c.Query("error"); providerErr != "" {
+			return ssoFrontendRedirect(c, cfg, url.Values{"error": {providerErr}})
+		}
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		org, err := loadSSOOrganization(ctx, store, c)
+		if org == nil {
+			return err
+		}
+
+		// The sign in can only be completed once, in the browser that started it
+		verifier := c.Cookies(ssoCookie)
+		setSSOCookie(c, cfg, org.ID, "", time.Unix(0, 0))
+
+		// Verify the state matches this organization and browser
+		orgID, nonce, err := sso.ParseState(c.Query("state"), cfg.JWTSecret, verifier)
+		if err != nil || orgID != org.ID {
+			return ssoFrontendRedirect(c, cfg, url.Values{"error": {"Sign in expired, please try again"}})
+		}
+
+		// Redeem the code for the user's identity
+		identity, err := sso.Exchange(ctx, org.SSO, ssoRedirectURI(cfg, org.ID), c.Query("code"), nonce, verifier)
+		if err != nil {
+			log.Printf("SSO sign in failed for organization %s: %v", org.ID.Hex(), err)
+			return ssoFrontendRedirect(c, cfg, url.Values{"error": {"Identity provider sign in failed"}})
+		}
+
+		// The provider can only sign in users on the organization's domains
+		if !org.SSO.CoversEmail(identity.Email) {
+			return ssoFrontendRedirect(c, cfg, url.Values{"error": {"Your email domain isn't allowed to sign in to this organization"}})
+		}
+
+		user, err := provisionSSOUser(ctx, store, org, identity)
+		if err != nil {
+			return ssoFrontendRedirect(c, cfg, url.Values{"error": {err.Error()}})
+		}
+
+		// Start a session and generate its JWT token
+		token, err := issueToken(ctx, store, c, cfg, user.ID)
+		if err != nil {
+			return ssoFrontendRedirect(c, cfg, url.Values{"error": {"Failed to generate token"}})
+		}
+
+		return ssoFrontendRedirect(c, cfg, url.Values{"token": {token}})
+	}
+}
+
+// provisionSSOUser returns the account for an identity, creating it and adding
+// it to the organization on first sign in. Existing accounts must already be
+// members so a provider can't take over accounts outside the organization.
+func provisionSSOUser(ctx context.Context, store models.Store, org *models.Organization, identity *sso.Identity) (*models.User, error) {
+	user, err := store.GetUserByEmail(ctx, identity.Email)
+	if err != nil {
+		return nil, errors.New("Failed to look up user")
+	}
+
+	if user != nil {
+		if org.RoleFor(user.ID) == models.OrgRoleNone {
+			return nil, errors.New("An account with this email already exists, ask an organization admin to add it before using SSO")
+		}
+		return user, nil
+	}
+
+	// Users never see this password, they sign in through the provider
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return nil, errors.New("Failed to create user")
+	}
+
+	user, err = store.CreateUser(ctx, identity.Email, hex.EncodeToString(b), identity.Name)
+	if err != nil {
+		return nil, errors.New("Failed to create user")
+	}
+
+	if err := store.AddOrganizationMember(ctx, org.ID, models.OrganizationMember{
+		UserID: user.ID,
+		Email:  user.Email,
+		Name:   user.Name,
+		Role:   org.SSO.DefaultRole,
+	}); err != nil {
+		return nil, errors.New("Failed to add user to organization")
+	}
+
+	// Link dashboards that were shared with this email before signup, only
+	// when the organization proved it controls the email's domain
+	if org.SSO.CoversEmail(user.Email) {
+		if err := store.ClaimDashboardInvites(ctx, user.ID, user.Email); err != nil {
+			log.Printf("Failed to claim dashboard invites for %s: %v", user.Email, err)
+		}
+	}
+
+	return user, nil
+}
+
+// loadSSOOrganization resolves the organization in the request path and checks
+// it has SSO enabled. When the organization is nil the returned error is the
+// response already written.
+func loadSSOOrganization(ctx context.Context, store models.Store, c *fiber.Ctx) (*models.Organization, error) {
+	// Get organization ID from params
+	orgID, err := primitive.ObjectIDFromHex(c.Params("orgId"))
+	if err != nil {
+		return nil, c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid organization ID",
+		})
+	}
+
+	// Get organization
+	org, err := store.GetOrganizationByID(ctx, orgID)
+	if err != nil {
+		return nil, c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to retrieve organization: " + err.Error(),
+		})
+	}
+
+	if org == nil || org.SSO == nil || !org.SSO.Enabled {
+		return nil, c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "SSO is not enabled for this organization",
+		})
+	}
+
+	return org, nil
+}
+
+// ssoResponse returns an organization's SSO configuration with what an admin
+// needs to set it up: the callback URL and the DNS record verifying its domains
+func ssoResponse(cfg *config.Config, orgID primitive.ObjectID, conf *models.SSOConfig) fiber.Map {
+	response := fiber.Map{
+		"sso":          conf,
+		"redirect_uri": ssoRedirectURI(cfg, orgID),
+	}
+	if conf != nil {
+		response["verification_record"] = conf.VerificationRecord()
+		response["unverified_domains"] = conf.UnverifiedDomains()
+	}
+	return response
+}
+
+// ssoRedirectURI returns the callback URL registered with an organization's provider
+func ssoRedirectURI(cfg *config.Config, orgID primitive.ObjectID) string {
+	return cfg.PublicURL + "/api/auth/sso/" + orgID.Hex() + "/callback"
+}
+
+// setSSOCookie keeps the PKCE verifier of a sign in for the organization's
+// callback, or clears it when it expires in the past. Lax cookies are sent on
+// the provider's redirect back but not on requests other sites make.
+func setSSOCookie(c *fiber.Ctx, cfg *config.Config, orgID primitive.ObjectID, verifier string, expires time.Time) {
+	c.Cookie(&fiber.Cookie{
+		Name:     ssoCookie,
+		Value:    verifier,
+		Path:     "/api/auth/sso/" + orgID.Hex() + "/",
+		Expires:  expires,
+		Secure:   strings.HasPrefix(cfg.PublicURL, "https://"),
+		HTTPOnly: true,
+		SameSite: fiber.CookieSameSiteLaxMode,
+	})
+}
+
+// ssoFrontendRedirect sends the browser back to the frontend with the result of
+// a sign in. Values go in the fragment so tokens stay out of server logs.
+func ssoFrontendRedirect(c *fiber.Ctx, cfg *config.Config, values url.Values) error {
+	return c.Redirect(cfg.FrontendURL+"/sso/callback#"+values.Encode(), fiber.StatusFound)
+}
@@ -0,0 +1,68 @@
+package api
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"log"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/zucced/goquery/middleware"
+	"github.com/zucced/goquery/models"
+)
+
+// streamMinRows is the number of results from which a query is streamed
+// instead of encoded in memory as a whole
+const streamMinRows = 1000
+
+// streamChunkRows is the number of rows sent in each chunk of a streamed
+// query
+const streamChunkRows = 500
+
+// sendQuery responds with a query as JSON, its results masked for the
+// requesting user. Queries with many results are streamed in chunks as they
+// are encoded, so concurrent large responses don't each hold a full copy of
+// their results as JSON. The query must not be saved afterwards.
+func sendQuery(c *fiber.Ctx, store models.Store, query *models.Query) error {
+	if err := maskQuery(c, store, query); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to mask results: " + err.Error(),
+		})
+	}
+
+	if len(query.Results) < streamMinRows {
+		return c.JSON(query)
+	}
+
+	encoding := middleware.StreamEncoding(c)
+	if encoding != "" {
+		c.Set(fiber.HeaderContentEncoding, encoding)
+		c.Vary(fiber.HeaderAcceptEncoding)
+	}
+	c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+
+	// The body is written once the handler returns, after the request's
+	// context may have ended, so nothing from it is used
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		var out io.Writer = w
+		flush := w.Flush
+		if encoding == "gzip" {
+			gz := gzip.NewWriter(w)
+			defer gz.Close()
+			out = gz
+			flush = func() error {
+				if err := gz.Flush(); err != nil {
+					return err
+				}
+				return w.Flush()
+			}
+		}
+
+		// The status is already sent, so failures can only cut the response
+		// short
+		if err := query.EncodeJSON(out, streamChunkRows, flush); err != nil {
+			log.Printf("Failed to stream query %s: %v", query.ID.Hex(), err)
+		}
+	})
+	return nil
+}
@@ -0,0 +1,85 @@
+package api
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/zucced/goquery/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// GetDatabaseSuggestionsHandler handles suggesting questions to ask a
+// database that start with the prefix parameter: the ones asked before in the
+// user's and their organizations' queries, most often first, then common
+// aggregates over its tables
+func GetDatabaseSuggestionsHandler(store models.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from context
+		userID := c.Locals("user_id").(primitive.ObjectID)
+
+		// Get database ID from params
+		databaseID, err := primitive.ObjectIDFromHex(c.Params("id"))
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid database ID",
+			})
+		}
+
+		// Get the prefix and result limit from query
+		prefix := strings.TrimLeft(c.Query("prefix"), " \t\n")
+		if len(prefix) > 500 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "The prefix can be at most 500 characters",
+			})
+		}
+
+		limit, err := strconv.Atoi(c.Query("limit", "10"))
+		if err != nil || limit < 1 || limit > 50 {
+			limit = 10
+		}
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Get database to check access
+		db, err := store.GetDatabaseByID(ctx, databaseID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve database: " + err.Error(),
+			})
+		}
+
+		if db == nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Database not found",
+			})
+		}
+
+		// Check if user can access database
+		allowed, err := store.CanAccessDatabase(ctx, db, userID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to check database access: " + err.Error(),
+			})
+		}
+		if !allowed {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "You don't have permission to access this database",
+			})
+		}
+
+		// Get the questions asked before
+		history, err := store.GetQuestionHistory(ctx, databaseID, userID, prefix, limit)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve question history: " + err.Error(),
+			})
+		}
+
+		// Return response
+		return c.JSON(fiber.Map{
+			"suggestions": models.SuggestQuestions(history, db.VisibleSchema(), prefix, limit),
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package api
+
+import (
+	"net/url"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/zucced/goquery/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ProfileTableHandler handles computing the column statistics of a table
+// from a sample of its rows. The stored profile is served while it is fresh,
+// unless refresh is set and it is more than a minute old.
+func ProfileTableHandler(store models.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from context
+		userID := c.Locals("user_id").(primitive.ObjectID)
+
+		// Get database ID and table from params
+		databaseID, err := primitive.ObjectIDFromHex(c.Params("id"))
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid database ID",
+			})
+		}
+
+		tableName, err := url.PathUnescape(c.Params("table"))
+		if err != nil || tableName == "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid table name",
+			})
+		}
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Get database
+		db, err := store.GetDatabaseByID(ctx, databaseID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve database: " + err.Error(),
+			})
+		}
+
+		if db == nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Database not found",
+			})
+		}
+
+		// Check if user can access database
+		allowed, err := store.CanAccessDatabase(ctx, db, userID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to check database access: " + err.Error(),
+			})
+		}
+		if !allowed {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "You do not have permission to access this database",
+			})
+		}
+
+		// Find the table, hidden tables can't be profiled
+		var table *models.Table
+		if schema := db.VisibleSchema(); schema != nil {
+			for i := range schema.Tables {
+				if schema.Tables[i].Name == tableName {
+					table = &schema.Tables[i]
+					break
+				}
+			}
+		}
+		if table == nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Table not found",
+			})
+		}
+
+		// Serve the stored profile while it is fresh
+		cached, err := store.GetTableProfile(ctx, db.ID, table.Name)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve table profile: " + err.Error(),
+			})
+		}
+		if cached != nil {
+			age := time.Since(cached.ProfiledAt)
+			if age < models.TableProfileMinInterval || (cached.Fresh(time.Now()) && !c.QueryBool("refresh")) {
+				cached.Cached = true
+				return c.JSON(db.MaskProfile(cached, userID))
+			}
+		}
+
+		// Profile table
+		profile, err := models.ProfileTable(ctx, db, table)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to profile table: " + err.Error(),
+			})
+		}
+
+		// Save profile
+		if err := store.SaveTableProfile(ctx, profile); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to save table profile: " + err.Error(),
+			})
+		}
+
+		// Return response, without the values of columns the user sees masked
+		return c.JSON(db.MaskProfile(profile, userID))
+	}
+}
@@ -0,0 +1,142 @@
+package api
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/zucced/goquery/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// GetTrashHandler handles listing the queries and dashboards in a user's trash
+func GetTrashHandler(store models.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from context
+		userID := c.Locals("user_id").(primitive.ObjectID)
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Get trashed queries
+		queries, err := store.GetTrashedQueriesByUserID(ctx, userID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve trashed queries: " + err.Error(),
+			})
+		}
+
+		// Get trashed dashboards
+		dashboards, err := store.GetTrashedDashboardsByUserID(ctx, userID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve trashed dashboards: " + err.Error(),
+			})
+		}
+
+		// Return response
+		return c.JSON(fiber.Map{
+			"queries":    queries,
+			"dashboards": dashboards,
+		})
+	}
+}
+
+// RestoreQueryHandler handles restoring a query from the trash
+func RestoreQueryHandler(store models.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from context
+		userID := c.Locals("user_id").(primitive.ObjectID)
+
+		// Get query ID from params
+		queryID, err := primitive.ObjectIDFromHex(c.Params("id"))
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid query ID",
+			})
+		}
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Get the trashed query
+		query, err := store.GetTrashedQueryByID(ctx, queryID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve query: " + err.Error(),
+			})
+		}
+
+		if query == nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Query not found in trash",
+			})
+		}
+
+		// Check if query belongs to user
+		if query.UserID != userID {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "You don't have permission to restore this query",
+			})
+		}
+
+		// Restore query
+		if err := store.RestoreQuery(ctx, queryID); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to restore query: " + err.Error(),
+			})
+		}
+
+		// Return response
+		query.DeletedAt = nil
+		return sendQuery(c, store, query)
+	}
+}
+
+// RestoreDashboardHandler handles restoring a dashboard from the trash
+func RestoreDashboardHandler(store models.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from context
+		userID := c.Locals("user_id").(primitive.ObjectID)
+
+		// Get dashboard ID from params
+		dashboardID, err := primitive.ObjectIDFromHex(c.Params("id"))
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid dashboard ID",
+			})
+		}
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Get the trashed dashboard
+		dashboard, err := store.GetTrashedDashboardByID(ctx, dashboardID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve dashboard: " + err.Error(),
+			})
+		}
+
+		if dashboard == nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Dashboard not found in trash",
+			})
+		}
+
+		// Check if dashboard belongs to user
+		if dashboard.UserID != userID {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "You don't have permission to restore this dashboard",
+			})
+		}
+
+		// Restore dashboard
+		if err := store.RestoreDashboard(ctx, dashboardID); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to restore dashboard: " + err.Error(),
+			})
+		}
+
+		// Return response
+		dashboard.DeletedAt = nil
+		return c.JSON(dashboard)
+	}
+}
@@ -0,0 +1,188 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gofiber/fiber/v2"
+	"github.com/zucced/goquery/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// validate checks request bodies against their `validate` struct tags.
+// Fields are reported by their JSON names.
+var validate = newValidator()
+
+func newValidator() *validator.Validate {
+	v := validator.New(validator.WithRequiredStructEnabled())
+
+	v.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			return ""
+		}
+		if name == "" {
+			return field.Name
+		}
+		return name
+	})
+
+	// notblank rejects strings that are empty once trimmed
+	v.RegisterValidation("notblank", func(fl validator.FieldLevel) bool {
+		return strings.TrimSpace(fl.Field().String()) != ""
+	})
+
+	// port accepts a TCP port number given as a string
+	v.RegisterValidation("port", func(fl validator.FieldLevel) bool {
+		port, err := strconv.Atoi(fl.Field().String())
+		return err == nil && port >= 1 && port <= 65535
+	})
+
+	// objectid accepts the hex form of a MongoDB ObjectID
+	v.RegisterValidation("objectid", func(fl validator.FieldLevel) bool {
+		return primitive.IsValidObjectID(fl.Field().String())
+	})
+
+	// cron accepts the expressions report schedules can run on, replacing the
+	// looser built-in check
+	v.RegisterValidation("cron", func(fl validator.FieldLevel) bool {
+		_, err := models.NextReportRun(strings.TrimSpace(fl.Field().String()), "", time.Now())
+		return err == nil
+	})
+
+	return v
+}
+
+// FieldError describes why a single field of a request body is invalid
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationErrorResponse is returned for request bodies that fail validation
+type ValidationErrorResponse struct {
+	Error  string       `json:"error"`
+	Fields []FieldError `json:"fields,omitempty"`
+}
+
+// parseRequest parses the request body into req and validates it, returning
+// the response to send when the body is malformed or invalid. Fields named in
+// except are not validated.
+func parseRequest(c *fiber.Ctx, req any, except ...string) *ValidationErrorResponse {
+	if err := c.BodyParser(req); err != nil {
+		return &ValidationErrorResponse{Error: "Invalid request body"}
+	}
+	return validateRequest(req, except...)
+}
+
+// validateRequest validates a parsed request body, see parseRequest
+func validateRequest(req any, except ...string) *ValidationErrorResponse {
+	var err error
+	value := reflect.Indirect(reflect.ValueOf(req))
+	switch {
+	case value.Kind() == reflect.Slice:
+		err = validate.Var(value.Interface(), "dive")
+	case len(except) > 0:
+		err = validate.StructExcept(req, except...)
+	default:
+		err = validate.Struct(req)
+	}
+
+	var validationErrors validator.ValidationErrors
+	if !errors.As(err, &validationErrors) {
+		return nil
+	}
+
+	response := &ValidationErrorResponse{Fields: make([]FieldError, 0, len(validationErrors))}
+	messages := make([]string, 0, len(validationErrors))
+	for _, fieldError := range validationErrors {
+		field := fieldName(fieldError)
+		message := fieldMessage(field, fieldError)
+		response.Fields = append(response.Fields, FieldError{Field: field, Message: message})
+		messages = append(messages, message)
+	}
+	response.Error = strings.Join(messages, "; ")
+	return response
+}
+
+// fieldName returns the JSON path of an invalid field without the request type,
+// e.g. "tls.mode" or "recipients[2]"
+func fieldName(fieldError validator.FieldError) string {
+	namespace := fieldError.Namespace()
+	if strings.HasPrefix(namespace, "[") {
+		// Elements of a slice body
+		return namespace
+	}
+	if _, field, ok := strings.Cut(namespace, "."); ok {
+		return field
+	}
+	return namespace
+}
+
+// fieldMessage describes a failed validation tag in words
+func fieldMessage(field string, fieldError validator.FieldError) string {
+	param := fieldError.Param()
+	switch fieldError.Tag() {
+	case "required", "required_if", "required_unless", "notblank":
+		return fmt.Sprintf("%s is required", field)
+	case "required_without":
+		return fmt.Sprintf("%s is required without %s", field, snakeCase(param))
+	case "max", "lte":
+		return fmt.Sprintf("%s must be at most %s%s", field, param, sizeUnit(fieldError.Kind()))
+	case "gt":
+		return fmt.Sprintf("%s must be greater than %s", field, param)
+	case "min", "gte":
+		return fmt.Sprintf("%s must be at least %s%s", field, param, sizeUnit(fieldError.Kind()))
+	case "oneof":
+		return fmt.Sprintf("%s must be one of: %s", field, strings.ReplaceAll(param, " ", ", "))
+	case "email":
+		return fmt.Sprintf("%s must be a valid email address", field)
+	case "port":
+		return fmt.Sprintf("%s must be a port between 1 and 65535", field)
+	case "cron":
+		return fmt.Sprintf("%s must be a valid cron expression", field)
+	case "timezone":
+		return fmt.Sprintf("%s must be a valid IANA timezone", field)
+	case "objectid":
+		return fmt.Sprintf("%s must be a valid ID", field)
+	case "url", "http_url":
+		return fmt.Sprintf("%s must be a valid URL", field)
+	default:
+		return fmt.Sprintf("%s is invalid", field)
+	}
+}
+
+// snakeCase turns the Go name of a field a tag refers to into its JSON name,
+// e.g. ConnectionURI into connection_uri
+func snakeCase(name string) string {
+	var b strings.Builder
+	for i, r := range name {
+		if i > 0 && unicode.IsUpper(r) {
+			prev := rune(name[i-1])
+			nextLower := i+1 < len(name) && unicode.IsLower(rune(name[i+1]))
+			if unicode.IsLower(prev) || unicode.IsUpper(prev) && nextLower {
+				b.WriteByte('_')
+			}
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return b.String()
+}
+
+// sizeUnit returns the unit min and max are measured in for a kind of field
+func sizeUnit(kind reflect.Kind) string {
+	switch kind {
+	case reflect.String:
+		return " characters"
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return " items"
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,41 @@
+package api
+
+import "testing"
+
+func TestValidateDatabaseRequestHost(t *testing.T) {
+	tests := []struct {
+		name string
+		req  DatabaseRequest
+		want string
+	}{
+		{"host", DatabaseRequest{Name: "App", Type: "postgresql", Host: "db.example.com", DatabaseName: "app"}, ""},
+		{"connection uri", DatabaseRequest{Name: "App", Type: "postgresql", ConnectionURI: "postgres://db.example.com/app", DatabaseName: "app"}, ""},
+		{"neither", DatabaseRequest{Name: "App", Type: "postgresql", DatabaseName: "app"}, "host is required without connection_uri"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ""
+			if invalid := validateRequest(&tt.req); invalid != nil {
+				got = invalid.Error
+			}
+			if got != tt.want {
+				t.Errorf("validateRequest() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSnakeCase(t *testing.T) {
+	tests := map[string]string{
+		"Minutes":       "minutes",
+		"ConnectionURI": "connection_uri",
+		"HostKey":       "host_key",
+		"URIScheme":     "uri_scheme",
+	}
+	for name, want := range tests {
+		if got := snakeCase(name); got != want {
+			t.Errorf("snakeCase(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,296 @@
+package api
+
+import (
+	"context"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/zucced/goquery/models"
+	"github.com/zucced/goquery/netguard"
+	"github.com/zucced/goquery/webhooks"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// WebhookRequest represents the request body for creating or updating a webhook
+type WebhookRequest struct {
+	URL    string   `json:"url" validate:"required,url,max=2048"`
+	Events []string `json:"events" validate:"min=1,max=20,dive,notblank"`
+	Active *bool    `json:"active"`
+}
+
+// validate normalizes a webhook request, returning an error message if the
+// URL isn't http(s), points at an address the server must not reach, or an
+// event is unknown
+func (req *WebhookRequest) validate(ctx context.Context) string {
+	req.URL = strings.TrimSpace(req.URL)
+	if err := netguard.CheckURL(ctx, req.URL); err != nil {
+		return err.Error()
+	}
+
+	events := make([]string, 0, len(req.Events))
+	seen := make(map[string]bool, len(req.Events))
+	for _, event := range req.Events {
+		event = strings.TrimSpace(event)
+		if !webhooks.IsEvent(event) {
+			return "Unknown event: " + event + ". Events are: " + strings.Join(webhooks.Events, ", ")
+		}
+		if !seen[event] {
+			seen[event] = true
+			events = append(events, event)
+		}
+	}
+	req.Events = events
+
+	return ""
+}
+
+// WebhookCreatedResponse is a new webhook together with its signing secret,
+// which isn't returned again
+type WebhookCreatedResponse struct {
+	*models.Webhook
+	Secret string `json:"secret"`
+}
+
+// CreateWebhookHandler handles creating a webhook for the current user
+func CreateWebhookHandler(store models.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from context
+		userID := c.Locals("user_id").(primitive.ObjectID)
+
+		// Parse and validate request body
+		var req WebhookRequest
+		if invalid := parseRequest(c, &req); invalid != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(invalid)
+		}
+
+		// Validate request
+		if msg := req.validate(c.UserContext()); msg != "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": msg,
+			})
+		}
+
+		active := true
+		if req.Active != nil {
+			active = *req.Active
+		}
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Create webhook
+		webhook, err := store.CreateWebhook(ctx, &models.Webhook{
+			UserID: userID,
+			URL:    req.URL,
+			Events: req.Events,
+			Active: active,
+		})
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to create webhook: " + err.Error(),
+			})
+		}
+
+		// Return response
+		return c.Status(fiber.StatusCreated).JSON(WebhookCreatedResponse{
+			Webhook: webhook,
+			Secret:  webhook.Secret,
+		})
+	}
+}
+
+// GetWebhooksHandler handles listing the webhooks of the current user
+func GetWebhooksHandler(store models.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from context
+		userID := c.Locals("user_id").(primitive.ObjectID)
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Get webhooks
+		hooks, err := store.GetWebhooksByUserID(ctx, userID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve webhooks: " + err.Error(),
+			})
+		}
+
+		// Return response
+		return c.JSON(fiber.Map{
+			"webhooks": hooks,
+			"events":   webhooks.Events,
+		})
+	}
+}
+
+// GetWebhookHandler handles retrieving a single webhook
+func GetWebhookHandler(store models.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		webhook, err := loadWebhook(c, store)
+		if webhook == nil {
+			return err
+		}
+
+		// Return response
+		return c.JSON(webhook)
+	}
+}
+
+// UpdateWebhookHandler handles updating a webhook's URL, events and whether
+// it is active
+func UpdateWebhookHandler(store models.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Parse and validate request body
+		var req WebhookRequest
+		if invalid := parseRequest(c, &req); invalid != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(invalid)
+		}
+
+		// Validate request
+		if msg := req.validate(c.UserContext()); msg != "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": msg,
+			})
+		}
+
+		webhook, err := loadWebhook(c, store)
+		if webhook == nil {
+			return err
+		}
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Update fields
+		webhook.URL = req.URL
+		webhook.Events = req.Events
+		if req.Active != nil {
+			webhook.Active = *req.Active
+		}
+
+		// Save webhook
+		if err := store.UpdateWebhook(ctx, webhook); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to update webhook: " + err.Error(),
+			})
+		}
+
+		// Return response
+		return c.JSON(webhook)
+	}
+}
+
+// DeleteWebhookHandler handles deleting a webhook and its delivery log
+func DeleteWebhookHandler(store models.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		webhook, err := loadWebhook(c, store)
+		if webhook == nil {
+			return err
+		}
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Delete webhook
+		if err := store.DeleteWebhook(ctx, webhook.ID); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to delete webhook: " + err.Error(),
+			})
+		}
+
+		// Return response
+		return c.JSON(fiber.Map{
+			"message": "Webhook deleted successfully",
+		})
+	}
+}
+
+// TestWebhookHandler handles sending a ping event to a webhook
+func TestWebhookHandler(store models.Store, hooks *webhooks.Dispatcher) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		webhook, err := loadWebhook(c, store)
+		if webhook == nil {
+			return err
+		}
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Queue ping
+		delivery, err := hooks.Ping(ctx, webhook)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to queue test delivery: " + err.Error(),
+			})
+		}
+
+		// Return response
+		return c.Status(fiber.StatusAccepted).JSON(delivery)
+	}
+}
+
+// GetWebhookDeliveriesHandler handles listing the delivery log of a webhook
+func GetWebhookDeliveriesHandler(store models.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get limit from query
+		limit := c.QueryInt("limit", 50)
+		if limit < 1 || limit > 200 {
+			limit = 50
+		}
+
+		webhook, err := loadWebhook(c, store)
+		if webhook == nil {
+			return err
+		}
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Get deliveries
+		deliveries, err := store.GetWebhookDeliveries(ctx, webhook.ID, limit)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve webhook deliveries: " + err.Error(),
+			})
+		}
+
+		// Return response
+		return c.JSON(deliveries)
+	}
+}
+
+// loadWebhook resolves the webhook in the request path and checks the user owns it.
+// When the webhook is nil the returned error is the response already written.
+func loadWebhook(c *fiber.Ctx, store models.Store) (*models.Webhook, error) {
+	// Get user ID from context
+	userID := c.Locals("user_id").(primitive.ObjectID)
+
+	// Get webhook ID from params
+	webhookID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return nil, c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid webhook ID",
+		})
+	}
+
+	// Get the request context
+	ctx := c.UserContext()
+
+	// Get webhook
+	webhook, err := store.GetWebhookByID(ctx, webhookID)
+	if err != nil {
+		return nil, c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to retrieve webhook: " + err.Error(),
+		})
+	}
+
+	// Check if webhook exists and belongs to user
+	if webhook == nil || webhook.UserID != userID {
+		return nil, c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Webhook not found",
+		})
+	}
+
+	return webhook, nil
+}
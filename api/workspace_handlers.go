@@ -0,0 +1,211 @@
+package api
+
+import (
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/zucced/goquery/middleware"
+	"github.com/zucced/goquery/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// WorkspaceRequest represents the request body for workspace operations
+type WorkspaceRequest struct {
+	Name string `json:"name" validate:"notblank,max=100"`
+}
+
+// CreateWorkspaceHandler handles creating a workspace in an organization
+func CreateWorkspaceHandler(store models.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from context
+		userID := c.Locals("user_id").(primitive.ObjectID)
+
+		// Parse and validate request body
+		var req WorkspaceRequest
+		if invalid := parseRequest(c, &req); invalid != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(invalid)
+		}
+
+		name := strings.TrimSpace(req.Name)
+
+		org, err := loadOrganization(c, store)
+		if org == nil {
+			return err
+		}
+
+		// Check if user can manage organization
+		if !org.CanManage(userID) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "You don't have permission to manage this organization",
+			})
+		}
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Create workspace
+		workspace, err := store.CreateWorkspace(ctx, &models.Workspace{
+			OrgID:     org.ID,
+			Name:      name,
+			CreatedBy: userID,
+		})
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to create workspace: " + err.Error(),
+			})
+		}
+
+		// Return response
+		return c.Status(fiber.StatusCreated).JSON(workspace)
+	}
+}
+
+// GetWorkspacesHandler handles listing the workspaces of an organization
+func GetWorkspacesHandler(store models.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		org, err := loadOrganization(c, store)
+		if org == nil {
+			return err
+		}
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Get workspaces
+		workspaces, err := store.GetWorkspacesByOrgID(ctx, org.ID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve workspaces: " + err.Error(),
+			})
+		}
+
+		// Return response
+		return c.JSON(fiber.Map{
+			"workspaces": workspaces,
+		})
+	}
+}
+
+// UpdateWorkspaceHandler handles renaming a workspace
+func UpdateWorkspaceHandler(store models.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from context
+		userID := c.Locals("user_id").(primitive.ObjectID)
+
+		// Parse and validate request body
+		var req WorkspaceRequest
+		if invalid := parseRequest(c, &req); invalid != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(invalid)
+		}
+
+		name := strings.TrimSpace(req.Name)
+
+		org, workspace, err := loadWorkspace(c, store)
+		if workspace == nil {
+			return err
+		}
+
+		// Check if user can manage organization
+		if !org.CanManage(userID) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "You don't have permission to manage this organization",
+			})
+		}
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Save workspace
+		workspace.Name = name
+		if err := store.UpdateWorkspace(ctx, workspace); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to update workspace: " + err.Error(),
+			})
+		}
+
+		// Return response
+		return c.JSON(workspace)
+	}
+}
+
+// DeleteWorkspaceHandler handles deleting a workspace. Its resources stay
+// shared with the organization.
+func DeleteWorkspaceHandler(store models.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from context
+		userID := c.Locals("user_id").(primitive.ObjectID)
+
+		org, workspace, err := loadWorkspace(c, store)
+		if workspace == nil {
+			return err
+		}
+
+		// Check if user can manage organization
+		if !org.CanManage(userID) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "You don't have permission to manage this organization",
+			})
+		}
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Delete workspace
+		if err := store.DeleteWorkspace(ctx, workspace.ID); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to delete workspace: " + err.Error(),
+			})
+		}
+
+		// Return response
+		return c.JSON(fiber.Map{
+			"message": "Workspace deleted successfully",
+		})
+	}
+}
+
+// loadWorkspace resolves the organization and workspace in the request path and
+// checks the user is a member. When the workspace is nil the returned error is
+// the response already written.
+func loadWorkspace(c *fiber.Ctx, store models.Store) (*models.Organization, *models.Workspace, error) {
+	org, err := loadOrganization(c, store)
+	if org == nil {
+		return nil, nil, err
+	}
+
+	// Get workspace ID from params
+	workspaceID, err := primitive.ObjectIDFromHex(c.Params("workspaceId"))
+	if err != nil {
+		return nil, nil, c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid workspace ID",
+		})
+	}
+
+	// Get the request context
+	ctx := c.UserContext()
+
+	// Get workspace
+	workspace, err := store.GetWorkspaceByID(ctx, workspaceID)
+	if err != nil {
+		return nil, nil, c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to retrieve workspace: " + err.Error(),
+		})
+	}
+
+	if workspace == nil || workspace.OrgID != org.ID {
+		return nil, nil, c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Workspace not found",
+		})
+	}
+
+	return org, workspace, nil
+}
+
+// workspaceScope returns the organization and workspace selected for the
+// request, both zero when the request isn't scoped to a workspace
+func workspaceScope(c *fiber.Ctx) (orgID, workspaceID primitive.ObjectID) {
+	if workspace := middleware.CurrentWorkspace(c); workspace != nil {
+		return workspace.OrgID, workspace.ID
+	}
+	return primitive.NilObjectID, primitive.NilObjectID
+}
@@ -0,0 +1,127 @@
+package cache
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Cache keeps values for a fixed time. Caching is best effort: failures to
+// reach the store are logged and treated as misses.
+type Cache interface {
+	// Get returns the value stored under key, reporting false when there is none
+	Get(ctx context.Context, key string) ([]byte, bool)
+	// Set stores a value under key until the cache's TTL passes
+	Set(ctx context.Context, key string, value []byte)
+}
+
+// New returns a cache of values kept for ttl, shared through Redis when a
+// client is given and kept in memory otherwise. Keys are prefixed with name so
+// caches sharing a Redis database don't collide. It returns nil when ttl is 0,
+// which disables the cache.
+func New(client *redis.Client, name string, ttl time.Duration) Cache {
+	if ttl <= 0 {
+		return nil
+	}
+	if client != nil {
+		return &redisCache{client: client, prefix: KeyPrefix + name + ":", ttl: ttl}
+	}
+	return &memoryCache{ttl: ttl, entries: make(map[string]memoryEntry)}
+}
+
+// KeyPrefix starts every Redis key the server uses
+const KeyPrefix = "goquery:"
+
+// Connect connects to the Redis server at a redis:// or rediss:// URL and
+// checks it is reachable
+func Connect(ctx context.Context, url string) (*redis.Client, error) {
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, err
+	}
+
+	client := redis.NewClient(opts)
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, err
+	}
+	return client, nil
+}
+
+// redisCache keeps values in Redis, shared by every server
+type redisCache struct {
+	client *redis.Client
+	prefix string
+	ttl    time.Duration
+}
+
+func (c *redisCache) Get(ctx context.Context, key string) ([]byte, bool) {
+	value, err := c.client.Get(ctx, c.prefix+key).Bytes()
+	if err != nil {
+		if err != redis.Nil {
+			log.Printf("Failed to read %s from the cache: %v", c.prefix+key, err)
+		}
+		return nil, false
+	}
+	return value, true
+}
+
+func (c *redisCache) Set(ctx context.Context, key string, value []byte) {
+	if err := c.client.Set(ctx, c.prefix+key, value, c.ttl).Err(); err != nil {
+		log.Printf("Failed to write %s to the cache: %v", c.prefix+key, err)
+	}
+}
+
+// maxMemoryEntries bounds the number of values a memory cache keeps
+const maxMemoryEntries = 1000
+
+// memoryEntry is a value kept in memory until it expires
+type memoryEntry struct {
+	value     []byte
+	expiresAt time.Time
+}
+
+// memoryCache keeps values in the server's memory
+type memoryCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]memoryEntry
+}
+
+func (c *memoryCache) Get(ctx context.Context, key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok || time.Now().After(entry.expiresAt) {
+		return nil, false
+	}
+	return entry.value, true
+}
+
+func (c *memoryCache) Set(ctx context.Context, key string, value []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if len(c.entries) >= maxMemoryEntries {
+		c.sweep(now)
+	}
+	// Skip values while the cache is full of unexpired ones
+	if len(c.entries) >= maxMemoryEntries {
+		return
+	}
+	c.entries[key] = memoryEntry{value: value, expiresAt: now.Add(c.ttl)}
+}
+
+// sweep drops expired values. Callers must hold c.mu.
+func (c *memoryCache) sweep(now time.Time) {
+	for key, entry := range c.entries {
+		if now.After(entry.expiresAt) {
+			delete(c.entries, key)
+		}
+	}
+}
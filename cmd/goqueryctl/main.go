@@ -0,0 +1,292 @@
+// Command goqueryctl runs operational tasks against a GoQuery installation,
+// using the same configuration as the server, so nobody has to edit MongoDB
+// by hand.
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/zucced/goquery/config"
+	"github.com/zucced/goquery/database"
+	"github.com/zucced/goquery/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// command is a goqueryctl subcommand
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) error
+}
+
+var commands = []command{
+	{"create-admin", "Create a user listed in ADMIN_EMAILS, reading the password from stdin", createAdmin},
+	{"refresh-schemas", "Refresh the schemas of every connection, or of one", refreshSchemas},
+	{"purge-results", "Remove the stored results of queries last run long ago", purgeResults},
+	{"export-metadata", "Write users, connections, queries, dashboards and their settings to a file", exportMetadata},
+	{"import-metadata", "Save the documents of an export, replacing the ones with the same ID", importMetadata},
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	for _, cmd := range commands {
+		if cmd.name == os.Args[1] {
+			if err := cmd.run(os.Args[2:]); err != nil {
+				fmt.Fprintf(os.Stderr, "goqueryctl %s: %v\n", cmd.name, err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
+	usage()
+	os.Exit(2)
+}
+
+// usage lists the commands
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: goqueryctl <command> [flags]")
+	fmt.Fprintln(os.Stderr)
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-16s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Run goqueryctl <command> -h for the flags of a command.")
+}
+
+// connect loads the server's configuration and connects to its MongoDB
+func connect() (*config.Config, models.Store, func(), error) {
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to load configuration: %v", err)
+	}
+
+	db, err := database.ConnectDB(cfg)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return cfg, models.NewStore(db), func() { database.DisconnectDB(db) }, nil
+}
+
+// createAdmin creates the first admin user. Admins are the users whose email
+// is listed in ADMIN_EMAILS, so the email must be listed there already.
+func createAdmin(args []string) error {
+	flags := flag.NewFlagSet("create-admin", flag.ExitOnError)
+	email := flags.String("email", "", "Email of the user, listed in ADMIN_EMAILS")
+	name := flags.String("name", "", "Name of the user")
+	flags.Parse(args)
+
+	if *email == "" {
+		return errors.New("-email is required")
+	}
+
+	cfg, store, disconnect, err := connect()
+	if err != nil {
+		return err
+	}
+	defer disconnect()
+
+	if !cfg.IsAdmin(*email) {
+		return fmt.Errorf("%s is not listed in ADMIN_EMAILS, add it there first", *email)
+	}
+
+	// The password is read from stdin to keep it out of the shell history
+	fmt.Fprint(os.Stderr, "Password: ")
+	password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && password == "" {
+		return fmt.Errorf("failed to read the password: %v", err)
+	}
+	password = strings.TrimRight(password, "\r\n")
+	if len(password) < 8 || len(password) > 72 {
+		return errors.New("the password must be 8 to 72 characters long")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// The unique email index keeps a user from being created twice
+	if err := store.EnsureIndexes(ctx); err != nil {
+		return fmt.Errorf("failed to create indexes: %v", err)
+	}
+
+	user, err := store.CreateUser(ctx, *email, password, *name)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Created admin %s (%s)\n", user.Email, user.ID.Hex())
+	return nil
+}
+
+// refreshSchemas refreshes schemas one connection at a time, reporting each
+func refreshSchemas(args []string) error {
+	flags := flag.NewFlagSet("refresh-schemas", flag.ExitOnError)
+	databaseID := flags.String("database", "", "ID of the only connection to refresh")
+	timeout := flags.Duration("timeout", 5*time.Minute, "How long each refresh may take")
+	flags.Parse(args)
+
+	cfg, store, disconnect, err := connect()
+	if err != nil {
+		return err
+	}
+	defer disconnect()
+
+	// Refresh like the server does, through the same tunnels and samples
+	defer models.SSHTunnels.Close()
+	models.MongoDBSchemaSampleSize = cfg.MongoDBSchemaSampleSize
+
+	var ids []primitive.ObjectID
+	if *databaseID != "" {
+		id, err := primitive.ObjectIDFromHex(*databaseID)
+		if err != nil {
+			return errors.New("-database must be a valid ID")
+		}
+		ids = append(ids, id)
+	} else {
+		listCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		databases, err := store.GetDatabasesForHealthCheck(listCtx)
+		cancel()
+		if err != nil {
+			return fmt.Errorf("failed to list connections: %v", err)
+		}
+		for _, db := range databases {
+			ids = append(ids, db.ID)
+		}
+	}
+
+	failed := 0
+	for _, id := range ids {
+		ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+		_, err := store.RefreshDatabaseSchema(ctx, id)
+		cancel()
+		if err != nil {
+			failed++
+			fmt.Printf("%s failed: %v\n", id.Hex(), err)
+			continue
+		}
+		fmt.Printf("%s refreshed\n", id.Hex())
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d refreshes failed", failed, len(ids))
+	}
+	return nil
+}
+
+// purgeResults removes old query results to reclaim space
+func purgeResults(args []string) error {
+	flags := flag.NewFlagSet("purge-results", flag.ExitOnError)
+	olderThan := flags.Duration("older-than", 30*24*time.Hour, "Purge the results of queries last run longer ago than this")
+	flags.Parse(args)
+
+	if *olderThan <= 0 {
+		return errors.New("-older-than must be positive")
+	}
+
+	_, store, disconnect, err := connect()
+	if err != nil {
+		return err
+	}
+	defer disconnect()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	purged, err := store.PurgeQueryResults(ctx, time.Now().Add(-*olderThan))
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Purged the results of %d queries\n", purged)
+	return nil
+}
+
+// exportMetadata writes the metadata export to a file only the current user
+// can read, since it holds password hashes and connection credentials
+func exportMetadata(args []string) error {
+	flags := flag.NewFlagSet("export-metadata", flag.ExitOnError)
+	output := flags.String("o", "goquery-metadata.jsonl", "File to write the export to")
+	flags.Parse(args)
+
+	_, store, disconnect, err := connect()
+	if err != nil {
+		return err
+	}
+	defer disconnect()
+
+	file, err := os.OpenFile(*output, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+
+	writer := bufio.NewWriter(file)
+	counts, err := store.ExportMetadata(context.Background(), writer)
+	if err == nil {
+		err = writer.Flush()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	printCounts("Exported", counts)
+	return nil
+}
+
+// importMetadata saves an export, e.g. into a new installation
+func importMetadata(args []string) error {
+	flags := flag.NewFlagSet("import-metadata", flag.ExitOnError)
+	input := flags.String("i", "goquery-metadata.jsonl", "File to read the export from")
+	flags.Parse(args)
+
+	file, err := os.Open(*input)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, store, disconnect, err := connect()
+	if err != nil {
+		return err
+	}
+	defer disconnect()
+
+	// Create the indexes first, so the unique ones catch conflicting documents
+	indexCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	err = store.EnsureIndexes(indexCtx)
+	cancel()
+	if err != nil {
+		return fmt.Errorf("failed to create indexes: %v", err)
+	}
+
+	counts, err := store.ImportMetadata(context.Background(), file)
+	printCounts("Imported", counts)
+	return err
+}
+
+// printCounts reports how many documents of each collection were handled
+func printCounts(verb string, counts map[string]int64) {
+	names := make([]string, 0, len(counts))
+	for name := range counts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		fmt.Printf("%s %d %s\n", verb, counts[name], name)
+	}
+}
@@ -1,13 +1,19 @@
 package config
 
 import (
+	"net/url"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/joho/godotenv"
 )
 
+// DefaultSwaggerUIURL pins the exact Swagger UI release the API docs load, so
+// a new release can't change what runs on the page
+const DefaultSwaggerUIURL = "https://unpkg.com/swagger-ui-dist@5.17.14"
+
 // Config holds all configuration for the application
 type Config struct {
 	AppPort           int
@@ -16,10 +22,75 @@ type Config struct {
 	MongoDatabase     string
 	JWTSecret         string
 	JWTExpiry         time.Duration
-	AllowOrigins      string
+	AllowOrigins      []string // Origins browsers may call the API from, see NormalizeOrigin
 	OpenRouterAPIKey  string
 	OpenRouterModel   string
 	OpenRouterBaseURL string
+	TrashRetention    time.Duration
+
+	MaxConcurrentQueriesPerUser     int
+	MaxConcurrentQueriesPerDatabase int
+	QueryQueueTimeout               time.Duration
+	MaxResultRows                   int
+	MongoDBSchemaSampleSize         int
+
+	ConnectionHealthInterval time.Duration
+	DegradedLatency          time.Duration
+	ImportSecretPrefix       string // Environment variables connection imports can reference as ${secret:NAME} start with it
+
+	MailProvider string // smtp, ses or sendgrid, empty when email is disabled
+	MailFrom     string
+	SMTPHost     string
+	SMTPPort     int
+	SMTPUsername string
+	SMTPPassword string
+
+	SESRegion          string
+	SESAccessKeyID     string
+	SESSecretAccessKey string
+	SendGridAPIKey     string
+
+	EmbeddableDomains []string
+
+	GoogleClientID     string // OAuth client for exporting to Google Sheets, which is disabled without one
+	GoogleClientSecret string
+
+	FrontendURL         string
+	PublicURL           string
+	PasswordResetExpiry time.Duration
+
+	DemoMode            bool
+	DemoDatabaseURL     string
+	DemoSessionDuration time.Duration
+	SampleData          bool // Give new users the sample database, example queries and a starter dashboard
+
+	RateLimitWindow     time.Duration
+	RateLimitRequests   int
+	RateLimitAIRequests int
+
+	ShutdownTimeout     time.Duration
+	RequestTimeout      time.Duration
+	QueryRequestTimeout time.Duration // For routes that run queries or call the AI model
+
+	CompressionMinSize int
+
+	AdminEmails     []string
+	JobConcurrency  int
+	JobPollInterval time.Duration
+
+	RedisURL       string
+	AICacheTTL     time.Duration
+	ResultCacheTTL time.Duration
+
+	FeatureFlags map[string]bool
+
+	AuditBodyCapture string // redacted or none
+	AuditBodyMaxSize int
+	AuditBodyExclude []string // Path prefixes whose request bodies are never captured
+
+	SwaggerUIURL          string // Where the API docs load a pinned Swagger UI from
+	SwaggerUICSSIntegrity string // Subresource Integrity hashes of its stylesheet and script
+	SwaggerUIJSIntegrity  string
 }
 
 // LoadConfig loads configuration from environment variables
@@ -29,13 +100,49 @@ func LoadConfig() (*Config, error) {
 
 	// Set default values
 	config := &Config{
-		AppPort:       8080,
-		AppEnv:        "development",
-		MongoURI:      "mongodb://localhost:27017",
-		MongoDatabase: "goquery",
-		JWTSecret:     "your-secret-key",
-		JWTExpiry:     time.Hour * 24 * 7, // 7 days
-		AllowOrigins:  "*",
+		AppPort:        8080,
+		AppEnv:         "development",
+		MongoURI:       "mongodb://localhost:27017",
+		MongoDatabase:  "goquery",
+		JWTSecret:      DefaultJWTSecret,
+		JWTExpiry:      time.Hour * 24 * 7,  // 7 days
+		TrashRetention: time.Hour * 24 * 30, // 30 days
+
+		MaxConcurrentQueriesPerUser:     3,
+		MaxConcurrentQueriesPerDatabase: 5,
+		QueryQueueTimeout:               10 * time.Second,
+		MaxResultRows:                   10000,
+		MongoDBSchemaSampleSize:         100,
+
+		ConnectionHealthInterval: 5 * time.Minute,
+		DegradedLatency:          2 * time.Second,
+		ImportSecretPrefix:       "GOQUERY_SECRET_",
+
+		SMTPPort: 587,
+
+		FrontendURL:         "http://localhost:3000",
+		PasswordResetExpiry: time.Hour,
+
+		DemoSessionDuration: time.Hour,
+
+		RateLimitWindow:     time.Minute,
+		RateLimitRequests:   300,
+		RateLimitAIRequests: 10,
+
+		ShutdownTimeout:     30 * time.Second,
+		RequestTimeout:      30 * time.Second,
+		QueryRequestTimeout: 5 * time.Minute,
+
+		CompressionMinSize: 1024,
+
+		JobConcurrency:  4,
+		JobPollInterval: 5 * time.Second,
+
+		AuditBodyCapture: "redacted",
+		AuditBodyMaxSize: 8 * 1024,
+		AuditBodyExclude: []string{"/api/auth"},
+
+		SwaggerUIURL: DefaultSwaggerUIURL,
 	}
 
 	// Override with environment variables if they exist
@@ -67,8 +174,17 @@ func LoadConfig() (*Config, error) {
 		}
 	}
 
+	// Invalid origins are kept so production can report them
 	if origins := os.Getenv("ALLOW_ORIGINS"); origins != "" {
-		config.AllowOrigins = origins
+		for _, origin := range strings.Split(origins, ",") {
+			if origin = strings.TrimSpace(origin); origin == "" {
+				continue
+			}
+			if normalized, err := NormalizeOrigin(origin); err == nil {
+				origin = normalized
+			}
+			config.AllowOrigins = append(config.AllowOrigins, origin)
+		}
 	}
 
 	if apiKey := os.Getenv("OPENROUTER_API_KEY"); apiKey != "" {
@@ -89,5 +205,281 @@ func LoadConfig() (*Config, error) {
 		config.OpenRouterBaseURL = "https://api.deepseek.com/chat/completions"
 	}
 
+	if retention := os.Getenv("TRASH_RETENTION"); retention != "" {
+		if r, err := time.ParseDuration(retention); err == nil {
+			config.TrashRetention = r
+		}
+	}
+
+	if limit := os.Getenv("MAX_CONCURRENT_QUERIES_PER_USER"); limit != "" {
+		if l, err := strconv.Atoi(limit); err == nil {
+			config.MaxConcurrentQueriesPerUser = l
+		}
+	}
+
+	if limit := os.Getenv("MAX_CONCURRENT_QUERIES_PER_DATABASE"); limit != "" {
+		if l, err := strconv.Atoi(limit); err == nil {
+			config.MaxConcurrentQueriesPerDatabase = l
+		}
+	}
+
+	if timeout := os.Getenv("QUERY_QUEUE_TIMEOUT"); timeout != "" {
+		if t, err := time.ParseDuration(timeout); err == nil {
+			config.QueryQueueTimeout = t
+		}
+	}
+
+	if maxRows := os.Getenv("MAX_RESULT_ROWS"); maxRows != "" {
+		if m, err := strconv.Atoi(maxRows); err == nil && m > 0 {
+			config.MaxResultRows = m
+		}
+	}
+
+	if sampleSize := os.Getenv("MONGO_SCHEMA_SAMPLE_SIZE"); sampleSize != "" {
+		if s, err := strconv.Atoi(sampleSize); err == nil && s > 0 {
+			config.MongoDBSchemaSampleSize = s
+		}
+	}
+
+	if interval := os.Getenv("CONNECTION_HEALTH_INTERVAL"); interval != "" {
+		if i, err := time.ParseDuration(interval); err == nil {
+			config.ConnectionHealthInterval = i
+		}
+	}
+
+	if latency := os.Getenv("CONNECTION_DEGRADED_LATENCY"); latency != "" {
+		if l, err := time.ParseDuration(latency); err == nil && l > 0 {
+			config.DegradedLatency = l
+		}
+	}
+
+	if prefix := os.Getenv("IMPORT_SECRET_PREFIX"); prefix != "" {
+		config.ImportSecretPrefix = prefix
+	}
+
+	if host := os.Getenv("SMTP_HOST"); host != "" {
+		config.SMTPHost = host
+	}
+
+	if port := os.Getenv("SMTP_PORT"); port != "" {
+		if p, err := strconv.Atoi(port); err == nil {
+			config.SMTPPort = p
+		}
+	}
+
+	if username := os.Getenv("SMTP_USERNAME"); username != "" {
+		config.SMTPUsername = username
+	}
+
+	if password := os.Getenv("SMTP_PASSWORD"); password != "" {
+		config.SMTPPassword = password
+	}
+
+	if from := os.Getenv("MAIL_FROM"); from != "" {
+		config.MailFrom = from
+	} else if from := os.Getenv("SMTP_FROM"); from != "" {
+		config.MailFrom = from
+	} else {
+		config.MailFrom = config.SMTPUsername
+	}
+
+	config.SESRegion = firstEnv("SES_REGION", "AWS_REGION")
+	config.SESAccessKeyID = firstEnv("SES_ACCESS_KEY_ID", "AWS_ACCESS_KEY_ID")
+	config.SESSecretAccessKey = firstEnv("SES_SECRET_ACCESS_KEY", "AWS_SECRET_ACCESS_KEY")
+	config.SendGridAPIKey = os.Getenv("SENDGRID_API_KEY")
+
+	// Email goes through SMTP when a host is set, unless another provider is picked
+	if provider := os.Getenv("MAIL_PROVIDER"); provider != "" {
+		config.MailProvider = strings.ToLower(strings.TrimSpace(provider))
+	} else if config.SMTPHost != "" {
+		config.MailProvider = "smtp"
+	}
+
+	config.GoogleClientID = os.Getenv("GOOGLE_CLIENT_ID")
+	config.GoogleClientSecret = os.Getenv("GOOGLE_CLIENT_SECRET")
+
+	if domains := os.Getenv("EMBEDDABLE_DOMAINS"); domains != "" {
+		for _, domain := range strings.Split(domains, ",") {
+			if domain = strings.TrimSpace(domain); domain != "" {
+				config.EmbeddableDomains = append(config.EmbeddableDomains, domain)
+			}
+		}
+	}
+
+	if url := os.Getenv("FRONTEND_URL"); url != "" {
+		config.FrontendURL = strings.TrimRight(url, "/")
+	}
+
+	// Without ALLOW_ORIGINS production only allows the frontend, while
+	// development allows any origin
+	if config.AllowOrigins == nil {
+		if !config.IsProduction() {
+			config.AllowOrigins = []string{"*"}
+		} else if frontend, err := url.Parse(config.FrontendURL); err == nil {
+			if origin, err := NormalizeOrigin(frontend.Scheme + "://" + frontend.Host); err == nil {
+				config.AllowOrigins = []string{origin}
+			}
+		}
+	}
+
+	if url := os.Getenv("PUBLIC_URL"); url != "" {
+		config.PublicURL = strings.TrimRight(url, "/")
+	} else {
+		// Default to the local server if not specified
+		config.PublicURL = "http://localhost:" + strconv.Itoa(config.AppPort)
+	}
+
+	if expiry := os.Getenv("PASSWORD_RESET_EXPIRY"); expiry != "" {
+		if e, err := time.ParseDuration(expiry); err == nil && e > 0 {
+			config.PasswordResetExpiry = e
+		}
+	}
+
+	if demo := os.Getenv("DEMO_MODE"); demo != "" {
+		if d, err := strconv.ParseBool(demo); err == nil {
+			config.DemoMode = d
+		}
+	}
+
+	if url := os.Getenv("DEMO_DATABASE_URL"); url != "" {
+		config.DemoDatabaseURL = url
+	}
+
+	if duration := os.Getenv("DEMO_SESSION_DURATION"); duration != "" {
+		if d, err := time.ParseDuration(duration); err == nil && d > 0 {
+			config.DemoSessionDuration = d
+		}
+	}
+
+	if sample := os.Getenv("SAMPLE_DATA"); sample != "" {
+		if d, err := strconv.ParseBool(sample); err == nil {
+			config.SampleData = d
+		}
+	}
+
+	if window := os.Getenv("RATE_LIMIT_WINDOW"); window != "" {
+		if w, err := time.ParseDuration(window); err == nil && w > 0 {
+			config.RateLimitWindow = w
+		}
+	}
+
+	if limit := os.Getenv("RATE_LIMIT_REQUESTS"); limit != "" {
+		if l, err := strconv.Atoi(limit); err == nil {
+			config.RateLimitRequests = l
+		}
+	}
+
+	if limit := os.Getenv("RATE_LIMIT_AI_REQUESTS"); limit != "" {
+		if l, err := strconv.Atoi(limit); err == nil {
+			config.RateLimitAIRequests = l
+		}
+	}
+
+	if timeout := os.Getenv("SHUTDOWN_TIMEOUT"); timeout != "" {
+		if t, err := time.ParseDuration(timeout); err == nil && t > 0 {
+			config.ShutdownTimeout = t
+		}
+	}
+
+	if timeout := os.Getenv("REQUEST_TIMEOUT"); timeout != "" {
+		if t, err := time.ParseDuration(timeout); err == nil && t > 0 {
+			config.RequestTimeout = t
+		}
+	}
+
+	if timeout := os.Getenv("QUERY_REQUEST_TIMEOUT"); timeout != "" {
+		if t, err := time.ParseDuration(timeout); err == nil && t > 0 {
+			config.QueryRequestTimeout = t
+		}
+	}
+
+	if size := os.Getenv("COMPRESSION_MIN_SIZE"); size != "" {
+		if s, err := strconv.Atoi(size); err == nil && s >= 0 {
+			config.CompressionMinSize = s
+		}
+	}
+
+	if emails := os.Getenv("ADMIN_EMAILS"); emails != "" {
+		for _, email := range strings.Split(emails, ",") {
+			if email = strings.TrimSpace(email); email != "" {
+				config.AdminEmails = append(config.AdminEmails, email)
+			}
+		}
+	}
+
+	if concurrency := os.Getenv("JOB_CONCURRENCY"); concurrency != "" {
+		if c, err := strconv.Atoi(concurrency); err == nil && c > 0 {
+			config.JobConcurrency = c
+		}
+	}
+
+	if interval := os.Getenv("JOB_POLL_INTERVAL"); interval != "" {
+		if i, err := time.ParseDuration(interval); err == nil && i > 0 {
+			config.JobPollInterval = i
+		}
+	}
+
+	if url := os.Getenv("REDIS_URL"); url != "" {
+		config.RedisURL = url
+	}
+
+	if ttl := os.Getenv("AI_CACHE_TTL"); ttl != "" {
+		if t, err := time.ParseDuration(ttl); err == nil && t >= 0 {
+			config.AICacheTTL = t
+		}
+	}
+
+	if ttl := os.Getenv("RESULT_CACHE_TTL"); ttl != "" {
+		if t, err := time.ParseDuration(ttl); err == nil && t >= 0 {
+			config.ResultCacheTTL = t
+		}
+	}
+
+	if flags := os.Getenv("FEATURE_FLAGS"); flags != "" {
+		config.FeatureFlags = make(map[string]bool)
+		for _, flag := range strings.Split(flags, ",") {
+			key, value, _ := strings.Cut(strings.TrimSpace(flag), "=")
+			if enabled, err := strconv.ParseBool(value); err == nil && key != "" {
+				config.FeatureFlags[key] = enabled
+			}
+		}
+	}
+
+	if capture := strings.ToLower(os.Getenv("AUDIT_BODY_CAPTURE")); capture == "redacted" || capture == "none" {
+		config.AuditBodyCapture = capture
+	}
+
+	if size := os.Getenv("AUDIT_BODY_MAX_SIZE"); size != "" {
+		if s, err := strconv.Atoi(size); err == nil && s > 0 {
+			config.AuditBodyMaxSize = s
+		}
+	}
+
+	if exclude, ok := os.LookupEnv("AUDIT_BODY_EXCLUDE"); ok {
+		config.AuditBodyExclude = nil
+		for _, prefix := range strings.Split(exclude, ",") {
+			if prefix = strings.TrimSpace(prefix); prefix != "" {
+				config.AuditBodyExclude = append(config.AuditBodyExclude, prefix)
+			}
+		}
+	}
+
+	if swaggerURL := os.Getenv("SWAGGER_UI_URL"); swaggerURL != "" {
+		config.SwaggerUIURL = strings.TrimRight(swaggerURL, "/")
+	}
+
+	config.SwaggerUICSSIntegrity = os.Getenv("SWAGGER_UI_CSS_INTEGRITY")
+	config.SwaggerUIJSIntegrity = os.Getenv("SWAGGER_UI_JS_INTEGRITY")
+
 	return config, nil
 }
+
+// firstEnv returns the first of the environment variables that is set
+func firstEnv(names ...string) string {
+	for _, name := range names {
+		if value := os.Getenv(name); value != "" {
+			return value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,56 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// NormalizeOrigin checks that origin is one browsers send, such as
+// https://app.example.com or http://localhost:3000, and returns it in the
+// lower case browsers use. A * in place of the first label of the host
+// allows every subdomain, and * alone allows every origin.
+func NormalizeOrigin(origin string) (string, error) {
+	origin = strings.TrimSpace(origin)
+	if origin == "*" {
+		return origin, nil
+	}
+
+	u, err := url.Parse(strings.TrimSuffix(origin, "/"))
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", errors.New("must start with http:// or https://")
+	}
+	if u.Host == "" {
+		return "", errors.New("must have a host")
+	}
+	if u.User != nil || u.Path != "" || u.RawQuery != "" || u.Fragment != "" {
+		return "", errors.New("must only have a scheme, host and port")
+	}
+
+	host := strings.ToLower(u.Host)
+	if strings.Contains(strings.TrimPrefix(host, "*."), "*") {
+		return "", errors.New("can only use * in place of the first part of the host")
+	}
+
+	return u.Scheme + "://" + host, nil
+}
+
+// originProblems lists the allowed origins that aren't fit for production
+func (c *Config) originProblems() []string {
+	var problems []string
+	if len(c.AllowOrigins) == 0 {
+		problems = append(problems, "ALLOW_ORIGINS must list the frontend's origins")
+	}
+	for _, origin := range c.AllowOrigins {
+		if origin == "*" {
+			problems = append(problems, "ALLOW_ORIGINS must list the frontend's origins instead of *")
+		} else if _, err := NormalizeOrigin(origin); err != nil {
+			problems = append(problems, fmt.Sprintf("ALLOW_ORIGINS has an invalid origin %q: %v", origin, err))
+		}
+	}
+	return problems
+}
@@ -0,0 +1,57 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DefaultJWTSecret signs tokens when JWT_SECRET isn't set. It is public, so
+// it is only fit for development.
+const DefaultJWTSecret = "your-secret-key"
+
+// MinJWTSecretLength is the minimum length of the JWT secret in production
+const MinJWTSecretLength = 32
+
+// IsProduction reports whether the server runs in production
+func (c *Config) IsProduction() bool {
+	return c.AppEnv == "production"
+}
+
+// IsAdmin reports whether a user's email is listed in ADMIN_EMAILS
+func (c *Config) IsAdmin(email string) bool {
+	for _, admin := range c.AdminEmails {
+		if strings.EqualFold(admin, email) {
+			return true
+		}
+	}
+	return false
+}
+
+// ProductionProblems lists the settings that are insecure or missing for
+// running in production. Every problem is reported, not just the first.
+func (c *Config) ProductionProblems() []string {
+	var problems []string
+
+	switch {
+	case c.JWTSecret == "" || c.JWTSecret == DefaultJWTSecret:
+		problems = append(problems, "JWT_SECRET must be set, the default secret is public")
+	case len(c.JWTSecret) < MinJWTSecretLength:
+		problems = append(problems, fmt.Sprintf("JWT_SECRET must be at least %d characters", MinJWTSecretLength))
+	}
+
+	problems = append(problems, c.originProblems()...)
+
+	if c.OpenRouterAPIKey == "" {
+		problems = append(problems, "OPENROUTER_API_KEY must be set to generate queries")
+	}
+
+	if c.DemoMode && c.DemoDatabaseURL == "" {
+		problems = append(problems, "DEMO_DATABASE_URL must be set when DEMO_MODE is on")
+	}
+
+	if c.SampleData && c.DemoDatabaseURL == "" {
+		problems = append(problems, "DEMO_DATABASE_URL must be set when SAMPLE_DATA is on")
+	}
+
+	return problems
+}
@@ -11,14 +11,8 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/readpref"
 )
 
-// DB is a global MongoDB client
-var DB *mongo.Client
-
-// Database is a global MongoDB database
-var Database *mongo.Database
-
 // ConnectDB establishes a connection to the MongoDB database
-func ConnectDB(cfg *config.Config) error {
+func ConnectDB(cfg *config.Config) (*mongo.Database, error) {
 	// Set client options
 	clientOptions := options.Client().ApplyURI(cfg.MongoURI)
 
@@ -34,40 +28,32 @@ func ConnectDB(cfg *config.Config) error {
 	// Connect to MongoDB
 	client, err := mongo.Connect(ctx, clientOptions)
 	if err != nil {
-		return fmt.Errorf("failed to connect to MongoDB: %w", err)
+		return nil, fmt.Errorf("failed to connect to MongoDB: %w", err)
 	}
 
 	// Ping the database to verify connection
 	if err := client.Ping(ctx, readpref.Primary()); err != nil {
-		return fmt.Errorf("failed to ping MongoDB: %w", err)
+		client.Disconnect(ctx)
+		return nil, fmt.Errorf("failed to ping MongoDB: %w", err)
 	}
 
-	// Set global variables
-	DB = client
-	Database = client.Database(cfg.MongoDatabase)
-
 	fmt.Println("Connected to MongoDB!")
-	return nil
+	return client.Database(cfg.MongoDatabase), nil
 }
 
 // DisconnectDB closes the connection to the MongoDB database
-func DisconnectDB() error {
-	if DB == nil {
+func DisconnectDB(db *mongo.Database) error {
+	if db == nil {
 		return nil
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	if err := DB.Disconnect(ctx); err != nil {
+	if err := db.Client().Disconnect(ctx); err != nil {
 		return fmt.Errorf("failed to disconnect from MongoDB: %w", err)
 	}
 
 	fmt.Println("Disconnected from MongoDB")
 	return nil
 }
-
-// GetCollection returns a MongoDB collection
-func GetCollection(collectionName string) *mongo.Collection {
-	return Database.Collection(collectionName)
-}
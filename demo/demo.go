@@ -0,0 +1,134 @@
+package demo
+
+import (
+	"context"
+	"database/sql"
+	_ "embed"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	_ "github.com/lib/pq" // PostgreSQL driver
+	"github.com/zucced/goquery/models"
+)
+
+//go:embed sample.sql
+var sampleSQL string
+
+// Provisioner creates anonymous, time-boxed demo users bound to a copy of the
+// sample database connection, and gives new users a copy of it to start with
+type Provisioner struct {
+	store    models.Store
+	template *models.Database
+	duration time.Duration
+}
+
+// NewProvisioner seeds the sample database if it is empty and loads its schema
+// so demo users can start querying right away
+func NewProvisioner(ctx context.Context, store models.Store, databaseURL string, duration time.Duration) (*Provisioner, error) {
+	template, err := parseDatabaseURL(databaseURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := seed(ctx, databaseURL); err != nil {
+		return nil, fmt.Errorf("failed to seed sample database: %v", err)
+	}
+
+	schema, err := models.FetchDatabaseSchema(ctx, template)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch sample database schema: %v", err)
+	}
+	template.Schema = schema
+
+	if stats, err := models.FetchDatabaseStats(ctx, template); err == nil {
+		template.Stats = stats
+	}
+
+	return &Provisioner{store: store, template: template, duration: duration}, nil
+}
+
+// Duration returns how long a demo session lasts
+func (p *Provisioner) Duration() time.Duration {
+	return p.duration
+}
+
+// Provision creates a demo user with its own read-only copy of the sample
+// database connection, the example queries and the starter dashboard
+func (p *Provisioner) Provision(ctx context.Context) (*models.User, *models.Database, error) {
+	user, err := p.store.CreateDemoUser(ctx, time.Now().Add(p.duration))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	created, err := p.connect(ctx, user.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if _, _, err := p.seedExamples(ctx, user.ID, created); err != nil {
+		return nil, nil, err
+	}
+
+	// Queries run against the sample database without picking it
+	user.Preferences.DefaultDatabaseID = created.ID
+	if err := p.store.UpdateUserPreferences(ctx, user.ID, &user.Preferences); err != nil {
+		return nil, nil, err
+	}
+
+	return user, created, nil
+}
+
+// parseDatabaseURL turns a postgres:// URL into a database connection
+func parseDatabaseURL(databaseURL string) (*models.Database, error) {
+	u, err := url.Parse(databaseURL)
+	if err != nil || (u.Scheme != "postgres" && u.Scheme != "postgresql") {
+		return nil, fmt.Errorf("demo database URL must be a postgres:// URL")
+	}
+
+	password, _ := u.User.Password()
+	port := u.Port()
+	if port == "" {
+		port = "5432"
+	}
+
+	return &models.Database{
+		Name:         "Sample Store",
+		Type:         "postgresql",
+		Host:         u.Hostname(),
+		Port:         port,
+		Username:     u.User.Username(),
+		Password:     password,
+		DatabaseName: strings.TrimPrefix(u.Path, "/"),
+		SSL:          u.Query().Get("sslmode") != "" && u.Query().Get("sslmode") != "disable",
+	}, nil
+}
+
+// seed loads the sample data unless the database already has it
+func seed(ctx context.Context, databaseURL string) error {
+	conn, err := sql.Open("postgres", databaseURL)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var existing sql.NullString
+	if err := conn.QueryRowContext(ctx, "SELECT to_regclass('public.orders')::text").Scan(&existing); err != nil {
+		return err
+	}
+	if existing.Valid {
+		return nil
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, sampleSQL); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
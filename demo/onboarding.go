@@ -0,0 +1,177 @@
+package demo
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/zucced/goquery/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ErrAlreadyOnboarded is returned when a user already has the sample database
+var ErrAlreadyOnboarded = errors.New("you already have the sample database")
+
+// example is a query on the sample database shown on the starter dashboard
+type example struct {
+	name      string
+	question  string
+	sql       string
+	cardType  models.CardType
+	chartType models.ChartType
+	position  models.CardPosition
+}
+
+// examples are the queries new users start with
+var examples = []example{
+	{
+		name:      "Revenue by month",
+		question:  "What is the revenue of completed orders by month?",
+		sql:       "SELECT date_trunc('month', o.ordered_at)::date AS month, SUM(oi.quantity * oi.unit_price) AS revenue\nFROM orders o\nJOIN order_items oi ON oi.order_id = o.id\nWHERE o.status = 'completed'\nGROUP BY 1\nORDER BY 1",
+		cardType:  models.CardTypeChart,
+		chartType: models.ChartTypeLine,
+		position:  models.CardPosition{X: 0, Y: 0, W: 8, H: 4},
+	},
+	{
+		name:      "Orders by status",
+		question:  "How many orders are there in each status?",
+		sql:       "SELECT status, COUNT(*) AS orders\nFROM orders\nGROUP BY status\nORDER BY orders DESC",
+		cardType:  models.CardTypeChart,
+		chartType: models.ChartTypePie,
+		position:  models.CardPosition{X: 8, Y: 0, W: 4, H: 4},
+	},
+	{
+		name:      "Top products",
+		question:  "Which 10 products brought in the most revenue?",
+		sql:       "SELECT p.name, p.category, SUM(oi.quantity * oi.unit_price) AS revenue\nFROM order_items oi\nJOIN products p ON p.id = oi.product_id\nGROUP BY p.id, p.name, p.category\nORDER BY revenue DESC\nLIMIT 10",
+		cardType:  models.CardTypeChart,
+		chartType: models.ChartTypeBar,
+		position:  models.CardPosition{X: 0, Y: 4, W: 6, H: 4},
+	},
+	{
+		name:      "Customers by country",
+		question:  "How many customers are there in each country?",
+		sql:       "SELECT country, COUNT(*) AS customers\nFROM customers\nGROUP BY country\nORDER BY customers DESC",
+		cardType:  models.CardTypeQuery,
+		chartType: models.ChartTypeTable,
+		position:  models.CardPosition{X: 6, Y: 4, W: 6, H: 4},
+	},
+}
+
+// Onboarding is the sample data a user was given
+type Onboarding struct {
+	Database  *models.Database  `json:"database"`
+	Queries   []*models.Query   `json:"queries"`
+	Dashboard *models.Dashboard `json:"dashboard"`
+}
+
+// Onboard gives a user a read-only connection to the sample database, the
+// example queries with their results and a starter dashboard showing them
+func (p *Provisioner) Onboard(ctx context.Context, userID primitive.ObjectID) (*Onboarding, error) {
+	// Only one copy of the sample database per user
+	databases, err := p.store.GetDatabasesByUserID(ctx, userID, primitive.NilObjectID)
+	if err != nil {
+		return nil, err
+	}
+	for _, db := range databases {
+		if db.UserID == userID && p.isSample(db) {
+			return nil, ErrAlreadyOnboarded
+		}
+	}
+
+	db, err := p.connect(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	queries, dashboard, err := p.seedExamples(ctx, userID, db)
+	if err != nil {
+		return nil, err
+	}
+
+	// Queries run against the sample database unless the user picked another
+	preferences, err := p.store.GetUserPreferences(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if preferences.DefaultDatabaseID.IsZero() {
+		preferences.DefaultDatabaseID = db.ID
+		if err := p.store.UpdateUserPreferences(ctx, userID, preferences); err != nil {
+			return nil, err
+		}
+	}
+
+	return &Onboarding{Database: db, Queries: queries, Dashboard: dashboard}, nil
+}
+
+// isSample reports whether a connection points at the sample database
+func (p *Provisioner) isSample(db *models.Database) bool {
+	return db.Type == p.template.Type && db.Host == p.template.Host && db.Port == p.template.Port && db.DatabaseName == p.template.DatabaseName
+}
+
+// connect creates a read-only copy of the sample database connection for a user
+func (p *Provisioner) connect(ctx context.Context, userID primitive.ObjectID) (*models.Database, error) {
+	db := *p.template
+	db.UserID = userID
+	db.AllowWrites = false
+	now := time.Now()
+	db.LastConnected = &now
+
+	return p.store.CreateDatabase(ctx, &db)
+}
+
+// seedExamples runs the example queries against the sample database, saves
+// them for the user and creates the starter dashboard. Queries that fail are
+// saved with their error rather than left out.
+func (p *Provisioner) seedExamples(ctx context.Context, userID primitive.ObjectID, db *models.Database) ([]*models.Query, *models.Dashboard, error) {
+	queries := make([]*models.Query, 0, len(examples))
+	cards := make([]models.DashboardCard, 0, len(examples))
+	for _, ex := range examples {
+		query, err := p.store.CreateQuery(ctx, &models.Query{
+			UserID:       userID,
+			DatabaseID:   db.ID,
+			Name:         ex.name,
+			NaturalQuery: ex.question,
+			GeneratedSQL: ex.sql,
+			Tags:         []string{"sample"},
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+
+		results, columns, executionTime, err := models.ExecuteQuery(ctx, db, ex.sql)
+		if err != nil {
+			query.Status = models.QueryStatusFailed
+			query.Error = err.Error()
+		} else {
+			query.Status = models.QueryStatusCompleted
+			query.Results = results
+			query.Columns = columns
+			query.ExecutionTime = executionTime
+		}
+		if err := p.store.UpdateQuery(ctx, query); err != nil {
+			return nil, nil, err
+		}
+		queries = append(queries, query)
+
+		cards = append(cards, models.DashboardCard{
+			Title:     ex.name,
+			Type:      ex.cardType,
+			QueryID:   query.ID,
+			ChartType: ex.chartType,
+			Position:  ex.position,
+		})
+	}
+
+	dashboard, err := p.store.CreateDashboard(ctx, &models.Dashboard{
+		UserID:      userID,
+		Name:        "Getting Started",
+		Description: "Example queries on the sample store. Edit them, or ask your own questions about its customers, products and orders.",
+		Cards:       cards,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return queries, dashboard, nil
+}
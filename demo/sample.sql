@@ -0,0 +1,72 @@
+-- Sample store used by demo mode. Data is generated deterministically so every
+-- demo database looks the same.
+
+CREATE TABLE customers (
+    id          SERIAL PRIMARY KEY,
+    name        TEXT NOT NULL,
+    email       TEXT NOT NULL UNIQUE,
+    country     TEXT NOT NULL,
+    created_at  TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE products (
+    id          SERIAL PRIMARY KEY,
+    name        TEXT NOT NULL,
+    category    TEXT NOT NULL,
+    price       NUMERIC(10, 2) NOT NULL
+);
+
+CREATE TABLE orders (
+    id           SERIAL PRIMARY KEY,
+    customer_id  INTEGER NOT NULL REFERENCES customers (id),
+    status       TEXT NOT NULL,
+    ordered_at   TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE order_items (
+    id          SERIAL PRIMARY KEY,
+    order_id    INTEGER NOT NULL REFERENCES orders (id),
+    product_id  INTEGER NOT NULL REFERENCES products (id),
+    quantity    INTEGER NOT NULL,
+    unit_price  NUMERIC(10, 2) NOT NULL
+);
+
+INSERT INTO products (name, category, price) VALUES
+    ('Espresso Beans', 'Coffee', 14.50),
+    ('Filter Roast', 'Coffee', 12.00),
+    ('Decaf Blend', 'Coffee', 13.25),
+    ('Green Tea', 'Tea', 8.75),
+    ('Earl Grey', 'Tea', 7.90),
+    ('Chai Spice', 'Tea', 9.40),
+    ('Ceramic Mug', 'Merchandise', 16.00),
+    ('Travel Tumbler', 'Merchandise', 24.00),
+    ('Pour-over Kit', 'Equipment', 39.00),
+    ('Burr Grinder', 'Equipment', 129.00),
+    ('Milk Frother', 'Equipment', 45.00),
+    ('Gift Card', 'Gift', 50.00);
+
+INSERT INTO customers (name, email, country, created_at)
+SELECT
+    'Customer ' || g,
+    'customer' || g || '@example.com',
+    (ARRAY['United States', 'Germany', 'United Kingdom', 'France', 'Canada', 'Japan', 'Brazil', 'India'])[1 + g % 8],
+    TIMESTAMPTZ '2023-01-01' + (g * INTERVAL '17 hours')
+FROM generate_series(1, 500) AS g;
+
+INSERT INTO orders (customer_id, status, ordered_at)
+SELECT
+    1 + (g * 7) % 500,
+    (ARRAY['completed', 'completed', 'completed', 'shipped', 'pending', 'cancelled'])[1 + g % 6],
+    TIMESTAMPTZ '2023-03-01' + (g * INTERVAL '5 hours 13 minutes')
+FROM generate_series(1, 3000) AS g;
+
+INSERT INTO order_items (order_id, product_id, quantity, unit_price)
+SELECT
+    o.id,
+    p.id,
+    1 + (o.id + n) % 3,
+    p.price
+FROM orders o
+CROSS JOIN generate_series(0, 2) AS n
+JOIN products p ON p.id = 1 + (o.id * 5 + n * 3) % 12
+WHERE n <= o.id % 3;
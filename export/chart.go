@@ -0,0 +1,270 @@
+package export
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/zucced/goquery/models"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+// ErrNotChartable is returned when a card's data can't be drawn as a chart
+var ErrNotChartable = errors.New("card data can't be charted")
+
+const (
+	chartWidth     = 720
+	chartHeight    = 360
+	chartMargin    = 48
+	chartMaxPoints = 30
+)
+
+var (
+	chartAxisColor = color.RGBA{R: 150, G: 150, B: 150, A: 255}
+	chartBarColor  = color.RGBA{R: 66, G: 110, B: 200, A: 255}
+)
+
+// chartSeriesColors color the series of a chart in turn
+var chartSeriesColors = []color.RGBA{
+	chartBarColor,
+	{R: 230, G: 130, B: 50, A: 255},
+	{R: 80, G: 165, B: 100, A: 255},
+	{R: 200, G: 70, B: 90, A: 255},
+	{R: 140, G: 100, B: 190, A: 255},
+	{R: 120, G: 120, B: 120, A: 255},
+}
+
+// RenderCardChart draws a snapshot card as a PNG chart. Cards with a
+// transform are drawn from its series, with dates bucketed in location.
+// Otherwise the first column is used for the x axis and the first numeric
+// column for the y axis.
+func RenderCardChart(card models.SnapshotCard, location *time.Location) ([]byte, error) {
+	if card.Type != models.CardTypeChart || card.ChartType == models.ChartTypeTable || len(card.Results) == 0 {
+		return nil, ErrNotChartable
+	}
+
+	labels, series, title, err := chartData(card, location)
+	if err != nil {
+		return nil, err
+	}
+	if len(labels) == 0 || len(series) == 0 {
+		return nil, ErrNotChartable
+	}
+	if len(labels) > chartMaxPoints {
+		labels = labels[:chartMaxPoints]
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, chartWidth, chartHeight))
+	draw.Draw(img, img.Bounds(), image.White, image.Point{}, draw.Src)
+
+	// Scale values so zero is always on the axis
+	minY, maxY := 0.0, 0.0
+	for _, s := range series {
+		for _, value := range s.Values[:len(labels)] {
+			if value != nil {
+				minY = math.Min(minY, *value)
+				maxY = math.Max(maxY, *value)
+			}
+		}
+	}
+	if maxY == minY {
+		maxY = minY + 1
+	}
+
+	plot := image.Rect(chartMargin, chartMargin/2, chartWidth-chartMargin/2, chartHeight-chartMargin)
+	scale := func(v float64) int {
+		return plot.Max.Y - int((v-minY)/(maxY-minY)*float64(plot.Dy()))
+	}
+	zero := scale(0)
+
+	// Axes
+	fillRect(img, image.Rect(plot.Min.X, plot.Min.Y, plot.Min.X+1, plot.Max.Y), chartAxisColor)
+	fillRect(img, image.Rect(plot.Min.X, zero, plot.Max.X, zero+1), chartAxisColor)
+	drawText(img, formatAxisValue(maxY), 4, plot.Min.Y+10, pngMutedColor)
+	drawText(img, formatAxisValue(minY), 4, plot.Max.Y, pngMutedColor)
+
+	slot := plot.Dx() / len(labels)
+	for s, values := range series {
+		barColor := chartSeriesColors[s%len(chartSeriesColors)]
+		for i, value := range values.Values[:len(labels)] {
+			if value == nil {
+				continue
+			}
+			left := plot.Min.X + i*slot
+			center := left + slot/2
+
+			switch card.ChartType {
+			case models.ChartTypeLine, models.ChartTypeArea:
+				if i > 0 && values.Values[i-1] != nil {
+					prev := plot.Min.X + (i-1)*slot + slot/2
+					drawLine(img, prev, scale(*values.Values[i-1]), center, scale(*value), barColor)
+				}
+				fillRect(img, image.Rect(center-2, scale(*value)-2, center+3, scale(*value)+3), barColor)
+			default:
+				// Bars of each series stand side by side within the slot
+				width := (slot - slot/3) / len(series)
+				barLeft := left + slot/6 + s*width
+				top, bottom := scale(*value), zero
+				if top > bottom {
+					top, bottom = bottom, top
+				}
+				fillRect(img, image.Rect(barLeft, top, barLeft+max(width, 1), bottom), barColor)
+			}
+		}
+	}
+
+	// Label every point when there's room, otherwise every few
+	step := 1 + (len(labels)*8)/plot.Dx()
+	for i, x := range labels {
+		if i%step != 0 {
+			continue
+		}
+		label := fmt.Sprint(x)
+		maxChars := slot * step / basicfont.Face7x13.Advance
+		if maxChars > 0 && utf8.RuneCountInString(label) > maxChars {
+			label = string([]rune(label)[:maxChars])
+		}
+		drawText(img, label, plot.Min.X+i*slot+2, plot.Max.Y+16, pngTextColor)
+	}
+
+	drawText(img, title, plot.Min.X, chartHeight-8, pngMutedColor)
+
+	// Name the series after the title when there are several
+	if len(series) > 1 {
+		left := plot.Min.X + (utf8.RuneCountInString(title)+2)*basicfont.Face7x13.Advance
+		for s, values := range series {
+			name := fmt.Sprint(values.Name)
+			width := 12 + (utf8.RuneCountInString(name)+2)*basicfont.Face7x13.Advance
+			if left+width > chartWidth {
+				break
+			}
+			fillRect(img, image.Rect(left, chartHeight-16, left+8, chartHeight-8), chartSeriesColors[s%len(chartSeriesColors)])
+			drawText(img, name, left+12, chartHeight-8, pngMutedColor)
+			left += width
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// chartData returns the labels, series and title to draw for a card
+func chartData(card models.SnapshotCard, location *time.Location) ([]interface{}, []models.TransformedSeries, string, error) {
+	if card.Transform != nil {
+		chart, err := card.Transform.Apply(card.Results, location)
+		if err != nil {
+			return nil, nil, "", err
+		}
+
+		y := card.Transform.Y
+		if card.Transform.Agg == models.ChartAggregationCount {
+			y = "count"
+		}
+		return chart.Labels, chart.Series, fmt.Sprintf("%s by %s", y, card.Transform.X), nil
+	}
+
+	x, y := chartColumns(card)
+	if x == "" || y == "" {
+		return nil, nil, "", ErrNotChartable
+	}
+
+	series, err := models.BuildChartSeries(card.Results, x, y, models.ChartAggregationSum, models.DateBucketNone, nil)
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	labels := make([]interface{}, len(series.Points))
+	values := make([]*float64, len(series.Points))
+	for i := range series.Points {
+		labels[i] = series.Points[i].X
+		values[i] = &series.Points[i].Y
+	}
+	return labels, []models.TransformedSeries{{Name: y, Values: values}}, fmt.Sprintf("%s by %s", y, x), nil
+}
+
+// chartColumns picks the x column and the first numeric y column of a card
+func chartColumns(card models.SnapshotCard) (string, string) {
+	columns := cardColumns(card)
+	if len(columns) < 2 {
+		return "", ""
+	}
+
+	x := columns[0]
+	for _, column := range columns[1:] {
+		numeric := true
+		for _, row := range card.Results {
+			if value, ok := row[column]; ok && value != nil && !isNumeric(value) {
+				numeric = false
+				break
+			}
+		}
+		if numeric {
+			return x, column
+		}
+	}
+	return x, ""
+}
+
+// isNumeric reports whether a result value can be plotted
+func isNumeric(value interface{}) bool {
+	switch v := value.(type) {
+	case int, int32, int64, float32, float64:
+		return true
+	case string:
+		_, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		return err == nil
+	default:
+		return false
+	}
+}
+
+// formatAxisValue formats an axis label compactly
+func formatAxisValue(v float64) string {
+	return fmt.Sprintf("%.4g", v)
+}
+
+// fillRect fills a rectangle with a solid color
+func fillRect(img draw.Image, r image.Rectangle, c color.Color) {
+	draw.Draw(img, r, image.NewUniform(c), image.Point{}, draw.Src)
+}
+
+// drawLine draws a one pixel line between two points
+func drawLine(img *image.RGBA, x0, y0, x1, y1 int, c color.Color) {
+	steps := int(math.Max(math.Abs(float64(x1-x0)), math.Abs(float64(y1-y0))))
+	if steps == 0 {
+		img.Set(x0, y0, c)
+		return
+	}
+	for i := 0; i <= steps; i++ {
+		t := float64(i) / float64(steps)
+		x := x0 + int(math.Round(t*float64(x1-x0)))
+		y := y0 + int(math.Round(t*float64(y1-y0)))
+		img.Set(x, y, c)
+		img.Set(x, y+1, c)
+	}
+}
+
+// drawText draws a line of text with its baseline at y
+func drawText(img draw.Image, text string, x, y int, c color.Color) {
+	drawer := &font.Drawer{
+		Dst:  img,
+		Src:  image.NewUniform(c),
+		Face: basicfont.Face7x13,
+		Dot:  fixed.P(x, y),
+	}
+	drawer.DrawString(text)
+}
@@ -0,0 +1,77 @@
+package export
+
+import (
+	"bytes"
+	"html/template"
+	"strings"
+
+	"github.com/zucced/goquery/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// emailCard is a card table along with the content ID of its inline chart
+type emailCard struct {
+	cardTable
+	ChartCID string
+}
+
+var emailTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<body style="font-family: Helvetica, Arial, sans-serif; color: #141414;">
+<h1 style="font-size: 20px; margin-bottom: 4px;">{{.Title}}</h1>
+<p style="color: #6e6e6e; margin-top: 0;">{{.Subtitle}}</p>
+{{range .Cards}}
+<h2 style="font-size: 16px; margin-bottom: 2px;">{{.Title}}</h2>
+{{if .Subtitle}}<p style="color: #6e6e6e; font-size: 12px; margin-top: 0;">{{.Subtitle}}</p>{{end}}
+{{if .Error}}<p style="color: #b40000; font-size: 12px;">Error: {{.Error}}</p>{{end}}
+{{if .ChartCID}}<img src="cid:{{.ChartCID}}" alt="{{.Title}}" style="max-width: 100%;">{{end}}
+{{if .Content}}<p style="font-size: 13px; white-space: pre-wrap;">{{range $i, $line := .Content}}{{if $i}}
+{{end}}{{$line}}{{end}}</p>
+{{else if .Columns}}
+<table style="border-collapse: collapse; font-size: 12px;">
+<tr>{{range .Columns}}<th style="border: 1px solid #ccc; background: #e6e6e6; padding: 4px 8px; text-align: left;">{{.}}</th>{{end}}</tr>
+{{range .Rows}}<tr>{{range .}}<td style="border: 1px solid #ccc; padding: 4px 8px;">{{.}}</td>{{end}}</tr>
+{{end}}
+</table>
+{{if .Truncated}}<p style="color: #6e6e6e; font-size: 11px;">{{.Truncated}}</p>{{end}}
+{{else if not .Error}}<p style="color: #6e6e6e; font-size: 12px;">No data</p>{{end}}
+{{end}}
+</body>
+</html>
+`))
+
+// RenderSnapshotEmail renders a snapshot as HTML and plain text email bodies.
+// Charts maps card IDs to the content IDs of their inline chart images.
+func RenderSnapshotEmail(snapshot *models.DashboardSnapshot, charts map[primitive.ObjectID]string) (string, string, error) {
+	title, subtitle := snapshotHeader(snapshot)
+
+	cards := make([]emailCard, 0, len(snapshot.Cards))
+	for _, card := range snapshot.Cards {
+		cards = append(cards, emailCard{
+			cardTable: buildCardTable(card),
+			ChartCID:  charts[card.CardID],
+		})
+	}
+
+	var html bytes.Buffer
+	err := emailTemplate.Execute(&html, map[string]interface{}{
+		"Title":    title,
+		"Subtitle": subtitle,
+		"Cards":    cards,
+	})
+	if err != nil {
+		return "", "", err
+	}
+
+	// The plain text body reuses the fixed-width layout of the PNG export
+	var text strings.Builder
+	text.WriteString(title + "\n" + subtitle + "\n\n")
+	for _, card := range cards {
+		for _, line := range cardLines(card.cardTable) {
+			text.WriteString(line.text + "\n")
+		}
+		text.WriteString("\n")
+	}
+
+	return html.String(), text.String(), nil
+}
@@ -0,0 +1,113 @@
+package export
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/go-pdf/fpdf"
+	"github.com/zucced/goquery/models"
+)
+
+// renderPDF renders a snapshot as an A4 landscape PDF with one table per card
+func renderPDF(snapshot *models.DashboardSnapshot) ([]byte, error) {
+	pdf := fpdf.New("L", "mm", "A4", "")
+	pdf.SetMargins(12, 12, 12)
+	pdf.SetAutoPageBreak(true, 12)
+	pdf.AddPage()
+
+	// Core fonts only cover Latin-1
+	tr := pdf.UnicodeTranslatorFromDescriptor("")
+	pageWidth, _ := pdf.GetPageSize()
+	left, _, right, _ := pdf.GetMargins()
+	contentWidth := pageWidth - left - right
+
+	title, subtitle := snapshotHeader(snapshot)
+	pdf.SetFont("Helvetica", "B", 18)
+	pdf.CellFormat(contentWidth, 10, tr(title), "", 1, "L", false, 0, "")
+	pdf.SetFont("Helvetica", "", 10)
+	pdf.SetTextColor(100, 100, 100)
+	pdf.CellFormat(contentWidth, 6, tr(subtitle), "", 1, "L", false, 0, "")
+	pdf.SetTextColor(0, 0, 0)
+	pdf.Ln(4)
+
+	for _, card := range snapshot.Cards {
+		table := buildCardTable(card)
+
+		pdf.SetFont("Helvetica", "B", 13)
+		pdf.CellFormat(contentWidth, 8, tr(table.Title), "", 1, "L", false, 0, "")
+		if table.Subtitle != "" {
+			pdf.SetFont("Helvetica", "", 9)
+			pdf.SetTextColor(100, 100, 100)
+			pdf.CellFormat(contentWidth, 5, tr(table.Subtitle), "", 1, "L", false, 0, "")
+			pdf.SetTextColor(0, 0, 0)
+		}
+
+		if table.Error != "" {
+			pdf.SetFont("Helvetica", "", 9)
+			pdf.SetTextColor(180, 0, 0)
+			pdf.MultiCell(contentWidth, 5, tr("Error: "+table.Error), "", "L", false)
+			pdf.SetTextColor(0, 0, 0)
+		}
+
+		if table.Content != nil {
+			pdf.SetFont("Helvetica", "", 10)
+			pdf.MultiCell(contentWidth, 5, tr(strings.Join(table.Content, "\n")), "", "L", false)
+			pdf.Ln(4)
+			continue
+		}
+
+		if len(table.Columns) == 0 {
+			if table.Error == "" {
+				pdf.SetFont("Helvetica", "I", 9)
+				pdf.CellFormat(contentWidth, 6, "No data", "", 1, "L", false, 0, "")
+			}
+			pdf.Ln(4)
+			continue
+		}
+
+		columnWidth := contentWidth / float64(len(table.Columns))
+
+		// Header row
+		pdf.SetFont("Helvetica", "B", 8)
+		pdf.SetFillColor(230, 230, 230)
+		for _, column := range table.Columns {
+			pdf.CellFormat(columnWidth, 6, tr(column), "1", 0, "L", true, 0, "")
+		}
+		pdf.Ln(-1)
+
+		// Data rows
+		pdf.SetFont("Helvetica", "", 8)
+		for _, row := range table.Rows {
+			for _, cell := range row {
+				pdf.CellFormat(columnWidth, 5, tr(fitText(pdf, cell, columnWidth-2)), "1", 0, "L", false, 0, "")
+			}
+			pdf.Ln(-1)
+		}
+
+		if table.Truncated != "" {
+			pdf.SetFont("Helvetica", "I", 8)
+			pdf.CellFormat(contentWidth, 5, table.Truncated, "", 1, "L", false, 0, "")
+		}
+
+		pdf.Ln(6)
+	}
+
+	var buf bytes.Buffer
+	if err := pdf.Output(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// fitText shortens text until it fits within width at the current font
+func fitText(pdf *fpdf.Fpdf, text string, width float64) string {
+	if pdf.GetStringWidth(text) <= width {
+		return text
+	}
+
+	runes := []rune(text)
+	for len(runes) > 0 && pdf.GetStringWidth(string(runes)+"...") > width {
+		runes = runes[:len(runes)-1]
+	}
+	return string(runes) + "..."
+}
@@ -0,0 +1,136 @@
+package export
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/zucced/goquery/models"
+)
+
+const (
+	pngPadding    = 24
+	pngLineHeight = 18
+	pngCharWidth  = 7
+	pngMinWidth   = 800
+)
+
+// pngLine is a single line of text in a PNG export
+type pngLine struct {
+	text  string
+	color color.Color
+}
+
+var (
+	pngTextColor   = color.RGBA{R: 20, G: 20, B: 20, A: 255}
+	pngMutedColor  = color.RGBA{R: 110, G: 110, B: 110, A: 255}
+	pngErrorColor  = color.RGBA{R: 180, G: 0, B: 0, A: 255}
+	pngHeaderColor = color.RGBA{R: 30, G: 60, B: 140, A: 255}
+)
+
+// renderPNG renders a snapshot as a plain text report image
+func renderPNG(snapshot *models.DashboardSnapshot) ([]byte, error) {
+	title, subtitle := snapshotHeader(snapshot)
+	lines := []pngLine{
+		{text: title, color: pngHeaderColor},
+		{text: subtitle, color: pngMutedColor},
+		{},
+	}
+
+	for _, card := range snapshot.Cards {
+		lines = append(lines, cardLines(buildCardTable(card))...)
+		lines = append(lines, pngLine{})
+	}
+
+	// Size the image to fit the longest line
+	width := pngMinWidth
+	for _, line := range lines {
+		if w := utf8.RuneCountInString(line.text)*pngCharWidth + 2*pngPadding; w > width {
+			width = w
+		}
+	}
+	height := len(lines)*pngLineHeight + 2*pngPadding
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(img, img.Bounds(), image.White, image.Point{}, draw.Src)
+
+	for i, line := range lines {
+		if line.text == "" {
+			continue
+		}
+		drawText(img, line.text, pngPadding, pngPadding+(i+1)*pngLineHeight-5, line.color)
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// cardLines lays out a card table as fixed-width text lines
+func cardLines(table cardTable) []pngLine {
+	lines := []pngLine{{text: table.Title, color: pngHeaderColor}}
+	if table.Subtitle != "" {
+		lines = append(lines, pngLine{text: table.Subtitle, color: pngMutedColor})
+	}
+	if table.Error != "" {
+		lines = append(lines, pngLine{text: "Error: " + table.Error, color: pngErrorColor})
+	}
+	if table.Content != nil {
+		for _, line := range table.Content {
+			lines = append(lines, pngLine{text: line, color: pngTextColor})
+		}
+		return lines
+	}
+	if len(table.Columns) == 0 {
+		if table.Error == "" {
+			lines = append(lines, pngLine{text: "No data", color: pngMutedColor})
+		}
+		return lines
+	}
+
+	// Work out the width of each column
+	widths := make([]int, len(table.Columns))
+	for i, column := range table.Columns {
+		widths[i] = utf8.RuneCountInString(column)
+	}
+	for _, row := range table.Rows {
+		for i, cell := range row {
+			if w := utf8.RuneCountInString(cell); w > widths[i] {
+				widths[i] = w
+			}
+		}
+	}
+
+	lines = append(lines, pngLine{text: formatRow(table.Columns, widths), color: pngTextColor})
+
+	separators := make([]string, len(widths))
+	for i, w := range widths {
+		separators[i] = strings.Repeat("-", w)
+	}
+	lines = append(lines, pngLine{text: strings.Join(separators, "-+-"), color: pngMutedColor})
+
+	for _, row := range table.Rows {
+		lines = append(lines, pngLine{text: formatRow(row, widths), color: pngTextColor})
+	}
+
+	if table.Truncated != "" {
+		lines = append(lines, pngLine{text: table.Truncated, color: pngMutedColor})
+	}
+
+	return lines
+}
+
+// formatRow pads each cell to its column width and joins them
+func formatRow(cells []string, widths []int) string {
+	padded := make([]string, len(cells))
+	for i, cell := range cells {
+		padded[i] = cell + strings.Repeat(" ", widths[i]-utf8.RuneCountInString(cell))
+	}
+	return strings.TrimRight(strings.Join(padded, " | "), " ")
+}
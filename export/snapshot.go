@@ -0,0 +1,178 @@
+// Package export renders dashboard snapshots into shareable documents.
+package export
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/zucced/goquery/models"
+)
+
+// Format represents a snapshot export format
+type Format string
+
+const (
+	FormatPDF Format = "pdf"
+	FormatPNG Format = "png"
+)
+
+const (
+	// maxRowsPerCard caps how many result rows are rendered for each card
+	maxRowsPerCard = 50
+	// maxColumnsPerCard caps how many columns are rendered for each card
+	maxColumnsPerCard = 8
+	// maxCellLength caps the number of characters rendered in a cell
+	maxCellLength = 40
+)
+
+// ContentType returns the MIME type for an export format
+func (f Format) ContentType() string {
+	switch f {
+	case FormatPNG:
+		return "image/png"
+	default:
+		return "application/pdf"
+	}
+}
+
+// RenderSnapshot renders a snapshot in the given format
+func RenderSnapshot(snapshot *models.DashboardSnapshot, format Format) ([]byte, error) {
+	switch format {
+	case FormatPDF:
+		return renderPDF(snapshot)
+	case FormatPNG:
+		return renderPNG(snapshot)
+	default:
+		return nil, fmt.Errorf("unsupported export format: %s", format)
+	}
+}
+
+// cardTable is the tabular view of a snapshot card used by the renderers
+type cardTable struct {
+	Title     string
+	Subtitle  string
+	Error     string
+	Content   []string
+	Columns   []string
+	Rows      [][]string
+	Truncated string
+}
+
+// buildCardTable converts a snapshot card into a bounded table of strings
+func buildCardTable(card models.SnapshotCard) cardTable {
+	table := cardTable{
+		Title: card.Title,
+		Error: card.Error,
+	}
+
+	// Text cards are shown as their Markdown source
+	if card.Type == models.CardTypeText {
+		table.Content = strings.Split(card.Content, "\n")
+		return table
+	}
+
+	// External content can't be rendered, so link to it instead
+	if card.Type == models.CardTypeEmbed {
+		table.Content = []string{"Embedded content: " + card.URL}
+		return table
+	}
+
+	var subtitle []string
+	if card.QueryName != "" {
+		subtitle = append(subtitle, card.QueryName)
+	}
+	if !card.DataAsOf.IsZero() {
+		subtitle = append(subtitle, "data as of "+card.DataAsOf.UTC().Format("2006-01-02 15:04 MST"))
+	}
+	table.Subtitle = strings.Join(subtitle, " - ")
+
+	columns := cardColumns(card)
+	hiddenColumns := 0
+	if len(columns) > maxColumnsPerCard {
+		hiddenColumns = len(columns) - maxColumnsPerCard
+		columns = columns[:maxColumnsPerCard]
+	}
+	table.Columns = columns
+
+	rows := card.Results
+	hiddenRows := 0
+	if len(rows) > maxRowsPerCard {
+		hiddenRows = len(rows) - maxRowsPerCard
+		rows = rows[:maxRowsPerCard]
+	}
+
+	for _, row := range rows {
+		cells := make([]string, len(columns))
+		for i, column := range columns {
+			cells[i] = formatCell(row[column])
+		}
+		table.Rows = append(table.Rows, cells)
+	}
+
+	var notes []string
+	if hiddenRows > 0 {
+		notes = append(notes, fmt.Sprintf("%d more rows", hiddenRows))
+	}
+	if hiddenColumns > 0 {
+		notes = append(notes, fmt.Sprintf("%d more columns", hiddenColumns))
+	}
+	if len(notes) > 0 {
+		table.Truncated = "Not shown: " + strings.Join(notes, ", ")
+	}
+
+	return table
+}
+
+// cardColumns returns the column order for a card, preferring the stored column metadata
+func cardColumns(card models.SnapshotCard) []string {
+	if len(card.Columns) > 0 {
+		columns := make([]string, len(card.Columns))
+		for i, column := range card.Columns {
+			columns[i] = column.Name
+		}
+		return columns
+	}
+
+	seen := make(map[string]bool)
+	var columns []string
+	for _, row := range card.Results {
+		for key := range row {
+			if !seen[key] {
+				seen[key] = true
+				columns = append(columns, key)
+			}
+		}
+	}
+	sort.Strings(columns)
+	return columns
+}
+
+// formatCell renders a result value as a single line of text
+func formatCell(value interface{}) string {
+	var text string
+	switch v := value.(type) {
+	case nil:
+		text = ""
+	case time.Time:
+		text = v.UTC().Format("2006-01-02 15:04:05")
+	default:
+		text = fmt.Sprint(v)
+	}
+
+	text = strings.Join(strings.Fields(text), " ")
+	if runes := []rune(text); len(runes) > maxCellLength {
+		text = string(runes[:maxCellLength-3]) + "..."
+	}
+	return text
+}
+
+// snapshotHeader returns the title and subtitle lines of an export
+func snapshotHeader(snapshot *models.DashboardSnapshot) (string, string) {
+	subtitle := "Snapshot taken " + snapshot.CreatedAt.UTC().Format("2006-01-02 15:04 MST")
+	if snapshot.Description != "" {
+		subtitle = snapshot.Description + " - " + subtitle
+	}
+	return snapshot.Name, subtitle
+}
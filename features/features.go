@@ -0,0 +1,135 @@
+package features
+
+import (
+	"context"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/zucced/goquery/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Flags of the features that can be rolled out gradually
+const (
+	// WriteQueries lets connections that allow writes run write queries
+	WriteQueries = "write_queries"
+)
+
+// Flag describes a feature that can be turned on and off
+type Flag struct {
+	Key         string `json:"key"`
+	Description string `json:"description"`
+	Default     bool   `json:"default"`
+}
+
+// Flags lists every feature flag with its default, which applies when neither
+// the configuration nor a stored value sets it
+var Flags = []Flag{
+	{Key: WriteQueries, Description: "Run write queries on connections that allow writes", Default: true},
+}
+
+// Lookup returns the flag with a key, reporting false for unknown flags
+func Lookup(key string) (Flag, bool) {
+	for _, flag := range Flags {
+		if flag.Key == key {
+			return flag, true
+		}
+	}
+	return Flag{}, false
+}
+
+// refreshInterval is how long stored flags are used before they are read
+// again, which bounds how long other servers take to see a change
+const refreshInterval = 30 * time.Second
+
+// Service decides whether features are on. A flag is looked up in order in the
+// organization's override, the stored value, the configuration and the
+// flag's default.
+type Service struct {
+	store      models.Store
+	configured map[string]bool
+
+	mu       sync.Mutex
+	stored   map[string]*models.FeatureFlag
+	loadedAt time.Time
+}
+
+// NewService creates a service reading stored flags from store, with the
+// values of configured taking precedence over the flags' defaults
+func NewService(store models.Store, configured map[string]bool) *Service {
+	return &Service{store: store, configured: configured}
+}
+
+// Enabled reports whether a feature is on for an organization. orgID is zero
+// for resources that don't belong to one.
+func (s *Service) Enabled(ctx context.Context, key string, orgID primitive.ObjectID) bool {
+	if flag, ok := s.storedFlag(ctx, key); ok {
+		if enabled, ok := flag.EnabledFor(orgID); ok {
+			return enabled
+		}
+	}
+	return s.Default(key)
+}
+
+// Default returns whether a feature is on when no value is stored for it
+func (s *Service) Default(key string) bool {
+	if enabled, ok := s.configured[key]; ok {
+		return enabled
+	}
+	flag, _ := Lookup(key)
+	return flag.Default
+}
+
+// EnabledFlags returns the features that are on for an organization, sorted
+// by key
+func (s *Service) EnabledFlags(ctx context.Context, orgID primitive.ObjectID) []string {
+	enabled := []string{}
+	for _, flag := range Flags {
+		if s.Enabled(ctx, flag.Key, orgID) {
+			enabled = append(enabled, flag.Key)
+		}
+	}
+	sort.Strings(enabled)
+	return enabled
+}
+
+// Save stores a flag and uses it right away on this server
+func (s *Service) Save(ctx context.Context, flag *models.FeatureFlag) error {
+	if err := s.store.SaveFeatureFlag(ctx, flag); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stored != nil {
+		s.stored[flag.Key] = flag
+	}
+	return nil
+}
+
+// storedFlag returns the stored value of a flag, reading the stored flags
+// again once they are older than refreshInterval. Flags that can't be read
+// keep their previous values.
+func (s *Service) storedFlag(ctx context.Context, key string) (*models.FeatureFlag, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if time.Since(s.loadedAt) > refreshInterval {
+		flags, err := s.store.GetFeatureFlags(ctx)
+		if err != nil {
+			log.Printf("Failed to load feature flags: %v", err)
+		} else {
+			s.stored = make(map[string]*models.FeatureFlag, len(flags))
+			for _, flag := range flags {
+				s.stored[flag.Key] = flag
+			}
+		}
+		// Don't retry on every check while the store is unreachable
+		s.loadedAt = time.Now()
+	}
+
+	flag, ok := s.stored[key]
+	return flag, ok
+}
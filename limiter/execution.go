@@ -0,0 +1,121 @@
+package limiter
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrLimitExceeded is returned when a slot could not be acquired in time
+var ErrLimitExceeded = errors.New("too many queries are running, please try again shortly")
+
+// keyedSemaphore caps the number of concurrent holders per key
+type keyedSemaphore struct {
+	mu     sync.Mutex
+	limit  int
+	slots  map[string]chan struct{}
+	active map[string]int
+}
+
+// newKeyedSemaphore creates a semaphore allowing limit holders per key
+func newKeyedSemaphore(limit int) *keyedSemaphore {
+	return &keyedSemaphore{
+		limit:  limit,
+		slots:  make(map[string]chan struct{}),
+		active: make(map[string]int),
+	}
+}
+
+// acquire blocks until a slot for key is free or ctx is done
+func (s *keyedSemaphore) acquire(ctx context.Context, key string) (func(), error) {
+	if s.limit <= 0 {
+		return func() {}, nil
+	}
+
+	s.mu.Lock()
+	slot, ok := s.slots[key]
+	if !ok {
+		slot = make(chan struct{}, s.limit)
+		s.slots[key] = slot
+	}
+	s.active[key]++
+	s.mu.Unlock()
+
+	release := func() {
+		<-slot
+		s.done(key)
+	}
+
+	// A free slot is taken before looking at ctx, which is already done when
+	// the queue timeout is zero and select picks ready cases at random
+	select {
+	case slot <- struct{}{}:
+		return release, nil
+	default:
+	}
+
+	select {
+	case slot <- struct{}{}:
+		return release, nil
+	case <-ctx.Done():
+		s.done(key)
+		return nil, ErrLimitExceeded
+	}
+}
+
+// done drops the bookkeeping for a key once nobody is using or waiting on it
+func (s *keyedSemaphore) done(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.active[key]--
+	if s.active[key] == 0 {
+		delete(s.active, key)
+		delete(s.slots, key)
+	}
+}
+
+// ExecutionLimiter caps concurrent query executions per user and per target database
+type ExecutionLimiter struct {
+	users        *keyedSemaphore
+	databases    *keyedSemaphore
+	queueTimeout time.Duration
+}
+
+// NewExecutionLimiter creates a limiter. A limit of zero disables that cap and
+// queueTimeout is how long a request may wait for a free slot.
+func NewExecutionLimiter(perUser, perDatabase int, queueTimeout time.Duration) *ExecutionLimiter {
+	return &ExecutionLimiter{
+		users:        newKeyedSemaphore(perUser),
+		databases:    newKeyedSemaphore(perDatabase),
+		queueTimeout: queueTimeout,
+	}
+}
+
+// Acquire waits for an execution slot for the user and database. The returned
+// function must be called to release the slot once execution has finished.
+func (l *ExecutionLimiter) Acquire(ctx context.Context, userID, databaseID string) (func(), error) {
+	if l == nil {
+		return func() {}, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, l.queueTimeout)
+	defer cancel()
+
+	releaseUser, err := l.users.acquire(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	releaseDatabase, err := l.databases.acquire(ctx, databaseID)
+	if err != nil {
+		releaseUser()
+		return nil, err
+	}
+
+	return func() {
+		releaseDatabase()
+		releaseUser()
+	}, nil
+}
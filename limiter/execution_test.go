@@ -0,0 +1,24 @@
+package limiter
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestExecutionLimiterZeroQueueTimeout(t *testing.T) {
+	l := NewExecutionLimiter(1, 1, 0)
+
+	// Without a queue, a free slot is always taken and a busy one never waited on
+	for i := 0; i < 1000; i++ {
+		release, err := l.Acquire(context.Background(), "user", "database")
+		if err != nil {
+			t.Fatalf("Acquire() with a free slot failed on attempt %d: %v", i, err)
+		}
+
+		if _, err := l.Acquire(context.Background(), "user", "database"); !errors.Is(err, ErrLimitExceeded) {
+			t.Fatalf("Acquire() with a busy slot = %v, want ErrLimitExceeded", err)
+		}
+		release()
+	}
+}
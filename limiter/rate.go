@@ -0,0 +1,144 @@
+package limiter
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/zucced/goquery/cache"
+)
+
+// rateWindow counts the requests for a key in the current window
+type rateWindow struct {
+	start time.Time
+	count int
+}
+
+// RateLimiter allows a fixed number of requests per key in each time window
+type RateLimiter struct {
+	mu      sync.Mutex
+	limit   int
+	window  time.Duration
+	windows map[string]*rateWindow
+
+	// redis shares the counts between servers when set, under keys starting
+	// with prefix
+	redis  *redis.Client
+	prefix string
+}
+
+// NewRateLimiter creates a rate limiter allowing limit requests per key every window
+func NewRateLimiter(limit int, window time.Duration) *RateLimiter {
+	return &RateLimiter{
+		limit:   limit,
+		window:  window,
+		windows: make(map[string]*rateWindow),
+	}
+}
+
+// NewSharedRateLimiter creates a rate limiter like NewRateLimiter whose counts
+// are kept in Redis, so every server enforces the same limit. Limiters with
+// different names count separately. While Redis is unreachable each server
+// counts on its own.
+func NewSharedRateLimiter(client *redis.Client, name string, limit int, window time.Duration) *RateLimiter {
+	l := NewRateLimiter(limit, window)
+	l.redis = client
+	l.prefix = cache.KeyPrefix + "ratelimit:" + name + ":"
+	return l
+}
+
+// takeScript counts a request in the key's window, starting the window on the
+// first request, and returns the count and the milliseconds until it resets
+var takeScript = redis.NewScript(`
+local count = redis.call("INCR", KEYS[1])
+if count == 1 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[1])
+end
+return {count, redis.call("PTTL", KEYS[1])}
+`)
+
+// sharedTimeout bounds how long a request waits for Redis before counting locally
+const sharedTimeout = 500 * time.Millisecond
+
+// Allow records a request for key and reports whether it is within the limit
+func (l *RateLimiter) Allow(key string) bool {
+	allowed, _, _ := l.Take(key)
+	return allowed
+}
+
+// Take records a request for key and reports whether it is within the limit,
+// how many requests remain in the current window and when the window resets
+func (l *RateLimiter) Take(key string) (allowed bool, remaining int, reset time.Time) {
+	if l == nil || l.limit <= 0 {
+		return true, 0, time.Time{}
+	}
+
+	if l.redis != nil {
+		allowed, remaining, reset, err := l.takeShared(key)
+		if err == nil {
+			return allowed, remaining, reset
+		}
+		log.Printf("Failed to count request in Redis, counting locally: %v", err)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.sweep(now)
+
+	w, ok := l.windows[key]
+	if !ok || now.Sub(w.start) >= l.window {
+		w = &rateWindow{start: now}
+		l.windows[key] = w
+	}
+	reset = w.start.Add(l.window)
+
+	if w.count >= l.limit {
+		return false, 0, reset
+	}
+	w.count++
+	return true, l.limit - w.count, reset
+}
+
+// takeShared records a request for key in Redis
+func (l *RateLimiter) takeShared(key string) (allowed bool, remaining int, reset time.Time, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), sharedTimeout)
+	defer cancel()
+
+	result, err := takeScript.Run(ctx, l.redis, []string{l.prefix + key}, l.window.Milliseconds()).Int64Slice()
+	if err != nil {
+		return false, 0, time.Time{}, err
+	}
+
+	count, ttl := int(result[0]), time.Duration(result[1])*time.Millisecond
+	if ttl < 0 {
+		ttl = l.window
+	}
+	reset = time.Now().Add(ttl)
+
+	if count > l.limit {
+		return false, 0, reset, nil
+	}
+	return true, l.limit - count, reset, nil
+}
+
+// Limit returns the number of requests allowed per window, 0 when unlimited
+func (l *RateLimiter) Limit() int {
+	if l == nil || l.limit <= 0 {
+		return 0
+	}
+	return l.limit
+}
+
+// sweep drops expired windows so idle keys don't accumulate.
+// Callers must hold l.mu.
+func (l *RateLimiter) sweep(now time.Time) {
+	for key, w := range l.windows {
+		if now.Sub(w.start) >= l.window {
+			delete(l.windows, key)
+		}
+	}
+}
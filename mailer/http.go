@@ -0,0 +1,30 @@
+package mailer
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// httpClient sends the requests of the API providers
+var httpClient = &http.Client{Timeout: 30 * time.Second}
+
+// doRequest sends a provider API request, turning responses other than 2xx
+// into errors that include the start of the provider's explanation
+func doRequest(req *http.Request) error {
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+		return nil
+	}
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return fmt.Errorf("provider responded with status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+}
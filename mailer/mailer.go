@@ -0,0 +1,98 @@
+// Package mailer sends outgoing email such as password resets and scheduled
+// dashboard reports, through SMTP, Amazon SES or SendGrid.
+package mailer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/zucced/goquery/config"
+)
+
+// ErrNotConfigured is returned when email delivery has not been configured
+var ErrNotConfigured = errors.New("email delivery is not configured")
+
+// Attachment is a file attached to a message. Inline attachments are
+// referenced from the HTML body with cid:<ContentID>.
+type Attachment struct {
+	Filename    string `bson:"filename"`
+	ContentType string `bson:"content_type"`
+	Data        []byte `bson:"data"`
+	Inline      bool   `bson:"is_inline,omitempty"`
+	ContentID   string `bson:"content_id,omitempty"`
+}
+
+// Message is an email message. It is stored as the payload of queued sends.
+type Message struct {
+	To          []string     `bson:"to"`
+	Subject     string       `bson:"subject"`
+	TextBody    string       `bson:"text_body"`
+	HTMLBody    string       `bson:"html_body,omitempty"`
+	Attachments []Attachment `bson:"attachments,omitempty"`
+}
+
+// Mailer sends email messages
+type Mailer interface {
+	Send(ctx context.Context, msg *Message) error
+}
+
+// Providers email can be sent through
+const (
+	ProviderSMTP     = "smtp"
+	ProviderSES      = "ses"
+	ProviderSendGrid = "sendgrid"
+)
+
+// New creates the mailer of the configured provider. Without a provider the
+// returned mailer rejects every message with ErrNotConfigured.
+func New(cfg *config.Config) (Mailer, error) {
+	if cfg.MailProvider == "" {
+		return disabledMailer{}, nil
+	}
+	if cfg.MailFrom == "" {
+		return nil, errors.New("MAIL_FROM must be set to send email")
+	}
+
+	switch cfg.MailProvider {
+	case ProviderSMTP:
+		if cfg.SMTPHost == "" {
+			return nil, errors.New("SMTP_HOST must be set to send email through SMTP")
+		}
+		return &SMTPMailer{
+			Host:     cfg.SMTPHost,
+			Port:     cfg.SMTPPort,
+			Username: cfg.SMTPUsername,
+			Password: cfg.SMTPPassword,
+			From:     cfg.MailFrom,
+		}, nil
+	case ProviderSES:
+		if cfg.SESRegion == "" || cfg.SESAccessKeyID == "" || cfg.SESSecretAccessKey == "" {
+			return nil, errors.New("SES_REGION, SES_ACCESS_KEY_ID and SES_SECRET_ACCESS_KEY must be set to send email through SES")
+		}
+		return &SESMailer{
+			Region:          cfg.SESRegion,
+			AccessKeyID:     cfg.SESAccessKeyID,
+			SecretAccessKey: cfg.SESSecretAccessKey,
+			From:            cfg.MailFrom,
+		}, nil
+	case ProviderSendGrid:
+		if cfg.SendGridAPIKey == "" {
+			return nil, errors.New("SENDGRID_API_KEY must be set to send email through SendGrid")
+		}
+		return &SendGridMailer{
+			APIKey: cfg.SendGridAPIKey,
+			From:   cfg.MailFrom,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown MAIL_PROVIDER %q, use smtp, ses or sendgrid", cfg.MailProvider)
+	}
+}
+
+// disabledMailer is used when email delivery is not configured
+type disabledMailer struct{}
+
+// Send always fails with ErrNotConfigured
+func (disabledMailer) Send(ctx context.Context, msg *Message) error {
+	return ErrNotConfigured
+}
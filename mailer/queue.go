@@ -0,0 +1,45 @@
+package mailer
+
+import (
+	"context"
+	"time"
+
+	"github.com/zucced/goquery/models"
+)
+
+// JobType is the type of the jobs that send queued messages
+const JobType = "email"
+
+// Queue is a Mailer that sends messages from background jobs, so a message
+// that can't be sent right away is retried with backoff and survives
+// restarts. Send returns once the message is queued.
+type Queue struct {
+	store  models.Store
+	mailer Mailer
+}
+
+// NewQueue creates a queue sending its messages through mailer. Deliver must
+// be registered as the handler of JobType jobs.
+func NewQueue(store models.Store, mailer Mailer) *Queue {
+	return &Queue{store: store, mailer: mailer}
+}
+
+// Send queues a message. Without a configured provider nothing is queued and
+// ErrNotConfigured is returned, as from the mailer itself.
+func (q *Queue) Send(ctx context.Context, msg *Message) error {
+	if _, disabled := q.mailer.(disabledMailer); disabled {
+		return ErrNotConfigured
+	}
+
+	_, err := q.store.EnqueueJob(ctx, JobType, msg, "", time.Time{})
+	return err
+}
+
+// Deliver sends a queued message, the handler of JobType jobs
+func (q *Queue) Deliver(ctx context.Context, job *models.Job) error {
+	var msg Message
+	if err := job.DecodePayload(&msg); err != nil {
+		return err
+	}
+	return q.mailer.Send(ctx, &msg)
+}
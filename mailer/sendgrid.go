@@ -0,0 +1,109 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/mail"
+)
+
+// sendGridEndpoint is SendGrid's v3 send API
+const sendGridEndpoint = "https://api.sendgrid.com/v3/mail/send"
+
+// SendGridMailer sends email through the SendGrid API
+type SendGridMailer struct {
+	APIKey string
+	From   string
+}
+
+// sendGridAddress is an address in a SendGrid request
+type sendGridAddress struct {
+	Email string `json:"email"`
+	Name  string `json:"name,omitempty"`
+}
+
+// sendGridContent is a body of a SendGrid request
+type sendGridContent struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+// sendGridAttachment is an attachment of a SendGrid request
+type sendGridAttachment struct {
+	Content     string `json:"content"`
+	Type        string `json:"type"`
+	Filename    string `json:"filename"`
+	Disposition string `json:"disposition"`
+	ContentID   string `json:"content_id,omitempty"`
+}
+
+// sendGridPersonalization lists the recipients of a SendGrid request
+type sendGridPersonalization struct {
+	To []sendGridAddress `json:"to"`
+}
+
+// sendGridRequest is the body of a send request
+type sendGridRequest struct {
+	Personalizations []sendGridPersonalization `json:"personalizations"`
+	From             sendGridAddress           `json:"from"`
+	Subject          string                    `json:"subject"`
+	Content          []sendGridContent         `json:"content"`
+	Attachments      []sendGridAttachment      `json:"attachments,omitempty"`
+}
+
+// Send delivers a message to all of its recipients
+func (m *SendGridMailer) Send(ctx context.Context, msg *Message) error {
+	if len(msg.To) == 0 {
+		return errors.New("message has no recipients")
+	}
+
+	from, err := mail.ParseAddress(m.From)
+	if err != nil {
+		return fmt.Errorf("invalid sender address: %v", err)
+	}
+
+	body := sendGridRequest{
+		From:    sendGridAddress{Email: from.Address, Name: from.Name},
+		Subject: msg.Subject,
+		Content: []sendGridContent{{Type: "text/plain", Value: msg.TextBody}},
+	}
+	recipients := sendGridPersonalization{}
+	for _, to := range msg.To {
+		recipients.To = append(recipients.To, sendGridAddress{Email: to})
+	}
+	body.Personalizations = []sendGridPersonalization{recipients}
+	if msg.HTMLBody != "" {
+		body.Content = append(body.Content, sendGridContent{Type: "text/html", Value: msg.HTMLBody})
+	}
+	for _, attachment := range msg.Attachments {
+		disposition := "attachment"
+		if attachment.Inline {
+			disposition = "inline"
+		}
+		body.Attachments = append(body.Attachments, sendGridAttachment{
+			Content:     base64.StdEncoding.EncodeToString(attachment.Data),
+			Type:        attachment.ContentType,
+			Filename:    attachment.Filename,
+			Disposition: disposition,
+			ContentID:   attachment.ContentID,
+		})
+	}
+
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sendGridEndpoint, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+m.APIKey)
+	req.Header.Set("Content-Type", "application/json")
+
+	return doRequest(req)
+}
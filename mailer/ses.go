@@ -0,0 +1,112 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"time"
+)
+
+// SESMailer sends email through the Amazon SES v2 API. Messages are sent as
+// raw MIME so attachments and inline images work as they do over SMTP.
+type SESMailer struct {
+	Region          string
+	AccessKeyID     string
+	SecretAccessKey string
+	From            string
+}
+
+// sesRequest is the body of an SES SendEmail request
+type sesRequest struct {
+	FromEmailAddress string `json:"FromEmailAddress"`
+	Destination      struct {
+		ToAddresses []string `json:"ToAddresses"`
+	} `json:"Destination"`
+	Content struct {
+		Raw struct {
+			Data []byte `json:"Data"`
+		} `json:"Raw"`
+	} `json:"Content"`
+}
+
+// Send delivers a message to all of its recipients
+func (m *SESMailer) Send(ctx context.Context, msg *Message) error {
+	if len(msg.To) == 0 {
+		return errors.New("message has no recipients")
+	}
+
+	from, err := mail.ParseAddress(m.From)
+	if err != nil {
+		return fmt.Errorf("invalid sender address: %v", err)
+	}
+
+	raw, err := buildMessage(from.String(), msg)
+	if err != nil {
+		return err
+	}
+
+	var body sesRequest
+	body.FromEmailAddress = from.String()
+	body.Destination.ToAddresses = msg.To
+	body.Content.Raw.Data = raw
+
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	host := "email." + m.Region + ".amazonaws.com"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "https://"+host+"/v2/email/outbound-emails", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	m.sign(req, host, data, time.Now().UTC())
+
+	return doRequest(req)
+}
+
+// sign adds an AWS Signature Version 4 Authorization header to a request
+func (m *SESMailer) sign(req *http.Request, host string, payload []byte, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+
+	payloadHash := sha256.Sum256(payload)
+	signedHeaders := "content-type;host;x-amz-date"
+	canonicalRequest := req.Method + "\n" +
+		req.URL.EscapedPath() + "\n" +
+		req.URL.RawQuery + "\n" +
+		"content-type:" + req.Header.Get("Content-Type") + "\n" +
+		"host:" + host + "\n" +
+		"x-amz-date:" + amzDate + "\n" +
+		"\n" +
+		signedHeaders + "\n" +
+		hex.EncodeToString(payloadHash[:])
+
+	scope := date + "/" + m.Region + "/ses/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+m.SecretAccessKey), date)
+	key = hmacSHA256(key, m.Region)
+	key = hmacSHA256(key, "ses")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+m.AccessKeyID+"/"+scope+", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+// hmacSHA256 returns the HMAC-SHA256 of data keyed with key
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
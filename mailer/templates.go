@@ -0,0 +1,54 @@
+package mailer
+
+import (
+	"bytes"
+	"embed"
+	htmltemplate "html/template"
+	"strings"
+	"text/template"
+)
+
+// Templates of the messages the server sends. Each has a name.txt defining
+// its "subject" and "text" body and a name.html defining the "content" of the
+// shared HTML layout.
+const (
+	TemplatePasswordReset   = "password_reset"
+	TemplateDashboardInvite = "dashboard_invite"
+	TemplateAlert           = "alert"
+	TemplateNotification    = "notification"
+	TemplateQueryApproval   = "query_approval"
+	TemplateCommentMention  = "comment_mention"
+)
+
+//go:embed templates
+var templateFS embed.FS
+
+// Render builds a message to recipients from a template, filled in with data
+func Render(name string, to []string, data interface{}) (*Message, error) {
+	text, err := template.ParseFS(templateFS, "templates/"+name+".txt")
+	if err != nil {
+		return nil, err
+	}
+	html, err := htmltemplate.ParseFS(templateFS, "templates/layout.html", "templates/"+name+".html")
+	if err != nil {
+		return nil, err
+	}
+
+	var subject, textBody, htmlBody bytes.Buffer
+	if err := text.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return nil, err
+	}
+	if err := text.ExecuteTemplate(&textBody, "text", data); err != nil {
+		return nil, err
+	}
+	if err := html.ExecuteTemplate(&htmlBody, "layout", data); err != nil {
+		return nil, err
+	}
+
+	return &Message{
+		To:       to,
+		Subject:  strings.TrimSpace(subject.String()),
+		TextBody: textBody.String(),
+		HTMLBody: htmlBody.String(),
+	}, nil
+}
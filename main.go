@@ -1,18 +1,41 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"os"
+	"os/signal"
 	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
 	"github.com/gofiber/fiber/v2/middleware/logger"
 	"github.com/gofiber/fiber/v2/middleware/recover"
+	"github.com/redis/go-redis/v9"
+	"github.com/zucced/goquery/ai"
+	"github.com/zucced/goquery/alerts"
 	"github.com/zucced/goquery/api"
+	"github.com/zucced/goquery/cache"
 	"github.com/zucced/goquery/config"
 	"github.com/zucced/goquery/database"
+	"github.com/zucced/goquery/demo"
+	"github.com/zucced/goquery/features"
+	"github.com/zucced/goquery/limiter"
+	"github.com/zucced/goquery/mailer"
 	"github.com/zucced/goquery/middleware"
+	"github.com/zucced/goquery/models"
+	"github.com/zucced/goquery/notify"
+	"github.com/zucced/goquery/openapi"
+	"github.com/zucced/goquery/origins"
+	"github.com/zucced/goquery/realtime"
+	"github.com/zucced/goquery/sheets"
+	"github.com/zucced/goquery/webhooks"
+	"github.com/zucced/goquery/workers"
+	"golang.org/x/oauth2"
 )
 
 func main() {
@@ -22,13 +45,130 @@ func main() {
 		log.Fatalf("Failed to load configuration: %v", err)
 	}
 
-	fmt.Println("Loaded config: ", cfg)
+	log.Printf("Loaded %s configuration", cfg.AppEnv)
 
 	// Connect to MongoDB
-	if err := database.ConnectDB(cfg); err != nil {
-		log.Fatalf("Failed to connect to MongoDB: %v", err)
+	db, dbErr := database.ConnectDB(cfg)
+
+	// Refuse to start production with insecure or missing settings, reporting
+	// every problem at once instead of one per deploy
+	if cfg.IsProduction() {
+		problems := cfg.ProductionProblems()
+		if dbErr != nil {
+			problems = append(problems, fmt.Sprintf("MONGO_URI must be reachable: %v", dbErr))
+		}
+		if len(problems) > 0 {
+			log.Fatalf("Invalid production configuration:\n  - %s", strings.Join(problems, "\n  - "))
+		}
+	}
+
+	if dbErr != nil {
+		log.Fatalf("Failed to connect to MongoDB: %v", dbErr)
+	}
+	defer database.DisconnectDB(db)
+	store := models.NewStore(db)
+
+	// Close ssh tunnels to database bastion hosts on shutdown
+	defer models.SSHTunnels.Close()
+
+	// Ensure the indexes every collection is filtered and sorted by
+	indexCtx, indexCancel := context.WithTimeout(context.Background(), 30*time.Second)
+	if err := store.EnsureIndexes(indexCtx); err != nil {
+		log.Printf("Failed to create indexes: %v", err)
+	}
+	indexCancel()
+
+	// Cap the number of rows kept from a single query execution
+	models.MaxResultRows = cfg.MaxResultRows
+
+	// Number of documents sampled per collection when inferring MongoDB schemas
+	models.MongoDBSchemaSampleSize = cfg.MongoDBSchemaSampleSize
+
+	// Restrict embed cards to the configured domains
+	models.EmbeddableDomains = cfg.EmbeddableDomains
+
+	// Environment variables connections can reference as ${secret:NAME}
+	models.SecretPrefix = cfg.ImportSecretPrefix
+
+	// Share rate limits, caches and realtime messages between servers
+	var redisClient *redis.Client
+	if cfg.RedisURL != "" {
+		redisCtx, redisCancel := context.WithTimeout(context.Background(), 10*time.Second)
+		redisClient, err = cache.Connect(redisCtx, cfg.RedisURL)
+		redisCancel()
+		if err != nil {
+			log.Fatalf("Failed to connect to Redis: %v", err)
+		}
+		defer redisClient.Close()
+	}
+
+	// Cache AI responses and live card results, disabled when the TTL is 0
+	ai.ResponseCache = cache.New(redisClient, "ai", cfg.AICacheTTL)
+	resultCache := cache.New(redisClient, "results", cfg.ResultCacheTTL)
+
+	// Limit concurrent query executions per user and per target database
+	execLimiter := limiter.NewExecutionLimiter(
+		cfg.MaxConcurrentQueriesPerUser,
+		cfg.MaxConcurrentQueriesPerDatabase,
+		cfg.QueryQueueTimeout,
+	)
+
+	// Send email through the configured provider, queueing messages that can wait
+	mail, err := mailer.New(cfg)
+	if err != nil {
+		log.Fatalf("Failed to set up email: %v", err)
+	}
+	mailQueue := mailer.NewQueue(store, mail)
+	workers.RegisterJobHandler(mailer.JobType, mailQueue.Deliver)
+
+	// Start background workers
+	workerCtx, stopWorkers := context.WithCancel(context.Background())
+	defer stopWorkers()
+	workers.StartTrashPurger(workerCtx, store, cfg.TrashRetention, time.Hour)
+	hooks := webhooks.NewDispatcher(store, cfg.PublicURL)
+	workers.RegisterJobHandler(webhooks.JobType, hooks.Deliver)
+	sender := notify.NewSender(store, mail)
+	workers.RegisterJobHandler(notify.JobType, sender.Deliver)
+	notifier := alerts.NewNotifier(store, mail, hooks, sender, cfg.FrontendURL)
+	workers.RegisterJobHandler(alerts.JobType, notifier.Deliver)
+	workers.StartCardRefresher(workerCtx, store, execLimiter, hooks, time.Minute)
+	workers.StartReportScheduler(workerCtx, store, execLimiter, mail, hooks, sender, time.Minute)
+	workers.StartMaterializer(workerCtx, store, execLimiter, time.Minute)
+
+	// Export query results to Google Sheets, disabled without an OAuth client
+	googleConf := sheets.NewConfig(cfg)
+	if googleConf != nil {
+		workers.StartSheetsExporter(workerCtx, store, execLimiter, googleConf, time.Minute)
+	}
+	gateway := realtime.NewGateway()
+	hub := realtime.NewHub()
+	hub.ForwardTo(gateway)
+	if redisClient != nil {
+		realtime.StartRelay(workerCtx, redisClient, hub, gateway)
+	}
+	schemaRefresher := workers.StartSchemaRefresher(workerCtx, store, 2, time.Minute, gateway, hooks)
+	workers.StartJobRunner(workerCtx, store, sender, cfg.JobConcurrency, cfg.JobPollInterval)
+	workers.StartHostKeyPinner(workerCtx, store, 10*time.Minute)
+	if cfg.ConnectionHealthInterval > 0 {
+		models.DegradedLatency = cfg.DegradedLatency
+		workers.StartConnectionMonitor(workerCtx, store, 4, cfg.ConnectionHealthInterval)
+	}
+	workers.StartFreshnessMonitor(workerCtx, store, hooks, sender, 4, time.Minute)
+
+	// Demo mode lets visitors try the sample database without signing up, and
+	// sample data gives it to new users to start with
+	var demoProvisioner *demo.Provisioner
+	if cfg.DemoMode || cfg.SampleData {
+		demoCtx, demoCancel := context.WithTimeout(context.Background(), 3*time.Minute)
+		demoProvisioner, err = demo.NewProvisioner(demoCtx, store, cfg.DemoDatabaseURL, cfg.DemoSessionDuration)
+		demoCancel()
+		if err != nil {
+			log.Fatalf("Failed to set up the sample database: %v", err)
+		}
+	}
+	if cfg.DemoMode {
+		workers.StartDemoPurger(workerCtx, store, 5*time.Minute)
 	}
-	defer database.DisconnectDB()
 
 	// Create Fiber app
 	app := fiber.New(fiber.Config{
@@ -39,62 +179,340 @@ func main() {
 	// Middleware
 	app.Use(logger.New())
 	app.Use(recover.New())
+
+	// Browsers may call the API from the configured origins and those
+	// added through the admin API
+	allowlist := origins.NewAllowlist(store, cfg.AllowOrigins, cfg.IsProduction())
 	app.Use(cors.New(cors.Config{
-		AllowOrigins: cfg.AllowOrigins,
-		AllowHeaders: "Origin, Content-Type, Accept, Authorization",
-		AllowMethods: "GET, POST, PUT, DELETE",
+		AllowOriginsFunc: allowlist.Allowed,
+		AllowHeaders:     "Origin, Content-Type, Accept, Authorization, If-None-Match, Last-Event-ID, " + api.ConnectionIDHeader + ", " + middleware.WorkspaceIDHeader,
+		AllowMethods:     "GET, POST, PUT, DELETE",
+		ExposeHeaders:    "ETag, X-RateLimit-Limit, X-RateLimit-Remaining, X-RateLimit-Reset, Retry-After",
 	}))
+	app.Use(middleware.TimeoutMiddleware(cfg.RequestTimeout))
+	app.Use(middleware.AuditMiddleware(store, cfg))
+
+	// Roll features out gradually, see the features package
+	flags := features.NewService(store, cfg.FeatureFlags)
 
 	// Routes
-	setupRoutes(app, cfg)
+	setupRoutes(app, store, cfg, execLimiter, resultCache, redisClient, hub, gateway, flags, allowlist, hooks, sender, mail, mailQueue, demoProvisioner, schemaRefresher, googleConf)
 
 	// Start server
 	addr := ":" + strconv.Itoa(cfg.AppPort)
 	fmt.Printf("Server is running on http://localhost%s\n", addr)
-	if err := app.Listen(addr); err != nil {
+	listenErr := make(chan error, 1)
+	go func() {
+		listenErr <- app.Listen(addr)
+	}()
+
+	// Run until the server fails or is asked to stop
+	signals, stopSignals := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stopSignals()
+	select {
+	case err := <-listenErr:
 		log.Fatalf("Failed to start server: %v", err)
+	case <-signals.Done():
+	}
+
+	// A second signal stops the server immediately
+	stopSignals()
+	shutdown(app, store, hub, gateway, stopWorkers, cfg.ShutdownTimeout)
+}
+
+// shutdown stops accepting requests and gives in-flight requests and
+// background jobs until the timeout to finish. Queries that are still running
+// afterwards are marked as failed instead of being left running forever.
+func shutdown(app *fiber.App, store models.Store, hub *realtime.Hub, gateway *realtime.Gateway, stopWorkers context.CancelFunc, timeout time.Duration) {
+	log.Printf("Shutting down, waiting up to %s for requests and background jobs to finish", timeout)
+	deadline := time.Now().Add(timeout)
+
+	// Live dashboard and gateway connections never finish on their own
+	hub.Close()
+	gateway.Close()
+
+	// Stop workers from starting new jobs while requests drain
+	stopWorkers()
+
+	if err := app.ShutdownWithTimeout(time.Until(deadline)); err != nil {
+		log.Printf("Failed to wait for requests to finish: %v", err)
+	}
+
+	waitCtx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+	if err := workers.Wait(waitCtx); err != nil {
+		log.Printf("Background jobs are still running at the shutdown deadline")
+	}
+
+	// Flush the status of queries that didn't finish in time
+	flushCtx, flushCancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer flushCancel()
+	failed, err := store.FailRunningQueries(flushCtx, "Query was interrupted because the server shut down")
+	if err != nil {
+		log.Printf("Failed to update interrupted queries: %v", err)
+	} else if failed > 0 {
+		log.Printf("Marked %d interrupted queries as failed", failed)
 	}
 }
 
-func setupRoutes(app *fiber.App, cfg *config.Config) {
+func setupRoutes(app *fiber.App, store models.Store, cfg *config.Config, execLimiter *limiter.ExecutionLimiter, resultCache cache.Cache, redisClient *redis.Client, hub *realtime.Hub, gateway *realtime.Gateway, flags *features.Service, allowlist *origins.Allowlist, hooks *webhooks.Dispatcher, sender *notify.Sender, mail, mailQueue mailer.Mailer, demoProvisioner *demo.Provisioner, schemaRefresher *workers.SchemaRefresher, googleConf *oauth2.Config) {
 	// API group
 	apiGroup := app.Group("/api")
 
+	// Per-user request limits, with a smaller separate allowance for AI-backed endpoints
+	rateLimit := middleware.RateLimitMiddleware(newRateLimiter(redisClient, "requests", cfg.RateLimitRequests, cfg.RateLimitWindow))
+	aiRateLimit := middleware.RateLimitMiddleware(newRateLimiter(redisClient, "ai", cfg.RateLimitAIRequests, cfg.RateLimitWindow))
+
+	// Query results and schemas can be megabytes of JSON, so compress them
+	compress := middleware.CompressMiddleware(cfg.CompressionMinSize)
+
+	// Routes that connect to databases, run queries or call the AI model get
+	// longer than the default request timeout
+	queryTimeout := middleware.RouteTimeoutMiddleware(cfg.QueryRequestTimeout)
+
 	// Auth routes
 	auth := apiGroup.Group("/auth")
-	auth.Post("/signup", api.SignupHandler(cfg))
-	auth.Post("/login", api.LoginHandler(cfg))
-	auth.Get("/me", middleware.AuthMiddleware(cfg), api.MeHandler())
+	auth.Post("/signup", api.SignupHandler(store, cfg, demoProvisioner))
+	auth.Post("/login", api.LoginHandler(store, cfg))
+	auth.Get("/me", middleware.AuthMiddleware(store, cfg), rateLimit, api.MeHandler(store))
+	auth.Get("/me/preferences", middleware.AuthMiddleware(store, cfg), rateLimit, api.GetPreferencesHandler(store))
+	auth.Put("/me/preferences", middleware.AuthMiddleware(store, cfg), rateLimit, api.UpdatePreferencesHandler(store))
+	auth.Post("/logout", middleware.AuthMiddleware(store, cfg), rateLimit, api.LogoutHandler(store))
+	auth.Post("/change-password", middleware.AuthMiddleware(store, cfg), rateLimit, api.ChangePasswordHandler(store))
+	auth.Get("/sessions", middleware.AuthMiddleware(store, cfg), rateLimit, api.GetSessionsHandler(store))
+	auth.Delete("/sessions", middleware.AuthMiddleware(store, cfg), rateLimit, api.RevokeSessionsHandler(store))
+	auth.Delete("/sessions/:id", middleware.AuthMiddleware(store, cfg), rateLimit, api.RevokeSessionHandler(store))
+
+	// Password reset routes are public, so limit how often each client can call them
+	passwordResetLimiter := limiter.NewRateLimiter(10, 15*time.Minute)
+	auth.Post("/forgot-password", api.ForgotPasswordHandler(store, cfg, mail, passwordResetLimiter))
+	auth.Post("/reset-password", api.ResetPasswordHandler(store, passwordResetLimiter))
+
+	// Demo sessions are anonymous, so limit how many each client can start
+	if cfg.DemoMode {
+		auth.Post("/demo", api.StartDemoHandler(store, cfg, demoProvisioner, limiter.NewRateLimiter(5, time.Hour)))
+	}
+
+	// Single sign-on routes
+	auth.Post("/sso/lookup", api.SSOLookupHandler(store))
+	auth.Get("/sso/:orgId/login", api.SSOLoginHandler(store, cfg))
+	auth.Get("/sso/:orgId/callback", api.SSOCallbackHandler(store, cfg))
 
 	// Database routes (protected)
-	databases := apiGroup.Group("/databases", middleware.AuthMiddleware(cfg))
-	databases.Post("", api.CreateDatabaseHandler())
-	databases.Get("", api.GetDatabasesHandler())
-	databases.Get("/:id", api.GetDatabaseHandler())
-	databases.Delete("/:id", api.DeleteDatabaseHandler())
-	databases.Post("/test-connection", api.TestConnectionHandler())
-	databases.Get("/:id/queries", api.GetDatabaseQueriesHandler())
+	databases := apiGroup.Group("/databases", middleware.AuthMiddleware(store, cfg), rateLimit, middleware.WorkspaceMiddleware(store))
+	databases.Post("", queryTimeout, api.CreateDatabaseHandler(store, schemaRefresher))
+	databases.Get("", compress, api.GetDatabasesHandler(store))
+	databases.Get("/health", api.GetDatabasesHealthHandler(store))
+	databases.Post("/import", queryTimeout, api.ImportDatabasesHandler(store, cfg, schemaRefresher))
+	databases.Get("/:id", compress, api.GetDatabaseHandler(store, schemaRefresher))
+	databases.Delete("/:id", api.DeleteDatabaseHandler(store))
+	databases.Post("/:id/duplicate", queryTimeout, api.DuplicateDatabaseHandler(store, cfg, schemaRefresher))
+	databases.Post("/test-connection", queryTimeout, api.TestConnectionHandler())
+	databases.Post("/:id/test-connection", queryTimeout, api.TestSavedConnectionHandler(store))
+	databases.Get("/:id/queries", api.GetDatabaseQueriesHandler(store))
+	databases.Get("/:id/suggestions", api.GetDatabaseSuggestionsHandler(store))
+	databases.Put("/:id/organization", api.SetDatabaseOrganizationHandler(store))
+	databases.Put("/:id/members", api.SetDatabaseMembersHandler(store))
+	databases.Put("/:id/schema/annotations", api.SetSchemaAnnotationsHandler(store))
+	databases.Put("/:id/masking", api.SetDatabaseMaskingHandler(store))
+	databases.Post("/:id/tables/:table/profile", queryTimeout, api.ProfileTableHandler(store))
+	databases.Get("/:id/freshness", api.GetFreshnessChecksHandler(store))
+	databases.Post("/:id/freshness", api.CreateFreshnessCheckHandler(store))
+	databases.Put("/:id/freshness/:checkId", api.UpdateFreshnessCheckHandler(store))
+	databases.Delete("/:id/freshness/:checkId", api.DeleteFreshnessCheckHandler(store))
 
 	// Query routes (protected)
-	queries := apiGroup.Group("/queries", middleware.AuthMiddleware(cfg))
-	queries.Post("", api.CreateQueryHandler(cfg))
-	queries.Get("", api.GetQueriesHandler())
-	queries.Get("/:id", api.GetQueryHandler())
-	queries.Put("/:id", api.UpdateQueryHandler())
-	queries.Delete("/:id", api.DeleteQueryHandler())
-	queries.Post("/:id/rerun", api.RerunQueryHandler())
+	queries := apiGroup.Group("/queries", middleware.AuthMiddleware(store, cfg), rateLimit, middleware.WorkspaceMiddleware(store))
+	queries.Post("", aiRateLimit, queryTimeout, compress, api.CreateQueryHandler(store, cfg, execLimiter, gateway, hooks, mailQueue, flags))
+	queries.Post("/federated", aiRateLimit, queryTimeout, compress, api.CreateFederatedQueryHandler(store, cfg, execLimiter, gateway, hooks))
+	queries.Get("", compress, api.GetQueriesHandler(store))
+	queries.Get("/approvals", api.GetPendingApprovalsHandler(store))
+	queries.Get("/:id", compress, api.GetQueryHandler(store))
+	queries.Put("/:id", api.UpdateQueryHandler(store))
+	queries.Delete("/:id", api.DeleteQueryHandler(store))
+	queries.Post("/:id/rerun", queryTimeout, compress, api.RerunQueryHandler(store, cfg, execLimiter, gateway, hooks, mailQueue, flags))
+	queries.Put("/:id/tags", api.SetQueryTagsHandler(store))
+	queries.Post("/:id/duplicate", api.DuplicateQueryHandler(store))
+	queries.Post("/:id/restore", api.RestoreQueryHandler(store))
+	queries.Post("/:id/confirm-write", queryTimeout, compress, api.ConfirmWriteHandler(store, execLimiter, gateway, hooks, flags))
+	queries.Post("/:id/approve", queryTimeout, compress, api.ApproveQueryHandler(store, execLimiter, gateway, hooks, flags))
+	queries.Post("/:id/reject", api.RejectQueryHandler(store, gateway, hooks))
+	queries.Get("/:id/chart-data", compress, api.GetChartDataHandler(store))
+	queries.Post("/:id/pivot", compress, api.PivotQueryHandler(store))
+	queries.Post("/:id/transform", compress, api.TransformQueryHandler(store))
+	queries.Get("/:id/runs", api.GetQueryRunsHandler(store))
+	queries.Put("/:id/materialization", api.MaterializeQueryHandler(store))
+	queries.Delete("/:id/materialization", api.UnmaterializeQueryHandler(store))
+	queries.Get("/:id/comments", compress, api.GetQueryCommentsHandler(store))
+	queries.Post("/:id/comments", api.CreateQueryCommentHandler(store))
+	queries.Put("/:id/comments/:commentId", api.UpdateQueryCommentHandler(store))
+	queries.Delete("/:id/comments/:commentId", api.DeleteQueryCommentHandler(store))
+	queries.Get("/:id/export/google-sheets", api.GetSheetExportHandler(store))
+	queries.Post("/:id/export/google-sheets", api.ExportQueryToSheetsHandler(store, googleConf))
+	queries.Put("/:id/export/google-sheets", api.UpdateSheetExportScheduleHandler(store))
+	queries.Delete("/:id/export/google-sheets", api.DeleteSheetExportHandler(store))
+	queries.Put("/:id/organization", api.SetQueryOrganizationHandler(store))
 
 	// Dashboard routes (protected)
-	dashboards := apiGroup.Group("/dashboards", middleware.AuthMiddleware(cfg))
-	dashboards.Post("", api.CreateDashboardHandler())
-	dashboards.Get("", api.GetDashboardsHandler())
-	dashboards.Get("/:id", api.GetDashboardHandler())
-	dashboards.Put("/:id", api.UpdateDashboardHandler())
-	dashboards.Delete("/:id", api.DeleteDashboardHandler())
-	dashboards.Post("/:id/cards", api.AddCardHandler())
-	dashboards.Put("/:id/cards/:cardId", api.UpdateCardHandler())
-	dashboards.Delete("/:id/cards/:cardId", api.DeleteCardHandler())
-	dashboards.Put("/:id/cards", api.UpdateCardPositionsHandler())
+	dashboards := apiGroup.Group("/dashboards", middleware.AuthMiddleware(store, cfg), rateLimit, middleware.WorkspaceMiddleware(store))
+	dashboards.Post("", api.CreateDashboardHandler(store))
+	dashboards.Get("", api.GetDashboardsHandler(store))
+	dashboards.Get("/default", api.GetDefaultDashboardHandler(store))
+	dashboards.Post("/invites/accept", api.AcceptDashboardInviteHandler(store))
+	dashboards.Get("/:id", api.GetDashboardHandler(store))
+	dashboards.Put("/:id", api.UpdateDashboardHandler(store, hub))
+	dashboards.Delete("/:id", api.DeleteDashboardHandler(store, hub))
+	dashboards.Post("/:id/cards", api.AddCardHandler(store, hub))
+	dashboards.Put("/:id/cards/:cardId", api.UpdateCardHandler(store, hub))
+	dashboards.Delete("/:id/cards/:cardId", api.DeleteCardHandler(store, hub))
+	dashboards.Get("/:id/data", queryTimeout, compress, api.GetDashboardDataHandler(store, execLimiter, resultCache))
+	dashboards.Post("/:id/refresh", queryTimeout, api.RefreshDashboardHandler(store, execLimiter, hub, hooks))
+	dashboards.Get("/:id/cards/:cardId/data", queryTimeout, compress, api.GetCardDataHandler(store, execLimiter, resultCache))
+	dashboards.Put("/:id/cards", api.UpdateCardPositionsHandler(store, hub))
+	dashboards.Get("/:id/live", api.DashboardLiveHandler(store, hub))
+	dashboards.Post("/:id/restore", api.RestoreDashboardHandler(store))
+	dashboards.Post("/:id/star", api.StarDashboardHandler(store))
+	dashboards.Delete("/:id/star", api.UnstarDashboardHandler(store))
+	dashboards.Post("/:id/set-default", api.SetDefaultDashboardHandler(store))
+	dashboards.Post("/:id/archive", api.ArchiveDashboardHandler(store))
+	dashboards.Post("/:id/unarchive", api.UnarchiveDashboardHandler(store))
+	dashboards.Post("/:id/snapshot", api.CreateSnapshotHandler(store, hooks))
+	dashboards.Get("/:id/snapshots", api.GetSnapshotsHandler(store))
+	dashboards.Get("/:id/snapshots/:snapshotId", compress, api.GetSnapshotHandler(store))
+	dashboards.Get("/:id/snapshots/:snapshotId/export", compress, api.ExportSnapshotHandler(store))
+	dashboards.Post("/:id/snapshots/:snapshotId/export/link", api.CreateSnapshotDownloadLinkHandler(store, cfg))
+	dashboards.Post("/:id/reports", api.CreateReportScheduleHandler(store))
+	dashboards.Get("/:id/reports", api.GetReportSchedulesHandler(store))
+	dashboards.Put("/:id/reports/:reportId", api.UpdateReportScheduleHandler(store))
+	dashboards.Delete("/:id/reports/:reportId", api.DeleteReportScheduleHandler(store))
+	dashboards.Get("/:id/reports/:reportId/deliveries", api.GetReportDeliveriesHandler(store))
+	dashboards.Post("/:id/embed", api.CreateEmbedTokenHandler(store, cfg))
+	dashboards.Get("/:id/collaborators", api.GetCollaboratorsHandler(store))
+	dashboards.Post("/:id/collaborators", api.ShareDashboardHandler(store, cfg, gateway, mailQueue))
+	dashboards.Delete("/:id/collaborators/:collaboratorId", api.UnshareDashboardHandler(store))
+	dashboards.Get("/:id/comments", api.GetDashboardCommentsHandler(store))
+	dashboards.Post("/:id/comments", api.CreateDashboardCommentHandler(store, cfg, hub, gateway, mailQueue))
+	dashboards.Put("/:id/comments/:commentId", api.UpdateDashboardCommentHandler(store, cfg, hub, gateway, mailQueue))
+	dashboards.Delete("/:id/comments/:commentId", api.DeleteDashboardCommentHandler(store, hub))
+	dashboards.Post("/:id/comments/:commentId/resolve", api.ResolveDashboardCommentHandler(store, hub))
+	dashboards.Post("/:id/comments/:commentId/reopen", api.ReopenDashboardCommentHandler(store, hub))
+	dashboards.Put("/:id/organization", api.SetDashboardOrganizationHandler(store))
+
+	// Organization routes (protected)
+	orgs := apiGroup.Group("/orgs", middleware.AuthMiddleware(store, cfg), rateLimit)
+	orgs.Post("", api.CreateOrganizationHandler(store))
+	orgs.Get("", api.GetOrganizationsHandler(store))
+	orgs.Get("/:id", api.GetOrganizationHandler(store))
+	orgs.Put("/:id", api.UpdateOrganizationHandler(store))
+	orgs.Delete("/:id", api.DeleteOrganizationHandler(store))
+	orgs.Post("/:id/members", api.AddOrganizationMemberHandler(store, gateway))
+	orgs.Put("/:id/members/:userId", api.UpdateOrganizationMemberHandler(store))
+	orgs.Delete("/:id/members/:userId", api.RemoveOrganizationMemberHandler(store))
+	orgs.Get("/:id/sso", api.GetOrganizationSSOHandler(store, cfg))
+	orgs.Put("/:id/sso", api.UpdateOrganizationSSOHandler(store, cfg))
+	orgs.Delete("/:id/sso", api.DeleteOrganizationSSOHandler(store))
+	orgs.Post("/:id/sso/verify", api.VerifyOrganizationSSODomainsHandler(store, cfg))
+	orgs.Get("/:id/workspaces", api.GetWorkspacesHandler(store))
+	orgs.Post("/:id/workspaces", api.CreateWorkspaceHandler(store))
+	orgs.Put("/:id/workspaces/:workspaceId", api.UpdateWorkspaceHandler(store))
+	orgs.Delete("/:id/workspaces/:workspaceId", api.DeleteWorkspaceHandler(store))
+	orgs.Get("/:id/templates", api.GetQueryTemplatesHandler(store))
+	orgs.Post("/:id/templates", api.CreateQueryTemplateHandler(store))
+
+	// Query template routes (protected)
+	templates := apiGroup.Group("/templates", middleware.AuthMiddleware(store, cfg), rateLimit, middleware.WorkspaceMiddleware(store))
+	templates.Get("/:id", api.GetQueryTemplateHandler(store))
+	templates.Put("/:id", api.UpdateQueryTemplateHandler(store))
+	templates.Delete("/:id", api.DeleteQueryTemplateHandler(store))
+	templates.Post("/:id/instantiate", api.InstantiateQueryTemplateHandler(store))
+
+	// Alert routes (protected)
+	alertRoutes := apiGroup.Group("/alerts", middleware.AuthMiddleware(store, cfg), rateLimit)
+	alertRoutes.Post("", api.CreateAlertHandler(store))
+	alertRoutes.Get("", api.GetAlertsHandler(store))
+	alertRoutes.Get("/:id", api.GetAlertHandler(store))
+	alertRoutes.Put("/:id", api.UpdateAlertHandler(store))
+	alertRoutes.Delete("/:id", api.DeleteAlertHandler(store))
+	alertRoutes.Get("/:id/samples", api.GetAlertSamplesHandler(store))
+	alertRoutes.Post("/:id/snooze", api.SnoozeAlertHandler(store))
+	alertRoutes.Delete("/:id/snooze", api.UnsnoozeAlertHandler(store))
+	alertRoutes.Get("/:id/events", api.GetAlertEventsHandler(store))
+	alertRoutes.Post("/:id/acknowledge", api.AcknowledgeAlertHandler(store))
+	alertRoutes.Post("/:id/resolve", api.ResolveAlertHandler(store))
+
+	// Notification channel routes
+	channelRoutes := apiGroup.Group("/channels", middleware.AuthMiddleware(store, cfg), rateLimit)
+	channelRoutes.Post("", api.CreateNotificationChannelHandler(store, cfg))
+	channelRoutes.Get("", api.GetNotificationChannelsHandler(store))
+	channelRoutes.Get("/:id", api.GetNotificationChannelHandler(store))
+	channelRoutes.Put("/:id", api.UpdateNotificationChannelHandler(store, cfg))
+	channelRoutes.Delete("/:id", api.DeleteNotificationChannelHandler(store))
+	channelRoutes.Post("/:id/test", api.TestNotificationChannelHandler(store, sender))
+	channelRoutes.Get("/:id/deliveries", api.GetChannelDeliveriesHandler(store))
+
+	// In-app notification routes
+	notificationRoutes := apiGroup.Group("/notifications", middleware.AuthMiddleware(store, cfg), rateLimit)
+	notificationRoutes.Get("", api.GetNotificationsHandler(store))
+	notificationRoutes.Post("/read", api.MarkNotificationsReadHandler(store))
+
+	// Webhook routes (protected)
+	webhookRoutes := apiGroup.Group("/webhooks", middleware.AuthMiddleware(store, cfg), rateLimit)
+	webhookRoutes.Post("", api.CreateWebhookHandler(store))
+	webhookRoutes.Get("", api.GetWebhooksHandler(store))
+	webhookRoutes.Get("/:id", api.GetWebhookHandler(store))
+	webhookRoutes.Put("/:id", api.UpdateWebhookHandler(store))
+	webhookRoutes.Delete("/:id", api.DeleteWebhookHandler(store))
+	webhookRoutes.Post("/:id/test", api.TestWebhookHandler(store, hooks))
+	webhookRoutes.Get("/:id/deliveries", api.GetWebhookDeliveriesHandler(store))
+
+	// Google integration routes, the callback is public as Google redirects the browser to it
+	integrations := apiGroup.Group("/integrations")
+	integrations.Get("/google", middleware.AuthMiddleware(store, cfg), rateLimit, api.GetGoogleIntegrationHandler(store, googleConf))
+	integrations.Post("/google/connect", middleware.AuthMiddleware(store, cfg), rateLimit, api.ConnectGoogleHandler(cfg, googleConf))
+	integrations.Get("/google/callback", api.GoogleCallbackHandler(store, cfg, googleConf))
+	integrations.Delete("/google", middleware.AuthMiddleware(store, cfg), rateLimit, api.DisconnectGoogleHandler(store))
+
+	// Trash routes (protected)
+	apiGroup.Get("/trash", middleware.AuthMiddleware(store, cfg), rateLimit, api.GetTrashHandler(store))
+
+	// Lineage routes (protected)
+	apiGroup.Get("/lineage", middleware.AuthMiddleware(store, cfg), rateLimit, compress, api.GetLineageHandler(store))
+
+	// Onboarding routes (protected)
+	if cfg.SampleData {
+		apiGroup.Post("/onboarding/sample-data", middleware.AuthMiddleware(store, cfg), rateLimit, api.AddSampleDataHandler(demoProvisioner))
+	}
+
+	// Search routes (protected)
+	apiGroup.Get("/search", middleware.AuthMiddleware(store, cfg), rateLimit, middleware.WorkspaceMiddleware(store), compress, api.SearchHandler(store))
+
+	// Feature routes (protected)
+	apiGroup.Get("/features", middleware.AuthMiddleware(store, cfg), rateLimit, middleware.WorkspaceMiddleware(store), api.GetFeaturesHandler(flags))
+
+	// Admin routes (protected, for the operators listed in ADMIN_EMAILS)
+	admin := apiGroup.Group("/admin", middleware.AuthMiddleware(store, cfg), rateLimit, middleware.AdminMiddleware(store, cfg))
+	admin.Get("/jobs", api.GetJobsHandler(store))
+	admin.Get("/jobs/:id", api.GetJobHandler(store))
+	admin.Post("/jobs/:id/retry", api.RetryJobHandler(store))
+	admin.Get("/features", api.GetFeatureFlagsHandler(store, flags))
+	admin.Put("/features/:key", api.UpdateFeatureFlagHandler(flags))
+	admin.Get("/audit", api.GetAuditLogHandler(store))
+	admin.Get("/origins", api.GetAllowedOriginsHandler(store, allowlist))
+	admin.Post("/origins", api.AddAllowedOriginHandler(allowlist))
+	admin.Delete("/origins", api.RemoveAllowedOriginHandler(allowlist))
+
+	// Gateway routes (protected), multiplexing server-push channels over a
+	// WebSocket or, where WebSockets are blocked, Server-Sent Events
+	app.Get("/ws", middleware.AuthMiddleware(store, cfg), api.GatewayHandler(store, gateway))
+	app.Get("/events", middleware.AuthMiddleware(store, cfg), api.EventsHandler(store, gateway))
+
+	// Embed routes (protected by embed tokens)
+	embed := apiGroup.Group("/embed", middleware.EmbedMiddleware(cfg))
+	embed.Get("/dashboard", api.GetEmbeddedDashboardHandler(store))
+	embed.Get("/cards/:cardId/data", queryTimeout, compress, api.GetEmbeddedCardDataHandler(store, execLimiter, resultCache))
+
+	// Download routes (protected by the signed token in the link)
+	apiGroup.Get("/downloads/:token", compress, api.DownloadHandler(store, cfg))
 
 	// Health check
 	app.Get("/health", func(c *fiber.Ctx) error {
@@ -102,6 +520,21 @@ func setupRoutes(app *fiber.App, cfg *config.Config) {
 			"status": "ok",
 		})
 	})
+
+	// API documentation, generated from the routes registered above
+	spec := openapi.NewSpec("GoQuery API", "1.0.0")
+	api.DescribeRoutes(spec)
+	apiGroup.Get("/openapi.json", api.OpenAPIHandler(spec.Document(app.GetRoutes(true))))
+	apiGroup.Get("/docs", api.SwaggerUIHandler(cfg))
+}
+
+// newRateLimiter creates a rate limiter whose counts are shared between
+// servers through Redis when it is configured
+func newRateLimiter(redisClient *redis.Client, name string, limit int, window time.Duration) *limiter.RateLimiter {
+	if redisClient != nil {
+		return limiter.NewSharedRateLimiter(redisClient, name, limit, window)
+	}
+	return limiter.NewRateLimiter(limit, window)
 }
 
 func errorHandler(c *fiber.Ctx, err error) error {
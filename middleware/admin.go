@@ -0,0 +1,36 @@
+package middleware
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/zucced/goquery/config"
+	"github.com/zucced/goquery/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// AdminMiddleware only lets the operators listed in ADMIN_EMAILS through.
+// Must run after AuthMiddleware.
+func AdminMiddleware(store models.Store, cfg *config.Config) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from context
+		userID := c.Locals("user_id").(primitive.ObjectID)
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Get user
+		user, err := store.GetUserByID(ctx, userID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve user: " + err.Error(),
+			})
+		}
+
+		if user == nil || !cfg.IsAdmin(user.Email) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "Administrator access required",
+			})
+		}
+
+		return c.Next()
+	}
+}
@@ -0,0 +1,180 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/zucced/goquery/config"
+	"github.com/zucced/goquery/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// auditedMethods are the methods of requests that change something
+var auditedMethods = map[string]bool{
+	fiber.MethodPost:   true,
+	fiber.MethodPut:    true,
+	fiber.MethodPatch:  true,
+	fiber.MethodDelete: true,
+}
+
+// sensitiveFields are parts of the field names whose values are never
+// recorded, matched case-insensitively
+var sensitiveFields = []string{"password", "secret", "token", "passphrase", "private_key", "client_key", "api_key", "apikey"}
+
+// urlCredentials matches the password in URLs such as connection URIs that
+// net/url can't parse, e.g. with several hosts. The password runs to the last
+// @, since it may hold an unescaped one.
+var urlCredentials = regexp.MustCompile(`(://[^:/@\s]*:)\S*@`)
+
+// keyValueSecrets matches sensitive key=value pairs, in query strings such as
+// ?sslpassword=... and in key=value DSNs such as password='...'
+var keyValueSecrets = regexp.MustCompile(`(?i)([a-z0-9_.]*(?:` + strings.Join(sensitiveFields, "|") + `)[a-z0-9_.]*\s*=\s*)('(?:[^'\\]|\\.)*'|[^\s&;]+)`)
+
+// redacted replaces the values of sensitive fields
+const redacted = "[REDACTED]"
+
+// AuditMiddleware records every request that changes something in the audit
+// log: who made it, what it matched, how it ended and how long it took. JSON
+// bodies are recorded with secrets redacted unless AUDIT_BODY_CAPTURE is none
+// or the path is excluded. Must run before the routes so it sees the user
+// AuthMiddleware sets.
+func AuditMiddleware(store models.Store, cfg *config.Config) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if !auditedMethods[c.Method()] {
+			return c.Next()
+		}
+
+		start := time.Now()
+		err := c.Next()
+
+		// Errors are turned into responses by the error handler afterwards
+		status := c.Response().StatusCode()
+		if err != nil {
+			status = fiber.StatusInternalServerError
+			var fiberErr *fiber.Error
+			if errors.As(err, &fiberErr) {
+				status = fiberErr.Code
+			}
+		}
+
+		entry := &models.AuditEntry{
+			Method:    c.Method(),
+			Path:      c.Path(),
+			Route:     c.Route().Path,
+			Status:    status,
+			LatencyMs: time.Since(start).Milliseconds(),
+			IP:        c.IP(),
+			UserAgent: c.Get(fiber.HeaderUserAgent),
+			CreatedAt: start,
+		}
+		if userID, ok := c.Locals("user_id").(primitive.ObjectID); ok {
+			entry.UserID = userID
+		}
+		entry.Body, entry.BodyNote = auditBody(c, cfg)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := store.CreateAuditEntry(ctx, entry); err != nil {
+			log.Printf("Failed to record audit entry for %s %s: %v", entry.Method, entry.Path, err)
+		}
+
+		return err
+	}
+}
+
+// auditBody returns the request body to record under the capture policy, or
+// a note on why it was left out or cut short
+func auditBody(c *fiber.Ctx, cfg *config.Config) (string, string) {
+	body := c.Body()
+	if cfg.AuditBodyCapture == "none" || len(body) == 0 {
+		return "", ""
+	}
+
+	for _, prefix := range cfg.AuditBodyExclude {
+		if strings.HasPrefix(c.Path(), prefix) {
+			return "", "excluded by policy"
+		}
+	}
+
+	if !strings.HasPrefix(c.Get(fiber.HeaderContentType), fiber.MIMEApplicationJSON) {
+		return "", "not JSON"
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return "", "malformed JSON"
+	}
+
+	data, err := json.Marshal(redact(value))
+	if err != nil {
+		return "", "malformed JSON"
+	}
+
+	if len(data) > cfg.AuditBodyMaxSize {
+		return strings.ToValidUTF8(string(data[:cfg.AuditBodyMaxSize]), ""), fmt.Sprintf("truncated to %d bytes", cfg.AuditBodyMaxSize)
+	}
+	return string(data), ""
+}
+
+// redact replaces the values of sensitive fields and the passwords in URLs
+// throughout a decoded JSON value
+func redact(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, field := range v {
+			if isSensitiveField(key) {
+				if field != nil && field != "" {
+					v[key] = redacted
+				}
+				continue
+			}
+			v[key] = redact(field)
+		}
+		return v
+	case []interface{}:
+		for i, item := range v {
+			v[i] = redact(item)
+		}
+		return v
+	case string:
+		return redactString(v)
+	default:
+		return v
+	}
+}
+
+// redactString replaces the passwords of URLs and the values of sensitive
+// key=value pairs in a string
+func redactString(value string) string {
+	if parsed, err := url.Parse(value); err == nil && parsed.Scheme != "" && parsed.Host != "" {
+		if _, ok := parsed.User.Password(); ok {
+			parsed.User = url.UserPassword(parsed.User.Username(), redacted)
+			value = strings.Replace(parsed.String(), url.QueryEscape(redacted), redacted, 1)
+		}
+	} else {
+		value = urlCredentials.ReplaceAllString(value, "${1}"+redacted+"@")
+	}
+	return keyValueSecrets.ReplaceAllString(value, "${1}"+redacted)
+}
+
+// isSensitiveField reports whether a field's value must not be recorded
+func isSensitiveField(name string) bool {
+	name = strings.ToLower(name)
+	for _, sensitive := range sensitiveFields {
+		if strings.Contains(name, sensitive) {
+			return true
+		}
+	}
+	return false
+}
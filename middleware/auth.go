@@ -1,26 +1,37 @@
 package middleware
 
 import (
+	"log"
 	"strings"
 	"time"
 
+	"github.com/gofiber/contrib/websocket"
 	"github.com/gofiber/fiber/v2"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/zucced/goquery/config"
+	"github.com/zucced/goquery/models"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
-// TokenClaims contains the claims of the JWT token
+// TokenClaims contains the claims of the JWT token. The jti claim holds the
+// session the token was issued for, and tokens of revoked sessions are rejected.
 type TokenClaims struct {
 	UserID string `json:"user_id"`
 	jwt.RegisteredClaims
 }
 
 // AuthMiddleware is a middleware that checks for a valid JWT token
-func AuthMiddleware(cfg *config.Config) fiber.Handler {
+func AuthMiddleware(store models.Store, cfg *config.Config) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		// Get the Authorization header
 		authHeader := c.Get("Authorization")
+
+		// Browsers can't set headers on WebSocket handshakes or EventSource
+		// requests, so accept the token as a query parameter
+		if authHeader == "" && (websocket.IsWebSocketUpgrade(c) || IsEventStream(c)) && c.Query("token") != "" {
+			authHeader = "Bearer " + c.Query("token")
+		}
+
 		if authHeader == "" {
 			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
 				"error": "Authorization header is required",
@@ -64,6 +75,15 @@ func AuthMiddleware(cfg *config.Config) fiber.Handler {
 			})
 		}
 
+		// Embed tokens only grant access to embed endpoints
+		for _, audience := range claims.Audience {
+			if audience == EmbedAudience {
+				return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+					"error": "Invalid token",
+				})
+			}
+		}
+
 		// Convert user ID string to ObjectID
 		userID, err := primitive.ObjectIDFromHex(claims.UserID)
 		if err != nil {
@@ -72,19 +92,58 @@ func AuthMiddleware(cfg *config.Config) fiber.Handler {
 			})
 		}
 
-		// Set user ID in context
+		// Check the session hasn't been revoked
+		sessionID, err := primitive.ObjectIDFromHex(claims.ID)
+		if err != nil {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "Invalid session in token",
+			})
+		}
+
+		ctx := c.UserContext()
+		session, err := store.GetActiveSession(ctx, sessionID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to check session: " + err.Error(),
+			})
+		}
+		if session == nil || session.UserID != userID {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "Session has been revoked or expired",
+			})
+		}
+
+		// Demo sessions are read-only apart from running queries
+		if session.Demo && !demoAllows(c) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "This isn't available in the demo, sign up to use it",
+			})
+		}
+
+		if err := store.TouchSession(ctx, session, c.IP()); err != nil {
+			log.Printf("Failed to update session %s: %v", sessionID.Hex(), err)
+		}
+
+		// Set user ID and session ID in context
 		c.Locals("user_id", userID)
+		c.Locals("session_id", sessionID)
 
 		return c.Next()
 	}
 }
 
-// GenerateToken generates a JWT token for a user
-func GenerateToken(userID primitive.ObjectID, cfg *config.Config) (string, error) {
+// IsEventStream reports whether a request asks for a Server-Sent Events stream
+func IsEventStream(c *fiber.Ctx) bool {
+	return strings.Contains(c.Get(fiber.HeaderAccept), "text/event-stream")
+}
+
+// GenerateToken generates a JWT token for a user's session
+func GenerateToken(userID, sessionID primitive.ObjectID, cfg *config.Config) (string, error) {
 	// Create the token claims
 	claims := &TokenClaims{
 		UserID: userID.Hex(),
 		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        sessionID.Hex(),
 			ExpiresAt: jwt.NewNumericDate(time.Now().Add(cfg.JWTExpiry)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 		},
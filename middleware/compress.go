@@ -0,0 +1,81 @@
+package middleware
+
+import (
+	"bytes"
+
+	"github.com/gofiber/contrib/websocket"
+	"github.com/gofiber/fiber/v2"
+	"github.com/valyala/fasthttp"
+)
+
+// compressibleTypes are the content types worth compressing. Images and
+// other binary exports are already compressed.
+var compressibleTypes = [][]byte{
+	[]byte(fiber.MIMEApplicationJSON),
+	[]byte("text/"),
+}
+
+// CompressMiddleware compresses responses of at least minSize bytes with
+// brotli or gzip, whichever the client accepts, preferring brotli. Smaller
+// responses aren't worth the CPU time. A minSize of 0 disables compression.
+func CompressMiddleware(minSize int) fiber.Handler {
+	if minSize <= 0 {
+		return func(c *fiber.Ctx) error {
+			return c.Next()
+		}
+	}
+
+	return func(c *fiber.Ctx) error {
+		// Live dashboard connections aren't HTTP responses
+		if websocket.IsWebSocketUpgrade(c) {
+			return c.Next()
+		}
+
+		// Streamed responses are compressed by whoever streams them
+		c.Locals("compress_streams", true)
+
+		if err := c.Next(); err != nil {
+			return err
+		}
+
+		resp := c.Response()
+		if resp.IsBodyStream() || len(resp.Body()) < minSize || len(resp.Header.ContentEncoding()) > 0 || !compressible(resp.Header.ContentType()) {
+			return nil
+		}
+
+		// The response differs by the encodings the client accepts
+		c.Vary(fiber.HeaderAcceptEncoding)
+
+		request := &c.Context().Request.Header
+		switch {
+		case request.HasAcceptEncoding("br"):
+			resp.SetBodyRaw(fasthttp.AppendBrotliBytesLevel(nil, resp.Body(), fasthttp.CompressBrotliDefaultCompression))
+			resp.Header.SetContentEncoding("br")
+		case request.HasAcceptEncoding("gzip"):
+			resp.SetBodyRaw(fasthttp.AppendGzipBytesLevel(nil, resp.Body(), fasthttp.CompressDefaultCompression))
+			resp.Header.SetContentEncoding("gzip")
+		}
+		return nil
+	}
+}
+
+// compressible reports whether a content type is worth compressing
+func compressible(contentType []byte) bool {
+	for _, prefix := range compressibleTypes {
+		if bytes.HasPrefix(contentType, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// StreamEncoding returns the content encoding a streamed response to the
+// request should use, gzip when the route compresses responses and the client
+// accepts it or empty otherwise. Streams are compressed as they are written,
+// which brotli has no streaming encoder here for.
+func StreamEncoding(c *fiber.Ctx) string {
+	if compress, _ := c.Locals("compress_streams").(bool); compress && c.Context().Request.Header.HasAcceptEncoding("gzip") {
+		return "gzip"
+	}
+	return ""
+}
@@ -0,0 +1,30 @@
+package middleware
+
+import (
+	"regexp"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// demoWriteRoutes are the only non-read requests demo sessions can make:
+// asking questions in natural language, rerunning them and logging out
+var demoWriteRoutes = []*regexp.Regexp{
+	regexp.MustCompile(`^/api/queries/?$`),
+	regexp.MustCompile(`^/api/queries/[0-9a-f]{24}/rerun/?$`),
+	regexp.MustCompile(`^/api/auth/logout/?$`),
+}
+
+// demoAllows reports whether a demo session can make a request
+func demoAllows(c *fiber.Ctx) bool {
+	switch c.Method() {
+	case fiber.MethodGet, fiber.MethodHead, fiber.MethodOptions:
+		return true
+	case fiber.MethodPost:
+		for _, route := range demoWriteRoutes {
+			if route.MatchString(c.Path()) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,15 @@
+//go:build !unix
+
+package middleware
+
+import (
+	"context"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// watchDisconnect can't tell when clients disconnect on this platform, so
+// requests only stop at their deadline
+func watchDisconnect(c *fiber.Ctx, cancel context.CancelFunc) func() {
+	return func() {}
+}
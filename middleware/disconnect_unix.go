@@ -0,0 +1,53 @@
+//go:build unix
+
+package middleware
+
+import (
+	"context"
+	"syscall"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// watchDisconnect calls cancel if the client closes its connection before
+// the request is answered. fasthttp doesn't report this, so the socket is
+// peeked at, leaving any data for fasthttp to read. The returned function
+// stops watching and must be called before the handler returns.
+func watchDisconnect(c *fiber.Ctx, cancel context.CancelFunc) func() {
+	conn := c.Context().Conn()
+	sysConn, ok := conn.(syscall.Conn)
+	if !ok {
+		// TLS connections can't be peeked at without decrypting them
+		return func() {}
+	}
+	raw, err := sysConn.SyscallConn()
+	if err != nil {
+		return func() {}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		buf := make([]byte, 1)
+		raw.Read(func(fd uintptr) bool {
+			n, _, err := syscall.Recvfrom(int(fd), buf, syscall.MSG_PEEK)
+			if err == syscall.EAGAIN || err == syscall.EINTR {
+				return false // Nothing yet, wait until the socket is readable
+			}
+			// End of stream or a reset means the client is gone. Data is
+			// the client's next request, so it is still there.
+			if n == 0 || err != nil {
+				cancel()
+			}
+			return true
+		})
+	}()
+
+	return func() {
+		// Wake the watcher up, then clear the deadline for fasthttp's next read
+		conn.SetReadDeadline(time.Now())
+		<-done
+		conn.SetReadDeadline(time.Time{})
+	}
+}
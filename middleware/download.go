@@ -0,0 +1,70 @@
+package middleware
+
+import (
+	"errors"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/zucced/goquery/config"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// DownloadAudience is the audience of download tokens. Tokens with this
+// audience are only accepted by the download handler and never as sessions.
+const DownloadAudience = "goquery-download"
+
+// DownloadClaims contains the claims of a download token. The jti claim holds
+// the ID of the download link, which makes the token single-use.
+type DownloadClaims struct {
+	UserID string `json:"user_id"`
+	jwt.RegisteredClaims
+}
+
+// GenerateDownloadToken generates the token of a download link's URL
+func GenerateDownloadToken(userID, linkID primitive.ObjectID, expiresAt time.Time, cfg *config.Config) (string, error) {
+	// Create the token claims
+	claims := &DownloadClaims{
+		UserID: userID.Hex(),
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        linkID.Hex(),
+			Audience:  jwt.ClaimStrings{DownloadAudience},
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+		},
+	}
+
+	// Create the token
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+
+	// Generate encoded token
+	return token.SignedString([]byte(cfg.JWTSecret))
+}
+
+// ParseDownloadToken verifies a download token and returns the IDs of its
+// user and download link
+func ParseDownloadToken(tokenString string, cfg *config.Config) (primitive.ObjectID, primitive.ObjectID, error) {
+	// Parse the token
+	token, err := jwt.ParseWithClaims(tokenString, &DownloadClaims{}, func(token *jwt.Token) (any, error) {
+		return []byte(cfg.JWTSecret), nil
+	}, jwt.WithAudience(DownloadAudience), jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	if err != nil || !token.Valid {
+		return primitive.NilObjectID, primitive.NilObjectID, errors.New("invalid or expired download token")
+	}
+
+	// Extract claims
+	claims, ok := token.Claims.(*DownloadClaims)
+	if !ok {
+		return primitive.NilObjectID, primitive.NilObjectID, errors.New("invalid download token claims")
+	}
+
+	userID, err := primitive.ObjectIDFromHex(claims.UserID)
+	if err != nil {
+		return primitive.NilObjectID, primitive.NilObjectID, errors.New("invalid user ID in download token")
+	}
+	linkID, err := primitive.ObjectIDFromHex(claims.ID)
+	if err != nil {
+		return primitive.NilObjectID, primitive.NilObjectID, errors.New("invalid link ID in download token")
+	}
+
+	return userID, linkID, nil
+}
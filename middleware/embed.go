@@ -0,0 +1,128 @@
+package middleware
+
+import (
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/zucced/goquery/config"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// EmbedAudience is the audience of embed tokens. Tokens with this audience are
+// only accepted by EmbedMiddleware and never as user sessions.
+const EmbedAudience = "goquery-embed"
+
+// EmbedClaims contains the claims of an embed token. A token without a card ID
+// grants access to the whole dashboard.
+type EmbedClaims struct {
+	UserID      string `json:"user_id"`
+	DashboardID string `json:"dashboard_id"`
+	CardID      string `json:"card_id,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// EmbedScope is the resource an embed token grants read access to
+type EmbedScope struct {
+	UserID      primitive.ObjectID
+	DashboardID primitive.ObjectID
+	CardID      primitive.ObjectID // Zero when the whole dashboard is embedded
+}
+
+// AllowsCard reports whether the scope grants access to a card
+func (s *EmbedScope) AllowsCard(cardID primitive.ObjectID) bool {
+	return s.CardID.IsZero() || s.CardID == cardID
+}
+
+// EmbedMiddleware checks for a valid embed token in the token query parameter
+// or the Authorization header and stores its scope in the context
+func EmbedMiddleware(cfg *config.Config) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Embeds in iframes can't set headers, so accept the token as a query parameter
+		tokenString := c.Query("token")
+		if tokenString == "" {
+			tokenString = strings.TrimPrefix(c.Get("Authorization"), "Bearer ")
+		}
+		if tokenString == "" {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "Embed token is required",
+			})
+		}
+
+		// Parse the token
+		token, err := jwt.ParseWithClaims(tokenString, &EmbedClaims{}, func(token *jwt.Token) (any, error) {
+			return []byte(cfg.JWTSecret), nil
+		}, jwt.WithAudience(EmbedAudience), jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+
+		if err != nil || !token.Valid {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "Invalid or expired embed token",
+			})
+		}
+
+		// Extract claims
+		claims, ok := token.Claims.(*EmbedClaims)
+		if !ok {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "Invalid embed token claims",
+			})
+		}
+
+		scope := &EmbedScope{}
+		if scope.UserID, err = primitive.ObjectIDFromHex(claims.UserID); err != nil {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "Invalid user ID in embed token",
+			})
+		}
+		if scope.DashboardID, err = primitive.ObjectIDFromHex(claims.DashboardID); err != nil {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "Invalid dashboard ID in embed token",
+			})
+		}
+		if claims.CardID != "" {
+			if scope.CardID, err = primitive.ObjectIDFromHex(claims.CardID); err != nil {
+				return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+					"error": "Invalid card ID in embed token",
+				})
+			}
+		}
+
+		// Set embed scope in context
+		c.Locals("embed_scope", scope)
+
+		return c.Next()
+	}
+}
+
+// GenerateEmbedToken generates an embed token for a dashboard, or for a single
+// card when cardID is not zero
+func GenerateEmbedToken(userID, dashboardID, cardID primitive.ObjectID, expiry time.Duration, cfg *config.Config) (string, time.Time, error) {
+	now := time.Now()
+	expiresAt := now.Add(expiry)
+
+	// Create the token claims
+	claims := &EmbedClaims{
+		UserID:      userID.Hex(),
+		DashboardID: dashboardID.Hex(),
+		RegisteredClaims: jwt.RegisteredClaims{
+			Audience:  jwt.ClaimStrings{EmbedAudience},
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+			IssuedAt:  jwt.NewNumericDate(now),
+		},
+	}
+	if !cardID.IsZero() {
+		claims.CardID = cardID.Hex()
+	}
+
+	// Create the token
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+
+	// Generate encoded token
+	tokenString, err := token.SignedString([]byte(cfg.JWTSecret))
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	return tokenString, expiresAt, nil
+}
@@ -0,0 +1,48 @@
+package middleware
+
+import (
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/zucced/goquery/limiter"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// RateLimitMiddleware limits how many requests each user can make in the
+// limiter's window and reports the remaining allowance in X-RateLimit headers.
+// Requests without a user are keyed by IP. Must run after AuthMiddleware.
+func RateLimitMiddleware(rl *limiter.RateLimiter) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if rl.Limit() == 0 {
+			return c.Next()
+		}
+
+		// Key by user so clients sharing an IP don't share a limit
+		key := "ip:" + c.IP()
+		if userID, ok := c.Locals("user_id").(primitive.ObjectID); ok {
+			key = "user:" + userID.Hex()
+		}
+
+		allowed, remaining, reset := rl.Take(key)
+
+		c.Set("X-RateLimit-Limit", strconv.Itoa(rl.Limit()))
+		c.Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+		c.Set("X-RateLimit-Reset", strconv.FormatInt(reset.Unix(), 10))
+
+		if !allowed {
+			retryAfter := int(math.Ceil(time.Until(reset).Seconds()))
+			if retryAfter < 1 {
+				retryAfter = 1
+			}
+			c.Set(fiber.HeaderRetryAfter, strconv.Itoa(retryAfter))
+
+			return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+				"error": "Too many requests, please try again later",
+			})
+		}
+
+		return c.Next()
+	}
+}
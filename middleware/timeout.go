@@ -0,0 +1,69 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/gofiber/contrib/websocket"
+	"github.com/gofiber/fiber/v2"
+)
+
+// requestContextKey is the Locals key of the request's context before a
+// deadline is applied, which RouteTimeoutMiddleware derives its own from
+const requestContextKey = "request_context"
+
+// TimeoutMiddleware gives every request a context, c.UserContext(), that is
+// cancelled when the client disconnects or timeout passes. Handlers pass it
+// to the store, connection and AI calls they make so work stops once nobody
+// is waiting for it. Requests that fail after their deadline respond with
+// 504 Gateway Timeout.
+//
+// The context isn't derived from c.Context(): fasthttp cancels that as soon
+// as the server starts shutting down, while in-flight requests are meant to
+// finish.
+func TimeoutMiddleware(timeout time.Duration) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		// Gateway connections outlive the handler, which only upgrades them
+		if !websocket.IsWebSocketUpgrade(c) {
+			defer watchDisconnect(c, cancel)()
+		}
+		c.Locals(requestContextKey, ctx)
+
+		err := withDeadline(c, ctx, timeout)
+
+		// A route may have replaced the deadline, so check the context the
+		// handler ended up with
+		if errors.Is(c.UserContext().Err(), context.DeadlineExceeded) && (err != nil || c.Response().StatusCode() >= fiber.StatusInternalServerError) {
+			return c.Status(fiber.StatusGatewayTimeout).JSON(fiber.Map{
+				"error": "Request timed out",
+			})
+		}
+		return err
+	}
+}
+
+// RouteTimeoutMiddleware replaces the deadline TimeoutMiddleware gave a
+// request, for routes that need longer, such as those running queries
+func RouteTimeoutMiddleware(timeout time.Duration) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		ctx, ok := c.Locals(requestContextKey).(context.Context)
+		if !ok {
+			ctx = context.Background()
+		}
+		return withDeadline(c, ctx, timeout)
+	}
+}
+
+// withDeadline runs the rest of the chain with a user context that expires
+// after timeout
+func withDeadline(c *fiber.Ctx, parent context.Context, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(parent, timeout)
+	defer cancel()
+
+	c.SetUserContext(ctx)
+	return c.Next()
+}
@@ -0,0 +1,70 @@
+package middleware
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/zucced/goquery/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// WorkspaceIDHeader selects the workspace a request works in
+const WorkspaceIDHeader = "X-Workspace-ID"
+
+// WorkspaceMiddleware resolves the workspace selected with the X-Workspace-ID
+// header and stores it in the context. Requests without the header are not
+// scoped to a workspace. Must run after AuthMiddleware.
+func WorkspaceMiddleware(store models.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		header := c.Get(WorkspaceIDHeader)
+		if header == "" {
+			return c.Next()
+		}
+
+		workspaceID, err := primitive.ObjectIDFromHex(header)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid workspace ID",
+			})
+		}
+
+		// Get user ID from context
+		userID := c.Locals("user_id").(primitive.ObjectID)
+
+		// Get the request context
+		ctx := c.UserContext()
+
+		// Get workspace
+		workspace, err := store.GetWorkspaceByID(ctx, workspaceID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve workspace: " + err.Error(),
+			})
+		}
+
+		// Only members of the workspace's organization can work in it
+		member := false
+		if workspace != nil {
+			if member, err = store.IsOrganizationMember(ctx, workspace.OrgID, userID); err != nil {
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+					"error": "Failed to check organization membership: " + err.Error(),
+				})
+			}
+		}
+		if !member {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Workspace not found",
+			})
+		}
+
+		// Set workspace in context
+		c.Locals("workspace", workspace)
+
+		return c.Next()
+	}
+}
+
+// CurrentWorkspace returns the workspace selected for the request, or nil when
+// the request isn't scoped to a workspace
+func CurrentWorkspace(c *fiber.Ctx) *models.Workspace {
+	workspace, _ := c.Locals("workspace").(*models.Workspace)
+	return workspace
+}
@@ -0,0 +1,354 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// AlertState is whether an alert's rule currently holds
+type AlertState string
+
+const (
+	AlertStateUnknown   AlertState = "unknown" // Not evaluated yet
+	AlertStateOK        AlertState = "ok"
+	AlertStateTriggered AlertState = "triggered"
+)
+
+// Kinds of alert rules
+const (
+	AlertRuleValue    = "value"     // The value of a column in the first row
+	AlertRuleRowCount = "row_count" // The number of rows
+	AlertRuleAnomaly  = "anomaly"   // The value of a column in the first row, compared to its learned baseline
+)
+
+// Channels alerts notify through
+const (
+	AlertChannelEmail   = "email"   // The alert's recipients
+	AlertChannelWebhook = "webhook" // The owner's webhooks subscribed to alert events
+)
+
+// AlertRule compares a value read from a query's results to a threshold, e.g.
+// the value of column total in the first row > 100, or row count == 0.
+// Anomaly rules instead compare the value to the baseline learned from its
+// previous values.
+type AlertRule struct {
+	Kind        string  `json:"kind" bson:"kind"`
+	Column      string  `json:"column,omitempty" bson:"column,omitempty"`     // Read by value and anomaly rules
+	Operator    string  `json:"operator,omitempty" bson:"operator,omitempty"` // gt, gte, lt, lte, eq or ne
+	Threshold   float64 `json:"threshold" bson:"threshold"`
+	Sensitivity float64 `json:"sensitivity,omitempty" bson:"sensitivity,omitempty"` // Standard deviations from the baseline anomaly rules flag
+}
+
+// Alert watches the results of a saved query. It is evaluated after every run
+// of the query and notifies its channels when it triggers or resolves.
+type Alert struct {
+	ID                 primitive.ObjectID   `json:"id" bson:"_id,omitempty"`
+	UserID             primitive.ObjectID   `json:"user_id" bson:"user_id"`
+	QueryID            primitive.ObjectID   `json:"query_id" bson:"query_id"`
+	Name               string               `json:"name" bson:"name"`
+	Rule               AlertRule            `json:"rule" bson:"rule"`
+	Channels           []string             `json:"channels" bson:"channels"`
+	Recipients         []string             `json:"recipients,omitempty" bson:"recipients,omitempty"`   // Emailed when the email channel is on
+	ChannelIDs         []primitive.ObjectID `json:"channel_ids,omitempty" bson:"channel_ids,omitempty"` // Notification channels also notified
+	Enabled            bool                 `json:"enabled" bson:"enabled"`
+	SnoozedUntil       *time.Time           `json:"snoozed_until,omitempty" bson:"snoozed_until,omitempty"`
+	MaintenanceWindows []MaintenanceWindow  `json:"maintenance_windows,omitempty" bson:"maintenance_windows,omitempty"`
+	Silenced           string               `json:"silenced,omitempty" bson:"-"` // snoozed or maintenance while the alert doesn't notify, set for responses
+	State              AlertState           `json:"state" bson:"state"`
+	NotifiedState      AlertState           `json:"-" bson:"notified_state,omitempty"` // Last state the channels were told about
+	LastValue          *float64             `json:"last_value,omitempty" bson:"last_value,omitempty"`
+	LastError          string               `json:"last_error,omitempty" bson:"last_error,omitempty"` // Why the latest run couldn't be evaluated
+	LastEvaluatedAt    *time.Time           `json:"last_evaluated_at,omitempty" bson:"last_evaluated_at,omitempty"`
+	LastTriggeredAt    *time.Time           `json:"last_triggered_at,omitempty" bson:"last_triggered_at,omitempty"`
+	AcknowledgedAt     *time.Time           `json:"acknowledged_at,omitempty" bson:"acknowledged_at,omitempty"` // Cleared when the alert's state changes
+	AcknowledgedBy     *primitive.ObjectID  `json:"acknowledged_by,omitempty" bson:"acknowledged_by,omitempty"`
+	Baseline           *AnomalyBaseline     `json:"baseline,omitempty" bson:"baseline,omitempty"` // Learned by anomaly rules
+	CreatedAt          time.Time            `json:"created_at" bson:"created_at"`
+	UpdatedAt          time.Time            `json:"updated_at" bson:"updated_at"`
+}
+
+// Notifies reports whether the alert notifies through a channel
+func (a *Alert) Notifies(channel string) bool {
+	for _, c := range a.Channels {
+		if c == channel {
+			return true
+		}
+	}
+	return false
+}
+
+// Evaluate reads the rule's value from query results and reports whether the
+// rule holds. Anomaly rules also learn the value into the alert's baseline
+// and return the sample to record. An error means the value couldn't be
+// read, e.g. when the column is missing or isn't numeric.
+func (a *Alert) Evaluate(results []QueryResult) (float64, bool, *AlertSample, error) {
+	value, err := a.Rule.value(results)
+	if err != nil {
+		return 0, false, nil, err
+	}
+
+	if a.Rule.Kind == AlertRuleAnomaly {
+		sample := a.observe(value)
+		return value, sample.Anomalous, sample, nil
+	}
+
+	holds, err := a.Rule.compare(value)
+	return value, holds, nil, err
+}
+
+// value reads the value the rule looks at from query results
+func (r *AlertRule) value(results []QueryResult) (float64, error) {
+	switch r.Kind {
+	case AlertRuleRowCount:
+		return float64(len(results)), nil
+	case AlertRuleValue, AlertRuleAnomaly:
+		if len(results) == 0 {
+			return 0, errors.New("the query returned no rows")
+		}
+		raw, ok := results[0][r.Column]
+		if !ok {
+			return 0, fmt.Errorf("column %s isn't in the results", r.Column)
+		}
+		value, ok := toFloat(raw)
+		if !ok {
+			return 0, fmt.Errorf("column %s isn't numeric", r.Column)
+		}
+		return value, nil
+	default:
+		return 0, fmt.Errorf("unknown rule kind %s", r.Kind)
+	}
+}
+
+// compare reports whether a value is on the triggering side of the threshold
+func (r *AlertRule) compare(value float64) (bool, error) {
+	switch r.Operator {
+	case "gt":
+		return value > r.Threshold, nil
+	case "gte":
+		return value >= r.Threshold, nil
+	case "lt":
+		return value < r.Threshold, nil
+	case "lte":
+		return value <= r.Threshold, nil
+	case "eq":
+		return value == r.Threshold, nil
+	case "ne":
+		return value != r.Threshold, nil
+	default:
+		return false, fmt.Errorf("unknown operator %s", r.Operator)
+	}
+}
+
+// String describes the rule, e.g. "row count == 0"
+func (r *AlertRule) String() string {
+	if r.Kind == AlertRuleAnomaly {
+		return fmt.Sprintf("%s deviates more than %g standard deviations from its baseline", r.Column, r.sensitivity())
+	}
+
+	subject := "row count"
+	if r.Kind == AlertRuleValue {
+		subject = r.Column
+	}
+	operators := map[string]string{"gt": ">", "gte": ">=", "lt": "<", "lte": "<=", "eq": "==", "ne": "!="}
+	return fmt.Sprintf("%s %s %g", subject, operators[r.Operator], r.Threshold)
+}
+
+// alertCollection returns the alerts collection
+func (s *mongoStore) alertCollection() *mongo.Collection {
+	return s.db.Collection("alerts")
+}
+
+// ensureAlertIndexes creates the indexes alerts are listed and evaluated by
+func (s *mongoStore) ensureAlertIndexes(ctx context.Context) error {
+	_, err := s.alertCollection().Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}},
+			Options: options.Index().SetName("alert_user"),
+		},
+		{
+			Keys:    bson.D{{Key: "query_id", Value: 1}, {Key: "enabled", Value: 1}},
+			Options: options.Index().SetName("alert_query"),
+		},
+	})
+	return err
+}
+
+// CreateAlert creates a new alert
+func (s *mongoStore) CreateAlert(ctx context.Context, alert *Alert) (*Alert, error) {
+	// Set timestamps and initial state
+	now := time.Now()
+	alert.CreatedAt = now
+	alert.UpdatedAt = now
+	alert.State = AlertStateUnknown
+	alert.NotifiedState = AlertStateUnknown
+
+	result, err := s.alertCollection().InsertOne(ctx, alert)
+	if err != nil {
+		return nil, err
+	}
+
+	// Set the ID
+	alert.ID = result.InsertedID.(primitive.ObjectID)
+
+	return alert, nil
+}
+
+// GetAlertByID retrieves an alert by ID
+func (s *mongoStore) GetAlertByID(ctx context.Context, id primitive.ObjectID) (*Alert, error) {
+	var alert Alert
+	err := s.alertCollection().FindOne(ctx, bson.M{"_id": id}).Decode(&alert)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &alert, nil
+}
+
+// GetAlertsByUserID retrieves a user's alerts, optionally only those on a
+// query, newest first
+func (s *mongoStore) GetAlertsByUserID(ctx context.Context, userID, queryID primitive.ObjectID) ([]*Alert, error) {
+	filter := bson.M{"user_id": userID}
+	if !queryID.IsZero() {
+		filter["query_id"] = queryID
+	}
+
+	opts := options.Find().SetSort(bson.M{"created_at": -1})
+	cursor, err := s.alertCollection().Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	alerts := []*Alert{}
+	if err := cursor.All(ctx, &alerts); err != nil {
+		return nil, err
+	}
+
+	return alerts, nil
+}
+
+// GetEnabledAlertsByQueryID retrieves the enabled alerts on a query
+func (s *mongoStore) GetEnabledAlertsByQueryID(ctx context.Context, queryID primitive.ObjectID) ([]*Alert, error) {
+	cursor, err := s.alertCollection().Find(ctx, bson.M{"query_id": queryID, "enabled": true})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var alerts []*Alert
+	if err := cursor.All(ctx, &alerts); err != nil {
+		return nil, err
+	}
+
+	return alerts, nil
+}
+
+// UpdateAlert updates an alert's settings. Changing the rule starts it over
+// from the unknown state, without the baseline, samples or acknowledgement of
+// the previous rule.
+func (s *mongoStore) UpdateAlert(ctx context.Context, alert *Alert, ruleChanged bool) error {
+	alert.UpdatedAt = time.Now()
+
+	set := bson.M{
+		"name":                alert.Name,
+		"rule":                alert.Rule,
+		"channels":            alert.Channels,
+		"recipients":          alert.Recipients,
+		"channel_ids":         alert.ChannelIDs,
+		"enabled":             alert.Enabled,
+		"maintenance_windows": alert.MaintenanceWindows,
+		"updated_at":          alert.UpdatedAt,
+	}
+	update := bson.M{"$set": set}
+	if ruleChanged {
+		alert.State = AlertStateUnknown
+		alert.NotifiedState = AlertStateUnknown
+		alert.LastValue = nil
+		alert.LastError = ""
+		alert.Baseline = nil
+		alert.AcknowledgedAt = nil
+		alert.AcknowledgedBy = nil
+		set["state"] = alert.State
+		set["notified_state"] = alert.NotifiedState
+		update["$unset"] = bson.M{"last_value": "", "last_error": "", "baseline": "", "acknowledged_at": "", "acknowledged_by": ""}
+	}
+
+	if _, err := s.alertCollection().UpdateOne(ctx, bson.M{"_id": alert.ID}, update); err != nil {
+		return err
+	}
+
+	if ruleChanged {
+		return s.deleteAlertSamples(ctx, alert.ID)
+	}
+	return nil
+}
+
+// SaveAlertEvaluation records the outcome of evaluating an alert that was in
+// the previous state, with its channels last told about the notified state.
+// It returns false when another evaluation changed either first, so a
+// transition or notification is only acted on once. A change of state clears
+// the acknowledgement of the previous incident.
+func (s *mongoStore) SaveAlertEvaluation(ctx context.Context, alert *Alert, previous, notified AlertState) (bool, error) {
+	set := bson.M{
+		"state":             alert.State,
+		"notified_state":    alert.NotifiedState,
+		"last_error":        alert.LastError,
+		"last_evaluated_at": alert.LastEvaluatedAt,
+	}
+	if alert.LastValue != nil {
+		set["last_value"] = alert.LastValue
+	}
+	if alert.LastTriggeredAt != nil {
+		set["last_triggered_at"] = alert.LastTriggeredAt
+	}
+	if alert.Baseline != nil {
+		set["baseline"] = alert.Baseline
+	}
+	update := bson.M{"$set": set}
+	if alert.State != previous {
+		alert.AcknowledgedAt = nil
+		alert.AcknowledgedBy = nil
+		update["$unset"] = bson.M{"acknowledged_at": "", "acknowledged_by": ""}
+	}
+
+	result, err := s.alertCollection().UpdateOne(
+		ctx,
+		bson.M{"_id": alert.ID, "state": previous, "notified_state": notifiedFilter(notified)},
+		update,
+	)
+	if err != nil {
+		return false, err
+	}
+
+	return result.MatchedCount > 0, nil
+}
+
+// notifiedFilter matches the notified state an alert was loaded with. Alerts
+// saved before it was recorded don't have one.
+func notifiedFilter(notified AlertState) interface{} {
+	if notified == "" {
+		return bson.M{"$exists": false}
+	}
+	return notified
+}
+
+// DeleteAlert deletes an alert with its samples and events
+func (s *mongoStore) DeleteAlert(ctx context.Context, id primitive.ObjectID) error {
+	if _, err := s.alertCollection().DeleteOne(ctx, bson.M{"_id": id}); err != nil {
+		return err
+	}
+
+	if err := s.deleteAlertSamples(ctx, id); err != nil {
+		return err
+	}
+	return s.deleteAlertEvents(ctx, id)
+}
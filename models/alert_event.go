@@ -0,0 +1,160 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// alertEventRetention is how long alert events are kept
+const alertEventRetention = 180 * 24 * time.Hour
+
+// Types of alert events
+const (
+	AlertEventTriggered    = "triggered"    // The rule started holding
+	AlertEventResolved     = "resolved"     // The rule stopped holding, or a user resolved the alert
+	AlertEventAcknowledged = "acknowledged" // A user took ownership of the triggered alert
+)
+
+// AlertEvent records a change in an alert's incident: a trigger or resolve
+// found by an evaluation, or an action a user took. Events caused by a user
+// carry who it was.
+type AlertEvent struct {
+	ID         primitive.ObjectID  `json:"id" bson:"_id,omitempty"`
+	AlertID    primitive.ObjectID  `json:"alert_id" bson:"alert_id"`
+	Type       string              `json:"type" bson:"type"`
+	Value      *float64            `json:"value,omitempty" bson:"value,omitempty"`             // The evaluated value, for events found by an evaluation
+	ActorID    *primitive.ObjectID `json:"actor_id,omitempty" bson:"actor_id,omitempty"`       // The user who acted, empty for evaluations
+	ActorEmail string              `json:"actor_email,omitempty" bson:"actor_email,omitempty"` // Kept so the history outlives the user
+	Note       string              `json:"note,omitempty" bson:"note,omitempty"`
+	CreatedAt  time.Time           `json:"created_at" bson:"created_at"`
+}
+
+// alertEventCollection returns the alert events collection
+func (s *mongoStore) alertEventCollection() *mongo.Collection {
+	return s.db.Collection("alert_events")
+}
+
+// ensureAlertEventIndexes creates the indexes events are listed and expired by
+func (s *mongoStore) ensureAlertEventIndexes(ctx context.Context) error {
+	_, err := s.alertEventCollection().Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "created_at", Value: 1}},
+			Options: options.Index().SetName("alert_event_expiry").SetExpireAfterSeconds(int32(alertEventRetention.Seconds())),
+		},
+		{
+			Keys:    bson.D{{Key: "alert_id", Value: 1}, {Key: "created_at", Value: -1}},
+			Options: options.Index().SetName("alert_event_alert"),
+		},
+	})
+	return err
+}
+
+// CreateAlertEvent records an alert event
+func (s *mongoStore) CreateAlertEvent(ctx context.Context, event *AlertEvent) error {
+	if event.CreatedAt.IsZero() {
+		event.CreatedAt = time.Now()
+	}
+
+	result, err := s.alertEventCollection().InsertOne(ctx, event)
+	if err != nil {
+		return err
+	}
+
+	// Set the ID
+	event.ID = result.InsertedID.(primitive.ObjectID)
+
+	return nil
+}
+
+// GetAlertEvents retrieves the events of an alert, newest first, with
+// pagination
+func (s *mongoStore) GetAlertEvents(ctx context.Context, alertID primitive.ObjectID, page, limit int64) ([]*AlertEvent, int64, error) {
+	filter := bson.M{"alert_id": alertID}
+
+	// Count total documents for pagination
+	totalCount, err := s.alertEventCollection().CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}).
+		SetSkip((page - 1) * limit).
+		SetLimit(limit)
+
+	cursor, err := s.alertEventCollection().Find(ctx, filter, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cursor.Close(ctx)
+
+	events := []*AlertEvent{}
+	if err := cursor.All(ctx, &events); err != nil {
+		return nil, 0, err
+	}
+
+	return events, totalCount, nil
+}
+
+// AcknowledgeAlert marks a triggered alert as acknowledged by a user. It
+// returns false when the alert isn't triggered or was already acknowledged.
+func (s *mongoStore) AcknowledgeAlert(ctx context.Context, alert *Alert, userID primitive.ObjectID) (bool, error) {
+	now := time.Now()
+	result, err := s.alertCollection().UpdateOne(
+		ctx,
+		bson.M{
+			"_id":             alert.ID,
+			"state":           AlertStateTriggered,
+			"acknowledged_at": bson.M{"$exists": false},
+		},
+		bson.M{"$set": bson.M{"acknowledged_at": now, "acknowledged_by": userID}},
+	)
+	if err != nil {
+		return false, err
+	}
+	if result.MatchedCount == 0 {
+		return false, nil
+	}
+
+	alert.AcknowledgedAt = &now
+	alert.AcknowledgedBy = &userID
+	return true, nil
+}
+
+// ResolveAlert moves a triggered alert back to ok by hand, e.g. once the
+// cause is fixed and the next run is a while off. It returns false when the
+// alert isn't triggered. An evaluation that still finds the rule holding
+// triggers it again. Nobody is notified, so it counts as told.
+func (s *mongoStore) ResolveAlert(ctx context.Context, alert *Alert) (bool, error) {
+	result, err := s.alertCollection().UpdateOne(
+		ctx,
+		bson.M{"_id": alert.ID, "state": AlertStateTriggered},
+		bson.M{
+			"$set":   bson.M{"state": AlertStateOK, "notified_state": AlertStateOK},
+			"$unset": bson.M{"acknowledged_at": "", "acknowledged_by": ""},
+		},
+	)
+	if err != nil {
+		return false, err
+	}
+	if result.MatchedCount == 0 {
+		return false, nil
+	}
+
+	alert.State = AlertStateOK
+	alert.NotifiedState = AlertStateOK
+	alert.AcknowledgedAt = nil
+	alert.AcknowledgedBy = nil
+	return true, nil
+}
+
+// deleteAlertEvents deletes the events of an alert
+func (s *mongoStore) deleteAlertEvents(ctx context.Context, alertID primitive.ObjectID) error {
+	_, err := s.alertEventCollection().DeleteMany(ctx, bson.M{"alert_id": alertID})
+	return err
+}
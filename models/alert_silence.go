@@ -0,0 +1,73 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Why an alert isn't notifying right now
+const (
+	AlertSilenceSnoozed     = "snoozed"
+	AlertSilenceMaintenance = "maintenance"
+)
+
+// MaintenanceWindow is a recurring period of known downtime, e.g. nightly
+// backups, during which an alert doesn't notify
+type MaintenanceWindow struct {
+	Cron     string `json:"cron" bson:"cron"`         // Standard five-field cron expression of when each window starts
+	Duration int    `json:"duration" bson:"duration"` // Minutes each window lasts
+	Timezone string `json:"timezone" bson:"timezone"`
+}
+
+// Active reports whether a window is open at now, i.e. whether one started
+// less than its duration ago
+func (w MaintenanceWindow) Active(now time.Time) bool {
+	start, err := NextReportRun(w.Cron, w.Timezone, now.Add(-time.Duration(w.Duration)*time.Minute))
+	return err == nil && !start.After(now)
+}
+
+// Silence returns why the alert doesn't notify at now, snoozed or in a
+// maintenance window, or "" when it notifies as usual
+func (a *Alert) Silence(now time.Time) string {
+	if a.SnoozedUntil != nil && now.Before(*a.SnoozedUntil) {
+		return AlertSilenceSnoozed
+	}
+	for _, window := range a.MaintenanceWindows {
+		if window.Active(now) {
+			return AlertSilenceMaintenance
+		}
+	}
+	return ""
+}
+
+// PendingNotification reports whether the alert's channels haven't been told
+// about its state yet: a trigger, or the resolve of a trigger they were told
+// about. Transitions while the alert is silenced leave it pending, so an
+// alert still triggered when its snooze or maintenance window ends notifies
+// on its next evaluation.
+func (a *Alert) PendingNotification() bool {
+	if a.State == a.NotifiedState || a.State == AlertStateUnknown {
+		return false
+	}
+	return a.State == AlertStateTriggered || a.NotifiedState == AlertStateTriggered
+}
+
+// SnoozeAlert keeps an alert from notifying until a time, or lifts its snooze
+// when until is nil
+func (s *mongoStore) SnoozeAlert(ctx context.Context, id primitive.ObjectID, until *time.Time) error {
+	update := bson.M{
+		"$set": bson.M{"snoozed_until": until, "updated_at": time.Now()},
+	}
+	if until == nil {
+		update = bson.M{
+			"$set":   bson.M{"updated_at": time.Now()},
+			"$unset": bson.M{"snoozed_until": ""},
+		}
+	}
+
+	_, err := s.alertCollection().UpdateOne(ctx, bson.M{"_id": id}, update)
+	return err
+}
@@ -0,0 +1,29 @@
+package models
+
+import "testing"
+
+func TestAlertPendingNotification(t *testing.T) {
+	tests := []struct {
+		name     string
+		state    AlertState
+		notified AlertState
+		want     bool
+	}{
+		{"first evaluation ok", AlertStateOK, AlertStateUnknown, false},
+		{"first evaluation triggered", AlertStateTriggered, AlertStateUnknown, true},
+		{"triggered", AlertStateTriggered, AlertStateOK, true},
+		{"still triggered", AlertStateTriggered, AlertStateTriggered, false},
+		{"resolved", AlertStateOK, AlertStateTriggered, true},
+		{"resolved without being told of the trigger", AlertStateOK, AlertStateOK, false},
+		{"not evaluated", AlertStateUnknown, AlertStateTriggered, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			alert := &Alert{State: tt.state, NotifiedState: tt.notified}
+			if got := alert.PendingNotification(); got != tt.want {
+				t.Errorf("PendingNotification() with state %s and notified %s = %v, want %v", tt.state, tt.notified, got, tt.want)
+			}
+		})
+	}
+}
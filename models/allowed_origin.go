@@ -0,0 +1,66 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// AllowedOrigin is an origin browsers may call the API from, added at runtime
+// on top of the configured ones
+type AllowedOrigin struct {
+	Origin    string             `json:"origin" bson:"_id"`
+	Note      string             `json:"note,omitempty" bson:"note,omitempty"` // Who or what the origin is for
+	CreatedBy primitive.ObjectID `json:"created_by" bson:"created_by"`
+	CreatedAt time.Time          `json:"created_at" bson:"created_at"`
+}
+
+// allowedOriginCollection returns the allowed origins collection
+func (s *mongoStore) allowedOriginCollection() *mongo.Collection {
+	return s.db.Collection("allowed_origins")
+}
+
+// GetAllowedOrigins retrieves every stored allowed origin
+func (s *mongoStore) GetAllowedOrigins(ctx context.Context) ([]*AllowedOrigin, error) {
+	cursor, err := s.allowedOriginCollection().Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	origins := []*AllowedOrigin{}
+	if err := cursor.All(ctx, &origins); err != nil {
+		return nil, err
+	}
+
+	return origins, nil
+}
+
+// SaveAllowedOrigin stores an allowed origin, replacing its previous note
+func (s *mongoStore) SaveAllowedOrigin(ctx context.Context, origin *AllowedOrigin) error {
+	if origin.CreatedAt.IsZero() {
+		origin.CreatedAt = time.Now()
+	}
+
+	_, err := s.allowedOriginCollection().ReplaceOne(
+		ctx,
+		bson.M{"_id": origin.Origin},
+		origin,
+		options.Replace().SetUpsert(true),
+	)
+	return err
+}
+
+// DeleteAllowedOrigin removes a stored allowed origin, reporting whether it
+// existed
+func (s *mongoStore) DeleteAllowedOrigin(ctx context.Context, origin string) (bool, error) {
+	result, err := s.allowedOriginCollection().DeleteOne(ctx, bson.M{"_id": origin})
+	if err != nil {
+		return false, err
+	}
+	return result.DeletedCount > 0, nil
+}
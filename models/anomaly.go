@@ -0,0 +1,169 @@
+package models
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// DefaultAnomalySensitivity is the number of standard deviations from the
+// baseline anomaly rules flag unless they set their own
+const DefaultAnomalySensitivity = 3
+
+// AnomalyMinSamples is the number of values an anomaly rule learns before it
+// flags anything, so a handful of runs don't make a baseline
+const AnomalyMinSamples = 10
+
+// anomalyAlpha is the weight of each new value in the baseline. Higher values
+// follow recent changes faster and forget older values sooner.
+const anomalyAlpha = 0.2
+
+// alertSampleRetention is how long anomaly rule samples are kept
+const alertSampleRetention = 90 * 24 * time.Hour
+
+// AnomalyBaseline is the exponentially weighted mean and variance of the
+// values an anomaly rule has seen
+type AnomalyBaseline struct {
+	Mean     float64 `json:"mean" bson:"mean"`
+	Variance float64 `json:"variance" bson:"variance"`
+	Samples  int     `json:"samples" bson:"samples"`
+}
+
+// StdDev returns the baseline's standard deviation
+func (b *AnomalyBaseline) StdDev() float64 {
+	return math.Sqrt(b.Variance)
+}
+
+// learn folds a value into the baseline
+func (b *AnomalyBaseline) learn(value float64) {
+	if b.Samples == 0 {
+		b.Mean = value
+		b.Variance = 0
+		b.Samples = 1
+		return
+	}
+
+	diff := value - b.Mean
+	increment := anomalyAlpha * diff
+	b.Mean += increment
+	b.Variance = (1 - anomalyAlpha) * (b.Variance + diff*increment)
+	b.Samples++
+}
+
+// AlertSample records a value an anomaly rule saw and how it compared to the
+// baseline learned before it
+type AlertSample struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	AlertID   primitive.ObjectID `json:"alert_id" bson:"alert_id"`
+	Value     float64            `json:"value" bson:"value"`
+	Mean      float64            `json:"mean" bson:"mean"`
+	StdDev    float64            `json:"std_dev" bson:"std_dev"`
+	ZScore    *float64           `json:"z_score,omitempty" bson:"z_score,omitempty"` // Left out while learning, or when the baseline has no spread
+	Anomalous bool               `json:"anomalous" bson:"anomalous"`
+	CreatedAt time.Time          `json:"created_at" bson:"created_at"`
+}
+
+// sensitivity returns the number of standard deviations an anomaly rule flags
+func (r *AlertRule) sensitivity() float64 {
+	if r.Sensitivity > 0 {
+		return r.Sensitivity
+	}
+	return DefaultAnomalySensitivity
+}
+
+// observe scores a value against the alert's baseline and then learns it.
+// Anomalous values are learned too, so a lasting change in level becomes the
+// new baseline instead of triggering forever.
+func (a *Alert) observe(value float64) *AlertSample {
+	if a.Baseline == nil {
+		a.Baseline = &AnomalyBaseline{}
+	}
+	baseline := a.Baseline
+
+	sample := &AlertSample{
+		AlertID:   a.ID,
+		Value:     value,
+		Mean:      baseline.Mean,
+		StdDev:    baseline.StdDev(),
+		CreatedAt: time.Now(),
+	}
+
+	if baseline.Samples >= AnomalyMinSamples {
+		if sample.StdDev > 0 {
+			score := (value - baseline.Mean) / sample.StdDev
+			sample.ZScore = &score
+			sample.Anomalous = math.Abs(score) >= a.Rule.sensitivity()
+		} else {
+			// A metric that never moved is anomalous as soon as it does
+			sample.Anomalous = value != baseline.Mean
+		}
+	}
+
+	baseline.learn(value)
+	return sample
+}
+
+// alertSampleCollection returns the anomaly rule samples collection
+func (s *mongoStore) alertSampleCollection() *mongo.Collection {
+	return s.db.Collection("alert_samples")
+}
+
+// ensureAlertSampleIndexes creates the indexes samples are listed and
+// expired by
+func (s *mongoStore) ensureAlertSampleIndexes(ctx context.Context) error {
+	_, err := s.alertSampleCollection().Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "created_at", Value: 1}},
+			Options: options.Index().SetName("alert_sample_expiry").SetExpireAfterSeconds(int32(alertSampleRetention.Seconds())),
+		},
+		{
+			Keys:    bson.D{{Key: "alert_id", Value: 1}, {Key: "created_at", Value: -1}},
+			Options: options.Index().SetName("alert_sample_alert"),
+		},
+	})
+	return err
+}
+
+// CreateAlertSample records a value an anomaly rule saw
+func (s *mongoStore) CreateAlertSample(ctx context.Context, sample *AlertSample) error {
+	result, err := s.alertSampleCollection().InsertOne(ctx, sample)
+	if err != nil {
+		return err
+	}
+
+	// Set the ID
+	sample.ID = result.InsertedID.(primitive.ObjectID)
+
+	return nil
+}
+
+// GetAlertSamples retrieves the most recent samples of an alert, newest first
+func (s *mongoStore) GetAlertSamples(ctx context.Context, alertID primitive.ObjectID, limit int) ([]*AlertSample, error) {
+	opts := options.Find().
+		SetSort(bson.M{"created_at": -1}).
+		SetLimit(int64(limit))
+
+	cursor, err := s.alertSampleCollection().Find(ctx, bson.M{"alert_id": alertID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	samples := []*AlertSample{}
+	if err := cursor.All(ctx, &samples); err != nil {
+		return nil, err
+	}
+
+	return samples, nil
+}
+
+// deleteAlertSamples deletes the samples of an alert
+func (s *mongoStore) deleteAlertSamples(ctx context.Context, alertID primitive.ObjectID) error {
+	_, err := s.alertSampleCollection().DeleteMany(ctx, bson.M{"alert_id": alertID})
+	return err
+}
@@ -0,0 +1,118 @@
+package models
+
+import (
+	"context"
+	"regexp"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// auditRetention is how long audit entries are kept
+const auditRetention = 90 * 24 * time.Hour
+
+// AuditEntry records a request that changed something, so security reviews
+// can reconstruct who changed what and when
+type AuditEntry struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	UserID    primitive.ObjectID `json:"user_id,omitempty" bson:"user_id,omitempty"` // Empty for unauthenticated requests
+	Method    string             `json:"method" bson:"method"`
+	Path      string             `json:"path" bson:"path"`
+	Route     string             `json:"route,omitempty" bson:"route,omitempty"` // Route the path matched, e.g. /api/databases/:id
+	Status    int                `json:"status" bson:"status"`
+	LatencyMs int64              `json:"latency_ms" bson:"latency_ms"`
+	IP        string             `json:"ip" bson:"ip"`
+	UserAgent string             `json:"user_agent,omitempty" bson:"user_agent,omitempty"`
+	Body      string             `json:"body,omitempty" bson:"body,omitempty"`           // Request body with secrets redacted, when the capture policy allows it
+	BodyNote  string             `json:"body_note,omitempty" bson:"body_note,omitempty"` // Why the body was left out or cut short
+	CreatedAt time.Time          `json:"created_at" bson:"created_at"`
+}
+
+// AuditFilter narrows the audit entries that are listed
+type AuditFilter struct {
+	UserID     primitive.ObjectID
+	Method     string
+	PathPrefix string
+	Since      time.Time
+	Until      time.Time
+	Limit      int
+}
+
+// auditCollection returns the audit log collection
+func (s *mongoStore) auditCollection() *mongo.Collection {
+	return s.db.Collection("audit_log")
+}
+
+// ensureAuditIndexes creates the indexes audit entries are listed and
+// expired by
+func (s *mongoStore) ensureAuditIndexes(ctx context.Context) error {
+	_, err := s.auditCollection().Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "created_at", Value: 1}},
+			Options: options.Index().SetName("audit_expiry").SetExpireAfterSeconds(int32(auditRetention.Seconds())),
+		},
+		{
+			Keys:    bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}},
+			Options: options.Index().SetName("audit_user").SetSparse(true),
+		},
+	})
+	return err
+}
+
+// CreateAuditEntry records an audit entry
+func (s *mongoStore) CreateAuditEntry(ctx context.Context, entry *AuditEntry) error {
+	if entry.CreatedAt.IsZero() {
+		entry.CreatedAt = time.Now()
+	}
+
+	result, err := s.auditCollection().InsertOne(ctx, entry)
+	if err != nil {
+		return err
+	}
+
+	// Set the ID
+	entry.ID = result.InsertedID.(primitive.ObjectID)
+
+	return nil
+}
+
+// GetAuditEntries retrieves the audit entries matching a filter, newest first
+func (s *mongoStore) GetAuditEntries(ctx context.Context, filter AuditFilter) ([]*AuditEntry, error) {
+	query := bson.M{}
+	if !filter.UserID.IsZero() {
+		query["user_id"] = filter.UserID
+	}
+	if filter.Method != "" {
+		query["method"] = filter.Method
+	}
+	if filter.PathPrefix != "" {
+		query["path"] = bson.M{"$regex": "^" + regexp.QuoteMeta(filter.PathPrefix)}
+	}
+	if !filter.Since.IsZero() || !filter.Until.IsZero() {
+		createdAt := bson.M{}
+		if !filter.Since.IsZero() {
+			createdAt["$gte"] = filter.Since
+		}
+		if !filter.Until.IsZero() {
+			createdAt["$lt"] = filter.Until
+		}
+		query["created_at"] = createdAt
+	}
+
+	opts := options.Find().SetSort(bson.M{"created_at": -1}).SetLimit(int64(filter.Limit))
+	cursor, err := s.auditCollection().Find(ctx, query, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	entries := []*AuditEntry{}
+	if err := cursor.All(ctx, &entries); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
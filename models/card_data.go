@@ -0,0 +1,253 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/zucced/goquery/limiter"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// CardData is the latest cached result snapshot for a dashboard card
+type CardData struct {
+	ID            primitive.ObjectID `json:"-" bson:"_id,omitempty"`
+	DashboardID   primitive.ObjectID `json:"dashboard_id" bson:"dashboard_id"`
+	CardID        primitive.ObjectID `json:"card_id" bson:"card_id"`
+	QueryID       primitive.ObjectID `json:"query_id" bson:"query_id"`
+	Status        QueryStatus        `json:"status" bson:"status"`
+	Columns       []ResultColumn     `json:"columns,omitempty" bson:"columns,omitempty"`
+	Results       []QueryResult      `json:"results" bson:"results"`
+	Error         string             `json:"error,omitempty" bson:"error,omitempty"`
+	ExecutionTime string             `json:"execution_time,omitempty" bson:"execution_time,omitempty"`
+	RefreshedAt   time.Time          `json:"refreshed_at" bson:"refreshed_at"`       // Last successful refresh
+	LastAttemptAt time.Time          `json:"last_attempt_at" bson:"last_attempt_at"` // Last refresh attempt, successful or not
+	Value         *float64           `json:"-" bson:"value,omitempty"`               // Metric value of this run, for metric cards
+	PreviousValue *float64           `json:"-" bson:"previous_value,omitempty"`      // Metric value of the previous successful run
+}
+
+// cardDataCollection returns the card data collection
+func (s *mongoStore) cardDataCollection() *mongo.Collection {
+	return s.db.Collection("card_data")
+}
+
+// ensureCardDataIndexes indexes cached card data by its card
+func (s *mongoStore) ensureCardDataIndexes(ctx context.Context) error {
+	_, err := s.cardDataCollection().Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "dashboard_id", Value: 1}, {Key: "card_id", Value: 1}},
+			Options: options.Index().SetName("card_data_card"),
+		},
+	})
+	return err
+}
+
+// GetCardData retrieves the cached snapshot for a card
+func (s *mongoStore) GetCardData(ctx context.Context, dashboardID, cardID primitive.ObjectID) (*CardData, error) {
+	var data CardData
+	err := s.cardDataCollection().FindOne(ctx, bson.M{"dashboard_id": dashboardID, "card_id": cardID}).Decode(&data)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &data, nil
+}
+
+// SaveCardData replaces the cached snapshot for a card after a successful refresh
+func (s *mongoStore) SaveCardData(ctx context.Context, data *CardData) error {
+	if data.Results == nil {
+		data.Results = []QueryResult{}
+	}
+
+	_, err := s.cardDataCollection().ReplaceOne(
+		ctx,
+		bson.M{"dashboard_id": data.DashboardID, "card_id": data.CardID},
+		data,
+		options.Replace().SetUpsert(true),
+	)
+	return err
+}
+
+// SaveCardDataFailure records a failed refresh, keeping the last good results
+func (s *mongoStore) SaveCardDataFailure(ctx context.Context, data *CardData) error {
+	_, err := s.cardDataCollection().UpdateOne(
+		ctx,
+		bson.M{"dashboard_id": data.DashboardID, "card_id": data.CardID},
+		bson.M{
+			"$set": bson.M{
+				"query_id":        data.QueryID,
+				"status":          data.Status,
+				"error":           data.Error,
+				"last_attempt_at": data.LastAttemptAt,
+			},
+			"$setOnInsert": bson.M{"results": []QueryResult{}},
+		},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+// RefreshCardData reruns the query behind a card with the dashboard's default
+// variable values and stores the result snapshot. Execution goes through the
+// limiter so background refreshes respect the same concurrency caps as
+// interactive queries.
+func (s *mongoStore) RefreshCardData(ctx context.Context, execLimiter *limiter.ExecutionLimiter, dashboard *Dashboard, card *DashboardCard) (*CardData, error) {
+	values, err := ResolveVariableValues(dashboard.Variables, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	data, execErr := s.RunCardQuery(ctx, execLimiter, dashboard, card, values)
+	if data == nil {
+		return nil, execErr
+	}
+
+	if execErr != nil {
+		if err := s.SaveCardDataFailure(ctx, data); err != nil {
+			return nil, err
+		}
+		return data, execErr
+	}
+
+	// Metric cards remember the previous value so the change can be reported
+	if card.Type == CardTypeMetric && card.Metric != nil {
+		previous, err := s.GetCardData(ctx, dashboard.ID, card.ID)
+		if err != nil {
+			return nil, err
+		}
+		if previous != nil {
+			data.PreviousValue = previous.Value
+		}
+		data.Value = card.Metric.ExtractMetricValue(data.Results)
+	}
+
+	if err := s.SaveCardData(ctx, data); err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// RunCardQuery executes the query behind a card with the given variable values
+// without caching the result. When the query itself fails the returned data
+// holds the failure alongside the error; a nil result means the card couldn't
+// be run at all.
+func (s *mongoStore) RunCardQuery(ctx context.Context, execLimiter *limiter.ExecutionLimiter, dashboard *Dashboard, card *DashboardCard, values map[string]interface{}) (*CardData, error) {
+	if card.QueryID.IsZero() {
+		return nil, errors.New("card has no query")
+	}
+
+	query, err := s.GetQueryByID(ctx, card.QueryID)
+	if err != nil {
+		return nil, err
+	}
+	if query == nil {
+		return nil, errors.New("query not found")
+	}
+	if query.IsWrite {
+		return nil, errors.New("write queries can't be run from dashboards")
+	}
+	// Materialized queries serve the results of their last refresh
+	if query.Materialized() && !HasQueryVariables(query.GeneratedSQL) {
+		return MaterializedCardData(dashboard, card, query)
+	}
+	if query.Federated() {
+		return s.runFederatedCardQuery(ctx, execLimiter, dashboard, card, query)
+	}
+
+	db, err := s.GetDatabaseByID(ctx, query.DatabaseID)
+	if err != nil {
+		return nil, err
+	}
+	if db == nil {
+		return nil, errors.New("database not found")
+	}
+
+	// Connections that require approval only run SQL an approver approved,
+	// cards of other queries serve their stored results
+	if db.RequiresApproval() && !query.ApprovedToRun() {
+		if query.Status != QueryStatusCompleted {
+			return nil, errors.New("the query must be approved before it can run on a dashboard")
+		}
+		return MaterializedCardData(dashboard, card, query)
+	}
+
+	release, err := execLimiter.Acquire(ctx, dashboard.UserID.Hex(), db.ID.Hex())
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	now := time.Now()
+	data := &CardData{
+		DashboardID:   dashboard.ID,
+		CardID:        card.ID,
+		QueryID:       query.ID,
+		LastAttemptAt: now,
+	}
+
+	results, columns, executionTime, execErr := ExecuteQueryWithVariables(ctx, db, query.GeneratedSQL, values)
+	s.recordCardExecution(ctx, db, dashboard.UserID, now, execErr)
+	if execErr != nil {
+		data.Status = QueryStatusFailed
+		data.Error = execErr.Error()
+		return data, execErr
+	}
+
+	data.Status = QueryStatusCompleted
+	data.Results = results
+	data.Columns = columns
+	data.ExecutionTime = executionTime
+	data.RefreshedAt = now
+
+	return data, nil
+}
+
+// runFederatedCardQuery joins the current results of a federated query's
+// sources for a card. Dashboard variables don't apply to federated queries.
+func (s *mongoStore) runFederatedCardQuery(ctx context.Context, execLimiter *limiter.ExecutionLimiter, dashboard *Dashboard, card *DashboardCard, query *Query) (*CardData, error) {
+	tables, err := s.LoadFederatedTables(ctx, query.Sources, dashboard.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	release, err := execLimiter.Acquire(ctx, dashboard.UserID.Hex(), FederationLimiterKey)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	now := time.Now()
+	data := &CardData{
+		DashboardID:   dashboard.ID,
+		CardID:        card.ID,
+		QueryID:       query.ID,
+		LastAttemptAt: now,
+	}
+
+	results, columns, executionTime, execErr := ExecuteFederatedQuery(ctx, tables, query.GeneratedSQL)
+	if execErr != nil {
+		data.Status = QueryStatusFailed
+		data.Error = execErr.Error()
+		return data, execErr
+	}
+
+	data.Status = QueryStatusCompleted
+	data.Results = results
+	data.Columns = columns
+	data.ExecutionTime = executionTime
+	data.RefreshedAt = now
+
+	return data, nil
+}
+
+// DeleteCardData removes the cached snapshot for a card
+func (s *mongoStore) DeleteCardData(ctx context.Context, dashboardID, cardID primitive.ObjectID) error {
+	_, err := s.cardDataCollection().DeleteOne(ctx, bson.M{"dashboard_id": dashboardID, "card_id": cardID})
+	return err
+}
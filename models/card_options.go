@@ -0,0 +1,143 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+// NumberFormat controls how numeric values on a card are displayed
+type NumberFormat string
+
+const (
+	NumberFormatNumber   NumberFormat = "number"
+	NumberFormatInteger  NumberFormat = "integer"
+	NumberFormatPercent  NumberFormat = "percent"
+	NumberFormatCurrency NumberFormat = "currency"
+	NumberFormatCompact  NumberFormat = "compact"
+)
+
+// ChartStacking controls how multiple series share a bar or area chart
+type ChartStacking string
+
+const (
+	ChartStackingGrouped ChartStacking = "grouped"
+	ChartStackingStacked ChartStacking = "stacked"
+)
+
+// MaxCardColors is the largest number of series colors a card can define
+const MaxCardColors = 20
+
+// maxAxisLabelLength caps the length of axis labels
+const maxAxisLabelLength = 100
+
+var (
+	cardColorRegex    = regexp.MustCompile(`^#(?:[0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+	currencyCodeRegex = regexp.MustCompile(`^[A-Z]{3}$`)
+)
+
+// CardOptions holds the appearance settings of a card. Which settings apply
+// depends on how the card is rendered.
+type CardOptions struct {
+	Colors       []string      `json:"colors,omitempty" bson:"colors,omitempty"` // Series colors as #rgb or #rrggbb
+	XAxisLabel   string        `json:"x_axis_label,omitempty" bson:"x_axis_label,omitempty"`
+	YAxisLabel   string        `json:"y_axis_label,omitempty" bson:"y_axis_label,omitempty"`
+	NumberFormat NumberFormat  `json:"number_format,omitempty" bson:"number_format,omitempty"`
+	Decimals     *int          `json:"decimals,omitempty" bson:"decimals,omitempty"`
+	Currency     string        `json:"currency,omitempty" bson:"currency,omitempty"` // ISO 4217 code for currency formatting
+	ShowLegend   *bool         `json:"show_legend,omitempty" bson:"show_legend,omitempty"`
+	Stacking     ChartStacking `json:"stacking,omitempty" bson:"stacking,omitempty"`
+}
+
+// cardOptionSupport lists the settings a rendering style accepts
+type cardOptionSupport struct {
+	colors, axes, legend, stacking bool
+}
+
+// cardOptionSupportFor returns the settings supported by a card type and chart type.
+// Query cards and chart cards without a chart type render as tables.
+func cardOptionSupportFor(cardType CardType, chartType ChartType) cardOptionSupport {
+	if cardType == CardTypeMetric {
+		return cardOptionSupport{colors: true}
+	}
+	if cardType != CardTypeChart {
+		return cardOptionSupport{}
+	}
+
+	switch chartType {
+	case ChartTypeBar, ChartTypeArea:
+		return cardOptionSupport{colors: true, axes: true, legend: true, stacking: true}
+	case ChartTypeLine:
+		return cardOptionSupport{colors: true, axes: true, legend: true}
+	case ChartTypePie:
+		return cardOptionSupport{colors: true, legend: true}
+	default:
+		return cardOptionSupport{}
+	}
+}
+
+// Validate checks the options are well formed and supported by the card's type
+// and chart type
+func (o *CardOptions) Validate(cardType CardType, chartType ChartType) error {
+	support := cardOptionSupportFor(cardType, chartType)
+	renderedAs := string(cardType)
+	if cardType == CardTypeChart && chartType != "" {
+		renderedAs = string(chartType) + " chart"
+	}
+
+	if len(o.Colors) > 0 {
+		if !support.colors {
+			return fmt.Errorf("colors aren't supported for %s cards", renderedAs)
+		}
+		if len(o.Colors) > MaxCardColors {
+			return fmt.Errorf("at most %d colors can be set", MaxCardColors)
+		}
+		for _, color := range o.Colors {
+			if !cardColorRegex.MatchString(color) {
+				return fmt.Errorf("invalid color %q, use #rgb or #rrggbb", color)
+			}
+		}
+	}
+
+	if o.XAxisLabel != "" || o.YAxisLabel != "" {
+		if !support.axes {
+			return fmt.Errorf("axis labels aren't supported for %s cards", renderedAs)
+		}
+		if len(o.XAxisLabel) > maxAxisLabelLength || len(o.YAxisLabel) > maxAxisLabelLength {
+			return fmt.Errorf("axis labels must be at most %d characters", maxAxisLabelLength)
+		}
+	}
+
+	if o.ShowLegend != nil && !support.legend {
+		return fmt.Errorf("legend visibility isn't supported for %s cards", renderedAs)
+	}
+
+	switch o.Stacking {
+	case "":
+	case ChartStackingGrouped, ChartStackingStacked:
+		if !support.stacking {
+			return fmt.Errorf("stacking isn't supported for %s cards", renderedAs)
+		}
+	default:
+		return errors.New("stacking must be grouped or stacked")
+	}
+
+	switch o.NumberFormat {
+	case "", NumberFormatNumber, NumberFormatInteger, NumberFormatPercent, NumberFormatCompact:
+		if o.Currency != "" {
+			return errors.New("currency is only used with the currency number format")
+		}
+	case NumberFormatCurrency:
+		if !currencyCodeRegex.MatchString(o.Currency) {
+			return errors.New("currency number format needs a three-letter currency code")
+		}
+	default:
+		return errors.New("number format must be number, integer, percent, currency or compact")
+	}
+
+	if o.Decimals != nil && (*o.Decimals < 0 || *o.Decimals > 10) {
+		return errors.New("decimals must be between 0 and 10")
+	}
+
+	return nil
+}
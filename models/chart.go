@@ -0,0 +1,248 @@
+package models
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ChartAggregation represents how values are combined within a group
+type ChartAggregation string
+
+const (
+	ChartAggregationSum   ChartAggregation = "sum"
+	ChartAggregationAvg   ChartAggregation = "avg"
+	ChartAggregationCount ChartAggregation = "count"
+	ChartAggregationMin   ChartAggregation = "min"
+	ChartAggregationMax   ChartAggregation = "max"
+)
+
+// DateBucket represents the granularity used to group date values
+type DateBucket string
+
+const (
+	DateBucketNone  DateBucket = ""
+	DateBucketDay   DateBucket = "day"
+	DateBucketWeek  DateBucket = "week"
+	DateBucketMonth DateBucket = "month"
+	DateBucketYear  DateBucket = "year"
+)
+
+// ChartPoint is a single aggregated point in a chart series
+type ChartPoint struct {
+	X interface{} `json:"x"`
+	Y float64     `json:"y"`
+}
+
+// ChartSeries is a compact chart-ready representation of query results
+type ChartSeries struct {
+	X           string           `json:"x"`
+	Y           string           `json:"y,omitempty"`
+	Aggregation ChartAggregation `json:"agg"`
+	Bucket      DateBucket       `json:"bucket,omitempty"`
+	Points      []ChartPoint     `json:"points"`
+	RowCount    int              `json:"row_count"`
+}
+
+// chartGroup accumulates values for a single x value
+type chartGroup struct {
+	key   string
+	x     interface{}
+	date  time.Time
+	sum   float64
+	count int
+	min   float64
+	max   float64
+}
+
+// BuildChartSeries groups results by the x column and aggregates the y column.
+// Dates are bucketed by their calendar day in location, or as stored when nil.
+func BuildChartSeries(results []QueryResult, x, y string, agg ChartAggregation, bucket DateBucket, location *time.Location) (*ChartSeries, error) {
+	if x == "" {
+		return nil, fmt.Errorf("x column is required")
+	}
+
+	switch agg {
+	case ChartAggregationSum, ChartAggregationAvg, ChartAggregationMin, ChartAggregationMax:
+		if y == "" {
+			return nil, fmt.Errorf("y column is required for %s aggregation", agg)
+		}
+	case ChartAggregationCount:
+	default:
+		return nil, fmt.Errorf("unsupported aggregation: %s", agg)
+	}
+
+	switch bucket {
+	case DateBucketNone, DateBucketDay, DateBucketWeek, DateBucketMonth, DateBucketYear:
+	default:
+		return nil, fmt.Errorf("unsupported date bucket: %s", bucket)
+	}
+
+	groups := make(map[string]*chartGroup)
+	var order []*chartGroup
+
+	for _, row := range results {
+		rawX, ok := row[x]
+		if !ok {
+			continue
+		}
+
+		// Work out the group key, truncating dates when bucketing
+		var key string
+		var groupX interface{}
+		var date time.Time
+		if bucket != DateBucketNone {
+			t, ok := toTime(rawX)
+			if !ok {
+				continue
+			}
+			if location != nil {
+				t = t.In(location)
+			}
+			date = truncateDate(t, bucket)
+			key = date.Format(time.RFC3339)
+			groupX = key
+		} else {
+			key = fmt.Sprint(rawX)
+			groupX = rawX
+		}
+
+		// Resolve the numeric value for this row
+		value := 0.0
+		if agg != ChartAggregationCount {
+			v, ok := toFloat(row[y])
+			if !ok {
+				continue
+			}
+			value = v
+		}
+
+		group, exists := groups[key]
+		if !exists {
+			group = &chartGroup{key: key, x: groupX, date: date, min: value, max: value}
+			groups[key] = group
+			order = append(order, group)
+		}
+
+		group.sum += value
+		group.count++
+		if value < group.min {
+			group.min = value
+		}
+		if value > group.max {
+			group.max = value
+		}
+	}
+
+	// Dates are shown chronologically, everything else in order of appearance
+	if bucket != DateBucketNone {
+		sort.Slice(order, func(i, j int) bool {
+			return order[i].date.Before(order[j].date)
+		})
+	}
+
+	points := make([]ChartPoint, 0, len(order))
+	for _, group := range order {
+		var y float64
+		switch agg {
+		case ChartAggregationSum:
+			y = group.sum
+		case ChartAggregationAvg:
+			y = group.sum / float64(group.count)
+		case ChartAggregationCount:
+			y = float64(group.count)
+		case ChartAggregationMin:
+			y = group.min
+		case ChartAggregationMax:
+			y = group.max
+		}
+		points = append(points, ChartPoint{X: group.x, Y: y})
+	}
+
+	return &ChartSeries{
+		X:           x,
+		Y:           y,
+		Aggregation: agg,
+		Bucket:      bucket,
+		Points:      points,
+		RowCount:    len(results),
+	}, nil
+}
+
+// truncateDate truncates a time to the start of its bucket
+func truncateDate(t time.Time, bucket DateBucket) time.Time {
+	switch bucket {
+	case DateBucketDay:
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	case DateBucketWeek:
+		// Weeks start on Monday
+		offset := (int(t.Weekday()) + 6) % 7
+		day := t.AddDate(0, 0, -offset)
+		return time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, t.Location())
+	case DateBucketMonth:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+	case DateBucketYear:
+		return time.Date(t.Year(), 1, 1, 0, 0, 0, 0, t.Location())
+	default:
+		return t
+	}
+}
+
+// toFloat converts a result value to a float64
+func toFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case primitive.Decimal128:
+		f, err := strconv.ParseFloat(v.String(), 64)
+		return f, err == nil
+	case string:
+		// Postgres numeric values are returned as strings
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		return f, err == nil
+	case bool:
+		if v {
+			return 1, true
+		}
+		return 0, true
+	default:
+		return 0, false
+	}
+}
+
+// dateLayouts are the string formats recognised as dates
+var dateLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999-07:00",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+}
+
+// toTime converts a result value to a time.Time
+func toTime(value interface{}) (time.Time, bool) {
+	switch v := value.(type) {
+	case time.Time:
+		return v, true
+	case primitive.DateTime:
+		return v.Time(), true
+	case string:
+		for _, layout := range dateLayouts {
+			if t, err := time.Parse(layout, v); err == nil {
+				return t, true
+			}
+		}
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,291 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// Limits of a transformed chart
+const (
+	MaxChartLabels = 1000
+	MaxChartSeries = 50
+	MaxChartTopN   = 50
+)
+
+// ChartOtherLabel names the group the rest are merged into by top N
+const ChartOtherLabel = "Other"
+
+// ChartTransform reshapes query results into chart series. Rows are grouped
+// by the x column, dates optionally by bucket, and split into a series per
+// value of the series column when there is one. The y column is aggregated
+// within each group.
+type ChartTransform struct {
+	X          string           `json:"x" bson:"x"`
+	Y          string           `json:"y,omitempty" bson:"y,omitempty"`
+	Agg        ChartAggregation `json:"agg" bson:"agg"`
+	Bucket     DateBucket       `json:"bucket,omitempty" bson:"bucket,omitempty"`
+	Series     string           `json:"series,omitempty" bson:"series,omitempty"`
+	TopN       int              `json:"top_n,omitempty" bson:"top_n,omitempty"`           // Keeps the largest series, or x values without a series column, merging the rest into Other
+	Cumulative bool             `json:"cumulative,omitempty" bson:"cumulative,omitempty"` // Running totals along x, for sums and counts
+}
+
+// TransformedChart is query results reshaped by a chart transform. Every
+// series has a value per label.
+type TransformedChart struct {
+	Transform ChartTransform      `json:"transform"`
+	Labels    []interface{}       `json:"labels"`
+	Series    []TransformedSeries `json:"series"`
+	RowCount  int                 `json:"row_count"`
+}
+
+// TransformedSeries is one series of a transformed chart. Values are by
+// label, nil where the series has nothing to aggregate.
+type TransformedSeries struct {
+	Name   interface{} `json:"name"`
+	Values []*float64  `json:"values"`
+}
+
+// Validate checks the transform names its columns and combines its settings
+// sensibly
+func (t *ChartTransform) Validate() error {
+	if t.X == "" {
+		return errors.New("x column is required")
+	}
+
+	switch t.Agg {
+	case ChartAggregationSum, ChartAggregationAvg, ChartAggregationMin, ChartAggregationMax:
+		if t.Y == "" {
+			return fmt.Errorf("y column is required for %s aggregation", t.Agg)
+		}
+	case ChartAggregationCount:
+	default:
+		return fmt.Errorf("unsupported aggregation: %s", t.Agg)
+	}
+
+	switch t.Bucket {
+	case DateBucketNone, DateBucketDay, DateBucketWeek, DateBucketMonth, DateBucketYear:
+	default:
+		return fmt.Errorf("unsupported date bucket: %s", t.Bucket)
+	}
+
+	if t.TopN < 0 || t.TopN > MaxChartTopN {
+		return fmt.Errorf("top_n must be between 0 and %d", MaxChartTopN)
+	}
+	if t.TopN > 0 && t.Series == "" && t.Bucket != DateBucketNone {
+		return errors.New("top_n needs a series column when x is bucketed by date")
+	}
+	if t.Cumulative && t.Agg != ChartAggregationSum && t.Agg != ChartAggregationCount {
+		return errors.New("cumulative only applies to sum and count")
+	}
+	return nil
+}
+
+// chartGroupKey is a distinct x or series value of a transformed chart
+type chartGroupKey struct {
+	label interface{}
+	date  time.Time
+	total pivotAccumulator // Across the other dimension, to rank by for top N
+}
+
+// chartGroupKeys collects distinct groups in order of appearance
+type chartGroupKeys struct {
+	byKey map[string]int
+	keys  []*chartGroupKey
+}
+
+// index returns the index of a group, adding it when new
+func (g *chartGroupKeys) index(key string, label interface{}, date time.Time) int {
+	i, ok := g.byKey[key]
+	if !ok {
+		i = len(g.keys)
+		g.byKey[key] = i
+		g.keys = append(g.keys, &chartGroupKey{label: label, date: date})
+	}
+	return i
+}
+
+// Apply reshapes query results. Dates are bucketed in location, or as stored
+// when nil. Rows without an x value, or with a y value that isn't a number,
+// are left out.
+func (t *ChartTransform) Apply(results []QueryResult, location *time.Location) (*TransformedChart, error) {
+	if err := t.Validate(); err != nil {
+		return nil, err
+	}
+
+	labels := &chartGroupKeys{byKey: make(map[string]int)}
+	series := &chartGroupKeys{byKey: make(map[string]int)}
+	cells := make(map[[2]int]*pivotAccumulator)
+
+	for _, row := range results {
+		rawX, ok := row[t.X]
+		if !ok || rawX == nil {
+			continue
+		}
+
+		// Work out the label, truncating dates when bucketing
+		var key string
+		var label interface{}
+		var date time.Time
+		if t.Bucket != DateBucketNone {
+			parsed, ok := toTime(rawX)
+			if !ok {
+				continue
+			}
+			if location != nil {
+				parsed = parsed.In(location)
+			}
+			date = truncateDate(parsed, t.Bucket)
+			key = date.Format(time.RFC3339)
+			label = key
+		} else {
+			key = fmt.Sprint(rawX)
+			label = rawX
+		}
+
+		value := 1.0
+		if t.Agg != ChartAggregationCount {
+			number, ok := toFloat(row[t.Y])
+			if !ok || math.IsNaN(number) || math.IsInf(number, 0) {
+				continue
+			}
+			value = number
+		}
+
+		var s int
+		if t.Series != "" {
+			name := row[t.Series]
+			s = series.index(fmt.Sprint(name), name, time.Time{})
+		} else {
+			s = series.index("", t.seriesName(), time.Time{})
+		}
+		l := labels.index(key, label, date)
+
+		if len(labels.keys) > MaxChartLabels {
+			return nil, fmt.Errorf("the chart has more than %d x values", MaxChartLabels)
+		}
+		if len(series.keys) > MaxChartSeries && t.TopN == 0 {
+			return nil, fmt.Errorf("the chart has more than %d series, use top_n to keep the largest", MaxChartSeries)
+		}
+
+		cell := cells[[2]int{s, l}]
+		if cell == nil {
+			cell = &pivotAccumulator{}
+			cells[[2]int{s, l}] = cell
+		}
+		cell.add(value)
+		labels.keys[l].total.add(value)
+		series.keys[s].total.add(value)
+	}
+
+	// Dates are shown chronologically, everything else in order of appearance
+	labelOrder := make([]int, len(labels.keys))
+	for i := range labelOrder {
+		labelOrder[i] = i
+	}
+	if t.Bucket != DateBucketNone {
+		sort.SliceStable(labelOrder, func(i, j int) bool {
+			return labels.keys[labelOrder[i]].date.Before(labels.keys[labelOrder[j]].date)
+		})
+	}
+	seriesOrder := make([]int, len(series.keys))
+	for i := range seriesOrder {
+		seriesOrder[i] = i
+	}
+
+	// Top N keeps the largest groups, the rest are merged into Other
+	if t.TopN > 0 {
+		if t.Series != "" {
+			seriesOrder = t.keepTop(series, seriesOrder, cells, true)
+		} else {
+			labelOrder = t.keepTop(labels, labelOrder, cells, false)
+		}
+	}
+
+	chart := &TransformedChart{
+		Transform: *t,
+		Labels:    make([]interface{}, len(labelOrder)),
+		Series:    make([]TransformedSeries, len(seriesOrder)),
+		RowCount:  len(results),
+	}
+	for i, l := range labelOrder {
+		chart.Labels[i] = labels.keys[l].label
+	}
+	for i, s := range seriesOrder {
+		values := make([]*float64, len(labelOrder))
+		running := 0.0
+		for j, l := range labelOrder {
+			cell := cells[[2]int{s, l}]
+			if cell == nil {
+				cell = &pivotAccumulator{}
+			}
+			value := cell.result(t.Agg)
+			if t.Cumulative {
+				if value != nil {
+					running += *value
+				}
+				total := running
+				value = &total
+			}
+			values[j] = value
+		}
+		chart.Series[i] = TransformedSeries{Name: series.keys[s].label, Values: values}
+	}
+
+	return chart, nil
+}
+
+// seriesName names the only series of a transform without a series column
+func (t *ChartTransform) seriesName() string {
+	if t.Agg == ChartAggregationCount {
+		return "count"
+	}
+	return t.Y
+}
+
+// keepTop returns the order of the largest TopN groups, largest first, and
+// merges the cells of the rest into an Other group appended last. Series
+// cells are keyed by the series index first, labels by the label index
+// second.
+func (t *ChartTransform) keepTop(groups *chartGroupKeys, order []int, cells map[[2]int]*pivotAccumulator, isSeries bool) []int {
+	if len(order) <= t.TopN {
+		return order
+	}
+
+	ranked := append([]int(nil), order...)
+	sort.SliceStable(ranked, func(i, j int) bool {
+		a := groups.keys[ranked[i]].total.result(t.Agg)
+		b := groups.keys[ranked[j]].total.result(t.Agg)
+		return a != nil && (b == nil || *a > *b)
+	})
+
+	dropped := make(map[int]bool, len(ranked)-t.TopN)
+	for _, i := range ranked[t.TopN:] {
+		dropped[i] = true
+	}
+
+	side := 1
+	if isSeries {
+		side = 0
+	}
+
+	other := groups.index("\x00"+ChartOtherLabel, ChartOtherLabel, time.Time{})
+	merged := make(map[[2]int]*pivotAccumulator)
+	for key, cell := range cells {
+		if !dropped[key[side]] {
+			continue
+		}
+		key[side] = other
+		if merged[key] == nil {
+			merged[key] = &pivotAccumulator{}
+		}
+		merged[key].merge(cell)
+	}
+	for key, cell := range merged {
+		cells[key] = cell
+	}
+
+	return append(ranked[:t.TopN:t.TopN], other)
+}
@@ -0,0 +1,112 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestChartTransformApply(t *testing.T) {
+	results := []QueryResult{
+		{"day": time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC), "region": "EU", "total": 10.0},
+		{"day": time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC), "region": "US", "total": 4.0},
+		{"day": time.Date(2024, 1, 1, 18, 0, 0, 0, time.UTC), "region": "EU", "total": "6"},
+		{"day": time.Date(2024, 1, 2, 11, 0, 0, 0, time.UTC), "region": "APAC", "total": 1.0},
+		{"day": nil, "region": "EU", "total": 100.0},
+		{"day": time.Date(2024, 1, 2, 12, 0, 0, 0, time.UTC), "region": "EU", "total": "n/a"},
+	}
+
+	tests := []struct {
+		name      string
+		transform ChartTransform
+		labels    []interface{}
+		series    map[interface{}][]*float64
+	}{
+		{
+			name:      "sum by day",
+			transform: ChartTransform{X: "day", Y: "total", Agg: ChartAggregationSum, Bucket: DateBucketDay},
+			labels:    []interface{}{"2024-01-01T00:00:00Z", "2024-01-02T00:00:00Z"},
+			series:    map[interface{}][]*float64{"total": {float(10), float(11)}},
+		},
+		{
+			name:      "count by region",
+			transform: ChartTransform{X: "region", Agg: ChartAggregationCount},
+			labels:    []interface{}{"EU", "US", "APAC"},
+			series:    map[interface{}][]*float64{"count": {float(4), float(1), float(1)}},
+		},
+		{
+			name:      "series per region",
+			transform: ChartTransform{X: "day", Y: "total", Agg: ChartAggregationAvg, Bucket: DateBucketDay, Series: "region"},
+			labels:    []interface{}{"2024-01-01T00:00:00Z", "2024-01-02T00:00:00Z"},
+			series: map[interface{}][]*float64{
+				"EU":   {float(6), float(10)},
+				"US":   {float(4), nil},
+				"APAC": {nil, float(1)},
+			},
+		},
+		{
+			name:      "cumulative",
+			transform: ChartTransform{X: "day", Y: "total", Agg: ChartAggregationSum, Bucket: DateBucketDay, Cumulative: true},
+			labels:    []interface{}{"2024-01-01T00:00:00Z", "2024-01-02T00:00:00Z"},
+			series:    map[interface{}][]*float64{"total": {float(10), float(21)}},
+		},
+		{
+			name:      "top region",
+			transform: ChartTransform{X: "day", Y: "total", Agg: ChartAggregationSum, Bucket: DateBucketDay, Series: "region", TopN: 1},
+			labels:    []interface{}{"2024-01-01T00:00:00Z", "2024-01-02T00:00:00Z"},
+			series: map[interface{}][]*float64{
+				"EU":            {float(6), float(10)},
+				ChartOtherLabel: {float(4), float(1)},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chart, err := tt.transform.Apply(results, nil)
+			if err != nil {
+				t.Fatalf("Apply() error = %v", err)
+			}
+			if !reflect.DeepEqual(chart.Labels, tt.labels) {
+				t.Errorf("labels = %v, want %v", chart.Labels, tt.labels)
+			}
+			if len(chart.Series) != len(tt.series) {
+				t.Fatalf("got %d series, want %d", len(chart.Series), len(tt.series))
+			}
+			for _, series := range chart.Series {
+				want, ok := tt.series[series.Name]
+				if !ok {
+					t.Errorf("unexpected series %v", series.Name)
+					continue
+				}
+				if !reflect.DeepEqual(series.Values, want) {
+					t.Errorf("series %v = %v, want %v", series.Name, describeValues(series.Values), describeValues(want))
+				}
+			}
+		})
+	}
+}
+
+func TestChartTransformValidate(t *testing.T) {
+	tests := []struct {
+		name      string
+		transform ChartTransform
+		wantErr   bool
+	}{
+		{"count", ChartTransform{X: "region", Agg: ChartAggregationCount}, false},
+		{"no x", ChartTransform{Agg: ChartAggregationCount}, true},
+		{"sum without y", ChartTransform{X: "region", Agg: ChartAggregationSum}, true},
+		{"unknown bucket", ChartTransform{X: "day", Agg: ChartAggregationCount, Bucket: "hour"}, true},
+		{"top n too large", ChartTransform{X: "region", Agg: ChartAggregationCount, TopN: MaxChartTopN + 1}, true},
+		{"top n of dates", ChartTransform{X: "day", Agg: ChartAggregationCount, Bucket: DateBucketDay, TopN: 3}, true},
+		{"cumulative avg", ChartTransform{X: "day", Y: "total", Agg: ChartAggregationAvg, Cumulative: true}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.transform.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
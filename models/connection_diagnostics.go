@@ -0,0 +1,310 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+// Endpoint roles
+const (
+	EndpointPrimary = "primary"
+	EndpointReplica = "replica"
+)
+
+// ConnectionDiagnostics describes a connection test in detail
+type ConnectionDiagnostics struct {
+	Reachable     bool                   `json:"reachable"` // Every endpoint could be reached
+	ServerVersion string                 `json:"server_version,omitempty"`
+	LatencyMs     int64                  `json:"latency_ms"` // Time to connect to and ping the primary
+	Permissions   *ConnectionPermissions `json:"permissions,omitempty"`
+	TLS           *TLSDiagnostics        `json:"tls,omitempty"`
+	Endpoints     []EndpointDiagnostics  `json:"endpoints"`
+}
+
+// ConnectionPermissions describes what the connection's user may do
+type ConnectionPermissions struct {
+	CanSelect bool   `json:"can_select"` // The user can read at least one table
+	ReadOnly  bool   `json:"read_only"`  // The user or server can't modify data
+	Error     string `json:"error,omitempty"`
+}
+
+// TLSDiagnostics describes the encryption of the connection to the primary
+type TLSDiagnostics struct {
+	Enabled     bool   `json:"enabled"`
+	Mode        string `json:"mode,omitempty"`
+	Version     string `json:"version,omitempty"`
+	CipherSuite string `json:"cipher_suite,omitempty"`
+}
+
+// EndpointDiagnostics is the outcome of connecting to one server
+type EndpointDiagnostics struct {
+	Role      string `json:"role"`
+	Address   string `json:"address,omitempty"`
+	Reachable bool   `json:"reachable"`
+	LatencyMs int64  `json:"latency_ms"`
+	Error     string `json:"error,omitempty"`
+}
+
+// Err returns the first endpoint's error, or nil when every endpoint is reachable
+func (d *ConnectionDiagnostics) Err() error {
+	for _, endpoint := range d.Endpoints {
+		if !endpoint.Reachable {
+			if endpoint.Role == EndpointReplica {
+				return fmt.Errorf("read replica %s: %s", endpoint.Address, endpoint.Error)
+			}
+			return errors.New(endpoint.Error)
+		}
+	}
+	return nil
+}
+
+// DiagnoseConnection tests a connection and reports its server version,
+// latency, permissions, TLS and which endpoints could be reached
+func DiagnoseConnection(ctx context.Context, db *Database) (*ConnectionDiagnostics, error) {
+	var diagnostics *ConnectionDiagnostics
+	switch db.Type {
+	case "postgresql":
+		diagnostics = diagnosePostgres(ctx, db)
+	case "mongodb":
+		diagnostics = diagnoseMongoDB(ctx, db)
+	default:
+		return nil, fmt.Errorf("unsupported database type: %s", db.Type)
+	}
+
+	diagnostics.Reachable = diagnostics.Err() == nil
+	return diagnostics, nil
+}
+
+// tlsMode returns the configured TLS verification mode of a connection
+func (db *Database) tlsMode() string {
+	if db.TLS != nil {
+		return db.TLS.mode()
+	}
+	return ""
+}
+
+// address returns the primary's host:port, empty when the connection is
+// configured with a URI
+func (db *Database) address() string {
+	if db.Host == "" {
+		return ""
+	}
+	return net.JoinHostPort(db.Host, db.Port)
+}
+
+// diagnosePostgres connects to the primary and each read replica of a
+// PostgreSQL database, inspecting the primary
+func diagnosePostgres(ctx context.Context, db *Database) *ConnectionDiagnostics {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	diagnostics := &ConnectionDiagnostics{}
+
+	start := time.Now()
+	conn, err := openPostgresConnection(ctx, db)
+	primary := EndpointDiagnostics{
+		Role:      EndpointPrimary,
+		Address:   db.address(),
+		LatencyMs: time.Since(start).Milliseconds(),
+	}
+	diagnostics.LatencyMs = primary.LatencyMs
+	if err != nil {
+		primary.Error = err.Error()
+	} else {
+		primary.Reachable = true
+		defer conn.Close()
+
+		if err := conn.QueryRowContext(ctx, "SHOW server_version").Scan(&diagnostics.ServerVersion); err != nil {
+			log.Printf("Failed to read server version: %v", err)
+		}
+		diagnostics.Permissions = postgresPermissions(ctx, conn)
+		diagnostics.TLS = postgresTLS(ctx, conn, db)
+	}
+	diagnostics.Endpoints = append(diagnostics.Endpoints, primary)
+
+	for _, replica := range db.ReadReplicas {
+		start := time.Now()
+		err := testPostgresConnection(ctx, db.replica(replica))
+		endpoint := EndpointDiagnostics{
+			Role:      EndpointReplica,
+			Address:   replica.address(db.Port),
+			Reachable: err == nil,
+			LatencyMs: time.Since(start).Milliseconds(),
+		}
+		if err != nil {
+			endpoint.Error = err.Error()
+		}
+		diagnostics.Endpoints = append(diagnostics.Endpoints, endpoint)
+	}
+
+	return diagnostics
+}
+
+// postgresPermissionsQuery checks whether the user can read any table, can
+// modify any table, and whether the server accepts writes at all
+const postgresPermissionsQuery = `
+	SELECT
+		EXISTS (
+			SELECT 1 FROM information_schema.tables
+			WHERE table_schema NOT IN ('pg_catalog', 'information_schema')
+			  AND has_table_privilege(quote_ident(table_schema) || '.' || quote_ident(table_name), 'SELECT')
+		),
+		EXISTS (
+			SELECT 1 FROM information_schema.tables
+			WHERE table_schema NOT IN ('pg_catalog', 'information_schema')
+			  AND has_table_privilege(quote_ident(table_schema) || '.' || quote_ident(table_name), 'INSERT, UPDATE, DELETE, TRUNCATE')
+		),
+		pg_is_in_recovery() OR current_setting('transaction_read_only') = 'on'
+`
+
+// postgresPermissions checks what the connection's user may do
+func postgresPermissions(ctx context.Context, conn *sql.DB) *ConnectionPermissions {
+	var canSelect, canWrite, serverReadOnly bool
+	if err := conn.QueryRowContext(ctx, postgresPermissionsQuery).Scan(&canSelect, &canWrite, &serverReadOnly); err != nil {
+		return &ConnectionPermissions{Error: err.Error()}
+	}
+	return &ConnectionPermissions{
+		CanSelect: canSelect,
+		ReadOnly:  !canWrite || serverReadOnly,
+	}
+}
+
+// postgresTLS reports the encryption of the current connection
+func postgresTLS(ctx context.Context, conn *sql.DB, db *Database) *TLSDiagnostics {
+	diagnostics := &TLSDiagnostics{Mode: db.tlsMode()}
+
+	var version, cipher sql.NullString
+	err := conn.QueryRowContext(ctx, "SELECT ssl, version, cipher FROM pg_stat_ssl WHERE pid = pg_backend_pid()").
+		Scan(&diagnostics.Enabled, &version, &cipher)
+	if err != nil {
+		// pg_stat_ssl may not be readable, fall back to the configuration
+		diagnostics.Enabled = db.TLS != nil || db.SSL
+		return diagnostics
+	}
+
+	diagnostics.Version = version.String
+	diagnostics.CipherSuite = cipher.String
+	return diagnostics
+}
+
+// diagnoseMongoDB connects to a MongoDB deployment and inspects it
+func diagnoseMongoDB(ctx context.Context, db *Database) *ConnectionDiagnostics {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	diagnostics := &ConnectionDiagnostics{}
+	primary := EndpointDiagnostics{
+		Role:    EndpointPrimary,
+		Address: db.address(),
+	}
+
+	clientOptions, err := mongoDBClientOptions(db)
+	if err != nil {
+		primary.Error = fmt.Sprintf("failed to create MongoDB client: %v", err)
+		diagnostics.Endpoints = []EndpointDiagnostics{primary}
+		return diagnostics
+	}
+	if len(clientOptions.Hosts) > 0 {
+		primary.Address = strings.Join(clientOptions.Hosts, ",")
+	}
+
+	start := time.Now()
+	client, err := mongo.Connect(ctx, clientOptions)
+	if err == nil {
+		defer client.Disconnect(ctx)
+		err = client.Ping(ctx, readpref.Primary())
+	}
+	primary.LatencyMs = time.Since(start).Milliseconds()
+	diagnostics.LatencyMs = primary.LatencyMs
+	if err != nil {
+		primary.Error = fmt.Sprintf("failed to connect to MongoDB: %v", err)
+		diagnostics.Endpoints = []EndpointDiagnostics{primary}
+		return diagnostics
+	}
+	primary.Reachable = true
+	diagnostics.Endpoints = []EndpointDiagnostics{primary}
+
+	var buildInfo struct {
+		Version string `bson:"version"`
+	}
+	if err := client.Database("admin").RunCommand(ctx, bson.D{{Key: "buildInfo", Value: 1}}).Decode(&buildInfo); err == nil {
+		diagnostics.ServerVersion = buildInfo.Version
+	}
+
+	diagnostics.Permissions = mongoDBPermissions(ctx, client, mongoDBDatabaseName(db))
+	diagnostics.TLS = &TLSDiagnostics{
+		Enabled: clientOptions.TLSConfig != nil,
+		Mode:    db.tlsMode(),
+	}
+
+	return diagnostics
+}
+
+// mongoDBWriteActions are privilege actions that modify documents
+var mongoDBWriteActions = map[string]bool{
+	"insert": true,
+	"update": true,
+	"remove": true,
+}
+
+// mongoDBPermissions checks the privileges the authenticated user has on a database
+func mongoDBPermissions(ctx context.Context, client *mongo.Client, dbName string) *ConnectionPermissions {
+	var status struct {
+		AuthInfo struct {
+			AuthenticatedUsers          []bson.M `bson:"authenticatedUsers"`
+			AuthenticatedUserPrivileges []struct {
+				Resource bson.M   `bson:"resource"`
+				Actions  []string `bson:"actions"`
+			} `bson:"authenticatedUserPrivileges"`
+		} `bson:"authInfo"`
+	}
+
+	command := bson.D{{Key: "connectionStatus", Value: 1}, {Key: "showPrivileges", Value: true}}
+	if err := client.Database(dbName).RunCommand(ctx, command).Decode(&status); err != nil {
+		return &ConnectionPermissions{Error: err.Error()}
+	}
+
+	// Deployments without authentication allow everything
+	if len(status.AuthInfo.AuthenticatedUsers) == 0 {
+		return &ConnectionPermissions{CanSelect: true}
+	}
+
+	permissions := &ConnectionPermissions{ReadOnly: true}
+	for _, privilege := range status.AuthInfo.AuthenticatedUserPrivileges {
+		if !mongoDBResourceCovers(privilege.Resource, dbName) {
+			continue
+		}
+		for _, action := range privilege.Actions {
+			if action == "find" {
+				permissions.CanSelect = true
+			}
+			if mongoDBWriteActions[action] {
+				permissions.ReadOnly = false
+			}
+		}
+	}
+	return permissions
+}
+
+// mongoDBResourceCovers reports whether a privilege's resource includes the
+// collections of a database. An empty database name matches every database.
+func mongoDBResourceCovers(resource bson.M, dbName string) bool {
+	if anyResource, _ := resource["anyResource"].(bool); anyResource {
+		return true
+	}
+	if _, ok := resource["collection"]; !ok {
+		return false
+	}
+	resourceDB, _ := resource["db"].(string)
+	return resourceDB == "" || resourceDB == dbName
+}
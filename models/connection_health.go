@@ -0,0 +1,92 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Connection health statuses
+const (
+	ConnectionHealthy     = "healthy"
+	ConnectionDegraded    = "degraded"    // Reachable, but slower than DegradedLatency
+	ConnectionUnreachable = "unreachable" // The connection test failed
+)
+
+// DegradedLatency is the connection test duration above which a reachable
+// connection is reported as degraded
+var DegradedLatency = 2 * time.Second
+
+// ConnectionHealth is the outcome of the latest background connection test
+type ConnectionHealth struct {
+	Status        string     `json:"status" bson:"status"`
+	LatencyMs     int64      `json:"latency_ms" bson:"latency_ms"`
+	LastError     string     `json:"last_error,omitempty" bson:"last_error,omitempty"`
+	CheckedAt     time.Time  `json:"checked_at" bson:"checked_at"`
+	LastHealthyAt *time.Time `json:"last_healthy_at,omitempty" bson:"last_healthy_at,omitempty"` // Last time the connection was reachable
+}
+
+// CheckConnectionHealth tests a connection and classifies the result. The
+// time it was last reachable carries over from previous when it fails.
+func CheckConnectionHealth(ctx context.Context, db *Database, previous *ConnectionHealth) ConnectionHealth {
+	start := time.Now()
+	err := TestConnection(ctx, db)
+	latency := time.Since(start)
+
+	health := ConnectionHealth{
+		LatencyMs: latency.Milliseconds(),
+		CheckedAt: time.Now(),
+	}
+
+	switch {
+	case err != nil:
+		health.Status = ConnectionUnreachable
+		health.LastError = err.Error()
+		if previous != nil {
+			health.LastHealthyAt = previous.LastHealthyAt
+		}
+	case latency > DegradedLatency:
+		health.Status = ConnectionDegraded
+		health.LastHealthyAt = &health.CheckedAt
+	default:
+		health.Status = ConnectionHealthy
+		health.LastHealthyAt = &health.CheckedAt
+	}
+
+	return health
+}
+
+// GetDatabasesForHealthCheck retrieves every saved connection, without
+// their schemas and stats
+func (s *mongoStore) GetDatabasesForHealthCheck(ctx context.Context) ([]*Database, error) {
+	cursor, err := s.databaseCollection().Find(
+		ctx,
+		bson.M{},
+		options.Find().SetProjection(bson.M{"schema": 0, "stats": 0}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var databases []*Database
+	if err := cursor.All(ctx, &databases); err != nil {
+		return nil, err
+	}
+
+	return databases, nil
+}
+
+// UpdateConnectionHealth stores the outcome of a connection test
+func (s *mongoStore) UpdateConnectionHealth(ctx context.Context, id primitive.ObjectID, health ConnectionHealth) error {
+	set := bson.M{"health": health}
+	if health.Status != ConnectionUnreachable {
+		set["last_connected"] = health.CheckedAt
+	}
+
+	_, err := s.databaseCollection().UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": set})
+	return err
+}
@@ -4,7 +4,6 @@ import (
 	"context"
 	"time"
 
-	"github.com/zucced/goquery/database"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -23,8 +22,11 @@ type CardPosition struct {
 type CardType string
 
 const (
-	CardTypeQuery CardType = "query"
-	CardTypeChart CardType = "chart"
+	CardTypeQuery  CardType = "query"
+	CardTypeChart  CardType = "chart"
+	CardTypeText   CardType = "text"
+	CardTypeEmbed  CardType = "embed"
+	CardTypeMetric CardType = "metric"
 )
 
 // ChartType represents the type of chart for a card
@@ -48,27 +50,107 @@ type DashboardCard struct {
 	Position  CardPosition       `json:"position" bson:"position"`
 	CreatedAt time.Time          `json:"created_at" bson:"created_at"`
 	UpdatedAt time.Time          `json:"updated_at" bson:"updated_at"`
+
+	RefreshInterval int             `json:"refresh_interval,omitempty" bson:"refresh_interval,omitempty"` // Seconds between background refreshes, 0 disables
+	Content         string          `json:"content,omitempty" bson:"content,omitempty"`                   // Sanitized Markdown for text cards
+	URL             string          `json:"url,omitempty" bson:"url,omitempty"`                           // Embedded content for embed cards
+	EmbedMode       EmbedMode       `json:"embed_mode,omitempty" bson:"embed_mode,omitempty"`
+	Metric          *MetricConfig   `json:"metric,omitempty" bson:"metric,omitempty"`       // Value column, target and thresholds for metric cards
+	Options         *CardOptions    `json:"options,omitempty" bson:"options,omitempty"`     // Appearance settings
+	Transform       *ChartTransform `json:"transform,omitempty" bson:"transform,omitempty"` // Reshapes the data of chart cards into series
 }
 
 // Dashboard represents a user dashboard
 type Dashboard struct {
-	ID          primitive.ObjectID `json:"id" bson:"_id,omitempty"`
-	UserID      primitive.ObjectID `json:"user_id" bson:"user_id"`
-	Name        string             `json:"name" bson:"name"`
-	Description string             `json:"description,omitempty" bson:"description,omitempty"`
-	Cards       []DashboardCard    `json:"cards" bson:"cards"`
-	IsDefault   bool               `json:"is_default" bson:"is_default"`
-	CreatedAt   time.Time          `json:"created_at" bson:"created_at"`
-	UpdatedAt   time.Time          `json:"updated_at" bson:"updated_at"`
+	ID          primitive.ObjectID   `json:"id" bson:"_id,omitempty"`
+	UserID      primitive.ObjectID   `json:"user_id" bson:"user_id"`
+	Name        string               `json:"name" bson:"name"`
+	Description string               `json:"description,omitempty" bson:"description,omitempty"`
+	Cards       []DashboardCard      `json:"cards" bson:"cards"`
+	Variables   []DashboardVariable  `json:"variables,omitempty" bson:"variables"`
+	IsDefault   bool                 `json:"is_default" bson:"is_default"`
+	CreatedAt   time.Time            `json:"created_at" bson:"created_at"`
+	UpdatedAt   time.Time            `json:"updated_at" bson:"updated_at"`
+	DeletedAt   *time.Time           `json:"deleted_at,omitempty" bson:"deleted_at,omitempty"`
+	ArchivedAt  *time.Time           `json:"archived_at,omitempty" bson:"archived_at,omitempty"`
+	StarredBy   []primitive.ObjectID `json:"-" bson:"starred_by,omitempty"`
+	Starred     bool                 `json:"starred" bson:"-"` // Whether the requesting user starred the dashboard
+
+	Collaborators []DashboardCollaborator `json:"collaborators,omitempty" bson:"collaborators,omitempty"`
+	Role          DashboardRole           `json:"role,omitempty" bson:"-"` // The requesting user's role on the dashboard
+
+	OrgID       primitive.ObjectID `json:"org_id,omitempty" bson:"org_id,omitempty"`             // Organization the dashboard is shared with
+	WorkspaceID primitive.ObjectID `json:"workspace_id,omitempty" bson:"workspace_id,omitempty"` // Workspace within the organization
+	org         *Organization      // Loaded with the dashboard so org members get access
+}
+
+// DashboardFilter narrows the dashboards returned for a user
+type DashboardFilter struct {
+	Starred     bool
+	Archived    bool               // List archived dashboards instead of active ones
+	WorkspaceID primitive.ObjectID // Only list dashboards in this workspace when set
 }
 
-// DashboardCollection returns the dashboards collection
-func DashboardCollection() *mongo.Collection {
-	return database.GetCollection("dashboards")
+// IsStarredBy reports whether a user has starred the dashboard
+func (d *Dashboard) IsStarredBy(userID primitive.ObjectID) bool {
+	for _, id := range d.StarredBy {
+		if id == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// MinCardRefreshInterval is the shortest allowed card refresh interval in seconds
+const MinCardRefreshInterval = 60
+
+// dashboardCollection returns the dashboards collection
+func (s *mongoStore) dashboardCollection() *mongo.Collection {
+	return s.db.Collection("dashboards")
+}
+
+// ensureDashboardIndexes creates the indexes dashboards are listed by: their
+// owner, collaborators and organization, newest first, searched by: their name
+// and description, and found by: the tokens of their pending invites
+func (s *mongoStore) ensureDashboardIndexes(ctx context.Context) error {
+	_, err := s.dashboardCollection().Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}},
+			Options: options.Index().SetName("dashboard_user_created"),
+		},
+		{
+			Keys:    bson.D{{Key: "collaborators.user_id", Value: 1}},
+			Options: options.Index().SetName("dashboard_collaborator"),
+		},
+		{
+			Keys:    bson.D{{Key: "collaborators.email", Value: 1}},
+			Options: options.Index().SetName("dashboard_collaborator_email"),
+		},
+		{
+			Keys:    bson.D{{Key: "collaborators.invite_token_hash", Value: 1}},
+			Options: options.Index().SetName("dashboard_collaborator_invite").SetSparse(true),
+		},
+		{
+			Keys:    bson.D{{Key: "org_id", Value: 1}, {Key: "created_at", Value: -1}},
+			Options: options.Index().SetName("dashboard_org_created").SetSparse(true),
+		},
+		{
+			Keys:    bson.D{{Key: "deleted_at", Value: 1}},
+			Options: options.Index().SetName("dashboard_deleted").SetSparse(true),
+		},
+		{
+			Keys: bson.D{
+				{Key: "name", Value: "text"},
+				{Key: "description", Value: "text"},
+			},
+			Options: options.Index().SetName("dashboard_text_search"),
+		},
+	})
+	return err
 }
 
 // CreateDashboard creates a new dashboard
-func CreateDashboard(ctx context.Context, dashboard *Dashboard) (*Dashboard, error) {
+func (s *mongoStore) CreateDashboard(ctx context.Context, dashboard *Dashboard) (*Dashboard, error) {
 	// Set timestamps
 	now := time.Now()
 	dashboard.CreatedAt = now
@@ -87,7 +169,7 @@ func CreateDashboard(ctx context.Context, dashboard *Dashboard) (*Dashboard, err
 	}
 
 	// Insert the dashboard into the collection
-	result, err := DashboardCollection().InsertOne(ctx, dashboard)
+	result, err := s.dashboardCollection().InsertOne(ctx, dashboard)
 	if err != nil {
 		return nil, err
 	}
@@ -98,26 +180,63 @@ func CreateDashboard(ctx context.Context, dashboard *Dashboard) (*Dashboard, err
 	return dashboard, nil
 }
 
-// GetDashboardByID retrieves a dashboard by ID
-func GetDashboardByID(ctx context.Context, id primitive.ObjectID) (*Dashboard, error) {
+// GetDashboardByID retrieves a dashboard by ID, ignoring dashboards in the trash
+func (s *mongoStore) GetDashboardByID(ctx context.Context, id primitive.ObjectID) (*Dashboard, error) {
 	var dashboard Dashboard
-	err := DashboardCollection().FindOne(ctx, bson.M{"_id": id}).Decode(&dashboard)
+	err := s.dashboardCollection().FindOne(ctx, bson.M{"_id": id, "deleted_at": notDeleted}).Decode(&dashboard)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
 			return nil, nil
 		}
 		return nil, err
 	}
+	if err := s.loadDashboardOrganizations(ctx, &dashboard); err != nil {
+		return nil, err
+	}
 	return &dashboard, nil
 }
 
-// GetDashboardsByUserID retrieves all dashboards for a user
-func GetDashboardsByUserID(ctx context.Context, userID primitive.ObjectID) ([]*Dashboard, error) {
+// GetDashboardsWithRefreshingCards retrieves all active dashboards that have at
+// least one card with a background refresh interval
+func (s *mongoStore) GetDashboardsWithRefreshingCards(ctx context.Context) ([]*Dashboard, error) {
+	cursor, err := s.dashboardCollection().Find(ctx, bson.M{
+		"deleted_at":             notDeleted,
+		"archived_at":            bson.M{"$exists": false},
+		"cards.refresh_interval": bson.M{"$gt": 0},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var dashboards []*Dashboard
+	if err := cursor.All(ctx, &dashboards); err != nil {
+		return nil, err
+	}
+
+	return dashboards, nil
+}
+
+// GetDashboardsByUserID retrieves all dashboards a user owns or has been shared matching the filter
+func (s *mongoStore) GetDashboardsByUserID(ctx context.Context, userID primitive.ObjectID, filter DashboardFilter) ([]*Dashboard, error) {
 	// Create options for sorting
 	opts := options.Find().SetSort(bson.M{"created_at": -1}) // Sort by created_at descending (newest first)
 
+	orgIDs, err := s.GetOrganizationIDsByUserID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	query := dashboardAccessFilter(userID, orgIDs)
+	query["deleted_at"] = notDeleted
+	if filter.Starred {
+		query["starred_by"] = userID
+	}
+	query["archived_at"] = bson.M{"$exists": filter.Archived}
+	workspaceFilter(query, filter.WorkspaceID)
+
 	// Execute the query
-	cursor, err := DashboardCollection().Find(ctx, bson.M{"user_id": userID}, opts)
+	cursor, err := s.dashboardCollection().Find(ctx, query, opts)
 	if err != nil {
 		return nil, err
 	}
@@ -128,14 +247,18 @@ func GetDashboardsByUserID(ctx context.Context, userID primitive.ObjectID) ([]*D
 		return nil, err
 	}
 
+	if err := s.loadDashboardOrganizations(ctx, dashboards...); err != nil {
+		return nil, err
+	}
+
 	return dashboards, nil
 }
 
 // UpdateDashboard updates a dashboard
-func UpdateDashboard(ctx context.Context, dashboard *Dashboard) error {
+func (s *mongoStore) UpdateDashboard(ctx context.Context, dashboard *Dashboard) error {
 	dashboard.UpdatedAt = time.Now()
 
-	_, err := DashboardCollection().UpdateOne(
+	_, err := s.dashboardCollection().UpdateOne(
 		ctx,
 		bson.M{"_id": dashboard.ID},
 		bson.M{"$set": dashboard},
@@ -143,14 +266,190 @@ func UpdateDashboard(ctx context.Context, dashboard *Dashboard) error {
 	return err
 }
 
-// DeleteDashboard deletes a dashboard
-func DeleteDashboard(ctx context.Context, id primitive.ObjectID) error {
-	_, err := DashboardCollection().DeleteOne(ctx, bson.M{"_id": id})
+// SetDefaultDashboard makes a dashboard the user's default and clears the flag
+// on all of their other dashboards in a single update
+func (s *mongoStore) SetDefaultDashboard(ctx context.Context, userID, dashboardID primitive.ObjectID) error {
+	_, err := s.dashboardCollection().UpdateMany(
+		ctx,
+		bson.M{
+			"user_id":    userID,
+			"deleted_at": notDeleted,
+			"$or": bson.A{
+				bson.M{"_id": dashboardID},
+				bson.M{"is_default": true},
+			},
+		},
+		mongo.Pipeline{
+			{{Key: "$set", Value: bson.M{
+				"is_default": bson.M{"$eq": bson.A{"$_id", dashboardID}},
+				"updated_at": "$$NOW",
+			}}},
+		},
+	)
+	return err
+}
+
+// GetDefaultDashboard retrieves the user's default dashboard
+func (s *mongoStore) GetDefaultDashboard(ctx context.Context, userID primitive.ObjectID) (*Dashboard, error) {
+	var dashboard Dashboard
+	err := s.dashboardCollection().FindOne(ctx, bson.M{
+		"user_id":     userID,
+		"is_default":  true,
+		"deleted_at":  notDeleted,
+		"archived_at": bson.M{"$exists": false},
+	}).Decode(&dashboard)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &dashboard, nil
+}
+
+// ArchiveDashboard hides a dashboard from the main list and pauses its background
+// work. An archived dashboard can't stay the default.
+func (s *mongoStore) ArchiveDashboard(ctx context.Context, id primitive.ObjectID) error {
+	now := time.Now()
+	_, err := s.dashboardCollection().UpdateOne(
+		ctx,
+		bson.M{"_id": id},
+		bson.M{"$set": bson.M{
+			"archived_at": now,
+			"updated_at":  now,
+			"is_default":  false,
+		}},
+	)
 	return err
 }
 
+// UnarchiveDashboard returns an archived dashboard to the main list
+func (s *mongoStore) UnarchiveDashboard(ctx context.Context, id primitive.ObjectID) error {
+	_, err := s.dashboardCollection().UpdateOne(
+		ctx,
+		bson.M{"_id": id},
+		bson.M{
+			"$unset": bson.M{"archived_at": ""},
+			"$set":   bson.M{"updated_at": time.Now()},
+		},
+	)
+	return err
+}
+
+// StarDashboard adds a dashboard to a user's favorites
+func (s *mongoStore) StarDashboard(ctx context.Context, id, userID primitive.ObjectID) error {
+	_, err := s.dashboardCollection().UpdateOne(
+		ctx,
+		bson.M{"_id": id},
+		bson.M{"$addToSet": bson.M{"starred_by": userID}},
+	)
+	return err
+}
+
+// UnstarDashboard removes a dashboard from a user's favorites
+func (s *mongoStore) UnstarDashboard(ctx context.Context, id, userID primitive.ObjectID) error {
+	_, err := s.dashboardCollection().UpdateOne(
+		ctx,
+		bson.M{"_id": id},
+		bson.M{"$pull": bson.M{"starred_by": userID}},
+	)
+	return err
+}
+
+// DeleteDashboard moves a dashboard to the trash and clears its default flag
+func (s *mongoStore) DeleteDashboard(ctx context.Context, id primitive.ObjectID) error {
+	now := time.Now()
+	_, err := s.dashboardCollection().UpdateOne(
+		ctx,
+		bson.M{"_id": id},
+		bson.M{"$set": bson.M{
+			"deleted_at": now,
+			"updated_at": now,
+			"is_default": false, // A restored dashboard shouldn't compete with the current default
+		}},
+	)
+	return err
+}
+
+// GetTrashedDashboardByID retrieves a dashboard that is in the trash
+func (s *mongoStore) GetTrashedDashboardByID(ctx context.Context, id primitive.ObjectID) (*Dashboard, error) {
+	var dashboard Dashboard
+	err := s.dashboardCollection().FindOne(ctx, bson.M{"_id": id, "deleted_at": bson.M{"$exists": true}}).Decode(&dashboard)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &dashboard, nil
+}
+
+// GetTrashedDashboardsByUserID retrieves all dashboards in a user's trash
+func (s *mongoStore) GetTrashedDashboardsByUserID(ctx context.Context, userID primitive.ObjectID) ([]*Dashboard, error) {
+	opts := options.Find().SetSort(bson.M{"deleted_at": -1})
+
+	cursor, err := s.dashboardCollection().Find(ctx, bson.M{"user_id": userID, "deleted_at": bson.M{"$exists": true}}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var dashboards []*Dashboard
+	if err := cursor.All(ctx, &dashboards); err != nil {
+		return nil, err
+	}
+
+	return dashboards, nil
+}
+
+// RestoreDashboard moves a dashboard out of the trash
+func (s *mongoStore) RestoreDashboard(ctx context.Context, id primitive.ObjectID) error {
+	_, err := s.dashboardCollection().UpdateOne(
+		ctx,
+		bson.M{"_id": id},
+		bson.M{
+			"$unset": bson.M{"deleted_at": ""},
+			"$set":   bson.M{"updated_at": time.Now()},
+		},
+	)
+	return err
+}
+
+// PurgeDeletedDashboards permanently removes dashboards trashed before the cutoff
+func (s *mongoStore) PurgeDeletedDashboards(ctx context.Context, cutoff time.Time) (int64, error) {
+	filter := bson.M{"deleted_at": bson.M{"$lt": cutoff}}
+
+	// Comments go with their dashboard
+	cursor, err := s.dashboardCollection().Find(ctx, filter, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return 0, err
+	}
+	var purged []struct {
+		ID primitive.ObjectID `bson:"_id"`
+	}
+	if err := cursor.All(ctx, &purged); err != nil {
+		return 0, err
+	}
+	if len(purged) == 0 {
+		return 0, nil
+	}
+	ids := make([]primitive.ObjectID, len(purged))
+	for i, dashboard := range purged {
+		ids[i] = dashboard.ID
+	}
+
+	result, err := s.dashboardCollection().DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil {
+		return 0, err
+	}
+	if _, err := s.dashboardCommentCollection().DeleteMany(ctx, bson.M{"dashboard_id": bson.M{"$in": ids}}); err != nil {
+		return result.DeletedCount, err
+	}
+	return result.DeletedCount, nil
+}
+
 // AddCardToDashboard adds a card to a dashboard
-func AddCardToDashboard(ctx context.Context, dashboardID primitive.ObjectID, card *DashboardCard) error {
+func (s *mongoStore) AddCardToDashboard(ctx context.Context, dashboardID primitive.ObjectID, card *DashboardCard) error {
 	// Set card ID and timestamps
 	now := time.Now()
 	card.ID = primitive.NewObjectID()
@@ -158,7 +457,7 @@ func AddCardToDashboard(ctx context.Context, dashboardID primitive.ObjectID, car
 	card.UpdatedAt = now
 
 	// Add the card to the dashboard
-	_, err := DashboardCollection().UpdateOne(
+	_, err := s.dashboardCollection().UpdateOne(
 		ctx,
 		bson.M{"_id": dashboardID},
 		bson.M{
@@ -170,21 +469,21 @@ func AddCardToDashboard(ctx context.Context, dashboardID primitive.ObjectID, car
 }
 
 // UpdateDashboardCard updates a card in a dashboard
-func UpdateDashboardCard(ctx context.Context, dashboardID, cardID primitive.ObjectID, updates map[string]interface{}) error {
+func (s *mongoStore) UpdateDashboardCard(ctx context.Context, dashboardID, cardID primitive.ObjectID, updates map[string]interface{}) error {
 	now := time.Now()
 	updates["updated_at"] = now
 
 	// Create the update fields with proper dot notation for nested documents
 	updateFields := bson.M{}
 	for key, value := range updates {
-		updateFields["cards.$." + key] = value
+		updateFields["cards.$."+key] = value
 	}
 
 	// Update the card
-	_, err := DashboardCollection().UpdateOne(
+	_, err := s.dashboardCollection().UpdateOne(
 		ctx,
 		bson.M{
-			"_id":      dashboardID,
+			"_id":       dashboardID,
 			"cards._id": cardID,
 		},
 		bson.M{
@@ -195,11 +494,11 @@ func UpdateDashboardCard(ctx context.Context, dashboardID, cardID primitive.Obje
 }
 
 // DeleteDashboardCard deletes a card from a dashboard
-func DeleteDashboardCard(ctx context.Context, dashboardID, cardID primitive.ObjectID) error {
+func (s *mongoStore) DeleteDashboardCard(ctx context.Context, dashboardID, cardID primitive.ObjectID) error {
 	now := time.Now()
 
 	// Remove the card from the dashboard
-	_, err := DashboardCollection().UpdateOne(
+	_, err := s.dashboardCollection().UpdateOne(
 		ctx,
 		bson.M{"_id": dashboardID},
 		bson.M{
@@ -207,15 +506,20 @@ func DeleteDashboardCard(ctx context.Context, dashboardID, cardID primitive.Obje
 			"$set":  bson.M{"updated_at": now},
 		},
 	)
-	return err
+	if err != nil {
+		return err
+	}
+
+	// The discussion of the card goes with it
+	return s.deleteCardComments(ctx, dashboardID, cardID)
 }
 
 // UpdateCardPositions updates the positions of multiple cards in a dashboard
-func UpdateCardPositions(ctx context.Context, dashboardID primitive.ObjectID, cardPositions map[primitive.ObjectID]CardPosition) error {
+func (s *mongoStore) UpdateCardPositions(ctx context.Context, dashboardID primitive.ObjectID, cardPositions map[primitive.ObjectID]CardPosition) error {
 	now := time.Now()
 
 	// Get the dashboard
-	dashboard, err := GetDashboardByID(ctx, dashboardID)
+	dashboard, err := s.GetDashboardByID(ctx, dashboardID)
 	if err != nil {
 		return err
 	}
@@ -231,5 +535,5 @@ func UpdateCardPositions(ctx context.Context, dashboardID primitive.ObjectID, ca
 	dashboard.UpdatedAt = now
 
 	// Update the dashboard
-	return UpdateDashboard(ctx, dashboard)
+	return s.UpdateDashboard(ctx, dashboard)
 }
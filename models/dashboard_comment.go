@@ -0,0 +1,243 @@
+package models
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MaxCommentMentions caps the people a single comment can mention
+const MaxCommentMentions = 20
+
+// DashboardComment is a note a user left on a dashboard, optionally about one
+// of its cards. A comment without a parent starts a thread, replies belong to
+// the thread's first comment, and threads are resolved once their discussion
+// is settled.
+type DashboardComment struct {
+	ID          primitive.ObjectID   `json:"id" bson:"_id,omitempty"`
+	DashboardID primitive.ObjectID   `json:"dashboard_id" bson:"dashboard_id"`
+	CardID      primitive.ObjectID   `json:"card_id,omitempty" bson:"card_id,omitempty"`     // Card the thread is about
+	ParentID    primitive.ObjectID   `json:"parent_id,omitempty" bson:"parent_id,omitempty"` // First comment of the thread, for replies
+	UserID      primitive.ObjectID   `json:"user_id" bson:"user_id"`
+	AuthorName  string               `json:"author_name,omitempty" bson:"author_name,omitempty"`
+	AuthorEmail string               `json:"author_email" bson:"author_email"`
+	Body        string               `json:"body" bson:"body"`
+	Mentions    []primitive.ObjectID `json:"mentions,omitempty" bson:"mentions,omitempty"`       // Users mentioned who can view the dashboard
+	ResolvedAt  *time.Time           `json:"resolved_at,omitempty" bson:"resolved_at,omitempty"` // Threads only
+	ResolvedBy  primitive.ObjectID   `json:"resolved_by,omitempty" bson:"resolved_by,omitempty"`
+	CreatedAt   time.Time            `json:"created_at" bson:"created_at"`
+	EditedAt    *time.Time           `json:"edited_at,omitempty" bson:"edited_at,omitempty"`
+	Replies     []*DashboardComment  `json:"replies,omitempty" bson:"-"` // Loaded with threads, oldest first
+}
+
+// DashboardCommentFilter narrows the threads listed for a dashboard
+type DashboardCommentFilter struct {
+	CardID   primitive.ObjectID // Only threads about this card when set
+	Resolved *bool              // Only resolved or unresolved threads when set
+}
+
+// IsThread reports whether the comment starts a thread rather than replies to one
+func (c *DashboardComment) IsThread() bool {
+	return c.ParentID.IsZero()
+}
+
+// mentionRegex matches @-mentions of email addresses, not preceded by
+// anything that would make the @ part of a word or another address
+var mentionRegex = regexp.MustCompile(`(?:^|[^\w.+@-])@([A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,})`)
+
+// ParseMentions returns the email addresses mentioned in a comment as
+// @user@example.com, in the order they first appear. Addresses differing only
+// in case are returned once.
+func ParseMentions(body string) []string {
+	seen := map[string]bool{}
+	emails := []string{}
+	for _, match := range mentionRegex.FindAllStringSubmatch(body, -1) {
+		email := match[1]
+		if key := strings.ToLower(email); !seen[key] {
+			seen[key] = true
+			emails = append(emails, email)
+		}
+	}
+	return emails
+}
+
+// dashboardCommentCollection returns the dashboard comments collection
+func (s *mongoStore) dashboardCommentCollection() *mongo.Collection {
+	return s.db.Collection("dashboard_comments")
+}
+
+// ensureDashboardCommentIndexes creates the indexes threads are listed by and
+// their replies loaded with
+func (s *mongoStore) ensureDashboardCommentIndexes(ctx context.Context) error {
+	_, err := s.dashboardCommentCollection().Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "dashboard_id", Value: 1}, {Key: "created_at", Value: -1}},
+			Options: options.Index().SetName("dashboard_comment_dashboard"),
+		},
+		{
+			Keys:    bson.D{{Key: "parent_id", Value: 1}, {Key: "created_at", Value: 1}},
+			Options: options.Index().SetName("dashboard_comment_parent").SetSparse(true),
+		},
+	})
+	return err
+}
+
+// CreateDashboardComment saves a new comment
+func (s *mongoStore) CreateDashboardComment(ctx context.Context, comment *DashboardComment) error {
+	comment.CreatedAt = time.Now()
+
+	result, err := s.dashboardCommentCollection().InsertOne(ctx, comment)
+	if err != nil {
+		return err
+	}
+
+	// Set the ID
+	comment.ID = result.InsertedID.(primitive.ObjectID)
+
+	return nil
+}
+
+// GetDashboardComment retrieves a comment on a dashboard
+func (s *mongoStore) GetDashboardComment(ctx context.Context, dashboardID, id primitive.ObjectID) (*DashboardComment, error) {
+	var comment DashboardComment
+	err := s.dashboardCommentCollection().FindOne(ctx, bson.M{"_id": id, "dashboard_id": dashboardID}).Decode(&comment)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &comment, nil
+}
+
+// GetDashboardCommentThreads retrieves the threads on a dashboard, newest
+// first, with pagination. Each thread comes with all its replies.
+func (s *mongoStore) GetDashboardCommentThreads(ctx context.Context, dashboardID primitive.ObjectID, commentFilter DashboardCommentFilter, page, limit int64) ([]*DashboardComment, int64, error) {
+	filter := bson.M{
+		"dashboard_id": dashboardID,
+		"parent_id":    bson.M{"$exists": false},
+	}
+	if !commentFilter.CardID.IsZero() {
+		filter["card_id"] = commentFilter.CardID
+	}
+	if commentFilter.Resolved != nil {
+		filter["resolved_at"] = bson.M{"$exists": *commentFilter.Resolved}
+	}
+
+	// Count total documents for pagination
+	totalCount, err := s.dashboardCommentCollection().CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}).
+		SetSkip((page - 1) * limit).
+		SetLimit(limit)
+
+	cursor, err := s.dashboardCommentCollection().Find(ctx, filter, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cursor.Close(ctx)
+
+	threads := []*DashboardComment{}
+	if err := cursor.All(ctx, &threads); err != nil {
+		return nil, 0, err
+	}
+	if len(threads) == 0 {
+		return threads, totalCount, nil
+	}
+
+	// Load the replies of the page's threads at once
+	ids := make([]primitive.ObjectID, len(threads))
+	byID := make(map[primitive.ObjectID]*DashboardComment, len(threads))
+	for i, thread := range threads {
+		ids[i] = thread.ID
+		byID[thread.ID] = thread
+	}
+
+	replyOpts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}})
+	replyCursor, err := s.dashboardCommentCollection().Find(ctx, bson.M{"parent_id": bson.M{"$in": ids}}, replyOpts)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer replyCursor.Close(ctx)
+
+	var replies []*DashboardComment
+	if err := replyCursor.All(ctx, &replies); err != nil {
+		return nil, 0, err
+	}
+	for _, reply := range replies {
+		if thread := byID[reply.ParentID]; thread != nil {
+			thread.Replies = append(thread.Replies, reply)
+		}
+	}
+
+	return threads, totalCount, nil
+}
+
+// UpdateDashboardCommentBody replaces the body of a comment and the users it
+// mentions, and marks it edited
+func (s *mongoStore) UpdateDashboardCommentBody(ctx context.Context, comment *DashboardComment, body string, mentions []primitive.ObjectID) error {
+	now := time.Now()
+	_, err := s.dashboardCommentCollection().UpdateOne(
+		ctx,
+		bson.M{"_id": comment.ID},
+		bson.M{"$set": bson.M{"body": body, "mentions": mentions, "edited_at": now}},
+	)
+	if err != nil {
+		return err
+	}
+
+	comment.Body = body
+	comment.Mentions = mentions
+	comment.EditedAt = &now
+	return nil
+}
+
+// SetDashboardCommentResolved resolves a thread on behalf of a user, or
+// reopens it
+func (s *mongoStore) SetDashboardCommentResolved(ctx context.Context, comment *DashboardComment, userID primitive.ObjectID, resolved bool) error {
+	update := bson.M{"$unset": bson.M{"resolved_at": "", "resolved_by": ""}}
+	var resolvedAt *time.Time
+	if resolved {
+		now := time.Now()
+		resolvedAt = &now
+		update = bson.M{"$set": bson.M{"resolved_at": now, "resolved_by": userID}}
+	} else {
+		userID = primitive.NilObjectID
+	}
+
+	if _, err := s.dashboardCommentCollection().UpdateOne(ctx, bson.M{"_id": comment.ID}, update); err != nil {
+		return err
+	}
+
+	comment.ResolvedAt = resolvedAt
+	comment.ResolvedBy = userID
+	return nil
+}
+
+// DeleteDashboardComment removes a comment, and its replies when it starts a
+// thread
+func (s *mongoStore) DeleteDashboardComment(ctx context.Context, comment *DashboardComment) error {
+	filter := bson.M{"_id": comment.ID}
+	if comment.IsThread() {
+		filter = bson.M{"$or": bson.A{filter, bson.M{"parent_id": comment.ID}}}
+	}
+
+	_, err := s.dashboardCommentCollection().DeleteMany(ctx, filter)
+	return err
+}
+
+// deleteCardComments removes the threads about a card and their replies
+func (s *mongoStore) deleteCardComments(ctx context.Context, dashboardID, cardID primitive.ObjectID) error {
+	_, err := s.dashboardCommentCollection().DeleteMany(ctx, bson.M{"dashboard_id": dashboardID, "card_id": cardID})
+	return err
+}
@@ -0,0 +1,31 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseMentions(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want []string
+	}{
+		{"none", "Looks good to me", []string{}},
+		{"single", "@ana@example.com can you check this?", []string{"ana@example.com"}},
+		{"in order", "cc @bo@example.com and @ana@example.com", []string{"bo@example.com", "ana@example.com"}},
+		{"case duplicates", "@Ana@example.com @ana@EXAMPLE.com", []string{"Ana@example.com"}},
+		{"after punctuation", "(@ana@example.com)", []string{"ana@example.com"}},
+		{"plain address", "mail ana@example.com", []string{}},
+		{"inside a word", "foo@ana@example.com", []string{}},
+		{"no domain", "@ana", []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ParseMentions(tt.body); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseMentions(%q) = %v, want %v", tt.body, got, tt.want)
+			}
+		})
+	}
+}
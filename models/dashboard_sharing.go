@@ -0,0 +1,226 @@
+package models
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// DashboardRole represents a user's level of access to a dashboard
+type DashboardRole string
+
+const (
+	DashboardRoleNone   DashboardRole = ""
+	DashboardRoleViewer DashboardRole = "viewer"
+	DashboardRoleEditor DashboardRole = "editor"
+	DashboardRoleOwner  DashboardRole = "owner"
+)
+
+// DashboardCollaborator is a user a dashboard has been shared with. Invites for
+// emails without an account are stored with a zero user ID until someone
+// accepts them with the token mailed to the email. Only a hash of the token is
+// stored.
+type DashboardCollaborator struct {
+	ID              primitive.ObjectID `json:"id" bson:"_id"`
+	UserID          primitive.ObjectID `json:"user_id,omitempty" bson:"user_id,omitempty"`
+	Email           string             `json:"email" bson:"email"`
+	Role            DashboardRole      `json:"role" bson:"role"`
+	InviteTokenHash string             `json:"-" bson:"invite_token_hash,omitempty"`
+	AddedAt         time.Time          `json:"added_at" bson:"added_at"`
+}
+
+// NewInviteToken issues the token that accepts the invite of a collaborator
+// without an account and returns it. Any earlier token stops working once the
+// collaborator is saved.
+func (c *DashboardCollaborator) NewInviteToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(buf)
+	c.InviteTokenHash = hashInviteToken(token)
+	return token, nil
+}
+
+// hashInviteToken returns the stored form of an invite token
+func hashInviteToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// RoleFor returns the role a user has on the dashboard. Members of the
+// dashboard's organization can view it and its admins can edit it; a direct
+// share can grant more.
+func (d *Dashboard) RoleFor(userID primitive.ObjectID) DashboardRole {
+	if d.UserID == userID {
+		return DashboardRoleOwner
+	}
+
+	role := DashboardRoleNone
+	for _, collaborator := range d.Collaborators {
+		if !collaborator.UserID.IsZero() && collaborator.UserID == userID {
+			role = collaborator.Role
+			break
+		}
+	}
+
+	if d.org != nil && role != DashboardRoleEditor {
+		switch d.org.RoleFor(userID) {
+		case OrgRoleOwner, OrgRoleAdmin:
+			role = DashboardRoleEditor
+		case OrgRoleMember, OrgRoleApprover:
+			role = DashboardRoleViewer
+		}
+	}
+
+	return role
+}
+
+// CanView reports whether a user can read the dashboard and its card data
+func (d *Dashboard) CanView(userID primitive.ObjectID) bool {
+	return d.RoleFor(userID) != DashboardRoleNone
+}
+
+// CanEdit reports whether a user can change the dashboard's cards and settings
+func (d *Dashboard) CanEdit(userID primitive.ObjectID) bool {
+	role := d.RoleFor(userID)
+	return role == DashboardRoleOwner || role == DashboardRoleEditor
+}
+
+// dashboardAccessFilter matches dashboards a user owns, has been shared or can
+// see through one of the given organizations
+func dashboardAccessFilter(userID primitive.ObjectID, orgIDs []primitive.ObjectID) bson.M {
+	access := bson.A{
+		bson.M{"user_id": userID},
+		bson.M{"collaborators.user_id": userID},
+	}
+	if len(orgIDs) > 0 {
+		access = append(access, bson.M{"org_id": bson.M{"$in": orgIDs}})
+	}
+	return bson.M{"$or": access}
+}
+
+// loadDashboardOrganizations attaches the organization of each shared dashboard
+// so RoleFor can account for org membership
+func (s *mongoStore) loadDashboardOrganizations(ctx context.Context, dashboards ...*Dashboard) error {
+	orgs := make(map[primitive.ObjectID]*Organization)
+	for _, dashboard := range dashboards {
+		if dashboard.OrgID.IsZero() {
+			continue
+		}
+
+		org, ok := orgs[dashboard.OrgID]
+		if !ok {
+			var err error
+			org, err = s.GetOrganizationByID(ctx, dashboard.OrgID)
+			if err != nil {
+				return err
+			}
+			orgs[dashboard.OrgID] = org
+		}
+		dashboard.org = org
+	}
+	return nil
+}
+
+// ShareDashboard adds a collaborator to a dashboard, or updates the role of an
+// existing collaborator with the same user or email
+func (s *mongoStore) ShareDashboard(ctx context.Context, dashboardID primitive.ObjectID, collaborator *DashboardCollaborator) error {
+	collaborator.Email = strings.ToLower(strings.TrimSpace(collaborator.Email))
+
+	// Update an existing collaborator in place
+	match := bson.M{"email": collaborator.Email}
+	if !collaborator.UserID.IsZero() {
+		match = bson.M{"$or": bson.A{bson.M{"user_id": collaborator.UserID}, match}}
+	}
+
+	set := bson.M{"collaborators.$.role": collaborator.Role}
+	if collaborator.InviteTokenHash != "" {
+		set["collaborators.$.invite_token_hash"] = collaborator.InviteTokenHash
+	}
+
+	result, err := s.dashboardCollection().UpdateOne(
+		ctx,
+		bson.M{"_id": dashboardID, "collaborators": bson.M{"$elemMatch": match}},
+		bson.M{"$set": set},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount > 0 {
+		return nil
+	}
+
+	collaborator.ID = primitive.NewObjectID()
+	collaborator.AddedAt = time.Now()
+
+	_, err = s.dashboardCollection().UpdateOne(
+		ctx,
+		bson.M{"_id": dashboardID},
+		bson.M{"$push": bson.M{"collaborators": collaborator}},
+	)
+	return err
+}
+
+// UnshareDashboard removes a collaborator from a dashboard
+func (s *mongoStore) UnshareDashboard(ctx context.Context, dashboardID, collaboratorID primitive.ObjectID) error {
+	_, err := s.dashboardCollection().UpdateOne(
+		ctx,
+		bson.M{"_id": dashboardID},
+		bson.M{"$pull": bson.M{"collaborators": bson.M{"_id": collaboratorID}}},
+	)
+	return err
+}
+
+// AcceptDashboardInvite links the pending invite a token was mailed for to a
+// user and returns the ID of its dashboard. It returns a zero ID when the token
+// is unknown or the invite was already accepted.
+func (s *mongoStore) AcceptDashboardInvite(ctx context.Context, userID primitive.ObjectID, token string) (primitive.ObjectID, error) {
+	invite := bson.M{"invite_token_hash": hashInviteToken(token), "user_id": bson.M{"$exists": false}}
+
+	var dashboard Dashboard
+	err := s.dashboardCollection().FindOneAndUpdate(
+		ctx,
+		bson.M{"collaborators": bson.M{"$elemMatch": invite}},
+		bson.M{
+			"$set":   bson.M{"collaborators.$.user_id": userID},
+			"$unset": bson.M{"collaborators.$.invite_token_hash": ""},
+		},
+		options.FindOneAndUpdate().SetProjection(bson.M{"_id": 1}),
+	).Decode(&dashboard)
+	if err == mongo.ErrNoDocuments {
+		return primitive.NilObjectID, nil
+	}
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	return dashboard.ID, nil
+}
+
+// ClaimDashboardInvites links pending email invites to a user whose email is
+// known to be theirs, such as SSO users of a verified domain
+func (s *mongoStore) ClaimDashboardInvites(ctx context.Context, userID primitive.ObjectID, email string) error {
+	email = strings.ToLower(strings.TrimSpace(email))
+
+	_, err := s.dashboardCollection().UpdateMany(
+		ctx,
+		bson.M{"collaborators": bson.M{"$elemMatch": bson.M{"email": email, "user_id": bson.M{"$exists": false}}}},
+		bson.M{
+			"$set":   bson.M{"collaborators.$[invite].user_id": userID},
+			"$unset": bson.M{"collaborators.$[invite].invite_token_hash": ""},
+		},
+		options.Update().SetArrayFilters(options.ArrayFilters{
+			Filters: []interface{}{bson.M{"invite.email": email, "invite.user_id": bson.M{"$exists": false}}},
+		}),
+	)
+	return err
+}
@@ -3,75 +3,220 @@ package models
 import (
 	"context"
 	"fmt"
+	"sort"
 	"time"
 
-	"github.com/zucced/goquery/database"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 // Column represents a database column
 type Column struct {
-	Name       string   `json:"name" bson:"name"`
-	Type       string   `json:"type" bson:"type"`
-	Nullable   bool     `json:"nullable" bson:"nullable"`
-	PrimaryKey bool     `json:"primary_key" bson:"primary_key"`
-	Fields     []Column `json:"fields,omitempty" bson:"fields,omitempty"` // For nested fields in MongoDB
-	Path       string   `json:"path,omitempty" bson:"path,omitempty"`     // Full path for nested fields
+	Name        string           `json:"name" bson:"name"`
+	Type        string           `json:"type" bson:"type"`
+	Nullable    bool             `json:"nullable" bson:"nullable"`
+	PrimaryKey  bool             `json:"primary_key" bson:"primary_key"`
+	Fields      []Column         `json:"fields,omitempty" bson:"fields,omitempty"`           // For nested fields in MongoDB
+	Path        string           `json:"path,omitempty" bson:"path,omitempty"`               // Full path for nested fields
+	Types       map[string]int   `json:"types,omitempty" bson:"types,omitempty"`             // How often each type was seen across sampled MongoDB documents
+	MixedTypes  bool             `json:"mixed_types,omitempty" bson:"mixed_types,omitempty"` // Sampled documents disagree on the field's type
+	Comment     string           `json:"comment,omitempty" bson:"comment,omitempty"`         // Comment stored in the database
+	Description string           `json:"description,omitempty" bson:"-"`                     // From the column's annotation
+	Semantics   *ColumnSemantics `json:"semantics,omitempty" bson:"-"`                       // From the column's annotation
 }
 
-// Table represents a database table
+// Table kinds
+const (
+	TableKindTable            = "table"
+	TableKindView             = "view"
+	TableKindMaterializedView = "materialized_view"
+)
+
+// Table represents a database table, or a view queried like one
 type Table struct {
 	Name    string   `json:"name" bson:"name"`
+	Kind    string   `json:"kind,omitempty" bson:"kind,omitempty"` // table, view or materialized_view, empty for MongoDB collections
 	Columns []Column `json:"columns" bson:"columns"`
+	Indexes []Index  `json:"indexes,omitempty" bson:"indexes,omitempty"`
+}
+
+// Index represents an index on a table, with its columns in key order
+type Index struct {
+	Name    string   `json:"name" bson:"name"`
+	Columns []string `json:"columns" bson:"columns"`
+	Unique  bool     `json:"unique" bson:"unique"`
+	Primary bool     `json:"primary,omitempty" bson:"primary,omitempty"`
 }
 
 // Schema represents a database schema
 type Schema struct {
-	Tables []Table `json:"tables" bson:"tables"`
+	Tables        []Table        `json:"tables" bson:"tables"`
+	Relationships []Relationship `json:"relationships,omitempty" bson:"relationships,omitempty"`
+	Functions     []Function     `json:"functions,omitempty" bson:"functions,omitempty"` // Only discovered when the connection enables it
 }
 
 // DatabaseStats represents statistics about the database
 type DatabaseStats struct {
-	TableCount int    `json:"table_count" bson:"table_count"`
-	Size       string `json:"size" bson:"size"`
+	TableCount int          `json:"table_count" bson:"table_count"`
+	Size       string       `json:"size" bson:"size"`
+	Tables     []TableStats `json:"tables,omitempty" bson:"tables,omitempty"` // Largest tables first
+}
+
+// TableStats represents statistics about a single table or collection
+type TableStats struct {
+	Name      string `json:"name" bson:"name"`
+	RowCount  int64  `json:"row_count" bson:"row_count"` // Estimated
+	SizeBytes int64  `json:"size_bytes" bson:"size_bytes"`
+	Size      string `json:"size" bson:"size"`
+}
+
+// MaxTableStats caps the number of tables kept in the per-table breakdown
+const MaxTableStats = 50
+
+// largestTables sorts tables by size, largest first, and keeps at most MaxTableStats
+func largestTables(tables []TableStats) []TableStats {
+	sort.SliceStable(tables, func(i, j int) bool {
+		return tables[i].SizeBytes > tables[j].SizeBytes
+	})
+	if len(tables) > MaxTableStats {
+		tables = tables[:MaxTableStats]
+	}
+	return tables
 }
 
 // Database represents a database connection in the system
 type Database struct {
-	ID            primitive.ObjectID `json:"id" bson:"_id,omitempty"`
-	UserID        primitive.ObjectID `json:"user_id" bson:"user_id"`
-	Name          string             `json:"name" bson:"name"`
-	Type          string             `json:"type" bson:"type"`
-	Host          string             `json:"host" bson:"host"`
-	Port          string             `json:"port" bson:"port"`
-	Username      string             `json:"username" bson:"username"`
-	Password      string             `json:"-" bson:"password"`
-	DatabaseName  string             `json:"database_name" bson:"database_name"`
-	SSL           bool               `json:"ssl" bson:"ssl"`
-	ConnectionURI string             `json:"connection_uri,omitempty" bson:"connection_uri,omitempty"`
-	Schema        *Schema            `json:"schema,omitempty" bson:"schema,omitempty"`
-	Stats         *DatabaseStats     `json:"stats,omitempty" bson:"stats,omitempty"`
-	CreatedAt     time.Time          `json:"created_at" bson:"created_at"`
-	UpdatedAt     time.Time          `json:"updated_at" bson:"updated_at"`
-	LastConnected *time.Time         `json:"last_connected,omitempty" bson:"last_connected,omitempty"`
-}
-
-// DatabaseCollection returns the databases collection
-func DatabaseCollection() *mongo.Collection {
-	return database.GetCollection("databases")
+	ID                    primitive.ObjectID       `json:"id" bson:"_id,omitempty"`
+	UserID                primitive.ObjectID       `json:"user_id" bson:"user_id"`
+	OrgID                 primitive.ObjectID       `json:"org_id,omitempty" bson:"org_id,omitempty"`             // Organization the connection is shared with
+	WorkspaceID           primitive.ObjectID       `json:"workspace_id,omitempty" bson:"workspace_id,omitempty"` // Workspace within the organization
+	SharedWith            []primitive.ObjectID     `json:"shared_with,omitempty" bson:"shared_with,omitempty"`   // Members of the organization it is shared with, all when empty
+	Access                DatabaseAccess           `json:"access,omitempty" bson:"-"`                            // The requesting user's access
+	Name                  string                   `json:"name" bson:"name"`
+	Type                  string                   `json:"type" bson:"type"`
+	Environment           string                   `json:"environment,omitempty" bson:"environment,omitempty"` // production, staging or development
+	SafetyLevel           string                   `json:"safety_level,omitempty" bson:"safety_level,omitempty"`
+	Host                  string                   `json:"host" bson:"host"`
+	Port                  string                   `json:"port" bson:"port"`
+	Username              string                   `json:"username" bson:"username"`
+	Password              string                   `json:"-" bson:"password"`
+	DatabaseName          string                   `json:"database_name" bson:"database_name"`
+	SSL                   bool                     `json:"ssl" bson:"ssl"`
+	TLS                   *DatabaseTLS             `json:"tls,omitempty" bson:"tls,omitempty"` // Overrides SSL when set
+	ConnectionURI         string                   `json:"connection_uri,omitempty" bson:"connection_uri,omitempty"`
+	SSHTunnel             *SSHTunnel               `json:"ssh_tunnel,omitempty" bson:"ssh_tunnel,omitempty"`
+	SecretReferences      bool                     `json:"secret_references,omitempty" bson:"secret_references,omitempty"` // Credentials may be ${secret:NAME} references, resolved when connecting
+	ReadReplicas          []ReadReplica            `json:"read_replicas,omitempty" bson:"read_replicas,omitempty"`         // Read-only queries are sent here
+	IncludeTables         []string                 `json:"include_tables,omitempty" bson:"include_tables,omitempty"`       // Glob patterns, only matching tables are discovered
+	ExcludeTables         []string                 `json:"exclude_tables,omitempty" bson:"exclude_tables,omitempty"`       // Glob patterns, matching tables are always hidden
+	DiscoverFunctions     bool                     `json:"discover_functions" bson:"discover_functions,omitempty"`         // Include functions and procedures in the schema
+	Annotations           []ColumnAnnotation       `json:"annotations,omitempty" bson:"annotations,omitempty"`
+	MaskingRules          []MaskingRule            `json:"masking_rules,omitempty" bson:"masking_rules,omitempty"`   // Sensitive columns, masked in results
+	UnmaskedUsers         []primitive.ObjectID     `json:"unmasked_users,omitempty" bson:"unmasked_users,omitempty"` // Members who see sensitive columns unmasked, besides the owner
+	MaskingKey            string                   `json:"-" bson:"masking_key,omitempty"`                           // Hex key of masked hashes
+	Profiles              map[string]*TableProfile `json:"-" bson:"-"`                                               // Column statistics of profiled tables, loaded for query generation
+	AllowWrites           bool                     `json:"allow_writes" bson:"allow_writes"`
+	Execution             *ExecutionSettings       `json:"execution,omitempty" bson:"execution,omitempty"`
+	Schema                *Schema                  `json:"schema,omitempty" bson:"schema,omitempty"`
+	SchemaRefreshInterval int                      `json:"schema_refresh_interval" bson:"schema_refresh_interval,omitempty"` // Seconds between background schema refreshes, 0 to disable
+	SchemaRefresh         *SchemaRefreshStatus     `json:"schema_refresh,omitempty" bson:"schema_refresh,omitempty"`
+	Stats                 *DatabaseStats           `json:"stats,omitempty" bson:"stats,omitempty"`
+	CreatedAt             time.Time                `json:"created_at" bson:"created_at"`
+	UpdatedAt             time.Time                `json:"updated_at" bson:"updated_at"`
+	LastConnected         *time.Time               `json:"last_connected,omitempty" bson:"last_connected,omitempty"`
+	Health                *ConnectionHealth        `json:"health,omitempty" bson:"health,omitempty"`
+	PrivilegeLevel        PrivilegeLevel           `json:"privilege_level,omitempty" bson:"privilege_level,omitempty"` // What the credentials were found to be allowed to do
+	Privileges            *PrivilegeReport         `json:"privileges,omitempty" bson:"privileges,omitempty"`
+}
+
+// Duplicate copies the connection settings and credentials of the database
+// into a new, unsaved database. Its schema, stats and health aren't copied.
+func (db *Database) Duplicate() *Database {
+	duplicate := &Database{
+		UserID:                db.UserID,
+		OrgID:                 db.OrgID,
+		WorkspaceID:           db.WorkspaceID,
+		SharedWith:            append([]primitive.ObjectID(nil), db.SharedWith...),
+		Name:                  db.Name,
+		Type:                  db.Type,
+		Environment:           db.Environment,
+		SafetyLevel:           db.SafetyLevel,
+		Host:                  db.Host,
+		Port:                  db.Port,
+		Username:              db.Username,
+		Password:              db.Password,
+		DatabaseName:          db.DatabaseName,
+		SSL:                   db.SSL,
+		ConnectionURI:         db.ConnectionURI,
+		SecretReferences:      db.SecretReferences,
+		ReadReplicas:          append([]ReadReplica(nil), db.ReadReplicas...),
+		IncludeTables:         append([]string(nil), db.IncludeTables...),
+		ExcludeTables:         append([]string(nil), db.ExcludeTables...),
+		Annotations:           append([]ColumnAnnotation(nil), db.Annotations...),
+		MaskingRules:          append([]MaskingRule(nil), db.MaskingRules...),
+		UnmaskedUsers:         append([]primitive.ObjectID(nil), db.UnmaskedUsers...),
+		MaskingKey:            db.MaskingKey,
+		AllowWrites:           db.AllowWrites,
+		SchemaRefreshInterval: db.SchemaRefreshInterval,
+		DiscoverFunctions:     db.DiscoverFunctions,
+	}
+
+	if db.TLS != nil {
+		tls := *db.TLS
+		duplicate.TLS = &tls
+	}
+	if db.SSHTunnel != nil {
+		sshTunnel := *db.SSHTunnel
+		duplicate.SSHTunnel = &sshTunnel
+	}
+	if db.Execution != nil {
+		execution := *db.Execution
+		duplicate.Execution = &execution
+	}
+
+	return duplicate
+}
+
+// databaseCollection returns the databases collection
+func (s *mongoStore) databaseCollection() *mongo.Collection {
+	return s.db.Collection("databases")
+}
+
+// ensureDatabaseIndexes creates the indexes connections are listed by: their
+// owner and organization, and searched by: their name, tables and columns
+func (s *mongoStore) ensureDatabaseIndexes(ctx context.Context) error {
+	_, err := s.databaseCollection().Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "user_id", Value: 1}},
+			Options: options.Index().SetName("database_user"),
+		},
+		{
+			Keys:    bson.D{{Key: "org_id", Value: 1}},
+			Options: options.Index().SetName("database_org").SetSparse(true),
+		},
+		{
+			Keys: bson.D{
+				{Key: "name", Value: "text"},
+				{Key: "schema.tables.name", Value: "text"},
+				{Key: "schema.tables.columns.name", Value: "text"},
+			},
+			Options: options.Index().SetName("database_text_search"),
+		},
+	})
+	return err
 }
 
 // CreateDatabase creates a new database connection
-func CreateDatabase(ctx context.Context, db *Database) (*Database, error) {
+func (s *mongoStore) CreateDatabase(ctx context.Context, db *Database) (*Database, error) {
 	// Set timestamps
 	now := time.Now()
 	db.CreatedAt = now
 	db.UpdatedAt = now
 
 	// Insert the database into the collection
-	result, err := DatabaseCollection().InsertOne(ctx, db)
+	result, err := s.databaseCollection().InsertOne(ctx, db)
 	if err != nil {
 		return nil, err
 	}
@@ -83,9 +228,9 @@ func CreateDatabase(ctx context.Context, db *Database) (*Database, error) {
 }
 
 // GetDatabaseByID retrieves a database by ID
-func GetDatabaseByID(ctx context.Context, id primitive.ObjectID) (*Database, error) {
+func (s *mongoStore) GetDatabaseByID(ctx context.Context, id primitive.ObjectID) (*Database, error) {
 	var db Database
-	err := DatabaseCollection().FindOne(ctx, bson.M{"_id": id}).Decode(&db)
+	err := s.databaseCollection().FindOne(ctx, bson.M{"_id": id}).Decode(&db)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
 			return nil, nil
@@ -95,9 +240,16 @@ func GetDatabaseByID(ctx context.Context, id primitive.ObjectID) (*Database, err
 	return &db, nil
 }
 
-// GetDatabasesByUserID retrieves all databases for a user
-func GetDatabasesByUserID(ctx context.Context, userID primitive.ObjectID) ([]*Database, error) {
-	cursor, err := DatabaseCollection().Find(ctx, bson.M{"user_id": userID})
+// GetDatabasesByUserID retrieves all databases a user owns or can use through an
+// organization, limited to a workspace when workspaceID is not zero
+func (s *mongoStore) GetDatabasesByUserID(ctx context.Context, userID, workspaceID primitive.ObjectID) ([]*Database, error) {
+	filter, err := s.databaseAccessFilter(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	workspaceFilter(filter, workspaceID)
+
+	cursor, err := s.databaseCollection().Find(ctx, filter)
 	if err != nil {
 		return nil, err
 	}
@@ -112,41 +264,63 @@ func GetDatabasesByUserID(ctx context.Context, userID primitive.ObjectID) ([]*Da
 }
 
 // UpdateDatabase updates a database
-func UpdateDatabase(ctx context.Context, db *Database) error {
+func (s *mongoStore) UpdateDatabase(ctx context.Context, db *Database) error {
 	db.UpdatedAt = time.Now()
 
-	_, err := DatabaseCollection().UpdateOne(
+	_, err := s.databaseCollection().UpdateOne(
 		ctx,
 		bson.M{"_id": db.ID},
 		bson.M{"$set": bson.M{
-			"name":           db.Name,
-			"type":           db.Type,
-			"host":           db.Host,
-			"port":           db.Port,
-			"username":       db.Username,
-			"password":       db.Password,
-			"database_name":  db.DatabaseName,
-			"ssl":            db.SSL,
-			"connection_uri": db.ConnectionURI,
-			"schema":         db.Schema,
-			"stats":          db.Stats,
-			"updated_at":     db.UpdatedAt,
-			"last_connected": db.LastConnected,
+			"name":                    db.Name,
+			"type":                    db.Type,
+			"host":                    db.Host,
+			"port":                    db.Port,
+			"username":                db.Username,
+			"password":                db.Password,
+			"database_name":           db.DatabaseName,
+			"ssl":                     db.SSL,
+			"tls":                     db.TLS,
+			"connection_uri":          db.ConnectionURI,
+			"ssh_tunnel":              db.SSHTunnel,
+			"allow_writes":            db.AllowWrites,
+			"environment":             db.Environment,
+			"safety_level":            db.SafetyLevel,
+			"execution":               db.Execution,
+			"schema_refresh_interval": db.SchemaRefreshInterval,
+			"annotations":             db.Annotations,
+			"discover_functions":      db.DiscoverFunctions,
+			"include_tables":          db.IncludeTables,
+			"exclude_tables":          db.ExcludeTables,
+			"read_replicas":           db.ReadReplicas,
+			"schema":                  db.Schema,
+			"stats":                   db.Stats,
+			"updated_at":              db.UpdatedAt,
+			"last_connected":          db.LastConnected,
+			"privilege_level":         db.PrivilegeLevel,
+			"privileges":              db.Privileges,
 		}},
 	)
 	return err
 }
 
-// DeleteDatabase deletes a database
-func DeleteDatabase(ctx context.Context, id primitive.ObjectID) error {
-	_, err := DatabaseCollection().DeleteOne(ctx, bson.M{"_id": id})
+// DeleteDatabase deletes a database with the freshness checks and profiles
+// of its tables
+func (s *mongoStore) DeleteDatabase(ctx context.Context, id primitive.ObjectID) error {
+	if _, err := s.databaseCollection().DeleteOne(ctx, bson.M{"_id": id}); err != nil {
+		return err
+	}
+
+	if _, err := s.freshnessCheckCollection().DeleteMany(ctx, bson.M{"database_id": id}); err != nil {
+		return err
+	}
+	_, err := s.tableProfileCollection().DeleteMany(ctx, bson.M{"database_id": id})
 	return err
 }
 
 // UpdateLastConnected updates the last connected timestamp
-func UpdateLastConnected(ctx context.Context, id primitive.ObjectID) error {
+func (s *mongoStore) UpdateLastConnected(ctx context.Context, id primitive.ObjectID) error {
 	now := time.Now()
-	_, err := DatabaseCollection().UpdateOne(
+	_, err := s.databaseCollection().UpdateOne(
 		ctx,
 		bson.M{"_id": id},
 		bson.M{"$set": bson.M{
@@ -158,39 +332,61 @@ func UpdateLastConnected(ctx context.Context, id primitive.ObjectID) error {
 }
 
 // TestConnection tests the connection to the database
-func TestConnection(db *Database) error {
+func TestConnection(ctx context.Context, db *Database) error {
 	switch db.Type {
 	case "postgresql":
-		return testPostgresConnection(db)
+		if err := testPostgresConnection(ctx, db); err != nil {
+			return err
+		}
+		return testPostgresReplicas(ctx, db)
 	case "mongodb":
-		return testMongoDBConnection(db)
+		return testMongoDBConnection(ctx, db)
 	default:
 		return fmt.Errorf("unsupported database type: %s", db.Type)
 	}
 }
 
 // FetchDatabaseSchema fetches the schema of the database
-func FetchDatabaseSchema(db *Database) (*Schema, error) {
+func FetchDatabaseSchema(ctx context.Context, db *Database) (*Schema, error) {
+	var schema *Schema
+	var err error
 	switch db.Type {
 	case "postgresql":
-		return fetchPostgresSchema(db)
+		schema, err = fetchPostgresSchema(ctx, db)
 	case "mongodb":
-		return fetchMongoDBSchema(db)
+		schema, err = fetchMongoDBSchema(ctx, db)
 	default:
 		return &Schema{Tables: []Table{}}, fmt.Errorf("unsupported database type: %s", db.Type)
 	}
+
+	// Leave out tables hidden by the connection's include and exclude lists
+	return db.filterSchema(schema), err
 }
 
 // FetchDatabaseStats fetches statistics about the database
-func FetchDatabaseStats(db *Database) (*DatabaseStats, error) {
+func FetchDatabaseStats(ctx context.Context, db *Database) (*DatabaseStats, error) {
+	var stats *DatabaseStats
+	var err error
 	switch db.Type {
 	case "postgresql":
-		return fetchPostgresStats(db)
+		stats, err = fetchPostgresStats(ctx, db)
 	case "mongodb":
-		return fetchMongoDBStats(db)
+		stats, err = fetchMongoDBStats(ctx, db)
 	default:
 		return &DatabaseStats{TableCount: 0, Size: "Unknown"}, fmt.Errorf("unsupported database type: %s", db.Type)
 	}
+
+	// Hidden tables don't appear in the per-table breakdown either
+	if stats != nil && len(stats.Tables) > 0 {
+		tables := stats.Tables[:0]
+		for _, table := range stats.Tables {
+			if db.TableVisible(table.Name) {
+				tables = append(tables, table)
+			}
+		}
+		stats.Tables = tables
+	}
+	return stats, err
 }
 
 // formatSize converts bytes to a human-readable format
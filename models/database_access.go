@@ -0,0 +1,115 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// DatabaseAccess represents a user's level of access to a database connection
+type DatabaseAccess string
+
+const (
+	DatabaseAccessNone  DatabaseAccess = ""
+	DatabaseAccessQuery DatabaseAccess = "query" // Can query the database without seeing its credentials
+	DatabaseAccessOwner DatabaseAccess = "owner"
+)
+
+// CanQuery reports whether the access allows running queries against the database
+func (a DatabaseAccess) CanQuery() bool {
+	return a != DatabaseAccessNone
+}
+
+// CanManage reports whether the access allows changing, sharing or deleting
+// the database and seeing its credentials
+func (a DatabaseAccess) CanManage() bool {
+	return a == DatabaseAccessOwner
+}
+
+// ResolveDatabaseAccess returns a user's access to a database. The owner has
+// full access. A database shared with an organization can be queried by all
+// its members, or only by the listed members when SharedWith is set.
+func (s *mongoStore) ResolveDatabaseAccess(ctx context.Context, db *Database, userID primitive.ObjectID) (DatabaseAccess, error) {
+	if db.UserID == userID {
+		return DatabaseAccessOwner, nil
+	}
+	if db.OrgID.IsZero() || !db.sharedWithMember(userID) {
+		return DatabaseAccessNone, nil
+	}
+
+	member, err := s.IsOrganizationMember(ctx, db.OrgID, userID)
+	if err != nil {
+		return DatabaseAccessNone, err
+	}
+	if !member {
+		return DatabaseAccessNone, nil
+	}
+	return DatabaseAccessQuery, nil
+}
+
+// sharedWithMember reports whether a member of the database's organization is
+// among those it is shared with
+func (db *Database) sharedWithMember(userID primitive.ObjectID) bool {
+	if len(db.SharedWith) == 0 {
+		return true
+	}
+	for _, id := range db.SharedWith {
+		if id == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// RedactCredentials removes everything that could be used to connect to the
+// database outside of GoQuery
+func (db *Database) RedactCredentials() {
+	db.Username = ""
+	db.Password = ""
+	db.ConnectionURI = ""
+	db.SSHTunnel = nil
+	if db.TLS != nil {
+		db.TLS = &DatabaseTLS{Mode: db.TLS.Mode}
+	}
+}
+
+// SetDatabaseMembers limits which members of its organization a database is
+// shared with. An empty list shares it with the whole organization.
+func (s *mongoStore) SetDatabaseMembers(ctx context.Context, id primitive.ObjectID, memberIDs []primitive.ObjectID) error {
+	update := bson.M{"$set": bson.M{"shared_with": memberIDs, "updated_at": time.Now()}}
+	if len(memberIDs) == 0 {
+		update = bson.M{
+			"$set":   bson.M{"updated_at": time.Now()},
+			"$unset": bson.M{"shared_with": ""},
+		}
+	}
+
+	_, err := s.databaseCollection().UpdateOne(ctx, bson.M{"_id": id}, update)
+	return err
+}
+
+// databaseAccessFilter matches databases a user owns or that are shared with
+// them through one of their organizations
+func (s *mongoStore) databaseAccessFilter(ctx context.Context, userID primitive.ObjectID) (bson.M, error) {
+	orgIDs, err := s.GetOrganizationIDsByUserID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if len(orgIDs) == 0 {
+		return bson.M{"user_id": userID}, nil
+	}
+
+	return bson.M{"$or": bson.A{
+		bson.M{"user_id": userID},
+		bson.M{
+			"org_id": bson.M{"$in": orgIDs},
+			"$or": bson.A{
+				bson.M{"shared_with": bson.M{"$exists": false}},
+				bson.M{"shared_with": bson.M{"$size": 0}},
+				bson.M{"shared_with": userID},
+			},
+		},
+	}}, nil
+}
@@ -0,0 +1,157 @@
+package models
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// TLS verification modes
+const (
+	TLSModeVerifyFull = "verify-full" // Verify the certificate chain and the host name
+	TLSModeVerifyCA   = "verify-ca"   // Verify the certificate chain only
+	TLSModeSkipVerify = "skip-verify" // Encrypt without verifying the server
+)
+
+// DatabaseTLS is the TLS configuration of a database connection. Certificates
+// and keys are PEM encoded.
+type DatabaseTLS struct {
+	Mode       string `json:"mode" bson:"mode"`
+	CACert     string `json:"ca_cert,omitempty" bson:"ca_cert,omitempty"`
+	ClientCert string `json:"client_cert,omitempty" bson:"client_cert,omitempty"`
+	ClientKey  string `json:"-" bson:"client_key,omitempty"`
+}
+
+// mode returns the verification mode, defaulting to verify-full
+func (t *DatabaseTLS) mode() string {
+	if t.Mode == "" {
+		return TLSModeVerifyFull
+	}
+	return t.Mode
+}
+
+// Validate checks the mode and that the certificates parse
+func (t *DatabaseTLS) Validate() error {
+	switch t.mode() {
+	case TLSModeVerifyFull, TLSModeVerifyCA, TLSModeSkipVerify:
+	default:
+		return fmt.Errorf("invalid tls mode: %s", t.Mode)
+	}
+
+	if t.CACert != "" {
+		if !x509.NewCertPool().AppendCertsFromPEM([]byte(t.CACert)) {
+			return errors.New("invalid ca certificate")
+		}
+	}
+
+	if (t.ClientCert == "") != (t.ClientKey == "") {
+		return errors.New("client certificate and key must be set together")
+	}
+	if t.ClientCert != "" {
+		if _, err := tls.X509KeyPair([]byte(t.ClientCert), []byte(t.ClientKey)); err != nil {
+			return fmt.Errorf("invalid client certificate: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// postgresParams returns the libpq ssl parameters for the configuration
+func (t *DatabaseTLS) postgresParams() string {
+	mode := t.mode()
+	if mode == TLSModeSkipVerify {
+		// libpq's require mode encrypts without verifying, as long as no root
+		// certificate is given
+		mode = "require"
+	}
+
+	params := []string{"sslmode=" + mode}
+	inline := false
+	if t.CACert != "" && t.mode() != TLSModeSkipVerify {
+		params = append(params, "sslrootcert="+quotePostgresValue(t.CACert))
+		inline = true
+	}
+	if t.ClientCert != "" {
+		params = append(params,
+			"sslcert="+quotePostgresValue(t.ClientCert),
+			"sslkey="+quotePostgresValue(t.ClientKey),
+		)
+		inline = true
+	}
+	if inline {
+		params = append(params, "sslinline=true")
+	}
+
+	return strings.Join(params, " ")
+}
+
+// mongoDBConfig builds the TLS configuration used by the MongoDB client
+func (t *DatabaseTLS) mongoDBConfig() (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if t.CACert != "" {
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM([]byte(t.CACert)) {
+			return nil, errors.New("invalid ca certificate")
+		}
+		config.RootCAs = roots
+	}
+
+	if t.ClientCert != "" {
+		cert, err := tls.X509KeyPair([]byte(t.ClientCert), []byte(t.ClientKey))
+		if err != nil {
+			return nil, fmt.Errorf("invalid client certificate: %v", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	switch t.mode() {
+	case TLSModeSkipVerify:
+		config.InsecureSkipVerify = true
+	case TLSModeVerifyCA:
+		// Verify the chain ourselves, without checking the host name
+		config.InsecureSkipVerify = true
+		config.VerifyPeerCertificate = verifyCertificateChain(config.RootCAs)
+	}
+
+	return config, nil
+}
+
+// verifyCertificateChain verifies the peer's certificate chain against roots,
+// or the system roots when nil, ignoring the host name
+func verifyCertificateChain(roots *x509.CertPool) func([][]byte, [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return errors.New("server presented no certificate")
+		}
+
+		certs := make([]*x509.Certificate, len(rawCerts))
+		for i, raw := range rawCerts {
+			cert, err := x509.ParseCertificate(raw)
+			if err != nil {
+				return fmt.Errorf("failed to parse server certificate: %v", err)
+			}
+			certs[i] = cert
+		}
+
+		intermediates := x509.NewCertPool()
+		for _, cert := range certs[1:] {
+			intermediates.AddCert(cert)
+		}
+
+		_, err := certs[0].Verify(x509.VerifyOptions{
+			Roots:         roots,
+			Intermediates: intermediates,
+		})
+		return err
+	}
+}
+
+// quotePostgresValue quotes a value for a libpq key/value connection string
+func quotePostgresValue(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, `'`, `\'`)
+	return "'" + value + "'"
+}
@@ -0,0 +1,65 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// CreateDemoUser creates an anonymous user that is removed once expiresAt passes
+func (s *mongoStore) CreateDemoUser(ctx context.Context, expiresAt time.Time) (*User, error) {
+	now := time.Now()
+	id := primitive.NewObjectID()
+	user := &User{
+		ID:            id,
+		Email:         "demo-" + id.Hex() + "@demo.invalid",
+		Name:          "Demo User",
+		DemoExpiresAt: &expiresAt,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+
+	if _, err := s.userCollection().InsertOne(ctx, user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// PurgeExpiredDemoUsers deletes demo users whose time is up along with
+// everything they created, returning the number of users removed
+func (s *mongoStore) PurgeExpiredDemoUsers(ctx context.Context, now time.Time) (int64, error) {
+	cursor, err := s.userCollection().Find(ctx, bson.M{"demo_expires_at": bson.M{"$lt": now}})
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	var users []*User
+	if err := cursor.All(ctx, &users); err != nil {
+		return 0, err
+	}
+	if len(users) == 0 {
+		return 0, nil
+	}
+
+	ids := make([]primitive.ObjectID, 0, len(users))
+	for _, user := range users {
+		ids = append(ids, user.ID)
+	}
+
+	owned := bson.M{"user_id": bson.M{"$in": ids}}
+	for _, collection := range []*mongo.Collection{s.databaseCollection(), s.queryCollection(), s.dashboardCollection(), s.sessionCollection()} {
+		if _, err := collection.DeleteMany(ctx, owned); err != nil {
+			return 0, err
+		}
+	}
+
+	result, err := s.userCollection().DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil {
+		return 0, err
+	}
+	return result.DeletedCount, nil
+}
@@ -0,0 +1,101 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// DownloadArtifact is the kind of export a download link is for
+type DownloadArtifact string
+
+const (
+	// DownloadArtifactSnapshot is a dashboard snapshot rendered to PDF or PNG
+	DownloadArtifactSnapshot DownloadArtifact = "snapshot"
+)
+
+// DownloadLink records a signed download URL so it can only be used once. The
+// URL's token carries the link's ID, the link says what it downloads.
+type DownloadLink struct {
+	ID          primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	UserID      primitive.ObjectID `json:"user_id" bson:"user_id"`
+	Artifact    DownloadArtifact   `json:"artifact" bson:"artifact"`
+	DashboardID primitive.ObjectID `json:"dashboard_id" bson:"dashboard_id"`
+	SnapshotID  primitive.ObjectID `json:"snapshot_id" bson:"snapshot_id"`
+	Format      string             `json:"format" bson:"format"`
+	ExpiresAt   time.Time          `json:"expires_at" bson:"expires_at"`
+	UsedAt      *time.Time         `json:"used_at,omitempty" bson:"used_at,omitempty"`
+	CreatedAt   time.Time          `json:"created_at" bson:"created_at"`
+}
+
+// downloadLinkCollection returns the download links collection
+func (s *mongoStore) downloadLinkCollection() *mongo.Collection {
+	return s.db.Collection("download_links")
+}
+
+// ensureDownloadLinkIndexes creates the index that removes links once they
+// expire, used or not
+func (s *mongoStore) ensureDownloadLinkIndexes(ctx context.Context) error {
+	_, err := s.downloadLinkCollection().Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "expires_at", Value: 1}},
+			Options: options.Index().SetName("download_link_expiry").SetExpireAfterSeconds(0),
+		},
+	})
+	return err
+}
+
+// CreateDownloadLink saves a new download link
+func (s *mongoStore) CreateDownloadLink(ctx context.Context, link *DownloadLink) error {
+	link.CreatedAt = time.Now()
+
+	result, err := s.downloadLinkCollection().InsertOne(ctx, link)
+	if err != nil {
+		return err
+	}
+
+	// Set the ID
+	link.ID = result.InsertedID.(primitive.ObjectID)
+
+	return nil
+}
+
+// GetDownloadLink retrieves a download link that is unused and hasn't expired
+func (s *mongoStore) GetDownloadLink(ctx context.Context, id primitive.ObjectID) (*DownloadLink, error) {
+	var link DownloadLink
+	err := s.downloadLinkCollection().FindOne(ctx, bson.M{
+		"_id":        id,
+		"used_at":    bson.M{"$exists": false},
+		"expires_at": bson.M{"$gt": time.Now()},
+	}).Decode(&link)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &link, nil
+}
+
+// ConsumeDownloadLink marks a download link as used. It reports false when the
+// link was used in the meantime or has expired.
+func (s *mongoStore) ConsumeDownloadLink(ctx context.Context, id primitive.ObjectID) (bool, error) {
+	now := time.Now()
+	result, err := s.downloadLinkCollection().UpdateOne(
+		ctx,
+		bson.M{
+			"_id":        id,
+			"used_at":    bson.M{"$exists": false},
+			"expires_at": bson.M{"$gt": now},
+		},
+		bson.M{"$set": bson.M{"used_at": now}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return result.ModifiedCount == 1, nil
+}
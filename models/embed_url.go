@@ -0,0 +1,55 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// EmbeddableDomains lists the domains embed cards may load content from.
+// Subdomains of a listed domain are allowed too.
+var EmbeddableDomains []string
+
+// EmbedMode represents how an embed card displays its URL
+type EmbedMode string
+
+const (
+	EmbedModeIframe EmbedMode = "iframe"
+	EmbedModeImage  EmbedMode = "image"
+)
+
+// ValidateEmbedURL checks that an embed card URL is an https URL on an
+// allowed domain and returns it in normalized form
+func ValidateEmbedURL(raw string) (string, error) {
+	if len(EmbeddableDomains) == 0 {
+		return "", errors.New("embed cards are disabled, no embeddable domains are configured")
+	}
+
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		return "", fmt.Errorf("invalid URL: %v", err)
+	}
+
+	if u.Scheme != "https" {
+		return "", errors.New("embed URLs must use https")
+	}
+
+	if u.User != nil {
+		return "", errors.New("embed URLs can't contain credentials")
+	}
+
+	host := strings.ToLower(u.Hostname())
+	if host == "" {
+		return "", errors.New("embed URL has no host")
+	}
+
+	for _, domain := range EmbeddableDomains {
+		domain = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(domain), "."))
+		if domain != "" && (host == domain || strings.HasSuffix(host, "."+domain)) {
+			return u.String(), nil
+		}
+	}
+
+	return "", fmt.Errorf("domain %s is not in the list of embeddable domains", host)
+}
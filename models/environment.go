@@ -0,0 +1,65 @@
+package models
+
+import "fmt"
+
+// Connection environments
+const (
+	EnvironmentProduction  = "production"
+	EnvironmentStaging     = "staging"
+	EnvironmentDevelopment = "development"
+)
+
+// Connection safety levels
+const (
+	SafetyStandard = "standard" // Queries run as soon as they are generated
+	SafetyConfirm  = "confirm"  // Every query waits for confirmation before it runs
+	SafetyStrict   = "strict"   // Like confirm, with results capped at StrictMaxResultRows
+	SafetyApproval = "approval" // Like strict, with every query approved by an organization approver instead
+)
+
+// StrictMaxResultRows caps the rows kept from a query against a strict connection
+var StrictMaxResultRows = 1000
+
+// ValidateEnvironment checks a connection's environment and safety level
+func ValidateEnvironment(environment, safetyLevel string) error {
+	switch environment {
+	case "", EnvironmentProduction, EnvironmentStaging, EnvironmentDevelopment:
+	default:
+		return fmt.Errorf("invalid environment: %s", environment)
+	}
+
+	switch safetyLevel {
+	case "", SafetyStandard, SafetyConfirm, SafetyStrict:
+	case SafetyApproval:
+		if environment != EnvironmentProduction {
+			return fmt.Errorf("only production databases can require approval")
+		}
+	default:
+		return fmt.Errorf("invalid safety level: %s", safetyLevel)
+	}
+
+	return nil
+}
+
+// DefaultSafetyLevel returns the safety level used when none is chosen.
+// Production connections are strict, everything else standard.
+func DefaultSafetyLevel(environment string) string {
+	if environment == EnvironmentProduction {
+		return SafetyStrict
+	}
+	return SafetyStandard
+}
+
+// RequiresConfirmation reports whether queries against the connection wait
+// for confirmation or approval even when they only read data
+func (db *Database) RequiresConfirmation() bool {
+	return db.SafetyLevel == SafetyConfirm || db.SafetyLevel == SafetyStrict || db.SafetyLevel == SafetyApproval
+}
+
+// limitRows caps results at the connection's row limit
+func (db *Database) limitRows(results []QueryResult) []QueryResult {
+	if limit := db.rowLimit(); len(results) > limit {
+		return results[:limit]
+	}
+	return results
+}
@@ -0,0 +1,152 @@
+package models
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ExecutionMetricRetention is how long execution metrics are kept
+const ExecutionMetricRetention = 30 * 24 * time.Hour
+
+// Sources of query executions
+const (
+	ExecutionSourceQuery = "query" // Run, rerun or confirmed by a user
+	ExecutionSourceCard  = "card"  // Run for a dashboard card
+)
+
+// ExecutionMetric records how a single query execution against a connection
+// went, for its health and latency
+type ExecutionMetric struct {
+	ID         primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	DatabaseID primitive.ObjectID `json:"database_id" bson:"database_id"`
+	UserID     primitive.ObjectID `json:"user_id" bson:"user_id"`
+	Source     string             `json:"source" bson:"source"`
+	DurationMs int64              `json:"duration_ms" bson:"duration_ms"`
+	Failed     bool               `json:"failed" bson:"failed"`
+	CreatedAt  time.Time          `json:"created_at" bson:"created_at"`
+}
+
+// NewExecutionMetric describes an execution against db that started at
+// start and ended with err
+func NewExecutionMetric(db *Database, userID primitive.ObjectID, source string, start time.Time, err error) *ExecutionMetric {
+	return &ExecutionMetric{
+		DatabaseID: db.ID,
+		UserID:     userID,
+		Source:     source,
+		DurationMs: time.Since(start).Milliseconds(),
+		Failed:     err != nil,
+		CreatedAt:  time.Now(),
+	}
+}
+
+// ExecutionStats summarizes the executions against a connection
+type ExecutionStats struct {
+	Executions      int64      `json:"executions" bson:"executions"`
+	Failures        int64      `json:"failures" bson:"failures"`
+	ErrorRate       float64    `json:"error_rate" bson:"-"`                  // Share of executions that failed, from 0 to 1
+	AvgLatencyMs    float64    `json:"avg_latency_ms" bson:"avg_latency_ms"` // Of the executions that succeeded
+	LastSucceededAt *time.Time `json:"last_succeeded_at,omitempty" bson:"last_succeeded_at,omitempty"`
+}
+
+// executionMetricCollection returns the execution metrics collection
+func (s *mongoStore) executionMetricCollection() *mongo.Collection {
+	return s.db.Collection("execution_metrics")
+}
+
+// ensureExecutionMetricIndexes creates the indexes execution metrics are
+// summarized and expired by
+func (s *mongoStore) ensureExecutionMetricIndexes(ctx context.Context) error {
+	_, err := s.executionMetricCollection().Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "created_at", Value: 1}},
+			Options: options.Index().SetName("execution_metric_expiry").SetExpireAfterSeconds(int32(ExecutionMetricRetention.Seconds())),
+		},
+		{
+			Keys:    bson.D{{Key: "database_id", Value: 1}, {Key: "created_at", Value: -1}},
+			Options: options.Index().SetName("execution_metric_database"),
+		},
+	})
+	return err
+}
+
+// RecordExecution stores the metric of a query execution
+func (s *mongoStore) RecordExecution(ctx context.Context, metric *ExecutionMetric) error {
+	result, err := s.executionMetricCollection().InsertOne(ctx, metric)
+	if err != nil {
+		return err
+	}
+
+	// Set the ID
+	metric.ID = result.InsertedID.(primitive.ObjectID)
+
+	return nil
+}
+
+// recordCardExecution stores the metric of a card query execution. Executions
+// cut short by the caller giving up are left out.
+func (s *mongoStore) recordCardExecution(ctx context.Context, db *Database, userID primitive.ObjectID, start time.Time, err error) {
+	if ctx.Err() != nil {
+		return
+	}
+
+	metric := NewExecutionMetric(db, userID, ExecutionSourceCard, start, err)
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+	defer cancel()
+	if err := s.RecordExecution(ctx, metric); err != nil {
+		log.Printf("Failed to record execution of card query against %s: %v", db.ID.Hex(), err)
+	}
+}
+
+// GetExecutionStats summarizes the executions against each of the databases
+// since a time. Databases without executions are left out.
+func (s *mongoStore) GetExecutionStats(ctx context.Context, databaseIDs []primitive.ObjectID, since time.Time) (map[primitive.ObjectID]*ExecutionStats, error) {
+	stats := make(map[primitive.ObjectID]*ExecutionStats)
+	if len(databaseIDs) == 0 {
+		return stats, nil
+	}
+
+	cursor, err := s.executionMetricCollection().Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{
+			"database_id": bson.M{"$in": databaseIDs},
+			"created_at":  bson.M{"$gte": since},
+		}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":               "$database_id",
+			"executions":        bson.M{"$sum": 1},
+			"failures":          bson.M{"$sum": bson.M{"$cond": bson.A{"$failed", 1, 0}}},
+			"avg_latency_ms":    bson.M{"$avg": bson.M{"$cond": bson.A{"$failed", nil, "$duration_ms"}}},
+			"last_succeeded_at": bson.M{"$max": bson.M{"$cond": bson.A{"$failed", nil, "$created_at"}}},
+		}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var result struct {
+			DatabaseID     primitive.ObjectID `bson:"_id"`
+			ExecutionStats `bson:",inline"`
+		}
+		if err := cursor.Decode(&result); err != nil {
+			return nil, err
+		}
+
+		summary := result.ExecutionStats
+		if summary.Executions > 0 {
+			summary.ErrorRate = float64(summary.Failures) / float64(summary.Executions)
+		}
+		stats[result.DatabaseID] = &summary
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, err
+	}
+
+	return stats, nil
+}
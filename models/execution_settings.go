@@ -0,0 +1,254 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// MaxStatementTimeout is the longest allowed per-connection statement timeout, in seconds
+const MaxStatementTimeout = 3600
+
+// ExecutionSettings tune how queries are run against a connection. Zero
+// values fall back to the server-wide defaults.
+type ExecutionSettings struct {
+	MaxRows               int  `json:"max_rows,omitempty" bson:"max_rows,omitempty"`                   // Rows kept from a single query, capped at MaxResultRows
+	DefaultLimit          int  `json:"default_limit,omitempty" bson:"default_limit,omitempty"`         // Limit added to reads that don't set one
+	StatementTimeout      int  `json:"statement_timeout,omitempty" bson:"statement_timeout,omitempty"` // Seconds before a query is cancelled
+	AllowAggregationsOnly bool `json:"allow_aggregations_only,omitempty" bson:"allow_aggregations_only,omitempty"`
+}
+
+// Validate checks the settings are within the server-wide limits
+func (s *ExecutionSettings) Validate() error {
+	if s.MaxRows < 0 || s.MaxRows > MaxResultRows {
+		return fmt.Errorf("max rows must be between 0 and %d", MaxResultRows)
+	}
+	if s.DefaultLimit < 0 || s.DefaultLimit > MaxResultRows {
+		return fmt.Errorf("default limit must be between 0 and %d", MaxResultRows)
+	}
+	if s.MaxRows > 0 && s.DefaultLimit > s.MaxRows {
+		return errors.New("default limit can't exceed max rows")
+	}
+	if s.StatementTimeout < 0 || s.StatementTimeout > MaxStatementTimeout {
+		return fmt.Errorf("statement timeout must be between 0 and %d seconds", MaxStatementTimeout)
+	}
+	return nil
+}
+
+// execution returns the connection's execution settings, or the defaults
+func (db *Database) execution() ExecutionSettings {
+	if db.Execution == nil {
+		return ExecutionSettings{}
+	}
+	return *db.Execution
+}
+
+// rowLimit returns the most rows kept from a single query against the connection
+func (db *Database) rowLimit() int {
+	limit := MaxResultRows
+	if maxRows := db.execution().MaxRows; maxRows > 0 && maxRows < limit {
+		limit = maxRows
+	}
+	if (db.SafetyLevel == SafetyStrict || db.SafetyLevel == SafetyApproval) && StrictMaxResultRows < limit {
+		limit = StrictMaxResultRows
+	}
+	return limit
+}
+
+// queryTimeout returns how long a query may run, falling back to the
+// executor's own timeout when the connection doesn't set one
+func (db *Database) queryTimeout(fallback time.Duration) time.Duration {
+	if seconds := db.execution().StatementTimeout; seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	return fallback
+}
+
+// checkAggregationsOnly rejects reads that return individual rows when the
+// connection only allows aggregate queries
+func (db *Database) checkAggregationsOnly(query string) error {
+	if !db.execution().AllowAggregationsOnly {
+		return nil
+	}
+
+	var aggregate bool
+	switch db.Type {
+	case "postgresql":
+		aggregate = isPostgresAggregateQuery(query)
+	case "mongodb":
+		aggregate = isMongoDBAggregateQuery(query)
+	}
+	if !aggregate {
+		return errors.New("only aggregate queries are allowed on this database")
+	}
+	return nil
+}
+
+// postgresAggregateCallRegex matches calls of aggregate functions in a
+// statement scanned with topLevel set, and the FILTER and OVER clauses that
+// can follow them. Calls followed by OVER are window functions, which return
+// a value for every row.
+var postgresAggregateCallRegex = regexp.MustCompile(`\b(count|sum|avg|min|max|stddev|stddev_pop|stddev_samp|variance|var_pop|var_samp|percentile_cont|percentile_disc|bool_and|bool_or|every)\s*\(\)(\s*within\s+group\s*\(\))?(\s*filter\s*\(\))?(\s*over\b)?`)
+
+// postgresGroupByRegex matches a GROUP BY clause
+var postgresGroupByRegex = regexp.MustCompile(`\bgroup\s+by\b`)
+
+// postgresSetOperatorRegex matches the operators combining the queries of a
+// compound statement
+var postgresSetOperatorRegex = regexp.MustCompile(`\b(union|intersect|except)(\s+(all|distinct))?\b`)
+
+// postgresRowAggregateRegex matches aggregates that collect the values of
+// every row, which would hand the rows back in a single value
+var postgresRowAggregateRegex = regexp.MustCompile(`(?i)\b(array_agg|string_agg|json_agg|jsonb_agg|json_object_agg|jsonb_object_agg|xmlagg)\s*\(`)
+
+// isPostgresAggregateQuery reports whether a read only returns aggregated
+// values. Only the outermost query counts, so aggregates in subqueries, CTEs
+// and comments don't make a statement that returns rows pass, and each query
+// of a UNION, INTERSECT or EXCEPT must group on its own.
+func isPostgresAggregateQuery(query string) bool {
+	if isPostgresMultiStatement(query) || postgresRowAggregateRegex.MatchString(scanPostgres(query, false)) {
+		return false
+	}
+
+	scanned := strings.ToLower(scanPostgres(query, true))
+	switch postgresFirstKeyword(scanned) {
+	case "select", "with":
+	default:
+		return false
+	}
+
+	for _, part := range postgresSetOperatorRegex.Split(scanned, -1) {
+		if postgresGroupByRegex.MatchString(part) {
+			continue
+		}
+
+		aggregate := false
+		for _, call := range postgresAggregateCallRegex.FindAllStringSubmatch(part, -1) {
+			if call[4] != "" {
+				return false
+			}
+			aggregate = true
+		}
+		if !aggregate {
+			return false
+		}
+	}
+	return true
+}
+
+// mongoDBOperationRegex matches the operation of generated MongoDB code
+var mongoDBOperationRegex = regexp.MustCompile(`var operation = "([^"]+)"`)
+
+// mongoDBGroupingStages are the pipeline stages that group documents
+var mongoDBGroupingStages = map[string]bool{
+	"$group": true, "$count": true, "$bucket": true, "$bucketAuto": true, "$sortByCount": true,
+}
+
+// mongoDBStagesAfterGrouping are the stages that can follow the last grouping
+// stage without bringing individual documents back
+var mongoDBStagesAfterGrouping = map[string]bool{
+	"$match": true, "$sort": true, "$limit": true, "$skip": true, "$project": true,
+	"$addFields": true, "$set": true, "$unset": true,
+}
+
+// isMongoDBAggregateQuery reports whether generated MongoDB code only returns
+// aggregated values. Pipelines are checked as parsed for running, so only
+// their own stages count, not ones nested in $lookup or $facet.
+func isMongoDBAggregateQuery(code string) bool {
+	operationMatch := mongoDBOperationRegex.FindStringSubmatch(code)
+	if len(operationMatch) < 2 {
+		return false
+	}
+
+	switch operationMatch[1] {
+	case "countDocuments", "distinct":
+		return true
+	case "aggregate":
+		return isMongoDBAggregatePipeline(parseMongoDBPipeline(code))
+	default:
+		return false
+	}
+}
+
+// isMongoDBAggregatePipeline reports whether a pipeline groups its documents
+// and only reshapes the groups afterwards
+func isMongoDBAggregatePipeline(pipeline mongo.Pipeline) bool {
+	grouped := false
+	for _, stage := range pipeline {
+		for _, elem := range stage {
+			switch {
+			case mongoDBGroupingStages[elem.Key]:
+				grouped = true
+			case grouped && !mongoDBStagesAfterGrouping[elem.Key]:
+				return false
+			}
+		}
+	}
+	return grouped
+}
+
+// postgresLimitRegex matches a LIMIT or FETCH clause ending a statement
+// scanned with topLevel set
+var postgresLimitRegex = regexp.MustCompile(`(?is)\b(limit\s+\S+(\s+offset\s+\S+(\s+rows?)?)?|fetch\s+(first|next)\b[^;]*)\s*;?\s*$`)
+
+// applyPostgresDefaultLimit adds the connection's default limit to a SELECT
+// that doesn't end in a LIMIT or FETCH clause. Other statements, such as SHOW
+// and EXPLAIN, are left alone, and comments are removed so the limit isn't
+// appended to a trailing one.
+func (db *Database) applyPostgresDefaultLimit(sqlQuery string) string {
+	limit := db.execution().DefaultLimit
+	if limit <= 0 || isPostgresWriteQuery(sqlQuery) || isPostgresMultiStatement(sqlQuery) {
+		return sqlQuery
+	}
+
+	scanned := scanPostgres(sqlQuery, true)
+	switch postgresFirstKeyword(scanned) {
+	case "select", "with":
+	default:
+		return sqlQuery
+	}
+	if postgresLimitRegex.MatchString(scanned) {
+		return sqlQuery
+	}
+
+	trimmed := strings.TrimRight(strings.TrimSpace(scanPostgres(sqlQuery, false)), "; \t\r\n")
+	return fmt.Sprintf("%s LIMIT %d", trimmed, limit)
+}
+
+// setPostgresStatementTimeout applies the connection's statement timeout to
+// the rest of a transaction, so the server cancels the query itself
+func (db *Database) setPostgresStatementTimeout(ctx context.Context, tx *sql.Tx) error {
+	seconds := db.execution().StatementTimeout
+	if seconds <= 0 {
+		return nil
+	}
+	_, err := tx.ExecContext(ctx, fmt.Sprintf("SET LOCAL statement_timeout = %d", seconds*1000))
+	return err
+}
+
+// hasMongoDBLimitStage reports whether a pipeline already limits its output
+func hasMongoDBLimitStage(pipeline mongo.Pipeline) bool {
+	for _, stage := range pipeline {
+		for _, elem := range stage {
+			if elem.Key == "$limit" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// withMongoDBDefaultLimit appends a $limit stage to a pipeline that doesn't have one
+func withMongoDBDefaultLimit(pipeline mongo.Pipeline, limit int) mongo.Pipeline {
+	if limit <= 0 || hasMongoDBLimitStage(pipeline) {
+		return pipeline
+	}
+	return append(pipeline, bson.D{{Key: "$limit", Value: limit}})
+}
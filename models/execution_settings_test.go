@@ -0,0 +1,134 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestApplyPostgresDefaultLimit(t *testing.T) {
+	db := &Database{Type: "postgresql", Execution: &ExecutionSettings{DefaultLimit: 100}}
+
+	tests := []struct {
+		name  string
+		query string
+		want  string
+	}{
+		{"select", "SELECT * FROM users", "SELECT * FROM users LIMIT 100"},
+		{"trailing semicolon", "SELECT * FROM users;", "SELECT * FROM users LIMIT 100"},
+		{"trailing comment", "SELECT * FROM users -- everyone", "SELECT * FROM users LIMIT 100"},
+		{"block comment", "SELECT * FROM users /* LIMIT 5 */", "SELECT * FROM users LIMIT 100"},
+		{"has limit", "SELECT * FROM users LIMIT 5", "SELECT * FROM users LIMIT 5"},
+		{"has limit and offset", "SELECT * FROM users LIMIT 5 OFFSET 10;", "SELECT * FROM users LIMIT 5 OFFSET 10;"},
+		{"has fetch", "SELECT * FROM users FETCH FIRST 5 ROWS ONLY", "SELECT * FROM users FETCH FIRST 5 ROWS ONLY"},
+		{"limit in a literal", "SELECT * FROM users WHERE note = 'limit 5'", "SELECT * FROM users WHERE note = 'limit 5' LIMIT 100"},
+		{"limit in a subquery", "SELECT * FROM (SELECT * FROM users LIMIT 5) u", "SELECT * FROM (SELECT * FROM users LIMIT 5) u LIMIT 100"},
+		{"with", "WITH u AS (SELECT 1) SELECT * FROM u", "WITH u AS (SELECT 1) SELECT * FROM u LIMIT 100"},
+		{"show", "SHOW search_path", "SHOW search_path"},
+		{"explain", "EXPLAIN SELECT * FROM users", "EXPLAIN SELECT * FROM users"},
+		{"write", "DELETE FROM users", "DELETE FROM users"},
+		{"multiple statements", "SELECT * FROM users; SELECT 1", "SELECT * FROM users; SELECT 1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := db.applyPostgresDefaultLimit(tt.query); got != tt.want {
+				t.Errorf("applyPostgresDefaultLimit(%q) = %q, want %q", tt.query, got, tt.want)
+			}
+		})
+	}
+
+	t.Run("no default", func(t *testing.T) {
+		query := "SELECT * FROM users"
+		if got := (&Database{Type: "postgresql"}).applyPostgresDefaultLimit(query); got != query {
+			t.Errorf("applyPostgresDefaultLimit(%q) = %q, want it unchanged", query, got)
+		}
+	})
+}
+
+func TestIsPostgresAggregateQuery(t *testing.T) {
+	tests := []struct {
+		query string
+		want  bool
+	}{
+		{"SELECT count(*) FROM users", true},
+		{"SELECT status, count(*) FROM users GROUP BY status", true},
+		{"SELECT count(*) FILTER (WHERE active) FROM users", true},
+		{"WITH u AS (SELECT * FROM users) SELECT avg(age) FROM u", true},
+		{"SELECT count(*) FROM a UNION ALL SELECT count(*) FROM b", true},
+		{"SELECT * FROM users", false},
+		{"SELECT email, count(*) OVER () FROM users", false},
+		{"SELECT email FROM users WHERE id IN (SELECT max(id) FROM users)", false},
+		{"WITH c AS (SELECT count(*) FROM users) SELECT * FROM c", false},
+		{"SELECT count(*) FROM a UNION ALL SELECT email FROM users", false},
+		{"SELECT email FROM users -- count(*)", false},
+		{"SELECT 'count(*)' FROM users", false},
+		{"SELECT count(*), string_agg(email, ',') FROM users", false},
+		{"SHOW search_path", false},
+		{"SELECT * FROM users; SELECT count(*) FROM users", false},
+		{"SELECT count(*) FROM users; -- done", true},
+	}
+
+	for _, tt := range tests {
+		if got := isPostgresAggregateQuery(tt.query); got != tt.want {
+			t.Errorf("isPostgresAggregateQuery(%q) = %v, want %v", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestIsPostgresMultiStatement(t *testing.T) {
+	tests := []struct {
+		query string
+		want  bool
+	}{
+		{"SELECT * FROM users", false},
+		{"SELECT * FROM users;", false},
+		{"SELECT * FROM users; ;", false},
+		{"SELECT * FROM users; -- done", false},
+		{"SELECT * FROM users; /* done */", false},
+		{"SELECT * FROM users WHERE note = 'a; b'", false},
+		{"SELECT $$;$$", false},
+		{"SELECT * FROM users; SELECT count(*) FROM users", true},
+		{"SELECT count(*) FROM users;DELETE FROM users", true},
+	}
+
+	for _, tt := range tests {
+		if got := isPostgresMultiStatement(tt.query); got != tt.want {
+			t.Errorf("isPostgresMultiStatement(%q) = %v, want %v", tt.query, got, tt.want)
+		}
+	}
+
+	// Every execution path refuses them before running anything
+	db := &Database{Type: "postgresql", Execution: &ExecutionSettings{AllowAggregationsOnly: true}}
+	if _, _, _, err := ExecuteQuery(context.Background(), db, "SELECT * FROM users; SELECT count(*) FROM users"); !errors.Is(err, ErrMultipleStatements) {
+		t.Errorf("ExecuteQuery() = %v, want ErrMultipleStatements", err)
+	}
+}
+
+func TestIsMongoDBAggregateQuery(t *testing.T) {
+	aggregate := func(pipeline string) string {
+		return "var collection = \"orders\"\nvar operation = \"aggregate\"\n*PIPELINE_START mongo.Pipeline{" + pipeline + "} *PIPELINE_END"
+	}
+
+	tests := []struct {
+		name string
+		code string
+		want bool
+	}{
+		{"count documents", `var operation = "countDocuments"`, true},
+		{"find", `var operation = "find"`, false},
+		{"group", aggregate(`bson.D{{"$group", bson.M{"_id": "$status", "n": bson.M{"$sum": 1}}}}`), true},
+		{"group then sort", aggregate(`bson.D{{"$group", bson.M{"_id": "$status"}}}, bson.D{{"$sort", bson.M{"_id": 1}}}`), true},
+		{"match only", aggregate(`bson.D{{"$match", bson.M{"status": "paid"}}}`), false},
+		{"group in a lookup", aggregate(`bson.D{{"$lookup", bson.M{"from": "items", "pipeline": bson.A{bson.M{"$group": bson.M{"_id": 1}}}, "as": "items"}}}`), false},
+		{"lookup after group", aggregate(`bson.D{{"$group", bson.M{"_id": "$user"}}}, bson.D{{"$lookup", bson.M{"from": "users", "localField": "_id", "foreignField": "_id", "as": "user"}}}`), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isMongoDBAggregateQuery(tt.code); got != tt.want {
+				t.Errorf("isMongoDBAggregateQuery() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
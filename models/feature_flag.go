@@ -0,0 +1,81 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// FeatureFlag turns a feature on or off for every organization, with
+// overrides for single organizations. A flag without a stored value falls back
+// to the server's configuration.
+type FeatureFlag struct {
+	Key       string                `json:"key" bson:"_id"`
+	Enabled   *bool                 `json:"enabled,omitempty" bson:"enabled,omitempty"`
+	Overrides []FeatureFlagOverride `json:"overrides" bson:"overrides"`
+	UpdatedBy primitive.ObjectID    `json:"updated_by,omitempty" bson:"updated_by,omitempty"`
+	UpdatedAt time.Time             `json:"updated_at" bson:"updated_at"`
+}
+
+// FeatureFlagOverride turns a feature on or off for a single organization
+type FeatureFlagOverride struct {
+	OrgID   primitive.ObjectID `json:"org_id" bson:"org_id"`
+	Enabled bool               `json:"enabled" bson:"enabled"`
+}
+
+// EnabledFor reports whether the flag is on for an organization, and whether
+// it has a stored value for it at all
+func (f *FeatureFlag) EnabledFor(orgID primitive.ObjectID) (enabled bool, ok bool) {
+	if !orgID.IsZero() {
+		for _, override := range f.Overrides {
+			if override.OrgID == orgID {
+				return override.Enabled, true
+			}
+		}
+	}
+	if f.Enabled != nil {
+		return *f.Enabled, true
+	}
+	return false, false
+}
+
+// featureFlagCollection returns the feature flags collection
+func (s *mongoStore) featureFlagCollection() *mongo.Collection {
+	return s.db.Collection("feature_flags")
+}
+
+// GetFeatureFlags retrieves every stored feature flag
+func (s *mongoStore) GetFeatureFlags(ctx context.Context) ([]*FeatureFlag, error) {
+	cursor, err := s.featureFlagCollection().Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	flags := []*FeatureFlag{}
+	if err := cursor.All(ctx, &flags); err != nil {
+		return nil, err
+	}
+
+	return flags, nil
+}
+
+// SaveFeatureFlag stores a feature flag, replacing its previous value
+func (s *mongoStore) SaveFeatureFlag(ctx context.Context, flag *FeatureFlag) error {
+	if flag.Overrides == nil {
+		flag.Overrides = []FeatureFlagOverride{}
+	}
+	flag.UpdatedAt = time.Now()
+
+	_, err := s.featureFlagCollection().ReplaceOne(
+		ctx,
+		bson.M{"_id": flag.Key},
+		flag,
+		options.Replace().SetUpsert(true),
+	)
+	return err
+}
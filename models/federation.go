@@ -0,0 +1,296 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Limits of a federated query
+const (
+	MinFederatedSources = 2
+	MaxFederatedSources = 5
+)
+
+// FederationLimiterKey stands in for the database when limiting concurrent
+// federated queries, which all run in this process
+const FederationLimiterKey = "federation"
+
+// FederatedQueryTimeout bounds loading the sources and running a federated query
+var FederatedQueryTimeout = 30 * time.Second
+
+// ErrFederationUnavailable is returned when the server was built without the
+// embedded engine federated queries run in
+var ErrFederationUnavailable = errors.New("federated queries aren't available on this server")
+
+// federatedTableRegex matches the table names sources can be given
+var federatedTableRegex = regexp.MustCompile(`^[a-z_][a-z0-9_]{0,62}$`)
+
+// FederatedSource is a saved query whose stored results a federated query
+// reads as a table. Sources can come from different connections and engines.
+type FederatedSource struct {
+	QueryID primitive.ObjectID `json:"query_id" bson:"query_id"`
+	Table   string             `json:"table" bson:"table"`
+}
+
+// FederatedTable is the stored results of a source, typed for loading into
+// the engine a federated query runs in
+type FederatedTable struct {
+	Name    string
+	Columns []FederatedColumn
+	Rows    []QueryResult
+}
+
+// FederatedColumn is a column of a federated table and its engine type
+type FederatedColumn struct {
+	Name string
+	Type string
+}
+
+// Engine types of federated table columns
+const (
+	federatedBoolean   = "BOOLEAN"
+	federatedBigint    = "BIGINT"
+	federatedDouble    = "DOUBLE"
+	federatedTimestamp = "TIMESTAMP"
+	federatedVarchar   = "VARCHAR"
+)
+
+// Federated reports whether the query joins the results of other queries
+// instead of running against a database
+func (q *Query) Federated() bool {
+	return len(q.Sources) > 0
+}
+
+// ValidateFederatedTable checks a source's table name is a plain identifier
+func ValidateFederatedTable(name string) error {
+	if !federatedTableRegex.MatchString(name) {
+		return fmt.Errorf("table name %q must start with a lowercase letter or underscore and contain only lowercase letters, digits and underscores", name)
+	}
+	return nil
+}
+
+// FederatedTableName derives a table name for a source from its query's
+// name, falling back to source_N, and keeps it distinct from taken names
+func FederatedTableName(queryName string, index int, taken map[string]bool) string {
+	var b strings.Builder
+	underscore := false
+	for _, r := range strings.ToLower(queryName) {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			b.WriteRune(r)
+			underscore = false
+		case b.Len() > 0 && !underscore:
+			b.WriteByte('_')
+			underscore = true
+		}
+	}
+	name := strings.Trim(b.String(), "_")
+	if len(name) > 40 {
+		name = strings.TrimRight(name[:40], "_")
+	}
+	if name == "" || name == "query" || ValidateFederatedTable(name) != nil {
+		name = fmt.Sprintf("source_%d", index+1)
+	}
+
+	unique := name
+	for n := 2; taken[unique]; n++ {
+		unique = fmt.Sprintf("%s_%d", name, n)
+	}
+	taken[unique] = true
+	return unique
+}
+
+// LoadFederatedTables loads the stored results of a federated query's
+// sources as tables. Every source must be a completed query the user can
+// access.
+func (s *mongoStore) LoadFederatedTables(ctx context.Context, sources []FederatedSource, userID primitive.ObjectID) ([]FederatedTable, error) {
+	if len(sources) < MinFederatedSources || len(sources) > MaxFederatedSources {
+		return nil, fmt.Errorf("a federated query needs between %d and %d sources", MinFederatedSources, MaxFederatedSources)
+	}
+
+	tables := make([]FederatedTable, 0, len(sources))
+	seen := make(map[string]bool, len(sources))
+	masker := s.NewResultMasker(userID)
+	for _, source := range sources {
+		if err := ValidateFederatedTable(source.Table); err != nil {
+			return nil, err
+		}
+		if seen[source.Table] {
+			return nil, fmt.Errorf("table name %q is used by more than one source", source.Table)
+		}
+		seen[source.Table] = true
+
+		query, err := s.GetQueryByID(ctx, source.QueryID)
+		if err != nil {
+			return nil, err
+		}
+		if query == nil {
+			return nil, fmt.Errorf("source query %s not found", source.QueryID.Hex())
+		}
+
+		allowed, err := s.CanAccessQuery(ctx, query, userID)
+		if err != nil {
+			return nil, err
+		}
+		if !allowed {
+			return nil, fmt.Errorf("you don't have permission to access source query %s", source.QueryID.Hex())
+		}
+		if query.Status != QueryStatusCompleted {
+			return nil, fmt.Errorf("source query %q has no results", source.Table)
+		}
+
+		// Sources are read as the user may see them
+		rows, err := masker.Mask(ctx, query, query.Results)
+		if err != nil {
+			return nil, err
+		}
+		tables = append(tables, NewFederatedTable(source.Table, query.Columns, rows))
+	}
+
+	return tables, nil
+}
+
+// NewFederatedTable types a query's results as a table. Columns keep the
+// query's column order, with fields only some documents have after them.
+func NewFederatedTable(name string, columns []ResultColumn, rows []QueryResult) FederatedTable {
+	names := make([]string, 0, len(columns))
+	dbTypes := make(map[string]string, len(columns))
+	for _, column := range columns {
+		if _, ok := dbTypes[column.Name]; ok {
+			continue
+		}
+		names = append(names, column.Name)
+		dbTypes[column.Name] = column.DBType
+	}
+
+	var extra []string
+	for _, row := range rows {
+		for key := range row {
+			if _, ok := dbTypes[key]; !ok {
+				dbTypes[key] = ""
+				extra = append(extra, key)
+			}
+		}
+	}
+	sort.Strings(extra)
+	names = append(names, extra...)
+
+	table := FederatedTable{Name: name, Columns: make([]FederatedColumn, len(names)), Rows: rows}
+	for i, column := range names {
+		table.Columns[i] = FederatedColumn{Name: column, Type: federatedColumnType(rows, column, dbTypes[column])}
+	}
+	return table
+}
+
+// federatedColumnType picks the narrowest engine type every value of a
+// column converts to. Postgres numerics are stored as strings, so they are
+// read as numbers when the column's type says so.
+func federatedColumnType(rows []QueryResult, column, dbType string) string {
+	numeric := strings.EqualFold(dbType, "NUMERIC") || strings.EqualFold(dbType, "DECIMAL")
+
+	kind := ""
+	for _, row := range rows {
+		value := row[column]
+		if value == nil {
+			continue
+		}
+
+		var valueKind string
+		switch v := value.(type) {
+		case bool:
+			valueKind = federatedBoolean
+		case int, int32, int64:
+			valueKind = federatedBigint
+		case float32, float64, primitive.Decimal128:
+			valueKind = federatedDouble
+		case time.Time, primitive.DateTime:
+			valueKind = federatedTimestamp
+		case string:
+			valueKind = federatedVarchar
+			if numeric {
+				if _, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil {
+					valueKind = federatedDouble
+				}
+			}
+		default:
+			valueKind = federatedVarchar
+		}
+
+		switch {
+		case kind == "" || kind == valueKind:
+			kind = valueKind
+		case (kind == federatedBigint && valueKind == federatedDouble) || (kind == federatedDouble && valueKind == federatedBigint):
+			kind = federatedDouble
+		default:
+			return federatedVarchar
+		}
+	}
+
+	if kind == "" {
+		return federatedVarchar
+	}
+	return kind
+}
+
+// Value converts a stored result value to what the engine expects for the
+// column
+func (c FederatedColumn) Value(value interface{}) interface{} {
+	if value == nil {
+		return nil
+	}
+
+	switch c.Type {
+	case federatedBigint:
+		switch v := value.(type) {
+		case int:
+			return int64(v)
+		case int32:
+			return int64(v)
+		}
+	case federatedDouble:
+		if f, ok := toFloat(value); ok {
+			return f
+		}
+		return nil
+	case federatedTimestamp:
+		if t, ok := toTime(value); ok {
+			return t.UTC()
+		}
+		return nil
+	case federatedVarchar:
+		switch v := value.(type) {
+		case string:
+			return v
+		case primitive.ObjectID:
+			return v.Hex()
+		case time.Time:
+			return v.UTC().Format(time.RFC3339Nano)
+		case primitive.DateTime:
+			return v.Time().UTC().Format(time.RFC3339Nano)
+		case primitive.Decimal128:
+			return v.String()
+		case bool, int, int32, int64, float32, float64:
+			return fmt.Sprint(v)
+		case primitive.D:
+			// Documents keep their field order as JSON objects
+			if data, err := bson.MarshalExtJSON(v, false, false); err == nil {
+				return string(data)
+			}
+		}
+		if data, err := json.Marshal(sanitizeJSONValue(value)); err == nil {
+			return string(data)
+		}
+		return fmt.Sprint(value)
+	}
+	return value
+}
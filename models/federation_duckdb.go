@@ -0,0 +1,229 @@
+//go:build cgo
+
+package models
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/marcboeker/go-duckdb"
+)
+
+// Resources a federated query may use in the embedded engine
+const (
+	federatedMemoryLimit = "512MB"
+	federatedThreads     = 2
+)
+
+// ExecuteFederatedQuery loads tables into a fresh in-memory DuckDB instance
+// and runs a query over them. The instance can't read files or the network,
+// and is discarded afterwards.
+func ExecuteFederatedQuery(ctx context.Context, tables []FederatedTable, query string) ([]QueryResult, []ResultColumn, string, error) {
+	startTime := time.Now()
+
+	ctx, cancel := context.WithTimeout(ctx, FederatedQueryTimeout)
+	defer cancel()
+
+	db, err := sql.Open("duckdb", "")
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("failed to start the federation engine: %v", err)
+	}
+	defer db.Close()
+
+	// A single connection so settings apply to everything that runs
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("failed to start the federation engine: %v", err)
+	}
+	defer conn.Close()
+
+	settings := []string{
+		fmt.Sprintf("SET memory_limit = '%s'", federatedMemoryLimit),
+		fmt.Sprintf("SET threads = %d", federatedThreads),
+	}
+	for _, setting := range settings {
+		if _, err := conn.ExecContext(ctx, setting); err != nil {
+			return nil, nil, "", fmt.Errorf("failed to configure the federation engine: %v", err)
+		}
+	}
+
+	for _, table := range tables {
+		if err := loadFederatedTable(ctx, conn, table); err != nil {
+			return nil, nil, "", fmt.Errorf("failed to load table %s: %v", table.Name, err)
+		}
+	}
+
+	// The generated query only sees the loaded tables
+	for _, setting := range []string{"SET enable_external_access = false", "SET lock_configuration = true"} {
+		if _, err := conn.ExecContext(ctx, setting); err != nil {
+			return nil, nil, "", fmt.Errorf("failed to configure the federation engine: %v", err)
+		}
+	}
+
+	rows, err := conn.QueryContext(ctx, query)
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("failed to execute query: %v", err)
+	}
+	defer rows.Close()
+
+	columns, results, err := readFederatedRows(rows, MaxResultRows)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	if len(results) >= MaxResultRows {
+		log.Printf("Federated query results truncated to %d rows", MaxResultRows)
+	}
+
+	return results, columns, time.Since(startTime).String(), nil
+}
+
+// loadFederatedTable creates a table and inserts its rows in one transaction
+func loadFederatedTable(ctx context.Context, conn *sql.Conn, table FederatedTable) error {
+	definitions := make([]string, len(table.Columns))
+	placeholders := make([]string, len(table.Columns))
+	for i, column := range table.Columns {
+		definitions[i] = quoteFederatedIdentifier(column.Name) + " " + column.Type
+		placeholders[i] = "?"
+	}
+
+	name := quoteFederatedIdentifier(table.Name)
+	if _, err := conn.ExecContext(ctx, fmt.Sprintf("CREATE TABLE %s (%s)", name, strings.Join(definitions, ", "))); err != nil {
+		return err
+	}
+	if len(table.Rows) == 0 || len(table.Columns) == 0 {
+		return nil
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, fmt.Sprintf("INSERT INTO %s VALUES (%s)", name, strings.Join(placeholders, ", ")))
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	values := make([]interface{}, len(table.Columns))
+	for _, row := range table.Rows {
+		for i, column := range table.Columns {
+			values[i] = column.Value(row[column.Name])
+		}
+		if _, err := stmt.ExecContext(ctx, values...); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// quoteFederatedIdentifier quotes a table or column name for DuckDB
+func quoteFederatedIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// readFederatedRows reads up to limit rows of a federated query's result
+func readFederatedRows(rows *sql.Rows, limit int) ([]ResultColumn, []QueryResult, error) {
+	columnTypes, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get column types: %v", err)
+	}
+
+	columns := make([]ResultColumn, len(columnTypes))
+	for i, columnType := range columnTypes {
+		columns[i] = ResultColumn{
+			Name:     columnType.Name(),
+			DBType:   columnType.DatabaseTypeName(),
+			Nullable: true,
+		}
+	}
+
+	var results []QueryResult
+	values := make([]interface{}, len(columns))
+	valuePtrs := make([]interface{}, len(columns))
+	for i := range values {
+		valuePtrs[i] = &values[i]
+	}
+
+	for len(results) < limit && rows.Next() {
+		if err := rows.Scan(valuePtrs...); err != nil {
+			return nil, nil, fmt.Errorf("failed to scan row: %v", err)
+		}
+
+		row := make(QueryResult, len(columns))
+		for i, column := range columns {
+			if column.DBType == "UUID" {
+				if b, ok := values[i].([]byte); ok && len(b) == 16 {
+					row[column.Name] = fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+					continue
+				}
+			}
+			row[column.Name] = federatedResult(values[i])
+		}
+		results = append(results, row)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("error iterating over rows: %v", err)
+	}
+
+	return columns, results, nil
+}
+
+// federatedResult converts a DuckDB value to one that can be stored and
+// encoded as JSON
+func federatedResult(value interface{}) interface{} {
+	switch v := value.(type) {
+	case *big.Int:
+		// Sums of integers are 128-bit
+		if v.IsInt64() {
+			return v.Int64()
+		}
+		f, _ := new(big.Float).SetInt(v).Float64()
+		return f
+	case duckdb.Decimal:
+		return v.Float64()
+	case duckdb.Interval:
+		return fmt.Sprintf("%d months %d days %s", v.Months, v.Days, time.Duration(v.Micros)*time.Microsecond)
+	case []byte:
+		return string(v)
+	case uint8:
+		return int64(v)
+	case uint16:
+		return int64(v)
+	case uint32:
+		return int64(v)
+	case uint64:
+		if v <= 1<<63-1 {
+			return int64(v)
+		}
+		return float64(v)
+	case []interface{}:
+		converted := make([]interface{}, len(v))
+		for i, item := range v {
+			converted[i] = federatedResult(item)
+		}
+		return converted
+	case map[string]interface{}:
+		converted := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			converted[key] = federatedResult(item)
+		}
+		return converted
+	case duckdb.Map:
+		converted := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			converted[fmt.Sprint(key)] = federatedResult(item)
+		}
+		return converted
+	default:
+		return v
+	}
+}
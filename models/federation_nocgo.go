@@ -0,0 +1,11 @@
+//go:build !cgo
+
+package models
+
+import "context"
+
+// ExecuteFederatedQuery can't run without cgo, which the embedded DuckDB
+// engine needs
+func ExecuteFederatedQuery(ctx context.Context, tables []FederatedTable, query string) ([]QueryResult, []ResultColumn, string, error) {
+	return nil, nil, "", ErrFederationUnavailable
+}
@@ -0,0 +1,312 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// DefaultFreshnessInterval is how often a freshness check probes its table
+// unless it says otherwise
+const DefaultFreshnessInterval = 5 * time.Minute
+
+// FreshnessState represents whether a table's data is as recent as expected
+type FreshnessState string
+
+const (
+	FreshnessUnknown FreshnessState = "unknown" // Not probed successfully yet
+	FreshnessFresh   FreshnessState = "fresh"
+	FreshnessStale   FreshnessState = "stale"
+)
+
+// FreshnessCheck declares how recent the data in a table is expected to be,
+// e.g. orders should have rows newer than 1 hour. A monitor probes the
+// newest value of a timestamp column on schedule and notifies the check's
+// channels when the data goes stale and when it is fresh again.
+type FreshnessCheck struct {
+	ID            primitive.ObjectID   `json:"id" bson:"_id,omitempty"`
+	DatabaseID    primitive.ObjectID   `json:"database_id" bson:"database_id"`
+	UserID        primitive.ObjectID   `json:"user_id" bson:"user_id"`
+	Table         string               `json:"table" bson:"table"`
+	Column        string               `json:"column" bson:"column"`                               // A timestamp column, or a field of a MongoDB collection
+	MaxAge        int                  `json:"max_age" bson:"max_age"`                             // Seconds the newest row may be old before the data is stale
+	Interval      int                  `json:"interval" bson:"interval"`                           // Seconds between probes
+	ChannelIDs    []primitive.ObjectID `json:"channel_ids,omitempty" bson:"channel_ids,omitempty"` // Notification channels told when the state changes
+	Enabled       bool                 `json:"enabled" bson:"enabled"`
+	State         FreshnessState       `json:"state" bson:"state"`
+	LatestAt      *time.Time           `json:"latest_at,omitempty" bson:"latest_at,omitempty"`   // Newest value found by the last successful probe
+	LastError     string               `json:"last_error,omitempty" bson:"last_error,omitempty"` // Why the last probe failed
+	LastCheckedAt *time.Time           `json:"last_checked_at,omitempty" bson:"last_checked_at,omitempty"`
+	StaleSince    *time.Time           `json:"stale_since,omitempty" bson:"stale_since,omitempty"`
+	NextCheckAt   time.Time            `json:"next_check_at" bson:"next_check_at"`
+	CreatedAt     time.Time            `json:"created_at" bson:"created_at"`
+	UpdatedAt     time.Time            `json:"updated_at" bson:"updated_at"`
+}
+
+// Describe returns the expectation in words, e.g. "orders.created_at newer than 1h0m0s"
+func (c *FreshnessCheck) Describe() string {
+	return fmt.Sprintf("%s.%s newer than %s", c.Table, c.Column, time.Duration(c.MaxAge)*time.Second)
+}
+
+// Judge records the newest value a probe found, or why it failed, and
+// updates the state. A failed probe leaves the state as it was.
+func (c *FreshnessCheck) Judge(latest *time.Time, probeErr error, now time.Time) {
+	c.LastCheckedAt = &now
+	if probeErr != nil {
+		c.LastError = probeErr.Error()
+		return
+	}
+
+	c.LastError = ""
+	c.LatestAt = latest
+	maxAge := time.Duration(c.MaxAge) * time.Second
+
+	// An empty table has no recent rows either
+	if latest == nil || now.Sub(*latest) > maxAge {
+		if c.State != FreshnessStale {
+			c.StaleSince = &now
+		}
+		c.State = FreshnessStale
+		return
+	}
+
+	c.State = FreshnessFresh
+	c.StaleSince = nil
+}
+
+// ValidateFreshnessTarget checks a freshness check can probe a table's
+// column: the table must be visible and, when the schema is known, have the
+// column with a date or timestamp type. MongoDB fields aren't checked, their
+// types vary between documents.
+func (db *Database) ValidateFreshnessTarget(table, column string) error {
+	if !db.TableVisible(table) {
+		return fmt.Errorf("table %s is hidden on this connection", table)
+	}
+	if db.Schema == nil || len(db.Schema.Tables) == 0 {
+		return nil
+	}
+
+	for _, t := range db.Schema.Tables {
+		if t.Name != table {
+			continue
+		}
+		if db.Type != "postgresql" {
+			return nil
+		}
+		for _, c := range t.Columns {
+			if c.Name != column {
+				continue
+			}
+			columnType := strings.ToLower(c.Type)
+			if !strings.HasPrefix(columnType, "timestamp") && columnType != "date" {
+				return fmt.Errorf("column %s has type %s, not a date or timestamp", column, c.Type)
+			}
+			return nil
+		}
+		return fmt.Errorf("column %s isn't in table %s", column, table)
+	}
+	return fmt.Errorf("table %s isn't in the schema", table)
+}
+
+// FetchLatestTimestamp returns the newest value of a timestamp column in a
+// table, nil when the table has no rows with one
+func FetchLatestTimestamp(ctx context.Context, db *Database, table, column string) (*time.Time, error) {
+	ctx, cancel := context.WithTimeout(ctx, db.queryTimeout(30*time.Second))
+	defer cancel()
+
+	switch db.Type {
+	case "postgresql":
+		return fetchPostgresLatest(ctx, db, table, column)
+	case "mongodb":
+		return fetchMongoDBLatest(ctx, db, table, column)
+	default:
+		return nil, fmt.Errorf("unsupported database type: %s", db.Type)
+	}
+}
+
+// freshnessCheckCollection returns the freshness checks collection
+func (s *mongoStore) freshnessCheckCollection() *mongo.Collection {
+	return s.db.Collection("freshness_checks")
+}
+
+// ensureFreshnessCheckIndexes creates the indexes freshness checks are
+// listed and probed by
+func (s *mongoStore) ensureFreshnessCheckIndexes(ctx context.Context) error {
+	_, err := s.freshnessCheckCollection().Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "database_id", Value: 1}, {Key: "created_at", Value: 1}},
+			Options: options.Index().SetName("freshness_check_database"),
+		},
+		{
+			Keys:    bson.D{{Key: "enabled", Value: 1}, {Key: "next_check_at", Value: 1}},
+			Options: options.Index().SetName("freshness_check_due"),
+		},
+	})
+	return err
+}
+
+// CreateFreshnessCheck creates a new freshness check, probed right away
+func (s *mongoStore) CreateFreshnessCheck(ctx context.Context, check *FreshnessCheck) (*FreshnessCheck, error) {
+	// Set timestamps
+	now := time.Now()
+	check.State = FreshnessUnknown
+	check.NextCheckAt = now
+	check.CreatedAt = now
+	check.UpdatedAt = now
+
+	result, err := s.freshnessCheckCollection().InsertOne(ctx, check)
+	if err != nil {
+		return nil, err
+	}
+
+	// Set the ID
+	check.ID = result.InsertedID.(primitive.ObjectID)
+
+	return check, nil
+}
+
+// GetFreshnessCheckByID retrieves a freshness check by ID
+func (s *mongoStore) GetFreshnessCheckByID(ctx context.Context, id primitive.ObjectID) (*FreshnessCheck, error) {
+	var check FreshnessCheck
+	err := s.freshnessCheckCollection().FindOne(ctx, bson.M{"_id": id}).Decode(&check)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &check, nil
+}
+
+// GetFreshnessChecksByDatabaseID retrieves the freshness checks of a database
+func (s *mongoStore) GetFreshnessChecksByDatabaseID(ctx context.Context, databaseID primitive.ObjectID) ([]*FreshnessCheck, error) {
+	opts := options.Find().SetSort(bson.M{"created_at": 1})
+	cursor, err := s.freshnessCheckCollection().Find(ctx, bson.M{"database_id": databaseID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	checks := []*FreshnessCheck{}
+	if err := cursor.All(ctx, &checks); err != nil {
+		return nil, err
+	}
+
+	return checks, nil
+}
+
+// GetDueFreshnessChecks retrieves the enabled checks whose next probe has passed
+func (s *mongoStore) GetDueFreshnessChecks(ctx context.Context, now time.Time) ([]*FreshnessCheck, error) {
+	cursor, err := s.freshnessCheckCollection().Find(ctx, bson.M{
+		"enabled":       true,
+		"next_check_at": bson.M{"$lte": now},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var checks []*FreshnessCheck
+	if err := cursor.All(ctx, &checks); err != nil {
+		return nil, err
+	}
+
+	return checks, nil
+}
+
+// ClaimFreshnessCheck advances a due check to its next probe time. It
+// returns false when another worker already claimed this probe.
+func (s *mongoStore) ClaimFreshnessCheck(ctx context.Context, check *FreshnessCheck, now time.Time) (bool, error) {
+	nextCheck := now.Add(time.Duration(check.Interval) * time.Second)
+
+	result, err := s.freshnessCheckCollection().UpdateOne(
+		ctx,
+		bson.M{"_id": check.ID, "next_check_at": check.NextCheckAt},
+		bson.M{"$set": bson.M{"next_check_at": nextCheck}},
+	)
+	if err != nil {
+		return false, err
+	}
+	if result.MatchedCount == 0 {
+		return false, nil
+	}
+
+	check.NextCheckAt = nextCheck
+	return true, nil
+}
+
+// SaveFreshnessResult stores the outcome of a probe. It reports false without
+// saving when the check's state changed since it was read as previous, e.g.
+// because its settings were updated meanwhile.
+func (s *mongoStore) SaveFreshnessResult(ctx context.Context, check *FreshnessCheck, previous FreshnessState) (bool, error) {
+	set := bson.M{
+		"state":           check.State,
+		"last_error":      check.LastError,
+		"last_checked_at": check.LastCheckedAt,
+	}
+	unset := bson.M{}
+	if check.LatestAt != nil {
+		set["latest_at"] = check.LatestAt
+	} else {
+		unset["latest_at"] = ""
+	}
+	if check.StaleSince != nil {
+		set["stale_since"] = check.StaleSince
+	} else {
+		unset["stale_since"] = ""
+	}
+
+	update := bson.M{"$set": set}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+
+	result, err := s.freshnessCheckCollection().UpdateOne(ctx, bson.M{"_id": check.ID, "state": previous}, update)
+	if err != nil {
+		return false, err
+	}
+
+	return result.MatchedCount > 0, nil
+}
+
+// UpdateFreshnessCheck updates a check's settings. Changing what it probes
+// starts it over from the unknown state, probed right away.
+func (s *mongoStore) UpdateFreshnessCheck(ctx context.Context, check *FreshnessCheck, targetChanged bool) error {
+	check.UpdatedAt = time.Now()
+
+	set := bson.M{
+		"table":       check.Table,
+		"column":      check.Column,
+		"max_age":     check.MaxAge,
+		"interval":    check.Interval,
+		"channel_ids": check.ChannelIDs,
+		"enabled":     check.Enabled,
+		"updated_at":  check.UpdatedAt,
+	}
+	update := bson.M{"$set": set}
+	if targetChanged {
+		check.State = FreshnessUnknown
+		check.LatestAt = nil
+		check.LastError = ""
+		check.StaleSince = nil
+		check.NextCheckAt = check.UpdatedAt
+		set["state"] = check.State
+		set["next_check_at"] = check.NextCheckAt
+		update["$unset"] = bson.M{"latest_at": "", "last_error": "", "stale_since": ""}
+	}
+
+	_, err := s.freshnessCheckCollection().UpdateOne(ctx, bson.M{"_id": check.ID}, update)
+	return err
+}
+
+// DeleteFreshnessCheck deletes a freshness check
+func (s *mongoStore) DeleteFreshnessCheck(ctx context.Context, id primitive.ObjectID) error {
+	_, err := s.freshnessCheckCollection().DeleteOne(ctx, bson.M{"_id": id})
+	return err
+}
@@ -0,0 +1,286 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// GoogleConnection is a user's authorization to write spreadsheets to their
+// Google account. Only spreadsheets created through it can be written.
+type GoogleConnection struct {
+	ID           primitive.ObjectID `json:"-" bson:"_id,omitempty"`
+	UserID       primitive.ObjectID `json:"-" bson:"user_id"`
+	Email        string             `json:"email" bson:"email"`
+	AccessToken  string             `json:"-" bson:"access_token"`
+	RefreshToken string             `json:"-" bson:"refresh_token"`
+	TokenType    string             `json:"-" bson:"token_type"`
+	Expiry       time.Time          `json:"-" bson:"expiry"`
+	ConnectedAt  time.Time          `json:"connected_at" bson:"connected_at"`
+}
+
+// SheetExport writes a query's results to a Google spreadsheet of the user
+// who set it up, on demand and, with a cron expression, on a schedule. Each
+// export replaces the spreadsheet's first sheet.
+type SheetExport struct {
+	ID             primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	QueryID        primitive.ObjectID `json:"query_id" bson:"query_id"`
+	UserID         primitive.ObjectID `json:"user_id" bson:"user_id"`
+	Title          string             `json:"title" bson:"title"`
+	SpreadsheetID  string             `json:"spreadsheet_id,omitempty" bson:"spreadsheet_id,omitempty"`
+	SpreadsheetURL string             `json:"spreadsheet_url,omitempty" bson:"spreadsheet_url,omitempty"`
+	Cron           string             `json:"cron,omitempty" bson:"cron,omitempty"` // Reruns the query and exports the results, empty for on demand only
+	Timezone       string             `json:"timezone,omitempty" bson:"timezone,omitempty"`
+	NextRunAt      *time.Time         `json:"next_run_at,omitempty" bson:"next_run_at,omitempty"`
+	LastExportedAt *time.Time         `json:"last_exported_at,omitempty" bson:"last_exported_at,omitempty"` // Last successful export
+	LastRowCount   int                `json:"last_row_count" bson:"last_row_count"`
+	LastError      string             `json:"last_error,omitempty" bson:"last_error,omitempty"` // Why the last export failed, empty when it succeeded
+	CreatedAt      time.Time          `json:"created_at" bson:"created_at"`
+	UpdatedAt      time.Time          `json:"updated_at" bson:"updated_at"`
+}
+
+// Scheduled reports whether the export runs on a schedule
+func (e *SheetExport) Scheduled() bool {
+	return e.Cron != ""
+}
+
+// googleConnectionCollection returns the Google connections collection
+func (s *mongoStore) googleConnectionCollection() *mongo.Collection {
+	return s.db.Collection("google_connections")
+}
+
+// sheetExportCollection returns the sheet exports collection
+func (s *mongoStore) sheetExportCollection() *mongo.Collection {
+	return s.db.Collection("sheet_exports")
+}
+
+// ensureGoogleSheetsIndexes keeps one Google connection per user and one
+// export per query and user, and indexes exports by when they are due
+func (s *mongoStore) ensureGoogleSheetsIndexes(ctx context.Context) error {
+	_, err := s.googleConnectionCollection().Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "user_id", Value: 1}},
+			Options: options.Index().SetName("google_connection_user").SetUnique(true),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = s.sheetExportCollection().Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "query_id", Value: 1}, {Key: "user_id", Value: 1}},
+			Options: options.Index().SetName("sheet_export_query").SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "next_run_at", Value: 1}},
+			Options: options.Index().SetName("sheet_export_due").SetSparse(true),
+		},
+	})
+	return err
+}
+
+// SaveGoogleConnection saves a user's Google connection, replacing the one
+// they had
+func (s *mongoStore) SaveGoogleConnection(ctx context.Context, conn *GoogleConnection) error {
+	opts := options.FindOneAndReplace().
+		SetUpsert(true).
+		SetReturnDocument(options.After).
+		SetProjection(bson.M{"_id": 1})
+
+	var saved struct {
+		ID primitive.ObjectID `bson:"_id"`
+	}
+	conn.ID = primitive.NilObjectID
+	if err := s.googleConnectionCollection().FindOneAndReplace(ctx, bson.M{"user_id": conn.UserID}, conn, opts).Decode(&saved); err != nil {
+		return err
+	}
+
+	// Set the ID
+	conn.ID = saved.ID
+
+	return nil
+}
+
+// GetGoogleConnection retrieves a user's Google connection
+func (s *mongoStore) GetGoogleConnection(ctx context.Context, userID primitive.ObjectID) (*GoogleConnection, error) {
+	var conn GoogleConnection
+	err := s.googleConnectionCollection().FindOne(ctx, bson.M{"user_id": userID}).Decode(&conn)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &conn, nil
+}
+
+// UpdateGoogleToken saves the refreshed token of a Google connection
+func (s *mongoStore) UpdateGoogleToken(ctx context.Context, conn *GoogleConnection) error {
+	_, err := s.googleConnectionCollection().UpdateOne(
+		ctx,
+		bson.M{"user_id": conn.UserID},
+		bson.M{"$set": bson.M{
+			"access_token":  conn.AccessToken,
+			"refresh_token": conn.RefreshToken,
+			"token_type":    conn.TokenType,
+			"expiry":        conn.Expiry,
+		}},
+	)
+	return err
+}
+
+// DeleteGoogleConnection removes a user's Google connection. Their exports
+// are kept and fail until they connect again.
+func (s *mongoStore) DeleteGoogleConnection(ctx context.Context, userID primitive.ObjectID) error {
+	_, err := s.googleConnectionCollection().DeleteOne(ctx, bson.M{"user_id": userID})
+	return err
+}
+
+// GetSheetExport retrieves a user's export of a query
+func (s *mongoStore) GetSheetExport(ctx context.Context, queryID, userID primitive.ObjectID) (*SheetExport, error) {
+	var export SheetExport
+	err := s.sheetExportCollection().FindOne(ctx, bson.M{"query_id": queryID, "user_id": userID}).Decode(&export)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &export, nil
+}
+
+// SaveSheetExport creates or updates an export and recomputes its next run
+func (s *mongoStore) SaveSheetExport(ctx context.Context, export *SheetExport) error {
+	now := time.Now()
+	export.UpdatedAt = now
+	if export.CreatedAt.IsZero() {
+		export.CreatedAt = now
+	}
+
+	export.NextRunAt = nil
+	if export.Scheduled() {
+		nextRun, err := NextReportRun(export.Cron, export.Timezone, now)
+		if err != nil {
+			return err
+		}
+		export.NextRunAt = &nextRun
+	}
+
+	opts := options.FindOneAndReplace().
+		SetUpsert(true).
+		SetReturnDocument(options.After).
+		SetProjection(bson.M{"_id": 1})
+
+	var saved struct {
+		ID primitive.ObjectID `bson:"_id"`
+	}
+	export.ID = primitive.NilObjectID
+	filter := bson.M{"query_id": export.QueryID, "user_id": export.UserID}
+	if err := s.sheetExportCollection().FindOneAndReplace(ctx, filter, export, opts).Decode(&saved); err != nil {
+		return err
+	}
+
+	// Set the ID
+	export.ID = saved.ID
+
+	return nil
+}
+
+// RecordSheetExport saves the outcome of an export: the spreadsheet written
+// and row count when it succeeded, the error when it failed
+func (s *mongoStore) RecordSheetExport(ctx context.Context, export *SheetExport, rowCount int, exportErr error, now time.Time) error {
+	set := bson.M{
+		"spreadsheet_id":  export.SpreadsheetID,
+		"spreadsheet_url": export.SpreadsheetURL,
+		"updated_at":      now,
+	}
+	update := bson.M{"$set": set}
+	if exportErr != nil {
+		set["last_error"] = exportErr.Error()
+	} else {
+		set["last_exported_at"] = now
+		set["last_row_count"] = rowCount
+		update["$unset"] = bson.M{"last_error": ""}
+	}
+
+	if _, err := s.sheetExportCollection().UpdateOne(ctx, bson.M{"_id": export.ID}, update); err != nil {
+		return err
+	}
+
+	export.UpdatedAt = now
+	if exportErr != nil {
+		export.LastError = exportErr.Error()
+	} else {
+		export.LastExportedAt = &now
+		export.LastRowCount = rowCount
+		export.LastError = ""
+	}
+	return nil
+}
+
+// GetDueSheetExports retrieves scheduled exports whose next run is due
+func (s *mongoStore) GetDueSheetExports(ctx context.Context, now time.Time) ([]*SheetExport, error) {
+	cursor, err := s.sheetExportCollection().Find(ctx, bson.M{"next_run_at": bson.M{"$lte": now}})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var exports []*SheetExport
+	if err := cursor.All(ctx, &exports); err != nil {
+		return nil, err
+	}
+
+	return exports, nil
+}
+
+// ClaimSheetExport advances a due export to its next run time. It returns
+// false when another worker already claimed this run.
+func (s *mongoStore) ClaimSheetExport(ctx context.Context, export *SheetExport, now time.Time) (bool, error) {
+	nextRun, err := NextReportRun(export.Cron, export.Timezone, now)
+	if err != nil {
+		return false, err
+	}
+
+	result, err := s.sheetExportCollection().UpdateOne(
+		ctx,
+		bson.M{"_id": export.ID, "next_run_at": export.NextRunAt},
+		bson.M{"$set": bson.M{"next_run_at": nextRun}},
+	)
+	if err != nil {
+		return false, err
+	}
+
+	if result.ModifiedCount == 0 {
+		return false, nil
+	}
+
+	export.NextRunAt = &nextRun
+	return true, nil
+}
+
+// UnscheduleSheetExport stops a schedule that can no longer run, keeping the
+// export for running on demand
+func (s *mongoStore) UnscheduleSheetExport(ctx context.Context, id primitive.ObjectID) error {
+	_, err := s.sheetExportCollection().UpdateOne(
+		ctx,
+		bson.M{"_id": id},
+		bson.M{
+			"$unset": bson.M{"cron": "", "timezone": "", "next_run_at": ""},
+			"$set":   bson.M{"updated_at": time.Now()},
+		},
+	)
+	return err
+}
+
+// DeleteSheetExport removes a user's export of a query. The spreadsheet is
+// left in their Google account.
+func (s *mongoStore) DeleteSheetExport(ctx context.Context, queryID, userID primitive.ObjectID) error {
+	_, err := s.sheetExportCollection().DeleteOne(ctx, bson.M{"query_id": queryID, "user_id": userID})
+	return err
+}
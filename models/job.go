@@ -0,0 +1,355 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// JobStatus represents where a background job is in its lifecycle
+type JobStatus string
+
+const (
+	JobPending   JobStatus = "pending"   // Waiting for its run time or a worker
+	JobRunning   JobStatus = "running"   // Leased by a worker
+	JobSucceeded JobStatus = "succeeded" // Finished
+	JobDead      JobStatus = "dead"      // Failed on every attempt, kept until retried by hand
+)
+
+// DefaultJobMaxAttempts is the number of times a job is tried when it doesn't
+// set its own limit
+const DefaultJobMaxAttempts = 5
+
+// Error codes of dropping an index that doesn't exist, on a collection that
+// does and one that doesn't
+const (
+	mongoDBIndexNotFound     = 27
+	mongoDBNamespaceNotFound = 26
+)
+
+// ErrJobKeyActive is returned when retrying a job while another job with the
+// same key is pending or running
+var ErrJobKeyActive = errors.New("another job with the same key is pending or running")
+
+// Job is a unit of background work that survives restarts. Workers lease a
+// job while they run it; a job whose lease runs out, e.g. because its server
+// died, is picked up again by another worker.
+type Job struct {
+	ID   primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Type string             `json:"type" bson:"type"`
+	// Payload holds the job's arguments, see DecodePayload
+	Payload bson.M `json:"payload,omitempty" bson:"payload,omitempty"`
+	// Key de-duplicates jobs: a job isn't enqueued while another with the
+	// same key is pending or running
+	Key         string     `json:"key,omitempty" bson:"key,omitempty"`
+	Status      JobStatus  `json:"status" bson:"status"`
+	Attempts    int        `json:"attempts" bson:"attempts"`
+	MaxAttempts int        `json:"max_attempts" bson:"max_attempts"`
+	RunAt       time.Time  `json:"run_at" bson:"run_at"`
+	LeaseOwner  string     `json:"lease_owner,omitempty" bson:"lease_owner,omitempty"`
+	LeasedUntil *time.Time `json:"leased_until,omitempty" bson:"leased_until,omitempty"`
+	LastError   string     `json:"last_error,omitempty" bson:"last_error,omitempty"`
+	CreatedAt   time.Time  `json:"created_at" bson:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at" bson:"updated_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty" bson:"completed_at,omitempty"`
+}
+
+// jobCollection returns the jobs collection
+func (s *mongoStore) jobCollection() *mongo.Collection {
+	return s.db.Collection("jobs")
+}
+
+// ensureJobIndexes creates the indexes workers claim jobs with, keeps a
+// single pending or running job per key, and expires finished jobs after a
+// week
+func (s *mongoStore) ensureJobIndexes(ctx context.Context) error {
+	// Replaced by job_active_key, which also keeps keys unique
+	if _, err := s.jobCollection().Indexes().DropOne(ctx, "job_key"); err != nil {
+		var serverErr mongo.ServerError
+		if !errors.As(err, &serverErr) || !(serverErr.HasErrorCode(mongoDBNamespaceNotFound) || serverErr.HasErrorCode(mongoDBIndexNotFound)) {
+			return err
+		}
+	}
+
+	_, err := s.jobCollection().Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "status", Value: 1}, {Key: "type", Value: 1}, {Key: "run_at", Value: 1}},
+			Options: options.Index().SetName("job_claim"),
+		},
+		{
+			Keys: bson.D{{Key: "key", Value: 1}},
+			Options: options.Index().SetName("job_active_key").SetUnique(true).SetPartialFilterExpression(bson.M{
+				"key":    bson.M{"$exists": true},
+				"status": bson.M{"$in": []JobStatus{JobPending, JobRunning}},
+			}),
+		},
+		{
+			Keys:    bson.D{{Key: "completed_at", Value: 1}},
+			Options: options.Index().SetName("job_expiry").SetExpireAfterSeconds(int32((7 * 24 * time.Hour).Seconds())),
+		},
+	})
+	return err
+}
+
+// DecodePayload decodes a job's payload into v, the inverse of the payload
+// passed to EnqueueJob
+func (j *Job) DecodePayload(v interface{}) error {
+	data, err := bson.Marshal(j.Payload)
+	if err != nil {
+		return err
+	}
+	return bson.Unmarshal(data, v)
+}
+
+// EnqueueJob adds a job of the given type that runs at runAt, or right away
+// when runAt is zero. The payload is any value that encodes to a BSON
+// document. With a key, an existing pending or running job with the same key
+// is returned instead of adding another. The key's unique index settles
+// concurrent calls, the losers get the job of the winner.
+func (s *mongoStore) EnqueueJob(ctx context.Context, jobType string, payload interface{}, key string, runAt time.Time) (*Job, error) {
+	job := &Job{
+		Type:        jobType,
+		Key:         key,
+		Status:      JobPending,
+		MaxAttempts: DefaultJobMaxAttempts,
+		RunAt:       runAt,
+	}
+
+	if payload != nil {
+		data, err := bson.Marshal(payload)
+		if err != nil {
+			return nil, err
+		}
+		if err := bson.Unmarshal(data, &job.Payload); err != nil {
+			return nil, err
+		}
+	}
+
+	// Set timestamps
+	now := time.Now()
+	job.CreatedAt = now
+	job.UpdatedAt = now
+	if job.RunAt.IsZero() {
+		job.RunAt = now
+	}
+
+	if key != "" {
+		existing, err := s.getActiveJobByKey(ctx, key)
+		if err != nil || existing != nil {
+			return existing, err
+		}
+	}
+
+	result, err := s.jobCollection().InsertOne(ctx, job)
+	if mongo.IsDuplicateKeyError(err) && key != "" {
+		// Another call queued the same key since the check above
+		existing, err := s.getActiveJobByKey(ctx, key)
+		if err == nil && existing == nil {
+			err = ErrJobKeyActive
+		}
+		return existing, err
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Set the ID
+	job.ID = result.InsertedID.(primitive.ObjectID)
+
+	return job, nil
+}
+
+// getActiveJobByKey retrieves the pending or running job with a key
+func (s *mongoStore) getActiveJobByKey(ctx context.Context, key string) (*Job, error) {
+	var job Job
+	err := s.jobCollection().FindOne(ctx, bson.M{
+		"key":    key,
+		"status": bson.M{"$in": []JobStatus{JobPending, JobRunning}},
+	}).Decode(&job)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &job, nil
+}
+
+// GetJobByID retrieves a job by ID
+func (s *mongoStore) GetJobByID(ctx context.Context, id primitive.ObjectID) (*Job, error) {
+	var job Job
+	err := s.jobCollection().FindOne(ctx, bson.M{"_id": id}).Decode(&job)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &job, nil
+}
+
+// GetJobs retrieves the most recently updated jobs, optionally only those
+// with a status or type
+func (s *mongoStore) GetJobs(ctx context.Context, status JobStatus, jobType string, limit int) ([]*Job, error) {
+	filter := bson.M{}
+	if status != "" {
+		filter["status"] = status
+	}
+	if jobType != "" {
+		filter["type"] = jobType
+	}
+
+	opts := options.Find().SetSort(bson.M{"updated_at": -1}).SetLimit(int64(limit))
+	cursor, err := s.jobCollection().Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	jobs := []*Job{}
+	if err := cursor.All(ctx, &jobs); err != nil {
+		return nil, err
+	}
+
+	return jobs, nil
+}
+
+// ClaimJob leases the job of one of the given types that is due first to a
+// worker, counting it as an attempt. Jobs whose lease ran out are claimed
+// again while they have attempts left, otherwise they are dead. It returns nil
+// when no job is due.
+func (s *mongoStore) ClaimJob(ctx context.Context, types []string, owner string, lease time.Duration) (*Job, error) {
+	now := time.Now()
+	leasedUntil := now.Add(lease)
+
+	// Jobs whose worker died on their last attempt won't be tried again
+	_, err := s.jobCollection().UpdateMany(ctx, bson.M{
+		"type":         bson.M{"$in": types},
+		"status":       JobRunning,
+		"leased_until": bson.M{"$lt": now},
+		"$expr":        bson.M{"$gte": bson.A{"$attempts", "$max_attempts"}},
+	}, bson.M{
+		"$set":   bson.M{"status": JobDead, "last_error": "lease expired on the last attempt", "updated_at": now},
+		"$unset": bson.M{"lease_owner": "", "leased_until": ""},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	filter := bson.M{
+		"type": bson.M{"$in": types},
+		"$or": []bson.M{
+			{"status": JobPending, "run_at": bson.M{"$lte": now}},
+			{
+				"status":       JobRunning,
+				"leased_until": bson.M{"$lt": now},
+				"$expr":        bson.M{"$lt": bson.A{"$attempts", "$max_attempts"}},
+			},
+		},
+	}
+	update := bson.M{
+		"$set": bson.M{
+			"status":       JobRunning,
+			"lease_owner":  owner,
+			"leased_until": leasedUntil,
+			"updated_at":   now,
+		},
+		"$inc": bson.M{"attempts": 1},
+	}
+	opts := options.FindOneAndUpdate().
+		SetSort(bson.M{"run_at": 1}).
+		SetReturnDocument(options.After)
+
+	var job Job
+	err = s.jobCollection().FindOneAndUpdate(ctx, filter, update, opts).Decode(&job)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &job, nil
+}
+
+// ExtendJobLease keeps a running job leased to its worker. It reports false
+// when the worker lost the lease, e.g. because it was too slow to extend it.
+func (s *mongoStore) ExtendJobLease(ctx context.Context, job *Job, lease time.Duration) (bool, error) {
+	now := time.Now()
+	result, err := s.jobCollection().UpdateOne(ctx, leasedJobFilter(job), bson.M{
+		"$set": bson.M{"leased_until": now.Add(lease), "updated_at": now},
+	})
+	if err != nil {
+		return false, err
+	}
+	return result.MatchedCount > 0, nil
+}
+
+// CompleteJob marks a job its worker finished as succeeded
+func (s *mongoStore) CompleteJob(ctx context.Context, job *Job) error {
+	now := time.Now()
+	_, err := s.jobCollection().UpdateOne(ctx, leasedJobFilter(job), bson.M{
+		"$set":   bson.M{"status": JobSucceeded, "completed_at": now, "updated_at": now},
+		"$unset": bson.M{"lease_owner": "", "leased_until": "", "last_error": ""},
+	})
+	return err
+}
+
+// FailJob records a failed attempt of a job. The job runs again at retryAt
+// while it has attempts left, otherwise it is dead until retried by hand.
+func (s *mongoStore) FailJob(ctx context.Context, job *Job, jobErr error, retryAt time.Time) error {
+	set := bson.M{
+		"status":     JobPending,
+		"run_at":     retryAt,
+		"last_error": jobErr.Error(),
+		"updated_at": time.Now(),
+	}
+	if job.Attempts >= job.MaxAttempts {
+		set["status"] = JobDead
+	}
+
+	_, err := s.jobCollection().UpdateOne(ctx, leasedJobFilter(job), bson.M{
+		"$set":   set,
+		"$unset": bson.M{"lease_owner": "", "leased_until": ""},
+	})
+	return err
+}
+
+// RetryJob gives a dead job a fresh set of attempts, starting right away. It
+// returns nil when the job doesn't exist or isn't dead, and ErrJobKeyActive
+// when another job with its key is pending or running.
+func (s *mongoStore) RetryJob(ctx context.Context, id primitive.ObjectID) (*Job, error) {
+	now := time.Now()
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var job Job
+	err := s.jobCollection().FindOneAndUpdate(ctx, bson.M{"_id": id, "status": JobDead}, bson.M{
+		"$set": bson.M{"status": JobPending, "attempts": 0, "run_at": now, "updated_at": now},
+	}, opts).Decode(&job)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, ErrJobKeyActive
+		}
+		return nil, err
+	}
+	return &job, nil
+}
+
+// leasedJobFilter matches a job only while it is still leased to the worker
+// that claimed it, so a worker that lost its lease can't overwrite the
+// outcome of the worker that took over
+func leasedJobFilter(job *Job) bson.M {
+	return bson.M{
+		"_id":         job.ID,
+		"status":      JobRunning,
+		"lease_owner": job.LeaseOwner,
+		"attempts":    job.Attempts,
+	}
+}
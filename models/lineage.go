@@ -0,0 +1,357 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Kinds of lineage nodes
+const (
+	LineageDatabase       = "database"
+	LineageQuery          = "query"
+	LineageCard           = "card"
+	LineageDashboard      = "dashboard"
+	LineageReportSchedule = "report_schedule"
+	LineageAlert          = "alert"
+)
+
+// LineageNode is a resource in the dependency graph. Its ID is the kind and
+// the resource's ID, so resources of different kinds never collide.
+type LineageNode struct {
+	ID          string             `json:"id"`
+	Kind        string             `json:"kind"`
+	ResourceID  primitive.ObjectID `json:"resource_id"`
+	Name        string             `json:"name"`
+	DashboardID primitive.ObjectID `json:"dashboard_id,omitempty"` // The dashboard a card is on
+}
+
+// LineageEdge says the node To depends on the node From, e.g. a query on the
+// connection it runs against
+type LineageEdge struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// Lineage is the dependency graph from connections through the queries run
+// against them and the dashboard cards showing those, to the report
+// schedules of the dashboards and the alerts on the queries
+type Lineage struct {
+	Nodes []LineageNode `json:"nodes"`
+	Edges []LineageEdge `json:"edges"`
+}
+
+// LineageImpact counts the resources that depend on a connection, and stop
+// working when it is deleted
+type LineageImpact struct {
+	Queries         int `json:"queries"`
+	Dashboards      int `json:"dashboards"`
+	Cards           int `json:"cards"`
+	ReportSchedules int `json:"report_schedules"`
+	Alerts          int `json:"alerts"`
+}
+
+// LineageNodeID returns the ID of a resource's node
+func LineageNodeID(kind string, id primitive.ObjectID) string {
+	return kind + ":" + id.Hex()
+}
+
+// addNode adds a resource's node and returns its ID
+func (l *Lineage) addNode(kind string, id primitive.ObjectID, name string) string {
+	nodeID := LineageNodeID(kind, id)
+	l.Nodes = append(l.Nodes, LineageNode{ID: nodeID, Kind: kind, ResourceID: id, Name: name})
+	return nodeID
+}
+
+// Around returns the part of the graph a node depends on or is depended on
+// by, or nil when the node isn't in the graph
+func (l *Lineage) Around(nodeID string) *Lineage {
+	upstream := make(map[string][]string)
+	downstream := make(map[string][]string)
+	found := false
+	for _, node := range l.Nodes {
+		if node.ID == nodeID {
+			found = true
+		}
+	}
+	if !found {
+		return nil
+	}
+	for _, edge := range l.Edges {
+		downstream[edge.From] = append(downstream[edge.From], edge.To)
+		upstream[edge.To] = append(upstream[edge.To], edge.From)
+	}
+
+	// Walk each direction separately, so siblings sharing a dependency aren't included
+	keep := map[string]bool{nodeID: true}
+	for _, next := range []map[string][]string{upstream, downstream} {
+		queue := []string{nodeID}
+		seen := map[string]bool{nodeID: true}
+		for len(queue) > 0 {
+			current := queue[0]
+			queue = queue[1:]
+			for _, neighbour := range next[current] {
+				if !seen[neighbour] {
+					seen[neighbour] = true
+					keep[neighbour] = true
+					queue = append(queue, neighbour)
+				}
+			}
+		}
+	}
+
+	around := &Lineage{Nodes: []LineageNode{}, Edges: []LineageEdge{}}
+	for _, node := range l.Nodes {
+		if keep[node.ID] {
+			around.Nodes = append(around.Nodes, node)
+		}
+	}
+	for _, edge := range l.Edges {
+		if keep[edge.From] && keep[edge.To] {
+			around.Edges = append(around.Edges, edge)
+		}
+	}
+	return around
+}
+
+// Empty reports whether nothing depends on the connection
+func (i *LineageImpact) Empty() bool {
+	return i.Queries == 0 && i.Dashboards == 0 && i.ReportSchedules == 0 && i.Alerts == 0
+}
+
+// Summary describes what depends on the connection, e.g. "3 queries, 2
+// dashboards and 1 alert"
+func (i *LineageImpact) Summary() string {
+	var parts []string
+	for _, count := range []struct {
+		n              int
+		singular, many string
+	}{
+		{i.Queries, "query", "queries"},
+		{i.Dashboards, "dashboard", "dashboards"},
+		{i.ReportSchedules, "report schedule", "report schedules"},
+		{i.Alerts, "alert", "alerts"},
+	} {
+		switch {
+		case count.n == 1:
+			parts = append(parts, "1 "+count.singular)
+		case count.n > 1:
+			parts = append(parts, fmt.Sprintf("%d %s", count.n, count.many))
+		}
+	}
+
+	switch len(parts) {
+	case 0:
+		return "nothing"
+	case 1:
+		return parts[0]
+	default:
+		return strings.Join(parts[:len(parts)-1], ", ") + " and " + parts[len(parts)-1]
+	}
+}
+
+// lineageQuery is the part of a query the lineage is built from
+type lineageQuery struct {
+	ID           primitive.ObjectID `bson:"_id"`
+	DatabaseID   primitive.ObjectID `bson:"database_id"`
+	Sources      []FederatedSource  `bson:"sources"`
+	Name         string             `bson:"name"`
+	NaturalQuery string             `bson:"natural_query"`
+}
+
+// lineageDashboard is the part of a dashboard the lineage is built from
+type lineageDashboard struct {
+	ID    primitive.ObjectID `bson:"_id"`
+	Name  string             `bson:"name"`
+	Cards []struct {
+		ID      primitive.ObjectID `bson:"_id"`
+		Title   string             `bson:"title"`
+		QueryID primitive.ObjectID `bson:"query_id"`
+	} `bson:"cards"`
+}
+
+// lineageLink is the part of a connection, report schedule or alert the
+// lineage is built from
+type lineageLink struct {
+	ID          primitive.ObjectID `bson:"_id"`
+	Name        string             `bson:"name"`
+	DashboardID primitive.ObjectID `bson:"dashboard_id"`
+	QueryID     primitive.ObjectID `bson:"query_id"`
+}
+
+// findLineage decodes the projected documents of a collection matching
+// filter into results
+func findLineage(ctx context.Context, collection *mongo.Collection, filter, projection bson.M, results interface{}) error {
+	cursor, err := collection.Find(ctx, filter, options.Find().SetProjection(projection))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	return cursor.All(ctx, results)
+}
+
+// GetLineage builds the dependency graph of a user's resources: the queries
+// and dashboards they saved, the connections their queries run against, and
+// their report schedules and alerts. Resources in the trash are left out.
+func (s *mongoStore) GetLineage(ctx context.Context, userID primitive.ObjectID) (*Lineage, error) {
+	var queries []lineageQuery
+	if err := findLineage(ctx, s.queryCollection(), bson.M{"user_id": userID, "deleted_at": notDeleted}, bson.M{"database_id": 1, "sources": 1, "name": 1, "natural_query": 1}, &queries); err != nil {
+		return nil, err
+	}
+
+	// Connections the user's queries run against may be shared with them
+	databaseIDs := []primitive.ObjectID{}
+	for _, query := range queries {
+		if !query.DatabaseID.IsZero() {
+			databaseIDs = append(databaseIDs, query.DatabaseID)
+		}
+	}
+	var databases []lineageLink
+	if err := findLineage(ctx, s.databaseCollection(), bson.M{"$or": bson.A{bson.M{"user_id": userID}, bson.M{"_id": bson.M{"$in": databaseIDs}}}}, bson.M{"name": 1}, &databases); err != nil {
+		return nil, err
+	}
+
+	var dashboards []lineageDashboard
+	if err := findLineage(ctx, s.dashboardCollection(), bson.M{"user_id": userID, "deleted_at": notDeleted}, bson.M{"name": 1, "cards._id": 1, "cards.title": 1, "cards.query_id": 1}, &dashboards); err != nil {
+		return nil, err
+	}
+
+	var schedules []lineageLink
+	if err := findLineage(ctx, s.reportScheduleCollection(), bson.M{"user_id": userID}, bson.M{"name": 1, "dashboard_id": 1}, &schedules); err != nil {
+		return nil, err
+	}
+
+	var alerts []lineageLink
+	if err := findLineage(ctx, s.alertCollection(), bson.M{"user_id": userID}, bson.M{"name": 1, "query_id": 1}, &alerts); err != nil {
+		return nil, err
+	}
+
+	lineage := &Lineage{Nodes: []LineageNode{}, Edges: []LineageEdge{}}
+	present := make(map[string]bool)
+	link := func(from, to string) {
+		if present[from] && present[to] {
+			lineage.Edges = append(lineage.Edges, LineageEdge{From: from, To: to})
+		}
+	}
+
+	for _, db := range databases {
+		present[lineage.addNode(LineageDatabase, db.ID, db.Name)] = true
+	}
+	for _, query := range queries {
+		name := query.Name
+		if name == "" {
+			name = query.NaturalQuery
+		}
+		present[lineage.addNode(LineageQuery, query.ID, name)] = true
+	}
+	for _, query := range queries {
+		id := LineageNodeID(LineageQuery, query.ID)
+		if !query.DatabaseID.IsZero() {
+			link(LineageNodeID(LineageDatabase, query.DatabaseID), id)
+		}
+		for _, source := range query.Sources {
+			link(LineageNodeID(LineageQuery, source.QueryID), id)
+		}
+	}
+
+	for _, dashboard := range dashboards {
+		dashboardNode := lineage.addNode(LineageDashboard, dashboard.ID, dashboard.Name)
+		present[dashboardNode] = true
+		for _, card := range dashboard.Cards {
+			if card.QueryID.IsZero() {
+				continue
+			}
+			cardNode := lineage.addNode(LineageCard, card.ID, card.Title)
+			lineage.Nodes[len(lineage.Nodes)-1].DashboardID = dashboard.ID
+			present[cardNode] = true
+			link(LineageNodeID(LineageQuery, card.QueryID), cardNode)
+			link(cardNode, dashboardNode)
+		}
+	}
+
+	for _, schedule := range schedules {
+		id := lineage.addNode(LineageReportSchedule, schedule.ID, schedule.Name)
+		present[id] = true
+		link(LineageNodeID(LineageDashboard, schedule.DashboardID), id)
+	}
+	for _, alert := range alerts {
+		id := lineage.addNode(LineageAlert, alert.ID, alert.Name)
+		present[id] = true
+		link(LineageNodeID(LineageQuery, alert.QueryID), id)
+	}
+
+	return lineage, nil
+}
+
+// GetDatabaseImpact counts everything that depends on a connection, whoever
+// it belongs to: the queries run against it and the federated queries
+// joining their results, the dashboards and cards showing those, the
+// dashboards' report schedules and the queries' alerts
+func (s *mongoStore) GetDatabaseImpact(ctx context.Context, databaseID primitive.ObjectID) (*LineageImpact, error) {
+	impact := &LineageImpact{}
+
+	var queries []lineageLink
+	if err := findLineage(ctx, s.queryCollection(), bson.M{"database_id": databaseID, "deleted_at": notDeleted}, bson.M{"_id": 1}, &queries); err != nil {
+		return nil, err
+	}
+	if len(queries) == 0 {
+		return impact, nil
+	}
+
+	queryIDs := make([]primitive.ObjectID, 0, len(queries))
+	for _, query := range queries {
+		queryIDs = append(queryIDs, query.ID)
+	}
+
+	// Federated queries fail once one of their sources does
+	var federated []lineageLink
+	if err := findLineage(ctx, s.queryCollection(), bson.M{"sources.query_id": bson.M{"$in": queryIDs}, "deleted_at": notDeleted}, bson.M{"_id": 1}, &federated); err != nil {
+		return nil, err
+	}
+	for _, query := range federated {
+		queryIDs = append(queryIDs, query.ID)
+	}
+	impact.Queries = len(queryIDs)
+
+	var dashboards []lineageDashboard
+	if err := findLineage(ctx, s.dashboardCollection(), bson.M{"cards.query_id": bson.M{"$in": queryIDs}, "deleted_at": notDeleted}, bson.M{"cards.query_id": 1}, &dashboards); err != nil {
+		return nil, err
+	}
+
+	affected := make(map[primitive.ObjectID]bool, len(queryIDs))
+	for _, id := range queryIDs {
+		affected[id] = true
+	}
+	dashboardIDs := make([]primitive.ObjectID, 0, len(dashboards))
+	for _, dashboard := range dashboards {
+		dashboardIDs = append(dashboardIDs, dashboard.ID)
+		for _, card := range dashboard.Cards {
+			if affected[card.QueryID] {
+				impact.Cards++
+			}
+		}
+	}
+	impact.Dashboards = len(dashboards)
+
+	if len(dashboardIDs) > 0 {
+		count, err := s.reportScheduleCollection().CountDocuments(ctx, bson.M{"dashboard_id": bson.M{"$in": dashboardIDs}})
+		if err != nil {
+			return nil, err
+		}
+		impact.ReportSchedules = int(count)
+	}
+
+	count, err := s.alertCollection().CountDocuments(ctx, bson.M{"query_id": bson.M{"$in": queryIDs}})
+	if err != nil {
+		return nil, err
+	}
+	impact.Alerts = int(count)
+
+	return impact, nil
+}
@@ -0,0 +1,58 @@
+package models
+
+import (
+	"html"
+	"regexp"
+	"strings"
+)
+
+// MaxMarkdownLength is the maximum length of text card content in bytes
+const MaxMarkdownLength = 20000
+
+var (
+	markdownHTMLCommentRegex = regexp.MustCompile(`(?s)<!--.*?-->`)
+	markdownUnsafeBlockRegex = regexp.MustCompile(`(?is)<(script|style|iframe|object|embed)\b.*?</(script|style|iframe|object|embed)\s*>`)
+	markdownHTMLTagRegex     = regexp.MustCompile(`</?[A-Za-z][A-Za-z0-9-]*(\s[^<>]*)?/?>`)
+	markdownLinkTargetRegex  = regexp.MustCompile(`\]\(\s*<?([^)\s>]*)>?`)
+	markdownReferenceRegex   = regexp.MustCompile(`(?m)^( {0,3}\[[^\]]+\]:\s*)<?(\S*?)>?(\s|$)`)
+	markdownUnsafeURLRegex   = regexp.MustCompile(`(?i)^(javascript|vbscript|data|file):`)
+)
+
+// SanitizeMarkdown strips raw HTML from Markdown and neutralizes links with
+// script-capable URL schemes, leaving plain Markdown formatting intact
+func SanitizeMarkdown(content string) string {
+	content = strings.ReplaceAll(content, "\r\n", "\n")
+	content = markdownHTMLCommentRegex.ReplaceAllString(content, "")
+	content = markdownUnsafeBlockRegex.ReplaceAllString(content, "")
+	content = markdownHTMLTagRegex.ReplaceAllString(content, "")
+
+	content = markdownLinkTargetRegex.ReplaceAllStringFunc(content, func(match string) string {
+		if isUnsafeMarkdownURL(markdownLinkTargetRegex.FindStringSubmatch(match)[1]) {
+			return "](#"
+		}
+		return match
+	})
+
+	content = markdownReferenceRegex.ReplaceAllStringFunc(content, func(match string) string {
+		parts := markdownReferenceRegex.FindStringSubmatch(match)
+		if isUnsafeMarkdownURL(parts[2]) {
+			return parts[1] + "#" + parts[3]
+		}
+		return match
+	})
+
+	return strings.TrimSpace(content)
+}
+
+// isUnsafeMarkdownURL reports whether a link target uses a script-capable scheme.
+// Entities are decoded and control characters removed first, as renderers do.
+func isUnsafeMarkdownURL(target string) bool {
+	target = html.UnescapeString(target)
+	target = strings.Map(func(r rune) rune {
+		if r <= ' ' || r == 0x7f {
+			return -1
+		}
+		return r
+	}, target)
+	return markdownUnsafeURLRegex.MatchString(target)
+}
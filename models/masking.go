@@ -0,0 +1,565 @@
+package models
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Masking strategies of sensitive columns
+const (
+	MaskRedact  = "redact"  // Replaced by asterisks
+	MaskHash    = "hash"    // Replaced by a keyed hash, so equal values stay equal
+	MaskPartial = "partial" // Only the first letter and domain of emails, or the last four characters, are kept
+)
+
+// MaxMaskingRules caps the number of sensitive columns of a connection
+const MaxMaskingRules = 200
+
+// How masked values look
+const (
+	maskedValue       = "****"
+	maskedHashLength  = 16 // Hex characters of the hash kept
+	maskedPartialKept = 4  // Characters kept of values twice as long or longer
+)
+
+// maskingStrictness orders the strategies, from the one revealing the most
+var maskingStrictness = map[string]int{
+	MaskPartial: 1,
+	MaskHash:    2,
+	MaskRedact:  3,
+}
+
+// MaskingRule marks a column as sensitive. Nested MongoDB fields are
+// addressed by their full path.
+type MaskingRule struct {
+	Table    string `json:"table" bson:"table"`
+	Column   string `json:"column" bson:"column"`
+	Strategy string `json:"strategy" bson:"strategy"`
+}
+
+// ValidateMaskingRules checks rules name a column and a known strategy, and
+// don't mark the same column twice
+func ValidateMaskingRules(rules []MaskingRule) error {
+	if len(rules) > MaxMaskingRules {
+		return fmt.Errorf("at most %d columns can be masked", MaxMaskingRules)
+	}
+
+	seen := make(map[string]bool)
+	for _, rule := range rules {
+		if strings.TrimSpace(rule.Table) == "" || strings.TrimSpace(rule.Column) == "" {
+			return errors.New("masking rule table and column are required")
+		}
+		if _, ok := maskingStrictness[rule.Strategy]; !ok {
+			return fmt.Errorf("%s.%s: invalid masking strategy: %s", rule.Table, rule.Column, rule.Strategy)
+		}
+
+		key := rule.Table + "." + rule.Column
+		if seen[key] {
+			return fmt.Errorf("column %s is masked more than once", key)
+		}
+		seen[key] = true
+	}
+	return nil
+}
+
+// SetDatabaseMasking replaces the masking rules of a database and the members
+// who see its sensitive columns unmasked. The first time, a key is created
+// for the hashes of masked values.
+func (s *mongoStore) SetDatabaseMasking(ctx context.Context, db *Database, rules []MaskingRule, unmaskedUsers []primitive.ObjectID) error {
+	if db.MaskingKey == "" {
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return err
+		}
+		db.MaskingKey = hex.EncodeToString(key)
+	}
+
+	_, err := s.databaseCollection().UpdateOne(
+		ctx,
+		bson.M{"_id": db.ID},
+		bson.M{"$set": bson.M{
+			"masking_rules":  rules,
+			"unmasked_users": unmaskedUsers,
+			"masking_key":    db.MaskingKey,
+			"updated_at":     time.Now(),
+		}},
+	)
+	if err != nil {
+		return err
+	}
+
+	db.MaskingRules, db.UnmaskedUsers = rules, unmaskedUsers
+	return nil
+}
+
+// SeesUnmasked reports whether a user sees the sensitive columns of the
+// database unmasked: its owner and the members allowed to. Anonymous
+// viewers, given as the nil ID, never do.
+func (db *Database) SeesUnmasked(userID primitive.ObjectID) bool {
+	if userID.IsZero() {
+		return false
+	}
+	if userID == db.UserID {
+		return true
+	}
+	for _, id := range db.UnmaskedUsers {
+		if id == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// resultMask returns the mask of the database's results for a viewer, nil
+// when nothing is masked for them
+func (db *Database) resultMask(viewer primitive.ObjectID) *ResultMask {
+	if len(db.MaskingRules) == 0 || db.SeesUnmasked(viewer) {
+		return nil
+	}
+
+	// Without a key hashes would be guessable, so values are redacted
+	key, _ := hex.DecodeString(db.MaskingKey)
+	mask := &ResultMask{columns: make(map[string]columnMask, len(db.MaskingRules))}
+	for _, rule := range db.MaskingRules {
+		strategy := rule.Strategy
+		if strategy == MaskHash && len(key) == 0 {
+			strategy = MaskRedact
+		}
+		mask.add(rule.Column, columnMask{strategy: strategy, key: key})
+	}
+	return mask
+}
+
+// columnMask is how the values of a sensitive column are masked
+type columnMask struct {
+	strategy string
+	key      []byte // Of hashes
+}
+
+// ResultMask masks the sensitive columns of query results. Results don't say
+// which table a column came from, so columns are matched by name, and nested
+// fields by their path or name. A nil mask leaves results as they are.
+type ResultMask struct {
+	columns map[string]columnMask // By lowercase path and name
+	names   []string              // Of sensitive columns, to find in statements
+}
+
+// add marks a column as sensitive, keeping the strictest strategy when more
+// than one column shares a name
+func (m *ResultMask) add(path string, mask columnMask) {
+	path = strings.ToLower(path)
+	name := path[strings.LastIndex(path, ".")+1:]
+	for _, key := range []string{path, name} {
+		existing, ok := m.columns[key]
+		if !ok || maskingStrictness[mask.strategy] > maskingStrictness[existing.strategy] {
+			m.columns[key] = mask
+		}
+	}
+	if !containsName(m.names, name) {
+		m.names = append(m.names, name)
+	}
+}
+
+// merge returns a mask of the sensitive columns of both masks
+func (m *ResultMask) merge(other *ResultMask) *ResultMask {
+	if m == nil {
+		return other
+	}
+	if other == nil {
+		return m
+	}
+
+	merged := &ResultMask{columns: make(map[string]columnMask, len(m.columns)+len(other.columns))}
+	for _, mask := range []*ResultMask{m, other} {
+		for key, column := range mask.columns {
+			if existing, ok := merged.columns[key]; !ok || maskingStrictness[column.strategy] > maskingStrictness[existing.strategy] {
+				merged.columns[key] = column
+			}
+		}
+		for _, name := range mask.names {
+			if !containsName(merged.names, name) {
+				merged.names = append(merged.names, name)
+			}
+		}
+	}
+	return merged
+}
+
+// Apply returns a masked copy of results of the statement, leaving results
+// untouched. A sensitive column is only masked by name when it is selected
+// under its own name, and used nowhere else in the statement: when it is
+// renamed, filtered on or computed with, the values derived from it can't be
+// told apart, so every value is redacted.
+func (m *ResultMask) Apply(statement string, results []QueryResult) []QueryResult {
+	if m == nil {
+		return results
+	}
+
+	if m.leaks(statement, results) {
+		return redactResults(results)
+	}
+	masked := make([]QueryResult, len(results))
+	for i, row := range results {
+		masked[i] = m.maskRow(row, "")
+	}
+	return masked
+}
+
+// leaks reports whether the statement uses a sensitive column other than by
+// selecting it under its own name
+func (m *ResultMask) leaks(statement string, results []QueryResult) bool {
+	if m == nil {
+		return false
+	}
+
+	selected := make(map[string]bool)
+	for _, row := range results {
+		for key := range row {
+			selected[strings.ToLower(key)] = true
+		}
+	}
+
+	for _, name := range m.names {
+		uses := len(identifierRegex(name).FindAllStringIndex(statement, -1))
+		if uses > 1 || (uses == 1 && !selected[name] && !m.selectedParent(name, selected)) {
+			return true
+		}
+	}
+	return false
+}
+
+// selectedParent reports whether the document holding a nested sensitive
+// field is selected, where the field is masked
+func (m *ResultMask) selectedParent(name string, selected map[string]bool) bool {
+	for path := range m.columns {
+		if strings.HasSuffix(path, "."+name) && selected[path[:strings.Index(path, ".")]] {
+			return true
+		}
+	}
+	return false
+}
+
+// containsName reports whether names holds name
+func containsName(names []string, name string) bool {
+	for _, existing := range names {
+		if existing == name {
+			return true
+		}
+	}
+	return false
+}
+
+// identifierRegex matches a column name as a whole word, in any case
+func identifierRegex(name string) *regexp.Regexp {
+	return regexp.MustCompile(`(?i)\b` + regexp.QuoteMeta(name) + `\b`)
+}
+
+// maskRow copies a row or nested document, masking its sensitive fields
+func (m *ResultMask) maskRow(row map[string]interface{}, prefix string) QueryResult {
+	masked := make(QueryResult, len(row))
+	for key, value := range row {
+		path := prefix + strings.ToLower(key)
+		if column, ok := m.columns[path]; ok {
+			masked[key] = column.mask(value)
+			continue
+		}
+		if column, ok := m.columns[strings.ToLower(key)]; ok {
+			masked[key] = column.mask(value)
+			continue
+		}
+		masked[key] = m.maskNested(value, path+".")
+	}
+	return masked
+}
+
+// maskNested masks the sensitive fields of nested documents and arrays of
+// them, returning other values as they are
+func (m *ResultMask) maskNested(value interface{}, prefix string) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return map[string]interface{}(m.maskRow(v, prefix))
+	case bson.M:
+		return bson.M(m.maskRow(v, prefix))
+	case QueryResult:
+		return m.maskRow(v, prefix)
+	case bson.D:
+		// Stored results decode nested documents in order
+		document := make(bson.D, len(v))
+		for i, field := range v {
+			masked := m.maskRow(map[string]interface{}{field.Key: field.Value}, prefix)
+			document[i] = bson.E{Key: field.Key, Value: masked[field.Key]}
+		}
+		return document
+	case []interface{}:
+		return m.maskItems(v, prefix)
+	case bson.A:
+		return bson.A(m.maskItems(v, prefix))
+	}
+	return value
+}
+
+// maskItems masks the documents of an array
+func (m *ResultMask) maskItems(items []interface{}, prefix string) []interface{} {
+	masked := make([]interface{}, len(items))
+	for i, item := range items {
+		masked[i] = m.maskNested(item, prefix)
+	}
+	return masked
+}
+
+// mask masks one value, leaving nulls as they are
+func (c columnMask) mask(value interface{}) interface{} {
+	if value == nil {
+		return nil
+	}
+
+	text := fmt.Sprint(value)
+	switch c.strategy {
+	case MaskHash:
+		h := hmac.New(sha256.New, c.key)
+		h.Write([]byte(text))
+		return hex.EncodeToString(h.Sum(nil))[:maskedHashLength]
+	case MaskPartial:
+		return maskPartially(text)
+	}
+	return maskedValue
+}
+
+// maskPartially keeps the first letter and domain of emails, and the last
+// four characters of longer values
+func maskPartially(text string) string {
+	if at := strings.LastIndex(text, "@"); at > 0 {
+		first, _ := utf8.DecodeRuneInString(text)
+		return string(first) + "***" + text[at:]
+	}
+
+	runes := []rune(text)
+	if len(runes) <= maskedPartialKept*2 {
+		return maskedValue
+	}
+	return maskedValue + string(runes[len(runes)-maskedPartialKept:])
+}
+
+// redactResults copies results with every value redacted
+func redactResults(results []QueryResult) []QueryResult {
+	redacted := make([]QueryResult, len(results))
+	for i, row := range results {
+		redacted[i] = redactRow(row)
+	}
+	return redacted
+}
+
+// redactRow copies a row with every value redacted
+func redactRow(row QueryResult) QueryResult {
+	redacted := make(QueryResult, len(row))
+	for key, value := range row {
+		if value != nil {
+			value = maskedValue
+		}
+		redacted[key] = value
+	}
+	return redacted
+}
+
+// maxMaskedSourceDepth bounds how deep federated queries reading federated
+// queries are followed
+const maxMaskedSourceDepth = 3
+
+// ResultMasker masks query results for one viewer, loading the masking rules
+// of each connection once. It can be used concurrently.
+type ResultMasker struct {
+	store  *mongoStore
+	viewer primitive.ObjectID // Nil for anonymous viewers, e.g. of embeds
+	mu     sync.Mutex
+	masks  map[primitive.ObjectID]*ResultMask
+}
+
+// NewResultMasker returns a masker of results for the viewer, the nil ID for
+// anonymous viewers, who see every sensitive column masked
+func (s *mongoStore) NewResultMasker(viewer primitive.ObjectID) *ResultMasker {
+	return &ResultMasker{store: s, viewer: viewer, masks: make(map[primitive.ObjectID]*ResultMask)}
+}
+
+// Mask returns a masked copy of results of the query, e.g. its own results or
+// a dashboard card's. Federated queries are masked by the rules of their
+// sources' connections.
+func (m *ResultMasker) Mask(ctx context.Context, query *Query, results []QueryResult) ([]QueryResult, error) {
+	results, _, err := m.mask(ctx, query, results)
+	return results, err
+}
+
+// mask masks results of the query and reports whether any rules applied
+func (m *ResultMasker) mask(ctx context.Context, query *Query, results []QueryResult) ([]QueryResult, bool, error) {
+	mask, leaks, err := m.queryMask(ctx, query, 0)
+	if err != nil {
+		return nil, false, err
+	}
+	if leaks {
+		return redactResults(results), true, nil
+	}
+	return mask.Apply(query.GeneratedSQL, results), mask != nil, nil
+}
+
+// MaskQuery masks the results of a query in place. The query must not be
+// saved afterwards.
+func (m *ResultMasker) MaskQuery(ctx context.Context, query *Query) error {
+	results, err := m.Mask(ctx, query, query.Results)
+	if err != nil {
+		return err
+	}
+	query.Results = results
+	return nil
+}
+
+// MaskCardData masks the results of a dashboard card in place. Metric values
+// taken from unmasked results are dropped, to be taken from the masked ones.
+// The data must not be saved afterwards.
+func (m *ResultMasker) MaskCardData(ctx context.Context, data *CardData) error {
+	results, masked, err := m.maskResultsOf(ctx, data.QueryID, data.Results)
+	if err != nil {
+		return err
+	}
+	data.Results = results
+	if masked {
+		data.Value, data.PreviousValue = nil, nil
+	}
+	return nil
+}
+
+// MaskSnapshot masks the results of a snapshot's cards in place. The
+// snapshot must not be saved afterwards.
+func (m *ResultMasker) MaskSnapshot(ctx context.Context, snapshot *DashboardSnapshot) error {
+	for i := range snapshot.Cards {
+		card := &snapshot.Cards[i]
+		if card.QueryID.IsZero() {
+			continue
+		}
+		results, _, err := m.maskResultsOf(ctx, card.QueryID, card.Results)
+		if err != nil {
+			return err
+		}
+		card.Results = results
+	}
+	return nil
+}
+
+// maskResultsOf masks results of a query by its ID. The rules of a query
+// that no longer exists are unknown, so its results are redacted.
+func (m *ResultMasker) maskResultsOf(ctx context.Context, queryID primitive.ObjectID, results []QueryResult) ([]QueryResult, bool, error) {
+	query, err := m.loadQuery(ctx, queryID)
+	if err != nil {
+		return nil, false, err
+	}
+	if query == nil {
+		return redactResults(results), true, nil
+	}
+	return m.mask(ctx, query, results)
+}
+
+// loadQuery loads a query, trashed or not
+func (m *ResultMasker) loadQuery(ctx context.Context, id primitive.ObjectID) (*Query, error) {
+	query, err := m.store.GetQueryByID(ctx, id)
+	if err == nil && query == nil {
+		query, err = m.store.GetTrashedQueryByID(ctx, id)
+	}
+	return query, err
+}
+
+// queryMask returns the mask of a query's results, and whether a federated
+// query's source leaks a sensitive column under another name, which its
+// results can't be masked by name for
+func (m *ResultMasker) queryMask(ctx context.Context, query *Query, depth int) (*ResultMask, bool, error) {
+	if !query.DatabaseID.IsZero() {
+		mask, err := m.databaseMask(ctx, query.DatabaseID)
+		return mask, false, err
+	}
+	if depth >= maxMaskedSourceDepth {
+		return nil, true, nil
+	}
+
+	var merged *ResultMask
+	for _, source := range query.Sources {
+		sourceQuery, err := m.loadQuery(ctx, source.QueryID)
+		if err != nil {
+			return nil, false, err
+		}
+		if sourceQuery == nil {
+			// The rules of a purged source are unknown
+			return nil, true, nil
+		}
+
+		mask, leaks, err := m.queryMask(ctx, sourceQuery, depth+1)
+		if err != nil {
+			return nil, false, err
+		}
+		if leaks || mask.leaks(sourceQuery.GeneratedSQL, sourceQuery.Results) {
+			return nil, true, nil
+		}
+		merged = merged.merge(mask)
+	}
+	return merged, false, nil
+}
+
+// databaseMask returns the mask of a connection's results for the viewer
+func (m *ResultMasker) databaseMask(ctx context.Context, id primitive.ObjectID) (*ResultMask, error) {
+	m.mu.Lock()
+	mask, ok := m.masks[id]
+	m.mu.Unlock()
+	if ok {
+		return mask, nil
+	}
+
+	db, err := m.store.GetDatabaseByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if db != nil {
+		mask = db.resultMask(m.viewer)
+	}
+	m.mu.Lock()
+	m.masks[id] = mask
+	m.mu.Unlock()
+	return mask, nil
+}
+
+// MaskProfile returns a copy of a table profile without the values of the
+// sensitive columns the viewer sees masked, keeping their counts. The stored
+// profile is left untouched.
+func (db *Database) MaskProfile(profile *TableProfile, viewer primitive.ObjectID) *TableProfile {
+	if profile == nil || len(db.MaskingRules) == 0 || db.SeesUnmasked(viewer) {
+		return profile
+	}
+
+	sensitive := make(map[string]bool)
+	for _, rule := range db.MaskingRules {
+		if rule.Table == profile.Table {
+			sensitive[rule.Column] = true
+		}
+	}
+	if len(sensitive) == 0 {
+		return profile
+	}
+
+	masked := *profile
+	masked.Columns = make([]ColumnProfile, len(profile.Columns))
+	for i, column := range profile.Columns {
+		if sensitive[column.Name] {
+			column.Min, column.Max, column.TopValues, column.Histogram = nil, nil, nil, nil
+		}
+		masked.Columns[i] = column
+	}
+	return &masked
+}
@@ -0,0 +1,227 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Materialization refreshes a query's stored results on a schedule, so
+// dashboard cards and API reads serve them instead of running the query. The
+// results of the last successful refresh are kept when one fails.
+type Materialization struct {
+	Cron          string     `json:"cron" bson:"cron"`
+	Timezone      string     `json:"timezone" bson:"timezone"`
+	NextRunAt     *time.Time `json:"next_run_at,omitempty" bson:"next_run_at,omitempty"`
+	RefreshedAt   *time.Time `json:"refreshed_at,omitempty" bson:"refreshed_at,omitempty"`       // Last successful refresh
+	LastAttemptAt *time.Time `json:"last_attempt_at,omitempty" bson:"last_attempt_at,omitempty"` // Last refresh, successful or not
+	LastError     string     `json:"last_error,omitempty" bson:"last_error,omitempty"`           // Why the last refresh failed, empty when it succeeded
+}
+
+// Materialized reports whether the query's results are refreshed on a schedule
+func (q *Query) Materialized() bool {
+	return q.Materialization != nil
+}
+
+// MaterializedAt returns when the query's stored results were refreshed, or
+// when it was last updated when they haven't been yet
+func (q *Query) MaterializedAt() time.Time {
+	if q.Materialization != nil && q.Materialization.RefreshedAt != nil {
+		return *q.Materialization.RefreshedAt
+	}
+	return q.UpdatedAt
+}
+
+// CanMaterialize reports why a query's results can't be refreshed on a
+// schedule, or nil when they can
+func (q *Query) CanMaterialize() error {
+	if q.IsWrite {
+		return errors.New("write queries can't be materialized")
+	}
+	if q.GeneratedSQL == "" {
+		return errors.New("the query has nothing to run")
+	}
+	if HasQueryVariables(q.GeneratedSQL) {
+		return errors.New("queries with variables can't be materialized")
+	}
+	return nil
+}
+
+// MaterializedCardData returns a materialized query's stored results as the
+// data of a card showing it. It returns an error along with the data when the
+// query's last run failed.
+func MaterializedCardData(dashboard *Dashboard, card *DashboardCard, query *Query) (*CardData, error) {
+	data := &CardData{
+		DashboardID:   dashboard.ID,
+		CardID:        card.ID,
+		QueryID:       query.ID,
+		Status:        query.Status,
+		Columns:       query.Columns,
+		Results:       query.Results,
+		Error:         query.Error,
+		ExecutionTime: query.ExecutionTime,
+		RefreshedAt:   query.MaterializedAt(),
+		LastAttemptAt: time.Now(),
+	}
+	if query.Status == QueryStatusFailed {
+		return data, errors.New(query.Error)
+	}
+	return data, nil
+}
+
+// SetQueryMaterialization schedules a query's results to be refreshed by a
+// cron expression in a timezone, keeping when they were last refreshed
+func (s *mongoStore) SetQueryMaterialization(ctx context.Context, query *Query, cron, timezone string) error {
+	nextRun, err := NextReportRun(cron, timezone, time.Now())
+	if err != nil {
+		return err
+	}
+
+	materialization := &Materialization{}
+	if query.Materialization != nil {
+		*materialization = *query.Materialization
+	}
+	materialization.Cron = cron
+	materialization.Timezone = timezone
+	materialization.NextRunAt = &nextRun
+
+	_, err = s.queryCollection().UpdateOne(
+		ctx,
+		bson.M{"_id": query.ID},
+		bson.M{"$set": bson.M{"materialization": materialization}},
+	)
+	if err != nil {
+		return err
+	}
+
+	query.Materialization = materialization
+	return nil
+}
+
+// UnmaterializeQuery stops refreshing a query's results on a schedule. The
+// results it has are kept.
+func (s *mongoStore) UnmaterializeQuery(ctx context.Context, id primitive.ObjectID) error {
+	_, err := s.queryCollection().UpdateOne(
+		ctx,
+		bson.M{"_id": id},
+		bson.M{"$unset": bson.M{"materialization": ""}},
+	)
+	return err
+}
+
+// GetQueryMaterialization retrieves only the materialization of a query, nil
+// when the query isn't materialized or doesn't exist
+func (s *mongoStore) GetQueryMaterialization(ctx context.Context, id primitive.ObjectID) (*Materialization, error) {
+	var query Query
+	opts := options.FindOne().SetProjection(bson.M{"materialization": 1})
+	err := s.queryCollection().FindOne(ctx, bson.M{"_id": id, "deleted_at": notDeleted}, opts).Decode(&query)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return query.Materialization, nil
+}
+
+// GetDueMaterializedQueries retrieves the materialized queries whose next
+// refresh is due, without their results
+func (s *mongoStore) GetDueMaterializedQueries(ctx context.Context, now time.Time) ([]*Query, error) {
+	opts := options.Find().SetProjection(bson.M{"results": 0})
+	cursor, err := s.queryCollection().Find(ctx, bson.M{
+		"materialization.next_run_at": bson.M{"$lte": now},
+		"deleted_at":                  notDeleted,
+	}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var queries []*Query
+	if err := cursor.All(ctx, &queries); err != nil {
+		return nil, err
+	}
+
+	return queries, nil
+}
+
+// ClaimMaterialization advances a due materialized query to its next refresh.
+// It returns false when another worker already claimed this refresh.
+func (s *mongoStore) ClaimMaterialization(ctx context.Context, query *Query, now time.Time) (bool, error) {
+	materialization := query.Materialization
+	nextRun, err := NextReportRun(materialization.Cron, materialization.Timezone, now)
+	if err != nil {
+		return false, err
+	}
+
+	result, err := s.queryCollection().UpdateOne(
+		ctx,
+		bson.M{"_id": query.ID, "materialization.next_run_at": materialization.NextRunAt},
+		bson.M{"$set": bson.M{"materialization.next_run_at": nextRun, "materialization.last_attempt_at": now}},
+	)
+	if err != nil {
+		return false, err
+	}
+
+	if result.ModifiedCount == 0 {
+		return false, nil
+	}
+
+	materialization.NextRunAt = &nextRun
+	materialization.LastAttemptAt = &now
+	return true, nil
+}
+
+// SaveMaterializedResults stores the results of a successful refresh of a
+// materialized query
+func (s *mongoStore) SaveMaterializedResults(ctx context.Context, query *Query, results []QueryResult, columns []ResultColumn, executionTime string, now time.Time) error {
+	_, err := s.queryCollection().UpdateOne(
+		ctx,
+		bson.M{"_id": query.ID, "materialization": bson.M{"$exists": true}},
+		bson.M{
+			"$set": bson.M{
+				"status":                       QueryStatusCompleted,
+				"results":                      results,
+				"columns":                      columns,
+				"execution_time":               executionTime,
+				"updated_at":                   now,
+				"materialization.refreshed_at": now,
+			},
+			"$unset": bson.M{"error": "", "materialization.last_error": ""},
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	query.Status = QueryStatusCompleted
+	query.Results = results
+	query.Columns = columns
+	query.ExecutionTime = executionTime
+	query.Error = ""
+	query.UpdatedAt = now
+	query.Materialization.RefreshedAt = &now
+	query.Materialization.LastError = ""
+	return nil
+}
+
+// RecordMaterializationFailure records why a refresh of a materialized query
+// failed, keeping the results of the last successful one
+func (s *mongoStore) RecordMaterializationFailure(ctx context.Context, query *Query, refreshErr error) error {
+	_, err := s.queryCollection().UpdateOne(
+		ctx,
+		bson.M{"_id": query.ID, "materialization": bson.M{"$exists": true}},
+		bson.M{"$set": bson.M{"materialization.last_error": refreshErr.Error()}},
+	)
+	if err != nil {
+		return err
+	}
+
+	query.Materialization.LastError = refreshErr.Error()
+	return nil
+}
@@ -0,0 +1,150 @@
+package models
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// metadataCollection is a collection included in metadata exports, with the
+// fields left out of them
+type metadataCollection struct {
+	collection *mongo.Collection
+	exclude    bson.M
+}
+
+// metadataCollections lists what users set up: accounts, organizations,
+// connections, queries, dashboards and what watches them. Sessions, jobs,
+// deliveries, caches and metrics are left out, and so are query results,
+// which are rerun instead.
+func (s *mongoStore) metadataCollections() []metadataCollection {
+	return []metadataCollection{
+		{collection: s.userCollection()},
+		{collection: s.organizationCollection()},
+		{collection: s.workspaceCollection()},
+		{collection: s.databaseCollection()},
+		{collection: s.queryCollection(), exclude: bson.M{"results": 0}},
+		{collection: s.queryCommentCollection()},
+		{collection: s.queryTemplateCollection()},
+		{collection: s.dashboardCollection()},
+		{collection: s.dashboardCommentCollection()},
+		{collection: s.reportScheduleCollection()},
+		{collection: s.alertCollection()},
+		{collection: s.freshnessCheckCollection()},
+		{collection: s.notificationChannelCollection()},
+		{collection: s.webhookCollection()},
+		{collection: s.allowedOriginCollection()},
+		{collection: s.featureFlagCollection()},
+	}
+}
+
+// metadataLine is one document of a metadata export, in MongoDB's canonical
+// Extended JSON so types like ObjectIDs and dates survive the round trip
+type metadataLine struct {
+	Collection string          `json:"collection"`
+	Document   json.RawMessage `json:"document"`
+}
+
+// ExportMetadata writes every document of the metadata collections to w, one
+// JSON line each, and returns how many were written per collection. The
+// export includes password hashes and connection credentials.
+func (s *mongoStore) ExportMetadata(ctx context.Context, w io.Writer) (map[string]int64, error) {
+	counts := map[string]int64{}
+	encoder := json.NewEncoder(w)
+
+	for _, metadata := range s.metadataCollections() {
+		opts := options.Find().SetSort(bson.M{"_id": 1})
+		if metadata.exclude != nil {
+			opts.SetProjection(metadata.exclude)
+		}
+
+		name := metadata.collection.Name()
+		cursor, err := metadata.collection.Find(ctx, bson.M{}, opts)
+		if err != nil {
+			return counts, fmt.Errorf("%s: %v", name, err)
+		}
+
+		for cursor.Next(ctx) {
+			document, err := bson.MarshalExtJSON(cursor.Current, true, false)
+			if err != nil {
+				cursor.Close(ctx)
+				return counts, fmt.Errorf("%s: %v", name, err)
+			}
+			if err := encoder.Encode(metadataLine{Collection: name, Document: document}); err != nil {
+				cursor.Close(ctx)
+				return counts, err
+			}
+			counts[name]++
+		}
+		err = cursor.Err()
+		cursor.Close(ctx)
+		if err != nil {
+			return counts, fmt.Errorf("%s: %v", name, err)
+		}
+	}
+
+	return counts, nil
+}
+
+// ImportMetadata reads an export written by ExportMetadata and saves its
+// documents, replacing the ones with the same ID, and returns how many were
+// saved per collection. Documents of other collections are refused.
+func (s *mongoStore) ImportMetadata(ctx context.Context, r io.Reader) (map[string]int64, error) {
+	collections := map[string]*mongo.Collection{}
+	for _, metadata := range s.metadataCollections() {
+		collections[metadata.collection.Name()] = metadata.collection
+	}
+
+	counts := map[string]int64{}
+	reader := bufio.NewReader(r)
+	for number := 1; ; number++ {
+		// Lines are read whole, since schemas make some documents large
+		data, err := reader.ReadBytes('\n')
+		if len(data) == 0 && errors.Is(err, io.EOF) {
+			return counts, nil
+		}
+		if err != nil && !errors.Is(err, io.EOF) {
+			return counts, err
+		}
+		if len(bytes.TrimSpace(data)) == 0 {
+			continue
+		}
+
+		var line metadataLine
+		if err := json.Unmarshal(data, &line); err != nil {
+			return counts, fmt.Errorf("line %d: %v", number, err)
+		}
+		collection, ok := collections[line.Collection]
+		if !ok {
+			return counts, fmt.Errorf("line %d: %q is not a metadata collection", number, line.Collection)
+		}
+
+		var document bson.D
+		if err := bson.UnmarshalExtJSON(line.Document, true, &document); err != nil {
+			return counts, fmt.Errorf("line %d: %v", number, err)
+		}
+		var id interface{}
+		for _, field := range document {
+			if field.Key == "_id" {
+				id = field.Value
+			}
+		}
+		if id == nil {
+			return counts, fmt.Errorf("line %d: the document has no _id", number)
+		}
+
+		_, err = collection.ReplaceOne(ctx, bson.M{"_id": id}, document, options.Replace().SetUpsert(true))
+		if err != nil {
+			return counts, fmt.Errorf("line %d: %v", number, err)
+		}
+		counts[line.Collection]++
+	}
+}
@@ -0,0 +1,159 @@
+package models
+
+import (
+	"errors"
+	"sort"
+)
+
+// MetricDirection tells whether higher or lower metric values are better
+type MetricDirection string
+
+const (
+	MetricHigherIsBetter MetricDirection = "higher_is_better"
+	MetricLowerIsBetter  MetricDirection = "lower_is_better"
+)
+
+// MetricStatus is the threshold status of a metric value
+type MetricStatus string
+
+const (
+	MetricStatusOK       MetricStatus = "ok"
+	MetricStatusWarning  MetricStatus = "warning"
+	MetricStatusCritical MetricStatus = "critical"
+	MetricStatusUnknown  MetricStatus = "unknown"
+)
+
+// MetricConfig configures a metric card. The value is read from the first row
+// of the query results, using ValueColumn or the first numeric column.
+type MetricConfig struct {
+	ValueColumn string          `json:"value_column,omitempty" bson:"value_column,omitempty"`
+	Target      *float64        `json:"target,omitempty" bson:"target,omitempty"`
+	Warning     *float64        `json:"warning,omitempty" bson:"warning,omitempty"`
+	Critical    *float64        `json:"critical,omitempty" bson:"critical,omitempty"`
+	Direction   MetricDirection `json:"direction,omitempty" bson:"direction,omitempty"`
+}
+
+// MetricSummary is the current state of a metric card
+type MetricSummary struct {
+	Value         *float64     `json:"value"`
+	PreviousValue *float64     `json:"previous_value"`
+	Delta         *float64     `json:"delta"`
+	DeltaPercent  *float64     `json:"delta_percent"`
+	Target        *float64     `json:"target,omitempty"`
+	Status        MetricStatus `json:"status"`
+}
+
+// Validate checks the metric thresholds are consistent with the direction
+func (m *MetricConfig) Validate() error {
+	switch m.Direction {
+	case "":
+		m.Direction = MetricHigherIsBetter
+	case MetricHigherIsBetter, MetricLowerIsBetter:
+	default:
+		return errors.New("direction must be higher_is_better or lower_is_better")
+	}
+
+	if m.Warning != nil && m.Critical != nil {
+		if m.Direction == MetricHigherIsBetter && *m.Critical > *m.Warning {
+			return errors.New("critical threshold must not be above the warning threshold when higher is better")
+		}
+		if m.Direction == MetricLowerIsBetter && *m.Critical < *m.Warning {
+			return errors.New("critical threshold must not be below the warning threshold when lower is better")
+		}
+	}
+
+	return nil
+}
+
+// ExtractMetricValue reads the metric value from the first result row
+func (m *MetricConfig) ExtractMetricValue(results []QueryResult) *float64 {
+	if len(results) == 0 {
+		return nil
+	}
+	row := results[0]
+
+	if m.ValueColumn != "" {
+		if v, ok := toFloat(row[m.ValueColumn]); ok {
+			return &v
+		}
+		return nil
+	}
+
+	// Fall back to the first numeric column in name order, since rows are unordered maps
+	keys := make([]string, 0, len(row))
+	for key := range row {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	// Prefer native numbers, then numeric strings such as Postgres NUMERIC values
+	for _, allowStrings := range []bool{false, true} {
+		for _, key := range keys {
+			if _, isBool := row[key].(bool); isBool {
+				continue
+			}
+			if _, isString := row[key].(string); isString != allowStrings {
+				continue
+			}
+			if v, ok := toFloat(row[key]); ok {
+				return &v
+			}
+		}
+	}
+	return nil
+}
+
+// Summarize compares a metric value against the previous value and thresholds
+func (m *MetricConfig) Summarize(value, previous *float64) *MetricSummary {
+	summary := &MetricSummary{
+		Value:         value,
+		PreviousValue: previous,
+		Target:        m.Target,
+		Status:        m.status(value),
+	}
+
+	if value != nil && previous != nil {
+		delta := *value - *previous
+		summary.Delta = &delta
+		if *previous != 0 {
+			percent := delta / *previous * 100
+			summary.DeltaPercent = &percent
+		}
+	}
+
+	return summary
+}
+
+// Alerts reports whether a value breaches a threshold the previous value
+// didn't, so an alert fires once when a metric gets worse rather than on
+// every refresh while it stays bad
+func (m *MetricConfig) Alerts(value, previous *float64) bool {
+	severity := map[MetricStatus]int{MetricStatusWarning: 1, MetricStatusCritical: 2}
+	return severity[m.status(value)] > severity[m.status(previous)]
+}
+
+// status classifies a value against the warning and critical thresholds
+func (m *MetricConfig) status(value *float64) MetricStatus {
+	if value == nil {
+		return MetricStatusUnknown
+	}
+
+	breaches := func(threshold *float64) bool {
+		if threshold == nil {
+			return false
+		}
+		if m.Direction == MetricLowerIsBetter {
+			return *value >= *threshold
+		}
+		return *value <= *threshold
+	}
+
+	switch {
+	case breaches(m.Critical):
+		return MetricStatusCritical
+	case breaches(m.Warning):
+		return MetricStatusWarning
+	default:
+		return MetricStatusOK
+	}
+}
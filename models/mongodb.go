@@ -6,6 +6,7 @@ import (
 	"log"
 	"math"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -38,13 +39,57 @@ func getMongoDBConnectionString(db *Database) string {
 	return connStr
 }
 
+// mongoDBClientOptions returns the client options for a MongoDB database,
+// applying its TLS configuration and dialing through its ssh tunnel if it has one
+func mongoDBClientOptions(db *Database) (*options.ClientOptions, error) {
+	db, err := db.withSecrets()
+	if err != nil {
+		return nil, err
+	}
+
+	clientOptions := options.Client().ApplyURI(getMongoDBConnectionString(db))
+
+	if db.TLS != nil {
+		tlsConfig, err := db.TLS.mongoDBConfig()
+		if err != nil {
+			return nil, err
+		}
+		clientOptions.SetTLSConfig(tlsConfig)
+	}
+
+	if db.SSHTunnel != nil {
+		clientOptions.SetDialer(sshDialer{dial: SSHTunnels.Dialer(db.SSHTunnel.Config())})
+	}
+	return clientOptions, nil
+}
+
+// mongoDBDatabaseName returns the database named in the connection URI, or
+// the connection's database name
+func mongoDBDatabaseName(db *Database) string {
+	if resolved, err := db.withSecrets(); err == nil {
+		db = resolved
+	}
+
+	if db.ConnectionURI != "" {
+		parts := strings.Split(db.ConnectionURI, "/")
+		if len(parts) > 3 {
+			if name := strings.Split(parts[len(parts)-1], "?")[0]; name != "" {
+				return name
+			}
+		}
+	}
+	return db.DatabaseName
+}
+
 // testMongoDBConnection tests the connection to a MongoDB database
-func testMongoDBConnection(db *Database) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 120*time.Second)
+func testMongoDBConnection(ctx context.Context, db *Database) error {
+	ctx, cancel := context.WithTimeout(ctx, 120*time.Second)
 	defer cancel()
 
-	connStr := getMongoDBConnectionString(db)
-	clientOptions := options.Client().ApplyURI(connStr)
+	clientOptions, err := mongoDBClientOptions(db)
+	if err != nil {
+		return fmt.Errorf("failed to create MongoDB client: %v", err)
+	}
 
 	client, err := mongo.Connect(ctx, clientOptions)
 	if err != nil {
@@ -61,12 +106,14 @@ func testMongoDBConnection(db *Database) error {
 }
 
 // fetchMongoDBSchema fetches the schema of a MongoDB database
-func fetchMongoDBSchema(db *Database) (*Schema, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 120*time.Second)
+func fetchMongoDBSchema(ctx context.Context, db *Database) (*Schema, error) {
+	ctx, cancel := context.WithTimeout(ctx, 120*time.Second)
 	defer cancel()
 
-	connStr := getMongoDBConnectionString(db)
-	clientOptions := options.Client().ApplyURI(connStr)
+	clientOptions, err := mongoDBClientOptions(db)
+	if err != nil {
+		return &Schema{Tables: []Table{}}, fmt.Errorf("failed to create MongoDB client: %v", err)
+	}
 
 	client, err := mongo.Connect(ctx, clientOptions)
 	if err != nil {
@@ -100,31 +147,96 @@ func fetchMongoDBSchema(db *Database) (*Schema, error) {
 
 	var tables []Table
 	for _, collName := range collections {
-		if strings.HasPrefix(collName, "system.") {
+		if strings.HasPrefix(collName, "system.") || !db.TableVisible(collName) {
 			continue
 		}
 
 		coll := database.Collection(collName)
-		var doc bson.M
-		err := coll.FindOne(ctx, bson.M{}).Decode(&doc)
+		columns, err := sampleMongoDBColumns(ctx, coll, MongoDBSchemaSampleSize)
+		if err != nil {
+			log.Printf("Error sampling documents for collection %s: %v", collName, err)
+			columns = []Column{}
+		}
 
-		columns := []Column{}
-		if err == nil {
-			columns = inferMongoDBColumns(doc)
-		} else if err != mongo.ErrNoDocuments {
-			log.Printf("Error fetching sample document for collection %s: %v", collName, err)
+		indexes, err := fetchMongoDBIndexes(ctx, coll)
+		if err != nil {
+			log.Printf("Error fetching indexes for collection %s: %v", collName, err)
 		}
 
 		tables = append(tables, Table{
 			Name:    collName,
 			Columns: columns,
+			Indexes: indexes,
 		})
 	}
 
-	return &Schema{Tables: tables}, nil
+	return &Schema{
+		Tables:        tables,
+		Relationships: inferMongoDBRelationships(tables),
+	}, nil
+}
+
+// fetchMongoDBIndexes fetches the indexes of a collection with their keys in order
+func fetchMongoDBIndexes(ctx context.Context, coll *mongo.Collection) ([]Index, error) {
+	specs, err := coll.Indexes().ListSpecifications(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	indexes := make([]Index, 0, len(specs))
+	for _, spec := range specs {
+		elements, err := spec.KeysDocument.Elements()
+		if err != nil {
+			return nil, err
+		}
+
+		index := Index{
+			Name:    spec.Name,
+			Unique:  spec.Unique != nil && *spec.Unique,
+			Primary: spec.Name == "_id_",
+		}
+		for _, element := range elements {
+			index.Columns = append(index.Columns, element.Key())
+		}
+		// The _id index is unique even though it isn't flagged as such
+		if index.Primary {
+			index.Unique = true
+		}
+		indexes = append(indexes, index)
+	}
+
+	return indexes, nil
+}
+
+// sampleMongoDBColumns infers a collection's columns from a random sample of
+// up to size documents, so fields missing from some documents are still found
+func sampleMongoDBColumns(ctx context.Context, coll *mongo.Collection, size int) ([]Column, error) {
+	cursor, err := coll.Aggregate(ctx, mongo.Pipeline{
+		bson.D{{Key: "$sample", Value: bson.M{"size": size}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	fields := newSampledFields()
+	for cursor.Next(ctx) {
+		var doc bson.M
+		if err := cursor.Decode(&doc); err != nil {
+			return nil, err
+		}
+		fields.add(inferMongoDBColumns(doc))
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, err
+	}
+
+	return fields.columns(), nil
 }
 
-// inferMongoDBColumns infers columns from a MongoDB document
+// inferMongoDBColumns infers columns from a MongoDB document. A column is
+// nullable when the document has it as null, sampledFields decides for fields
+// missing from some documents.
 func inferMongoDBColumns(doc bson.M) []Column {
 	return inferMongoDBColumnsWithPath(doc, "")
 }
@@ -211,7 +323,7 @@ func inferMongoDBColumnsWithPath(doc bson.M, parentPath string) []Column {
 		columns = append(columns, Column{
 			Name:       key,
 			Type:       dataType,
-			Nullable:   true,
+			Nullable:   value == nil,
 			PrimaryKey: false,
 			Fields:     fields,
 			Path:       path,
@@ -222,12 +334,14 @@ func inferMongoDBColumnsWithPath(doc bson.M, parentPath string) []Column {
 }
 
 // fetchMongoDBStats fetches statistics about a MongoDB database
-func fetchMongoDBStats(db *Database) (*DatabaseStats, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 120*time.Second)
+func fetchMongoDBStats(ctx context.Context, db *Database) (*DatabaseStats, error) {
+	ctx, cancel := context.WithTimeout(ctx, 120*time.Second)
 	defer cancel()
 
-	connStr := getMongoDBConnectionString(db)
-	clientOptions := options.Client().ApplyURI(connStr)
+	clientOptions, err := mongoDBClientOptions(db)
+	if err != nil {
+		return &DatabaseStats{TableCount: 0, Size: "Unknown"}, fmt.Errorf("failed to create MongoDB client: %v", err)
+	}
 
 	client, err := mongo.Connect(ctx, clientOptions)
 	if err != nil {
@@ -281,26 +395,186 @@ func fetchMongoDBStats(db *Database) (*DatabaseStats, error) {
 	return &DatabaseStats{
 		TableCount: collectionCount,
 		Size:       size,
+		Tables:     fetchMongoDBCollectionStats(ctx, database, collections),
 	}, nil
 }
 
+// fetchMongoDBCollectionStats fetches the document count and total size,
+// including indexes, of each collection. Collections whose stats can't be
+// read, such as views, are skipped.
+func fetchMongoDBCollectionStats(ctx context.Context, database *mongo.Database, collections []string) []TableStats {
+	var tables []TableStats
+	for _, collName := range collections {
+		if strings.HasPrefix(collName, "system.") {
+			continue
+		}
+
+		var stats bson.M
+		err := database.RunCommand(ctx, bson.D{{Key: "collStats", Value: collName}}).Decode(&stats)
+		if err != nil {
+			log.Printf("Failed to get stats for collection %s: %v", collName, err)
+			continue
+		}
+
+		count, _ := toFloat(stats["count"])
+		sizeBytes, ok := toFloat(stats["totalSize"])
+		if !ok {
+			// totalSize was added in MongoDB 4.4
+			storageSize, _ := toFloat(stats["storageSize"])
+			indexSize, _ := toFloat(stats["totalIndexSize"])
+			sizeBytes = storageSize + indexSize
+		}
+
+		tables = append(tables, TableStats{
+			Name:      collName,
+			RowCount:  int64(count),
+			SizeBytes: int64(sizeBytes),
+			Size:      formatSize(int64(sizeBytes)),
+		})
+	}
+
+	return largestTables(tables)
+}
+
+// mongoDBWriteOperations are the generated operations that modify data
+var mongoDBWriteOperations = map[string]bool{
+	"insertOne":  true,
+	"updateMany": true,
+	"deleteMany": true,
+}
+
+// mongoDBWriteStageRegex matches aggregation stages that write their output
+var mongoDBWriteStageRegex = regexp.MustCompile(`"\$(out|merge)"`)
+
+// fetchMongoDBLatest returns the newest value of a date field in a MongoDB
+// collection. ObjectIDs count as the time they were generated.
+func fetchMongoDBLatest(ctx context.Context, db *Database, collection, field string) (*time.Time, error) {
+	clientOptions, err := mongoDBClientOptions(db)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create MongoDB client: %v", err)
+	}
+
+	client, err := mongo.Connect(ctx, clientOptions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create MongoDB client: %v", err)
+	}
+	defer client.Disconnect(ctx)
+
+	coll := client.Database(mongoDBDatabaseName(db)).Collection(collection)
+	opts := options.FindOne().
+		SetSort(bson.D{{Key: field, Value: -1}}).
+		SetProjection(bson.M{field: 1})
+	doc, err := coll.FindOne(ctx, bson.M{field: bson.M{"$exists": true}}, opts).DecodeBytes()
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read the newest %s: %v", field, err)
+	}
+
+	value, err := doc.LookupErr(strings.Split(field, ".")...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the newest %s: %v", field, err)
+	}
+
+	var latest time.Time
+	switch value.Type {
+	case bson.TypeDateTime:
+		latest = value.Time()
+	case bson.TypeTimestamp:
+		seconds, _ := value.Timestamp()
+		latest = time.Unix(int64(seconds), 0)
+	case bson.TypeObjectID:
+		latest = value.ObjectID().Timestamp()
+	default:
+		return nil, fmt.Errorf("field %s holds a %s, not a date", field, value.Type)
+	}
+	return &latest, nil
+}
+
+// sampleMongoDBDocuments reads up to limit documents of a collection with
+// only the given top-level fields. Collections larger than that are sampled
+// at random. It also returns the collection's estimated size, 0 when it
+// can't be estimated, e.g. for views.
+func sampleMongoDBDocuments(ctx context.Context, db *Database, collection string, fields []string, limit int) ([]QueryResult, int64, error) {
+	clientOptions, err := mongoDBClientOptions(db)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to create MongoDB client: %v", err)
+	}
+
+	client, err := mongo.Connect(ctx, clientOptions)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to create MongoDB client: %v", err)
+	}
+	defer client.Disconnect(ctx)
+
+	coll := client.Database(mongoDBDatabaseName(db)).Collection(collection)
+	estimate, err := coll.EstimatedDocumentCount(ctx)
+	if err != nil {
+		estimate = 0
+	}
+
+	projection := bson.M{}
+	for _, field := range fields {
+		projection[field] = 1
+	}
+	pipeline := mongo.Pipeline{{{Key: "$limit", Value: limit}}}
+	if estimate > int64(limit) {
+		pipeline = mongo.Pipeline{{{Key: "$sample", Value: bson.M{"size": limit}}}}
+	}
+	if len(projection) > 0 {
+		pipeline = append(pipeline, bson.D{{Key: "$project", Value: projection}})
+	}
+
+	cursor, err := coll.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to sample %s: %v", collection, err)
+	}
+	defer cursor.Close(ctx)
+
+	var results []QueryResult
+	for cursor.Next(ctx) {
+		var doc bson.M
+		if err := cursor.Decode(&doc); err != nil {
+			return nil, 0, fmt.Errorf("failed to decode document: %v", err)
+		}
+		results = append(results, QueryResult(doc))
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, 0, fmt.Errorf("failed to sample %s: %v", collection, err)
+	}
+
+	return results, estimate, nil
+}
+
+// isMongoDBWriteQuery reports whether generated MongoDB code modifies data
+func isMongoDBWriteQuery(code string) bool {
+	operationMatch := regexp.MustCompile(`var operation = "([^"]+)"`).FindStringSubmatch(code)
+	if len(operationMatch) >= 2 && mongoDBWriteOperations[operationMatch[1]] {
+		return true
+	}
+	return mongoDBWriteStageRegex.MatchString(code)
+}
+
 // executeMongoDBQuery executes a MongoDB query
-func executeMongoDBQuery(db *Database, query string, startTime time.Time) ([]QueryResult, string, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 120*time.Second)
+func executeMongoDBQuery(ctx context.Context, db *Database, query string, startTime time.Time, allowWrites bool) ([]QueryResult, []ResultColumn, string, error) {
+	ctx, cancel := context.WithTimeout(ctx, db.queryTimeout(120*time.Second))
 	defer cancel()
 
-	connStr := getMongoDBConnectionString(db)
-	clientOptions := options.Client().ApplyURI(connStr)
+	clientOptions, err := mongoDBClientOptions(db)
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("failed to create MongoDB client: %v", err)
+	}
 
 	client, err := mongo.Connect(ctx, clientOptions)
 	if err != nil {
-		return nil, "", fmt.Errorf("failed to create MongoDB client: %v", err)
+		return nil, nil, "", fmt.Errorf("failed to create MongoDB client: %v", err)
 	}
 	defer client.Disconnect(ctx)
 
 	err = client.Ping(ctx, readpref.Primary())
 	if err != nil {
-		return nil, "", fmt.Errorf("failed to connect to MongoDB: %v", err)
+		return nil, nil, "", fmt.Errorf("failed to connect to MongoDB: %v", err)
 	}
 
 	var dbName string
@@ -317,18 +591,19 @@ func executeMongoDBQuery(db *Database, query string, startTime time.Time) ([]Que
 	}
 
 	database := client.Database(dbName)
-	return executeMongoDBGoCode(database, query, ctx, startTime)
+	return executeMongoDBGoCode(database, query, ctx, startTime, allowWrites, db.execution().DefaultLimit, db.rowLimit())
 }
 
-// executeMongoDBGoCode executes MongoDB queries from Go code generated by AI
-func executeMongoDBGoCode(database *mongo.Database, code string, ctx context.Context, startTime time.Time) ([]QueryResult, string, error) {
+// executeMongoDBGoCode executes MongoDB queries from Go code generated by AI.
+// Reads without a limit get defaultLimit, and finds never return more than maxRows.
+func executeMongoDBGoCode(database *mongo.Database, code string, ctx context.Context, startTime time.Time, allowWrites bool, defaultLimit, maxRows int) ([]QueryResult, []ResultColumn, string, error) {
 	fmt.Printf("Executing MongoDB Go code:\n%s\n", code)
 
 	// Extract collection name
 	collectionRegex := regexp.MustCompile(`var collection = "([^"]+)"`)
 	collectionMatch := collectionRegex.FindStringSubmatch(code)
 	if len(collectionMatch) < 2 {
-		return nil, "", fmt.Errorf("missing collection name in generated code")
+		return nil, nil, "", fmt.Errorf("missing collection name in generated code")
 	}
 	collectionName := collectionMatch[1]
 
@@ -336,15 +611,30 @@ func executeMongoDBGoCode(database *mongo.Database, code string, ctx context.Con
 	operationRegex := regexp.MustCompile(`var operation = "([^"]+)"`)
 	operationMatch := operationRegex.FindStringSubmatch(code)
 	if len(operationMatch) < 2 {
-		return nil, "", fmt.Errorf("missing operation type in generated code")
+		return nil, nil, "", fmt.Errorf("missing operation type in generated code")
 	}
 	operationType := operationMatch[1]
 
+	// Writes only run once they have been explicitly confirmed
+	if !allowWrites && isMongoDBWriteQuery(code) {
+		return nil, nil, "", fmt.Errorf("write operation %s requires confirmation", operationType)
+	}
+
+	if mongoDBWriteOperations[operationType] {
+		results, err := executeMongoDBWrite(ctx, database.Collection(collectionName), operationType, code)
+		if err != nil {
+			return nil, nil, "", err
+		}
+		return toQueryResults(results), inferResultColumns(results), time.Since(startTime).String(), nil
+	}
+
 	var filter bson.M
 	var findOptions *options.FindOptions
 	var pipeline mongo.Pipeline
+	var distinctField string
 
-	if operationType == "find" {
+	switch operationType {
+	case "find", "findOne", "countDocuments", "distinct":
 		// Extract filter
 		filterRegex := regexp.MustCompile(`\*FILTER_START([\s\S]*?)\*FILTER_END`)
 		filterMatch := filterRegex.FindStringSubmatch(code)
@@ -364,6 +654,21 @@ func executeMongoDBGoCode(database *mongo.Database, code string, ctx context.Con
 			}
 		}
 
+		// Extract the field for distinct operations
+		if operationType == "distinct" {
+			fieldRegex := regexp.MustCompile(`var field = "([^"]+)"`)
+			fieldMatch := fieldRegex.FindStringSubmatch(code)
+			if len(fieldMatch) < 2 {
+				return nil, nil, "", fmt.Errorf("missing field name for distinct operation in generated code")
+			}
+			distinctField = fieldMatch[1]
+			break
+		}
+
+		if operationType == "countDocuments" {
+			break
+		}
+
 		// Initialize findOptions
 		findOptions = options.Find()
 
@@ -412,75 +717,153 @@ func executeMongoDBGoCode(database *mongo.Database, code string, ctx context.Con
 				}
 			}
 		}
-	} else if operationType == "aggregate" {
-		// Extract pipeline
-		pipelineRegex := regexp.MustCompile(`\*PIPELINE_START([\s\S]*?)\*PIPELINE_END`)
-		pipelineMatch := pipelineRegex.FindStringSubmatch(code)
-		if len(pipelineMatch) >= 2 {
-			pipelineContent := strings.TrimSpace(pipelineMatch[1])
-			pipelineContent = strings.TrimPrefix(pipelineContent, "mongo.Pipeline{")
-			pipelineContent = strings.TrimSuffix(pipelineContent, "}")
-			if pipelineContent != "" {
-				stages := splitPipelineStages(pipelineContent)
-				for _, stage := range stages {
-					stageContent := strings.TrimSpace(stage)
-					if strings.HasPrefix(stageContent, "bson.D{") {
-						stageContent = strings.TrimPrefix(stageContent, "bson.D{")
-						stageContent = strings.TrimSuffix(stageContent, "}")
-						s, err := parseBSOND(stageContent)
-						if err == nil {
-							pipeline = append(pipeline, s)
-						} else {
-							fmt.Printf("Error parsing pipeline stage: %v\n", err)
-						}
-					}
-				}
-			}
+
+		// Fall back to the connection's default limit, and never fetch more
+		// rows than the connection keeps
+		if findOptions.Limit == nil && defaultLimit > 0 {
+			findOptions.SetLimit(int64(defaultLimit))
+		}
+		if findOptions.Limit == nil || *findOptions.Limit <= 0 || *findOptions.Limit > int64(maxRows) {
+			findOptions.SetLimit(int64(maxRows))
+		}
+
+		// findOne is a find that returns at most a single document
+		if operationType == "findOne" {
+			findOptions.SetLimit(1)
 		}
-	} else {
-		return nil, "", fmt.Errorf("unsupported MongoDB operation: %s", operationType)
+	case "aggregate":
+		pipeline = parseMongoDBPipeline(code)
+	default:
+		return nil, nil, "", fmt.Errorf("unsupported MongoDB operation: %s", operationType)
+	}
+
+	if filter == nil {
+		filter = bson.M{}
 	}
 
 	var results []bson.M
 
-	if operationType == "find" {
-		if filter == nil {
-			filter = bson.M{}
-		}
+	switch operationType {
+	case "find", "findOne":
 		if findOptions == nil {
 			findOptions = options.Find()
 		}
 
-		fmt.Printf("Executing find on collection '%s' with filter: %+v, options: %+v\n", collectionName, filter, findOptions)
+		fmt.Printf("Executing %s on collection '%s' with filter: %+v, options: %+v\n", operationType, collectionName, filter, findOptions)
 		cursor, err := database.Collection(collectionName).Find(ctx, filter, findOptions)
 		if err != nil {
-			return nil, "", fmt.Errorf("failed to execute find query: %v", err)
+			return nil, nil, "", fmt.Errorf("failed to execute find query: %v", err)
 		}
 		defer cursor.Close(ctx)
 
 		if err := cursor.All(ctx, &results); err != nil {
-			return nil, "", fmt.Errorf("failed to decode results: %v", err)
+			return nil, nil, "", fmt.Errorf("failed to decode results: %v", err)
+		}
+	case "countDocuments":
+		fmt.Printf("Executing countDocuments on collection '%s' with filter: %+v\n", collectionName, filter)
+		count, err := database.Collection(collectionName).CountDocuments(ctx, filter)
+		if err != nil {
+			return nil, nil, "", fmt.Errorf("failed to execute count query: %v", err)
 		}
-	} else if operationType == "aggregate" {
+
+		results = []bson.M{{"count": count}}
+	case "distinct":
+		fmt.Printf("Executing distinct '%s' on collection '%s' with filter: %+v\n", distinctField, collectionName, filter)
+		values, err := database.Collection(collectionName).Distinct(ctx, distinctField, filter)
+		if err != nil {
+			return nil, nil, "", fmt.Errorf("failed to execute distinct query: %v", err)
+		}
+
+		// Return one row per distinct value
+		results = make([]bson.M, len(values))
+		for i, value := range values {
+			results[i] = bson.M{distinctField: value}
+		}
+	case "aggregate":
 		if len(pipeline) == 0 {
 			pipeline = mongo.Pipeline{
 				bson.D{{Key: "$match", Value: bson.M{}}},
 				bson.D{{Key: "$limit", Value: 100}},
 			}
 		}
+		pipeline = withMongoDBDefaultLimit(pipeline, defaultLimit)
 
 		fmt.Printf("Executing aggregate on collection '%s' with pipeline: %+v\n", collectionName, pipeline)
 		cursor, err := database.Collection(collectionName).Aggregate(ctx, pipeline)
 		if err != nil {
-			return nil, "", fmt.Errorf("failed to execute aggregate query: %v", err)
+			return nil, nil, "", fmt.Errorf("failed to execute aggregate query: %v", err)
 		}
 		defer cursor.Close(ctx)
 
 		if err := cursor.All(ctx, &results); err != nil {
-			return nil, "", fmt.Errorf("failed to decode results: %v", err)
+			return nil, nil, "", fmt.Errorf("failed to decode results: %v", err)
 		}
 	}
 
+	executionTime := time.Since(startTime).String()
+	return toQueryResults(results), inferResultColumns(results), executionTime, nil
+}
+
+// executeMongoDBWrite executes a confirmed insertOne, updateMany or deleteMany operation
+func executeMongoDBWrite(ctx context.Context, collection *mongo.Collection, operationType, code string) ([]bson.M, error) {
+	filter, err := extractBSONMBlock(code, "FILTER")
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse filter: %v", err)
+	}
+
+	switch operationType {
+	case "insertOne":
+		document, err := extractBSONMBlock(code, "DOCUMENT")
+		if err != nil || len(document) == 0 {
+			return nil, fmt.Errorf("missing or invalid document for insertOne")
+		}
+
+		result, err := collection.InsertOne(ctx, document)
+		if err != nil {
+			return nil, fmt.Errorf("failed to execute insert: %v", err)
+		}
+		return []bson.M{{"inserted_id": result.InsertedID}}, nil
+	case "updateMany":
+		update, err := extractBSONMBlock(code, "UPDATE")
+		if err != nil || len(update) == 0 {
+			return nil, fmt.Errorf("missing or invalid update for updateMany")
+		}
+
+		result, err := collection.UpdateMany(ctx, filter, update)
+		if err != nil {
+			return nil, fmt.Errorf("failed to execute update: %v", err)
+		}
+		return []bson.M{{"matched_count": result.MatchedCount, "modified_count": result.ModifiedCount}}, nil
+	case "deleteMany":
+		result, err := collection.DeleteMany(ctx, filter)
+		if err != nil {
+			return nil, fmt.Errorf("failed to execute delete: %v", err)
+		}
+		return []bson.M{{"deleted_count": result.DeletedCount}}, nil
+	default:
+		return nil, fmt.Errorf("unsupported MongoDB write operation: %s", operationType)
+	}
+}
+
+// extractBSONMBlock parses the bson.M wrapped in *NAME_START / *NAME_END placeholders
+func extractBSONMBlock(code, name string) (bson.M, error) {
+	blockRegex := regexp.MustCompile(`\*` + name + `_START([\s\S]*?)\*` + name + `_END`)
+	match := blockRegex.FindStringSubmatch(code)
+	if len(match) < 2 {
+		return bson.M{}, nil
+	}
+
+	content := strings.TrimSpace(match[1])
+	if !strings.HasPrefix(content, "bson.M{") {
+		return nil, fmt.Errorf("expected bson.M in %s block", name)
+	}
+	content = strings.TrimPrefix(content, "bson.M{")
+	content = strings.TrimSuffix(content, "}")
+	return parseBSONM(content)
+}
+
+// toQueryResults converts MongoDB documents to sanitized query results
+func toQueryResults(results []bson.M) []QueryResult {
 	queryResults := make([]QueryResult, len(results))
 	for i, result := range results {
 		queryResult := make(QueryResult)
@@ -489,9 +872,82 @@ func executeMongoDBGoCode(database *mongo.Database, code string, ctx context.Con
 		}
 		queryResults[i] = queryResult
 	}
+	return queryResults
+}
 
-	executionTime := time.Since(startTime).String()
-	return queryResults, executionTime, nil
+// inferResultColumns infers result column metadata from MongoDB documents
+func inferResultColumns(results []bson.M) []ResultColumn {
+	types := make(map[string]string)
+	present := make(map[string]int)
+	nulls := make(map[string]bool)
+
+	for _, result := range results {
+		for key, value := range result {
+			present[key]++
+			if value == nil {
+				nulls[key] = true
+				continue
+			}
+
+			valueType := mongoValueType(value)
+			if existing, ok := types[key]; ok && existing != valueType {
+				types[key] = "mixed"
+			} else if !ok {
+				types[key] = valueType
+			}
+		}
+	}
+
+	// Keep _id first and the rest in alphabetical order
+	names := make([]string, 0, len(present))
+	for name := range present {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if names[i] == "_id" || names[j] == "_id" {
+			return names[i] == "_id"
+		}
+		return names[i] < names[j]
+	})
+
+	columns := make([]ResultColumn, 0, len(names))
+	for _, name := range names {
+		dbType, ok := types[name]
+		if !ok {
+			dbType = "null"
+		}
+		columns = append(columns, ResultColumn{
+			Name:     name,
+			DBType:   dbType,
+			Nullable: nulls[name] || present[name] < len(results),
+		})
+	}
+
+	return columns
+}
+
+// mongoValueType returns a simple type name for a MongoDB value
+func mongoValueType(value interface{}) string {
+	switch value.(type) {
+	case string:
+		return "string"
+	case int, int32, int64, float32, float64, primitive.Decimal128:
+		return "number"
+	case bool:
+		return "boolean"
+	case time.Time, primitive.DateTime, primitive.Timestamp:
+		return "date"
+	case primitive.ObjectID:
+		return "ObjectID"
+	case bson.A, []interface{}:
+		return "array"
+	case bson.M, bson.D, map[string]interface{}:
+		return "object"
+	case nil:
+		return "null"
+	default:
+		return "unknown"
+	}
 }
 
 // parseBSONM parses a bson.M string into a bson.M map, handling dot notation
@@ -621,6 +1077,41 @@ func splitBSONPairs(content string) []string {
 	return pairs
 }
 
+// mongoDBPipelineRegex matches the pipeline of generated aggregate code
+var mongoDBPipelineRegex = regexp.MustCompile(`\*PIPELINE_START([\s\S]*?)\*PIPELINE_END`)
+
+// parseMongoDBPipeline extracts the pipeline of generated aggregate code.
+// Stages that can't be parsed are skipped.
+func parseMongoDBPipeline(code string) mongo.Pipeline {
+	var pipeline mongo.Pipeline
+	pipelineMatch := mongoDBPipelineRegex.FindStringSubmatch(code)
+	if len(pipelineMatch) < 2 {
+		return pipeline
+	}
+
+	pipelineContent := strings.TrimSpace(pipelineMatch[1])
+	pipelineContent = strings.TrimPrefix(pipelineContent, "mongo.Pipeline{")
+	pipelineContent = strings.TrimSuffix(pipelineContent, "}")
+	if pipelineContent == "" {
+		return pipeline
+	}
+
+	for _, stage := range splitPipelineStages(pipelineContent) {
+		stageContent := strings.TrimSpace(stage)
+		if strings.HasPrefix(stageContent, "bson.D{") {
+			stageContent = strings.TrimPrefix(stageContent, "bson.D{")
+			stageContent = strings.TrimSuffix(stageContent, "}")
+			s, err := parseBSOND(stageContent)
+			if err == nil {
+				pipeline = append(pipeline, s)
+			} else {
+				fmt.Printf("Error parsing pipeline stage: %v\n", err)
+			}
+		}
+	}
+	return pipeline
+}
+
 // splitPipelineStages splits a pipeline string into individual stages
 func splitPipelineStages(content string) []string {
 	var stages []string
@@ -0,0 +1,99 @@
+package models
+
+import "sort"
+
+// MongoDBSchemaSampleSize is the number of documents sampled per collection
+// when inferring a MongoDB schema
+var MongoDBSchemaSampleSize = 100
+
+// sampledField accumulates what was seen of one field across sampled documents
+type sampledField struct {
+	column   Column
+	types    map[string]int
+	fields   *sampledFields
+	seen     int  // Documents the field was in
+	nullable bool // Whether it was null in any of them
+}
+
+// sampledFields merges the columns inferred from many documents
+type sampledFields struct {
+	fields map[string]*sampledField
+	docs   int // Documents merged, a field seen in fewer is missing from some
+}
+
+// newSampledFields creates an empty field set
+func newSampledFields() *sampledFields {
+	return &sampledFields{fields: make(map[string]*sampledField)}
+}
+
+// add merges the columns inferred from one document
+func (s *sampledFields) add(columns []Column) {
+	s.docs++
+	for _, column := range columns {
+		field, ok := s.fields[column.Name]
+		if !ok {
+			field = &sampledField{
+				column: column,
+				types:  make(map[string]int),
+				fields: newSampledFields(),
+			}
+			s.fields[column.Name] = field
+		}
+
+		field.types[column.Type]++
+		field.seen++
+		field.nullable = field.nullable || column.Nullable
+		field.fields.add(column.Fields)
+	}
+}
+
+// columns returns the merged columns, _id first and the rest by name. Each
+// column takes its most frequent non-null type and is flagged when the
+// sampled documents disagree. It is nullable when any document had it null
+// or didn't have it.
+func (s *sampledFields) columns() []Column {
+	columns := make([]Column, 0, len(s.fields))
+	for _, field := range s.fields {
+		column := field.column
+		column.Type = dominantType(field.types)
+		column.Types = field.types
+		column.Nullable = field.nullable || field.seen < s.docs
+		column.Fields = field.fields.columns()
+		if len(column.Fields) == 0 {
+			column.Fields = nil
+		}
+
+		nonNull := 0
+		for t := range field.types {
+			if t != "null" {
+				nonNull++
+			}
+		}
+		column.MixedTypes = nonNull > 1
+
+		columns = append(columns, column)
+	}
+
+	sort.Slice(columns, func(i, j int) bool {
+		if columns[i].Name == "_id" || columns[j].Name == "_id" {
+			return columns[i].Name == "_id"
+		}
+		return columns[i].Name < columns[j].Name
+	})
+	return columns
+}
+
+// dominantType returns the most frequent non-null type, or null when the
+// field was always null. Ties are broken by name to keep results stable.
+func dominantType(types map[string]int) string {
+	best, bestCount := "null", 0
+	for t, count := range types {
+		if t == "null" {
+			continue
+		}
+		if count > bestCount || (count == bestCount && t < best) {
+			best, bestCount = t, count
+		}
+	}
+	return best
+}
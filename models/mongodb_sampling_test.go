@@ -0,0 +1,49 @@
+package models
+
+import (
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestSampledFieldsNullable(t *testing.T) {
+	docs := []bson.M{
+		{"_id": primitive.NewObjectID(), "name": "Ada", "email": "ada@example.com", "nickname": "ada", "address": bson.M{"city": "London", "zip": "N1"}},
+		{"_id": primitive.NewObjectID(), "name": "Grace", "email": nil, "address": bson.M{"city": "New York"}},
+		{"_id": primitive.NewObjectID(), "name": "Linus", "email": "linus@example.com", "nickname": "torvalds", "address": bson.M{"city": "Portland", "zip": "97201"}},
+	}
+
+	fields := newSampledFields()
+	for _, doc := range docs {
+		fields.add(inferMongoDBColumns(doc))
+	}
+
+	nullable := map[string]bool{}
+	for _, column := range fields.columns() {
+		nullable[column.Name] = column.Nullable
+		for _, field := range column.Fields {
+			nullable[column.Name+"."+field.Name] = field.Nullable
+		}
+	}
+
+	want := map[string]bool{
+		"_id":          false,
+		"name":         false,
+		"email":        true, // Null in one document
+		"nickname":     true, // Missing from one document
+		"address":      false,
+		"address.city": false,
+		"address.zip":  true,
+	}
+	for name, wantNullable := range want {
+		got, ok := nullable[name]
+		if !ok {
+			t.Errorf("column %s wasn't inferred", name)
+			continue
+		}
+		if got != wantNullable {
+			t.Errorf("column %s nullable = %v, want %v", name, got, wantNullable)
+		}
+	}
+}
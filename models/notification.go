@@ -0,0 +1,116 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// notificationRetention is how long in-app notifications are kept
+const notificationRetention = 90 * 24 * time.Hour
+
+// Notification is a message in a user's in-app inbox, sent through one of
+// their in-app channels, or directly when someone mentions them
+type Notification struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	UserID    primitive.ObjectID `json:"user_id" bson:"user_id"`
+	ChannelID primitive.ObjectID `json:"channel_id,omitempty" bson:"channel_id,omitempty"` // Unset for mentions
+	Source    string             `json:"source" bson:"source"`
+	Title     string             `json:"title" bson:"title"`
+	Body      string             `json:"body,omitempty" bson:"body,omitempty"`
+	Link      string             `json:"link,omitempty" bson:"link,omitempty"`
+	ReadAt    *time.Time         `json:"read_at,omitempty" bson:"read_at,omitempty"`
+	CreatedAt time.Time          `json:"created_at" bson:"created_at"`
+}
+
+// notificationCollection returns the in-app notifications collection
+func (s *mongoStore) notificationCollection() *mongo.Collection {
+	return s.db.Collection("notifications")
+}
+
+// ensureNotificationIndexes creates the indexes notifications are listed and
+// expired by
+func (s *mongoStore) ensureNotificationIndexes(ctx context.Context) error {
+	_, err := s.notificationCollection().Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}},
+			Options: options.Index().SetName("notification_user"),
+		},
+		{
+			Keys:    bson.D{{Key: "created_at", Value: 1}},
+			Options: options.Index().SetName("notification_expiry").SetExpireAfterSeconds(int32(notificationRetention.Seconds())),
+		},
+	})
+	return err
+}
+
+// CreateNotification adds a notification to a user's inbox
+func (s *mongoStore) CreateNotification(ctx context.Context, notification *Notification) error {
+	notification.CreatedAt = time.Now()
+
+	result, err := s.notificationCollection().InsertOne(ctx, notification)
+	if err != nil {
+		return err
+	}
+
+	// Set the ID
+	notification.ID = result.InsertedID.(primitive.ObjectID)
+
+	return nil
+}
+
+// GetNotifications retrieves a user's most recent notifications, only the
+// unread ones when unreadOnly is set
+func (s *mongoStore) GetNotifications(ctx context.Context, userID primitive.ObjectID, unreadOnly bool, limit int) ([]*Notification, error) {
+	filter := bson.M{"user_id": userID}
+	if unreadOnly {
+		filter["read_at"] = bson.M{"$exists": false}
+	}
+
+	opts := options.Find().
+		SetSort(bson.M{"created_at": -1}).
+		SetLimit(int64(limit))
+
+	cursor, err := s.notificationCollection().Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	notifications := []*Notification{}
+	if err := cursor.All(ctx, &notifications); err != nil {
+		return nil, err
+	}
+
+	return notifications, nil
+}
+
+// CountUnreadNotifications counts a user's unread notifications
+func (s *mongoStore) CountUnreadNotifications(ctx context.Context, userID primitive.ObjectID) (int64, error) {
+	return s.notificationCollection().CountDocuments(ctx, bson.M{
+		"user_id": userID,
+		"read_at": bson.M{"$exists": false},
+	})
+}
+
+// MarkNotificationsRead marks a user's notifications as read, all of them
+// when no IDs are given, and returns how many were unread
+func (s *mongoStore) MarkNotificationsRead(ctx context.Context, userID primitive.ObjectID, ids []primitive.ObjectID) (int64, error) {
+	filter := bson.M{
+		"user_id": userID,
+		"read_at": bson.M{"$exists": false},
+	}
+	if len(ids) > 0 {
+		filter["_id"] = bson.M{"$in": ids}
+	}
+
+	result, err := s.notificationCollection().UpdateMany(ctx, filter, bson.M{"$set": bson.M{"read_at": time.Now()}})
+	if err != nil {
+		return 0, err
+	}
+	return result.ModifiedCount, nil
+}
@@ -0,0 +1,297 @@
+package models
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Types of notification channels
+const (
+	ChannelTypeEmail   = "email"   // A list of email addresses
+	ChannelTypeSlack   = "slack"   // A Slack incoming webhook
+	ChannelTypeWebhook = "webhook" // Any URL, sent signed JSON
+	ChannelTypeInApp   = "in_app"  // The owner's notification inbox
+)
+
+// Sources of the notifications sent through channels
+const (
+	NotificationSourceAlert      = "alert"
+	NotificationSourceReport     = "report"
+	NotificationSourceJobFailure = "job_failure"
+	NotificationSourceFreshness  = "freshness"
+	NotificationSourceTest       = "test"
+	NotificationSourceMention    = "mention" // Only sent to the in-app inbox
+)
+
+// NotificationChannel is somewhere a user's notifications can be sent.
+// Alerts and report schedules refer to the channels they notify, and channels
+// an administrator marks receive background job failures.
+type NotificationChannel struct {
+	ID          primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	UserID      primitive.ObjectID `json:"user_id" bson:"user_id"`
+	Name        string             `json:"name" bson:"name"`
+	Type        string             `json:"type" bson:"type"`
+	Emails      []string           `json:"emails,omitempty" bson:"emails,omitempty"` // Email channels
+	URL         string             `json:"url,omitempty" bson:"url,omitempty"`       // Generic webhook channels
+	SlackURL    string             `json:"-" bson:"slack_url,omitempty"`             // Slack channels, a credential so never returned
+	Secret      string             `json:"-" bson:"secret,omitempty"`                // Signs generic webhook requests, only shown when created
+	JobFailures bool               `json:"job_failures" bson:"job_failures"`         // Receives background job failures, set by administrators
+	CreatedAt   time.Time          `json:"created_at" bson:"created_at"`
+	UpdatedAt   time.Time          `json:"updated_at" bson:"updated_at"`
+}
+
+// ChannelDeliveryStatus represents the outcome of a channel delivery
+type ChannelDeliveryStatus string
+
+const (
+	ChannelDeliveryPending ChannelDeliveryStatus = "pending"
+	ChannelDeliverySent    ChannelDeliveryStatus = "sent"
+	ChannelDeliveryFailed  ChannelDeliveryStatus = "failed"
+)
+
+// ChannelDelivery records a notification sent through a channel and its
+// latest attempt. Pending deliveries are retried until they are sent or run
+// out of attempts.
+type ChannelDelivery struct {
+	ID            primitive.ObjectID     `json:"id" bson:"_id,omitempty"`
+	ChannelID     primitive.ObjectID     `json:"channel_id" bson:"channel_id"`
+	Source        string                 `json:"source" bson:"source"`
+	Title         string                 `json:"title" bson:"title"`
+	Body          string                 `json:"body,omitempty" bson:"body,omitempty"`
+	Link          string                 `json:"link,omitempty" bson:"link,omitempty"`
+	Data          map[string]interface{} `json:"data,omitempty" bson:"data,omitempty"` // Sent to generic webhooks
+	Status        ChannelDeliveryStatus  `json:"status" bson:"status"`
+	Attempts      int                    `json:"attempts" bson:"attempts"`
+	Error         string                 `json:"error,omitempty" bson:"error,omitempty"`
+	CreatedAt     time.Time              `json:"created_at" bson:"created_at"`
+	LastAttemptAt *time.Time             `json:"last_attempt_at,omitempty" bson:"last_attempt_at,omitempty"`
+	DeliveredAt   *time.Time             `json:"delivered_at,omitempty" bson:"delivered_at,omitempty"`
+}
+
+// channelDeliveryRetention is how long channel deliveries are kept
+const channelDeliveryRetention = 30 * 24 * time.Hour
+
+// notificationChannelCollection returns the notification channels collection
+func (s *mongoStore) notificationChannelCollection() *mongo.Collection {
+	return s.db.Collection("notification_channels")
+}
+
+// channelDeliveryCollection returns the channel deliveries collection
+func (s *mongoStore) channelDeliveryCollection() *mongo.Collection {
+	return s.db.Collection("channel_deliveries")
+}
+
+// ensureNotificationChannelIndexes creates the indexes channels are looked
+// up by, and deliveries are listed and expired by
+func (s *mongoStore) ensureNotificationChannelIndexes(ctx context.Context) error {
+	_, err := s.notificationChannelCollection().Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}},
+			Options: options.Index().SetName("notification_channel_user"),
+		},
+		{
+			Keys:    bson.D{{Key: "job_failures", Value: 1}},
+			Options: options.Index().SetName("notification_channel_job_failures"),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = s.channelDeliveryCollection().Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "channel_id", Value: 1}, {Key: "created_at", Value: -1}},
+			Options: options.Index().SetName("channel_delivery_channel"),
+		},
+		{
+			Keys:    bson.D{{Key: "created_at", Value: 1}},
+			Options: options.Index().SetName("channel_delivery_expiry").SetExpireAfterSeconds(int32(channelDeliveryRetention.Seconds())),
+		},
+	})
+	return err
+}
+
+// CreateNotificationChannel creates a new notification channel. Generic
+// webhook channels get a random signing secret.
+func (s *mongoStore) CreateNotificationChannel(ctx context.Context, channel *NotificationChannel) (*NotificationChannel, error) {
+	if channel.Type == ChannelTypeWebhook {
+		buf := make([]byte, 32)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, err
+		}
+		channel.Secret = "whsec_" + hex.EncodeToString(buf)
+	}
+
+	// Set timestamps
+	now := time.Now()
+	channel.CreatedAt = now
+	channel.UpdatedAt = now
+
+	result, err := s.notificationChannelCollection().InsertOne(ctx, channel)
+	if err != nil {
+		return nil, err
+	}
+
+	// Set the ID
+	channel.ID = result.InsertedID.(primitive.ObjectID)
+
+	return channel, nil
+}
+
+// GetNotificationChannelByID retrieves a notification channel by ID
+func (s *mongoStore) GetNotificationChannelByID(ctx context.Context, id primitive.ObjectID) (*NotificationChannel, error) {
+	var channel NotificationChannel
+	err := s.notificationChannelCollection().FindOne(ctx, bson.M{"_id": id}).Decode(&channel)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &channel, nil
+}
+
+// GetNotificationChannelsByUserID retrieves a user's notification channels,
+// only those with the given IDs when any are given
+func (s *mongoStore) GetNotificationChannelsByUserID(ctx context.Context, userID primitive.ObjectID, ids []primitive.ObjectID) ([]*NotificationChannel, error) {
+	filter := bson.M{"user_id": userID}
+	if len(ids) > 0 {
+		filter["_id"] = bson.M{"$in": ids}
+	}
+
+	opts := options.Find().SetSort(bson.M{"created_at": -1})
+	cursor, err := s.notificationChannelCollection().Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	channels := []*NotificationChannel{}
+	if err := cursor.All(ctx, &channels); err != nil {
+		return nil, err
+	}
+
+	return channels, nil
+}
+
+// GetJobFailureChannels retrieves the channels that receive background job
+// failures
+func (s *mongoStore) GetJobFailureChannels(ctx context.Context) ([]*NotificationChannel, error) {
+	cursor, err := s.notificationChannelCollection().Find(ctx, bson.M{"job_failures": true})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var channels []*NotificationChannel
+	if err := cursor.All(ctx, &channels); err != nil {
+		return nil, err
+	}
+
+	return channels, nil
+}
+
+// UpdateNotificationChannel updates a channel's name, destination and
+// whether it receives job failures. Its type can't change.
+func (s *mongoStore) UpdateNotificationChannel(ctx context.Context, channel *NotificationChannel) error {
+	channel.UpdatedAt = time.Now()
+
+	_, err := s.notificationChannelCollection().UpdateOne(
+		ctx,
+		bson.M{"_id": channel.ID},
+		bson.M{"$set": bson.M{
+			"name":         channel.Name,
+			"emails":       channel.Emails,
+			"url":          channel.URL,
+			"slack_url":    channel.SlackURL,
+			"job_failures": channel.JobFailures,
+			"updated_at":   channel.UpdatedAt,
+		}},
+	)
+	return err
+}
+
+// DeleteNotificationChannel deletes a channel and its delivery log
+func (s *mongoStore) DeleteNotificationChannel(ctx context.Context, id primitive.ObjectID) error {
+	if _, err := s.notificationChannelCollection().DeleteOne(ctx, bson.M{"_id": id}); err != nil {
+		return err
+	}
+
+	_, err := s.channelDeliveryCollection().DeleteMany(ctx, bson.M{"channel_id": id})
+	return err
+}
+
+// CreateChannelDelivery records a delivery. Deliveries are pending until
+// their first attempt unless a status is set.
+func (s *mongoStore) CreateChannelDelivery(ctx context.Context, delivery *ChannelDelivery) error {
+	if delivery.Status == "" {
+		delivery.Status = ChannelDeliveryPending
+	}
+	delivery.CreatedAt = time.Now()
+
+	result, err := s.channelDeliveryCollection().InsertOne(ctx, delivery)
+	if err != nil {
+		return err
+	}
+
+	// Set the ID
+	delivery.ID = result.InsertedID.(primitive.ObjectID)
+
+	return nil
+}
+
+// GetChannelDeliveryByID retrieves a channel delivery by ID
+func (s *mongoStore) GetChannelDeliveryByID(ctx context.Context, id primitive.ObjectID) (*ChannelDelivery, error) {
+	var delivery ChannelDelivery
+	err := s.channelDeliveryCollection().FindOne(ctx, bson.M{"_id": id}).Decode(&delivery)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &delivery, nil
+}
+
+// UpdateChannelDelivery records the outcome of a delivery attempt
+func (s *mongoStore) UpdateChannelDelivery(ctx context.Context, delivery *ChannelDelivery) error {
+	_, err := s.channelDeliveryCollection().UpdateOne(
+		ctx,
+		bson.M{"_id": delivery.ID},
+		bson.M{"$set": bson.M{
+			"status":          delivery.Status,
+			"attempts":        delivery.Attempts,
+			"error":           delivery.Error,
+			"last_attempt_at": delivery.LastAttemptAt,
+			"delivered_at":    delivery.DeliveredAt,
+		}},
+	)
+	return err
+}
+
+// GetChannelDeliveries retrieves the most recent deliveries of a channel
+func (s *mongoStore) GetChannelDeliveries(ctx context.Context, channelID primitive.ObjectID, limit int) ([]*ChannelDelivery, error) {
+	opts := options.Find().
+		SetSort(bson.M{"created_at": -1}).
+		SetLimit(int64(limit))
+
+	cursor, err := s.channelDeliveryCollection().Find(ctx, bson.M{"channel_id": channelID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	deliveries := []*ChannelDelivery{}
+	if err := cursor.All(ctx, &deliveries); err != nil {
+		return nil, err
+	}
+
+	return deliveries, nil
+}
@@ -0,0 +1,335 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// OrgRole represents a member's role in an organization
+type OrgRole string
+
+const (
+	OrgRoleNone     OrgRole = ""
+	OrgRoleMember   OrgRole = "member"
+	OrgRoleApprover OrgRole = "approver" // A member who also approves queries against production connections
+	OrgRoleAdmin    OrgRole = "admin"
+	OrgRoleOwner    OrgRole = "owner"
+)
+
+// OrganizationMember is a user belonging to an organization
+type OrganizationMember struct {
+	UserID   primitive.ObjectID `json:"user_id" bson:"user_id"`
+	Email    string             `json:"email" bson:"email"`
+	Name     string             `json:"name,omitempty" bson:"name,omitempty"`
+	Role     OrgRole            `json:"role" bson:"role"`
+	JoinedAt time.Time          `json:"joined_at" bson:"joined_at"`
+}
+
+// Organization groups users so they can share database connections, queries
+// and dashboards
+type Organization struct {
+	ID        primitive.ObjectID   `json:"id" bson:"_id,omitempty"`
+	Name      string               `json:"name" bson:"name"`
+	Members   []OrganizationMember `json:"members" bson:"members"`
+	SSO       *SSOConfig           `json:"sso,omitempty" bson:"sso,omitempty"`
+	CreatedAt time.Time            `json:"created_at" bson:"created_at"`
+	UpdatedAt time.Time            `json:"updated_at" bson:"updated_at"`
+}
+
+// organizationCollection returns the organizations collection
+func (s *mongoStore) organizationCollection() *mongo.Collection {
+	return s.db.Collection("organizations")
+}
+
+// ensureOrganizationIndexes creates the indexes organizations are found by:
+// their members and single sign-on domains
+func (s *mongoStore) ensureOrganizationIndexes(ctx context.Context) error {
+	_, err := s.organizationCollection().Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "members.user_id", Value: 1}},
+			Options: options.Index().SetName("organization_member"),
+		},
+		{
+			Keys:    bson.D{{Key: "sso.domains", Value: 1}},
+			Options: options.Index().SetName("organization_sso_domain").SetSparse(true),
+		},
+	})
+	return err
+}
+
+// RoleFor returns the role a user has in the organization
+func (o *Organization) RoleFor(userID primitive.ObjectID) OrgRole {
+	for _, member := range o.Members {
+		if member.UserID == userID {
+			return member.Role
+		}
+	}
+	return OrgRoleNone
+}
+
+// CanManage reports whether a user can change the organization and its members
+func (o *Organization) CanManage(userID primitive.ObjectID) bool {
+	role := o.RoleFor(userID)
+	return role == OrgRoleOwner || role == OrgRoleAdmin
+}
+
+// CreateOrganization creates an organization with its creator as the owner
+func (s *mongoStore) CreateOrganization(ctx context.Context, name string, owner *User) (*Organization, error) {
+	now := time.Now()
+	org := &Organization{
+		Name: name,
+		Members: []OrganizationMember{{
+			UserID:   owner.ID,
+			Email:    owner.Email,
+			Name:     owner.Name,
+			Role:     OrgRoleOwner,
+			JoinedAt: now,
+		}},
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	result, err := s.organizationCollection().InsertOne(ctx, org)
+	if err != nil {
+		return nil, err
+	}
+
+	// Set the ID
+	org.ID = result.InsertedID.(primitive.ObjectID)
+
+	return org, nil
+}
+
+// GetOrganizationByID retrieves an organization by ID
+func (s *mongoStore) GetOrganizationByID(ctx context.Context, id primitive.ObjectID) (*Organization, error) {
+	var org Organization
+	err := s.organizationCollection().FindOne(ctx, bson.M{"_id": id}).Decode(&org)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &org, nil
+}
+
+// GetOrganizationsByUserID retrieves the organizations a user is a member of
+func (s *mongoStore) GetOrganizationsByUserID(ctx context.Context, userID primitive.ObjectID) ([]*Organization, error) {
+	opts := options.Find().SetSort(bson.M{"name": 1})
+
+	cursor, err := s.organizationCollection().Find(ctx, bson.M{"members.user_id": userID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	organizations := []*Organization{}
+	if err := cursor.All(ctx, &organizations); err != nil {
+		return nil, err
+	}
+
+	return organizations, nil
+}
+
+// GetOrganizationIDsByUserID retrieves the IDs of the organizations a user is a member of
+func (s *mongoStore) GetOrganizationIDsByUserID(ctx context.Context, userID primitive.ObjectID) ([]primitive.ObjectID, error) {
+	opts := options.Find().SetProjection(bson.M{"_id": 1})
+
+	cursor, err := s.organizationCollection().Find(ctx, bson.M{"members.user_id": userID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var orgs []struct {
+		ID primitive.ObjectID `bson:"_id"`
+	}
+	if err := cursor.All(ctx, &orgs); err != nil {
+		return nil, err
+	}
+
+	ids := make([]primitive.ObjectID, 0, len(orgs))
+	for _, org := range orgs {
+		ids = append(ids, org.ID)
+	}
+	return ids, nil
+}
+
+// IsOrganizationMember reports whether a user belongs to an organization
+func (s *mongoStore) IsOrganizationMember(ctx context.Context, orgID, userID primitive.ObjectID) (bool, error) {
+	count, err := s.organizationCollection().CountDocuments(ctx, bson.M{"_id": orgID, "members.user_id": userID})
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// UpdateOrganization renames an organization
+func (s *mongoStore) UpdateOrganization(ctx context.Context, org *Organization) error {
+	org.UpdatedAt = time.Now()
+
+	_, err := s.organizationCollection().UpdateOne(
+		ctx,
+		bson.M{"_id": org.ID},
+		bson.M{"$set": bson.M{
+			"name":       org.Name,
+			"updated_at": org.UpdatedAt,
+		}},
+	)
+	return err
+}
+
+// DeleteOrganization deletes an organization and its query template library.
+// Resources shared with it go back to being private to their owners.
+func (s *mongoStore) DeleteOrganization(ctx context.Context, id primitive.ObjectID) error {
+	if _, err := s.organizationCollection().DeleteOne(ctx, bson.M{"_id": id}); err != nil {
+		return err
+	}
+
+	for _, collection := range s.resourceCollections() {
+		if _, err := collection.UpdateMany(ctx, bson.M{"org_id": id}, bson.M{"$unset": bson.M{"org_id": "", "workspace_id": ""}}); err != nil {
+			return err
+		}
+	}
+	if err := s.deleteOrganizationTemplates(ctx, id); err != nil {
+		return err
+	}
+	return s.deleteOrganizationWorkspaces(ctx, id)
+}
+
+// AddOrganizationMember adds a user to an organization. It does nothing when
+// the user is already a member.
+func (s *mongoStore) AddOrganizationMember(ctx context.Context, orgID primitive.ObjectID, member OrganizationMember) error {
+	member.JoinedAt = time.Now()
+
+	_, err := s.organizationCollection().UpdateOne(
+		ctx,
+		bson.M{"_id": orgID, "members.user_id": bson.M{"$ne": member.UserID}},
+		bson.M{
+			"$push": bson.M{"members": member},
+			"$set":  bson.M{"updated_at": member.JoinedAt},
+		},
+	)
+	return err
+}
+
+// UpdateOrganizationMemberRole changes a member's role
+func (s *mongoStore) UpdateOrganizationMemberRole(ctx context.Context, orgID, userID primitive.ObjectID, role OrgRole) error {
+	_, err := s.organizationCollection().UpdateOne(
+		ctx,
+		bson.M{"_id": orgID, "members.user_id": userID},
+		bson.M{"$set": bson.M{
+			"members.$.role": role,
+			"updated_at":     time.Now(),
+		}},
+	)
+	return err
+}
+
+// RemoveOrganizationMember removes a user from an organization
+func (s *mongoStore) RemoveOrganizationMember(ctx context.Context, orgID, userID primitive.ObjectID) error {
+	_, err := s.organizationCollection().UpdateOne(
+		ctx,
+		bson.M{"_id": orgID},
+		bson.M{
+			"$pull": bson.M{"members": bson.M{"user_id": userID}},
+			"$set":  bson.M{"updated_at": time.Now()},
+		},
+	)
+	return err
+}
+
+// SetDatabaseOrganization shares a database connection with an organization and
+// optionally one of its workspaces, or makes it private again when orgID is zero
+func (s *mongoStore) SetDatabaseOrganization(ctx context.Context, id, orgID, workspaceID primitive.ObjectID) error {
+	if err := setResourceOrganization(ctx, s.databaseCollection(), id, orgID, workspaceID); err != nil {
+		return err
+	}
+	// Members of the previous organization no longer apply
+	return s.SetDatabaseMembers(ctx, id, nil)
+}
+
+// SetQueryOrganization shares a query with an organization and optionally one
+// of its workspaces, or makes it private again when orgID is zero
+func (s *mongoStore) SetQueryOrganization(ctx context.Context, id, orgID, workspaceID primitive.ObjectID) error {
+	return setResourceOrganization(ctx, s.queryCollection(), id, orgID, workspaceID)
+}
+
+// SetDashboardOrganization shares a dashboard with an organization and
+// optionally one of its workspaces, or makes it private again when orgID is zero
+func (s *mongoStore) SetDashboardOrganization(ctx context.Context, id, orgID, workspaceID primitive.ObjectID) error {
+	return setResourceOrganization(ctx, s.dashboardCollection(), id, orgID, workspaceID)
+}
+
+// setResourceOrganization sets or clears the organization and workspace of a document
+func setResourceOrganization(ctx context.Context, collection *mongo.Collection, id, orgID, workspaceID primitive.ObjectID) error {
+	set := bson.M{"updated_at": time.Now()}
+	unset := bson.M{}
+
+	if orgID.IsZero() {
+		unset["org_id"] = ""
+	} else {
+		set["org_id"] = orgID
+	}
+	if orgID.IsZero() || workspaceID.IsZero() {
+		unset["workspace_id"] = ""
+	} else {
+		set["workspace_id"] = workspaceID
+	}
+
+	update := bson.M{"$set": set}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+
+	_, err := collection.UpdateOne(ctx, bson.M{"_id": id}, update)
+	return err
+}
+
+// ownedOrSharedFilter matches documents a user owns or that are shared with one
+// of the user's organizations
+func (s *mongoStore) ownedOrSharedFilter(ctx context.Context, userID primitive.ObjectID) (bson.M, error) {
+	orgIDs, err := s.GetOrganizationIDsByUserID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if len(orgIDs) == 0 {
+		return bson.M{"user_id": userID}, nil
+	}
+
+	return bson.M{"$or": bson.A{
+		bson.M{"user_id": userID},
+		bson.M{"org_id": bson.M{"$in": orgIDs}},
+	}}, nil
+}
+
+// canAccessOwned reports whether a user owns a resource or belongs to the
+// organization it is shared with
+func (s *mongoStore) canAccessOwned(ctx context.Context, ownerID, orgID, userID primitive.ObjectID) (bool, error) {
+	if ownerID == userID {
+		return true, nil
+	}
+	if orgID.IsZero() {
+		return false, nil
+	}
+	return s.IsOrganizationMember(ctx, orgID, userID)
+}
+
+// CanAccessDatabase reports whether a user can query a database connection
+func (s *mongoStore) CanAccessDatabase(ctx context.Context, db *Database, userID primitive.ObjectID) (bool, error) {
+	access, err := s.ResolveDatabaseAccess(ctx, db, userID)
+	if err != nil {
+		return false, err
+	}
+	return access.CanQuery(), nil
+}
+
+// CanAccessQuery reports whether a user can read a query and its results
+func (s *mongoStore) CanAccessQuery(ctx context.Context, query *Query, userID primitive.ObjectID) (bool, error) {
+	return s.canAccessOwned(ctx, query.UserID, query.OrgID, userID)
+}
@@ -0,0 +1,241 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// SSOAttributeMapping names the ID token claims user attributes are read from
+type SSOAttributeMapping struct {
+	Email string `json:"email" bson:"email"`
+	Name  string `json:"name" bson:"name"`
+}
+
+// SSOConfig is an organization's OpenID Connect identity provider. Users whose
+// email domain is listed and verified sign in through the provider, and
+// accounts are created for them on first sign in.
+type SSOConfig struct {
+	Enabled           bool                `json:"enabled" bson:"enabled"`
+	Enforced          bool                `json:"enforced" bson:"enforced"` // Members on the SSO domains can't sign in with a password
+	Issuer            string              `json:"issuer" bson:"issuer"`
+	ClientID          string              `json:"client_id" bson:"client_id"`
+	ClientSecret      string              `json:"-" bson:"client_secret"`
+	Domains           []string            `json:"domains" bson:"domains"`
+	VerifiedDomains   []string            `json:"verified_domains" bson:"verified_domains"`     // Domains whose DNS carries the verification record
+	VerificationToken string              `json:"verification_token" bson:"verification_token"` // Proves the organization controls its domains
+	Attributes        SSOAttributeMapping `json:"attributes" bson:"attributes"`
+	DefaultRole       OrgRole             `json:"default_role" bson:"default_role"` // Role of users provisioned on first sign in
+}
+
+// EmailClaim returns the claim holding the user's email
+func (s *SSOConfig) EmailClaim() string {
+	if s.Attributes.Email == "" {
+		return "email"
+	}
+	return s.Attributes.Email
+}
+
+// NameClaim returns the claim holding the user's display name
+func (s *SSOConfig) NameClaim() string {
+	if s.Attributes.Name == "" {
+		return "name"
+	}
+	return s.Attributes.Name
+}
+
+// CoversEmail reports whether an email address is on one of the SSO domains
+// the organization verified it controls
+func (s *SSOConfig) CoversEmail(email string) bool {
+	return s.DomainVerified(EmailDomain(email))
+}
+
+// DomainVerified reports whether the organization verified it controls a domain
+func (s *SSOConfig) DomainVerified(domain string) bool {
+	for _, d := range s.VerifiedDomains {
+		if d == domain {
+			return true
+		}
+	}
+	return false
+}
+
+// UnverifiedDomains returns the SSO domains still waiting for verification
+func (s *SSOConfig) UnverifiedDomains() []string {
+	domains := []string{}
+	for _, domain := range s.Domains {
+		if !s.DomainVerified(domain) {
+			domains = append(domains, domain)
+		}
+	}
+	return domains
+}
+
+// VerificationRecord returns the DNS TXT record a domain must carry before its
+// users can sign in through the organization's provider
+func (s *SSOConfig) VerificationRecord() string {
+	return "goquery-verification=" + s.VerificationToken
+}
+
+// KeepVerification carries the verification of a previous configuration over,
+// for the domains still listed
+func (s *SSOConfig) KeepVerification(previous *SSOConfig) {
+	s.VerifiedDomains = []string{}
+	if previous == nil {
+		return
+	}
+
+	s.VerificationToken = previous.VerificationToken
+	for _, domain := range s.Domains {
+		if previous.DomainVerified(domain) {
+			s.VerifiedDomains = append(s.VerifiedDomains, domain)
+		}
+	}
+}
+
+// Validate checks the configuration and normalizes its domains
+func (s *SSOConfig) Validate() error {
+	issuer, err := url.Parse(s.Issuer)
+	if err != nil || issuer.Host == "" || issuer.Scheme != "https" {
+		return errors.New("issuer must be an https URL")
+	}
+	s.Issuer = strings.TrimRight(s.Issuer, "/")
+
+	if s.ClientID == "" || s.ClientSecret == "" {
+		return errors.New("client ID and client secret are required")
+	}
+
+	domains := []string{}
+	seen := make(map[string]bool)
+	for _, domain := range s.Domains {
+		domain = strings.ToLower(strings.TrimSpace(domain))
+		if domain == "" || seen[domain] {
+			continue
+		}
+		if strings.ContainsAny(domain, "@/ ") || !strings.Contains(domain, ".") {
+			return errors.New("invalid domain: " + domain)
+		}
+		seen[domain] = true
+		domains = append(domains, domain)
+	}
+	if len(domains) == 0 {
+		return errors.New("at least one email domain is required")
+	}
+	s.Domains = domains
+
+	switch s.DefaultRole {
+	case "":
+		s.DefaultRole = OrgRoleMember
+	case OrgRoleMember, OrgRoleAdmin:
+	default:
+		return errors.New("default role must be member or admin")
+	}
+
+	return nil
+}
+
+// EmailDomain returns the lowercased domain of an email address
+func EmailDomain(email string) string {
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return ""
+	}
+	return strings.ToLower(strings.TrimSpace(email[at+1:]))
+}
+
+// SetOrganizationSSO saves an organization's SSO configuration, or removes it when sso is nil
+func (s *mongoStore) SetOrganizationSSO(ctx context.Context, orgID primitive.ObjectID, sso *SSOConfig) error {
+	update := bson.M{"$set": bson.M{"sso": sso, "updated_at": time.Now()}}
+	if sso == nil {
+		update = bson.M{
+			"$unset": bson.M{"sso": ""},
+			"$set":   bson.M{"updated_at": time.Now()},
+		}
+	}
+
+	_, err := s.organizationCollection().UpdateOne(ctx, bson.M{"_id": orgID}, update)
+	return err
+}
+
+// GetOrganizationBySSODomain retrieves the organization with SSO enabled for an
+// email domain it verified
+func (s *mongoStore) GetOrganizationBySSODomain(ctx context.Context, domain string) (*Organization, error) {
+	var org Organization
+	err := s.organizationCollection().FindOne(ctx, bson.M{"sso.enabled": true, "sso.verified_domains": domain}).Decode(&org)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &org, nil
+}
+
+// SSODomainsClaimed returns the domains another organization already verified
+// for its SSO. Unverified claims don't count, so nobody can squat a domain.
+func (s *mongoStore) SSODomainsClaimed(ctx context.Context, orgID primitive.ObjectID, domains []string) ([]string, error) {
+	cursor, err := s.organizationCollection().Find(ctx, bson.M{
+		"_id":                  bson.M{"$ne": orgID},
+		"sso.verified_domains": bson.M{"$in": domains},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var orgs []*Organization
+	if err := cursor.All(ctx, &orgs); err != nil {
+		return nil, err
+	}
+
+	claimed := []string{}
+	for _, org := range orgs {
+		for _, domain := range domains {
+			if org.SSO.CoversEmail("@" + domain) {
+				claimed = append(claimed, domain)
+			}
+		}
+	}
+	return claimed, nil
+}
+
+// VerifySSODomains marks domains of an organization's SSO configuration as
+// verified, as long as they are still listed
+func (s *mongoStore) VerifySSODomains(ctx context.Context, orgID primitive.ObjectID, domains []string) error {
+	if len(domains) == 0 {
+		return nil
+	}
+
+	_, err := s.organizationCollection().UpdateOne(
+		ctx,
+		bson.M{"_id": orgID, "sso.domains": bson.M{"$all": domains}},
+		bson.M{
+			"$addToSet": bson.M{"sso.verified_domains": bson.M{"$each": domains}},
+			"$set":      bson.M{"updated_at": time.Now()},
+		},
+	)
+	return err
+}
+
+// RequiresSSO reports whether a user must sign in through an organization's
+// SSO instead of with a password
+func (s *mongoStore) RequiresSSO(ctx context.Context, user *User) (bool, error) {
+	cursor, err := s.organizationCollection().Find(ctx, bson.M{
+		"members.user_id":      user.ID,
+		"sso.enabled":          true,
+		"sso.enforced":         true,
+		"sso.verified_domains": EmailDomain(user.Email),
+	})
+	if err != nil {
+		return false, err
+	}
+	defer cursor.Close(ctx)
+
+	return cursor.Next(ctx), cursor.Err()
+}
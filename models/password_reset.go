@@ -0,0 +1,123 @@
+package models
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// PasswordResetToken is a one-time token that lets a user set a new password.
+// Only a hash of the token is stored.
+type PasswordResetToken struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	UserID    primitive.ObjectID `json:"user_id" bson:"user_id"`
+	TokenHash string             `json:"-" bson:"token_hash"`
+	ExpiresAt time.Time          `json:"expires_at" bson:"expires_at"`
+	UsedAt    *time.Time         `json:"used_at,omitempty" bson:"used_at,omitempty"`
+	CreatedAt time.Time          `json:"created_at" bson:"created_at"`
+}
+
+// passwordResetCollection returns the password reset tokens collection
+func (s *mongoStore) passwordResetCollection() *mongo.Collection {
+	return s.db.Collection("password_resets")
+}
+
+// ensurePasswordResetIndexes creates the indexes reset tokens are redeemed
+// and rate limited by
+func (s *mongoStore) ensurePasswordResetIndexes(ctx context.Context) error {
+	_, err := s.passwordResetCollection().Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "token_hash", Value: 1}},
+			Options: options.Index().SetName("password_reset_token"),
+		},
+		{
+			Keys:    bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}},
+			Options: options.Index().SetName("password_reset_user"),
+		},
+	})
+	return err
+}
+
+// hashResetToken returns the stored form of a reset token
+func hashResetToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreatePasswordResetToken issues a new reset token for a user and returns it.
+// Any earlier unused tokens for the user stop working.
+func (s *mongoStore) CreatePasswordResetToken(ctx context.Context, userID primitive.ObjectID, expiry time.Duration) (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(buf)
+
+	if err := s.InvalidatePasswordResetTokens(ctx, userID); err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	_, err := s.passwordResetCollection().InsertOne(ctx, &PasswordResetToken{
+		UserID:    userID,
+		TokenHash: hashResetToken(token),
+		ExpiresAt: now.Add(expiry),
+		CreatedAt: now,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// ConsumePasswordResetToken marks a valid token as used and returns it. It
+// returns nil when the token is unknown, expired or already used.
+func (s *mongoStore) ConsumePasswordResetToken(ctx context.Context, token string) (*PasswordResetToken, error) {
+	now := time.Now()
+
+	var reset PasswordResetToken
+	err := s.passwordResetCollection().FindOneAndUpdate(
+		ctx,
+		bson.M{
+			"token_hash": hashResetToken(token),
+			"used_at":    bson.M{"$exists": false},
+			"expires_at": bson.M{"$gt": now},
+		},
+		bson.M{"$set": bson.M{"used_at": now}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&reset)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &reset, nil
+}
+
+// InvalidatePasswordResetTokens marks all unused reset tokens of a user as used
+func (s *mongoStore) InvalidatePasswordResetTokens(ctx context.Context, userID primitive.ObjectID) error {
+	_, err := s.passwordResetCollection().UpdateMany(
+		ctx,
+		bson.M{"user_id": userID, "used_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"used_at": time.Now()}},
+	)
+	return err
+}
+
+// CountPasswordResetsSince counts the reset tokens issued to a user since the given time
+func (s *mongoStore) CountPasswordResetsSince(ctx context.Context, userID primitive.ObjectID, since time.Time) (int64, error) {
+	return s.passwordResetCollection().CountDocuments(ctx, bson.M{
+		"user_id":    userID,
+		"created_at": bson.M{"$gte": since},
+	})
+}
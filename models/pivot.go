@@ -0,0 +1,389 @@
+package models
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Limits of a pivot table, so a column with unique values can't blow it up
+const (
+	MaxPivotRowGroups    = 1000
+	MaxPivotColumnGroups = 100
+	MaxPivotCells        = 100000 // Row groups × column groups × values
+)
+
+// PivotDimension groups results by the values of a column. Dates can be
+// grouped by a bucket instead.
+type PivotDimension struct {
+	Column string     `json:"column"`
+	Bucket DateBucket `json:"bucket,omitempty"`
+}
+
+// PivotValue aggregates a column within each cell. Count without a column
+// counts rows, with one the rows where it isn't null.
+type PivotValue struct {
+	Column      string           `json:"column,omitempty"`
+	Aggregation ChartAggregation `json:"agg"`
+}
+
+// PivotDefinition describes a pivot table: the dimensions down its rows and
+// across its columns, and the values in each cell
+type PivotDefinition struct {
+	Rows    []PivotDimension `json:"rows"`
+	Columns []PivotDimension `json:"columns"`
+	Values  []PivotValue     `json:"values"`
+}
+
+// PivotRow is a row group of a pivot table. Cells are by column group, then
+// by value. A cell is nil when it has no values to aggregate, counts are 0.
+type PivotRow struct {
+	Keys   []interface{} `json:"keys"`
+	Cells  [][]*float64  `json:"cells"`
+	Totals []*float64    `json:"totals"` // By value, across all column groups
+}
+
+// PivotTable is a pivot computed over query results. Without column
+// dimensions there is a single column group, without row dimensions a single
+// row.
+type PivotTable struct {
+	Definition   PivotDefinition `json:"definition"`
+	ColumnKeys   [][]interface{} `json:"column_keys"`
+	Rows         []PivotRow      `json:"rows"`
+	ColumnTotals [][]*float64    `json:"column_totals"` // By column group, then by value
+	GrandTotals  []*float64      `json:"grand_totals"`
+	RowCount     int             `json:"row_count"`
+}
+
+// pivotAccumulator aggregates the values of a cell or total
+type pivotAccumulator struct {
+	sum   float64
+	count int
+	min   float64
+	max   float64
+}
+
+// add accumulates a value
+func (a *pivotAccumulator) add(value float64) {
+	if a.count == 0 || value < a.min {
+		a.min = value
+	}
+	if a.count == 0 || value > a.max {
+		a.max = value
+	}
+	a.sum += value
+	a.count++
+}
+
+// merge accumulates everything another accumulator has
+func (a *pivotAccumulator) merge(other *pivotAccumulator) {
+	if other.count == 0 {
+		return
+	}
+	if a.count == 0 || other.min < a.min {
+		a.min = other.min
+	}
+	if a.count == 0 || other.max > a.max {
+		a.max = other.max
+	}
+	a.sum += other.sum
+	a.count += other.count
+}
+
+// result returns the aggregate, nil when nothing was accumulated unless it
+// is a count
+func (a *pivotAccumulator) result(agg ChartAggregation) *float64 {
+	var value float64
+	switch agg {
+	case ChartAggregationCount:
+		value = float64(a.count)
+		return &value
+	case ChartAggregationSum:
+		value = a.sum
+	case ChartAggregationAvg:
+		value = a.sum / float64(a.count)
+	case ChartAggregationMin:
+		value = a.min
+	case ChartAggregationMax:
+		value = a.max
+	}
+	if a.count == 0 {
+		return nil
+	}
+	return &value
+}
+
+// pivotGroup is a distinct combination of dimension values
+type pivotGroup struct {
+	index int
+	keys  []interface{}
+}
+
+// pivotGroups collects the distinct groups of some dimensions in order of
+// appearance
+type pivotGroups struct {
+	byKey  map[string]*pivotGroup
+	groups []*pivotGroup
+}
+
+// get returns the group of a row's dimension values, adding it when new
+func (g *pivotGroups) get(keys []interface{}) *pivotGroup {
+	parts := make([]string, len(keys))
+	for i, key := range keys {
+		if key == nil {
+			parts[i] = "\x01"
+		} else {
+			parts[i] = fmt.Sprint(key)
+		}
+	}
+	id := strings.Join(parts, "\x00")
+
+	group, ok := g.byKey[id]
+	if !ok {
+		group = &pivotGroup{index: len(g.groups), keys: keys}
+		g.byKey[id] = group
+		g.groups = append(g.groups, group)
+	}
+	return group
+}
+
+// sorted returns the groups sorted by their dimension values
+func (g *pivotGroups) sorted() []*pivotGroup {
+	groups := append([]*pivotGroup(nil), g.groups...)
+	sort.SliceStable(groups, func(i, j int) bool {
+		return pivotKeysLess(groups[i].keys, groups[j].keys)
+	})
+	return groups
+}
+
+// Validate checks the definition names its columns and uses known
+// aggregations and buckets
+func (d *PivotDefinition) Validate() error {
+	if len(d.Values) == 0 {
+		return fmt.Errorf("at least one value is required")
+	}
+	for _, dimension := range append(append([]PivotDimension(nil), d.Rows...), d.Columns...) {
+		if dimension.Column == "" {
+			return fmt.Errorf("dimension column is required")
+		}
+		switch dimension.Bucket {
+		case DateBucketNone, DateBucketDay, DateBucketWeek, DateBucketMonth, DateBucketYear:
+		default:
+			return fmt.Errorf("unsupported date bucket: %s", dimension.Bucket)
+		}
+	}
+	for _, value := range d.Values {
+		switch value.Aggregation {
+		case ChartAggregationSum, ChartAggregationAvg, ChartAggregationMin, ChartAggregationMax:
+			if value.Column == "" {
+				return fmt.Errorf("value column is required for %s aggregation", value.Aggregation)
+			}
+		case ChartAggregationCount:
+		default:
+			return fmt.Errorf("unsupported aggregation: %s", value.Aggregation)
+		}
+	}
+	return nil
+}
+
+// BuildPivot computes a pivot table over query results. Groups are sorted by
+// their values, nulls last. Dates are bucketed in location, or as stored when
+// nil, and values that aren't dates fall in the null group.
+func BuildPivot(results []QueryResult, def PivotDefinition, location *time.Location) (*PivotTable, error) {
+	if err := def.Validate(); err != nil {
+		return nil, err
+	}
+
+	rowGroups := &pivotGroups{byKey: make(map[string]*pivotGroup)}
+	columnGroups := &pivotGroups{byKey: make(map[string]*pivotGroup)}
+
+	// Without dimensions on a side, all results fall in a single group
+	if len(def.Rows) == 0 {
+		rowGroups.get([]interface{}{})
+	}
+	if len(def.Columns) == 0 {
+		columnGroups.get([]interface{}{})
+	}
+
+	// Find the groups of each result first, so limits are checked before
+	// anything is aggregated
+	type placement struct{ row, column *pivotGroup }
+	placements := make([]placement, len(results))
+	for i, result := range results {
+		placements[i] = placement{
+			row:    rowGroups.get(pivotKeys(result, def.Rows, location)),
+			column: columnGroups.get(pivotKeys(result, def.Columns, location)),
+		}
+		if len(rowGroups.groups) > MaxPivotRowGroups {
+			return nil, fmt.Errorf("the pivot has more than %d row groups", MaxPivotRowGroups)
+		}
+		if len(columnGroups.groups) > MaxPivotColumnGroups {
+			return nil, fmt.Errorf("the pivot has more than %d column groups", MaxPivotColumnGroups)
+		}
+	}
+	rowCount, columnCount, valueCount := len(rowGroups.groups), len(columnGroups.groups), len(def.Values)
+	if rowCount*columnCount*valueCount > MaxPivotCells {
+		return nil, fmt.Errorf("the pivot has more than %d cells", MaxPivotCells)
+	}
+
+	// Accumulate each value into its cell and totals
+	newAccumulators := func(n int) [][]pivotAccumulator {
+		accumulators := make([][]pivotAccumulator, n)
+		for i := range accumulators {
+			accumulators[i] = make([]pivotAccumulator, valueCount)
+		}
+		return accumulators
+	}
+	cells := newAccumulators(rowCount * columnCount)
+	rowTotals := newAccumulators(rowCount)
+	columnTotals := newAccumulators(columnCount)
+	grandTotals := make([]pivotAccumulator, valueCount)
+
+	for i, result := range results {
+		row, column := placements[i].row.index, placements[i].column.index
+		for v, value := range def.Values {
+			number, ok := pivotNumber(result, value)
+			if !ok {
+				continue
+			}
+			cells[row*columnCount+column][v].add(number)
+			rowTotals[row][v].add(number)
+			columnTotals[column][v].add(number)
+			grandTotals[v].add(number)
+		}
+	}
+
+	aggregates := func(accumulators []pivotAccumulator) []*float64 {
+		values := make([]*float64, valueCount)
+		for v, value := range def.Values {
+			values[v] = accumulators[v].result(value.Aggregation)
+		}
+		return values
+	}
+
+	table := &PivotTable{
+		Definition:   def,
+		ColumnKeys:   make([][]interface{}, 0, columnCount),
+		Rows:         make([]PivotRow, 0, rowCount),
+		ColumnTotals: make([][]*float64, 0, columnCount),
+		GrandTotals:  aggregates(grandTotals),
+		RowCount:     len(results),
+	}
+
+	columns := columnGroups.sorted()
+	for _, column := range columns {
+		table.ColumnKeys = append(table.ColumnKeys, column.keys)
+		table.ColumnTotals = append(table.ColumnTotals, aggregates(columnTotals[column.index]))
+	}
+	for _, row := range rowGroups.sorted() {
+		pivotRow := PivotRow{
+			Keys:   row.keys,
+			Cells:  make([][]*float64, len(columns)),
+			Totals: aggregates(rowTotals[row.index]),
+		}
+		for c, column := range columns {
+			pivotRow.Cells[c] = aggregates(cells[row.index*columnCount+column.index])
+		}
+		table.Rows = append(table.Rows, pivotRow)
+	}
+
+	return table, nil
+}
+
+// pivotKeys returns the values of a result's dimensions, with dates
+// truncated to their bucket
+func pivotKeys(result QueryResult, dimensions []PivotDimension, location *time.Location) []interface{} {
+	keys := make([]interface{}, len(dimensions))
+	for i, dimension := range dimensions {
+		raw := result[dimension.Column]
+		if date, ok := raw.(primitive.DateTime); ok {
+			raw = date.Time()
+		}
+		if raw == nil || dimension.Bucket == DateBucketNone {
+			keys[i] = raw
+			continue
+		}
+
+		t, ok := toTime(raw)
+		if !ok {
+			continue
+		}
+		if location != nil {
+			t = t.In(location)
+		}
+		keys[i] = truncateDate(t, dimension.Bucket).Format(time.RFC3339)
+	}
+	return keys
+}
+
+// pivotNumber returns what a result adds to a value's aggregate, false when
+// it adds nothing
+func pivotNumber(result QueryResult, value PivotValue) (float64, bool) {
+	if value.Aggregation == ChartAggregationCount {
+		if value.Column == "" {
+			return 1, true
+		}
+		return 1, result[value.Column] != nil
+	}
+	number, ok := toFloat(result[value.Column])
+	if !ok || math.IsNaN(number) || math.IsInf(number, 0) {
+		return 0, false
+	}
+	return number, true
+}
+
+// pivotKeysLess orders groups by their dimension values in turn
+func pivotKeysLess(a, b []interface{}) bool {
+	for i := range a {
+		if c := comparePivotKeys(a[i], b[i]); c != 0 {
+			return c < 0
+		}
+	}
+	return false
+}
+
+// comparePivotKeys compares two dimension values: nulls last, numbers and
+// dates by value, anything else as text
+func comparePivotKeys(a, b interface{}) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return 1
+	case b == nil:
+		return -1
+	}
+
+	if x, ok := pivotKeyNumber(a); ok {
+		if y, ok := pivotKeyNumber(b); ok {
+			switch {
+			case x < y:
+				return -1
+			case x > y:
+				return 1
+			default:
+				return 0
+			}
+		}
+	}
+	if x, ok := a.(time.Time); ok {
+		if y, ok := b.(time.Time); ok {
+			return x.Compare(y)
+		}
+	}
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+// pivotKeyNumber reads a dimension value as a number, leaving out text and
+// booleans that toFloat would convert
+func pivotKeyNumber(value interface{}) (float64, bool) {
+	switch value.(type) {
+	case string, bool:
+		return 0, false
+	}
+	return toFloat(value)
+}
@@ -0,0 +1,159 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// float returns a pointer to a value, for expected aggregates
+func float(value float64) *float64 {
+	return &value
+}
+
+func TestBuildPivot(t *testing.T) {
+	results := []QueryResult{
+		{"region": "EU", "status": "paid", "total": 10.0},
+		{"region": "EU", "status": "open", "total": int64(5)},
+		{"region": "US", "status": "paid", "total": "7.5"},
+		{"region": "EU", "status": "paid", "total": 20.0},
+		{"region": nil, "status": "paid", "total": nil},
+	}
+
+	tests := []struct {
+		name       string
+		def        PivotDefinition
+		columnKeys [][]interface{}
+		rows       []PivotRow
+		columns    [][]*float64
+		grand      []*float64
+	}{
+		{
+			name:       "sum by row",
+			def:        PivotDefinition{Rows: []PivotDimension{{Column: "region"}}, Values: []PivotValue{{Column: "total", Aggregation: ChartAggregationSum}}},
+			columnKeys: [][]interface{}{{}},
+			rows: []PivotRow{
+				{Keys: []interface{}{"EU"}, Cells: [][]*float64{{float(35)}}, Totals: []*float64{float(35)}},
+				{Keys: []interface{}{"US"}, Cells: [][]*float64{{float(7.5)}}, Totals: []*float64{float(7.5)}},
+				{Keys: []interface{}{nil}, Cells: [][]*float64{{nil}}, Totals: []*float64{nil}},
+			},
+			columns: [][]*float64{{float(42.5)}},
+			grand:   []*float64{float(42.5)},
+		},
+		{
+			name: "count and avg across columns",
+			def: PivotDefinition{
+				Rows:    []PivotDimension{{Column: "region"}},
+				Columns: []PivotDimension{{Column: "status"}},
+				Values:  []PivotValue{{Aggregation: ChartAggregationCount}, {Column: "total", Aggregation: ChartAggregationAvg}},
+			},
+			columnKeys: [][]interface{}{{"open"}, {"paid"}},
+			rows: []PivotRow{
+				{Keys: []interface{}{"EU"}, Cells: [][]*float64{{float(1), float(5)}, {float(2), float(15)}}, Totals: []*float64{float(3), float(35.0 / 3)}},
+				{Keys: []interface{}{"US"}, Cells: [][]*float64{{float(0), nil}, {float(1), float(7.5)}}, Totals: []*float64{float(1), float(7.5)}},
+				{Keys: []interface{}{nil}, Cells: [][]*float64{{float(0), nil}, {float(1), nil}}, Totals: []*float64{float(1), nil}},
+			},
+			columns: [][]*float64{{float(1), float(5)}, {float(4), float(37.5 / 3)}},
+			grand:   []*float64{float(5), float(42.5 / 4)},
+		},
+		{
+			name:       "min and max without rows",
+			def:        PivotDefinition{Values: []PivotValue{{Column: "total", Aggregation: ChartAggregationMin}, {Column: "total", Aggregation: ChartAggregationMax}}},
+			columnKeys: [][]interface{}{{}},
+			rows: []PivotRow{
+				{Keys: []interface{}{}, Cells: [][]*float64{{float(5), float(20)}}, Totals: []*float64{float(5), float(20)}},
+			},
+			columns: [][]*float64{{float(5), float(20)}},
+			grand:   []*float64{float(5), float(20)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			table, err := BuildPivot(results, tt.def, nil)
+			if err != nil {
+				t.Fatalf("BuildPivot() error = %v", err)
+			}
+			if !reflect.DeepEqual(table.ColumnKeys, tt.columnKeys) {
+				t.Errorf("column keys = %v, want %v", table.ColumnKeys, tt.columnKeys)
+			}
+			if !reflect.DeepEqual(table.Rows, tt.rows) {
+				t.Errorf("rows = %v, want %v", describePivotRows(table.Rows), describePivotRows(tt.rows))
+			}
+			if !reflect.DeepEqual(table.ColumnTotals, tt.columns) {
+				t.Errorf("column totals = %v, want %v", describeValues(table.ColumnTotals...), describeValues(tt.columns...))
+			}
+			if !reflect.DeepEqual(table.GrandTotals, tt.grand) {
+				t.Errorf("grand totals = %v, want %v", describeValues(table.GrandTotals), describeValues(tt.grand))
+			}
+			if table.RowCount != len(results) {
+				t.Errorf("row count = %d, want %d", table.RowCount, len(results))
+			}
+		})
+	}
+}
+
+func TestBuildPivotDateBuckets(t *testing.T) {
+	results := []QueryResult{
+		{"day": time.Date(2024, 1, 31, 23, 0, 0, 0, time.UTC)},
+		{"day": time.Date(2024, 2, 1, 12, 0, 0, 0, time.UTC)},
+		{"day": "not a date"},
+	}
+	def := PivotDefinition{Rows: []PivotDimension{{Column: "day", Bucket: DateBucketMonth}}, Values: []PivotValue{{Aggregation: ChartAggregationCount}}}
+
+	table, err := BuildPivot(results, def, nil)
+	if err != nil {
+		t.Fatalf("BuildPivot() error = %v", err)
+	}
+	var keys []interface{}
+	for _, row := range table.Rows {
+		keys = append(keys, row.Keys[0])
+	}
+	want := []interface{}{"2024-01-01T00:00:00Z", "2024-02-01T00:00:00Z", nil}
+	if !reflect.DeepEqual(keys, want) {
+		t.Errorf("row keys = %v, want %v", keys, want)
+	}
+}
+
+func TestBuildPivotInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		def  PivotDefinition
+	}{
+		{"no values", PivotDefinition{Rows: []PivotDimension{{Column: "region"}}}},
+		{"sum without column", PivotDefinition{Values: []PivotValue{{Aggregation: ChartAggregationSum}}}},
+		{"unknown aggregation", PivotDefinition{Values: []PivotValue{{Column: "total", Aggregation: "median"}}}},
+		{"unknown bucket", PivotDefinition{Rows: []PivotDimension{{Column: "day", Bucket: "hour"}}, Values: []PivotValue{{Aggregation: ChartAggregationCount}}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := BuildPivot(nil, tt.def, nil); err == nil {
+				t.Error("BuildPivot() error = nil, want an error")
+			}
+		})
+	}
+}
+
+// describePivotRows formats rows with their values rather than pointers
+func describePivotRows(rows []PivotRow) []interface{} {
+	described := make([]interface{}, len(rows))
+	for i, row := range rows {
+		described[i] = []interface{}{row.Keys, describeValues(row.Cells...), describeValues(row.Totals)}
+	}
+	return described
+}
+
+// describeValues formats aggregates with their values rather than pointers
+func describeValues(groups ...[]*float64) [][]interface{} {
+	described := make([][]interface{}, len(groups))
+	for i, values := range groups {
+		described[i] = make([]interface{}, len(values))
+		for j, value := range values {
+			if value != nil {
+				described[i][j] = *value
+			}
+		}
+	}
+	return described
+}
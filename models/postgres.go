@@ -5,40 +5,70 @@ import (
 	"database/sql"
 	"fmt"
 	"log"
+	"regexp"
+	"strings"
 	"time"
 
-	"github.com/lib/pq" // PostgreSQL driver
+	"github.com/lib/pq"
 )
 
+// MaxResultRows caps the number of rows kept from a single query execution
+var MaxResultRows = 10000
+
+// PostgresFetchBatchSize is the number of rows fetched from a cursor at a time
+var PostgresFetchBatchSize = 1000
+
 // getPostgresConnectionString returns a connection string for PostgreSQL
 func getPostgresConnectionString(db *Database) string {
-	sslMode := "disable"
-	if db.SSL {
-		sslMode = "require"
+	sslParams := "sslmode=disable"
+	if db.TLS != nil {
+		sslParams = db.TLS.postgresParams()
+	} else if db.SSL {
+		sslParams = "sslmode=require"
 	}
 
 	return fmt.Sprintf(
-		"host=%s port=%s user=%s password=%s dbname=%s sslmode=%s",
+		"host=%s port=%s user=%s password=%s dbname=%s %s",
 		db.Host,
 		db.Port,
 		db.Username,
 		db.Password,
 		db.DatabaseName,
-		sslMode,
+		sslParams,
 	)
 }
 
+// newPostgresConnector creates a connector for a PostgreSQL database,
+// dialing through its ssh tunnel if it has one
+func newPostgresConnector(db *Database) (*pq.Connector, error) {
+	db, err := db.withSecrets()
+	if err != nil {
+		return nil, err
+	}
+
+	connector, err := pq.NewConnector(getPostgresConnectionString(db))
+	if err != nil {
+		return nil, err
+	}
+
+	if db.SSHTunnel != nil {
+		connector.Dialer(sshDialer{dial: SSHTunnels.Dialer(db.SSHTunnel.Config())})
+	}
+	return connector, nil
+}
+
 // testPostgresConnection tests the connection to a PostgreSQL database
-func testPostgresConnection(db *Database) error {
-	connStr := getPostgresConnectionString(db)
-	conn, err := sql.Open("postgres", connStr)
+func testPostgresConnection(ctx context.Context, db *Database) error {
+	connector, err := newPostgresConnector(db)
 	if err != nil {
 		return fmt.Errorf("failed to open connection: %v", err)
 	}
+
+	conn := sql.OpenDB(connector)
 	defer conn.Close()
 
 	// Test the connection
-	err = conn.Ping()
+	err = conn.PingContext(ctx)
 	if err != nil {
 		return fmt.Errorf("failed to connect to database: %v", err)
 	}
@@ -47,15 +77,13 @@ func testPostgresConnection(db *Database) error {
 }
 
 // fetchPostgresSchema fetches the schema of a PostgreSQL database
-func fetchPostgresSchema(db *Database) (*Schema, error) {
-	connStr := getPostgresConnectionString(db)
-
+func fetchPostgresSchema(ctx context.Context, db *Database) (*Schema, error) {
 	// Set a connection timeout
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
 	defer cancel()
 
 	// Open connection with context
-	connector, err := pq.NewConnector(connStr)
+	connector, err := newPostgresConnector(db)
 	if err != nil {
 		return &Schema{Tables: []Table{}}, fmt.Errorf("failed to create connector: %v", err)
 	}
@@ -68,13 +96,18 @@ func fetchPostgresSchema(db *Database) (*Schema, error) {
 		return &Schema{Tables: []Table{}}, fmt.Errorf("failed to ping database: %v", err)
 	}
 
-	// Query to get all tables in the public schema
+	// Query to get all tables, views and materialized views in the public
+	// schema. Materialized views aren't part of information_schema.
 	query := `
-		SELECT table_name
+		SELECT table_name, CASE table_type WHEN 'VIEW' THEN 'view' ELSE 'table' END
 		FROM information_schema.tables
 		WHERE table_schema = 'public'
-		AND table_type = 'BASE TABLE'
-		ORDER BY table_name
+		AND table_type IN ('BASE TABLE', 'VIEW')
+		UNION ALL
+		SELECT matviewname, 'materialized_view'
+		FROM pg_matviews
+		WHERE schemaname = 'public'
+		ORDER BY 1
 	`
 
 	rows, err := conn.QueryContext(ctx, query)
@@ -83,15 +116,29 @@ func fetchPostgresSchema(db *Database) (*Schema, error) {
 	}
 	defer rows.Close()
 
+	// Indexes are informational, a failure doesn't fail schema discovery
+	indexes, err := fetchPostgresIndexes(conn, ctx)
+	if err != nil {
+		log.Printf("Error fetching indexes: %v", err)
+	}
+
 	var tables []Table
 	for rows.Next() {
-		var tableName string
-		if err := rows.Scan(&tableName); err != nil {
+		var tableName, kind string
+		if err := rows.Scan(&tableName, &kind); err != nil {
 			return &Schema{Tables: []Table{}}, fmt.Errorf("failed to scan table name: %v", err)
 		}
+		if !db.TableVisible(tableName) {
+			continue
+		}
 
 		// Get columns for this table
-		columns, err := fetchPostgresColumns(conn, tableName, ctx)
+		var columns []Column
+		if kind == TableKindMaterializedView {
+			columns, err = fetchPostgresMaterializedViewColumns(conn, tableName, ctx)
+		} else {
+			columns, err = fetchPostgresColumns(conn, tableName, ctx)
+		}
 		if err != nil {
 			// Log the error but continue with other tables
 			log.Printf("Error fetching columns for table %s: %v", tableName, err)
@@ -100,12 +147,30 @@ func fetchPostgresSchema(db *Database) (*Schema, error) {
 
 		tables = append(tables, Table{
 			Name:    tableName,
+			Kind:    kind,
 			Columns: columns,
+			Indexes: indexes[tableName],
 		})
 	}
 
+	// Relationships are informational, a failure doesn't fail schema discovery
+	relationships, err := fetchPostgresForeignKeys(conn, ctx)
+	if err != nil {
+		log.Printf("Error fetching foreign keys: %v", err)
+	}
+
+	// Functions are only discovered on request, and a failure doesn't fail
+	// schema discovery either
+	var functions []Function
+	if db.DiscoverFunctions {
+		functions, err = fetchPostgresFunctions(conn, ctx)
+		if err != nil {
+			log.Printf("Error fetching functions: %v", err)
+		}
+	}
+
 	// Always return a valid schema with at least an empty tables array
-	return &Schema{Tables: tables}, nil
+	return &Schema{Tables: tables, Relationships: relationships, Functions: functions}, nil
 }
 
 // fetchPostgresColumns fetches the columns of a PostgreSQL table
@@ -116,7 +181,8 @@ func fetchPostgresColumns(db *sql.DB, tableName string, ctx context.Context) ([]
 			c.column_name,
 			c.data_type,
 			c.is_nullable = 'YES' as is_nullable,
-			pg_constraint.contype = 'p' as is_primary_key
+			pg_constraint.contype = 'p' as is_primary_key,
+			col_description(format('%I.%I', c.table_schema, c.table_name)::regclass, c.ordinal_position) as comment
 		FROM
 			information_schema.columns c
 		LEFT JOIN
@@ -141,13 +207,15 @@ func fetchPostgresColumns(db *sql.DB, tableName string, ctx context.Context) ([]
 	for rows.Next() {
 		var column Column
 		var isNullable, isPrimaryKey bool
+		var comment sql.NullString
 
-		if err := rows.Scan(&column.Name, &column.Type, &isNullable, &isPrimaryKey); err != nil {
+		if err := rows.Scan(&column.Name, &column.Type, &isNullable, &isPrimaryKey, &comment); err != nil {
 			return nil, fmt.Errorf("failed to scan column: %v", err)
 		}
 
 		column.Nullable = isNullable
 		column.PrimaryKey = isPrimaryKey
+		column.Comment = comment.String
 
 		columns = append(columns, column)
 	}
@@ -155,16 +223,130 @@ func fetchPostgresColumns(db *sql.DB, tableName string, ctx context.Context) ([]
 	return columns, nil
 }
 
-// fetchPostgresStats fetches statistics about a PostgreSQL database
-func fetchPostgresStats(db *Database) (*DatabaseStats, error) {
-	connStr := getPostgresConnectionString(db)
+// fetchPostgresMaterializedViewColumns fetches the columns of a materialized
+// view in the public schema from the system catalog
+func fetchPostgresMaterializedViewColumns(db *sql.DB, viewName string, ctx context.Context) ([]Column, error) {
+	query := `
+		SELECT
+			a.attname,
+			format_type(a.atttypid, a.atttypmod),
+			NOT a.attnotnull,
+			col_description(a.attrelid, a.attnum)
+		FROM
+			pg_attribute a
+		WHERE
+			a.attrelid = format('public.%I', $1::text)::regclass
+			AND a.attnum > 0
+			AND NOT a.attisdropped
+		ORDER BY
+			a.attnum
+	`
+
+	rows, err := db.QueryContext(ctx, query, viewName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query columns: %v", err)
+	}
+	defer rows.Close()
+
+	var columns []Column
+	for rows.Next() {
+		var column Column
+		var comment sql.NullString
+		if err := rows.Scan(&column.Name, &column.Type, &column.Nullable, &comment); err != nil {
+			return nil, fmt.Errorf("failed to scan column: %v", err)
+		}
+		column.Comment = comment.String
+		columns = append(columns, column)
+	}
+
+	return columns, rows.Err()
+}
 
+// fetchPostgresIndexes fetches the indexes of every table in the public
+// schema, keyed by table name. Expression columns are left out.
+func fetchPostgresIndexes(db *sql.DB, ctx context.Context) (map[string][]Index, error) {
+	query := `
+		SELECT t.relname, i.relname, ix.indisunique, ix.indisprimary,
+			array_agg(a.attname ORDER BY k.ord)
+		FROM pg_index ix
+		JOIN pg_class t ON t.oid = ix.indrelid
+		JOIN pg_class i ON i.oid = ix.indexrelid
+		JOIN pg_namespace n ON n.oid = t.relnamespace
+		CROSS JOIN LATERAL unnest(ix.indkey) WITH ORDINALITY AS k(attnum, ord)
+		JOIN pg_attribute a ON a.attrelid = t.oid AND a.attnum = k.attnum
+		WHERE n.nspname = 'public'
+		GROUP BY t.relname, i.relname, ix.indisunique, ix.indisprimary
+		ORDER BY t.relname, ix.indisprimary DESC, i.relname
+	`
+
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	indexes := make(map[string][]Index)
+	for rows.Next() {
+		var tableName string
+		var index Index
+		if err := rows.Scan(&tableName, &index.Name, &index.Unique, &index.Primary, pq.Array(&index.Columns)); err != nil {
+			return nil, err
+		}
+		indexes[tableName] = append(indexes[tableName], index)
+	}
+
+	return indexes, rows.Err()
+}
+
+// fetchPostgresForeignKeys fetches the foreign keys between tables in the public schema
+func fetchPostgresForeignKeys(db *sql.DB, ctx context.Context) ([]Relationship, error) {
+	query := `
+		SELECT c.conname, src.relname, array_agg(sa.attname ORDER BY k.ord),
+			tgt.relname, array_agg(ta.attname ORDER BY k.ord)
+		FROM pg_constraint c
+		JOIN pg_class src ON src.oid = c.conrelid
+		JOIN pg_class tgt ON tgt.oid = c.confrelid
+		JOIN pg_namespace n ON n.oid = src.relnamespace
+		CROSS JOIN LATERAL unnest(c.conkey, c.confkey) WITH ORDINALITY AS k(src_attnum, tgt_attnum, ord)
+		JOIN pg_attribute sa ON sa.attrelid = src.oid AND sa.attnum = k.src_attnum
+		JOIN pg_attribute ta ON ta.attrelid = tgt.oid AND ta.attnum = k.tgt_attnum
+		WHERE c.contype = 'f' AND n.nspname = 'public'
+		GROUP BY c.conname, src.relname, tgt.relname
+		ORDER BY src.relname, c.conname
+	`
+
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var relationships []Relationship
+	for rows.Next() {
+		var relationship Relationship
+		if err := rows.Scan(
+			&relationship.Name,
+			&relationship.FromTable,
+			pq.Array(&relationship.FromColumns),
+			&relationship.ToTable,
+			pq.Array(&relationship.ToColumns),
+		); err != nil {
+			return nil, err
+		}
+		relationships = append(relationships, relationship)
+	}
+
+	return relationships, rows.Err()
+}
+
+// fetchPostgresStats fetches statistics about a PostgreSQL database
+func fetchPostgresStats(ctx context.Context, db *Database) (*DatabaseStats, error) {
 	// Set a connection timeout
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
 	defer cancel()
 
 	// Open connection with context
-	connector, err := pq.NewConnector(connStr)
+	connector, err := newPostgresConnector(db)
 	if err != nil {
 		return &DatabaseStats{TableCount: 0, Size: "Unknown"}, fmt.Errorf("failed to create connector: %v", err)
 	}
@@ -205,52 +387,336 @@ func fetchPostgresStats(db *Database) (*DatabaseStats, error) {
 	// Format size to human-readable format
 	size := formatSize(sizeBytes)
 
+	// Per-table breakdown, informational only
+	tables, err := fetchPostgresTableStats(ctx, conn)
+	if err != nil {
+		log.Printf("Failed to fetch table stats: %v", err)
+	}
+
 	return &DatabaseStats{
 		TableCount: tableCount,
 		Size:       size,
+		Tables:     tables,
 	}, nil
 }
 
-// executePostgresQuery executes a SQL query against a PostgreSQL database
-func executePostgresQuery(db *Database, sqlQuery string, startTime time.Time) ([]QueryResult, string, error) {
-	connStr := getPostgresConnectionString(db)
+// fetchPostgresTableStats fetches the estimated row count and total size,
+// including indexes and TOAST data, of each table in the public schema
+func fetchPostgresTableStats(ctx context.Context, conn *sql.DB) ([]TableStats, error) {
+	query := `
+		SELECT relname, GREATEST(n_live_tup, 0), pg_total_relation_size(relid)
+		FROM pg_stat_user_tables
+		WHERE schemaname = 'public'
+		ORDER BY pg_total_relation_size(relid) DESC
+		LIMIT $1
+	`
 
-	// Set a connection timeout
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
+	rows, err := conn.QueryContext(ctx, query, MaxTableStats)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
 
-	// Open connection with context
-	connector, err := pq.NewConnector(connStr)
+	var tables []TableStats
+	for rows.Next() {
+		var table TableStats
+		if err := rows.Scan(&table.Name, &table.RowCount, &table.SizeBytes); err != nil {
+			return nil, err
+		}
+		table.Size = formatSize(table.SizeBytes)
+		tables = append(tables, table)
+	}
+
+	return tables, rows.Err()
+}
+
+// postgresWriteKeywords are statement keywords that modify data or schema
+var postgresWriteKeywords = map[string]bool{
+	"INSERT": true, "UPDATE": true, "DELETE": true, "MERGE": true, "UPSERT": true,
+	"CREATE": true, "ALTER": true, "DROP": true, "TRUNCATE": true, "GRANT": true,
+	"REVOKE": true, "COPY": true, "VACUUM": true, "REINDEX": true, "CLUSTER": true,
+	"COMMENT": true, "CALL": true, "DO": true, "REFRESH": true,
+}
+
+// postgresCommentRegex matches SQL line and block comments
+var postgresCommentRegex = regexp.MustCompile(`(?s)--[^\n]*|/\*.*?\*/`)
+
+// postgresModifyingCTERegex matches data-modifying statements inside a WITH clause
+var postgresModifyingCTERegex = regexp.MustCompile(`(?i)\b(INSERT\s+INTO|UPDATE\s+\S+\s+SET|DELETE\s+FROM)\b`)
+
+// postgresReturningRegex matches a RETURNING clause
+var postgresReturningRegex = regexp.MustCompile(`(?i)\bRETURNING\b`)
+
+// fetchPostgresLatest returns the newest value of a timestamp column in a
+// PostgreSQL table, read from a replica when there is one
+func fetchPostgresLatest(ctx context.Context, db *Database, table, column string) (*time.Time, error) {
+	conn, err := openPostgresReadConnection(ctx, db)
 	if err != nil {
-		return nil, "", fmt.Errorf("failed to create connector: %v", err)
+		return nil, err
 	}
+	defer conn.Close()
 
-	conn := sql.OpenDB(connector)
+	var latest sql.NullTime
+	query := fmt.Sprintf("SELECT max(%s) FROM %s", pq.QuoteIdentifier(column), pq.QuoteIdentifier(table))
+	if err := conn.QueryRowContext(ctx, query).Scan(&latest); err != nil {
+		return nil, fmt.Errorf("failed to read the newest %s: %v", column, err)
+	}
+
+	if !latest.Valid {
+		return nil, nil
+	}
+	return &latest.Time, nil
+}
+
+// samplePostgresRows reads up to limit rows of a table's columns, read from a
+// replica when there is one. Large tables are sampled across their pages
+// rather than read from the start. It also returns the planner's estimate of
+// the table's rows, 0 when there is none.
+func samplePostgresRows(ctx context.Context, db *Database, table string, columns []string, limit int) ([]QueryResult, int64, error) {
+	conn, err := openPostgresReadConnection(ctx, db)
+	if err != nil {
+		return nil, 0, err
+	}
 	defer conn.Close()
 
-	// Test the connection
-	if err := conn.PingContext(ctx); err != nil {
-		return nil, "", fmt.Errorf("failed to ping database: %v", err)
+	var estimate sql.NullFloat64
+	if err := conn.QueryRowContext(ctx, "SELECT reltuples FROM pg_class WHERE oid = to_regclass($1)", pq.QuoteIdentifier(table)).Scan(&estimate); err != nil && err != sql.ErrNoRows {
+		return nil, 0, fmt.Errorf("failed to estimate the size of %s: %v", table, err)
+	}
+	rowEstimate := int64(0)
+	if estimate.Valid && estimate.Float64 > 0 {
+		rowEstimate = int64(estimate.Float64)
+	}
+
+	quoted := make([]string, len(columns))
+	for i, column := range columns {
+		quoted[i] = pq.QuoteIdentifier(column)
+	}
+	query := fmt.Sprintf("SELECT %s FROM %s", strings.Join(quoted, ", "), pq.QuoteIdentifier(table))
+
+	// Sample a few times more pages than needed, pages hold uneven numbers of rows
+	if rowEstimate > int64(limit)*3 {
+		percent := float64(limit) * 3 * 100 / float64(rowEstimate)
+		query += fmt.Sprintf(" TABLESAMPLE SYSTEM (%g)", percent)
 	}
+	query += fmt.Sprintf(" LIMIT %d", limit)
 
-	// Execute the query
-	rows, err := conn.QueryContext(ctx, sqlQuery)
+	rows, err := conn.QueryContext(ctx, query)
 	if err != nil {
-		return nil, "", fmt.Errorf("failed to execute query: %v", err)
+		return nil, 0, fmt.Errorf("failed to sample %s: %v", table, err)
 	}
 	defer rows.Close()
 
+	_, results, err := readPostgresRows(rows, limit)
+	if err != nil {
+		return nil, 0, err
+	}
+	return results, rowEstimate, nil
+}
+
+// isPostgresWriteQuery reports whether a SQL statement modifies data or schema
+func isPostgresWriteQuery(sqlQuery string) bool {
+	stripped := strings.TrimSpace(postgresCommentRegex.ReplaceAllString(sqlQuery, " "))
+	fields := strings.Fields(stripped)
+	if len(fields) == 0 {
+		return false
+	}
+
+	keyword := strings.ToUpper(strings.TrimLeft(fields[0], "("))
+	if postgresWriteKeywords[keyword] {
+		return true
+	}
+
+	return keyword == "WITH" && postgresModifyingCTERegex.MatchString(stripped)
+}
+
+// postgresMainKeywords start the statement a WITH clause is attached to
+var postgresMainKeywords = map[string]bool{
+	"select": true, "values": true, "table": true, "insert": true, "update": true, "delete": true, "merge": true,
+}
+
+// isPostgresCursorQuery reports whether a read can be streamed through a
+// cursor, which only takes a SELECT or VALUES. Other reads, such as SHOW or
+// EXPLAIN, run as they are.
+func isPostgresCursorQuery(sqlQuery string) bool {
+	if isPostgresMultiStatement(sqlQuery) {
+		return false
+	}
+
+	fields := strings.Fields(strings.ToLower(scanPostgres(sqlQuery, true)))
+	if len(fields) == 0 {
+		return false
+	}
+
+	switch fields[0] {
+	case "select", "values":
+		return true
+	case "with":
+		// Only a SELECT after the common table expressions is known to work
+		for _, field := range fields[1:] {
+			if keyword := strings.Trim(field, "()"); postgresMainKeywords[keyword] {
+				return keyword == "select"
+			}
+		}
+	}
+	return false
+}
+
+// executePostgresQuery executes a SQL query with optional positional arguments
+// against a PostgreSQL database. Unless allowWrites is set the query runs inside
+// a read-only transaction.
+func executePostgresQuery(ctx context.Context, db *Database, sqlQuery string, args []interface{}, startTime time.Time, allowWrites bool) ([]QueryResult, []ResultColumn, string, error) {
+	// Set a connection timeout
+	ctx, cancel := context.WithTimeout(ctx, db.queryTimeout(30*time.Second))
+	defer cancel()
+
+	// Reads without a limit get the connection's default limit
+	sqlQuery = db.applyPostgresDefaultLimit(sqlQuery)
+
+	// Writes go to the primary, everything else to a read replica if there is one
+	var conn *sql.DB
+	var err error
+	if allowWrites && isPostgresWriteQuery(sqlQuery) {
+		conn, err = openPostgresConnection(ctx, db)
+	} else {
+		conn, err = openPostgresReadConnection(ctx, db)
+	}
+	if err != nil {
+		return nil, nil, "", err
+	}
+	defer conn.Close()
+
+	// Begin a transaction, read-only unless writes were confirmed
+	tx, err := conn.BeginTx(ctx, &sql.TxOptions{ReadOnly: !allowWrites})
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	if err := db.setPostgresStatementTimeout(ctx, tx); err != nil {
+		return nil, nil, "", fmt.Errorf("failed to set statement timeout: %v", err)
+	}
+
+	// Confirmed writes without a RETURNING clause report the affected row count
+	if allowWrites && isPostgresWriteQuery(sqlQuery) && !postgresReturningRegex.MatchString(sqlQuery) {
+		result, err := tx.ExecContext(ctx, sqlQuery, args...)
+		if err != nil {
+			return nil, nil, "", fmt.Errorf("failed to execute statement: %v", err)
+		}
+		if err := tx.Commit(); err != nil {
+			return nil, nil, "", fmt.Errorf("failed to commit transaction: %v", err)
+		}
+
+		rowsAffected, _ := result.RowsAffected()
+		results := []QueryResult{{"rows_affected": rowsAffected}}
+		columns := []ResultColumn{{Name: "rows_affected", DBType: "INT8"}}
+		return results, columns, time.Since(startTime).String(), nil
+	}
+
+	maxRows := db.rowLimit()
+	var results []QueryResult
+	var resultColumns []ResultColumn
+
+	if isPostgresWriteQuery(sqlQuery) || !isPostgresCursorQuery(sqlQuery) {
+		// Data-modifying statements and reads other than SELECT and VALUES
+		// can't be wrapped in a cursor
+		rows, err := tx.QueryContext(ctx, sqlQuery, args...)
+		if err != nil {
+			return nil, nil, "", fmt.Errorf("failed to execute query: %v", err)
+		}
+
+		resultColumns, results, err = readPostgresRows(rows, maxRows)
+		rows.Close()
+		if err != nil {
+			return nil, nil, "", err
+		}
+	} else {
+		// Stream the results through a server-side cursor so memory stays bounded
+		cursorQuery := strings.TrimRight(strings.TrimSpace(sqlQuery), ";")
+		if _, err := tx.ExecContext(ctx, "DECLARE goquery_cursor NO SCROLL CURSOR FOR "+cursorQuery, args...); err != nil {
+			return nil, nil, "", fmt.Errorf("failed to execute query: %v", err)
+		}
+
+		for len(results) < maxRows {
+			batchSize := PostgresFetchBatchSize
+			if remaining := maxRows - len(results); remaining < batchSize {
+				batchSize = remaining
+			}
+
+			rows, err := tx.QueryContext(ctx, fmt.Sprintf("FETCH FORWARD %d FROM goquery_cursor", batchSize))
+			if err != nil {
+				return nil, nil, "", fmt.Errorf("failed to fetch rows: %v", err)
+			}
+
+			columns, batch, err := readPostgresRows(rows, batchSize)
+			rows.Close()
+			if err != nil {
+				return nil, nil, "", err
+			}
+
+			if resultColumns == nil {
+				resultColumns = columns
+			}
+			results = append(results, batch...)
+
+			// A short batch means the cursor is exhausted
+			if len(batch) < batchSize {
+				break
+			}
+		}
+
+		if len(results) >= maxRows {
+			log.Printf("Query results truncated to %d rows", maxRows)
+		}
+
+		if _, err := tx.ExecContext(ctx, "CLOSE goquery_cursor"); err != nil {
+			return nil, nil, "", fmt.Errorf("failed to close cursor: %v", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, nil, "", fmt.Errorf("failed to commit transaction: %v", err)
+	}
+
+	// Calculate execution time
+	executionTime := time.Since(startTime).String()
+
+	return results, resultColumns, executionTime, nil
+}
+
+// readPostgresRows reads up to limit rows along with their column metadata
+func readPostgresRows(rows *sql.Rows, limit int) ([]ResultColumn, []QueryResult, error) {
 	// Get column names
 	columns, err := rows.Columns()
 	if err != nil {
-		return nil, "", fmt.Errorf("failed to get column names: %v", err)
+		return nil, nil, fmt.Errorf("failed to get column names: %v", err)
+	}
+
+	// Get column type information
+	columnTypes, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get column types: %v", err)
+	}
+
+	resultColumns := make([]ResultColumn, len(columnTypes))
+	for i, columnType := range columnTypes {
+		nullable, ok := columnType.Nullable()
+		if !ok {
+			// The driver doesn't report nullability, so assume the worst
+			nullable = true
+		}
+		resultColumns[i] = ResultColumn{
+			Name:     columnType.Name(),
+			DBType:   columnType.DatabaseTypeName(),
+			Nullable: nullable,
+		}
 	}
 
 	// Prepare result slice
 	var results []QueryResult
 
 	// Iterate through rows
-	for rows.Next() {
+	for len(results) < limit && rows.Next() {
 		// Create a slice of interface{} to hold the values
 		values := make([]interface{}, len(columns))
 		valuePtrs := make([]interface{}, len(columns))
@@ -262,7 +728,7 @@ func executePostgresQuery(db *Database, sqlQuery string, startTime time.Time) ([
 
 		// Scan the row into the slice of pointers
 		if err := rows.Scan(valuePtrs...); err != nil {
-			return nil, "", fmt.Errorf("failed to scan row: %v", err)
+			return nil, nil, fmt.Errorf("failed to scan row: %v", err)
 		}
 
 		// Create a map for this row
@@ -294,11 +760,8 @@ func executePostgresQuery(db *Database, sqlQuery string, startTime time.Time) ([
 
 	// Check for errors from iterating over rows
 	if err := rows.Err(); err != nil {
-		return nil, "", fmt.Errorf("error iterating over rows: %v", err)
+		return nil, nil, fmt.Errorf("error iterating over rows: %v", err)
 	}
 
-	// Calculate execution time
-	executionTime := time.Since(startTime).String()
-
-	return results, executionTime, nil
+	return resultColumns, results, nil
 }
@@ -0,0 +1,149 @@
+package models
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+)
+
+// ErrMultipleStatements is returned for PostgreSQL queries with more than one
+// statement
+var ErrMultipleStatements = errors.New("only a single SQL statement can be run at a time")
+
+// postgresDollarTagRegex matches the opening tag of a dollar-quoted string
+var postgresDollarTagRegex = regexp.MustCompile(`^\$([A-Za-z_][A-Za-z0-9_]*)?\$`)
+
+// scanPostgres walks a statement the way the server tokenizes it, so keywords
+// inside comments, string literals and quoted identifiers aren't mistaken for
+// clauses. Comments always become a space. With topLevel set, literals become
+// placeholders and everything between parentheses is dropped, leaving only the
+// clauses of the outermost query: "SELECT count(*) FROM (SELECT ...) s"
+// becomes "SELECT count() FROM () s".
+func scanPostgres(query string, topLevel bool) string {
+	var out strings.Builder
+	depth := 0
+	write := func(s string) {
+		if !topLevel || depth == 0 {
+			out.WriteString(s)
+		}
+	}
+
+	for i := 0; i < len(query); {
+		rest := query[i:]
+		switch {
+		case strings.HasPrefix(rest, "--"):
+			end := strings.IndexByte(rest, '\n')
+			if end < 0 {
+				end = len(rest)
+			}
+			write(" ")
+			i += end
+
+		case strings.HasPrefix(rest, "/*"):
+			// Block comments nest
+			nested, j := 0, 0
+			for j < len(rest) {
+				if strings.HasPrefix(rest[j:], "/*") {
+					nested++
+					j += 2
+				} else if strings.HasPrefix(rest[j:], "*/") {
+					nested--
+					j += 2
+					if nested == 0 {
+						break
+					}
+				} else {
+					j++
+				}
+			}
+			write(" ")
+			i += j
+
+		case rest[0] == '\'' || rest[0] == '"':
+			// E'' strings escape with backslashes, others by doubling the quote
+			quote := rest[0]
+			escapes := quote == '\'' && i > 0 && (query[i-1] == 'e' || query[i-1] == 'E') &&
+				(i == 1 || !isPostgresIdentChar(query[i-2]))
+			j := 1
+			for j < len(rest) {
+				if escapes && rest[j] == '\\' {
+					j += 2
+					continue
+				}
+				if rest[j] == quote {
+					if j+1 < len(rest) && rest[j+1] == quote {
+						j += 2
+						continue
+					}
+					j++
+					break
+				}
+				j++
+			}
+			if j > len(rest) {
+				j = len(rest)
+			}
+			if topLevel {
+				write(string(quote) + string(quote))
+			} else {
+				write(rest[:j])
+			}
+			i += j
+
+		case rest[0] == '$' && (i == 0 || !isPostgresIdentChar(query[i-1])) && postgresDollarTagRegex.MatchString(rest):
+			tag := postgresDollarTagRegex.FindString(rest)
+			end := strings.Index(rest[len(tag):], tag)
+			j := len(rest)
+			if end >= 0 {
+				j = len(tag) + end + len(tag)
+			}
+			if topLevel {
+				write("''")
+			} else {
+				write(rest[:j])
+			}
+			i += j
+
+		case rest[0] == '(':
+			write("(")
+			depth++
+			i++
+
+		case rest[0] == ')':
+			if depth > 0 {
+				depth--
+			}
+			write(")")
+			i++
+
+		default:
+			write(rest[:1])
+			i++
+		}
+	}
+	return out.String()
+}
+
+// isPostgresMultiStatement reports whether a query has anything but comments
+// after a top-level semicolon. The simple query protocol runs every statement,
+// so checks that only look at one of them could be bypassed.
+func isPostgresMultiStatement(query string) bool {
+	scanned := scanPostgres(query, true)
+	end := strings.IndexByte(scanned, ';')
+	return end >= 0 && strings.TrimLeft(scanned[end+1:], "; \t\r\n") != ""
+}
+
+// isPostgresIdentChar reports whether a byte can be part of an identifier
+func isPostgresIdentChar(b byte) bool {
+	return b == '_' || b == '$' || b >= '0' && b <= '9' || b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z' || b >= 0x80
+}
+
+// postgresFirstKeyword returns the first keyword of a statement scanned with
+// topLevel set, in lower case
+func postgresFirstKeyword(scanned string) string {
+	fields := strings.Fields(strings.ToLower(scanned))
+	if len(fields) == 0 {
+		return ""
+	}
+	return fields[0]
+}
@@ -0,0 +1,30 @@
+package models
+
+import "testing"
+
+func TestIsPostgresCursorQuery(t *testing.T) {
+	tests := []struct {
+		query string
+		want  bool
+	}{
+		{"SELECT * FROM users", true},
+		{"  select 1;", true},
+		{"VALUES (1), (2)", true},
+		{"WITH u AS (SELECT * FROM users) SELECT * FROM u", true},
+		{"WITH RECURSIVE t(n) AS (VALUES (1) UNION ALL SELECT n + 1 FROM t) SELECT n FROM t", true},
+		{"WITH u AS (SELECT 1)SELECT * FROM u", true},
+		{"WITH v AS (SELECT 1) VALUES (1)", false},
+		{"SHOW search_path", false},
+		{"EXPLAIN SELECT * FROM users", false},
+		{"EXPLAIN ANALYZE SELECT 1", false},
+		{"TABLE users", false},
+		{"-- SELECT\nSHOW timezone", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		if got := isPostgresCursorQuery(tt.query); got != tt.want {
+			t.Errorf("isPostgresCursorQuery(%q) = %v, want %v", tt.query, got, tt.want)
+		}
+	}
+}
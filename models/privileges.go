@@ -0,0 +1,376 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// PrivilegeLevel is the most a connection's credentials can do
+type PrivilegeLevel string
+
+// Privilege levels, from least to most
+const (
+	PrivilegeReadOnly PrivilegeLevel = "read_only"
+	PrivilegeWrite    PrivilegeLevel = "write" // Can insert, update or delete rows
+	PrivilegeDDL      PrivilegeLevel = "ddl"   // Can create, alter or drop tables or collections
+	PrivilegeUnknown  PrivilegeLevel = "unknown"
+)
+
+// PrivilegeReport describes what a connection's credentials were found to be
+// allowed to do when the connection was saved
+type PrivilegeReport struct {
+	Level     PrivilegeLevel `json:"level" bson:"level"`
+	Superuser bool           `json:"superuser,omitempty" bson:"superuser,omitempty"`
+	Roles     []string       `json:"roles,omitempty" bson:"roles,omitempty"` // Roles granted to the user
+	CanWrite  bool           `json:"can_write" bson:"can_write"`
+	CanDDL    bool           `json:"can_ddl" bson:"can_ddl"`
+	Probed    bool           `json:"probed" bson:"probed"` // A rolled-back write confirmed the grants
+	Warning   string         `json:"warning,omitempty" bson:"warning,omitempty"`
+	Error     string         `json:"error,omitempty" bson:"error,omitempty"`
+	CheckedAt time.Time      `json:"checked_at" bson:"checked_at"`
+}
+
+// privilegeProbeTable is the table created, and rolled back, to find out
+// whether the credentials can change the schema
+const privilegeProbeTable = "goquery_privilege_probe"
+
+// CheckPrivileges inspects the role grants of a connection's credentials and
+// confirms them by attempting a harmless write and table creation inside a
+// transaction that is always rolled back. Credentials that can write or
+// change the schema get a warning, writes only when the connection isn't
+// meant for write queries. A check that fails reports the unknown level.
+func CheckPrivileges(ctx context.Context, db *Database) *PrivilegeReport {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	var report *PrivilegeReport
+	var err error
+	switch db.Type {
+	case "postgresql":
+		report, err = checkPostgresPrivileges(ctx, db)
+	case "mongodb":
+		report, err = checkMongoDBPrivileges(ctx, db)
+	default:
+		err = fmt.Errorf("unsupported database type: %s", db.Type)
+	}
+	if err != nil {
+		return &PrivilegeReport{Level: PrivilegeUnknown, Error: err.Error(), CheckedAt: time.Now()}
+	}
+
+	switch {
+	case report.Superuser || report.CanDDL:
+		report.Level = PrivilegeDDL
+	case report.CanWrite:
+		report.Level = PrivilegeWrite
+	default:
+		report.Level = PrivilegeReadOnly
+	}
+	report.Warning = report.warning(db.AllowWrites)
+	report.CheckedAt = time.Now()
+	return report
+}
+
+// warning explains why credentials with more than read access are a risk
+func (r *PrivilegeReport) warning(allowWrites bool) string {
+	switch {
+	case r.Superuser:
+		return "These credentials have superuser rights and can do anything on the server. Connect with a read-only user instead"
+	case r.Level == PrivilegeDDL:
+		return "These credentials can create, alter or drop tables. Connect with a user that can't change the schema"
+	case r.Level == PrivilegeWrite && !allowWrites:
+		return "These credentials can modify data although the connection doesn't allow write queries. Connect with a read-only user"
+	}
+	return ""
+}
+
+// postgresPrivilegesQuery reads whether the user is a superuser, the roles
+// granted to it, a schema it may create tables in and whether it owns any
+// table, which lets it alter or drop them, and whether the server accepts
+// writes at all
+const postgresPrivilegesQuery = `
+	SELECT
+		r.rolsuper,
+		COALESCE((
+			SELECT string_agg(g.rolname, ',' ORDER BY g.rolname)
+			FROM pg_auth_members m JOIN pg_roles g ON g.oid = m.roleid
+			WHERE m.member = r.oid
+		), ''),
+		COALESCE((
+			SELECT quote_ident(nspname)
+			FROM pg_namespace
+			WHERE nspname NOT IN ('pg_catalog', 'information_schema')
+			  AND nspname NOT LIKE 'pg\_%'
+			  AND has_schema_privilege(oid, 'CREATE')
+			LIMIT 1
+		), ''),
+		EXISTS (
+			SELECT 1 FROM pg_tables
+			WHERE tableowner = current_user
+			  AND schemaname NOT IN ('pg_catalog', 'information_schema')
+		),
+		NOT pg_is_in_recovery() AND current_setting('transaction_read_only') = 'off'
+	FROM pg_roles r
+	WHERE r.rolname = current_user
+`
+
+// postgresWritableTablesQuery finds, for each of INSERT, UPDATE and DELETE, a
+// table the user holds that privilege on and its first column. A list of
+// privileges would pass has_table_privilege when any one of them is held.
+const postgresWritableTablesQuery = `
+	SELECT p.privilege, quote_ident(t.table_schema) || '.' || quote_ident(t.table_name), quote_ident(c.column_name)
+	FROM unnest(ARRAY['INSERT', 'UPDATE', 'DELETE']) AS p(privilege)
+	CROSS JOIN LATERAL (
+		SELECT table_schema, table_name
+		FROM information_schema.tables
+		WHERE table_schema NOT IN ('pg_catalog', 'information_schema')
+		  AND table_type = 'BASE TABLE'
+		  AND has_table_privilege(quote_ident(table_schema) || '.' || quote_ident(table_name), p.privilege)
+		LIMIT 1
+	) t
+	CROSS JOIN LATERAL (
+		SELECT column_name
+		FROM information_schema.columns
+		WHERE table_schema = t.table_schema AND table_name = t.table_name
+		ORDER BY ordinal_position
+		LIMIT 1
+	) c
+`
+
+// postgresWriteProbes are statements that need a privilege but change no
+// rows, for a table and one of its columns
+var postgresWriteProbes = map[string]func(table, column string) string{
+	"INSERT": func(table, column string) string {
+		return "INSERT INTO " + table + " (" + column + ") OVERRIDING SYSTEM VALUE SELECT NULL WHERE false"
+	},
+	"UPDATE": func(table, column string) string {
+		return "UPDATE " + table + " SET " + column + " = " + column + " WHERE false"
+	},
+	"DELETE": func(table, column string) string {
+		return "DELETE FROM " + table + " WHERE false"
+	},
+}
+
+// writableTable is a table the user holds a write privilege on
+type writableTable struct {
+	privilege string
+	table     string
+	column    string
+}
+
+// postgresWritableTables returns a table for each write privilege the user
+// holds on one
+func postgresWritableTables(ctx context.Context, conn *sql.DB) ([]writableTable, error) {
+	rows, err := conn.QueryContext(ctx, postgresWritableTablesQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tables []writableTable
+	for rows.Next() {
+		var table writableTable
+		if err := rows.Scan(&table.privilege, &table.table, &table.column); err != nil {
+			return nil, err
+		}
+		tables = append(tables, table)
+	}
+	return tables, rows.Err()
+}
+
+// checkPostgresPrivileges reads the user's grants and confirms them in a
+// transaction that is rolled back
+func checkPostgresPrivileges(ctx context.Context, db *Database) (*PrivilegeReport, error) {
+	conn, err := openPostgresConnection(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	report := &PrivilegeReport{}
+	var roles, creatableSchema string
+	var ownsTables, serverWritable bool
+	err = conn.QueryRowContext(ctx, postgresPrivilegesQuery).
+		Scan(&report.Superuser, &roles, &creatableSchema, &ownsTables, &serverWritable)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read role grants: %v", err)
+	}
+	if roles != "" {
+		report.Roles = strings.Split(roles, ",")
+	}
+
+	writableTables, err := postgresWritableTables(ctx, conn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read table grants: %v", err)
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start the privilege probe: %v", err)
+	}
+	defer tx.Rollback()
+
+	// Don't wait behind locks other sessions hold on the probed table
+	if _, err := tx.ExecContext(ctx, "SET LOCAL lock_timeout = '2s'"); err != nil {
+		return nil, fmt.Errorf("failed to start the privilege probe: %v", err)
+	}
+	report.Probed = true
+
+	// Changing no rows still needs the privilege, and read-only servers or
+	// transactions refuse it. Each privilege is tried on its own, any one of
+	// them lets the credentials modify data.
+	for _, table := range writableTables {
+		if probePostgres(ctx, tx, postgresWriteProbes[table.privilege](table.table, table.column)) {
+			report.CanWrite = true
+			break
+		}
+	}
+	if creatableSchema != "" {
+		report.CanDDL = probePostgres(ctx, tx, "CREATE TABLE "+creatableSchema+"."+privilegeProbeTable+" (id integer)")
+	}
+
+	// Owners can alter and drop their tables without the right to create any
+	if ownsTables && serverWritable {
+		report.CanDDL = true
+	}
+	return report, nil
+}
+
+// probePostgres runs a statement within a savepoint, so a failure doesn't end
+// the transaction, and reports whether it succeeded
+func probePostgres(ctx context.Context, tx *sql.Tx, statement string) bool {
+	if _, err := tx.ExecContext(ctx, "SAVEPOINT goquery_probe"); err != nil {
+		return false
+	}
+	if _, err := tx.ExecContext(ctx, statement); err != nil {
+		tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT goquery_probe")
+		return false
+	}
+	return true
+}
+
+// mongoDBDDLActions are privilege actions that change collections or indexes
+var mongoDBDDLActions = map[string]bool{
+	"createCollection":       true,
+	"dropCollection":         true,
+	"createIndex":            true,
+	"dropIndex":              true,
+	"collMod":                true,
+	"convertToCapped":        true,
+	"renameCollectionSameDB": true,
+	"dropDatabase":           true,
+}
+
+// mongoDBUnauthorized is the error code of commands the user may not run
+const mongoDBUnauthorized = 13
+
+// checkMongoDBPrivileges reads the user's roles and privileges on the
+// connection's database and confirms writes in a transaction that is aborted
+func checkMongoDBPrivileges(ctx context.Context, db *Database) (*PrivilegeReport, error) {
+	clientOptions, err := mongoDBClientOptions(db)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create MongoDB client: %v", err)
+	}
+	client, err := mongo.Connect(ctx, clientOptions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create MongoDB client: %v", err)
+	}
+	defer client.Disconnect(ctx)
+
+	dbName := mongoDBDatabaseName(db)
+	var status struct {
+		AuthInfo struct {
+			AuthenticatedUsers     []bson.M `bson:"authenticatedUsers"`
+			AuthenticatedUserRoles []struct {
+				Role string `bson:"role"`
+				DB   string `bson:"db"`
+			} `bson:"authenticatedUserRoles"`
+			AuthenticatedUserPrivileges []struct {
+				Resource bson.M   `bson:"resource"`
+				Actions  []string `bson:"actions"`
+			} `bson:"authenticatedUserPrivileges"`
+		} `bson:"authInfo"`
+	}
+	command := bson.D{{Key: "connectionStatus", Value: 1}, {Key: "showPrivileges", Value: true}}
+	if err := client.Database(dbName).RunCommand(ctx, command).Decode(&status); err != nil {
+		return nil, fmt.Errorf("failed to read role grants: %v", err)
+	}
+
+	report := &PrivilegeReport{}
+	if len(status.AuthInfo.AuthenticatedUsers) == 0 {
+		// Deployments without authentication allow everything
+		report.Superuser, report.CanWrite, report.CanDDL = true, true, true
+	} else {
+		for _, role := range status.AuthInfo.AuthenticatedUserRoles {
+			report.Roles = append(report.Roles, role.Role+"@"+role.DB)
+		}
+		for _, privilege := range status.AuthInfo.AuthenticatedUserPrivileges {
+			if anyResource, _ := privilege.Resource["anyResource"].(bool); anyResource {
+				report.Superuser = true
+			}
+			if !mongoDBResourceCovers(privilege.Resource, dbName) {
+				continue
+			}
+			for _, action := range privilege.Actions {
+				if mongoDBWriteActions[action] {
+					report.CanWrite = true
+				}
+				if mongoDBDDLActions[action] {
+					report.CanDDL = true
+				}
+			}
+		}
+	}
+
+	if report.CanWrite {
+		report.CanWrite, report.Probed = probeMongoDBWrite(ctx, client.Database(dbName))
+		if !report.Probed {
+			// Without transactions the grants have to be trusted
+			report.CanWrite = true
+		}
+	}
+	return report, nil
+}
+
+// probeMongoDBWrite deletes nothing from a collection inside a transaction
+// that is aborted. It reports whether the delete was allowed and whether the
+// probe could run at all, which needs a replica set and a collection.
+func probeMongoDBWrite(ctx context.Context, database *mongo.Database) (allowed, probed bool) {
+	names, err := database.ListCollectionNames(ctx, bson.M{"type": "collection"}, options.ListCollections().SetAuthorizedCollections(true))
+	if err != nil || len(names) == 0 {
+		return false, false
+	}
+
+	session, err := database.Client().StartSession()
+	if err != nil {
+		return false, false
+	}
+	defer session.EndSession(ctx)
+
+	if err := session.StartTransaction(); err != nil {
+		return false, false
+	}
+	defer session.AbortTransaction(ctx)
+
+	_, err = database.Collection(names[0]).DeleteMany(
+		mongo.NewSessionContext(ctx, session),
+		bson.M{"_id": bson.M{"$in": bson.A{}}},
+	)
+	var serverErr mongo.ServerError
+	switch {
+	case err == nil:
+		return true, true
+	case errors.As(err, &serverErr) && serverErr.HasErrorCode(mongoDBUnauthorized):
+		return false, true
+	default:
+		// Standalone servers don't support transactions
+		return false, false
+	}
+}
@@ -4,10 +4,11 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"math"
+	"strings"
 	"time"
 
-	"github.com/zucced/goquery/database"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -67,6 +68,13 @@ func sanitizeJSONValue(value interface{}) interface{} {
 	return value
 }
 
+// ResultColumn describes a column in a query's result set
+type ResultColumn struct {
+	Name     string `json:"name" bson:"name"`
+	DBType   string `json:"db_type" bson:"db_type"`
+	Nullable bool   `json:"nullable" bson:"nullable"`
+}
+
 // QueryStatus represents the status of a query
 type QueryStatus string
 
@@ -75,22 +83,41 @@ const (
 	QueryStatusRunning   QueryStatus = "running"
 	QueryStatusCompleted QueryStatus = "completed"
 	QueryStatusFailed    QueryStatus = "failed"
+
+	// QueryStatusAwaitingConfirmation marks a write query, or any query on a
+	// connection that requires confirmation, that has been generated but not
+	// yet confirmed by the user
+	QueryStatusAwaitingConfirmation QueryStatus = "awaiting_confirmation"
+
+	// QueryStatusPendingApproval marks a query against a connection that
+	// requires approval, waiting for an approver of its organization
+	QueryStatusPendingApproval QueryStatus = "pending_approval"
 )
 
 // Query represents a database query
 type Query struct {
-	ID            primitive.ObjectID `json:"id" bson:"_id,omitempty"`
-	UserID        primitive.ObjectID `json:"user_id" bson:"user_id"`
-	DatabaseID    primitive.ObjectID `json:"database_id" bson:"database_id"`
-	Name          string             `json:"name,omitempty" bson:"name,omitempty"`
-	NaturalQuery  string             `json:"query" bson:"natural_query"`
-	GeneratedSQL  string             `json:"sql,omitempty" bson:"generated_sql,omitempty"`
-	Status        QueryStatus        `json:"status" bson:"status"`
-	Results       []QueryResult      `json:"results,omitempty" bson:"results,omitempty"`
-	Error         string             `json:"error,omitempty" bson:"error,omitempty"`
-	ExecutionTime string             `json:"execution_time,omitempty" bson:"execution_time,omitempty"`
-	CreatedAt     time.Time          `json:"created_at" bson:"created_at"`
-	UpdatedAt     time.Time          `json:"updated_at" bson:"updated_at"`
+	ID              primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	UserID          primitive.ObjectID `json:"user_id" bson:"user_id"`
+	OrgID           primitive.ObjectID `json:"org_id,omitempty" bson:"org_id,omitempty"`             // Organization the query is shared with
+	WorkspaceID     primitive.ObjectID `json:"workspace_id,omitempty" bson:"workspace_id,omitempty"` // Workspace within the organization
+	DatabaseID      primitive.ObjectID `json:"database_id" bson:"database_id"`
+	Sources         []FederatedSource  `json:"sources,omitempty" bson:"sources,omitempty"` // Queries a federated query joins the results of, without a database
+	Name            string             `json:"name,omitempty" bson:"name,omitempty"`
+	NaturalQuery    string             `json:"query" bson:"natural_query"`
+	GeneratedSQL    string             `json:"sql,omitempty" bson:"generated_sql,omitempty"`
+	IsWrite         bool               `json:"is_write,omitempty" bson:"is_write,omitempty"`
+	Status          QueryStatus        `json:"status" bson:"status"`
+	Columns         []ResultColumn     `json:"columns,omitempty" bson:"columns,omitempty"`
+	Results         []QueryResult      `json:"results,omitempty" bson:"results,omitempty"`
+	Error           string             `json:"error,omitempty" bson:"error,omitempty"`
+	ExecutionTime   string             `json:"execution_time,omitempty" bson:"execution_time,omitempty"`
+	Tags            []string           `json:"tags,omitempty" bson:"tags,omitempty"`
+	TemplateID      primitive.ObjectID `json:"template_id,omitempty" bson:"template_id,omitempty"`         // Template the query was created from
+	Materialization *Materialization   `json:"materialization,omitempty" bson:"materialization,omitempty"` // Refreshes the results on a schedule
+	Approval        *QueryApproval     `json:"approval,omitempty" bson:"approval,omitempty"`               // Latest approval requested for the query
+	CreatedAt       time.Time          `json:"created_at" bson:"created_at"`
+	UpdatedAt       time.Time          `json:"updated_at" bson:"updated_at"`
+	DeletedAt       *time.Time         `json:"deleted_at,omitempty" bson:"deleted_at,omitempty"`
 }
 
 // MarshalJSON implements the json.Marshaler interface for Query
@@ -105,13 +132,126 @@ func (q Query) MarshalJSON() ([]byte, error) {
 	return json.Marshal(aliasValue)
 }
 
-// QueryCollection returns the queries collection
-func QueryCollection() *mongo.Collection {
-	return database.GetCollection("queries")
+// EncodeJSON writes the query as JSON like MarshalJSON, but encodes its
+// results one row at a time so a large result set is never held in memory a
+// second time as JSON. flush is called every flushEvery rows, when set, to
+// send what has been written so far.
+func (q *Query) EncodeJSON(w io.Writer, flushEvery int, flush func() error) error {
+	// Everything but the results is small, so it is encoded at once
+	head := *q
+	head.Results = nil
+	data, err := json.Marshal(head)
+	if err != nil {
+		return err
+	}
+	if len(q.Results) == 0 {
+		_, err := w.Write(data)
+		return err
+	}
+
+	// Leave the object open to append the results to it
+	if _, err := w.Write(data[:len(data)-1]); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, `,"results":[`); err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(w)
+	for i, row := range q.Results {
+		if i > 0 {
+			if _, err := io.WriteString(w, ","); err != nil {
+				return err
+			}
+			if flush != nil && flushEvery > 0 && i%flushEvery == 0 {
+				if err := flush(); err != nil {
+					return err
+				}
+			}
+		}
+		if err := encoder.Encode(row); err != nil {
+			return err
+		}
+	}
+
+	_, err = io.WriteString(w, "]}")
+	return err
+}
+
+// QueryFilter holds optional filters for listing queries
+type QueryFilter struct {
+	Search      string             // Full-text search over name, natural query and generated SQL
+	Tag         string             // Only return queries carrying this tag
+	WorkspaceID primitive.ObjectID // Only return queries in this workspace when set
+}
+
+// queryCollection returns the queries collection
+func (s *mongoStore) queryCollection() *mongo.Collection {
+	return s.db.Collection("queries")
+}
+
+// ensureQueryIndexes creates the indexes queries are searched and listed by:
+// their owner, database and organization, newest first, and the approvals
+// organizations wait for
+func (s *mongoStore) ensureQueryIndexes(ctx context.Context) error {
+	_, err := s.queryCollection().Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys: bson.D{
+				{Key: "name", Value: "text"},
+				{Key: "natural_query", Value: "text"},
+				{Key: "generated_sql", Value: "text"},
+			},
+			Options: options.Index().SetName("query_text_search"),
+		},
+		{
+			Keys:    bson.D{{Key: "user_id", Value: 1}, {Key: "tags", Value: 1}},
+			Options: options.Index().SetName("query_user_tags"),
+		},
+		{
+			Keys:    bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}},
+			Options: options.Index().SetName("query_user_created"),
+		},
+		{
+			Keys:    bson.D{{Key: "database_id", Value: 1}, {Key: "created_at", Value: -1}},
+			Options: options.Index().SetName("query_database_created"),
+		},
+		{
+			Keys:    bson.D{{Key: "org_id", Value: 1}, {Key: "created_at", Value: -1}},
+			Options: options.Index().SetName("query_org_created").SetSparse(true),
+		},
+		{
+			Keys:    bson.D{{Key: "deleted_at", Value: 1}},
+			Options: options.Index().SetName("query_deleted").SetSparse(true),
+		},
+		{
+			Keys:    bson.D{{Key: "materialization.next_run_at", Value: 1}},
+			Options: options.Index().SetName("query_materialization_due").SetSparse(true),
+		},
+		{
+			Keys:    bson.D{{Key: "approval.org_id", Value: 1}, {Key: "status", Value: 1}},
+			Options: options.Index().SetName("query_approval").SetSparse(true),
+		},
+	})
+	return err
+}
+
+// NormalizeTags trims, lowercases and de-duplicates a list of tags
+func NormalizeTags(tags []string) []string {
+	seen := make(map[string]bool)
+	normalized := []string{}
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		normalized = append(normalized, tag)
+	}
+	return normalized
 }
 
 // CreateQuery creates a new query
-func CreateQuery(ctx context.Context, query *Query) (*Query, error) {
+func (s *mongoStore) CreateQuery(ctx context.Context, query *Query) (*Query, error) {
 	// Set timestamps and initial status
 	now := time.Now()
 	query.CreatedAt = now
@@ -119,7 +259,7 @@ func CreateQuery(ctx context.Context, query *Query) (*Query, error) {
 	query.Status = QueryStatusPending
 
 	// Insert the query into the collection
-	result, err := QueryCollection().InsertOne(ctx, query)
+	result, err := s.queryCollection().InsertOne(ctx, query)
 	if err != nil {
 		return nil, err
 	}
@@ -130,10 +270,13 @@ func CreateQuery(ctx context.Context, query *Query) (*Query, error) {
 	return query, nil
 }
 
-// GetQueryByID retrieves a query by ID
-func GetQueryByID(ctx context.Context, id primitive.ObjectID) (*Query, error) {
+// notDeleted matches documents that have not been moved to the trash
+var notDeleted = bson.M{"$exists": false}
+
+// GetQueryByID retrieves a query by ID, ignoring queries in the trash
+func (s *mongoStore) GetQueryByID(ctx context.Context, id primitive.ObjectID) (*Query, error) {
 	var query Query
-	err := QueryCollection().FindOne(ctx, bson.M{"_id": id}).Decode(&query)
+	err := s.queryCollection().FindOne(ctx, bson.M{"_id": id, "deleted_at": notDeleted}).Decode(&query)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
 			return nil, nil
@@ -143,13 +286,32 @@ func GetQueryByID(ctx context.Context, id primitive.ObjectID) (*Query, error) {
 	return &query, nil
 }
 
-// GetQueriesByUserID retrieves all queries for a user with pagination
-func GetQueriesByUserID(ctx context.Context, userID primitive.ObjectID, page, limit int64) ([]*Query, int64, error) {
-	// Create a filter for the user ID
-	filter := bson.M{"user_id": userID}
+// GetQueriesByUserID retrieves all queries a user owns or can see through an organization with pagination
+func (s *mongoStore) GetQueriesByUserID(ctx context.Context, userID primitive.ObjectID, page, limit int64, queryFilter QueryFilter) ([]*Query, int64, error) {
+	// Create a filter for the user's own and shared queries
+	filter, err := s.ownedOrSharedFilter(ctx, userID)
+	if err != nil {
+		return nil, 0, err
+	}
+	filter["deleted_at"] = notDeleted
+	workspaceFilter(filter, queryFilter.WorkspaceID)
+
+	// Apply optional tag and search filters
+	if queryFilter.Tag != "" {
+		filter["tags"] = strings.ToLower(strings.TrimSpace(queryFilter.Tag))
+	}
+	sort := bson.D{{Key: "created_at", Value: -1}} // Sort by created_at descending (newest first)
+	if queryFilter.Search != "" {
+		filter["$text"] = bson.M{"$search": queryFilter.Search}
+		// Rank by relevance first when searching
+		sort = bson.D{
+			{Key: "score", Value: bson.M{"$meta": "textScore"}},
+			{Key: "created_at", Value: -1},
+		}
+	}
 
 	// Count total documents for pagination
-	totalCount, err := QueryCollection().CountDocuments(ctx, filter)
+	totalCount, err := s.queryCollection().CountDocuments(ctx, filter)
 	if err != nil {
 		return nil, 0, err
 	}
@@ -162,12 +324,12 @@ func GetQueriesByUserID(ctx context.Context, userID primitive.ObjectID, page, li
 
 	// Create options for sorting and pagination
 	opts := options.Find().
-		SetSort(bson.M{"created_at": -1}). // Sort by created_at descending (newest first)
+		SetSort(sort).
 		SetSkip(skip).
 		SetLimit(limit)
 
 	// Execute the query
-	cursor, err := QueryCollection().Find(ctx, filter, opts)
+	cursor, err := s.queryCollection().Find(ctx, filter, opts)
 	if err != nil {
 		return nil, 0, err
 	}
@@ -181,13 +343,19 @@ func GetQueriesByUserID(ctx context.Context, userID primitive.ObjectID, page, li
 	return queries, totalCount, nil
 }
 
-// GetQueriesByDatabaseID retrieves all queries for a specific database with pagination
-func GetQueriesByDatabaseID(ctx context.Context, databaseID primitive.ObjectID, page, limit int64) ([]*Query, int64, error) {
+// GetQueriesByDatabaseID retrieves the queries on a specific database that a user
+// owns or can see through an organization, with pagination
+func (s *mongoStore) GetQueriesByDatabaseID(ctx context.Context, databaseID, userID primitive.ObjectID, page, limit int64) ([]*Query, int64, error) {
 	// Create a filter for the database ID
-	filter := bson.M{"database_id": databaseID}
+	filter, err := s.ownedOrSharedFilter(ctx, userID)
+	if err != nil {
+		return nil, 0, err
+	}
+	filter["database_id"] = databaseID
+	filter["deleted_at"] = notDeleted
 
 	// Count total documents for pagination
-	totalCount, err := QueryCollection().CountDocuments(ctx, filter)
+	totalCount, err := s.queryCollection().CountDocuments(ctx, filter)
 	if err != nil {
 		return nil, 0, err
 	}
@@ -205,7 +373,7 @@ func GetQueriesByDatabaseID(ctx context.Context, databaseID primitive.ObjectID,
 		SetLimit(limit)
 
 	// Execute the query
-	cursor, err := QueryCollection().Find(ctx, filter, opts)
+	cursor, err := s.queryCollection().Find(ctx, filter, opts)
 	if err != nil {
 		return nil, 0, err
 	}
@@ -220,33 +388,217 @@ func GetQueriesByDatabaseID(ctx context.Context, databaseID primitive.ObjectID,
 }
 
 // UpdateQuery updates a query
-func UpdateQuery(ctx context.Context, query *Query) error {
+func (s *mongoStore) UpdateQuery(ctx context.Context, query *Query) error {
 	query.UpdatedAt = time.Now()
 
-	_, err := QueryCollection().UpdateOne(
+	// The materialization is changed by its own methods, so saving a query
+	// loaded before a scheduled refresh doesn't undo the refresh's claim
+	update := *query
+	update.Materialization = nil
+
+	_, err := s.queryCollection().UpdateOne(
 		ctx,
 		bson.M{"_id": query.ID},
-		bson.M{"$set": query},
+		bson.M{"$set": &update},
+	)
+	return err
+}
+
+// SetQueryTags replaces the tags on a query
+func (s *mongoStore) SetQueryTags(ctx context.Context, id primitive.ObjectID, tags []string) error {
+	_, err := s.queryCollection().UpdateOne(
+		ctx,
+		bson.M{"_id": id},
+		bson.M{"$set": bson.M{
+			"tags":       tags,
+			"updated_at": time.Now(),
+		}},
 	)
 	return err
 }
 
-// DeleteQuery deletes a query
-func DeleteQuery(ctx context.Context, id primitive.ObjectID) error {
-	_, err := QueryCollection().DeleteOne(ctx, bson.M{"_id": id})
+// DeleteQuery moves a query to the trash
+func (s *mongoStore) DeleteQuery(ctx context.Context, id primitive.ObjectID) error {
+	now := time.Now()
+	_, err := s.queryCollection().UpdateOne(
+		ctx,
+		bson.M{"_id": id},
+		bson.M{"$set": bson.M{
+			"deleted_at": now,
+			"updated_at": now,
+		}},
+	)
 	return err
 }
 
-// ExecuteQuery executes a query against the specified database
-func ExecuteQuery(db *Database, query string) ([]QueryResult, string, error) {
+// GetTrashedQueryByID retrieves a query that is in the trash
+func (s *mongoStore) GetTrashedQueryByID(ctx context.Context, id primitive.ObjectID) (*Query, error) {
+	var query Query
+	err := s.queryCollection().FindOne(ctx, bson.M{"_id": id, "deleted_at": bson.M{"$exists": true}}).Decode(&query)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &query, nil
+}
+
+// GetTrashedQueriesByUserID retrieves all queries in a user's trash
+func (s *mongoStore) GetTrashedQueriesByUserID(ctx context.Context, userID primitive.ObjectID) ([]*Query, error) {
+	// Leave out results to keep the trash listing light
+	opts := options.Find().
+		SetSort(bson.M{"deleted_at": -1}).
+		SetProjection(bson.M{"results": 0})
+
+	cursor, err := s.queryCollection().Find(ctx, bson.M{"user_id": userID, "deleted_at": bson.M{"$exists": true}}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var queries []*Query
+	if err := cursor.All(ctx, &queries); err != nil {
+		return nil, err
+	}
+
+	return queries, nil
+}
+
+// RestoreQuery moves a query out of the trash
+func (s *mongoStore) RestoreQuery(ctx context.Context, id primitive.ObjectID) error {
+	_, err := s.queryCollection().UpdateOne(
+		ctx,
+		bson.M{"_id": id},
+		bson.M{
+			"$unset": bson.M{"deleted_at": ""},
+			"$set":   bson.M{"updated_at": time.Now()},
+		},
+	)
+	return err
+}
+
+// PurgeDeletedQueries permanently removes queries trashed before the cutoff
+func (s *mongoStore) PurgeDeletedQueries(ctx context.Context, cutoff time.Time) (int64, error) {
+	filter := bson.M{"deleted_at": bson.M{"$lt": cutoff}}
+
+	// Comments go with their query
+	cursor, err := s.queryCollection().Find(ctx, filter, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return 0, err
+	}
+	var purged []struct {
+		ID primitive.ObjectID `bson:"_id"`
+	}
+	if err := cursor.All(ctx, &purged); err != nil {
+		return 0, err
+	}
+	if len(purged) == 0 {
+		return 0, nil
+	}
+	ids := make([]primitive.ObjectID, len(purged))
+	for i, query := range purged {
+		ids[i] = query.ID
+	}
+
+	result, err := s.queryCollection().DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil {
+		return 0, err
+	}
+	if _, err := s.queryCommentCollection().DeleteMany(ctx, bson.M{"query_id": bson.M{"$in": ids}}); err != nil {
+		return result.DeletedCount, err
+	}
+	return result.DeletedCount, nil
+}
+
+// PurgeQueryResults removes the stored results of queries last run before
+// the cutoff, which are rerun to get them back. Materialized queries keep
+// theirs, since they are refreshed on their schedule anyway.
+func (s *mongoStore) PurgeQueryResults(ctx context.Context, cutoff time.Time) (int64, error) {
+	result, err := s.queryCollection().UpdateMany(
+		ctx,
+		bson.M{
+			"updated_at":      bson.M{"$lt": cutoff},
+			"results":         bson.M{"$exists": true},
+			"materialization": bson.M{"$exists": false},
+		},
+		bson.M{"$unset": bson.M{"results": ""}},
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.ModifiedCount, nil
+}
+
+// IsWriteQuery reports whether a generated query modifies the target database
+func IsWriteQuery(dbType, query string) bool {
+	switch dbType {
+	case "postgresql":
+		return isPostgresWriteQuery(query)
+	case "mongodb":
+		return isMongoDBWriteQuery(query)
+	default:
+		return false
+	}
+}
+
+// ExecuteQuery executes a read-only query against the specified database and
+// returns the rows, metadata about the result columns and the execution time
+func ExecuteQuery(ctx context.Context, db *Database, query string) ([]QueryResult, []ResultColumn, string, error) {
+	return executeQuery(ctx, db, query, nil, false)
+}
+
+// ExecuteQueryWithVariables binds dashboard variable values into a query and
+// executes it as a read-only query
+func ExecuteQueryWithVariables(ctx context.Context, db *Database, query string, values map[string]interface{}) ([]QueryResult, []ResultColumn, string, error) {
+	bound, args, err := BindQueryVariables(db.Type, query, values)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	return executeQuery(ctx, db, bound, args, false)
+}
+
+// ExecuteWriteQuery executes a query that has been confirmed as a write
+// against a database that allows write operations
+func ExecuteWriteQuery(ctx context.Context, db *Database, query string) ([]QueryResult, []ResultColumn, string, error) {
+	if !db.AllowWrites {
+		return nil, nil, "", fmt.Errorf("write operations are not allowed on this database")
+	}
+	return executeQuery(ctx, db, query, nil, true)
+}
+
+// executeQuery dispatches a query to the executor for the database type
+func executeQuery(ctx context.Context, db *Database, query string, args []interface{}, allowWrites bool) ([]QueryResult, []ResultColumn, string, error) {
 	startTime := time.Now()
 
+	// A single statement is checked and run, so others can't slip past the
+	// read-only, aggregation and limit checks
+	if db.Type == "postgresql" && isPostgresMultiStatement(query) {
+		return nil, nil, "", ErrMultipleStatements
+	}
+
+	// Confirmed writes aren't reads, so the aggregations-only setting doesn't apply
+	if !allowWrites || !IsWriteQuery(db.Type, query) {
+		if err := db.checkAggregationsOnly(query); err != nil {
+			return nil, nil, "", err
+		}
+	}
+
+	var results []QueryResult
+	var columns []ResultColumn
+	var executionTime string
+	var err error
 	switch db.Type {
 	case "postgresql":
-		return executePostgresQuery(db, query, startTime)
+		results, columns, executionTime, err = executePostgresQuery(ctx, db, query, args, startTime, allowWrites)
 	case "mongodb":
-		return executeMongoDBQuery(db, query, startTime)
+		results, columns, executionTime, err = executeMongoDBQuery(ctx, db, query, startTime, allowWrites)
 	default:
-		return nil, "", fmt.Errorf("unsupported database type: %s", db.Type)
+		return nil, nil, "", fmt.Errorf("unsupported database type: %s", db.Type)
 	}
+	if err != nil {
+		return results, columns, executionTime, err
+	}
+
+	return db.limitRows(results), columns, executionTime, nil
 }
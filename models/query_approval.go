@@ -0,0 +1,151 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Decisions on a query waiting for approval
+const (
+	ApprovalApproved = "approved"
+	ApprovalRejected = "rejected"
+)
+
+// MaxPendingApprovals caps the queries listed for an approver
+const MaxPendingApprovals = 100
+
+// QueryApproval records the approval a query against a connection that
+// requires one waits for, and the decision once an approver made it
+type QueryApproval struct {
+	OrgID       primitive.ObjectID `json:"org_id" bson:"org_id"` // Organization whose approvers decide
+	SQL         string             `json:"sql" bson:"sql"`       // The statement approvers decide on
+	RequestedAt time.Time          `json:"requested_at" bson:"requested_at"`
+	Decision    string             `json:"decision,omitempty" bson:"decision,omitempty"`
+	ReviewerID  primitive.ObjectID `json:"reviewer_id,omitempty" bson:"reviewer_id,omitempty"`
+	Reason      string             `json:"reason,omitempty" bson:"reason,omitempty"` // Given when rejecting
+	DecidedAt   *time.Time         `json:"decided_at,omitempty" bson:"decided_at,omitempty"`
+}
+
+// RequiresApproval reports whether queries against the connection wait for an
+// approver of its organization before they run
+func (db *Database) RequiresApproval() bool {
+	return db.SafetyLevel == SafetyApproval
+}
+
+// ApprovedToRun reports whether an approver approved the query's current SQL,
+// so it may run again against a connection that requires approval
+func (q *Query) ApprovedToRun() bool {
+	return q.Approval != nil && q.Approval.Decision == ApprovalApproved && q.Approval.SQL == q.GeneratedSQL
+}
+
+// CanApprove reports whether a user can approve queries against the
+// organization's connections: its approvers, admins and owner
+func (o *Organization) CanApprove(userID primitive.ObjectID) bool {
+	switch o.RoleFor(userID) {
+	case OrgRoleApprover, OrgRoleAdmin, OrgRoleOwner:
+		return true
+	}
+	return false
+}
+
+// Approvers returns the members who can approve a query of the given user,
+// everyone who can approve but the user themselves
+func (o *Organization) Approvers(requesterID primitive.ObjectID) []OrganizationMember {
+	approvers := []OrganizationMember{}
+	for _, member := range o.Members {
+		if member.UserID != requesterID && o.CanApprove(member.UserID) {
+			approvers = append(approvers, member)
+		}
+	}
+	return approvers
+}
+
+// DecideQueryApproval records an approver's decision on a query waiting for
+// approval. Approved queries are marked running, rejected ones failed with
+// the reason. It returns false when the query was decided, rerun or trashed
+// in the meantime.
+func (s *mongoStore) DecideQueryApproval(ctx context.Context, query *Query, reviewerID primitive.ObjectID, approved bool, reason string) (bool, error) {
+	now := time.Now()
+	approval := *query.Approval
+	approval.ReviewerID = reviewerID
+	approval.DecidedAt = &now
+
+	status := QueryStatusRunning
+	queryError := ""
+	if approved {
+		approval.Decision = ApprovalApproved
+	} else {
+		approval.Decision = ApprovalRejected
+		approval.Reason = reason
+		status = QueryStatusFailed
+		queryError = "Query was rejected"
+		if reason != "" {
+			queryError += ": " + reason
+		}
+	}
+
+	result, err := s.queryCollection().UpdateOne(
+		ctx,
+		bson.M{
+			"_id":                   query.ID,
+			"status":                QueryStatusPendingApproval,
+			"approval.requested_at": query.Approval.RequestedAt,
+			"deleted_at":            notDeleted,
+		},
+		bson.M{"$set": bson.M{
+			"status":     status,
+			"error":      queryError,
+			"approval":   approval,
+			"updated_at": now,
+		}},
+	)
+	if err != nil {
+		return false, err
+	}
+
+	if result.ModifiedCount == 0 {
+		return false, nil
+	}
+
+	query.Status = status
+	query.Error = queryError
+	query.Approval = &approval
+	query.UpdatedAt = now
+	return true, nil
+}
+
+// GetPendingApprovals retrieves the queries waiting for the approvers of the
+// given organizations, oldest first, leaving out the user's own
+func (s *mongoStore) GetPendingApprovals(ctx context.Context, orgIDs []primitive.ObjectID, userID primitive.ObjectID) ([]*Query, error) {
+	queries := []*Query{}
+	if len(orgIDs) == 0 {
+		return queries, nil
+	}
+
+	filter := bson.M{
+		"status":          QueryStatusPendingApproval,
+		"approval.org_id": bson.M{"$in": orgIDs},
+		"user_id":         bson.M{"$ne": userID},
+		"deleted_at":      notDeleted,
+	}
+	opts := options.Find().
+		SetSort(bson.M{"approval.requested_at": 1}).
+		SetLimit(MaxPendingApprovals).
+		SetProjection(bson.M{"results": 0})
+
+	cursor, err := s.queryCollection().Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	if err := cursor.All(ctx, &queries); err != nil {
+		return nil, err
+	}
+
+	return queries, nil
+}
@@ -0,0 +1,129 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// QueryComment is a note a user left on a query, optionally about one of its
+// scheduled runs or a row of its results. The row is copied when the comment
+// is made, so it still makes sense after the query is rerun.
+type QueryComment struct {
+	ID          primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	QueryID     primitive.ObjectID `json:"query_id" bson:"query_id"`
+	UserID      primitive.ObjectID `json:"user_id" bson:"user_id"`
+	AuthorName  string             `json:"author_name,omitempty" bson:"author_name,omitempty"`
+	AuthorEmail string             `json:"author_email" bson:"author_email"`
+	Body        string             `json:"body" bson:"body"`
+	RunID       primitive.ObjectID `json:"run_id,omitempty" bson:"run_id,omitempty"`
+	Row         *int               `json:"row,omitempty" bson:"row,omitempty"`               // Index of the result row the comment is about
+	Column      string             `json:"column,omitempty" bson:"column,omitempty"`         // Column of the row, when it's about one value
+	RowValues   QueryResult        `json:"row_values,omitempty" bson:"row_values,omitempty"` // The row when the comment was made
+	CreatedAt   time.Time          `json:"created_at" bson:"created_at"`
+	EditedAt    *time.Time         `json:"edited_at,omitempty" bson:"edited_at,omitempty"`
+}
+
+// queryCommentCollection returns the query comments collection
+func (s *mongoStore) queryCommentCollection() *mongo.Collection {
+	return s.db.Collection("comments")
+}
+
+// ensureQueryCommentIndexes creates the index comments are listed by
+func (s *mongoStore) ensureQueryCommentIndexes(ctx context.Context) error {
+	_, err := s.queryCommentCollection().Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "query_id", Value: 1}, {Key: "created_at", Value: 1}},
+			Options: options.Index().SetName("comment_query"),
+		},
+	})
+	return err
+}
+
+// CreateQueryComment saves a new comment
+func (s *mongoStore) CreateQueryComment(ctx context.Context, comment *QueryComment) error {
+	comment.CreatedAt = time.Now()
+
+	result, err := s.queryCommentCollection().InsertOne(ctx, comment)
+	if err != nil {
+		return err
+	}
+
+	// Set the ID
+	comment.ID = result.InsertedID.(primitive.ObjectID)
+
+	return nil
+}
+
+// GetQueryComment retrieves a comment on a query
+func (s *mongoStore) GetQueryComment(ctx context.Context, queryID, id primitive.ObjectID) (*QueryComment, error) {
+	var comment QueryComment
+	err := s.queryCommentCollection().FindOne(ctx, bson.M{"_id": id, "query_id": queryID}).Decode(&comment)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &comment, nil
+}
+
+// GetQueryComments retrieves the comments on a query, oldest first, with
+// pagination. A run ID limits them to the comments about that run.
+func (s *mongoStore) GetQueryComments(ctx context.Context, queryID, runID primitive.ObjectID, page, limit int64) ([]*QueryComment, int64, error) {
+	filter := bson.M{"query_id": queryID}
+	if !runID.IsZero() {
+		filter["run_id"] = runID
+	}
+
+	// Count total documents for pagination
+	totalCount, err := s.queryCommentCollection().CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}}).
+		SetSkip((page - 1) * limit).
+		SetLimit(limit)
+
+	cursor, err := s.queryCommentCollection().Find(ctx, filter, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cursor.Close(ctx)
+
+	comments := []*QueryComment{}
+	if err := cursor.All(ctx, &comments); err != nil {
+		return nil, 0, err
+	}
+
+	return comments, totalCount, nil
+}
+
+// UpdateQueryCommentBody replaces the body of a comment and marks it edited
+func (s *mongoStore) UpdateQueryCommentBody(ctx context.Context, comment *QueryComment, body string) error {
+	now := time.Now()
+	_, err := s.queryCommentCollection().UpdateOne(
+		ctx,
+		bson.M{"_id": comment.ID},
+		bson.M{"$set": bson.M{"body": body, "edited_at": now}},
+	)
+	if err != nil {
+		return err
+	}
+
+	comment.Body = body
+	comment.EditedAt = &now
+	return nil
+}
+
+// DeleteQueryComment removes a comment
+func (s *mongoStore) DeleteQueryComment(ctx context.Context, id primitive.ObjectID) error {
+	_, err := s.queryCommentCollection().DeleteOne(ctx, bson.M{"_id": id})
+	return err
+}
@@ -0,0 +1,154 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// QueryRunRetention is how long the history of scheduled runs is kept
+const QueryRunRetention = 90 * 24 * time.Hour
+
+// Triggers of scheduled query runs
+const (
+	QueryRunTriggerCardRefresh = "card_refresh" // A dashboard card's refresh interval
+	QueryRunTriggerReport      = "report"       // A report schedule refreshing its dashboard
+	QueryRunTriggerMaterialize = "materialize"  // A materialized query's scheduled refresh
+)
+
+// QueryRun records a scheduled execution of a saved query, so users can check
+// their scheduled refreshes actually succeeded. Runs by hand are the query's
+// own results and aren't recorded.
+type QueryRun struct {
+	ID               primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	QueryID          primitive.ObjectID `json:"query_id" bson:"query_id"`
+	UserID           primitive.ObjectID `json:"user_id" bson:"user_id"` // Owner of the dashboard or query the run was for
+	DashboardID      primitive.ObjectID `json:"dashboard_id,omitempty" bson:"dashboard_id,omitempty"`
+	CardID           primitive.ObjectID `json:"card_id,omitempty" bson:"card_id,omitempty"` // Its latest cached results are at the card
+	ReportScheduleID primitive.ObjectID `json:"report_schedule_id,omitempty" bson:"report_schedule_id,omitempty"`
+	SnapshotID       primitive.ObjectID `json:"snapshot_id,omitempty" bson:"snapshot_id,omitempty"` // The report snapshot holding the results
+	Trigger          string             `json:"trigger" bson:"trigger"`
+	Status           QueryStatus        `json:"status" bson:"status"`
+	DurationMs       int64              `json:"duration_ms" bson:"duration_ms"`
+	RowCount         int                `json:"row_count" bson:"row_count"`
+	Error            string             `json:"error,omitempty" bson:"error,omitempty"`
+	StartedAt        time.Time          `json:"started_at" bson:"started_at"`
+}
+
+// NewQueryRun describes a scheduled refresh of a card that started at start
+// and ended with data and err. Data is nil when the card couldn't be run.
+func NewQueryRun(trigger string, dashboard *Dashboard, card *DashboardCard, start time.Time, data *CardData, err error) *QueryRun {
+	run := &QueryRun{
+		QueryID:     card.QueryID,
+		UserID:      dashboard.UserID,
+		DashboardID: dashboard.ID,
+		CardID:      card.ID,
+		Trigger:     trigger,
+		Status:      QueryStatusCompleted,
+		DurationMs:  time.Since(start).Milliseconds(),
+		StartedAt:   start,
+	}
+	if data != nil {
+		run.RowCount = len(data.Results)
+	}
+	if err != nil {
+		run.Status = QueryStatusFailed
+		run.Error = err.Error()
+		run.RowCount = 0
+	}
+	return run
+}
+
+// queryRunCollection returns the query runs collection
+func (s *mongoStore) queryRunCollection() *mongo.Collection {
+	return s.db.Collection("query_runs")
+}
+
+// ensureQueryRunIndexes creates the indexes query runs are listed and
+// expired by
+func (s *mongoStore) ensureQueryRunIndexes(ctx context.Context) error {
+	_, err := s.queryRunCollection().Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "started_at", Value: 1}},
+			Options: options.Index().SetName("query_run_expiry").SetExpireAfterSeconds(int32(QueryRunRetention.Seconds())),
+		},
+		{
+			Keys:    bson.D{{Key: "query_id", Value: 1}, {Key: "started_at", Value: -1}},
+			Options: options.Index().SetName("query_run_query"),
+		},
+	})
+	return err
+}
+
+// CreateQueryRun records a scheduled run of a query
+func (s *mongoStore) CreateQueryRun(ctx context.Context, run *QueryRun) error {
+	result, err := s.queryRunCollection().InsertOne(ctx, run)
+	if err != nil {
+		return err
+	}
+
+	// Set the ID
+	run.ID = result.InsertedID.(primitive.ObjectID)
+
+	return nil
+}
+
+// SetQueryRunsSnapshot links runs made for a report to the snapshot of its results
+func (s *mongoStore) SetQueryRunsSnapshot(ctx context.Context, ids []primitive.ObjectID, snapshotID primitive.ObjectID) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	_, err := s.queryRunCollection().UpdateMany(
+		ctx,
+		bson.M{"_id": bson.M{"$in": ids}},
+		bson.M{"$set": bson.M{"snapshot_id": snapshotID}},
+	)
+	return err
+}
+
+// GetQueryRun retrieves a scheduled run of a query
+func (s *mongoStore) GetQueryRun(ctx context.Context, queryID, id primitive.ObjectID) (*QueryRun, error) {
+	var run QueryRun
+	err := s.queryRunCollection().FindOne(ctx, bson.M{"_id": id, "query_id": queryID}).Decode(&run)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &run, nil
+}
+
+// GetQueryRuns retrieves the scheduled runs of a query, newest first, with pagination
+func (s *mongoStore) GetQueryRuns(ctx context.Context, queryID primitive.ObjectID, page, limit int64) ([]*QueryRun, int64, error) {
+	filter := bson.M{"query_id": queryID}
+
+	// Count total documents for pagination
+	totalCount, err := s.queryRunCollection().CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	opts := options.Find().
+		SetSort(bson.M{"started_at": -1}).
+		SetSkip((page - 1) * limit).
+		SetLimit(limit)
+
+	cursor, err := s.queryRunCollection().Find(ctx, filter, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cursor.Close(ctx)
+
+	runs := []*QueryRun{}
+	if err := cursor.All(ctx, &runs); err != nil {
+		return nil, 0, err
+	}
+
+	return runs, totalCount, nil
+}
@@ -0,0 +1,226 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// TemplateRequirement is a table a connection's schema must have, with at
+// least the listed columns, for a template to run against it
+type TemplateRequirement struct {
+	Table   string   `json:"table" bson:"table"`
+	Columns []string `json:"columns,omitempty" bson:"columns,omitempty"`
+}
+
+// QueryTemplate is a parameterized query published to an organization's
+// library, which members turn into queries on their own connections. Its SQL
+// references its variables with {{name}} like dashboard card queries.
+type QueryTemplate struct {
+	ID           primitive.ObjectID    `json:"id" bson:"_id,omitempty"`
+	OrgID        primitive.ObjectID    `json:"org_id" bson:"org_id"`
+	UserID       primitive.ObjectID    `json:"user_id" bson:"user_id"` // The member who published it
+	AuthorName   string                `json:"author_name,omitempty" bson:"author_name,omitempty"`
+	Name         string                `json:"name" bson:"name"`
+	Description  string                `json:"description,omitempty" bson:"description,omitempty"`
+	DatabaseType string                `json:"database_type" bson:"database_type"`
+	SQL          string                `json:"sql" bson:"sql"`
+	Variables    []DashboardVariable   `json:"variables,omitempty" bson:"variables,omitempty"`
+	Requirements []TemplateRequirement `json:"requirements,omitempty" bson:"requirements,omitempty"`
+	Uses         int                   `json:"uses" bson:"uses"` // How many queries were created from it
+	CreatedAt    time.Time             `json:"created_at" bson:"created_at"`
+	UpdatedAt    time.Time             `json:"updated_at" bson:"updated_at"`
+}
+
+// Validate checks the template's variables and that its SQL only references
+// them and doesn't change data
+func (t *QueryTemplate) Validate() error {
+	if t.DatabaseType != "postgresql" && t.DatabaseType != "mongodb" {
+		return fmt.Errorf("unsupported database type: %s", t.DatabaseType)
+	}
+	if IsWriteQuery(t.DatabaseType, t.SQL) {
+		return errors.New("templates can't change data")
+	}
+	if err := ValidateDashboardVariables(t.Variables); err != nil {
+		return err
+	}
+
+	defined := make(map[string]DashboardVariable, len(t.Variables))
+	for _, variable := range t.Variables {
+		defined[variable.Name] = variable
+	}
+	for _, match := range variablePlaceholderRegex.FindAllStringSubmatch(t.SQL, -1) {
+		placeholder := match[1]
+		name := strings.TrimSuffix(strings.TrimSuffix(placeholder, ".start"), ".end")
+		variable, ok := defined[name]
+		if !ok {
+			return fmt.Errorf("the query uses variable %s, which isn't defined", name)
+		}
+		if (variable.Type == VariableTypeDateRange) != (placeholder != name) {
+			if variable.Type == VariableTypeDateRange {
+				return fmt.Errorf("date range %s is used as {{%s.start}} and {{%s.end}}", name, name, name)
+			}
+			return fmt.Errorf("only date ranges have a start and end: {{%s}}", placeholder)
+		}
+	}
+
+	for _, requirement := range t.Requirements {
+		if strings.TrimSpace(requirement.Table) == "" {
+			return errors.New("required tables need a name")
+		}
+	}
+	return nil
+}
+
+// MissingRequirements lists the required tables and columns a schema lacks,
+// e.g. "orders" or "orders.total". Names are compared case-insensitively.
+func (t *QueryTemplate) MissingRequirements(schema *Schema) []string {
+	tables := make(map[string]*Table)
+	if schema != nil {
+		for i := range schema.Tables {
+			tables[strings.ToLower(schema.Tables[i].Name)] = &schema.Tables[i]
+		}
+	}
+
+	missing := []string{}
+	for _, requirement := range t.Requirements {
+		table, ok := tables[strings.ToLower(requirement.Table)]
+		if !ok {
+			missing = append(missing, requirement.Table)
+			continue
+		}
+
+		columns := make(map[string]bool, len(table.Columns))
+		for _, column := range table.Columns {
+			columns[strings.ToLower(column.Name)] = true
+		}
+		for _, column := range requirement.Columns {
+			if !columns[strings.ToLower(column)] {
+				missing = append(missing, requirement.Table+"."+column)
+			}
+		}
+	}
+	return missing
+}
+
+// Render returns the template's SQL with the supplied variable values, or the
+// variables' defaults, written into it
+func (t *QueryTemplate) Render(supplied map[string]string) (string, error) {
+	values, err := ResolveVariableValues(t.Variables, supplied)
+	if err != nil {
+		return "", err
+	}
+	return RenderQueryVariables(t.DatabaseType, t.SQL, values)
+}
+
+// queryTemplateCollection returns the query templates collection
+func (s *mongoStore) queryTemplateCollection() *mongo.Collection {
+	return s.db.Collection("query_templates")
+}
+
+// ensureQueryTemplateIndexes indexes templates by their organization's library
+func (s *mongoStore) ensureQueryTemplateIndexes(ctx context.Context) error {
+	_, err := s.queryTemplateCollection().Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "org_id", Value: 1}, {Key: "name", Value: 1}},
+			Options: options.Index().SetName("query_template_org"),
+		},
+	})
+	return err
+}
+
+// CreateQueryTemplate publishes a template to its organization's library
+func (s *mongoStore) CreateQueryTemplate(ctx context.Context, template *QueryTemplate) (*QueryTemplate, error) {
+	// Set timestamps
+	now := time.Now()
+	template.CreatedAt = now
+	template.UpdatedAt = now
+
+	result, err := s.queryTemplateCollection().InsertOne(ctx, template)
+	if err != nil {
+		return nil, err
+	}
+
+	// Set the ID
+	template.ID = result.InsertedID.(primitive.ObjectID)
+
+	return template, nil
+}
+
+// GetQueryTemplateByID retrieves a template by ID
+func (s *mongoStore) GetQueryTemplateByID(ctx context.Context, id primitive.ObjectID) (*QueryTemplate, error) {
+	var template QueryTemplate
+	err := s.queryTemplateCollection().FindOne(ctx, bson.M{"_id": id}).Decode(&template)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &template, nil
+}
+
+// GetQueryTemplatesByOrgID retrieves an organization's library, by name
+func (s *mongoStore) GetQueryTemplatesByOrgID(ctx context.Context, orgID primitive.ObjectID) ([]*QueryTemplate, error) {
+	opts := options.Find().SetSort(bson.M{"name": 1})
+
+	cursor, err := s.queryTemplateCollection().Find(ctx, bson.M{"org_id": orgID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	templates := []*QueryTemplate{}
+	if err := cursor.All(ctx, &templates); err != nil {
+		return nil, err
+	}
+
+	return templates, nil
+}
+
+// UpdateQueryTemplate saves a template's name, description, SQL, variables
+// and requirements
+func (s *mongoStore) UpdateQueryTemplate(ctx context.Context, template *QueryTemplate) error {
+	template.UpdatedAt = time.Now()
+
+	_, err := s.queryTemplateCollection().UpdateOne(
+		ctx,
+		bson.M{"_id": template.ID},
+		bson.M{"$set": bson.M{
+			"name":          template.Name,
+			"description":   template.Description,
+			"database_type": template.DatabaseType,
+			"sql":           template.SQL,
+			"variables":     template.Variables,
+			"requirements":  template.Requirements,
+			"updated_at":    template.UpdatedAt,
+		}},
+	)
+	return err
+}
+
+// CountQueryTemplateUse records that a query was created from a template
+func (s *mongoStore) CountQueryTemplateUse(ctx context.Context, id primitive.ObjectID) error {
+	_, err := s.queryTemplateCollection().UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$inc": bson.M{"uses": 1}})
+	return err
+}
+
+// DeleteQueryTemplate removes a template from its library. Queries created
+// from it are kept.
+func (s *mongoStore) DeleteQueryTemplate(ctx context.Context, id primitive.ObjectID) error {
+	_, err := s.queryTemplateCollection().DeleteOne(ctx, bson.M{"_id": id})
+	return err
+}
+
+// deleteOrganizationTemplates deletes an organization's library
+func (s *mongoStore) deleteOrganizationTemplates(ctx context.Context, orgID primitive.ObjectID) error {
+	_, err := s.queryTemplateCollection().DeleteMany(ctx, bson.M{"org_id": orgID})
+	return err
+}
@@ -0,0 +1,102 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync/atomic"
+)
+
+// ReadReplica is a read-only copy of a SQL database that analytical queries
+// are sent to. It shares the primary's credentials, TLS and ssh tunnel.
+type ReadReplica struct {
+	Host string `json:"host" bson:"host"`
+	Port string `json:"port,omitempty" bson:"port,omitempty"` // Defaults to the primary's port
+}
+
+// address returns the replica's host:port for messages
+func (r ReadReplica) address(defaultPort string) string {
+	port := r.Port
+	if port == "" {
+		port = defaultPort
+	}
+	return net.JoinHostPort(r.Host, port)
+}
+
+// ValidateReadReplicas checks the replicas of a connection
+func ValidateReadReplicas(dbType string, replicas []ReadReplica) error {
+	if len(replicas) == 0 {
+		return nil
+	}
+	if dbType != "postgresql" {
+		return fmt.Errorf("read replicas are not supported for %s connections", dbType)
+	}
+	for _, replica := range replicas {
+		if replica.Host == "" {
+			return errors.New("read replica host is required")
+		}
+	}
+	return nil
+}
+
+// replicaRotation spreads reads across a connection's replicas
+var replicaRotation uint32
+
+// replica returns a copy of the connection pointing at a read replica
+func (db *Database) replica(replica ReadReplica) *Database {
+	replicaDB := *db
+	replicaDB.Host = replica.Host
+	if replica.Port != "" {
+		replicaDB.Port = replica.Port
+	}
+	replicaDB.ReadReplicas = nil
+	return &replicaDB
+}
+
+// openPostgresConnection opens and pings a connection to a PostgreSQL database
+func openPostgresConnection(ctx context.Context, db *Database) (*sql.DB, error) {
+	connector, err := newPostgresConnector(db)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create connector: %v", err)
+	}
+
+	conn := sql.OpenDB(connector)
+	if err := conn.PingContext(ctx); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to ping database: %v", err)
+	}
+	return conn, nil
+}
+
+// openPostgresReadConnection opens a connection for read-only queries. The
+// replicas are tried in turn, starting with a different one each time, and
+// the primary is only used when none of them can be reached.
+func openPostgresReadConnection(ctx context.Context, db *Database) (*sql.DB, error) {
+	if count := len(db.ReadReplicas); count > 0 {
+		start := int(atomic.AddUint32(&replicaRotation, 1) % uint32(count))
+		for i := 0; i < count; i++ {
+			replica := db.ReadReplicas[(start+i)%count]
+			conn, err := openPostgresConnection(ctx, db.replica(replica))
+			if err == nil {
+				return conn, nil
+			}
+			log.Printf("Read replica %s of database %s is unavailable: %v", replica.address(db.Port), db.ID.Hex(), err)
+		}
+		log.Printf("No read replica of database %s is available, using the primary", db.ID.Hex())
+	}
+
+	return openPostgresConnection(ctx, db)
+}
+
+// testPostgresReplicas tests the connection to each read replica
+func testPostgresReplicas(ctx context.Context, db *Database) error {
+	for _, replica := range db.ReadReplicas {
+		if err := testPostgresConnection(ctx, db.replica(replica)); err != nil {
+			return fmt.Errorf("read replica %s: %v", replica.address(db.Port), err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package models
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Relationship is a reference from columns of one table to columns of
+// another, such as a foreign key. Columns are listed in matching order.
+type Relationship struct {
+	Name        string   `json:"name,omitempty" bson:"name,omitempty"`
+	FromTable   string   `json:"from_table" bson:"from_table"`
+	FromColumns []string `json:"from_columns" bson:"from_columns"`
+	ToTable     string   `json:"to_table" bson:"to_table"`
+	ToColumns   []string `json:"to_columns" bson:"to_columns"`
+	Inferred    bool     `json:"inferred,omitempty" bson:"inferred,omitempty"` // Guessed from naming rather than declared
+}
+
+// Involves reports whether the relationship starts or ends at table
+func (r Relationship) Involves(table string) bool {
+	return r.FromTable == table || r.ToTable == table
+}
+
+// referenceFieldRegex matches field names that conventionally reference
+// another collection, such as customerId, customer_id or customerRef
+var referenceFieldRegex = regexp.MustCompile(`^(.+?)(?:_id|_ref|Id|ID|Ref)$`)
+
+// inferMongoDBRelationships guesses references between collections from
+// ObjectID fields named after another collection
+func inferMongoDBRelationships(tables []Table) []Relationship {
+	collections := make(map[string]string, len(tables))
+	for _, table := range tables {
+		collections[normalizeCollectionName(table.Name)] = table.Name
+	}
+
+	var relationships []Relationship
+	for _, table := range tables {
+		for _, column := range table.Columns {
+			if column.Type != "ObjectID" || column.Name == "_id" {
+				continue
+			}
+
+			match := referenceFieldRegex.FindStringSubmatch(column.Name)
+			if match == nil {
+				continue
+			}
+
+			target, ok := matchCollection(collections, match[1])
+			if !ok {
+				continue
+			}
+
+			relationships = append(relationships, Relationship{
+				FromTable:   table.Name,
+				FromColumns: []string{column.Name},
+				ToTable:     target,
+				ToColumns:   []string{"_id"},
+				Inferred:    true,
+			})
+		}
+	}
+
+	return relationships
+}
+
+// matchCollection finds the collection a reference field's base name refers
+// to, trying the name as is and its common plural forms
+func matchCollection(collections map[string]string, base string) (string, bool) {
+	base = normalizeCollectionName(base)
+	candidates := []string{base, base + "s", base + "es"}
+	if strings.HasSuffix(base, "y") {
+		candidates = append(candidates, strings.TrimSuffix(base, "y")+"ies")
+	}
+
+	for _, candidate := range candidates {
+		if name, ok := collections[candidate]; ok {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// normalizeCollectionName lowercases a name and drops separators so that
+// orderItems, order_items and order-items compare equal
+func normalizeCollectionName(name string) string {
+	name = strings.ToLower(name)
+	name = strings.ReplaceAll(name, "_", "")
+	return strings.ReplaceAll(name, "-", "")
+}
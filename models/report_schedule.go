@@ -0,0 +1,297 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ReportSchedule emails a dashboard summary to a list of recipients on a cron schedule
+type ReportSchedule struct {
+	ID            primitive.ObjectID   `json:"id" bson:"_id,omitempty"`
+	DashboardID   primitive.ObjectID   `json:"dashboard_id" bson:"dashboard_id"`
+	UserID        primitive.ObjectID   `json:"user_id" bson:"user_id"`
+	Name          string               `json:"name" bson:"name"`
+	Cron          string               `json:"cron" bson:"cron"`
+	Timezone      string               `json:"timezone" bson:"timezone"`
+	Recipients    []string             `json:"recipients" bson:"recipients"`
+	ChannelIDs    []primitive.ObjectID `json:"channel_ids,omitempty" bson:"channel_ids,omitempty"` // Notification channels told about each report
+	IncludeCharts bool                 `json:"include_charts" bson:"include_charts"`
+	Enabled       bool                 `json:"enabled" bson:"enabled"`
+	NextRunAt     time.Time            `json:"next_run_at" bson:"next_run_at"`
+	LastRunAt     *time.Time           `json:"last_run_at,omitempty" bson:"last_run_at,omitempty"`
+	CreatedAt     time.Time            `json:"created_at" bson:"created_at"`
+	UpdatedAt     time.Time            `json:"updated_at" bson:"updated_at"`
+}
+
+// ReportDeliveryStatus represents the outcome of a report delivery
+type ReportDeliveryStatus string
+
+const (
+	ReportDeliverySent   ReportDeliveryStatus = "sent"
+	ReportDeliveryFailed ReportDeliveryStatus = "failed"
+)
+
+// ReportDelivery records a single run of a report schedule
+type ReportDelivery struct {
+	ID          primitive.ObjectID   `json:"id" bson:"_id,omitempty"`
+	ScheduleID  primitive.ObjectID   `json:"schedule_id" bson:"schedule_id"`
+	DashboardID primitive.ObjectID   `json:"dashboard_id" bson:"dashboard_id"`
+	SnapshotID  primitive.ObjectID   `json:"snapshot_id,omitempty" bson:"snapshot_id,omitempty"`
+	Recipients  []string             `json:"recipients" bson:"recipients"`
+	Status      ReportDeliveryStatus `json:"status" bson:"status"`
+	Error       string               `json:"error,omitempty" bson:"error,omitempty"`
+	StartedAt   time.Time            `json:"started_at" bson:"started_at"`
+	CompletedAt time.Time            `json:"completed_at" bson:"completed_at"`
+}
+
+// reportScheduleCollection returns the report schedules collection
+func (s *mongoStore) reportScheduleCollection() *mongo.Collection {
+	return s.db.Collection("report_schedules")
+}
+
+// reportDeliveryCollection returns the report deliveries collection
+func (s *mongoStore) reportDeliveryCollection() *mongo.Collection {
+	return s.db.Collection("report_deliveries")
+}
+
+// ensureReportIndexes creates the indexes report schedules are listed and
+// run by, and deliveries are listed by
+func (s *mongoStore) ensureReportIndexes(ctx context.Context) error {
+	_, err := s.reportScheduleCollection().Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "dashboard_id", Value: 1}, {Key: "created_at", Value: -1}},
+			Options: options.Index().SetName("report_schedule_dashboard"),
+		},
+		{
+			Keys:    bson.D{{Key: "enabled", Value: 1}, {Key: "next_run_at", Value: 1}},
+			Options: options.Index().SetName("report_schedule_due"),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = s.reportDeliveryCollection().Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "schedule_id", Value: 1}, {Key: "started_at", Value: -1}},
+			Options: options.Index().SetName("report_delivery_schedule"),
+		},
+	})
+	return err
+}
+
+// NextReportRun returns the first time after the given time that matches a
+// standard five-field cron expression in the given timezone
+func NextReportRun(expr, timezone string, after time.Time) (time.Time, error) {
+	schedule, err := cron.ParseStandard(expr)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid cron expression: %v", err)
+	}
+
+	location := time.UTC
+	if timezone != "" {
+		location, err = time.LoadLocation(timezone)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid timezone: %v", err)
+		}
+	}
+
+	next := schedule.Next(after.In(location))
+	if next.IsZero() {
+		return time.Time{}, fmt.Errorf("cron expression never matches")
+	}
+
+	return next.UTC(), nil
+}
+
+// CreateReportSchedule creates a new report schedule
+func (s *mongoStore) CreateReportSchedule(ctx context.Context, schedule *ReportSchedule) (*ReportSchedule, error) {
+	// Set timestamps
+	now := time.Now()
+	schedule.CreatedAt = now
+	schedule.UpdatedAt = now
+
+	nextRun, err := NextReportRun(schedule.Cron, schedule.Timezone, now)
+	if err != nil {
+		return nil, err
+	}
+	schedule.NextRunAt = nextRun
+
+	result, err := s.reportScheduleCollection().InsertOne(ctx, schedule)
+	if err != nil {
+		return nil, err
+	}
+
+	// Set the ID
+	schedule.ID = result.InsertedID.(primitive.ObjectID)
+
+	return schedule, nil
+}
+
+// GetReportScheduleByID retrieves a report schedule by ID
+func (s *mongoStore) GetReportScheduleByID(ctx context.Context, id primitive.ObjectID) (*ReportSchedule, error) {
+	var schedule ReportSchedule
+	err := s.reportScheduleCollection().FindOne(ctx, bson.M{"_id": id}).Decode(&schedule)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &schedule, nil
+}
+
+// GetReportSchedulesByDashboardID retrieves all report schedules for a dashboard
+func (s *mongoStore) GetReportSchedulesByDashboardID(ctx context.Context, dashboardID primitive.ObjectID) ([]*ReportSchedule, error) {
+	opts := options.Find().SetSort(bson.M{"created_at": -1})
+
+	cursor, err := s.reportScheduleCollection().Find(ctx, bson.M{"dashboard_id": dashboardID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	schedules := []*ReportSchedule{}
+	if err := cursor.All(ctx, &schedules); err != nil {
+		return nil, err
+	}
+
+	return schedules, nil
+}
+
+// GetDueReportSchedules retrieves enabled report schedules whose next run is due
+func (s *mongoStore) GetDueReportSchedules(ctx context.Context, now time.Time) ([]*ReportSchedule, error) {
+	cursor, err := s.reportScheduleCollection().Find(ctx, bson.M{
+		"enabled":     true,
+		"next_run_at": bson.M{"$lte": now},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var schedules []*ReportSchedule
+	if err := cursor.All(ctx, &schedules); err != nil {
+		return nil, err
+	}
+
+	return schedules, nil
+}
+
+// UpdateReportSchedule updates a report schedule and recomputes its next run
+func (s *mongoStore) UpdateReportSchedule(ctx context.Context, schedule *ReportSchedule) error {
+	now := time.Now()
+	schedule.UpdatedAt = now
+
+	nextRun, err := NextReportRun(schedule.Cron, schedule.Timezone, now)
+	if err != nil {
+		return err
+	}
+	schedule.NextRunAt = nextRun
+
+	_, err = s.reportScheduleCollection().UpdateOne(
+		ctx,
+		bson.M{"_id": schedule.ID},
+		bson.M{"$set": bson.M{
+			"name":           schedule.Name,
+			"cron":           schedule.Cron,
+			"timezone":       schedule.Timezone,
+			"recipients":     schedule.Recipients,
+			"channel_ids":    schedule.ChannelIDs,
+			"include_charts": schedule.IncludeCharts,
+			"enabled":        schedule.Enabled,
+			"next_run_at":    schedule.NextRunAt,
+			"updated_at":     schedule.UpdatedAt,
+		}},
+	)
+	return err
+}
+
+// ClaimReportSchedule advances a due schedule to its next run time. It returns
+// false when another worker already claimed this run.
+func (s *mongoStore) ClaimReportSchedule(ctx context.Context, schedule *ReportSchedule, now time.Time) (bool, error) {
+	nextRun, err := NextReportRun(schedule.Cron, schedule.Timezone, now)
+	if err != nil {
+		return false, err
+	}
+
+	result, err := s.reportScheduleCollection().UpdateOne(
+		ctx,
+		bson.M{"_id": schedule.ID, "next_run_at": schedule.NextRunAt},
+		bson.M{"$set": bson.M{
+			"next_run_at": nextRun,
+			"last_run_at": now,
+		}},
+	)
+	if err != nil {
+		return false, err
+	}
+
+	if result.ModifiedCount == 0 {
+		return false, nil
+	}
+
+	schedule.NextRunAt = nextRun
+	schedule.LastRunAt = &now
+	return true, nil
+}
+
+// DisableReportSchedule turns off a schedule that can no longer run
+func (s *mongoStore) DisableReportSchedule(ctx context.Context, id primitive.ObjectID) error {
+	_, err := s.reportScheduleCollection().UpdateOne(
+		ctx,
+		bson.M{"_id": id},
+		bson.M{"$set": bson.M{"enabled": false, "updated_at": time.Now()}},
+	)
+	return err
+}
+
+// DeleteReportSchedule deletes a report schedule and its delivery history
+func (s *mongoStore) DeleteReportSchedule(ctx context.Context, id primitive.ObjectID) error {
+	if _, err := s.reportScheduleCollection().DeleteOne(ctx, bson.M{"_id": id}); err != nil {
+		return err
+	}
+
+	_, err := s.reportDeliveryCollection().DeleteMany(ctx, bson.M{"schedule_id": id})
+	return err
+}
+
+// CreateReportDelivery records a report delivery
+func (s *mongoStore) CreateReportDelivery(ctx context.Context, delivery *ReportDelivery) error {
+	result, err := s.reportDeliveryCollection().InsertOne(ctx, delivery)
+	if err != nil {
+		return err
+	}
+
+	// Set the ID
+	delivery.ID = result.InsertedID.(primitive.ObjectID)
+
+	return nil
+}
+
+// GetReportDeliveries retrieves the most recent deliveries of a report schedule
+func (s *mongoStore) GetReportDeliveries(ctx context.Context, scheduleID primitive.ObjectID, limit int) ([]*ReportDelivery, error) {
+	opts := options.Find().
+		SetSort(bson.M{"started_at": -1}).
+		SetLimit(int64(limit))
+
+	cursor, err := s.reportDeliveryCollection().Find(ctx, bson.M{"schedule_id": scheduleID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	deliveries := []*ReportDelivery{}
+	if err := cursor.All(ctx, &deliveries); err != nil {
+		return nil, err
+	}
+
+	return deliveries, nil
+}
@@ -0,0 +1,64 @@
+package models
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// runningQueries are the queries this process is generating or executing
+var runningQueries = struct {
+	sync.Mutex
+	ids map[primitive.ObjectID]int
+}{ids: make(map[primitive.ObjectID]int)}
+
+// TrackRunningQuery records that this process is running a query until the
+// returned function is called
+func TrackRunningQuery(id primitive.ObjectID) func() {
+	runningQueries.Lock()
+	runningQueries.ids[id]++
+	runningQueries.Unlock()
+
+	return func() {
+		runningQueries.Lock()
+		defer runningQueries.Unlock()
+
+		runningQueries.ids[id]--
+		if runningQueries.ids[id] <= 0 {
+			delete(runningQueries.ids, id)
+		}
+	}
+}
+
+// FailRunningQueries marks the queries this process is still generating or
+// running as failed, so a shutdown doesn't leave them pending or running
+// forever. It returns the number of queries updated.
+func (s *mongoStore) FailRunningQueries(ctx context.Context, reason string) (int64, error) {
+	runningQueries.Lock()
+	ids := make([]primitive.ObjectID, 0, len(runningQueries.ids))
+	for id := range runningQueries.ids {
+		ids = append(ids, id)
+	}
+	runningQueries.Unlock()
+
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	result, err := s.queryCollection().UpdateMany(
+		ctx,
+		bson.M{"_id": bson.M{"$in": ids}, "status": bson.M{"$in": []QueryStatus{QueryStatusPending, QueryStatusRunning}}},
+		bson.M{"$set": bson.M{
+			"status":     QueryStatusFailed,
+			"error":      reason,
+			"updated_at": time.Now(),
+		}},
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.ModifiedCount, nil
+}
@@ -0,0 +1,155 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MaxColumnDescriptionLength caps the length of a column description
+const MaxColumnDescriptionLength = 1000
+
+// MaxEnumValues caps the number of values an enum column can list
+const MaxEnumValues = 100
+
+// Column semantic kinds
+const (
+	SemanticCurrency      = "currency"       // Amounts in the currency's major unit
+	SemanticCurrencyCents = "currency_cents" // Amounts in the currency's minor unit, such as cents
+	SemanticSoftDelete    = "soft_delete"    // Set when the row is deleted
+	SemanticEnum          = "enum"           // Only ever holds one of Values
+	SemanticUnixTimestamp = "unix_timestamp" // Seconds since the Unix epoch
+	SemanticPercentage    = "percentage"     // Percentages from 0 to 100
+)
+
+// ColumnAnnotation is what users know about a column that the database
+// doesn't say. Nested MongoDB fields are addressed by their full path.
+type ColumnAnnotation struct {
+	Table       string           `json:"table" bson:"table"`
+	Column      string           `json:"column" bson:"column"`
+	Description string           `json:"description,omitempty" bson:"description,omitempty"`
+	Semantics   *ColumnSemantics `json:"semantics,omitempty" bson:"semantics,omitempty"`
+}
+
+// ColumnSemantics is the meaning of a column's values, which can't be
+// inferred from its name or type
+type ColumnSemantics struct {
+	Kind     string   `json:"kind" bson:"kind"`
+	Currency string   `json:"currency,omitempty" bson:"currency,omitempty"` // ISO 4217 code of currency columns
+	Values   []string `json:"values,omitempty" bson:"values,omitempty"`     // Values of enum columns
+}
+
+// Validate checks the kind is known and only has the settings it uses
+func (s *ColumnSemantics) Validate() error {
+	switch s.Kind {
+	case SemanticCurrency, SemanticCurrencyCents:
+		if s.Currency != "" && !currencyCodeRegex.MatchString(s.Currency) {
+			return fmt.Errorf("invalid currency code: %s", s.Currency)
+		}
+	case SemanticSoftDelete, SemanticUnixTimestamp, SemanticPercentage, SemanticEnum:
+		if s.Currency != "" {
+			return fmt.Errorf("currency only applies to currency columns")
+		}
+	default:
+		return fmt.Errorf("invalid semantic kind: %s", s.Kind)
+	}
+
+	if s.Kind != SemanticEnum {
+		if len(s.Values) > 0 {
+			return errors.New("values only apply to enum columns")
+		}
+		return nil
+	}
+
+	if len(s.Values) == 0 || len(s.Values) > MaxEnumValues {
+		return fmt.Errorf("enum columns must list between 1 and %d values", MaxEnumValues)
+	}
+	for _, value := range s.Values {
+		if value == "" {
+			return errors.New("enum values must not be empty")
+		}
+	}
+	return nil
+}
+
+// ValidateColumnAnnotations checks annotations name a column, stay within the
+// length limits, have valid semantics and don't annotate the same column twice
+func ValidateColumnAnnotations(annotations []ColumnAnnotation) error {
+	seen := make(map[string]bool)
+	for _, annotation := range annotations {
+		if strings.TrimSpace(annotation.Table) == "" || strings.TrimSpace(annotation.Column) == "" {
+			return errors.New("annotation table and column are required")
+		}
+		if len(annotation.Description) > MaxColumnDescriptionLength {
+			return fmt.Errorf("description of %s.%s must be at most %d characters", annotation.Table, annotation.Column, MaxColumnDescriptionLength)
+		}
+		if annotation.Semantics != nil {
+			if err := annotation.Semantics.Validate(); err != nil {
+				return fmt.Errorf("%s.%s: %v", annotation.Table, annotation.Column, err)
+			}
+		}
+
+		key := annotation.Table + "." + annotation.Column
+		if seen[key] {
+			return fmt.Errorf("column %s is annotated more than once", key)
+		}
+		seen[key] = true
+	}
+	return nil
+}
+
+// SetColumnAnnotations replaces the column annotations of a database
+func (s *mongoStore) SetColumnAnnotations(ctx context.Context, id primitive.ObjectID, annotations []ColumnAnnotation) error {
+	_, err := s.databaseCollection().UpdateOne(
+		ctx,
+		bson.M{"_id": id},
+		bson.M{"$set": bson.M{"annotations": annotations, "updated_at": time.Now()}},
+	)
+	return err
+}
+
+// annotateSchema returns a copy of the schema with the database's column
+// annotations applied. The stored schema is left untouched.
+func (db *Database) annotateSchema(schema *Schema) *Schema {
+	if schema == nil || len(db.Annotations) == 0 {
+		return schema
+	}
+
+	byColumn := make(map[string]ColumnAnnotation, len(db.Annotations))
+	for _, annotation := range db.Annotations {
+		byColumn[annotation.Table+"."+annotation.Column] = annotation
+	}
+
+	tables := make([]Table, len(schema.Tables))
+	for i, table := range schema.Tables {
+		table.Columns = annotateColumns(table.Name, table.Columns, byColumn)
+		tables[i] = table
+	}
+
+	return &Schema{Tables: tables, Relationships: schema.Relationships, Functions: schema.Functions}
+}
+
+// annotateColumns copies columns and their nested fields, applying annotations
+func annotateColumns(tableName string, columns []Column, byColumn map[string]ColumnAnnotation) []Column {
+	annotated := make([]Column, len(columns))
+	for i, column := range columns {
+		path := column.Path
+		if path == "" {
+			path = column.Name
+		}
+		if annotation, ok := byColumn[tableName+"."+path]; ok {
+			column.Description = annotation.Description
+			column.Semantics = annotation.Semantics
+		}
+		if len(column.Fields) > 0 {
+			column.Fields = annotateColumns(tableName, column.Fields, byColumn)
+		}
+		annotated[i] = column
+	}
+	return annotated
+}
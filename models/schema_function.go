@@ -0,0 +1,69 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// MaxSchemaFunctions caps the number of functions and procedures discovered per database
+const MaxSchemaFunctions = 200
+
+// Routine kinds
+const (
+	RoutineFunction  = "function"
+	RoutineProcedure = "procedure"
+)
+
+// Function is a user-defined function or stored procedure
+type Function struct {
+	Name       string `json:"name" bson:"name"`
+	Kind       string `json:"kind" bson:"kind"`           // function or procedure
+	Arguments  string `json:"arguments" bson:"arguments"` // As declared, e.g. "start_date date, end_date date"
+	ReturnType string `json:"return_type,omitempty" bson:"return_type,omitempty"`
+	Comment    string `json:"comment,omitempty" bson:"comment,omitempty"`
+}
+
+// fetchPostgresFunctions fetches the functions and procedures defined in the
+// public schema, leaving out those installed by extensions
+func fetchPostgresFunctions(db *sql.DB, ctx context.Context) ([]Function, error) {
+	query := `
+		SELECT
+			p.proname,
+			CASE p.prokind WHEN 'p' THEN 'procedure' ELSE 'function' END,
+			pg_get_function_arguments(p.oid),
+			COALESCE(pg_get_function_result(p.oid), ''),
+			COALESCE(obj_description(p.oid, 'pg_proc'), '')
+		FROM
+			pg_proc p
+		JOIN
+			pg_namespace n ON n.oid = p.pronamespace
+		WHERE
+			n.nspname = 'public'
+			AND p.prokind IN ('f', 'p')
+			AND NOT EXISTS (
+				SELECT 1 FROM pg_depend d
+				WHERE d.classid = 'pg_proc'::regclass AND d.objid = p.oid AND d.deptype = 'e'
+			)
+		ORDER BY
+			p.proname, p.oid
+		LIMIT $1
+	`
+
+	rows, err := db.QueryContext(ctx, query, MaxSchemaFunctions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query functions: %v", err)
+	}
+	defer rows.Close()
+
+	var functions []Function
+	for rows.Next() {
+		var function Function
+		if err := rows.Scan(&function.Name, &function.Kind, &function.Arguments, &function.ReturnType, &function.Comment); err != nil {
+			return nil, fmt.Errorf("failed to scan function: %v", err)
+		}
+		functions = append(functions, function)
+	}
+
+	return functions, rows.Err()
+}
@@ -0,0 +1,217 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MinSchemaRefreshInterval is the shortest allowed background refresh interval, in seconds
+const MinSchemaRefreshInterval = 300
+
+// Schema refresh statuses
+const (
+	SchemaRefreshPending   = "pending"
+	SchemaRefreshRunning   = "running"
+	SchemaRefreshSucceeded = "succeeded"
+	SchemaRefreshFailed    = "failed"
+)
+
+// SchemaRefreshStatus records the outcome of the latest schema refresh
+type SchemaRefreshStatus struct {
+	Status        string     `json:"status" bson:"status"`
+	Error         string     `json:"error,omitempty" bson:"error,omitempty"`
+	LastAttemptAt *time.Time `json:"last_attempt_at,omitempty" bson:"last_attempt_at,omitempty"` // Last refresh attempt, successful or not
+	RefreshedAt   *time.Time `json:"refreshed_at,omitempty" bson:"refreshed_at,omitempty"`       // Last successful refresh
+}
+
+// ValidateSchemaRefreshInterval checks a background refresh interval in seconds
+func ValidateSchemaRefreshInterval(seconds int) error {
+	if seconds != 0 && seconds < MinSchemaRefreshInterval {
+		return fmt.Errorf("schema refresh interval must be 0 or at least %d seconds", MinSchemaRefreshInterval)
+	}
+	return nil
+}
+
+// SchemaRefreshDue reports whether the database's refresh interval has
+// elapsed since its last refresh attempt
+func (db *Database) SchemaRefreshDue(now time.Time) bool {
+	if db.SchemaRefreshInterval <= 0 {
+		return false
+	}
+	if db.SchemaRefresh == nil || db.SchemaRefresh.LastAttemptAt == nil {
+		return true
+	}
+	interval := time.Duration(db.SchemaRefreshInterval) * time.Second
+	return now.Sub(*db.SchemaRefresh.LastAttemptAt) >= interval
+}
+
+// GetDatabasesWithSchemaRefresh retrieves every database with a background
+// refresh interval, without their schemas
+func (s *mongoStore) GetDatabasesWithSchemaRefresh(ctx context.Context) ([]*Database, error) {
+	cursor, err := s.databaseCollection().Find(
+		ctx,
+		bson.M{"schema_refresh_interval": bson.M{"$gt": 0}},
+		options.Find().SetProjection(bson.M{"schema": 0}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var databases []*Database
+	if err := cursor.All(ctx, &databases); err != nil {
+		return nil, err
+	}
+
+	return databases, nil
+}
+
+// MarkSchemaRefreshPending records that a refresh has been queued, keeping
+// the times of earlier refreshes
+func (s *mongoStore) MarkSchemaRefreshPending(ctx context.Context, id primitive.ObjectID) error {
+	_, err := s.databaseCollection().UpdateOne(
+		ctx,
+		bson.M{"_id": id},
+		bson.M{
+			"$set":   bson.M{"schema_refresh.status": SchemaRefreshPending},
+			"$unset": bson.M{"schema_refresh.error": ""},
+		},
+	)
+	return err
+}
+
+// RefreshDatabaseSchema fetches the schema and stats of a database and stores
+// them along with the outcome. A failed refresh keeps the previous schema. It
+// returns how the schema changed, nil when there was no previous schema to
+// compare with.
+func (s *mongoStore) RefreshDatabaseSchema(ctx context.Context, id primitive.ObjectID) (*SchemaChange, error) {
+	db, err := s.GetDatabaseByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if db == nil {
+		return nil, errors.New("database not found")
+	}
+
+	now := time.Now()
+	status := SchemaRefreshStatus{Status: SchemaRefreshRunning, LastAttemptAt: &now}
+	if db.SchemaRefresh != nil {
+		status.RefreshedAt = db.SchemaRefresh.RefreshedAt
+	}
+	if err := s.setSchemaRefreshStatus(ctx, id, status, nil); err != nil {
+		return nil, err
+	}
+
+	schema, err := FetchDatabaseSchema(ctx, db)
+	if err != nil {
+		status.Status = SchemaRefreshFailed
+		status.Error = err.Error()
+		if updateErr := s.setSchemaRefreshStatus(ctx, id, status, nil); updateErr != nil {
+			return nil, updateErr
+		}
+		return nil, err
+	}
+
+	update := bson.M{"schema": schema}
+	stats, err := FetchDatabaseStats(ctx, db)
+	if err != nil {
+		// Stats are informational, a failure doesn't fail the refresh
+		log.Printf("Failed to fetch stats for database %s: %v", id.Hex(), err)
+	} else {
+		update["stats"] = stats
+	}
+
+	refreshedAt := time.Now()
+	status.Status = SchemaRefreshSucceeded
+	status.RefreshedAt = &refreshedAt
+	update["last_connected"] = refreshedAt
+	if err := s.setSchemaRefreshStatus(ctx, id, status, update); err != nil {
+		return nil, err
+	}
+
+	if db.Schema == nil {
+		return nil, nil
+	}
+	return DiffSchemas(db.Schema, schema), nil
+}
+
+// SchemaChange lists the tables that differ between two versions of a schema
+type SchemaChange struct {
+	AddedTables   []string `json:"added_tables"`
+	RemovedTables []string `json:"removed_tables"`
+	ChangedTables []string `json:"changed_tables"` // Tables whose columns were added, removed or changed type
+}
+
+// Empty reports whether the schema didn't change
+func (c *SchemaChange) Empty() bool {
+	return len(c.AddedTables) == 0 && len(c.RemovedTables) == 0 && len(c.ChangedTables) == 0
+}
+
+// DiffSchemas compares the tables of two schemas by their columns' names and
+// types. Nullability, indexes and sampling details are left out, since they
+// vary between samples of MongoDB collections.
+func DiffSchemas(previous, current *Schema) *SchemaChange {
+	change := &SchemaChange{AddedTables: []string{}, RemovedTables: []string{}, ChangedTables: []string{}}
+
+	before := make(map[string]string, len(previous.Tables))
+	for _, table := range previous.Tables {
+		before[table.Name] = columnSignature(table.Columns)
+	}
+
+	seen := make(map[string]bool, len(current.Tables))
+	for _, table := range current.Tables {
+		seen[table.Name] = true
+		signature, ok := before[table.Name]
+		switch {
+		case !ok:
+			change.AddedTables = append(change.AddedTables, table.Name)
+		case signature != columnSignature(table.Columns):
+			change.ChangedTables = append(change.ChangedTables, table.Name)
+		}
+	}
+	for _, table := range previous.Tables {
+		if !seen[table.Name] {
+			change.RemovedTables = append(change.RemovedTables, table.Name)
+		}
+	}
+
+	sort.Strings(change.AddedTables)
+	sort.Strings(change.RemovedTables)
+	sort.Strings(change.ChangedTables)
+	return change
+}
+
+// columnSignature describes columns, including nested fields, in a form that
+// only changes when a column is added, removed or changes type
+func columnSignature(columns []Column) string {
+	parts := make([]string, 0, len(columns))
+	for _, column := range columns {
+		part := column.Name + ":" + column.Type
+		if len(column.Fields) > 0 {
+			part += "{" + columnSignature(column.Fields) + "}"
+		}
+		parts = append(parts, part)
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ",")
+}
+
+// setSchemaRefreshStatus stores the refresh status together with any other fields
+func (s *mongoStore) setSchemaRefreshStatus(ctx context.Context, id primitive.ObjectID, status SchemaRefreshStatus, fields bson.M) error {
+	set := bson.M{"schema_refresh": status}
+	for key, value := range fields {
+		set[key] = value
+	}
+
+	_, err := s.databaseCollection().UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": set})
+	return err
+}
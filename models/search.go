@@ -0,0 +1,253 @@
+package models
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Search result kinds
+const (
+	SearchQuery     = "query"
+	SearchDashboard = "dashboard"
+	SearchTable     = "table"
+	SearchColumn    = "column"
+)
+
+// searchKindOrder breaks ties between results of different kinds that score
+// the same
+var searchKindOrder = map[string]int{
+	SearchQuery:     0,
+	SearchDashboard: 1,
+	SearchTable:     2,
+	SearchColumn:    3,
+}
+
+// SearchResult is a query, dashboard, table or column matching a search.
+// Tables and columns are identified by their database and name.
+type SearchResult struct {
+	Kind         string             `json:"kind"`
+	ID           primitive.ObjectID `json:"id,omitempty"` // The query or dashboard
+	Name         string             `json:"name"`
+	Description  string             `json:"description,omitempty"` // A query's natural text, a dashboard's description or a column's type
+	DatabaseID   primitive.ObjectID `json:"database_id,omitempty"`
+	DatabaseName string             `json:"database_name,omitempty"`
+	Table        string             `json:"table,omitempty"` // The table a column belongs to
+	Score        float64            `json:"score"`
+}
+
+// searchQuery is the part of a query a search result is built from
+type searchQuery struct {
+	ID           primitive.ObjectID `bson:"_id"`
+	Name         string             `bson:"name"`
+	NaturalQuery string             `bson:"natural_query"`
+	DatabaseID   primitive.ObjectID `bson:"database_id"`
+	Score        float64            `bson:"score"`
+}
+
+// searchDashboard is the part of a dashboard a search result is built from
+type searchDashboard struct {
+	ID          primitive.ObjectID `bson:"_id"`
+	Name        string             `bson:"name"`
+	Description string             `bson:"description"`
+	Score       float64            `bson:"score"`
+}
+
+// searchDatabase is the part of a database its tables and columns are
+// searched in, with the table patterns that hide some of them
+type searchDatabase struct {
+	ID            primitive.ObjectID `bson:"_id"`
+	Name          string             `bson:"name"`
+	Schema        *Schema            `bson:"schema"`
+	IncludeTables []string           `bson:"include_tables"`
+	ExcludeTables []string           `bson:"exclude_tables"`
+}
+
+// searchTerms splits a search into the lowercase words names are matched
+// against, without the quotes and negations of Mongo's text search syntax
+func searchTerms(text string) []string {
+	var terms []string
+	for _, term := range strings.Fields(strings.ToLower(text)) {
+		term = strings.Trim(term, `"-`)
+		if term != "" {
+			terms = append(terms, term)
+		}
+	}
+	return terms
+}
+
+// nameScore rates how well a name matches a search: the whole search 3, a
+// name starting with it 2, a name sharing a word with it 1, otherwise 0.
+// Words match when either contains the other, so "orders" finds "order".
+func nameScore(name, text string, terms []string) float64 {
+	name = strings.ToLower(name)
+	text = strings.ToLower(strings.TrimSpace(text))
+	switch {
+	case name == text:
+		return 3
+	case strings.HasPrefix(name, text):
+		return 2
+	}
+	for _, term := range terms {
+		if strings.Contains(name, term) || (len(name) >= 3 && strings.Contains(term, name)) {
+			return 1
+		}
+	}
+	return 0
+}
+
+// Search finds the queries, dashboards, tables and columns a user can access
+// matching text, best matches first. Names matching the search rank above
+// results that only match elsewhere, e.g. in a query's natural text.
+func (s *mongoStore) Search(ctx context.Context, userID, workspaceID primitive.ObjectID, text string, limit int) ([]*SearchResult, error) {
+	terms := searchTerms(text)
+	textSearch := bson.M{"$search": text}
+	score := bson.M{"$meta": "textScore"}
+	opts := func(projection bson.M) *options.FindOptions {
+		projection["score"] = score
+		return options.Find().
+			SetProjection(projection).
+			SetSort(bson.M{"score": score}).
+			SetLimit(int64(limit))
+	}
+
+	results := []*SearchResult{}
+
+	// Queries by name and natural text
+	queryFilter, err := s.ownedOrSharedFilter(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	queryFilter["deleted_at"] = notDeleted
+	queryFilter["$text"] = textSearch
+	workspaceFilter(queryFilter, workspaceID)
+
+	var queries []searchQuery
+	cursor, err := s.queryCollection().Find(ctx, queryFilter, opts(bson.M{"name": 1, "natural_query": 1, "database_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+	if err := cursor.All(ctx, &queries); err != nil {
+		return nil, err
+	}
+	for _, query := range queries {
+		name := query.Name
+		if name == "" {
+			name = query.NaturalQuery
+		}
+		results = append(results, &SearchResult{
+			Kind:        SearchQuery,
+			ID:          query.ID,
+			Name:        name,
+			Description: query.NaturalQuery,
+			DatabaseID:  query.DatabaseID,
+			Score:       query.Score + nameScore(name, text, terms),
+		})
+	}
+
+	// Dashboards by name and description
+	orgIDs, err := s.GetOrganizationIDsByUserID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	dashboardFilter := dashboardAccessFilter(userID, orgIDs)
+	dashboardFilter["deleted_at"] = notDeleted
+	dashboardFilter["$text"] = textSearch
+	workspaceFilter(dashboardFilter, workspaceID)
+
+	var dashboards []searchDashboard
+	cursor, err = s.dashboardCollection().Find(ctx, dashboardFilter, opts(bson.M{"name": 1, "description": 1}))
+	if err != nil {
+		return nil, err
+	}
+	if err := cursor.All(ctx, &dashboards); err != nil {
+		return nil, err
+	}
+	for _, dashboard := range dashboards {
+		results = append(results, &SearchResult{
+			Kind:        SearchDashboard,
+			ID:          dashboard.ID,
+			Name:        dashboard.Name,
+			Description: dashboard.Description,
+			Score:       dashboard.Score + nameScore(dashboard.Name, text, terms),
+		})
+	}
+
+	// Tables and columns of the databases whose schema matches
+	databaseFilter, err := s.databaseAccessFilter(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	databaseFilter["$text"] = textSearch
+	workspaceFilter(databaseFilter, workspaceID)
+
+	var databases []searchDatabase
+	cursor, err = s.databaseCollection().Find(ctx, databaseFilter, opts(bson.M{
+		"name":                       1,
+		"schema.tables.name":         1,
+		"schema.tables.columns.name": 1,
+		"schema.tables.columns.type": 1,
+		"include_tables":             1,
+		"exclude_tables":             1,
+	}))
+	if err != nil {
+		return nil, err
+	}
+	if err := cursor.All(ctx, &databases); err != nil {
+		return nil, err
+	}
+	for _, database := range databases {
+		if database.Schema == nil {
+			continue
+		}
+		filter := &Database{IncludeTables: database.IncludeTables, ExcludeTables: database.ExcludeTables}
+		for _, table := range database.Schema.Tables {
+			// Tables hidden by the connection's patterns aren't searched
+			if !filter.TableVisible(table.Name) {
+				continue
+			}
+			if score := nameScore(table.Name, text, terms); score > 0 {
+				results = append(results, &SearchResult{
+					Kind:         SearchTable,
+					Name:         table.Name,
+					DatabaseID:   database.ID,
+					DatabaseName: database.Name,
+					Score:        score,
+				})
+			}
+			for _, column := range table.Columns {
+				if score := nameScore(column.Name, text, terms); score > 0 {
+					results = append(results, &SearchResult{
+						Kind:         SearchColumn,
+						Name:         column.Name,
+						Description:  column.Type,
+						DatabaseID:   database.ID,
+						DatabaseName: database.Name,
+						Table:        table.Name,
+						Score:        score,
+					})
+				}
+			}
+		}
+	}
+
+	// Rank the results of all kinds together
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		if results[i].Kind != results[j].Kind {
+			return searchKindOrder[results[i].Kind] < searchKindOrder[results[j].Kind]
+		}
+		return results[i].Name < results[j].Name
+	})
+	if len(results) > limit {
+		results = results[:limit]
+	}
+
+	return results, nil
+}
@@ -0,0 +1,85 @@
+package models
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// SecretPrefix is the prefix of the environment variables connections can
+// reference as ${secret:NAME}, keeping the server's own configuration out of
+// reach
+var SecretPrefix = "GOQUERY_SECRET_"
+
+// secretReference matches values that reference a secret, e.g.
+// ${secret:ANALYTICS_PASSWORD}
+var secretReference = regexp.MustCompile(`^\$\{secret:([A-Za-z0-9_]+)\}$`)
+
+// SecretReference returns the name of the secret a value references, or ""
+// when it's a plain value
+func SecretReference(value string) string {
+	match := secretReference.FindStringSubmatch(strings.TrimSpace(value))
+	if match == nil {
+		return ""
+	}
+	return match[1]
+}
+
+// ResolveSecret returns the value of the secret a value references, or the
+// value itself when it doesn't reference one
+func ResolveSecret(value string) (string, error) {
+	name := SecretReference(value)
+	if name == "" {
+		return value, nil
+	}
+
+	secret, ok := os.LookupEnv(SecretPrefix + name)
+	if !ok {
+		return "", fmt.Errorf("Secret %s is not set", name)
+	}
+	return secret, nil
+}
+
+// SecretFields returns the credentials of a connection that can reference
+// secrets: its username, password and connection URI, TLS certificates and
+// key, and SSH tunnel credentials
+func (db *Database) SecretFields() []*string {
+	fields := []*string{&db.Username, &db.Password, &db.ConnectionURI}
+	if db.TLS != nil {
+		fields = append(fields, &db.TLS.CACert, &db.TLS.ClientCert, &db.TLS.ClientKey)
+	}
+	if db.SSHTunnel != nil {
+		fields = append(fields, &db.SSHTunnel.Username, &db.SSHTunnel.Password, &db.SSHTunnel.PrivateKey, &db.SSHTunnel.Passphrase)
+	}
+	return fields
+}
+
+// withSecrets returns a copy of the connection with the secrets it references
+// resolved, for connecting. Only the references are stored, so the values
+// never reach the database or API responses. Connections without references
+// are returned as they are.
+func (db *Database) withSecrets() (*Database, error) {
+	if !db.SecretReferences {
+		return db, nil
+	}
+
+	resolved := *db
+	if db.TLS != nil {
+		tls := *db.TLS
+		resolved.TLS = &tls
+	}
+	if db.SSHTunnel != nil {
+		sshTunnel := *db.SSHTunnel
+		resolved.SSHTunnel = &sshTunnel
+	}
+
+	for _, field := range resolved.SecretFields() {
+		value, err := ResolveSecret(*field)
+		if err != nil {
+			return nil, err
+		}
+		*field = value
+	}
+	return &resolved, nil
+}
@@ -0,0 +1,175 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// sessionTouchInterval is how often a session's last seen time is updated
+const sessionTouchInterval = time.Minute
+
+// Session is a signed-in device. Every issued token belongs to a session, and
+// revoking the session revokes the token.
+type Session struct {
+	ID         primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	UserID     primitive.ObjectID `json:"user_id" bson:"user_id"`
+	UserAgent  string             `json:"user_agent" bson:"user_agent"`
+	IP         string             `json:"ip" bson:"ip"`
+	CreatedAt  time.Time          `json:"created_at" bson:"created_at"`
+	LastSeenAt time.Time          `json:"last_seen_at" bson:"last_seen_at"`
+	ExpiresAt  time.Time          `json:"expires_at" bson:"expires_at"`
+	RevokedAt  *time.Time         `json:"revoked_at,omitempty" bson:"revoked_at,omitempty"`
+	Demo       bool               `json:"demo,omitempty" bson:"demo,omitempty"` // Anonymous demo sessions are read-only
+	Current    bool               `json:"current" bson:"-"`                     // Whether the session made the request
+}
+
+// sessionCollection returns the sessions collection
+func (s *mongoStore) sessionCollection() *mongo.Collection {
+	return s.db.Collection("sessions")
+}
+
+// ensureSessionIndexes creates the indexes for looking up sessions and removing
+// them once their tokens have expired
+func (s *mongoStore) ensureSessionIndexes(ctx context.Context) error {
+	_, err := s.sessionCollection().Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "user_id", Value: 1}, {Key: "last_seen_at", Value: -1}},
+			Options: options.Index().SetName("session_user"),
+		},
+		{
+			Keys:    bson.D{{Key: "expires_at", Value: 1}},
+			Options: options.Index().SetName("session_expiry").SetExpireAfterSeconds(0),
+		},
+	})
+	return err
+}
+
+// CreateSession records a new session for a user
+func (s *mongoStore) CreateSession(ctx context.Context, userID primitive.ObjectID, userAgent, ip string, expiry time.Duration) (*Session, error) {
+	return s.insertSession(ctx, newSession(userID, userAgent, ip, expiry))
+}
+
+// CreateDemoSession records a new read-only session for a demo user
+func (s *mongoStore) CreateDemoSession(ctx context.Context, userID primitive.ObjectID, userAgent, ip string, expiry time.Duration) (*Session, error) {
+	session := newSession(userID, userAgent, ip, expiry)
+	session.Demo = true
+	return s.insertSession(ctx, session)
+}
+
+// newSession returns a session starting now
+func newSession(userID primitive.ObjectID, userAgent, ip string, expiry time.Duration) *Session {
+	now := time.Now()
+	return &Session{
+		UserID:     userID,
+		UserAgent:  userAgent,
+		IP:         ip,
+		CreatedAt:  now,
+		LastSeenAt: now,
+		ExpiresAt:  now.Add(expiry),
+	}
+}
+
+// insertSession saves a new session
+func (s *mongoStore) insertSession(ctx context.Context, session *Session) (*Session, error) {
+	result, err := s.sessionCollection().InsertOne(ctx, session)
+	if err != nil {
+		return nil, err
+	}
+
+	// Set the ID
+	session.ID = result.InsertedID.(primitive.ObjectID)
+
+	return session, nil
+}
+
+// GetActiveSession retrieves a session that hasn't been revoked or expired
+func (s *mongoStore) GetActiveSession(ctx context.Context, id primitive.ObjectID) (*Session, error) {
+	var session Session
+	err := s.sessionCollection().FindOne(ctx, activeSessionFilter(bson.M{"_id": id})).Decode(&session)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &session, nil
+}
+
+// GetActiveSessionsByUserID retrieves a user's active sessions, most recently used first
+func (s *mongoStore) GetActiveSessionsByUserID(ctx context.Context, userID primitive.ObjectID) ([]*Session, error) {
+	opts := options.Find().SetSort(bson.M{"last_seen_at": -1})
+
+	cursor, err := s.sessionCollection().Find(ctx, activeSessionFilter(bson.M{"user_id": userID}), opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	sessions := []*Session{}
+	if err := cursor.All(ctx, &sessions); err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+// TouchSession records that a session was used from an IP. Writes are skipped
+// while the last seen time is recent.
+func (s *mongoStore) TouchSession(ctx context.Context, session *Session, ip string) error {
+	now := time.Now()
+	if now.Sub(session.LastSeenAt) < sessionTouchInterval && session.IP == ip {
+		return nil
+	}
+
+	_, err := s.sessionCollection().UpdateOne(
+		ctx,
+		bson.M{"_id": session.ID},
+		bson.M{"$set": bson.M{"last_seen_at": now, "ip": ip}},
+	)
+	return err
+}
+
+// RevokeSession revokes one of a user's sessions. It reports false when the
+// user has no such active session.
+func (s *mongoStore) RevokeSession(ctx context.Context, id, userID primitive.ObjectID) (bool, error) {
+	result, err := s.sessionCollection().UpdateOne(
+		ctx,
+		activeSessionFilter(bson.M{"_id": id, "user_id": userID}),
+		bson.M{"$set": bson.M{"revoked_at": time.Now()}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return result.ModifiedCount > 0, nil
+}
+
+// RevokeUserSessions revokes all of a user's sessions except the given one,
+// which may be zero to sign out everywhere
+func (s *mongoStore) RevokeUserSessions(ctx context.Context, userID, except primitive.ObjectID) (int64, error) {
+	filter := activeSessionFilter(bson.M{"user_id": userID})
+	if !except.IsZero() {
+		filter["_id"] = bson.M{"$ne": except}
+	}
+
+	result, err := s.sessionCollection().UpdateMany(
+		ctx,
+		filter,
+		bson.M{"$set": bson.M{"revoked_at": time.Now()}},
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.ModifiedCount, nil
+}
+
+// activeSessionFilter narrows a filter to sessions that can still be used
+func activeSessionFilter(filter bson.M) bson.M {
+	filter["revoked_at"] = bson.M{"$exists": false}
+	filter["expires_at"] = bson.M{"$gt": time.Now()}
+	return filter
+}
@@ -0,0 +1,225 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/x/mongo/driver"
+)
+
+// maxSnapshotSize is how large a snapshot document may get, leaving room
+// below MongoDB's 16MB document limit
+const maxSnapshotSize = 15 << 20
+
+// ErrSnapshotTooLarge is returned when a snapshot doesn't fit in a document
+// even without any card results
+var ErrSnapshotTooLarge = errors.New("the dashboard is too large to snapshot")
+
+// SnapshotCard is the frozen state of a dashboard card inside a snapshot
+type SnapshotCard struct {
+	CardID    primitive.ObjectID `json:"card_id" bson:"card_id"`
+	Title     string             `json:"title" bson:"title"`
+	Type      CardType           `json:"type" bson:"type"`
+	ChartType ChartType          `json:"chart_type,omitempty" bson:"chart_type,omitempty"`
+	Position  CardPosition       `json:"position" bson:"position"`
+	QueryID   primitive.ObjectID `json:"query_id,omitempty" bson:"query_id,omitempty"`
+	QueryName string             `json:"query_name,omitempty" bson:"query_name,omitempty"`
+	Columns   []ResultColumn     `json:"columns,omitempty" bson:"columns,omitempty"`
+	Results   []QueryResult      `json:"results" bson:"results"`
+	Truncated bool               `json:"truncated,omitempty" bson:"truncated,omitempty"` // Rows were left out to fit the snapshot in a document
+	Error     string             `json:"error,omitempty" bson:"error,omitempty"`
+	DataAsOf  time.Time          `json:"data_as_of,omitempty" bson:"data_as_of,omitempty"` // When the card's data was produced
+	Content   string             `json:"content,omitempty" bson:"content,omitempty"`       // Markdown for text cards
+	URL       string             `json:"url,omitempty" bson:"url,omitempty"`               // Embedded content for embed cards
+	Options   *CardOptions       `json:"options,omitempty" bson:"options,omitempty"`
+	Transform *ChartTransform    `json:"transform,omitempty" bson:"transform,omitempty"`
+}
+
+// DashboardSnapshot is an immutable point-in-time copy of a dashboard and its card data
+type DashboardSnapshot struct {
+	ID          primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	DashboardID primitive.ObjectID `json:"dashboard_id" bson:"dashboard_id"`
+	UserID      primitive.ObjectID `json:"user_id" bson:"user_id"`
+	Name        string             `json:"name" bson:"name"`
+	Description string             `json:"description,omitempty" bson:"description,omitempty"`
+	Cards       []SnapshotCard     `json:"cards" bson:"cards"`
+	CreatedAt   time.Time          `json:"created_at" bson:"created_at"`
+}
+
+// snapshotCollection returns the dashboard snapshots collection
+func (s *mongoStore) snapshotCollection() *mongo.Collection {
+	return s.db.Collection("dashboard_snapshots")
+}
+
+// ensureSnapshotIndexes indexes snapshots by their dashboard, newest first
+func (s *mongoStore) ensureSnapshotIndexes(ctx context.Context) error {
+	_, err := s.snapshotCollection().Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "dashboard_id", Value: 1}, {Key: "created_at", Value: -1}},
+			Options: options.Index().SetName("snapshot_dashboard"),
+		},
+	})
+	return err
+}
+
+// CaptureDashboardSnapshot freezes the current data of every card on a dashboard.
+// Cards use their cached refresh data when available, otherwise the last stored
+// results of their query.
+func (s *mongoStore) CaptureDashboardSnapshot(ctx context.Context, dashboard *Dashboard, name string) (*DashboardSnapshot, error) {
+	now := time.Now()
+	if name == "" {
+		name = dashboard.Name + " - " + now.Format("2006-01-02 15:04")
+	}
+
+	snapshot := &DashboardSnapshot{
+		DashboardID: dashboard.ID,
+		UserID:      dashboard.UserID,
+		Name:        name,
+		Description: dashboard.Description,
+		Cards:       make([]SnapshotCard, 0, len(dashboard.Cards)),
+		CreatedAt:   now,
+	}
+
+	for _, card := range dashboard.Cards {
+		snapshotCard := SnapshotCard{
+			CardID:    card.ID,
+			Title:     card.Title,
+			Type:      card.Type,
+			ChartType: card.ChartType,
+			Position:  card.Position,
+			QueryID:   card.QueryID,
+			Results:   []QueryResult{},
+			Content:   card.Content,
+			URL:       card.URL,
+			Options:   card.Options,
+			Transform: card.Transform,
+		}
+
+		if !card.QueryID.IsZero() {
+			if err := s.fillSnapshotCard(ctx, dashboard.ID, card, &snapshotCard); err != nil {
+				return nil, err
+			}
+		}
+
+		snapshot.Cards = append(snapshot.Cards, snapshotCard)
+	}
+
+	if err := fitSnapshot(snapshot); err != nil {
+		return nil, err
+	}
+
+	result, err := s.snapshotCollection().InsertOne(ctx, snapshot)
+	if err != nil {
+		if errors.Is(err, driver.ErrDocumentTooLarge) {
+			return nil, ErrSnapshotTooLarge
+		}
+		return nil, err
+	}
+
+	// Set the ID
+	snapshot.ID = result.InsertedID.(primitive.ObjectID)
+
+	return snapshot, nil
+}
+
+// fitSnapshot halves the results of the card with the most rows until the
+// snapshot fits in maxSnapshotSize, marking the cards it cut short
+func fitSnapshot(snapshot *DashboardSnapshot) error {
+	for {
+		doc, err := bson.Marshal(snapshot)
+		if err != nil {
+			return err
+		}
+		if len(doc) <= maxSnapshotSize {
+			return nil
+		}
+
+		largest := -1
+		for i, card := range snapshot.Cards {
+			if len(card.Results) > 0 && (largest < 0 || len(card.Results) > len(snapshot.Cards[largest].Results)) {
+				largest = i
+			}
+		}
+		if largest < 0 {
+			return ErrSnapshotTooLarge
+		}
+
+		card := &snapshot.Cards[largest]
+		card.Results = card.Results[:len(card.Results)/2]
+		card.Truncated = true
+	}
+}
+
+// fillSnapshotCard copies the current data for a card into its snapshot entry
+func (s *mongoStore) fillSnapshotCard(ctx context.Context, dashboardID primitive.ObjectID, card DashboardCard, snapshotCard *SnapshotCard) error {
+	query, err := s.GetQueryByID(ctx, card.QueryID)
+	if err != nil {
+		return err
+	}
+	if query == nil {
+		snapshotCard.Error = "Query not found"
+		return nil
+	}
+	snapshotCard.QueryName = query.Name
+
+	data, err := s.GetCardData(ctx, dashboardID, card.ID)
+	if err != nil {
+		return err
+	}
+
+	if data != nil && !data.RefreshedAt.IsZero() {
+		snapshotCard.Columns = data.Columns
+		snapshotCard.Results = data.Results
+		snapshotCard.Error = data.Error
+		snapshotCard.DataAsOf = data.RefreshedAt
+	} else {
+		snapshotCard.Columns = query.Columns
+		snapshotCard.Results = query.Results
+		snapshotCard.Error = query.Error
+		snapshotCard.DataAsOf = query.UpdatedAt
+	}
+
+	if snapshotCard.Results == nil {
+		snapshotCard.Results = []QueryResult{}
+	}
+
+	return nil
+}
+
+// GetSnapshotByID retrieves a snapshot by ID
+func (s *mongoStore) GetSnapshotByID(ctx context.Context, id primitive.ObjectID) (*DashboardSnapshot, error) {
+	var snapshot DashboardSnapshot
+	err := s.snapshotCollection().FindOne(ctx, bson.M{"_id": id}).Decode(&snapshot)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &snapshot, nil
+}
+
+// GetSnapshotsByDashboardID lists the snapshots of a dashboard without their card results
+func (s *mongoStore) GetSnapshotsByDashboardID(ctx context.Context, dashboardID primitive.ObjectID) ([]*DashboardSnapshot, error) {
+	opts := options.Find().
+		SetSort(bson.M{"created_at": -1}).
+		SetProjection(bson.M{"cards.results": 0, "cards.columns": 0})
+
+	cursor, err := s.snapshotCollection().Find(ctx, bson.M{"dashboard_id": dashboardID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	snapshots := []*DashboardSnapshot{}
+	if err := cursor.All(ctx, &snapshots); err != nil {
+		return nil, err
+	}
+
+	return snapshots, nil
+}
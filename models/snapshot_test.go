@@ -0,0 +1,57 @@
+package models
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestFitSnapshot(t *testing.T) {
+	rows := func(n int) []QueryResult {
+		results := make([]QueryResult, n)
+		for i := range results {
+			results[i] = QueryResult{"note": strings.Repeat("x", 1000)}
+		}
+		return results
+	}
+
+	t.Run("small", func(t *testing.T) {
+		snapshot := &DashboardSnapshot{Cards: []SnapshotCard{{Results: rows(10)}}}
+		if err := fitSnapshot(snapshot); err != nil {
+			t.Fatalf("fitSnapshot() = %v", err)
+		}
+		if card := snapshot.Cards[0]; len(card.Results) != 10 || card.Truncated {
+			t.Errorf("fitSnapshot() kept %d rows, truncated %v, want 10 rows untouched", len(card.Results), card.Truncated)
+		}
+	})
+
+	t.Run("too large", func(t *testing.T) {
+		snapshot := &DashboardSnapshot{Cards: []SnapshotCard{{Results: rows(10)}, {Results: rows(20000)}}}
+		if err := fitSnapshot(snapshot); err != nil {
+			t.Fatalf("fitSnapshot() = %v", err)
+		}
+
+		doc, err := bson.Marshal(snapshot)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(doc) > maxSnapshotSize {
+			t.Errorf("fitSnapshot() left %d bytes, want at most %d", len(doc), maxSnapshotSize)
+		}
+		if card := snapshot.Cards[0]; len(card.Results) != 10 || card.Truncated {
+			t.Errorf("fitSnapshot() cut the small card to %d rows, want it untouched", len(card.Results))
+		}
+		if card := snapshot.Cards[1]; len(card.Results) == 0 || len(card.Results) >= 20000 || !card.Truncated {
+			t.Errorf("fitSnapshot() kept %d rows of the large card, truncated %v, want some rows and truncated", len(card.Results), card.Truncated)
+		}
+	})
+
+	t.Run("too large without results", func(t *testing.T) {
+		snapshot := &DashboardSnapshot{Cards: []SnapshotCard{{Content: strings.Repeat("x", maxSnapshotSize)}}}
+		if err := fitSnapshot(snapshot); !errors.Is(err, ErrSnapshotTooLarge) {
+			t.Errorf("fitSnapshot() = %v, want ErrSnapshotTooLarge", err)
+		}
+	})
+}
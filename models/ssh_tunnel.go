@@ -0,0 +1,136 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/zucced/goquery/tunnel"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// SSHTunnels holds the ssh connections database connections are dialed through
+var SSHTunnels = tunnel.NewManager(15 * time.Second)
+
+// SSHTunnel is the bastion host a database connection is reached through
+type SSHTunnel struct {
+	Host       string `json:"host" bson:"host"`
+	Port       string `json:"port,omitempty" bson:"port,omitempty"`
+	Username   string `json:"username" bson:"username"`
+	Password   string `json:"-" bson:"password,omitempty"`
+	PrivateKey string `json:"-" bson:"private_key,omitempty"`
+	Passphrase string `json:"-" bson:"passphrase,omitempty"`
+	HostKey    string `json:"host_key,omitempty" bson:"host_key,omitempty"` // Pinned public key in authorized_keys format
+}
+
+// Config converts the tunnel to the tunnel manager's config
+func (t *SSHTunnel) Config() tunnel.Config {
+	return tunnel.Config{
+		Host:       t.Host,
+		Port:       t.Port,
+		User:       t.Username,
+		Password:   t.Password,
+		PrivateKey: t.PrivateKey,
+		Passphrase: t.Passphrase,
+		HostKey:    t.HostKey,
+	}
+}
+
+// Validate checks the tunnel settings
+func (t *SSHTunnel) Validate() error {
+	return t.Config().Validate()
+}
+
+// PinSSHHostKey pins the key the database's bastion host presents when the
+// tunnel doesn't have one yet, trusting it on first use. Connections are only
+// made to pinned hosts, so it's called when a connection is created or changed,
+// and by the host key pinner for connections saved before keys were pinned.
+func PinSSHHostKey(ctx context.Context, db *Database) error {
+	if db.SSHTunnel == nil || db.SSHTunnel.HostKey != "" {
+		return nil
+	}
+	resolved, err := db.withSecrets()
+	if err != nil {
+		return err
+	}
+	hostKey, err := SSHTunnels.ScanHostKey(ctx, resolved.SSHTunnel.Config())
+	if err != nil {
+		return fmt.Errorf("failed to read the ssh host key: %v", err)
+	}
+	db.SSHTunnel.HostKey = hostKey
+	return nil
+}
+
+// GetDatabasesWithUnpinnedSSHHostKey retrieves the connections reached through
+// an ssh tunnel that has no pinned host key, without their schema and stats
+func (s *mongoStore) GetDatabasesWithUnpinnedSSHHostKey(ctx context.Context) ([]*Database, error) {
+	cursor, err := s.databaseCollection().Find(
+		ctx,
+		bson.M{
+			"ssh_tunnel":          bson.M{"$type": "object"},
+			"ssh_tunnel.host_key": bson.M{"$in": bson.A{nil, ""}},
+		},
+		options.Find().SetProjection(bson.M{"schema": 0, "stats": 0}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var databases []*Database
+	if err := cursor.All(ctx, &databases); err != nil {
+		return nil, err
+	}
+
+	return databases, nil
+}
+
+// SetSSHHostKey pins the host key of a connection's ssh tunnel, unless one
+// has been pinned since it was read
+func (s *mongoStore) SetSSHHostKey(ctx context.Context, id primitive.ObjectID, hostKey string) error {
+	_, err := s.databaseCollection().UpdateOne(
+		ctx,
+		bson.M{"_id": id, "ssh_tunnel.host_key": bson.M{"$in": bson.A{nil, ""}}},
+		bson.M{"$set": bson.M{"ssh_tunnel.host_key": hostKey}},
+	)
+	return err
+}
+
+// CheckSSHTunnel reports whether the database's bastion host is reachable.
+// It returns nil when the database isn't reached through a tunnel.
+func CheckSSHTunnel(ctx context.Context, db *Database) *tunnel.Health {
+	if db.SSHTunnel == nil {
+		return nil
+	}
+	resolved, err := db.withSecrets()
+	if err != nil {
+		return &tunnel.Health{Status: "error", Error: err.Error()}
+	}
+	health := SSHTunnels.Check(ctx, resolved.SSHTunnel.Config())
+	return &health
+}
+
+// sshDialer adapts the tunnel manager to the PostgreSQL driver's dialer
+type sshDialer struct {
+	dial func(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+// Dial opens a connection through the tunnel
+func (d sshDialer) Dial(network, address string) (net.Conn, error) {
+	return d.dial(context.Background(), network, address)
+}
+
+// DialTimeout opens a connection through the tunnel, giving up after timeout
+func (d sshDialer) DialTimeout(network, address string, timeout time.Duration) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return d.dial(ctx, network, address)
+}
+
+// DialContext opens a connection through the tunnel
+func (d sshDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	return d.dial(ctx, network, address)
+}
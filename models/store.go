@@ -0,0 +1,488 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/zucced/goquery/limiter"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Store reads and writes the documents of every collection. Handlers and
+// workers are given a Store instead of reaching for a global connection, so
+// tests can substitute a fake for the parts they exercise.
+type Store interface {
+	UserStore
+	SessionStore
+	PasswordResetStore
+	OrganizationStore
+	WorkspaceStore
+	QueryTemplateStore
+	DatabaseStore
+	QueryStore
+	MaterializationStore
+	QueryApprovalStore
+	LineageStore
+	SearchStore
+	SuggestionStore
+	DashboardStore
+	CardDataStore
+	SnapshotStore
+	DownloadLinkStore
+	ReportScheduleStore
+	JobStore
+	FeatureFlagStore
+	AllowedOriginStore
+	WebhookStore
+	AlertStore
+	NotificationChannelStore
+	NotificationStore
+	AuditStore
+	ExecutionMetricStore
+	QueryRunStore
+	QueryCommentStore
+	DashboardCommentStore
+	FreshnessCheckStore
+	TableProfileStore
+	GoogleSheetsStore
+	MetadataStore
+
+	// EnsureIndexes creates the indexes of every collection. A collection
+	// whose indexes can't be created, e.g. because existing users share an
+	// email, doesn't keep the others from getting theirs.
+	EnsureIndexes(ctx context.Context) error
+}
+
+// UserStore manages users, their preferences and demo accounts
+type UserStore interface {
+	CreateUser(ctx context.Context, email, password, name string) (*User, error)
+	GetUserByEmail(ctx context.Context, email string) (*User, error)
+	GetUserByID(ctx context.Context, id primitive.ObjectID) (*User, error)
+	UpdateUser(ctx context.Context, user *User) error
+	UpdatePassword(ctx context.Context, userID primitive.ObjectID, password string) error
+	GetUserPreferences(ctx context.Context, userID primitive.ObjectID) (*UserPreferences, error)
+	UpdateUserPreferences(ctx context.Context, userID primitive.ObjectID, preferences *UserPreferences) error
+	CreateDemoUser(ctx context.Context, expiresAt time.Time) (*User, error)
+	PurgeExpiredDemoUsers(ctx context.Context, now time.Time) (int64, error)
+}
+
+// SessionStore manages login sessions
+type SessionStore interface {
+	CreateSession(ctx context.Context, userID primitive.ObjectID, userAgent, ip string, expiry time.Duration) (*Session, error)
+	CreateDemoSession(ctx context.Context, userID primitive.ObjectID, userAgent, ip string, expiry time.Duration) (*Session, error)
+	GetActiveSession(ctx context.Context, id primitive.ObjectID) (*Session, error)
+	GetActiveSessionsByUserID(ctx context.Context, userID primitive.ObjectID) ([]*Session, error)
+	TouchSession(ctx context.Context, session *Session, ip string) error
+	RevokeSession(ctx context.Context, id, userID primitive.ObjectID) (bool, error)
+	RevokeUserSessions(ctx context.Context, userID, except primitive.ObjectID) (int64, error)
+}
+
+// PasswordResetStore manages password reset tokens
+type PasswordResetStore interface {
+	CreatePasswordResetToken(ctx context.Context, userID primitive.ObjectID, expiry time.Duration) (string, error)
+	ConsumePasswordResetToken(ctx context.Context, token string) (*PasswordResetToken, error)
+	InvalidatePasswordResetTokens(ctx context.Context, userID primitive.ObjectID) error
+	CountPasswordResetsSince(ctx context.Context, userID primitive.ObjectID, since time.Time) (int64, error)
+}
+
+// OrganizationStore manages organizations, their members and single sign-on,
+// and which organization resources belong to
+type OrganizationStore interface {
+	CreateOrganization(ctx context.Context, name string, owner *User) (*Organization, error)
+	GetOrganizationByID(ctx context.Context, id primitive.ObjectID) (*Organization, error)
+	GetOrganizationsByUserID(ctx context.Context, userID primitive.ObjectID) ([]*Organization, error)
+	GetOrganizationIDsByUserID(ctx context.Context, userID primitive.ObjectID) ([]primitive.ObjectID, error)
+	IsOrganizationMember(ctx context.Context, orgID, userID primitive.ObjectID) (bool, error)
+	UpdateOrganization(ctx context.Context, org *Organization) error
+	DeleteOrganization(ctx context.Context, id primitive.ObjectID) error
+	AddOrganizationMember(ctx context.Context, orgID primitive.ObjectID, member OrganizationMember) error
+	UpdateOrganizationMemberRole(ctx context.Context, orgID, userID primitive.ObjectID, role OrgRole) error
+	RemoveOrganizationMember(ctx context.Context, orgID, userID primitive.ObjectID) error
+	SetDatabaseOrganization(ctx context.Context, id, orgID, workspaceID primitive.ObjectID) error
+	SetQueryOrganization(ctx context.Context, id, orgID, workspaceID primitive.ObjectID) error
+	SetDashboardOrganization(ctx context.Context, id, orgID, workspaceID primitive.ObjectID) error
+	CanAccessDatabase(ctx context.Context, db *Database, userID primitive.ObjectID) (bool, error)
+	CanAccessQuery(ctx context.Context, query *Query, userID primitive.ObjectID) (bool, error)
+	SetOrganizationSSO(ctx context.Context, orgID primitive.ObjectID, sso *SSOConfig) error
+	GetOrganizationBySSODomain(ctx context.Context, domain string) (*Organization, error)
+	SSODomainsClaimed(ctx context.Context, orgID primitive.ObjectID, domains []string) ([]string, error)
+	VerifySSODomains(ctx context.Context, orgID primitive.ObjectID, domains []string) error
+	RequiresSSO(ctx context.Context, user *User) (bool, error)
+}
+
+// QueryTemplateStore manages the query template libraries of organizations
+type QueryTemplateStore interface {
+	CreateQueryTemplate(ctx context.Context, template *QueryTemplate) (*QueryTemplate, error)
+	GetQueryTemplateByID(ctx context.Context, id primitive.ObjectID) (*QueryTemplate, error)
+	GetQueryTemplatesByOrgID(ctx context.Context, orgID primitive.ObjectID) ([]*QueryTemplate, error)
+	UpdateQueryTemplate(ctx context.Context, template *QueryTemplate) error
+	CountQueryTemplateUse(ctx context.Context, id primitive.ObjectID) error
+	DeleteQueryTemplate(ctx context.Context, id primitive.ObjectID) error
+}
+
+// WorkspaceStore manages the workspaces of organizations
+type WorkspaceStore interface {
+	CreateWorkspace(ctx context.Context, workspace *Workspace) (*Workspace, error)
+	GetWorkspaceByID(ctx context.Context, id primitive.ObjectID) (*Workspace, error)
+	GetWorkspacesByOrgID(ctx context.Context, orgID primitive.ObjectID) ([]*Workspace, error)
+	UpdateWorkspace(ctx context.Context, workspace *Workspace) error
+	DeleteWorkspace(ctx context.Context, id primitive.ObjectID) error
+}
+
+// DatabaseStore manages database connections, their schemas, health and
+// access
+type DatabaseStore interface {
+	CreateDatabase(ctx context.Context, db *Database) (*Database, error)
+	GetDatabaseByID(ctx context.Context, id primitive.ObjectID) (*Database, error)
+	GetDatabasesByUserID(ctx context.Context, userID, workspaceID primitive.ObjectID) ([]*Database, error)
+	UpdateDatabase(ctx context.Context, db *Database) error
+	DeleteDatabase(ctx context.Context, id primitive.ObjectID) error
+	UpdateLastConnected(ctx context.Context, id primitive.ObjectID) error
+	ResolveDatabaseAccess(ctx context.Context, db *Database, userID primitive.ObjectID) (DatabaseAccess, error)
+	SetDatabaseMembers(ctx context.Context, id primitive.ObjectID, memberIDs []primitive.ObjectID) error
+	SetColumnAnnotations(ctx context.Context, id primitive.ObjectID, annotations []ColumnAnnotation) error
+	SetDatabaseMasking(ctx context.Context, db *Database, rules []MaskingRule, unmaskedUsers []primitive.ObjectID) error
+	NewResultMasker(viewer primitive.ObjectID) *ResultMasker
+	GetDatabasesWithSchemaRefresh(ctx context.Context) ([]*Database, error)
+	MarkSchemaRefreshPending(ctx context.Context, id primitive.ObjectID) error
+	RefreshDatabaseSchema(ctx context.Context, id primitive.ObjectID) (*SchemaChange, error)
+	GetDatabasesForHealthCheck(ctx context.Context) ([]*Database, error)
+	UpdateConnectionHealth(ctx context.Context, id primitive.ObjectID, health ConnectionHealth) error
+	GetDatabasesWithUnpinnedSSHHostKey(ctx context.Context) ([]*Database, error)
+	SetSSHHostKey(ctx context.Context, id primitive.ObjectID, hostKey string) error
+}
+
+// QueryStore manages saved queries
+type QueryStore interface {
+	CreateQuery(ctx context.Context, query *Query) (*Query, error)
+	GetQueryByID(ctx context.Context, id primitive.ObjectID) (*Query, error)
+	GetQueriesByUserID(ctx context.Context, userID primitive.ObjectID, page, limit int64, queryFilter QueryFilter) ([]*Query, int64, error)
+	GetQueriesByDatabaseID(ctx context.Context, databaseID, userID primitive.ObjectID, page, limit int64) ([]*Query, int64, error)
+	UpdateQuery(ctx context.Context, query *Query) error
+	SetQueryTags(ctx context.Context, id primitive.ObjectID, tags []string) error
+	DeleteQuery(ctx context.Context, id primitive.ObjectID) error
+	GetTrashedQueryByID(ctx context.Context, id primitive.ObjectID) (*Query, error)
+	GetTrashedQueriesByUserID(ctx context.Context, userID primitive.ObjectID) ([]*Query, error)
+	RestoreQuery(ctx context.Context, id primitive.ObjectID) error
+	PurgeDeletedQueries(ctx context.Context, cutoff time.Time) (int64, error)
+	PurgeQueryResults(ctx context.Context, cutoff time.Time) (int64, error)
+	FailRunningQueries(ctx context.Context, reason string) (int64, error)
+	LoadFederatedTables(ctx context.Context, sources []FederatedSource, userID primitive.ObjectID) ([]FederatedTable, error)
+}
+
+// MaterializationStore manages the scheduled refreshes of materialized queries
+type MaterializationStore interface {
+	SetQueryMaterialization(ctx context.Context, query *Query, cron, timezone string) error
+	UnmaterializeQuery(ctx context.Context, id primitive.ObjectID) error
+	GetQueryMaterialization(ctx context.Context, id primitive.ObjectID) (*Materialization, error)
+	GetDueMaterializedQueries(ctx context.Context, now time.Time) ([]*Query, error)
+	ClaimMaterialization(ctx context.Context, query *Query, now time.Time) (bool, error)
+	SaveMaterializedResults(ctx context.Context, query *Query, results []QueryResult, columns []ResultColumn, executionTime string, now time.Time) error
+	RecordMaterializationFailure(ctx context.Context, query *Query, refreshErr error) error
+}
+
+// QueryApprovalStore manages the approvals queries against production
+// connections wait for
+type QueryApprovalStore interface {
+	DecideQueryApproval(ctx context.Context, query *Query, reviewerID primitive.ObjectID, approved bool, reason string) (bool, error)
+	GetPendingApprovals(ctx context.Context, orgIDs []primitive.ObjectID, userID primitive.ObjectID) ([]*Query, error)
+}
+
+// LineageStore traces what depends on connections, queries and dashboards
+type LineageStore interface {
+	GetLineage(ctx context.Context, userID primitive.ObjectID) (*Lineage, error)
+	GetDatabaseImpact(ctx context.Context, databaseID primitive.ObjectID) (*LineageImpact, error)
+}
+
+// SearchStore finds queries, dashboards, tables and columns across the user's
+// access
+type SearchStore interface {
+	Search(ctx context.Context, userID, workspaceID primitive.ObjectID, text string, limit int) ([]*SearchResult, error)
+}
+
+// SuggestionStore looks up the questions asked of a database
+type SuggestionStore interface {
+	GetQuestionHistory(ctx context.Context, databaseID, userID primitive.ObjectID, prefix string, limit int) ([]*QuestionSuggestion, error)
+}
+
+// DashboardStore manages dashboards, their cards and collaborators
+type DashboardStore interface {
+	CreateDashboard(ctx context.Context, dashboard *Dashboard) (*Dashboard, error)
+	GetDashboardByID(ctx context.Context, id primitive.ObjectID) (*Dashboard, error)
+	GetDashboardsWithRefreshingCards(ctx context.Context) ([]*Dashboard, error)
+	GetDashboardsByUserID(ctx context.Context, userID primitive.ObjectID, filter DashboardFilter) ([]*Dashboard, error)
+	UpdateDashboard(ctx context.Context, dashboard *Dashboard) error
+	SetDefaultDashboard(ctx context.Context, userID, dashboardID primitive.ObjectID) error
+	GetDefaultDashboard(ctx context.Context, userID primitive.ObjectID) (*Dashboard, error)
+	ArchiveDashboard(ctx context.Context, id primitive.ObjectID) error
+	UnarchiveDashboard(ctx context.Context, id primitive.ObjectID) error
+	StarDashboard(ctx context.Context, id, userID primitive.ObjectID) error
+	UnstarDashboard(ctx context.Context, id, userID primitive.ObjectID) error
+	DeleteDashboard(ctx context.Context, id primitive.ObjectID) error
+	GetTrashedDashboardByID(ctx context.Context, id primitive.ObjectID) (*Dashboard, error)
+	GetTrashedDashboardsByUserID(ctx context.Context, userID primitive.ObjectID) ([]*Dashboard, error)
+	RestoreDashboard(ctx context.Context, id primitive.ObjectID) error
+	PurgeDeletedDashboards(ctx context.Context, cutoff time.Time) (int64, error)
+	AddCardToDashboard(ctx context.Context, dashboardID primitive.ObjectID, card *DashboardCard) error
+	UpdateDashboardCard(ctx context.Context, dashboardID, cardID primitive.ObjectID, updates map[string]interface{}) error
+	DeleteDashboardCard(ctx context.Context, dashboardID, cardID primitive.ObjectID) error
+	UpdateCardPositions(ctx context.Context, dashboardID primitive.ObjectID, cardPositions map[primitive.ObjectID]CardPosition) error
+	ShareDashboard(ctx context.Context, dashboardID primitive.ObjectID, collaborator *DashboardCollaborator) error
+	UnshareDashboard(ctx context.Context, dashboardID, collaboratorID primitive.ObjectID) error
+	AcceptDashboardInvite(ctx context.Context, userID primitive.ObjectID, token string) (primitive.ObjectID, error)
+	ClaimDashboardInvites(ctx context.Context, userID primitive.ObjectID, email string) error
+}
+
+// CardDataStore runs dashboard cards and caches their results
+type CardDataStore interface {
+	GetCardData(ctx context.Context, dashboardID, cardID primitive.ObjectID) (*CardData, error)
+	SaveCardData(ctx context.Context, data *CardData) error
+	SaveCardDataFailure(ctx context.Context, data *CardData) error
+	RefreshCardData(ctx context.Context, execLimiter *limiter.ExecutionLimiter, dashboard *Dashboard, card *DashboardCard) (*CardData, error)
+	RunCardQuery(ctx context.Context, execLimiter *limiter.ExecutionLimiter, dashboard *Dashboard, card *DashboardCard, values map[string]interface{}) (*CardData, error)
+	DeleteCardData(ctx context.Context, dashboardID, cardID primitive.ObjectID) error
+}
+
+// SnapshotStore manages dashboard snapshots
+type SnapshotStore interface {
+	CaptureDashboardSnapshot(ctx context.Context, dashboard *Dashboard, name string) (*DashboardSnapshot, error)
+	GetSnapshotByID(ctx context.Context, id primitive.ObjectID) (*DashboardSnapshot, error)
+	GetSnapshotsByDashboardID(ctx context.Context, dashboardID primitive.ObjectID) ([]*DashboardSnapshot, error)
+}
+
+// DownloadLinkStore manages the single-use links exports are downloaded with
+type DownloadLinkStore interface {
+	CreateDownloadLink(ctx context.Context, link *DownloadLink) error
+	GetDownloadLink(ctx context.Context, id primitive.ObjectID) (*DownloadLink, error)
+	ConsumeDownloadLink(ctx context.Context, id primitive.ObjectID) (bool, error)
+}
+
+// ReportScheduleStore manages scheduled reports and their deliveries
+type ReportScheduleStore interface {
+	CreateReportSchedule(ctx context.Context, schedule *ReportSchedule) (*ReportSchedule, error)
+	GetReportScheduleByID(ctx context.Context, id primitive.ObjectID) (*ReportSchedule, error)
+	GetReportSchedulesByDashboardID(ctx context.Context, dashboardID primitive.ObjectID) ([]*ReportSchedule, error)
+	GetDueReportSchedules(ctx context.Context, now time.Time) ([]*ReportSchedule, error)
+	UpdateReportSchedule(ctx context.Context, schedule *ReportSchedule) error
+	ClaimReportSchedule(ctx context.Context, schedule *ReportSchedule, now time.Time) (bool, error)
+	DisableReportSchedule(ctx context.Context, id primitive.ObjectID) error
+	DeleteReportSchedule(ctx context.Context, id primitive.ObjectID) error
+	CreateReportDelivery(ctx context.Context, delivery *ReportDelivery) error
+	GetReportDeliveries(ctx context.Context, scheduleID primitive.ObjectID, limit int) ([]*ReportDelivery, error)
+}
+
+// JobStore manages background jobs
+type JobStore interface {
+	EnqueueJob(ctx context.Context, jobType string, payload interface{}, key string, runAt time.Time) (*Job, error)
+	GetJobByID(ctx context.Context, id primitive.ObjectID) (*Job, error)
+	GetJobs(ctx context.Context, status JobStatus, jobType string, limit int) ([]*Job, error)
+	ClaimJob(ctx context.Context, types []string, owner string, lease time.Duration) (*Job, error)
+	ExtendJobLease(ctx context.Context, job *Job, lease time.Duration) (bool, error)
+	CompleteJob(ctx context.Context, job *Job) error
+	FailJob(ctx context.Context, job *Job, jobErr error, retryAt time.Time) error
+	RetryJob(ctx context.Context, id primitive.ObjectID) (*Job, error)
+}
+
+// FeatureFlagStore manages feature flags
+type FeatureFlagStore interface {
+	GetFeatureFlags(ctx context.Context) ([]*FeatureFlag, error)
+	SaveFeatureFlag(ctx context.Context, flag *FeatureFlag) error
+}
+
+// AllowedOriginStore manages the origins allowed at runtime
+type AllowedOriginStore interface {
+	GetAllowedOrigins(ctx context.Context) ([]*AllowedOrigin, error)
+	SaveAllowedOrigin(ctx context.Context, origin *AllowedOrigin) error
+	DeleteAllowedOrigin(ctx context.Context, origin string) (bool, error)
+}
+
+// WebhookStore manages webhooks and their delivery logs
+type WebhookStore interface {
+	CreateWebhook(ctx context.Context, webhook *Webhook) (*Webhook, error)
+	GetWebhookByID(ctx context.Context, id primitive.ObjectID) (*Webhook, error)
+	GetWebhooksByUserID(ctx context.Context, userID primitive.ObjectID) ([]*Webhook, error)
+	GetWebhooksForEvent(ctx context.Context, userID primitive.ObjectID, event string) ([]*Webhook, error)
+	UpdateWebhook(ctx context.Context, webhook *Webhook) error
+	DeleteWebhook(ctx context.Context, id primitive.ObjectID) error
+	CreateWebhookDelivery(ctx context.Context, delivery *WebhookDelivery) error
+	GetWebhookDeliveryByID(ctx context.Context, id primitive.ObjectID) (*WebhookDelivery, error)
+	UpdateWebhookDelivery(ctx context.Context, delivery *WebhookDelivery) error
+	GetWebhookDeliveries(ctx context.Context, webhookID primitive.ObjectID, limit int) ([]*WebhookDelivery, error)
+}
+
+// AlertStore manages alerts on query results
+type AlertStore interface {
+	CreateAlert(ctx context.Context, alert *Alert) (*Alert, error)
+	GetAlertByID(ctx context.Context, id primitive.ObjectID) (*Alert, error)
+	GetAlertsByUserID(ctx context.Context, userID, queryID primitive.ObjectID) ([]*Alert, error)
+	GetEnabledAlertsByQueryID(ctx context.Context, queryID primitive.ObjectID) ([]*Alert, error)
+	UpdateAlert(ctx context.Context, alert *Alert, ruleChanged bool) error
+	SaveAlertEvaluation(ctx context.Context, alert *Alert, previous, notified AlertState) (bool, error)
+	SnoozeAlert(ctx context.Context, id primitive.ObjectID, until *time.Time) error
+	DeleteAlert(ctx context.Context, id primitive.ObjectID) error
+	CreateAlertSample(ctx context.Context, sample *AlertSample) error
+	GetAlertSamples(ctx context.Context, alertID primitive.ObjectID, limit int) ([]*AlertSample, error)
+	AcknowledgeAlert(ctx context.Context, alert *Alert, userID primitive.ObjectID) (bool, error)
+	ResolveAlert(ctx context.Context, alert *Alert) (bool, error)
+	CreateAlertEvent(ctx context.Context, event *AlertEvent) error
+	GetAlertEvents(ctx context.Context, alertID primitive.ObjectID, page, limit int64) ([]*AlertEvent, int64, error)
+}
+
+// NotificationChannelStore manages notification channels and their delivery
+// logs
+type NotificationChannelStore interface {
+	CreateNotificationChannel(ctx context.Context, channel *NotificationChannel) (*NotificationChannel, error)
+	GetNotificationChannelByID(ctx context.Context, id primitive.ObjectID) (*NotificationChannel, error)
+	GetNotificationChannelsByUserID(ctx context.Context, userID primitive.ObjectID, ids []primitive.ObjectID) ([]*NotificationChannel, error)
+	GetJobFailureChannels(ctx context.Context) ([]*NotificationChannel, error)
+	UpdateNotificationChannel(ctx context.Context, channel *NotificationChannel) error
+	DeleteNotificationChannel(ctx context.Context, id primitive.ObjectID) error
+	CreateChannelDelivery(ctx context.Context, delivery *ChannelDelivery) error
+	GetChannelDeliveryByID(ctx context.Context, id primitive.ObjectID) (*ChannelDelivery, error)
+	UpdateChannelDelivery(ctx context.Context, delivery *ChannelDelivery) error
+	GetChannelDeliveries(ctx context.Context, channelID primitive.ObjectID, limit int) ([]*ChannelDelivery, error)
+}
+
+// NotificationStore manages users' in-app notifications
+type NotificationStore interface {
+	CreateNotification(ctx context.Context, notification *Notification) error
+	GetNotifications(ctx context.Context, userID primitive.ObjectID, unreadOnly bool, limit int) ([]*Notification, error)
+	CountUnreadNotifications(ctx context.Context, userID primitive.ObjectID) (int64, error)
+	MarkNotificationsRead(ctx context.Context, userID primitive.ObjectID, ids []primitive.ObjectID) (int64, error)
+}
+
+// AuditStore manages the audit log
+type AuditStore interface {
+	CreateAuditEntry(ctx context.Context, entry *AuditEntry) error
+	GetAuditEntries(ctx context.Context, filter AuditFilter) ([]*AuditEntry, error)
+}
+
+// ExecutionMetricStore records query executions and summarizes them per
+// connection
+type ExecutionMetricStore interface {
+	RecordExecution(ctx context.Context, metric *ExecutionMetric) error
+	GetExecutionStats(ctx context.Context, databaseIDs []primitive.ObjectID, since time.Time) (map[primitive.ObjectID]*ExecutionStats, error)
+}
+
+// QueryRunStore records the history of scheduled query runs
+type QueryRunStore interface {
+	CreateQueryRun(ctx context.Context, run *QueryRun) error
+	SetQueryRunsSnapshot(ctx context.Context, ids []primitive.ObjectID, snapshotID primitive.ObjectID) error
+	GetQueryRun(ctx context.Context, queryID, id primitive.ObjectID) (*QueryRun, error)
+	GetQueryRuns(ctx context.Context, queryID primitive.ObjectID, page, limit int64) ([]*QueryRun, int64, error)
+}
+
+// QueryCommentStore manages the comments users leave on queries
+type QueryCommentStore interface {
+	CreateQueryComment(ctx context.Context, comment *QueryComment) error
+	GetQueryComment(ctx context.Context, queryID, id primitive.ObjectID) (*QueryComment, error)
+	GetQueryComments(ctx context.Context, queryID, runID primitive.ObjectID, page, limit int64) ([]*QueryComment, int64, error)
+	UpdateQueryCommentBody(ctx context.Context, comment *QueryComment, body string) error
+	DeleteQueryComment(ctx context.Context, id primitive.ObjectID) error
+}
+
+// DashboardCommentStore manages the comment threads on dashboards and their
+// cards
+type DashboardCommentStore interface {
+	CreateDashboardComment(ctx context.Context, comment *DashboardComment) error
+	GetDashboardComment(ctx context.Context, dashboardID, id primitive.ObjectID) (*DashboardComment, error)
+	GetDashboardCommentThreads(ctx context.Context, dashboardID primitive.ObjectID, commentFilter DashboardCommentFilter, page, limit int64) ([]*DashboardComment, int64, error)
+	UpdateDashboardCommentBody(ctx context.Context, comment *DashboardComment, body string, mentions []primitive.ObjectID) error
+	SetDashboardCommentResolved(ctx context.Context, comment *DashboardComment, userID primitive.ObjectID, resolved bool) error
+	DeleteDashboardComment(ctx context.Context, comment *DashboardComment) error
+}
+
+// FreshnessCheckStore manages the freshness expectations declared on tables
+// and the outcome of their probes
+type FreshnessCheckStore interface {
+	CreateFreshnessCheck(ctx context.Context, check *FreshnessCheck) (*FreshnessCheck, error)
+	GetFreshnessCheckByID(ctx context.Context, id primitive.ObjectID) (*FreshnessCheck, error)
+	GetFreshnessChecksByDatabaseID(ctx context.Context, databaseID primitive.ObjectID) ([]*FreshnessCheck, error)
+	GetDueFreshnessChecks(ctx context.Context, now time.Time) ([]*FreshnessCheck, error)
+	ClaimFreshnessCheck(ctx context.Context, check *FreshnessCheck, now time.Time) (bool, error)
+	SaveFreshnessResult(ctx context.Context, check *FreshnessCheck, previous FreshnessState) (bool, error)
+	UpdateFreshnessCheck(ctx context.Context, check *FreshnessCheck, targetChanged bool) error
+	DeleteFreshnessCheck(ctx context.Context, id primitive.ObjectID) error
+}
+
+// TableProfileStore manages the stored column statistics of tables
+type TableProfileStore interface {
+	SaveTableProfile(ctx context.Context, profile *TableProfile) error
+	GetTableProfile(ctx context.Context, databaseID primitive.ObjectID, table string) (*TableProfile, error)
+	GetTableProfiles(ctx context.Context, databaseID primitive.ObjectID) (map[string]*TableProfile, error)
+}
+
+// GoogleSheetsStore manages users' Google connections and the exports of
+// query results to their spreadsheets
+type GoogleSheetsStore interface {
+	SaveGoogleConnection(ctx context.Context, conn *GoogleConnection) error
+	GetGoogleConnection(ctx context.Context, userID primitive.ObjectID) (*GoogleConnection, error)
+	UpdateGoogleToken(ctx context.Context, conn *GoogleConnection) error
+	DeleteGoogleConnection(ctx context.Context, userID primitive.ObjectID) error
+	GetSheetExport(ctx context.Context, queryID, userID primitive.ObjectID) (*SheetExport, error)
+	SaveSheetExport(ctx context.Context, export *SheetExport) error
+	RecordSheetExport(ctx context.Context, export *SheetExport, rowCount int, exportErr error, now time.Time) error
+	GetDueSheetExports(ctx context.Context, now time.Time) ([]*SheetExport, error)
+	ClaimSheetExport(ctx context.Context, export *SheetExport, now time.Time) (bool, error)
+	UnscheduleSheetExport(ctx context.Context, id primitive.ObjectID) error
+	DeleteSheetExport(ctx context.Context, queryID, userID primitive.ObjectID) error
+}
+
+// MetadataStore exports and imports what users set up, to move or restore an
+// installation without its results and history
+type MetadataStore interface {
+	ExportMetadata(ctx context.Context, w io.Writer) (map[string]int64, error)
+	ImportMetadata(ctx context.Context, r io.Reader) (map[string]int64, error)
+}
+
+// mongoStore is the Store backed by a MongoDB database
+type mongoStore struct {
+	db *mongo.Database
+}
+
+// NewStore returns the Store of a MongoDB database
+func NewStore(db *mongo.Database) Store {
+	return &mongoStore{db: db}
+}
+
+func (s *mongoStore) EnsureIndexes(ctx context.Context) error {
+	var errs []error
+	for _, ensure := range []func(context.Context) error{
+		s.ensureUserIndexes,
+		s.ensureSessionIndexes,
+		s.ensurePasswordResetIndexes,
+		s.ensureOrganizationIndexes,
+		s.ensureWorkspaceIndexes,
+		s.ensureQueryTemplateIndexes,
+		s.ensureDatabaseIndexes,
+		s.ensureQueryIndexes,
+		s.ensureDashboardIndexes,
+		s.ensureCardDataIndexes,
+		s.ensureSnapshotIndexes,
+		s.ensureDownloadLinkIndexes,
+		s.ensureReportIndexes,
+		s.ensureJobIndexes,
+		s.ensureWebhookIndexes,
+		s.ensureAlertIndexes,
+		s.ensureAlertSampleIndexes,
+		s.ensureAlertEventIndexes,
+		s.ensureNotificationChannelIndexes,
+		s.ensureNotificationIndexes,
+		s.ensureAuditIndexes,
+		s.ensureExecutionMetricIndexes,
+		s.ensureQueryRunIndexes,
+		s.ensureQueryCommentIndexes,
+		s.ensureDashboardCommentIndexes,
+		s.ensureFreshnessCheckIndexes,
+		s.ensureTableProfileIndexes,
+		s.ensureGoogleSheetsIndexes,
+	} {
+		if err := ensure(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,208 @@
+package models
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Where a suggested question comes from
+const (
+	SuggestionHistory = "history" // Asked before on the database by the user or their organization
+	SuggestionSchema  = "schema"  // A common aggregate over one of the database's tables
+)
+
+// QuestionSuggestion is a natural language question to ask a database
+type QuestionSuggestion struct {
+	Text      string     `json:"text"`
+	Source    string     `json:"source"`
+	Count     int        `json:"count,omitempty"`      // How often it was asked
+	LastAsked *time.Time `json:"last_asked,omitempty"` // When it was last asked
+	Table     string     `json:"table,omitempty"`      // The table schema suggestions are about
+}
+
+// SuggestQuestions ranks the questions asked before, most often first, above
+// the schema's suggestions starting with prefix, leaving out the ones already
+// asked, and returns at most limit of them
+func SuggestQuestions(history []*QuestionSuggestion, schema *Schema, prefix string, limit int) []*QuestionSuggestion {
+	asked := make(map[string]bool, len(history))
+	suggestions := make([]*QuestionSuggestion, 0, limit)
+	for _, suggestion := range history {
+		asked[strings.ToLower(suggestion.Text)] = true
+		suggestions = append(suggestions, suggestion)
+	}
+
+	prefix = strings.ToLower(prefix)
+	for _, suggestion := range SchemaSuggestions(schema) {
+		text := strings.ToLower(suggestion.Text)
+		if strings.HasPrefix(text, prefix) && !asked[text] {
+			suggestions = append(suggestions, suggestion)
+		}
+	}
+
+	if len(suggestions) > limit {
+		suggestions = suggestions[:limit]
+	}
+	return suggestions
+}
+
+// GetQuestionHistory returns the questions asked on a database in the queries
+// a user owns or can see through an organization, starting with prefix, most
+// often asked first. Questions differing only in case or surrounding spaces
+// count as one, worded as they were last asked.
+func (s *mongoStore) GetQuestionHistory(ctx context.Context, databaseID, userID primitive.ObjectID, prefix string, limit int) ([]*QuestionSuggestion, error) {
+	filter, err := s.ownedOrSharedFilter(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	filter["database_id"] = databaseID
+	filter["deleted_at"] = notDeleted
+	filter["natural_query"] = questionPrefixFilter(prefix)
+
+	cursor, err := s.queryCollection().Aggregate(ctx, historyPipeline(filter, limit))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	suggestions := []*QuestionSuggestion{}
+	for cursor.Next(ctx) {
+		var result struct {
+			Text      string    `bson:"text"`
+			Count     int       `bson:"count"`
+			LastAsked time.Time `bson:"last_asked"`
+		}
+		if err := cursor.Decode(&result); err != nil {
+			return nil, err
+		}
+
+		lastAsked := result.LastAsked
+		suggestions = append(suggestions, &QuestionSuggestion{
+			Text:      strings.TrimSpace(result.Text),
+			Source:    SuggestionHistory,
+			Count:     result.Count,
+			LastAsked: &lastAsked,
+		})
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, err
+	}
+
+	return suggestions, nil
+}
+
+// normalizeQuestion is the form questions are grouped by
+var normalizeQuestion = bson.M{"$toLower": bson.M{"$trim": bson.M{"input": "$natural_query"}}}
+
+// questionPrefixFilter matches natural queries starting with prefix
+func questionPrefixFilter(prefix string) bson.M {
+	if prefix == "" {
+		return bson.M{"$nin": bson.A{"", nil}}
+	}
+	return bson.M{"$regex": "^\\s*" + regexp.QuoteMeta(prefix), "$options": "i"}
+}
+
+// historyPipeline groups the matched queries by question, most asked first
+func historyPipeline(filter bson.M, limit int) mongo.Pipeline {
+	return mongo.Pipeline{
+		{{Key: "$match", Value: filter}},
+		{{Key: "$sort", Value: bson.M{"created_at": -1}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":        normalizeQuestion,
+			"text":       bson.M{"$first": "$natural_query"},
+			"count":      bson.M{"$sum": 1},
+			"last_asked": bson.M{"$max": "$created_at"},
+		}}},
+		{{Key: "$sort", Value: bson.D{{Key: "count", Value: -1}, {Key: "last_asked", Value: -1}}}},
+		{{Key: "$limit", Value: limit}},
+	}
+}
+
+// SchemaSuggestions returns common aggregates over a schema's tables as
+// questions: counting rows, totals and averages of numeric columns, counts
+// over time by date columns and breakdowns by category-like columns
+func SchemaSuggestions(schema *Schema) []*QuestionSuggestion {
+	suggestions := []*QuestionSuggestion{}
+	if schema == nil {
+		return suggestions
+	}
+
+	for _, table := range schema.Tables {
+		name := humanizeName(table.Name)
+		add := func(text string) {
+			suggestions = append(suggestions, &QuestionSuggestion{Text: text, Source: SuggestionSchema, Table: table.Name})
+		}
+
+		add("How many " + name + " are there?")
+		for _, column := range table.Columns {
+			if column.PrimaryKey || isKeyColumn(column.Name) {
+				continue
+			}
+			columnName := humanizeName(column.Name)
+			switch {
+			case isNumericSuggestionType(column.Type):
+				add("What is the total " + columnName + " of " + name + "?")
+				add("What is the average " + columnName + " of " + name + "?")
+			case isTemporalSuggestionType(column.Type):
+				add("How many " + name + " are there per month by " + columnName + "?")
+			case isCategoryColumn(column.Name):
+				add("How many " + name + " are there per " + columnName + "?")
+			}
+		}
+	}
+	return suggestions
+}
+
+// humanizeName turns a table or column name into words, e.g. "order_items"
+// into "order items"
+func humanizeName(name string) string {
+	return strings.TrimSpace(strings.NewReplacer("_", " ", ".", " ").Replace(name))
+}
+
+// isKeyColumn reports whether a column holds an identifier rather than a value
+// worth aggregating
+func isKeyColumn(name string) bool {
+	name = strings.ToLower(name)
+	return name == "id" || name == "_id" || strings.HasSuffix(name, "_id") || strings.HasSuffix(name, "_ids")
+}
+
+// isNumericSuggestionType reports whether a PostgreSQL or MongoDB column type
+// holds numbers
+func isNumericSuggestionType(columnType string) bool {
+	columnType = strings.ToLower(columnType)
+	if strings.HasPrefix(columnType, "interval") {
+		return false
+	}
+	for _, prefix := range []string{"int", "bigint", "smallint", "numeric", "decimal", "real", "double", "float", "money", "long"} {
+		if strings.HasPrefix(columnType, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// isTemporalSuggestionType reports whether a PostgreSQL or MongoDB column type
+// holds dates
+func isTemporalSuggestionType(columnType string) bool {
+	columnType = strings.ToLower(columnType)
+	return strings.HasPrefix(columnType, "timestamp") || strings.HasPrefix(columnType, "date")
+}
+
+// categoryColumnNames are column names that usually hold a small set of values
+var categoryColumnNames = []string{"status", "type", "category", "kind", "country", "state", "city", "region", "channel", "plan", "tier"}
+
+// isCategoryColumn reports whether a column's name suggests it categorizes rows
+func isCategoryColumn(name string) bool {
+	name = strings.ToLower(name)
+	for _, category := range categoryColumnNames {
+		if name == category || strings.HasSuffix(name, "_"+category) {
+			return true
+		}
+	}
+	return false
+}
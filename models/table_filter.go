@@ -0,0 +1,72 @@
+package models
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// ValidateTablePatterns checks that include and exclude patterns are valid globs
+func ValidateTablePatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		if strings.TrimSpace(pattern) == "" {
+			return fmt.Errorf("table pattern must not be empty")
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid table pattern %q", pattern)
+		}
+	}
+	return nil
+}
+
+// TableVisible reports whether a table passes the connection's include and
+// exclude lists. With an include list only matching tables are visible, and
+// excluded tables are always hidden. Matching ignores case.
+func (db *Database) TableVisible(name string) bool {
+	if len(db.IncludeTables) > 0 && !matchesTablePattern(db.IncludeTables, name) {
+		return false
+	}
+	return !matchesTablePattern(db.ExcludeTables, name)
+}
+
+// VisibleSchema returns the stored schema without tables hidden by the
+// include and exclude lists, which may have changed since it was fetched, and
+// with the user's column annotations applied
+func (db *Database) VisibleSchema() *Schema {
+	return db.annotateSchema(db.filterSchema(db.Schema))
+}
+
+// filterSchema drops tables hidden by the include and exclude lists, along
+// with relationships that reference them
+func (db *Database) filterSchema(schema *Schema) *Schema {
+	if schema == nil || (len(db.IncludeTables) == 0 && len(db.ExcludeTables) == 0) {
+		return schema
+	}
+
+	tables := make([]Table, 0, len(schema.Tables))
+	for _, table := range schema.Tables {
+		if db.TableVisible(table.Name) {
+			tables = append(tables, table)
+		}
+	}
+
+	var relationships []Relationship
+	for _, relationship := range schema.Relationships {
+		if db.TableVisible(relationship.FromTable) && db.TableVisible(relationship.ToTable) {
+			relationships = append(relationships, relationship)
+		}
+	}
+
+	return &Schema{Tables: tables, Relationships: relationships, Functions: schema.Functions}
+}
+
+// matchesTablePattern reports whether name matches any of the glob patterns
+func matchesTablePattern(patterns []string, name string) bool {
+	name = strings.ToLower(name)
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(strings.ToLower(pattern), name); matched {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,416 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Limits of a table profile. Profiles are computed from a sample of rows, so
+// their cost doesn't grow with the table.
+const (
+	MaxProfileRows         = 10000 // Rows sampled
+	MaxProfileColumns      = 100   // Columns profiled, in schema order
+	MaxProfileTopValues    = 10    // Most frequent values listed per column
+	ProfileHistogramBucket = 10    // Buckets in the histogram of a numeric column
+	maxProfileValueLength  = 200   // Longer values are compared and shown by their start
+)
+
+// TableProfileTTL is how long a stored profile is served before it is
+// computed again
+const TableProfileTTL = time.Hour
+
+// TableProfileMinInterval is the least time between two computations of the
+// same table's profile, even when a refresh is asked for
+const TableProfileMinInterval = time.Minute
+
+// TableProfile describes how the values of a table's columns are distributed,
+// computed from a sample of its rows
+type TableProfile struct {
+	ID             primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	DatabaseID     primitive.ObjectID `json:"database_id" bson:"database_id"`
+	Table          string             `json:"table" bson:"table"`
+	RowEstimate    int64              `json:"row_estimate,omitempty" bson:"row_estimate,omitempty"` // The database's own estimate of the table's size
+	SampleSize     int                `json:"sample_size" bson:"sample_size"`                       // Rows the profile was computed from
+	Columns        []ColumnProfile    `json:"columns" bson:"columns"`
+	ColumnsOmitted int                `json:"columns_omitted,omitempty" bson:"columns_omitted,omitempty"` // Columns past MaxProfileColumns
+	DurationMs     int64              `json:"duration_ms" bson:"duration_ms"`
+	ProfiledAt     time.Time          `json:"profiled_at" bson:"profiled_at"`
+	Cached         bool               `json:"cached" bson:"-"` // Served from the stored profile, set for responses
+}
+
+// ColumnProfile is the distribution of one column's values in the sample.
+// Min and max are set for numbers, dates and text, the histogram for numbers.
+type ColumnProfile struct {
+	Name          string            `json:"name" bson:"name"`
+	Type          string            `json:"type" bson:"type"`
+	NullCount     int               `json:"null_count" bson:"null_count"`
+	NullRate      float64           `json:"null_rate" bson:"null_rate"` // From 0 to 1, missing MongoDB fields count as null
+	DistinctCount int               `json:"distinct_count" bson:"distinct_count"`
+	Min           interface{}       `json:"min,omitempty" bson:"min,omitempty"`
+	Max           interface{}       `json:"max,omitempty" bson:"max,omitempty"`
+	TopValues     []ValueFrequency  `json:"top_values,omitempty" bson:"top_values,omitempty"` // Left out when every value is distinct
+	Histogram     []HistogramBucket `json:"histogram,omitempty" bson:"histogram,omitempty"`
+}
+
+// ValueFrequency is how often a value occurs in the sample
+type ValueFrequency struct {
+	Value string `json:"value" bson:"value"`
+	Count int    `json:"count" bson:"count"`
+}
+
+// HistogramBucket counts the numbers from Low up to High, the last bucket
+// including High
+type HistogramBucket struct {
+	Low   float64 `json:"low" bson:"low"`
+	High  float64 `json:"high" bson:"high"`
+	Count int     `json:"count" bson:"count"`
+}
+
+// Column returns the profile of a column, nil when it wasn't profiled
+func (p *TableProfile) Column(name string) *ColumnProfile {
+	for i := range p.Columns {
+		if p.Columns[i].Name == name {
+			return &p.Columns[i]
+		}
+	}
+	return nil
+}
+
+// Fresh reports whether a stored profile can still be served at now
+func (p *TableProfile) Fresh(now time.Time) bool {
+	return now.Sub(p.ProfiledAt) < TableProfileTTL
+}
+
+// Kinds of profiled values
+const (
+	profileKindNull    = "null"
+	profileKindNumber  = "number"
+	profileKindTime    = "time"
+	profileKindText    = "text"
+	profileKindBool    = "bool"
+	profileKindID      = "id"
+	profileKindComplex = "complex" // Documents and arrays, only counted as null or not
+)
+
+// profileValue is a sampled value normalized for comparison
+type profileValue struct {
+	kind   string
+	number float64
+	time   time.Time
+	text   string
+}
+
+// key returns the value as compared for distinct counts and shown in top values
+func (v profileValue) key() string {
+	switch v.kind {
+	case profileKindNumber:
+		return strconv.FormatFloat(v.number, 'g', -1, 64)
+	case profileKindTime:
+		return v.time.UTC().Format(time.RFC3339Nano)
+	default:
+		return v.text
+	}
+}
+
+// normalizeProfileValue normalizes a value read from a column. Text is only
+// read as a number in numeric columns, which PostgreSQL returns as strings.
+func normalizeProfileValue(value interface{}, numericColumn bool) profileValue {
+	switch v := value.(type) {
+	case nil:
+		return profileValue{kind: profileKindNull}
+	case bool:
+		return profileValue{kind: profileKindBool, text: strconv.FormatBool(v)}
+	case int, int32, int64, float32, float64, primitive.Decimal128:
+		number, _ := toFloat(v)
+		return profileValue{kind: profileKindNumber, number: number}
+	case time.Time:
+		return profileValue{kind: profileKindTime, time: v}
+	case primitive.DateTime:
+		return profileValue{kind: profileKindTime, time: v.Time()}
+	case primitive.Timestamp:
+		return profileValue{kind: profileKindTime, time: time.Unix(int64(v.T), 0)}
+	case primitive.ObjectID:
+		return profileValue{kind: profileKindID, text: v.Hex()}
+	case string:
+		if numericColumn {
+			if number, ok := toFloat(v); ok {
+				return profileValue{kind: profileKindNumber, number: number}
+			}
+		}
+		return profileValue{kind: profileKindText, text: truncateProfileText(v)}
+	case bson.M, bson.D, primitive.A, map[string]interface{}, []interface{}:
+		return profileValue{kind: profileKindComplex}
+	default:
+		return profileValue{kind: profileKindText, text: truncateProfileText(fmt.Sprint(v))}
+	}
+}
+
+// truncateProfileText cuts text to the length profiles compare
+func truncateProfileText(text string) string {
+	if len(text) <= maxProfileValueLength {
+		return text
+	}
+	cut := maxProfileValueLength
+	for cut > 0 && !utf8.RuneStart(text[cut]) {
+		cut--
+	}
+	return text[:cut]
+}
+
+// isNumericColumnType reports whether a PostgreSQL column type holds numbers
+// the driver returns as text
+func isNumericColumnType(columnType string) bool {
+	columnType = strings.ToLower(columnType)
+	return strings.HasPrefix(columnType, "numeric") || strings.HasPrefix(columnType, "decimal")
+}
+
+// profileColumn computes the profile of a column from its sampled values
+func profileColumn(column Column, rows []QueryResult) ColumnProfile {
+	profile := ColumnProfile{Name: column.Name, Type: column.Type}
+	numericColumn := isNumericColumnType(column.Type)
+
+	counts := make(map[string]int)
+	var values []profileValue
+	kinds := make(map[string]bool)
+	for _, row := range rows {
+		value := normalizeProfileValue(row[column.Name], numericColumn)
+		if value.kind == profileKindNull {
+			profile.NullCount++
+			continue
+		}
+		kinds[value.kind] = true
+		if value.kind == profileKindComplex {
+			continue
+		}
+		counts[value.key()]++
+		values = append(values, value)
+	}
+
+	if len(rows) > 0 {
+		profile.NullRate = float64(profile.NullCount) / float64(len(rows))
+	}
+	profile.DistinctCount = len(counts)
+
+	// Listing the most frequent values says nothing when all are unique
+	if profile.DistinctCount > 0 && profile.DistinctCount < len(values) {
+		profile.TopValues = topValues(counts)
+	}
+
+	// Ranges only make sense when every value is of the same kind
+	if len(kinds) != 1 || len(values) == 0 {
+		return profile
+	}
+	switch values[0].kind {
+	case profileKindNumber:
+		numbers := make([]float64, len(values))
+		for i, value := range values {
+			numbers[i] = value.number
+		}
+		low, high := numbers[0], numbers[0]
+		for _, number := range numbers {
+			low = math.Min(low, number)
+			high = math.Max(high, number)
+		}
+		profile.Min, profile.Max = low, high
+		profile.Histogram = histogram(numbers, low, high)
+	case profileKindTime:
+		low, high := values[0].time, values[0].time
+		for _, value := range values {
+			if value.time.Before(low) {
+				low = value.time
+			}
+			if value.time.After(high) {
+				high = value.time
+			}
+		}
+		profile.Min, profile.Max = low.UTC(), high.UTC()
+	case profileKindText:
+		low, high := values[0].text, values[0].text
+		for _, value := range values {
+			if value.text < low {
+				low = value.text
+			}
+			if value.text > high {
+				high = value.text
+			}
+		}
+		profile.Min, profile.Max = low, high
+	}
+
+	return profile
+}
+
+// topValues returns the most frequent values, ties broken by value so the
+// profile is stable
+func topValues(counts map[string]int) []ValueFrequency {
+	frequencies := make([]ValueFrequency, 0, len(counts))
+	for value, count := range counts {
+		frequencies = append(frequencies, ValueFrequency{Value: value, Count: count})
+	}
+	sort.Slice(frequencies, func(i, j int) bool {
+		if frequencies[i].Count != frequencies[j].Count {
+			return frequencies[i].Count > frequencies[j].Count
+		}
+		return frequencies[i].Value < frequencies[j].Value
+	})
+
+	if len(frequencies) > MaxProfileTopValues {
+		frequencies = frequencies[:MaxProfileTopValues]
+	}
+	return frequencies
+}
+
+// histogram counts numbers into equally wide buckets from low to high
+func histogram(numbers []float64, low, high float64) []HistogramBucket {
+	if low == high {
+		return []HistogramBucket{{Low: low, High: high, Count: len(numbers)}}
+	}
+
+	width := (high - low) / ProfileHistogramBucket
+	buckets := make([]HistogramBucket, ProfileHistogramBucket)
+	for i := range buckets {
+		buckets[i].Low = low + float64(i)*width
+		buckets[i].High = low + float64(i+1)*width
+	}
+	buckets[len(buckets)-1].High = high
+
+	for _, number := range numbers {
+		i := int((number - low) / width)
+		if i >= len(buckets) {
+			i = len(buckets) - 1
+		}
+		buckets[i].Count++
+	}
+	return buckets
+}
+
+// ProfileTable samples a table of the database's schema and computes the
+// profile of its columns
+func ProfileTable(ctx context.Context, db *Database, table *Table) (*TableProfile, error) {
+	columns := table.Columns
+	omitted := 0
+	if len(columns) > MaxProfileColumns {
+		omitted = len(columns) - MaxProfileColumns
+		columns = columns[:MaxProfileColumns]
+	}
+	names := make([]string, len(columns))
+	for i, column := range columns {
+		names[i] = column.Name
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, db.queryTimeout(30*time.Second))
+	defer cancel()
+
+	start := time.Now()
+	var rows []QueryResult
+	var estimate int64
+	var err error
+	switch db.Type {
+	case "postgresql":
+		rows, estimate, err = samplePostgresRows(ctx, db, table.Name, names, MaxProfileRows)
+	case "mongodb":
+		rows, estimate, err = sampleMongoDBDocuments(ctx, db, table.Name, names, MaxProfileRows)
+	default:
+		return nil, fmt.Errorf("unsupported database type: %s", db.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	profile := &TableProfile{
+		DatabaseID:     db.ID,
+		Table:          table.Name,
+		RowEstimate:    estimate,
+		SampleSize:     len(rows),
+		Columns:        make([]ColumnProfile, len(columns)),
+		ColumnsOmitted: omitted,
+	}
+	for i, column := range columns {
+		profile.Columns[i] = profileColumn(column, rows)
+	}
+	profile.DurationMs = time.Since(start).Milliseconds()
+	profile.ProfiledAt = time.Now()
+
+	return profile, nil
+}
+
+// tableProfileCollection returns the table profiles collection
+func (s *mongoStore) tableProfileCollection() *mongo.Collection {
+	return s.db.Collection("table_profiles")
+}
+
+// ensureTableProfileIndexes creates the index profiles are looked up by
+func (s *mongoStore) ensureTableProfileIndexes(ctx context.Context) error {
+	_, err := s.tableProfileCollection().Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "database_id", Value: 1}, {Key: "table", Value: 1}},
+		Options: options.Index().SetName("table_profile_table").SetUnique(true),
+	})
+	return err
+}
+
+// SaveTableProfile stores a table's profile, replacing the previous one
+func (s *mongoStore) SaveTableProfile(ctx context.Context, profile *TableProfile) error {
+	filter := bson.M{"database_id": profile.DatabaseID, "table": profile.Table}
+	opts := options.FindOneAndReplace().
+		SetUpsert(true).
+		SetReturnDocument(options.After).
+		SetProjection(bson.M{"_id": 1})
+
+	var saved struct {
+		ID primitive.ObjectID `bson:"_id"`
+	}
+	profile.ID = primitive.NilObjectID
+	if err := s.tableProfileCollection().FindOneAndReplace(ctx, filter, profile, opts).Decode(&saved); err != nil {
+		return err
+	}
+
+	// Set the ID
+	profile.ID = saved.ID
+
+	return nil
+}
+
+// GetTableProfile retrieves the stored profile of a table
+func (s *mongoStore) GetTableProfile(ctx context.Context, databaseID primitive.ObjectID, table string) (*TableProfile, error) {
+	var profile TableProfile
+	err := s.tableProfileCollection().FindOne(ctx, bson.M{"database_id": databaseID, "table": table}).Decode(&profile)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &profile, nil
+}
+
+// GetTableProfiles retrieves the stored profiles of a database's tables,
+// keyed by table
+func (s *mongoStore) GetTableProfiles(ctx context.Context, databaseID primitive.ObjectID) (map[string]*TableProfile, error) {
+	cursor, err := s.tableProfileCollection().Find(ctx, bson.M{"database_id": databaseID})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var profiles []*TableProfile
+	if err := cursor.All(ctx, &profiles); err != nil {
+		return nil, err
+	}
+
+	byTable := make(map[string]*TableProfile, len(profiles))
+	for _, profile := range profiles {
+		byTable[profile.Table] = profile
+	}
+	return byTable, nil
+}
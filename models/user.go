@@ -5,32 +5,50 @@ import (
 	"errors"
 	"time"
 
-	"github.com/zucced/goquery/database"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 	"golang.org/x/crypto/bcrypt"
 )
 
 // User represents a user in the system
 type User struct {
-	ID           primitive.ObjectID `json:"id" bson:"_id,omitempty"`
-	Email        string             `json:"email" bson:"email"`
-	PasswordHash string             `json:"-" bson:"password_hash"`
-	Name         string             `json:"name" bson:"name"`
-	CreatedAt    time.Time          `json:"created_at" bson:"created_at"`
-	UpdatedAt    time.Time          `json:"updated_at" bson:"updated_at"`
+	ID            primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Email         string             `json:"email" bson:"email"`
+	PasswordHash  string             `json:"-" bson:"password_hash"`
+	Name          string             `json:"name" bson:"name"`
+	Preferences   UserPreferences    `json:"preferences" bson:"preferences"`
+	DemoExpiresAt *time.Time         `json:"demo_expires_at,omitempty" bson:"demo_expires_at,omitempty"` // Set on anonymous demo users
+	CreatedAt     time.Time          `json:"created_at" bson:"created_at"`
+	UpdatedAt     time.Time          `json:"updated_at" bson:"updated_at"`
 }
 
-// UserCollection returns the users collection
-func UserCollection() *mongo.Collection {
-	return database.GetCollection("users")
+// userCollection returns the users collection
+func (s *mongoStore) userCollection() *mongo.Collection {
+	return s.db.Collection("users")
+}
+
+// ensureUserIndexes makes emails unique, since they are looked up on every
+// login, and indexes when demo users expire
+func (s *mongoStore) ensureUserIndexes(ctx context.Context) error {
+	_, err := s.userCollection().Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "email", Value: 1}},
+			Options: options.Index().SetName("user_email").SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "demo_expires_at", Value: 1}},
+			Options: options.Index().SetName("user_demo_expiry").SetSparse(true),
+		},
+	})
+	return err
 }
 
 // CreateUser creates a new user
-func CreateUser(ctx context.Context, email, password, name string) (*User, error) {
+func (s *mongoStore) CreateUser(ctx context.Context, email, password, name string) (*User, error) {
 	// Check if user already exists
-	existingUser, _ := GetUserByEmail(ctx, email)
+	existingUser, _ := s.GetUserByEmail(ctx, email)
 	if existingUser != nil {
 		return nil, errors.New("user with this email already exists")
 	}
@@ -51,8 +69,12 @@ func CreateUser(ctx context.Context, email, password, name string) (*User, error
 		UpdatedAt:    now,
 	}
 
-	// Insert the user into the database
-	result, err := UserCollection().InsertOne(ctx, user)
+	// Insert the user into the database. The unique email index catches
+	// signups racing the check above.
+	result, err := s.userCollection().InsertOne(ctx, user)
+	if mongo.IsDuplicateKeyError(err) {
+		return nil, errors.New("user with this email already exists")
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -64,9 +86,9 @@ func CreateUser(ctx context.Context, email, password, name string) (*User, error
 }
 
 // GetUserByEmail retrieves a user by email
-func GetUserByEmail(ctx context.Context, email string) (*User, error) {
+func (s *mongoStore) GetUserByEmail(ctx context.Context, email string) (*User, error) {
 	var user User
-	err := UserCollection().FindOne(ctx, bson.M{"email": email}).Decode(&user)
+	err := s.userCollection().FindOne(ctx, bson.M{"email": email}).Decode(&user)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
 			return nil, nil
@@ -77,9 +99,9 @@ func GetUserByEmail(ctx context.Context, email string) (*User, error) {
 }
 
 // GetUserByID retrieves a user by ID
-func GetUserByID(ctx context.Context, id primitive.ObjectID) (*User, error) {
+func (s *mongoStore) GetUserByID(ctx context.Context, id primitive.ObjectID) (*User, error) {
 	var user User
-	err := UserCollection().FindOne(ctx, bson.M{"_id": id}).Decode(&user)
+	err := s.userCollection().FindOne(ctx, bson.M{"_id": id}).Decode(&user)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
 			return nil, nil
@@ -90,10 +112,10 @@ func GetUserByID(ctx context.Context, id primitive.ObjectID) (*User, error) {
 }
 
 // UpdateUser updates a user
-func UpdateUser(ctx context.Context, user *User) error {
+func (s *mongoStore) UpdateUser(ctx context.Context, user *User) error {
 	user.UpdatedAt = time.Now()
 
-	_, err := UserCollection().UpdateOne(
+	_, err := s.userCollection().UpdateOne(
 		ctx,
 		bson.M{"_id": user.ID},
 		bson.M{"$set": bson.M{
@@ -112,7 +134,7 @@ func VerifyPassword(hashedPassword, password string) bool {
 }
 
 // UpdatePassword updates a user's password
-func UpdatePassword(ctx context.Context, userID primitive.ObjectID, password string) error {
+func (s *mongoStore) UpdatePassword(ctx context.Context, userID primitive.ObjectID, password string) error {
 	// Hash the password
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
@@ -120,7 +142,7 @@ func UpdatePassword(ctx context.Context, userID primitive.ObjectID, password str
 	}
 
 	// Update the password
-	_, err = UserCollection().UpdateOne(
+	_, err = s.userCollection().UpdateOne(
 		ctx,
 		bson.M{"_id": userID},
 		bson.M{"$set": bson.M{
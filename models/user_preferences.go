@@ -0,0 +1,88 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/text/language"
+)
+
+// UserPreferences holds a user's personal settings
+type UserPreferences struct {
+	Timezone           string             `json:"timezone,omitempty" bson:"timezone,omitempty"`                         // IANA name used for date bucketing and report schedules
+	Locale             string             `json:"locale,omitempty" bson:"locale,omitempty"`                             // BCP 47 tag used to format numbers and dates
+	DefaultDatabaseID  primitive.ObjectID `json:"default_database_id,omitempty" bson:"default_database_id,omitempty"`   // Database used when a query doesn't name one
+	DefaultResultLimit int                `json:"default_result_limit,omitempty" bson:"default_result_limit,omitempty"` // Rows kept from an execution, 0 for the server maximum
+}
+
+// Validate checks the preferences and normalizes the locale
+func (p *UserPreferences) Validate() error {
+	if p.Timezone != "" {
+		if _, err := time.LoadLocation(p.Timezone); err != nil {
+			return fmt.Errorf("invalid timezone: %s", p.Timezone)
+		}
+	}
+
+	if p.Locale != "" {
+		tag, err := language.Parse(p.Locale)
+		if err != nil {
+			return fmt.Errorf("invalid locale: %s", p.Locale)
+		}
+		p.Locale = tag.String()
+	}
+
+	if p.DefaultResultLimit < 0 || p.DefaultResultLimit > MaxResultRows {
+		return fmt.Errorf("default result limit must be between 0 and %d", MaxResultRows)
+	}
+
+	return nil
+}
+
+// Location returns the user's timezone, UTC when unset or unknown
+func (p *UserPreferences) Location() *time.Location {
+	if p.Timezone == "" {
+		return time.UTC
+	}
+	location, err := time.LoadLocation(p.Timezone)
+	if err != nil {
+		return time.UTC
+	}
+	return location
+}
+
+// LimitResults trims results to the user's default result limit
+func (p *UserPreferences) LimitResults(results []QueryResult) []QueryResult {
+	if p.DefaultResultLimit > 0 && len(results) > p.DefaultResultLimit {
+		return results[:p.DefaultResultLimit]
+	}
+	return results
+}
+
+// GetUserPreferences retrieves a user's preferences
+func (s *mongoStore) GetUserPreferences(ctx context.Context, userID primitive.ObjectID) (*UserPreferences, error) {
+	user, err := s.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, errors.New("user not found")
+	}
+	return &user.Preferences, nil
+}
+
+// UpdateUserPreferences replaces a user's preferences
+func (s *mongoStore) UpdateUserPreferences(ctx context.Context, userID primitive.ObjectID, preferences *UserPreferences) error {
+	_, err := s.userCollection().UpdateOne(
+		ctx,
+		bson.M{"_id": userID},
+		bson.M{"$set": bson.M{
+			"preferences": preferences,
+			"updated_at":  time.Now(),
+		}},
+	)
+	return err
+}
@@ -0,0 +1,292 @@
+package models
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// VariableType represents the type of a dashboard variable
+type VariableType string
+
+const (
+	VariableTypeText      VariableType = "text"
+	VariableTypeNumber    VariableType = "number"
+	VariableTypeDate      VariableType = "date"
+	VariableTypeDateRange VariableType = "date_range"
+	VariableTypeSelect    VariableType = "select"
+)
+
+// DashboardVariable is a filter defined on a dashboard that card queries can
+// reference with {{name}}, or {{name.start}} and {{name.end}} for date ranges
+type DashboardVariable struct {
+	Name    string       `json:"name" bson:"name"`
+	Label   string       `json:"label,omitempty" bson:"label,omitempty"`
+	Type    VariableType `json:"type" bson:"type"`
+	Default string       `json:"default,omitempty" bson:"default,omitempty"` // Date ranges use "start..end"
+	Options []string     `json:"options,omitempty" bson:"options,omitempty"` // Allowed values for select variables
+}
+
+// variableDateLayout is the format of date variable values
+const variableDateLayout = "2006-01-02"
+
+// dateRangeSeparator separates the start and end of a date range value
+const dateRangeSeparator = ".."
+
+var (
+	variableNameRegex        = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	variablePlaceholderRegex = regexp.MustCompile(`\{\{\s*([A-Za-z_][A-Za-z0-9_]*(?:\.(?:start|end))?)\s*\}\}`)
+)
+
+// ValidateDashboardVariables checks variable definitions and their defaults
+func ValidateDashboardVariables(variables []DashboardVariable) error {
+	seen := make(map[string]bool)
+	for _, variable := range variables {
+		if !variableNameRegex.MatchString(variable.Name) {
+			return fmt.Errorf("invalid variable name: %q", variable.Name)
+		}
+		if seen[variable.Name] {
+			return fmt.Errorf("duplicate variable: %s", variable.Name)
+		}
+		seen[variable.Name] = true
+
+		switch variable.Type {
+		case VariableTypeText, VariableTypeNumber, VariableTypeDate, VariableTypeDateRange:
+		case VariableTypeSelect:
+			if len(variable.Options) == 0 {
+				return fmt.Errorf("select variable %s needs at least one option", variable.Name)
+			}
+		default:
+			return fmt.Errorf("unsupported type for variable %s: %s", variable.Name, variable.Type)
+		}
+
+		if variable.Default != "" {
+			if _, err := parseVariableValue(variable, variable.Default); err != nil {
+				return fmt.Errorf("invalid default for variable %s: %v", variable.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// ResolveVariableValues combines supplied values with variable defaults and
+// converts them to typed values keyed by placeholder name
+func ResolveVariableValues(variables []DashboardVariable, supplied map[string]string) (map[string]interface{}, error) {
+	defined := make(map[string]DashboardVariable, len(variables))
+	for _, variable := range variables {
+		defined[variable.Name] = variable
+	}
+
+	for name := range supplied {
+		if _, ok := defined[name]; !ok {
+			return nil, fmt.Errorf("unknown variable: %s", name)
+		}
+	}
+
+	values := make(map[string]interface{})
+	for _, variable := range variables {
+		raw, ok := supplied[variable.Name]
+		if !ok {
+			raw = variable.Default
+		}
+		if raw == "" {
+			continue
+		}
+
+		value, err := parseVariableValue(variable, raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for variable %s: %v", variable.Name, err)
+		}
+
+		if dateRange, ok := value.([2]time.Time); ok {
+			values[variable.Name+".start"] = dateRange[0]
+			values[variable.Name+".end"] = dateRange[1]
+		} else {
+			values[variable.Name] = value
+		}
+	}
+
+	return values, nil
+}
+
+// parseVariableValue converts a raw string value to the variable's type
+func parseVariableValue(variable DashboardVariable, raw string) (interface{}, error) {
+	raw = strings.TrimSpace(raw)
+
+	switch variable.Type {
+	case VariableTypeNumber:
+		if n, err := strconv.ParseInt(raw, 10, 64); err == nil {
+			return n, nil
+		}
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return nil, fmt.Errorf("not a number: %q", raw)
+		}
+		return f, nil
+	case VariableTypeDate:
+		t, err := time.Parse(variableDateLayout, raw)
+		if err != nil {
+			return nil, fmt.Errorf("dates must use YYYY-MM-DD: %q", raw)
+		}
+		return t, nil
+	case VariableTypeDateRange:
+		parts := strings.SplitN(raw, dateRangeSeparator, 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("date ranges must use YYYY-MM-DD..YYYY-MM-DD: %q", raw)
+		}
+		start, err := time.Parse(variableDateLayout, strings.TrimSpace(parts[0]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid range start: %q", parts[0])
+		}
+		end, err := time.Parse(variableDateLayout, strings.TrimSpace(parts[1]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid range end: %q", parts[1])
+		}
+		if end.Before(start) {
+			return nil, fmt.Errorf("range end is before its start")
+		}
+		return [2]time.Time{start, end}, nil
+	case VariableTypeSelect:
+		for _, option := range variable.Options {
+			if option == raw {
+				return raw, nil
+			}
+		}
+		return nil, fmt.Errorf("%q is not one of the allowed options", raw)
+	default:
+		return raw, nil
+	}
+}
+
+// HasQueryVariables reports whether a query references any variables
+func HasQueryVariables(query string) bool {
+	return variablePlaceholderRegex.MatchString(query)
+}
+
+// BindQueryVariables replaces variable placeholders in a query. Postgres queries
+// get positional parameters and the returned arguments; MongoDB queries get the
+// values inlined as literals since they are parsed rather than sent as text.
+func BindQueryVariables(dbType, query string, values map[string]interface{}) (string, []interface{}, error) {
+	var args []interface{}
+	positions := make(map[string]int)
+	var bindErr error
+
+	bound := variablePlaceholderRegex.ReplaceAllStringFunc(query, func(match string) string {
+		if bindErr != nil {
+			return match
+		}
+
+		name := variablePlaceholderRegex.FindStringSubmatch(match)[1]
+		value, ok := values[name]
+		if !ok {
+			bindErr = fmt.Errorf("no value for variable %s", name)
+			return match
+		}
+
+		switch dbType {
+		case "postgresql":
+			// Reuse the same parameter when a variable appears more than once
+			position, ok := positions[name]
+			if !ok {
+				args = append(args, value)
+				position = len(args)
+				positions[name] = position
+			}
+			return "$" + strconv.Itoa(position)
+		case "mongodb":
+			literal, err := mongoVariableLiteral(value)
+			if err != nil {
+				bindErr = fmt.Errorf("variable %s: %v", name, err)
+				return match
+			}
+			return literal
+		default:
+			bindErr = fmt.Errorf("unsupported database type: %s", dbType)
+			return match
+		}
+	})
+
+	if bindErr != nil {
+		return "", nil, bindErr
+	}
+	return bound, args, nil
+}
+
+// RenderQueryVariables replaces variable placeholders in a query with their
+// values written as literals, giving a query that runs on its own
+func RenderQueryVariables(dbType, query string, values map[string]interface{}) (string, error) {
+	var renderErr error
+
+	rendered := variablePlaceholderRegex.ReplaceAllStringFunc(query, func(match string) string {
+		if renderErr != nil {
+			return match
+		}
+
+		name := variablePlaceholderRegex.FindStringSubmatch(match)[1]
+		value, ok := values[name]
+		if !ok {
+			renderErr = fmt.Errorf("no value for variable %s", name)
+			return match
+		}
+
+		var literal string
+		var err error
+		switch dbType {
+		case "postgresql":
+			literal, err = postgresVariableLiteral(value)
+		case "mongodb":
+			literal, err = mongoVariableLiteral(value)
+		default:
+			err = fmt.Errorf("unsupported database type: %s", dbType)
+		}
+		if err != nil {
+			renderErr = fmt.Errorf("variable %s: %v", name, err)
+			return match
+		}
+		return literal
+	})
+
+	if renderErr != nil {
+		return "", renderErr
+	}
+	return rendered, nil
+}
+
+// postgresVariableLiteral formats a value as a Postgres literal, quoting
+// strings and dates
+func postgresVariableLiteral(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case time.Time:
+		return "'" + v.Format(variableDateLayout) + "'", nil
+	case string:
+		return "'" + strings.ReplaceAll(v, "'", "''") + "'", nil
+	default:
+		return "", fmt.Errorf("unsupported value type %T", value)
+	}
+}
+
+// mongoVariableLiteral formats a value as a literal understood by the MongoDB
+// query parser. Strings that could change the structure of the query are rejected.
+func mongoVariableLiteral(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case time.Time:
+		return `"` + v.Format(time.RFC3339) + `"`, nil
+	case string:
+		if strings.ContainsAny(v, "\"{}[],:\\\n\r") {
+			return "", fmt.Errorf("value contains characters that aren't allowed in MongoDB filters")
+		}
+		return `"` + v + `"`, nil
+	default:
+		return "", fmt.Errorf("unsupported value type %T", value)
+	}
+}
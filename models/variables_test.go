@@ -0,0 +1,81 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRenderQueryVariables(t *testing.T) {
+	day := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		dbType  string
+		query   string
+		values  map[string]interface{}
+		want    string
+		wantErr bool
+	}{
+		{
+			name:   "postgres literals",
+			dbType: "postgresql",
+			query:  "SELECT * FROM orders WHERE region = {{region}} AND total > {{ min }} AND created_at >= {{range.start}}",
+			values: map[string]interface{}{"region": "EU", "min": int64(10), "range.start": day},
+			want:   "SELECT * FROM orders WHERE region = 'EU' AND total > 10 AND created_at >= '2024-03-01'",
+		},
+		{
+			name:   "postgres quotes",
+			dbType: "postgresql",
+			query:  "SELECT * FROM users WHERE name = {{name}}",
+			values: map[string]interface{}{"name": "O'Brien"},
+			want:   "SELECT * FROM users WHERE name = 'O''Brien'",
+		},
+		{
+			name:   "postgres float",
+			dbType: "postgresql",
+			query:  "SELECT {{ratio}}",
+			values: map[string]interface{}{"ratio": 0.25},
+			want:   "SELECT 0.25",
+		},
+		{
+			name:   "mongodb literals",
+			dbType: "mongodb",
+			query:  `bson.M{"region": {{region}}, "since": {{since}}}`,
+			values: map[string]interface{}{"region": "EU", "since": day},
+			want:   `bson.M{"region": "EU", "since": "2024-03-01T00:00:00Z"}`,
+		},
+		{
+			name:    "mongodb structure",
+			dbType:  "mongodb",
+			query:   `bson.M{"region": {{region}}}`,
+			values:  map[string]interface{}{"region": `EU", "admin": "true`},
+			wantErr: true,
+		},
+		{
+			name:    "missing value",
+			dbType:  "postgresql",
+			query:   "SELECT {{missing}}",
+			values:  map[string]interface{}{},
+			wantErr: true,
+		},
+		{
+			name:    "unsupported database",
+			dbType:  "mysql",
+			query:   "SELECT {{a}}",
+			values:  map[string]interface{}{"a": "b"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := RenderQueryVariables(tt.dbType, tt.query, tt.values)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("RenderQueryVariables() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("RenderQueryVariables() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
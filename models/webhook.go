@@ -0,0 +1,259 @@
+package models
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Webhook sends a user's lifecycle events to a URL. Requests are signed with
+// the webhook's secret, which is only shown when the webhook is created.
+type Webhook struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	UserID    primitive.ObjectID `json:"user_id" bson:"user_id"`
+	URL       string             `json:"url" bson:"url"`
+	Secret    string             `json:"-" bson:"secret"`
+	Events    []string           `json:"events" bson:"events"`
+	Active    bool               `json:"active" bson:"active"`
+	CreatedAt time.Time          `json:"created_at" bson:"created_at"`
+	UpdatedAt time.Time          `json:"updated_at" bson:"updated_at"`
+}
+
+// WebhookDeliveryStatus represents the outcome of a webhook delivery
+type WebhookDeliveryStatus string
+
+const (
+	WebhookDeliveryPending   WebhookDeliveryStatus = "pending"
+	WebhookDeliverySucceeded WebhookDeliveryStatus = "succeeded"
+	WebhookDeliveryFailed    WebhookDeliveryStatus = "failed"
+)
+
+// WebhookDelivery records an event sent to a webhook and its latest attempt.
+// Pending deliveries are retried until they succeed or run out of attempts.
+type WebhookDelivery struct {
+	ID             primitive.ObjectID    `json:"id" bson:"_id,omitempty"`
+	WebhookID      primitive.ObjectID    `json:"webhook_id" bson:"webhook_id"`
+	Event          string                `json:"event" bson:"event"`
+	Payload        string                `json:"payload" bson:"payload"` // Request body, as sent
+	Status         WebhookDeliveryStatus `json:"status" bson:"status"`
+	Attempts       int                   `json:"attempts" bson:"attempts"`
+	ResponseStatus int                   `json:"response_status,omitempty" bson:"response_status,omitempty"`
+	Error          string                `json:"error,omitempty" bson:"error,omitempty"`
+	CreatedAt      time.Time             `json:"created_at" bson:"created_at"`
+	LastAttemptAt  *time.Time            `json:"last_attempt_at,omitempty" bson:"last_attempt_at,omitempty"`
+	DeliveredAt    *time.Time            `json:"delivered_at,omitempty" bson:"delivered_at,omitempty"`
+}
+
+// webhookDeliveryRetention is how long deliveries are kept
+const webhookDeliveryRetention = 30 * 24 * time.Hour
+
+// webhookCollection returns the webhooks collection
+func (s *mongoStore) webhookCollection() *mongo.Collection {
+	return s.db.Collection("webhooks")
+}
+
+// webhookDeliveryCollection returns the webhook deliveries collection
+func (s *mongoStore) webhookDeliveryCollection() *mongo.Collection {
+	return s.db.Collection("webhook_deliveries")
+}
+
+// ensureWebhookIndexes creates the indexes webhooks are looked up by, and
+// deliveries are listed and expired by
+func (s *mongoStore) ensureWebhookIndexes(ctx context.Context) error {
+	_, err := s.webhookCollection().Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}},
+			Options: options.Index().SetName("webhook_user"),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = s.webhookDeliveryCollection().Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "webhook_id", Value: 1}, {Key: "created_at", Value: -1}},
+			Options: options.Index().SetName("webhook_delivery_webhook"),
+		},
+		{
+			Keys:    bson.D{{Key: "created_at", Value: 1}},
+			Options: options.Index().SetName("webhook_delivery_expiry").SetExpireAfterSeconds(int32(webhookDeliveryRetention.Seconds())),
+		},
+	})
+	return err
+}
+
+// CreateWebhook creates a new webhook with a random secret
+func (s *mongoStore) CreateWebhook(ctx context.Context, webhook *Webhook) (*Webhook, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, err
+	}
+	webhook.Secret = "whsec_" + hex.EncodeToString(buf)
+
+	// Set timestamps
+	now := time.Now()
+	webhook.CreatedAt = now
+	webhook.UpdatedAt = now
+
+	result, err := s.webhookCollection().InsertOne(ctx, webhook)
+	if err != nil {
+		return nil, err
+	}
+
+	// Set the ID
+	webhook.ID = result.InsertedID.(primitive.ObjectID)
+
+	return webhook, nil
+}
+
+// GetWebhookByID retrieves a webhook by ID
+func (s *mongoStore) GetWebhookByID(ctx context.Context, id primitive.ObjectID) (*Webhook, error) {
+	var webhook Webhook
+	err := s.webhookCollection().FindOne(ctx, bson.M{"_id": id}).Decode(&webhook)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &webhook, nil
+}
+
+// GetWebhooksByUserID retrieves all webhooks of a user
+func (s *mongoStore) GetWebhooksByUserID(ctx context.Context, userID primitive.ObjectID) ([]*Webhook, error) {
+	opts := options.Find().SetSort(bson.M{"created_at": -1})
+
+	cursor, err := s.webhookCollection().Find(ctx, bson.M{"user_id": userID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	webhooks := []*Webhook{}
+	if err := cursor.All(ctx, &webhooks); err != nil {
+		return nil, err
+	}
+
+	return webhooks, nil
+}
+
+// GetWebhooksForEvent retrieves the active webhooks of a user that subscribe
+// to an event
+func (s *mongoStore) GetWebhooksForEvent(ctx context.Context, userID primitive.ObjectID, event string) ([]*Webhook, error) {
+	cursor, err := s.webhookCollection().Find(ctx, bson.M{
+		"user_id": userID,
+		"active":  true,
+		"events":  event,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var webhooks []*Webhook
+	if err := cursor.All(ctx, &webhooks); err != nil {
+		return nil, err
+	}
+
+	return webhooks, nil
+}
+
+// UpdateWebhook updates a webhook's URL, events and whether it is active
+func (s *mongoStore) UpdateWebhook(ctx context.Context, webhook *Webhook) error {
+	webhook.UpdatedAt = time.Now()
+
+	_, err := s.webhookCollection().UpdateOne(
+		ctx,
+		bson.M{"_id": webhook.ID},
+		bson.M{"$set": bson.M{
+			"url":        webhook.URL,
+			"events":     webhook.Events,
+			"active":     webhook.Active,
+			"updated_at": webhook.UpdatedAt,
+		}},
+	)
+	return err
+}
+
+// DeleteWebhook deletes a webhook and its delivery log
+func (s *mongoStore) DeleteWebhook(ctx context.Context, id primitive.ObjectID) error {
+	if _, err := s.webhookCollection().DeleteOne(ctx, bson.M{"_id": id}); err != nil {
+		return err
+	}
+
+	_, err := s.webhookDeliveryCollection().DeleteMany(ctx, bson.M{"webhook_id": id})
+	return err
+}
+
+// CreateWebhookDelivery records a delivery before its first attempt
+func (s *mongoStore) CreateWebhookDelivery(ctx context.Context, delivery *WebhookDelivery) error {
+	delivery.Status = WebhookDeliveryPending
+	delivery.CreatedAt = time.Now()
+
+	result, err := s.webhookDeliveryCollection().InsertOne(ctx, delivery)
+	if err != nil {
+		return err
+	}
+
+	// Set the ID
+	delivery.ID = result.InsertedID.(primitive.ObjectID)
+
+	return nil
+}
+
+// GetWebhookDeliveryByID retrieves a webhook delivery by ID
+func (s *mongoStore) GetWebhookDeliveryByID(ctx context.Context, id primitive.ObjectID) (*WebhookDelivery, error) {
+	var delivery WebhookDelivery
+	err := s.webhookDeliveryCollection().FindOne(ctx, bson.M{"_id": id}).Decode(&delivery)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &delivery, nil
+}
+
+// UpdateWebhookDelivery records the outcome of a delivery attempt
+func (s *mongoStore) UpdateWebhookDelivery(ctx context.Context, delivery *WebhookDelivery) error {
+	_, err := s.webhookDeliveryCollection().UpdateOne(
+		ctx,
+		bson.M{"_id": delivery.ID},
+		bson.M{"$set": bson.M{
+			"status":          delivery.Status,
+			"attempts":        delivery.Attempts,
+			"response_status": delivery.ResponseStatus,
+			"error":           delivery.Error,
+			"last_attempt_at": delivery.LastAttemptAt,
+			"delivered_at":    delivery.DeliveredAt,
+		}},
+	)
+	return err
+}
+
+// GetWebhookDeliveries retrieves the most recent deliveries of a webhook
+func (s *mongoStore) GetWebhookDeliveries(ctx context.Context, webhookID primitive.ObjectID, limit int) ([]*WebhookDelivery, error) {
+	opts := options.Find().
+		SetSort(bson.M{"created_at": -1}).
+		SetLimit(int64(limit))
+
+	cursor, err := s.webhookDeliveryCollection().Find(ctx, bson.M{"webhook_id": webhookID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	deliveries := []*WebhookDelivery{}
+	if err := cursor.All(ctx, &deliveries); err != nil {
+		return nil, err
+	}
+
+	return deliveries, nil
+}
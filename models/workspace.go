@@ -0,0 +1,138 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Workspace separates the resources of an organization, such as "Finance" and
+// "Engineering", while its members keep a single account
+type Workspace struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	OrgID     primitive.ObjectID `json:"org_id" bson:"org_id"`
+	Name      string             `json:"name" bson:"name"`
+	CreatedBy primitive.ObjectID `json:"created_by" bson:"created_by"`
+	CreatedAt time.Time          `json:"created_at" bson:"created_at"`
+	UpdatedAt time.Time          `json:"updated_at" bson:"updated_at"`
+}
+
+// workspaceCollection returns the workspaces collection
+func (s *mongoStore) workspaceCollection() *mongo.Collection {
+	return s.db.Collection("workspaces")
+}
+
+// ensureWorkspaceIndexes indexes workspaces by their organization
+func (s *mongoStore) ensureWorkspaceIndexes(ctx context.Context) error {
+	_, err := s.workspaceCollection().Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "org_id", Value: 1}, {Key: "name", Value: 1}},
+			Options: options.Index().SetName("workspace_org"),
+		},
+	})
+	return err
+}
+
+// CreateWorkspace creates a new workspace in an organization
+func (s *mongoStore) CreateWorkspace(ctx context.Context, workspace *Workspace) (*Workspace, error) {
+	// Set timestamps
+	now := time.Now()
+	workspace.CreatedAt = now
+	workspace.UpdatedAt = now
+
+	result, err := s.workspaceCollection().InsertOne(ctx, workspace)
+	if err != nil {
+		return nil, err
+	}
+
+	// Set the ID
+	workspace.ID = result.InsertedID.(primitive.ObjectID)
+
+	return workspace, nil
+}
+
+// GetWorkspaceByID retrieves a workspace by ID
+func (s *mongoStore) GetWorkspaceByID(ctx context.Context, id primitive.ObjectID) (*Workspace, error) {
+	var workspace Workspace
+	err := s.workspaceCollection().FindOne(ctx, bson.M{"_id": id}).Decode(&workspace)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &workspace, nil
+}
+
+// GetWorkspacesByOrgID retrieves all workspaces of an organization
+func (s *mongoStore) GetWorkspacesByOrgID(ctx context.Context, orgID primitive.ObjectID) ([]*Workspace, error) {
+	opts := options.Find().SetSort(bson.M{"name": 1})
+
+	cursor, err := s.workspaceCollection().Find(ctx, bson.M{"org_id": orgID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	workspaces := []*Workspace{}
+	if err := cursor.All(ctx, &workspaces); err != nil {
+		return nil, err
+	}
+
+	return workspaces, nil
+}
+
+// UpdateWorkspace renames a workspace
+func (s *mongoStore) UpdateWorkspace(ctx context.Context, workspace *Workspace) error {
+	workspace.UpdatedAt = time.Now()
+
+	_, err := s.workspaceCollection().UpdateOne(
+		ctx,
+		bson.M{"_id": workspace.ID},
+		bson.M{"$set": bson.M{
+			"name":       workspace.Name,
+			"updated_at": workspace.UpdatedAt,
+		}},
+	)
+	return err
+}
+
+// DeleteWorkspace deletes a workspace. Its resources stay shared with the
+// organization but no longer belong to a workspace.
+func (s *mongoStore) DeleteWorkspace(ctx context.Context, id primitive.ObjectID) error {
+	if _, err := s.workspaceCollection().DeleteOne(ctx, bson.M{"_id": id}); err != nil {
+		return err
+	}
+
+	for _, collection := range s.resourceCollections() {
+		if _, err := collection.UpdateMany(ctx, bson.M{"workspace_id": id}, bson.M{"$unset": bson.M{"workspace_id": ""}}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteOrganizationWorkspaces deletes all workspaces of an organization
+func (s *mongoStore) deleteOrganizationWorkspaces(ctx context.Context, orgID primitive.ObjectID) error {
+	_, err := s.workspaceCollection().DeleteMany(ctx, bson.M{"org_id": orgID})
+	return err
+}
+
+// resourceCollections returns the collections of resources that can be shared
+// with an organization and its workspaces
+func (s *mongoStore) resourceCollections() []*mongo.Collection {
+	return []*mongo.Collection{s.databaseCollection(), s.queryCollection(), s.dashboardCollection()}
+}
+
+// workspaceFilter narrows a resource filter to a workspace. A zero ID leaves
+// the filter unchanged.
+func workspaceFilter(filter bson.M, workspaceID primitive.ObjectID) bson.M {
+	if !workspaceID.IsZero() {
+		filter["workspace_id"] = workspaceID
+	}
+	return filter
+}
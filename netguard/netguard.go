@@ -0,0 +1,141 @@
+// Package netguard makes outbound HTTP requests to URLs users configure, such
+// as webhooks and notification channels. It refuses to reach loopback,
+// private and link-local addresses, so users can't probe the server's network
+// or read cloud metadata endpoints through it.
+package netguard
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+// maxRedirects is how many redirects a request follows
+const maxRedirects = 5
+
+// ErrBlockedAddress is returned for destinations the server must not reach
+var ErrBlockedAddress = errors.New("destination is a loopback, private or link-local address")
+
+// blockedNetworks are the ranges IsBlocked refuses on top of the loopback,
+// private, link-local and unspecified ones the net package knows about
+var blockedNetworks = mustParseCIDRs(
+	"0.0.0.0/8",     // "This" network
+	"100.64.0.0/10", // Carrier-grade NAT, used by some clouds for metadata
+	"192.0.0.0/24",  // IETF protocol assignments
+	"198.18.0.0/15", // Benchmarking
+	"240.0.0.0/4",   // Reserved, including broadcast
+	"64:ff9b::/96",  // NAT64, which can map to any of the above
+)
+
+// IsBlocked reports whether an address is one the server must not reach
+func IsBlocked(ip net.IP) bool {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return true
+	}
+
+	for _, network := range blockedNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckURL checks a URL is http or https and its host only resolves to
+// addresses the server may reach, for telling users about a bad URL when they
+// save it. Requests are checked again when they're made, since DNS can change.
+func CheckURL(ctx context.Context, rawURL string) error {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Hostname() == "" {
+		return errors.New("url must be an http or https URL")
+	}
+
+	host := parsed.Hostname()
+	if ip := net.ParseIP(host); ip != nil {
+		if IsBlocked(ip) {
+			return ErrBlockedAddress
+		}
+		return nil
+	}
+
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return fmt.Errorf("failed to resolve %s: %w", host, err)
+	}
+	for _, addr := range addrs {
+		if IsBlocked(addr.IP) {
+			return ErrBlockedAddress
+		}
+	}
+	return nil
+}
+
+// NewClient returns an HTTP client that refuses to connect to blocked
+// addresses. Addresses are checked as they're dialed, after DNS resolution,
+// so a host can't resolve to a public address when checked and a private one
+// when used. Redirects are checked the same way.
+func NewClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   10 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control:   control,
+	}
+
+	// Proxies from the environment would be dialed instead of the destination
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+
+	return &http.Client{
+		Timeout:       timeout,
+		Transport:     transport,
+		CheckRedirect: checkRedirect,
+	}
+}
+
+// control refuses connections to blocked addresses as they're dialed
+func control(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil || IsBlocked(ip) {
+		return ErrBlockedAddress
+	}
+	return nil
+}
+
+// checkRedirect follows a bounded number of redirects, only to http or https
+// URLs whose host may be reached
+func checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= maxRedirects {
+		return fmt.Errorf("stopped after %d redirects", maxRedirects)
+	}
+	return CheckURL(req.Context(), req.URL.String())
+}
+
+// mustParseCIDRs parses a list of networks known to be valid
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, len(cidrs))
+	for i, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks[i] = network
+	}
+	return networks
+}
@@ -0,0 +1,358 @@
+// Package notify sends notifications through users' notification channels:
+// email address lists, Slack, generic webhooks and the in-app inbox. Each
+// notification is recorded as a delivery per channel and sent by a
+// background job, so failed deliveries are retried with backoff.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/zucced/goquery/mailer"
+	"github.com/zucced/goquery/models"
+	"github.com/zucced/goquery/netguard"
+	"github.com/zucced/goquery/webhooks"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// JobType is the type of the jobs that send deliveries
+const JobType = "channel_delivery"
+
+// requestTimeout bounds a single Slack or webhook request
+const requestTimeout = 10 * time.Second
+
+// Message is a notification to send through channels
+type Message struct {
+	Title string
+	Body  string
+	Link  string
+	Data  map[string]interface{} // Sent to generic webhooks
+}
+
+// Sender records deliveries for channels and sends them. A nil Sender drops
+// notifications, for callers started without channels.
+type Sender struct {
+	store  models.Store
+	mail   mailer.Mailer
+	client *http.Client
+}
+
+// NewSender creates a sender that emails through mail. Deliver must be
+// registered as the handler of JobType jobs.
+func NewSender(store models.Store, mail mailer.Mailer) *Sender {
+	return &Sender{
+		store:  store,
+		mail:   mail,
+		client: netguard.NewClient(requestTimeout),
+	}
+}
+
+// Send queues a message for each channel. Failures are logged rather than
+// returned, a notification never fails the action that caused it.
+func (s *Sender) Send(channels []*models.NotificationChannel, source string, msg *Message) {
+	if s == nil || len(channels) == 0 {
+		return
+	}
+
+	// The caller's context may be about to end, e.g. when a request returns
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	for _, channel := range channels {
+		if _, err := s.enqueue(ctx, channel, source, msg); err != nil {
+			log.Printf("Failed to queue notification for channel %s: %v", channel.ID.Hex(), err)
+		}
+	}
+}
+
+// SendTo queues a message for the channels of a user with the given IDs.
+// Channels deleted since they were chosen are skipped.
+func (s *Sender) SendTo(userID primitive.ObjectID, channelIDs []primitive.ObjectID, source string, msg *Message) {
+	if s == nil || len(channelIDs) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	channels, err := s.store.GetNotificationChannelsByUserID(ctx, userID, channelIDs)
+	cancel()
+	if err != nil {
+		log.Printf("Failed to load notification channels of user %s: %v", userID.Hex(), err)
+		return
+	}
+
+	s.Send(channels, source, msg)
+}
+
+// SendJobFailure queues a notice of a background job that failed on every
+// attempt for the channels that receive job failures
+func (s *Sender) SendJobFailure(job *models.Job, jobErr error) {
+	if s == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	channels, err := s.store.GetJobFailureChannels(ctx)
+	cancel()
+	if err != nil {
+		log.Printf("Failed to load job failure channels: %v", err)
+		return
+	}
+
+	s.Send(channels, models.NotificationSourceJobFailure, &Message{
+		Title: fmt.Sprintf("Background job %s failed", job.Type),
+		Body:  fmt.Sprintf("Job %s failed on all %d attempts: %s", job.ID.Hex(), job.Attempts, jobErr),
+		Data: map[string]interface{}{
+			"job_id":   job.ID,
+			"type":     job.Type,
+			"attempts": job.Attempts,
+			"error":    jobErr.Error(),
+		},
+	})
+}
+
+// Test queues a test message for a channel and returns its delivery
+func (s *Sender) Test(ctx context.Context, channel *models.NotificationChannel) (*models.ChannelDelivery, error) {
+	return s.enqueue(ctx, channel, models.NotificationSourceTest, &Message{
+		Title: "Test notification from GoQuery",
+		Body:  fmt.Sprintf("The channel %q is set up to receive notifications.", channel.Name),
+	})
+}
+
+// Record records a delivery that was sent some other way, e.g. with the
+// report email its addresses were added to, so it shows in the channel's log
+func (s *Sender) Record(ctx context.Context, channel *models.NotificationChannel, source string, msg *Message, sendErr error) {
+	if s == nil {
+		return
+	}
+
+	now := time.Now()
+	delivery := newDelivery(channel, source, msg)
+	delivery.Attempts = 1
+	delivery.LastAttemptAt = &now
+	delivery.Status = models.ChannelDeliverySent
+	delivery.DeliveredAt = &now
+	if sendErr != nil {
+		delivery.Status = models.ChannelDeliveryFailed
+		delivery.Error = sendErr.Error()
+		delivery.DeliveredAt = nil
+	}
+
+	if err := s.store.CreateChannelDelivery(ctx, delivery); err != nil {
+		log.Printf("Failed to record delivery for channel %s: %v", channel.ID.Hex(), err)
+	}
+}
+
+// newDelivery builds the delivery of a message through a channel
+func newDelivery(channel *models.NotificationChannel, source string, msg *Message) *models.ChannelDelivery {
+	return &models.ChannelDelivery{
+		ChannelID: channel.ID,
+		Source:    source,
+		Title:     msg.Title,
+		Body:      msg.Body,
+		Link:      msg.Link,
+		Data:      msg.Data,
+	}
+}
+
+// enqueue records a delivery of a message and queues the job that sends it
+func (s *Sender) enqueue(ctx context.Context, channel *models.NotificationChannel, source string, msg *Message) (*models.ChannelDelivery, error) {
+	delivery := newDelivery(channel, source, msg)
+	if err := s.store.CreateChannelDelivery(ctx, delivery); err != nil {
+		return nil, err
+	}
+
+	_, err := s.store.EnqueueJob(ctx, JobType, deliveryJob{DeliveryID: delivery.ID}, "", time.Time{})
+	if err != nil {
+		return nil, err
+	}
+
+	return delivery, nil
+}
+
+// deliveryJob is the payload of the jobs that send deliveries
+type deliveryJob struct {
+	DeliveryID primitive.ObjectID `bson:"delivery_id"`
+}
+
+// Deliver sends a delivery, the handler of JobType jobs. A failed attempt is
+// recorded on the delivery and returned so the job is retried; the delivery
+// fails for good with the job's last attempt.
+func (s *Sender) Deliver(ctx context.Context, job *models.Job) error {
+	var args deliveryJob
+	if err := job.DecodePayload(&args); err != nil {
+		return err
+	}
+
+	delivery, err := s.store.GetChannelDeliveryByID(ctx, args.DeliveryID)
+	if err != nil {
+		return err
+	}
+	if delivery == nil || delivery.Status != models.ChannelDeliveryPending {
+		return nil
+	}
+
+	now := time.Now()
+	delivery.Attempts++
+	delivery.LastAttemptAt = &now
+
+	channel, err := s.store.GetNotificationChannelByID(ctx, delivery.ChannelID)
+	if err != nil {
+		return err
+	}
+	if channel == nil {
+		delivery.Status = models.ChannelDeliveryFailed
+		delivery.Error = "channel was deleted"
+		return s.store.UpdateChannelDelivery(ctx, delivery)
+	}
+
+	sendErr := s.send(ctx, channel, delivery)
+	if sendErr == nil {
+		delivery.Status = models.ChannelDeliverySent
+		delivery.Error = ""
+		delivery.DeliveredAt = &now
+	} else {
+		delivery.Error = sendErr.Error()
+		if job.Attempts >= job.MaxAttempts {
+			delivery.Status = models.ChannelDeliveryFailed
+		}
+	}
+
+	if err := s.store.UpdateChannelDelivery(ctx, delivery); err != nil {
+		log.Printf("Failed to record attempt of channel delivery %s: %v", delivery.ID.Hex(), err)
+	}
+	return sendErr
+}
+
+// send sends a delivery through its channel
+func (s *Sender) send(ctx context.Context, channel *models.NotificationChannel, delivery *models.ChannelDelivery) error {
+	switch channel.Type {
+	case models.ChannelTypeEmail:
+		return s.sendEmail(ctx, channel, delivery)
+	case models.ChannelTypeSlack:
+		return s.sendSlack(ctx, channel, delivery)
+	case models.ChannelTypeWebhook:
+		return s.sendWebhook(ctx, channel, delivery)
+	case models.ChannelTypeInApp:
+		return s.store.CreateNotification(ctx, &models.Notification{
+			UserID:    channel.UserID,
+			ChannelID: channel.ID,
+			Source:    delivery.Source,
+			Title:     delivery.Title,
+			Body:      delivery.Body,
+			Link:      delivery.Link,
+		})
+	default:
+		return fmt.Errorf("unknown channel type %s", channel.Type)
+	}
+}
+
+// sendEmail emails a delivery to the channel's addresses
+func (s *Sender) sendEmail(ctx context.Context, channel *models.NotificationChannel, delivery *models.ChannelDelivery) error {
+	msg, err := mailer.Render(mailer.TemplateNotification, channel.Emails, map[string]interface{}{
+		"Title": delivery.Title,
+		"Body":  delivery.Body,
+		"Link":  delivery.Link,
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := s.mail.Send(ctx, msg); err != nil {
+		if errors.Is(err, mailer.ErrNotConfigured) {
+			return errors.New("email isn't configured on this server")
+		}
+		return err
+	}
+	return nil
+}
+
+// sendSlack posts a delivery to the channel's Slack incoming webhook
+func (s *Sender) sendSlack(ctx context.Context, channel *models.NotificationChannel, delivery *models.ChannelDelivery) error {
+	text := "*" + delivery.Title + "*"
+	if delivery.Body != "" {
+		text += "\n" + delivery.Body
+	}
+	if delivery.Link != "" {
+		text += "\n<" + delivery.Link + "|Open in GoQuery>"
+	}
+
+	payload, err := json.Marshal(map[string]string{"text": text})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, channel.SlackURL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	return s.do(req)
+}
+
+// webhookBody is the JSON body sent to generic webhook channels
+type webhookBody struct {
+	ID        primitive.ObjectID     `json:"id"`
+	Source    string                 `json:"source"`
+	Title     string                 `json:"title"`
+	Body      string                 `json:"body,omitempty"`
+	Link      string                 `json:"link,omitempty"`
+	Data      map[string]interface{} `json:"data,omitempty"`
+	CreatedAt time.Time              `json:"created_at"`
+}
+
+// sendWebhook posts a delivery to the channel's URL, signed like webhook
+// deliveries so receivers can check it came from GoQuery
+func (s *Sender) sendWebhook(ctx context.Context, channel *models.NotificationChannel, delivery *models.ChannelDelivery) error {
+	payload, err := json.Marshal(webhookBody{
+		ID:        delivery.ID,
+		Source:    delivery.Source,
+		Title:     delivery.Title,
+		Body:      delivery.Body,
+		Link:      delivery.Link,
+		Data:      delivery.Data,
+		CreatedAt: delivery.CreatedAt.UTC(),
+	})
+	if err != nil {
+		return err
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, channel.URL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "GoQuery-Notifications")
+	req.Header.Set(webhooks.EventHeader, "notification."+delivery.Source)
+	req.Header.Set(webhooks.DeliveryHeader, delivery.ID.Hex())
+	req.Header.Set(webhooks.TimestampHeader, timestamp)
+	req.Header.Set(webhooks.SignatureHeader, webhooks.Sign(channel.Secret, timestamp, payload))
+
+	return s.do(req)
+}
+
+// do sends a request. Responses other than 2xx are errors.
+func (s *Sender) do(req *http.Request) error {
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// Drain a little of the body so the connection can be reused
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("channel responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
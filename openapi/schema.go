@@ -0,0 +1,174 @@
+package openapi
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Schema is an OpenAPI schema object
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Pattern              string             `json:"pattern,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+}
+
+// Object describes a JSON object built inline by a handler, mapping each key
+// to a value of the type it holds. A nil value accepts anything.
+type Object map[string]any
+
+var (
+	timeType     = reflect.TypeOf(time.Time{})
+	objectIDType = reflect.TypeOf(primitive.ObjectID{})
+	objectType   = reflect.TypeOf(Object{})
+	rawType      = reflect.TypeOf(json.RawMessage{})
+)
+
+// schemas generates schemas from Go values, collecting named structs as
+// components so recursive and shared types are only described once
+type schemas struct {
+	components map[string]*Schema
+	names      map[reflect.Type]string
+}
+
+func newSchemas() *schemas {
+	return &schemas{
+		components: make(map[string]*Schema),
+		names:      make(map[reflect.Type]string),
+	}
+}
+
+// of returns the schema of a value, or nil for a nil value
+func (s *schemas) of(value any) *Schema {
+	if value == nil {
+		return nil
+	}
+	if object, ok := value.(Object); ok {
+		schema := &Schema{Type: "object", Properties: make(map[string]*Schema, len(object))}
+		for key, v := range object {
+			if property := s.of(v); property != nil {
+				schema.Properties[key] = property
+			} else {
+				schema.Properties[key] = &Schema{}
+			}
+		}
+		return schema
+	}
+	return s.forType(reflect.TypeOf(value))
+}
+
+// forType returns the schema of a Go type, following encoding/json's rules.
+// Types with custom JSON encodings are assumed to keep the shape of their fields.
+func (s *schemas) forType(t reflect.Type) *Schema {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch t {
+	case timeType:
+		return &Schema{Type: "string", Format: "date-time"}
+	case objectIDType:
+		return &Schema{Type: "string", Pattern: "^[0-9a-f]{24}$"}
+	case rawType, objectType:
+		return &Schema{}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint64:
+		return &Schema{Type: "integer", Format: "int64"}
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return &Schema{Type: "integer", Format: "int32"}
+	case reflect.Float32:
+		return &Schema{Type: "number", Format: "float"}
+	case reflect.Float64:
+		return &Schema{Type: "number", Format: "double"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte"}
+		}
+		return &Schema{Type: "array", Items: s.forType(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: s.forType(t.Elem())}
+	case reflect.Struct:
+		return s.forStruct(t)
+	default:
+		// Interfaces hold any value
+		return &Schema{}
+	}
+}
+
+// forStruct returns a reference to the component of a named struct, or the
+// inline schema of an anonymous one
+func (s *schemas) forStruct(t reflect.Type) *Schema {
+	if t.Name() == "" {
+		return s.structSchema(t)
+	}
+
+	name, ok := s.names[t]
+	if !ok {
+		name = s.componentName(t)
+		s.names[t] = name
+		// Register before walking the fields so recursive types can refer to it
+		s.components[name] = &Schema{}
+		*s.components[name] = *s.structSchema(t)
+	}
+	return &Schema{Ref: "#/components/schemas/" + name}
+}
+
+// componentName names a struct's component after its type, qualifying it with
+// its package when another package has a type of the same name
+func (s *schemas) componentName(t reflect.Type) string {
+	name := t.Name()
+	if _, taken := s.components[name]; !taken {
+		return name
+	}
+	pkg := t.PkgPath()[strings.LastIndex(t.PkgPath(), "/")+1:]
+	return strings.ToUpper(pkg[:1]) + pkg[1:] + name
+}
+
+// structSchema describes the exported fields of a struct, flattening embedded
+// structs the way encoding/json does
+func (s *schemas) structSchema(t reflect.Type) *Schema {
+	schema := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+
+		fieldType := field.Type
+		for fieldType.Kind() == reflect.Pointer {
+			fieldType = fieldType.Elem()
+		}
+		if field.Anonymous && name == "" && fieldType.Kind() == reflect.Struct {
+			for key, property := range s.structSchema(fieldType).Properties {
+				if _, ok := schema.Properties[key]; !ok {
+					schema.Properties[key] = property
+				}
+			}
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+
+		if name == "" {
+			name = field.Name
+		}
+		schema.Properties[name] = s.forType(field.Type)
+	}
+	return schema
+}
@@ -0,0 +1,317 @@
+// Package openapi generates an OpenAPI 3 document from the routes registered
+// with Fiber, described by the Go types their handlers read and write
+package openapi
+
+import (
+	"net/http"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Version is the OpenAPI version of generated documents
+const Version = "3.0.3"
+
+// Security requirements of an operation
+const (
+	SecurityBearer = "bearer" // A session token in the Authorization header
+	SecurityEmbed  = "embed"  // An embed token in the token query parameter or Authorization header
+	SecurityNone   = "none"   // Public
+)
+
+// Operation describes a route. Routes that aren't described are still
+// documented, with their path parameters and a generic response.
+type Operation struct {
+	OperationID string // Derived from the handler's name when empty
+	Summary     string
+	Security    string   // SecurityBearer when empty
+	Query       []string // Names of the query parameters the handler reads
+	Request     any      // A value of the type of the JSON request body
+	Response    any      // A value of the type of the JSON response body
+	Status      int      // Status code of a successful response, 200 when zero
+	ContentType string   // Content type of non-JSON responses
+}
+
+// Spec collects operation descriptions and builds the document
+type Spec struct {
+	title      string
+	version    string
+	operations map[string]Operation
+}
+
+// NewSpec creates a spec for an API
+func NewSpec(title, version string) *Spec {
+	return &Spec{
+		title:      title,
+		version:    version,
+		operations: make(map[string]Operation),
+	}
+}
+
+// Describe describes the route with a method and Fiber path, such as
+// "GET" and "/api/databases/:id"
+func (s *Spec) Describe(method, path string, op Operation) {
+	s.operations[method+" "+path] = op
+}
+
+// Document is an OpenAPI document
+type Document struct {
+	OpenAPI    string                               `json:"openapi"`
+	Info       Info                                 `json:"info"`
+	Paths      map[string]map[string]*PathOperation `json:"paths"`
+	Components Components                           `json:"components"`
+}
+
+// Info is the metadata of the API
+type Info struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+// Components holds the schemas and security schemes operations refer to
+type Components struct {
+	Schemas         map[string]*Schema         `json:"schemas"`
+	SecuritySchemes map[string]*SecurityScheme `json:"securitySchemes"`
+}
+
+// SecurityScheme is a way of authenticating requests
+type SecurityScheme struct {
+	Type         string `json:"type"`
+	Scheme       string `json:"scheme,omitempty"`
+	BearerFormat string `json:"bearerFormat,omitempty"`
+	Name         string `json:"name,omitempty"`
+	In           string `json:"in,omitempty"`
+}
+
+// PathOperation is an OpenAPI operation object
+type PathOperation struct {
+	OperationID string                `json:"operationId"`
+	Summary     string                `json:"summary,omitempty"`
+	Tags        []string              `json:"tags"`
+	Parameters  []Parameter           `json:"parameters,omitempty"`
+	RequestBody *RequestBody          `json:"requestBody,omitempty"`
+	Responses   map[string]*Response  `json:"responses"`
+	Security    []map[string][]string `json:"security"`
+}
+
+// Parameter is a path or query parameter
+type Parameter struct {
+	Name     string  `json:"name"`
+	In       string  `json:"in"`
+	Required bool    `json:"required"`
+	Schema   *Schema `json:"schema"`
+}
+
+// RequestBody is the body of a request
+type RequestBody struct {
+	Required bool                  `json:"required"`
+	Content  map[string]*MediaType `json:"content"`
+}
+
+// Response is a response of an operation
+type Response struct {
+	Description string                `json:"description"`
+	Content     map[string]*MediaType `json:"content,omitempty"`
+}
+
+// MediaType is the schema of a body in a content type
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+// ErrorResponse is the body handlers respond with when a request fails.
+// Request bodies that fail validation also list their invalid fields.
+type ErrorResponse struct {
+	Error  string       `json:"error"`
+	Fields []FieldError `json:"fields,omitempty"`
+}
+
+// FieldError describes why a single field of a request body is invalid
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// documentedMethods are the methods routes are documented for. Fiber
+// registers a HEAD route for every GET route, which is left out.
+var documentedMethods = map[string]bool{
+	fiber.MethodGet:    true,
+	fiber.MethodPost:   true,
+	fiber.MethodPut:    true,
+	fiber.MethodPatch:  true,
+	fiber.MethodDelete: true,
+}
+
+// Document builds the document of the given routes, typically
+// app.GetRoutes(true). Descriptions of routes that aren't registered, such
+// as optional features that are turned off, are left out.
+func (s *Spec) Document(routes []fiber.Route) *Document {
+	schemas := newSchemas()
+	doc := &Document{
+		OpenAPI: Version,
+		Info:    Info{Title: s.title, Version: s.version},
+		Paths:   make(map[string]map[string]*PathOperation),
+	}
+
+	errorSchema := schemas.of(ErrorResponse{})
+	routed := make(map[string]bool)
+	operationIDs := make(map[string]int)
+
+	for _, route := range routes {
+		if !documentedMethods[route.Method] || len(route.Handlers) == 0 {
+			continue
+		}
+		key := route.Method + " " + route.Path
+		if routed[key] {
+			continue
+		}
+		routed[key] = true
+
+		op := s.operations[key]
+		operation := &PathOperation{
+			OperationID: op.OperationID,
+			Summary:     op.Summary,
+			Tags:        []string{tag(route.Path)},
+			Responses:   make(map[string]*Response),
+			Security:    security(op.Security),
+		}
+
+		// Operation IDs must be unique, which handlers shared by routes aren't
+		if operation.OperationID == "" {
+			operation.OperationID = operationID(route)
+		}
+		operationIDs[operation.OperationID]++
+		if n := operationIDs[operation.OperationID]; n > 1 {
+			operation.OperationID += strconv.Itoa(n)
+		}
+
+		for _, param := range route.Params {
+			operation.Parameters = append(operation.Parameters, Parameter{
+				Name:     param,
+				In:       "path",
+				Required: true,
+				Schema:   &Schema{Type: "string"},
+			})
+		}
+		for _, param := range op.Query {
+			operation.Parameters = append(operation.Parameters, Parameter{
+				Name:   param,
+				In:     "query",
+				Schema: &Schema{Type: "string"},
+			})
+		}
+
+		if op.Request != nil {
+			operation.RequestBody = &RequestBody{
+				Required: true,
+				Content:  map[string]*MediaType{fiber.MIMEApplicationJSON: {Schema: schemas.of(op.Request)}},
+			}
+		}
+
+		status := op.Status
+		if status == 0 {
+			status = fiber.StatusOK
+		}
+		response := &Response{Description: http.StatusText(status)}
+		switch {
+		case op.ContentType != "":
+			response.Content = map[string]*MediaType{op.ContentType: {Schema: &Schema{Type: "string", Format: "binary"}}}
+		case op.Response != nil:
+			response.Content = map[string]*MediaType{fiber.MIMEApplicationJSON: {Schema: schemas.of(op.Response)}}
+		}
+		operation.Responses[strconv.Itoa(status)] = response
+		operation.Responses["default"] = &Response{
+			Description: "Error",
+			Content:     map[string]*MediaType{fiber.MIMEApplicationJSON: {Schema: errorSchema}},
+		}
+
+		path := openAPIPath(route.Path)
+		if doc.Paths[path] == nil {
+			doc.Paths[path] = make(map[string]*PathOperation)
+		}
+		doc.Paths[path][strings.ToLower(route.Method)] = operation
+	}
+
+	doc.Components = Components{
+		Schemas: schemas.components,
+		SecuritySchemes: map[string]*SecurityScheme{
+			SecurityBearer: {Type: "http", Scheme: "bearer", BearerFormat: "JWT"},
+			SecurityEmbed:  {Type: "apiKey", Name: "token", In: "query"},
+		},
+	}
+
+	return doc
+}
+
+// security returns the security requirements of an operation
+func security(scheme string) []map[string][]string {
+	switch scheme {
+	case SecurityNone:
+		return []map[string][]string{}
+	case "":
+		scheme = SecurityBearer
+	}
+	return []map[string][]string{{scheme: {}}}
+}
+
+// tag groups a route by the first segment after /api
+func tag(path string) string {
+	segments := strings.Split(strings.Trim(strings.TrimPrefix(path, "/api"), "/"), "/")
+	return segments[0]
+}
+
+// openAPIPath converts Fiber path parameters like :id to {id}
+func openAPIPath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if strings.HasPrefix(segment, ":") {
+			segments[i] = "{" + strings.TrimSuffix(segment[1:], "?") + "}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// operationID derives an operation ID from the name of the route's handler,
+// so SignupHandler becomes signup. Routes with anonymous handlers are named
+// after their method and path instead, so GET /health becomes getHealth.
+func operationID(route fiber.Route) string {
+	handler := route.Handlers[len(route.Handlers)-1]
+	if fn := runtime.FuncForPC(reflect.ValueOf(handler).Pointer()); fn != nil {
+		name := fn.Name()
+		name = name[strings.LastIndex(name, "/")+1:]
+		if parts := strings.Split(name, "."); len(parts) >= 2 && strings.HasSuffix(parts[1], "Handler") {
+			return lowerFirst(strings.TrimSuffix(parts[1], "Handler"))
+		}
+	}
+
+	var id strings.Builder
+	id.WriteString(strings.ToLower(route.Method))
+	for _, segment := range strings.FieldsFunc(route.Path, func(r rune) bool { return r == '/' || r == '-' }) {
+		if segment == "api" || strings.HasPrefix(segment, ":") {
+			continue
+		}
+		id.WriteString(strings.ToUpper(segment[:1]) + segment[1:])
+	}
+	return id.String()
+}
+
+// lowerFirst lowercases the leading initialism or letter of a name, so SSOLookup
+// becomes ssoLookup
+func lowerFirst(name string) string {
+	runes := []rune(name)
+	for i := range runes {
+		if !unicode.IsUpper(runes[i]) {
+			break
+		}
+		// Keep the start of the next word capitalized
+		if i > 0 && i+1 < len(runes) && unicode.IsLower(runes[i+1]) {
+			break
+		}
+		runes[i] = unicode.ToLower(runes[i])
+	}
+	return string(runes)
+}
@@ -0,0 +1,162 @@
+package origins
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zucced/goquery/config"
+	"github.com/zucced/goquery/models"
+)
+
+// refreshInterval is how long stored origins are used before they are read
+// again, which bounds how long other servers take to see a change
+const refreshInterval = 30 * time.Second
+
+// loadTimeout bounds how long a request waits for the stored origins
+const loadTimeout = 2 * time.Second
+
+// ErrWildcard is returned when * is added in production
+var ErrWildcard = errors.New("* can't be allowed in production, list the origins instead")
+
+// Allowlist decides which origins browsers may call the API from: the
+// configured ones and those added at runtime through the admin API, so the
+// hosted product can allow a customer's domain without a deploy.
+type Allowlist struct {
+	store      models.Store
+	configured []string
+	production bool
+
+	mu       sync.Mutex
+	stored   []string
+	loadedAt time.Time
+}
+
+// NewAllowlist creates an allowlist of the configured origins and those
+// stored in store. In production * can't be added at runtime.
+func NewAllowlist(store models.Store, configured []string, production bool) *Allowlist {
+	return &Allowlist{store: store, configured: configured, production: production}
+}
+
+// Allowed reports whether browsers may call the API from origin
+func (a *Allowlist) Allowed(origin string) bool {
+	if origin == "" {
+		return false
+	}
+	origin = strings.ToLower(origin)
+
+	for _, pattern := range a.configured {
+		if matches(pattern, origin) {
+			return true
+		}
+	}
+	for _, pattern := range a.storedOrigins() {
+		// A * stored before the server ran in production doesn't count
+		if pattern == "*" && a.production {
+			continue
+		}
+		if matches(pattern, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// Configured returns the origins allowed by ALLOW_ORIGINS
+func (a *Allowlist) Configured() []string {
+	return a.configured
+}
+
+// Normalize checks that an origin can be added and returns it the way it is
+// stored
+func (a *Allowlist) Normalize(origin string) (string, error) {
+	normalized, err := config.NormalizeOrigin(origin)
+	if err != nil {
+		return "", err
+	}
+	if normalized == "*" && a.production {
+		return "", ErrWildcard
+	}
+	return normalized, nil
+}
+
+// Add stores an allowed origin and allows it right away on this server
+func (a *Allowlist) Add(ctx context.Context, origin *models.AllowedOrigin) error {
+	if err := a.store.SaveAllowedOrigin(ctx, origin); err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, stored := range a.stored {
+		if stored == origin.Origin {
+			return nil
+		}
+	}
+	a.stored = append(a.stored, origin.Origin)
+	return nil
+}
+
+// Remove deletes a stored origin and stops allowing it right away on this
+// server, reporting whether it was stored
+func (a *Allowlist) Remove(ctx context.Context, origin string) (bool, error) {
+	deleted, err := a.store.DeleteAllowedOrigin(ctx, origin)
+	if err != nil || !deleted {
+		return deleted, err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	stored := make([]string, 0, len(a.stored))
+	for _, o := range a.stored {
+		if o != origin {
+			stored = append(stored, o)
+		}
+	}
+	a.stored = stored
+	return true, nil
+}
+
+// storedOrigins returns the stored origins, reading them again once they are
+// older than refreshInterval. Origins that can't be read keep their previous
+// values.
+func (a *Allowlist) storedOrigins() []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if time.Since(a.loadedAt) > refreshInterval {
+		ctx, cancel := context.WithTimeout(context.Background(), loadTimeout)
+		defer cancel()
+
+		origins, err := a.store.GetAllowedOrigins(ctx)
+		if err != nil {
+			log.Printf("Failed to load allowed origins: %v", err)
+		} else {
+			a.stored = make([]string, 0, len(origins))
+			for _, origin := range origins {
+				a.stored = append(a.stored, origin.Origin)
+			}
+		}
+		// Don't retry on every request while the store is unreachable
+		a.loadedAt = time.Now()
+	}
+
+	return a.stored
+}
+
+// matches reports whether an origin is allowed by a pattern: the same
+// origin, * for any origin, or scheme://*.domain for the subdomains of domain
+func matches(pattern, origin string) bool {
+	if pattern == "*" || pattern == origin {
+		return true
+	}
+
+	scheme, host, ok := strings.Cut(pattern, "://*.")
+	if !ok {
+		return false
+	}
+	return strings.HasPrefix(origin, scheme+"://") && strings.HasSuffix(origin, "."+host)
+}
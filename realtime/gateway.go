@@ -0,0 +1,377 @@
+package realtime
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Channels every user can subscribe to, carrying only their own messages
+const (
+	ChannelQueries       = "queries"       // Status changes of the user's queries
+	ChannelNotifications = "notifications" // Things that happened to the user, such as a dashboard being shared with them
+)
+
+// Kinds of the channels of a single resource, named "<kind>:<resource ID>"
+const (
+	ChannelDashboard = "dashboard" // Changes to a dashboard, the events of its live channel
+	ChannelSchema    = "schema"    // Schema refresh progress of a database
+)
+
+// DashboardChannel names the channel of the changes to a dashboard
+func DashboardChannel(dashboardID primitive.ObjectID) string {
+	return ChannelDashboard + ":" + dashboardID.Hex()
+}
+
+// SchemaChannel names the channel of the schema refresh progress of a database
+func SchemaChannel(databaseID primitive.ObjectID) string {
+	return ChannelSchema + ":" + databaseID.Hex()
+}
+
+// ParseChannel splits a resource channel into its kind and resource ID.
+// User channels are their own kind and have no ID.
+func ParseChannel(channel string) (kind string, id primitive.ObjectID, err error) {
+	kind, hex, found := strings.Cut(channel, ":")
+	if !found {
+		return kind, primitive.NilObjectID, nil
+	}
+	id, err = primitive.ObjectIDFromHex(hex)
+	return kind, id, err
+}
+
+// Message is sent to the gateway connections subscribed to its channel
+type Message struct {
+	// ID orders the messages published on channels, so clients can resume
+	// after the last one they received. Replies to a single connection have none.
+	ID      uint64      `json:"id,omitempty"`
+	Channel string      `json:"channel,omitempty"`
+	Type    string      `json:"type"`
+	Data    interface{} `json:"data,omitempty"`
+	At      time.Time   `json:"at"`
+
+	// Origin is the connection the message was caused by, which doesn't
+	// receive it
+	Origin string `json:"-"`
+}
+
+// clientBuffer is the number of messages queued for a client before it is
+// considered too slow and disconnected
+const clientBuffer = 64
+
+// historySize is the number of recent messages kept for clients resuming
+// after a dropped connection
+const historySize = 1024
+
+// sent is a message kept in the history, with the user it was sent to when it
+// wasn't published to every subscriber
+type sent struct {
+	msg    Message
+	userID primitive.ObjectID
+}
+
+// Client is a single connection to the gateway. Its messages are closed when
+// it disconnects or is dropped for falling behind.
+type Client struct {
+	ID       string
+	UserID   primitive.ObjectID
+	Messages <-chan Message
+
+	messages chan Message
+	channels map[string]bool
+}
+
+// Gateway multiplexes server-push channels over each user's connections. It
+// keeps a registry of the connections of every user, and which channels each
+// of them subscribed to.
+type Gateway struct {
+	mu       sync.Mutex
+	clients  map[primitive.ObjectID]map[string]*Client
+	channels map[string]map[string]*Client
+
+	// lastID is the ID of the latest message, and history the latest
+	// messages, oldest first
+	lastID  uint64
+	history []sent
+
+	// relay shares messages with the gateways of other servers, if any
+	relay *Relay
+}
+
+// NewGateway creates a gateway without connections
+func NewGateway() *Gateway {
+	return &Gateway{
+		clients:  make(map[primitive.ObjectID]map[string]*Client),
+		channels: make(map[string]map[string]*Client),
+	}
+}
+
+// Connect registers a connection of a user
+func (g *Gateway) Connect(userID primitive.ObjectID) *Client {
+	messages := make(chan Message, clientBuffer)
+	client := &Client{
+		ID:       primitive.NewObjectID().Hex(),
+		UserID:   userID,
+		Messages: messages,
+		messages: messages,
+		channels: make(map[string]bool),
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	clients, ok := g.clients[userID]
+	if !ok {
+		clients = make(map[string]*Client)
+		g.clients[userID] = clients
+	}
+	clients[client.ID] = client
+	return client
+}
+
+// Disconnect removes a connection and closes its messages
+func (g *Gateway) Disconnect(client *Client) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.remove(client)
+}
+
+// Subscribe adds a channel to a connection. Callers check that the user may
+// see the channel.
+func (g *Gateway) Subscribe(client *Client, channel string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.connected(client) {
+		g.subscribe(client, channel)
+	}
+}
+
+// Unsubscribe removes a channel from a connection
+func (g *Gateway) Unsubscribe(client *Client, channel string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	delete(client.channels, channel)
+	g.unsubscribe(client, channel)
+}
+
+// Publish sends a message to every connection subscribed to its channel, on
+// this server and, with a relay, on every other server
+func (g *Gateway) Publish(msg Message) {
+	if msg.At.IsZero() {
+		msg.At = time.Now()
+	}
+
+	g.publish(msg)
+	g.relay.send(envelope{Message: &msg})
+}
+
+// publish sends a message to the local connections subscribed to its channel
+func (g *Gateway) publish(msg Message) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	msg = g.record(msg, primitive.NilObjectID)
+	subscribers := make([]*Client, 0, len(g.channels[msg.Channel]))
+	for _, client := range g.channels[msg.Channel] {
+		subscribers = append(subscribers, client)
+	}
+	g.deliver(subscribers, msg)
+}
+
+// SendToUser sends a message to the connections of a single user that
+// subscribed to its channel, wherever they are connected
+func (g *Gateway) SendToUser(userID primitive.ObjectID, msg Message) {
+	if msg.At.IsZero() {
+		msg.At = time.Now()
+	}
+
+	g.sendToUser(userID, msg)
+	g.relay.send(envelope{Message: &msg, UserID: userID})
+}
+
+// sendToUser sends a message to the local connections of a user that
+// subscribed to its channel
+func (g *Gateway) sendToUser(userID primitive.ObjectID, msg Message) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	msg = g.record(msg, userID)
+	var subscribed []*Client
+	for _, client := range g.clients[userID] {
+		if client.channels[msg.Channel] {
+			subscribed = append(subscribed, client)
+		}
+	}
+	g.deliver(subscribed, msg)
+}
+
+// Notify sends a notification to a user
+func (g *Gateway) Notify(userID primitive.ObjectID, notificationType string, data interface{}) {
+	g.SendToUser(userID, Message{Channel: ChannelNotifications, Type: notificationType, Data: data})
+}
+
+// Reply sends a message to a single connection, whatever it subscribed to
+func (g *Gateway) Reply(client *Client, msg Message) {
+	if msg.At.IsZero() {
+		msg.At = time.Now()
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.connected(client) {
+		g.deliver([]*Client{client}, msg)
+	}
+}
+
+// Resume subscribes a connection to channels and sends it the messages of
+// those channels published after lastID, as if it had never been
+// disconnected. When some of them are no longer kept it sends a "resync"
+// message instead, telling the client to reload what it shows.
+func (g *Gateway) Resume(client *Client, channels []string, lastID uint64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if !g.connected(client) {
+		return
+	}
+	for _, channel := range channels {
+		g.subscribe(client, channel)
+	}
+	if lastID == g.lastID {
+		return
+	}
+
+	// IDs start over when the server restarts
+	complete := lastID < g.lastID && len(g.history) > 0 && lastID+1 >= g.history[0].msg.ID
+
+	var missed []Message
+	if complete {
+		for _, entry := range g.history {
+			if entry.msg.ID <= lastID || !client.channels[entry.msg.Channel] || client.ID == entry.msg.Origin {
+				continue
+			}
+			if !entry.userID.IsZero() && entry.userID != client.UserID {
+				continue
+			}
+			missed = append(missed, entry.msg)
+		}
+	}
+	if !complete || len(missed) >= clientBuffer {
+		missed = []Message{{ID: g.lastID, Type: "resync", At: time.Now()}}
+	}
+
+	g.deliver([]*Client{client}, missed...)
+}
+
+// Connections returns the number of open connections of a user
+func (g *Gateway) Connections(userID primitive.ObjectID) int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return len(g.clients[userID])
+}
+
+// Close removes every connection and closes their messages, which ends the
+// connections
+func (g *Gateway) Close() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for _, clients := range g.clients {
+		for _, client := range clients {
+			g.remove(client)
+		}
+	}
+}
+
+// record assigns a message the next ID and keeps it in the history, along
+// with the user it is sent to if any. Callers must hold g.mu.
+func (g *Gateway) record(msg Message, userID primitive.ObjectID) Message {
+	g.lastID++
+	msg.ID = g.lastID
+
+	if len(g.history) == historySize {
+		copy(g.history, g.history[1:])
+		g.history = g.history[:historySize-1]
+	}
+	g.history = append(g.history, sent{msg: msg, userID: userID})
+	return msg
+}
+
+// deliver queues messages for clients and drops those whose buffers are
+// full. Callers must hold g.mu.
+func (g *Gateway) deliver(clients []*Client, msgs ...Message) {
+	var slow []*Client
+	for _, client := range clients {
+	queue:
+		for _, msg := range msgs {
+			if client.ID == msg.Origin {
+				continue
+			}
+			select {
+			case client.messages <- msg:
+			default:
+				slow = append(slow, client)
+				break queue
+			}
+		}
+	}
+
+	for _, client := range slow {
+		g.remove(client)
+	}
+}
+
+// connected reports whether a client is registered. Callers must hold g.mu.
+func (g *Gateway) connected(client *Client) bool {
+	_, ok := g.clients[client.UserID][client.ID]
+	return ok
+}
+
+// remove deletes a client with its subscriptions and closes its messages.
+// Callers must hold g.mu.
+func (g *Gateway) remove(client *Client) {
+	if !g.connected(client) {
+		return
+	}
+
+	for channel := range client.channels {
+		g.unsubscribe(client, channel)
+	}
+
+	clients := g.clients[client.UserID]
+	delete(clients, client.ID)
+	if len(clients) == 0 {
+		delete(g.clients, client.UserID)
+	}
+	close(client.messages)
+}
+
+// subscribe adds a client to a channel's subscribers. Callers must hold g.mu.
+func (g *Gateway) subscribe(client *Client, channel string) {
+	client.channels[channel] = true
+	subscribers, ok := g.channels[channel]
+	if !ok {
+		subscribers = make(map[string]*Client)
+		g.channels[channel] = subscribers
+	}
+	subscribers[client.ID] = client
+}
+
+// unsubscribe removes a client from a channel's subscribers. Callers must
+// hold g.mu.
+func (g *Gateway) unsubscribe(client *Client, channel string) {
+	subscribers, ok := g.channels[channel]
+	if !ok {
+		return
+	}
+	delete(subscribers, client.ID)
+	if len(subscribers) == 0 {
+		delete(g.channels, channel)
+	}
+}
@@ -0,0 +1,230 @@
+package realtime
+
+import (
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// EventType identifies the kind of change an event describes
+type EventType string
+
+const (
+	EventViewers          EventType = "viewers"
+	EventDashboardUpdated EventType = "dashboard_updated"
+	EventDashboardDeleted EventType = "dashboard_deleted"
+	EventCardAdded        EventType = "card_added"
+	EventCardUpdated      EventType = "card_updated"
+	EventCardDeleted      EventType = "card_deleted"
+	EventCardsMoved       EventType = "cards_moved"
+	EventCardsRefreshed   EventType = "cards_refreshed"
+	EventCommentAdded     EventType = "comment_added"
+	EventCommentUpdated   EventType = "comment_updated"
+	EventCommentDeleted   EventType = "comment_deleted"
+)
+
+// Event is a change to a dashboard sent to its connected viewers
+type Event struct {
+	Type         EventType          `json:"type"`
+	DashboardID  primitive.ObjectID `json:"dashboard_id"`
+	UserID       primitive.ObjectID `json:"user_id,omitempty"`       // User who made the change
+	ConnectionID string             `json:"connection_id,omitempty"` // Connection the change came from, if any
+	Data         interface{}        `json:"data,omitempty"`
+	At           time.Time          `json:"at"`
+}
+
+// subscriberBuffer is the number of events queued for a subscriber before it
+// is considered too slow and disconnected
+const subscriberBuffer = 32
+
+// Subscription receives the events of a single dashboard
+type Subscription struct {
+	ID          string
+	DashboardID primitive.ObjectID
+	UserID      primitive.ObjectID
+	Events      <-chan Event
+
+	events chan Event
+}
+
+// Hub fans dashboard events out to the connections viewing each dashboard
+type Hub struct {
+	mu          sync.Mutex
+	subscribers map[primitive.ObjectID]map[string]*Subscription
+	gateway     *Gateway
+	// relay shares events with the hubs of other servers, if any
+	relay *Relay
+}
+
+// NewHub creates an empty hub
+func NewHub() *Hub {
+	return &Hub{
+		subscribers: make(map[primitive.ObjectID]map[string]*Subscription),
+	}
+}
+
+// ForwardTo also publishes every event on the dashboard's gateway channel, so
+// gateway connections can follow dashboards. It must be called before the hub
+// is used.
+func (h *Hub) ForwardTo(gateway *Gateway) {
+	h.gateway = gateway
+}
+
+// Subscribe registers a connection of a user viewing a dashboard and announces
+// the updated viewer list
+func (h *Hub) Subscribe(dashboardID, userID primitive.ObjectID) *Subscription {
+	events := make(chan Event, subscriberBuffer)
+	sub := &Subscription{
+		ID:          primitive.NewObjectID().Hex(),
+		DashboardID: dashboardID,
+		UserID:      userID,
+		Events:      events,
+		events:      events,
+	}
+
+	h.mu.Lock()
+	subs, ok := h.subscribers[dashboardID]
+	if !ok {
+		subs = make(map[string]*Subscription)
+		h.subscribers[dashboardID] = subs
+	}
+	subs[sub.ID] = sub
+	h.broadcastViewers(dashboardID)
+	h.mu.Unlock()
+
+	return sub
+}
+
+// Unsubscribe removes a connection and closes its event channel
+func (h *Hub) Unsubscribe(sub *Subscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.remove(sub) {
+		h.broadcastViewers(sub.DashboardID)
+	}
+}
+
+// Close removes every connection and closes their event channels, which ends
+// the live connections
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, subs := range h.subscribers {
+		for _, sub := range subs {
+			h.remove(sub)
+		}
+	}
+}
+
+// Publish sends an event to every connection viewing the event's dashboard
+// except the one it came from, on this server and, with a relay, on every
+// other server
+func (h *Hub) Publish(event Event) {
+	if event.At.IsZero() {
+		event.At = time.Now()
+	}
+
+	h.publish(event)
+	h.relay.send(envelope{Event: &event})
+}
+
+// publish sends an event to the local connections viewing its dashboard
+func (h *Hub) publish(event Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.send(event)
+}
+
+// Viewers returns the IDs of the users currently viewing a dashboard
+func (h *Hub) Viewers(dashboardID primitive.ObjectID) []primitive.ObjectID {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.viewers(dashboardID)
+}
+
+// send delivers an event and drops subscribers whose buffers are full.
+// Callers must hold h.mu.
+func (h *Hub) send(event Event) {
+	var slow []*Subscription
+	for id, sub := range h.subscribers[event.DashboardID] {
+		if id == event.ConnectionID {
+			continue
+		}
+		select {
+		case sub.events <- event:
+		default:
+			slow = append(slow, sub)
+		}
+	}
+
+	for _, sub := range slow {
+		h.remove(sub)
+	}
+	if len(slow) > 0 {
+		h.broadcastViewers(event.DashboardID)
+	}
+
+	if h.gateway != nil {
+		// The relay already carries the event to other servers' hubs
+		h.gateway.publish(Message{
+			Channel: DashboardChannel(event.DashboardID),
+			Type:    string(event.Type),
+			Data:    event,
+			At:      event.At,
+			Origin:  event.ConnectionID,
+		})
+	}
+}
+
+// remove deletes a subscriber and reports whether it was registered.
+// Callers must hold h.mu.
+func (h *Hub) remove(sub *Subscription) bool {
+	subs, ok := h.subscribers[sub.DashboardID]
+	if !ok {
+		return false
+	}
+	if _, ok := subs[sub.ID]; !ok {
+		return false
+	}
+
+	delete(subs, sub.ID)
+	close(sub.events)
+	if len(subs) == 0 {
+		delete(h.subscribers, sub.DashboardID)
+	}
+	return true
+}
+
+// broadcastViewers tells every connection on a dashboard who is viewing it.
+// Callers must hold h.mu.
+func (h *Hub) broadcastViewers(dashboardID primitive.ObjectID) {
+	if _, ok := h.subscribers[dashboardID]; !ok {
+		return
+	}
+
+	h.send(Event{
+		Type:        EventViewers,
+		DashboardID: dashboardID,
+		Data:        h.viewers(dashboardID),
+		At:          time.Now(),
+	})
+}
+
+// viewers lists the distinct users subscribed to a dashboard.
+// Callers must hold h.mu.
+func (h *Hub) viewers(dashboardID primitive.ObjectID) []primitive.ObjectID {
+	seen := make(map[primitive.ObjectID]bool)
+	viewers := []primitive.ObjectID{}
+	for _, sub := range h.subscribers[dashboardID] {
+		if !seen[sub.UserID] {
+			seen[sub.UserID] = true
+			viewers = append(viewers, sub.UserID)
+		}
+	}
+	return viewers
+}
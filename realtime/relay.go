@@ -0,0 +1,132 @@
+package realtime
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// relayChannel is the Redis channel servers share events and messages on
+const relayChannel = "goquery:realtime"
+
+// relayBuffer is the number of outgoing events queued while Redis is slow
+// before new ones are dropped
+const relayBuffer = 256
+
+// envelope is an event or message passed between servers
+type envelope struct {
+	Node    string             `json:"node"`
+	Event   *Event             `json:"event,omitempty"`
+	Message *Message           `json:"message,omitempty"`
+	Origin  string             `json:"origin,omitempty"`
+	UserID  primitive.ObjectID `json:"user_id,omitempty"`
+}
+
+// Relay shares a server's dashboard events and gateway messages with every
+// other server through Redis pub/sub, so connections receive changes made
+// through any server
+type Relay struct {
+	node     string
+	client   *redis.Client
+	outgoing chan envelope
+}
+
+// StartRelay relays the events of hub and the messages of gateway through
+// Redis until ctx is done. It must be called before the hub and gateway are
+// used.
+func StartRelay(ctx context.Context, client *redis.Client, hub *Hub, gateway *Gateway) {
+	r := &Relay{
+		node:     primitive.NewObjectID().Hex(),
+		client:   client,
+		outgoing: make(chan envelope, relayBuffer),
+	}
+	hub.relay = r
+	gateway.relay = r
+
+	go r.publish(ctx)
+	go r.receive(ctx, hub, gateway)
+}
+
+// send queues an event or message for the other servers. It never blocks, so
+// it can be called while holding locks, and does nothing without a relay.
+func (r *Relay) send(env envelope) {
+	if r == nil {
+		return
+	}
+	env.Node = r.node
+	if env.Message != nil {
+		env.Origin = env.Message.Origin
+	}
+
+	select {
+	case r.outgoing <- env:
+	default:
+		log.Printf("Realtime relay is falling behind, dropping an event")
+	}
+}
+
+// publish sends queued events and messages to Redis until ctx is done
+func (r *Relay) publish(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case env := <-r.outgoing:
+			data, err := json.Marshal(env)
+			if err != nil {
+				log.Printf("Failed to encode realtime event: %v", err)
+				continue
+			}
+
+			publishCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+			err = r.client.Publish(publishCtx, relayChannel, data).Err()
+			cancel()
+			if err != nil {
+				log.Printf("Failed to relay realtime event: %v", err)
+			}
+		}
+	}
+}
+
+// receive delivers the events and messages of other servers to the local hub
+// and gateway until ctx is done
+func (r *Relay) receive(ctx context.Context, hub *Hub, gateway *Gateway) {
+	pubsub := r.client.Subscribe(ctx, relayChannel)
+	defer pubsub.Close()
+
+	messages := pubsub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case received, ok := <-messages:
+			if !ok {
+				return
+			}
+
+			var env envelope
+			if err := json.Unmarshal([]byte(received.Payload), &env); err != nil {
+				log.Printf("Failed to decode realtime event: %v", err)
+				continue
+			}
+			if env.Node == r.node {
+				continue
+			}
+
+			switch {
+			case env.Event != nil:
+				hub.publish(*env.Event)
+			case env.Message != nil && env.UserID.IsZero():
+				env.Message.Origin = env.Origin
+				gateway.publish(*env.Message)
+			case env.Message != nil:
+				env.Message.Origin = env.Origin
+				gateway.sendToUser(env.UserID, *env.Message)
+			}
+		}
+	}
+}
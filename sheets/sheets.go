@@ -0,0 +1,413 @@
+package sheets
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/zucced/goquery/config"
+	"github.com/zucced/goquery/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/endpoints"
+)
+
+// CallbackPath is where Google sends users back after they allow access
+const CallbackPath = "/api/integrations/google/callback"
+
+// stateAudience is the audience of state tokens, so they can't be used as sessions
+const stateAudience = "goquery-google-state"
+
+// stateExpiry is how long a user has to allow access at Google
+const stateExpiry = 10 * time.Minute
+
+// Limits of Google Sheets
+const (
+	MaxCells      = 10000000
+	maxCellLength = 50000
+)
+
+// wholeSheet is the range of every cell of the first sheet
+const wholeSheet = "A:ZZZ"
+
+const (
+	sheetsAPI   = "https://sheets.googleapis.com/v4/spreadsheets"
+	userInfoAPI = "https://www.googleapis.com/oauth2/v2/userinfo"
+	revokeAPI   = "https://oauth2.googleapis.com/revoke"
+)
+
+var (
+	// ErrNotConnected is returned when the user hasn't connected a Google account
+	ErrNotConnected = errors.New("connect your Google account to export to Google Sheets")
+
+	// ErrUnauthorized is returned when Google no longer accepts the user's token
+	ErrUnauthorized = errors.New("Google access was revoked, connect your Google account again")
+
+	// errNotFound is returned when a spreadsheet was deleted
+	errNotFound = errors.New("spreadsheet not found")
+)
+
+// scopes only let the app manage the spreadsheets it created
+var scopes = []string{
+	"https://www.googleapis.com/auth/drive.file",
+	"https://www.googleapis.com/auth/userinfo.email",
+}
+
+// stateClaims ties Google's callback to the user who started connecting
+type stateClaims struct {
+	UserID string `json:"user_id"`
+	jwt.RegisteredClaims
+}
+
+// Spreadsheet is a spreadsheet created for an export
+type Spreadsheet struct {
+	ID  string `json:"spreadsheetId"`
+	URL string `json:"spreadsheetUrl"`
+}
+
+// NewConfig returns the OAuth2 client for Google, or nil when exporting to
+// Google Sheets isn't configured
+func NewConfig(cfg *config.Config) *oauth2.Config {
+	if cfg.GoogleClientID == "" || cfg.GoogleClientSecret == "" {
+		return nil
+	}
+
+	return &oauth2.Config{
+		ClientID:     cfg.GoogleClientID,
+		ClientSecret: cfg.GoogleClientSecret,
+		RedirectURL:  cfg.PublicURL + CallbackPath,
+		Endpoint:     endpoints.Google,
+		Scopes:       scopes,
+	}
+}
+
+// AuthCodeURL returns the Google URL a user is sent to for allowing access.
+// Consent is always asked for so Google returns a refresh token.
+func AuthCodeURL(conf *oauth2.Config, state string) string {
+	return conf.AuthCodeURL(state, oauth2.AccessTypeOffline, oauth2.ApprovalForce)
+}
+
+// Exchange redeems the code Google sent back and returns the connection of
+// the account that allowed access
+func Exchange(ctx context.Context, conf *oauth2.Config, userID primitive.ObjectID, code string) (*models.GoogleConnection, error) {
+	token, err := conf.Exchange(ctx, code)
+	if err != nil {
+		return nil, err
+	}
+	if token.RefreshToken == "" {
+		return nil, errors.New("Google didn't return a refresh token")
+	}
+
+	var info struct {
+		Email string `json:"email"`
+	}
+	if err := call(ctx, conf.Client(ctx, token), http.MethodGet, userInfoAPI, nil, &info); err != nil {
+		return nil, err
+	}
+
+	return &models.GoogleConnection{
+		UserID:       userID,
+		Email:        info.Email,
+		AccessToken:  token.AccessToken,
+		RefreshToken: token.RefreshToken,
+		TokenType:    token.TokenType,
+		Expiry:       token.Expiry,
+		ConnectedAt:  time.Now(),
+	}, nil
+}
+
+// Revoke asks Google to forget a connection's access, so the app no longer
+// appears in the account's third-party access
+func Revoke(ctx context.Context, conn *models.GoogleConnection) error {
+	form := url.Values{"token": {conn.RefreshToken}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, revokeAPI, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// A token Google already forgot can't be revoked again
+	if resp.StatusCode >= 300 && resp.StatusCode != http.StatusBadRequest {
+		return fmt.Errorf("Google revoke failed: %s", resp.Status)
+	}
+	return nil
+}
+
+// NewState returns a signed state for connecting a user's Google account
+func NewState(userID primitive.ObjectID, secret string) (string, error) {
+	now := time.Now()
+	claims := &stateClaims{
+		UserID: userID.Hex(),
+		RegisteredClaims: jwt.RegisteredClaims{
+			Audience:  jwt.ClaimStrings{stateAudience},
+			ExpiresAt: jwt.NewNumericDate(now.Add(stateExpiry)),
+			IssuedAt:  jwt.NewNumericDate(now),
+		},
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+}
+
+// ParseState verifies a state returned by Google and returns the user
+// connecting their account
+func ParseState(state, secret string) (primitive.ObjectID, error) {
+	token, err := jwt.ParseWithClaims(state, &stateClaims{}, func(token *jwt.Token) (any, error) {
+		return []byte(secret), nil
+	}, jwt.WithAudience(stateAudience), jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	if err != nil || !token.Valid {
+		return primitive.NilObjectID, errors.New("invalid or expired state")
+	}
+
+	userID, err := primitive.ObjectIDFromHex(token.Claims.(*stateClaims).UserID)
+	if err != nil {
+		return primitive.NilObjectID, errors.New("invalid user in state")
+	}
+	return userID, nil
+}
+
+// Export writes results to an export's spreadsheet as the user who set it
+// up, replacing what the first sheet had. The spreadsheet is created on the
+// first export, and again when it was deleted. The outcome is recorded on
+// the export.
+func Export(ctx context.Context, store models.Store, conf *oauth2.Config, export *models.SheetExport, columns []models.ResultColumn, results []models.QueryResult) error {
+	err := write(ctx, store, conf, export, Rows(columns, results))
+	if recordErr := store.RecordSheetExport(ctx, export, len(results), err, time.Now()); recordErr != nil && err == nil {
+		return recordErr
+	}
+	return err
+}
+
+// write writes rows to an export's spreadsheet, saving the user's token when
+// it was refreshed
+func write(ctx context.Context, store models.Store, conf *oauth2.Config, export *models.SheetExport, rows [][]interface{}) error {
+	if len(rows) > 0 && len(rows)*len(rows[0]) > MaxCells {
+		return fmt.Errorf("the results have more than %d cells", MaxCells)
+	}
+
+	conn, err := store.GetGoogleConnection(ctx, export.UserID)
+	if err != nil {
+		return err
+	}
+	if conn == nil {
+		return ErrNotConnected
+	}
+
+	token := &oauth2.Token{
+		AccessToken:  conn.AccessToken,
+		RefreshToken: conn.RefreshToken,
+		TokenType:    conn.TokenType,
+		Expiry:       conn.Expiry,
+	}
+	source := oauth2.ReuseTokenSource(token, conf.TokenSource(ctx, token))
+	client := oauth2.NewClient(ctx, source)
+	defer saveToken(ctx, store, conn, source)
+
+	if export.SpreadsheetID != "" {
+		err := writeValues(ctx, client, export.SpreadsheetID, rows)
+		if !errors.Is(err, errNotFound) {
+			return err
+		}
+	}
+
+	var spreadsheet Spreadsheet
+	body := map[string]interface{}{"properties": map[string]string{"title": export.Title}}
+	if err := call(ctx, client, http.MethodPost, sheetsAPI, body, &spreadsheet); err != nil {
+		return err
+	}
+	export.SpreadsheetID = spreadsheet.ID
+	export.SpreadsheetURL = spreadsheet.URL
+
+	return writeValues(ctx, client, export.SpreadsheetID, rows)
+}
+
+// writeValues replaces the values of a spreadsheet's first sheet
+func writeValues(ctx context.Context, client *http.Client, spreadsheetID string, rows [][]interface{}) error {
+	base := sheetsAPI + "/" + url.PathEscape(spreadsheetID) + "/values/"
+
+	if err := call(ctx, client, http.MethodPost, base+url.PathEscape(wholeSheet)+":clear", map[string]interface{}{}, nil); err != nil {
+		return err
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+
+	// Values are written as they are, so text is never read as a formula
+	body := map[string]interface{}{"values": rows}
+	return call(ctx, client, http.MethodPut, base+"A1?valueInputOption=RAW", body, nil)
+}
+
+// saveToken saves the user's token when it was refreshed while exporting
+func saveToken(ctx context.Context, store models.Store, conn *models.GoogleConnection, source oauth2.TokenSource) {
+	token, err := source.Token()
+	if err != nil || token.AccessToken == conn.AccessToken {
+		return
+	}
+
+	conn.AccessToken = token.AccessToken
+	if token.RefreshToken != "" {
+		conn.RefreshToken = token.RefreshToken
+	}
+	conn.TokenType = token.TokenType
+	conn.Expiry = token.Expiry
+	if err := store.UpdateGoogleToken(ctx, conn); err != nil {
+		log.Printf("Failed to save refreshed Google token of user %s: %v", conn.UserID.Hex(), err)
+	}
+}
+
+// call sends a JSON request to a Google API and decodes the response into out
+func call(ctx context.Context, client *http.Client, method, endpoint string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(encoded)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		var retrieveErr *oauth2.RetrieveError
+		if errors.As(err, &retrieveErr) && retrieveErr.ErrorCode == "invalid_grant" {
+			return ErrUnauthorized
+		}
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized:
+		return ErrUnauthorized
+	case resp.StatusCode == http.StatusNotFound:
+		return errNotFound
+	case resp.StatusCode >= 300:
+		var apiErr struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		json.NewDecoder(io.LimitReader(resp.Body, 64*1024)).Decode(&apiErr)
+		if apiErr.Error.Message == "" {
+			apiErr.Error.Message = resp.Status
+		}
+		return fmt.Errorf("Google Sheets: %s", apiErr.Error.Message)
+	}
+
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// Rows returns results as spreadsheet rows, a header with the column names
+// and then a row per result. Without column metadata the columns are sorted
+// by name.
+func Rows(columns []models.ResultColumn, results []models.QueryResult) [][]interface{} {
+	names := make([]string, len(columns))
+	for i, column := range columns {
+		names[i] = column.Name
+	}
+	if len(names) == 0 {
+		seen := make(map[string]bool)
+		for _, result := range results {
+			for key := range result {
+				if !seen[key] {
+					seen[key] = true
+					names = append(names, key)
+				}
+			}
+		}
+		sort.Strings(names)
+	}
+	if len(names) == 0 {
+		return nil
+	}
+
+	rows := make([][]interface{}, 0, len(results)+1)
+	header := make([]interface{}, len(names))
+	for i, name := range names {
+		header[i] = name
+	}
+	rows = append(rows, header)
+
+	for _, result := range results {
+		row := make([]interface{}, len(names))
+		for i, name := range names {
+			row[i] = cellValue(result[name])
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// cellValue converts a result value to a spreadsheet cell. Numbers and
+// booleans are kept, dates are written in UTC and anything else as text.
+func cellValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case bool, int, int32, int64:
+		return v
+	case float32:
+		return cellValue(float64(v))
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return ""
+		}
+		return v
+	case string:
+		return truncateCell(v)
+	case time.Time:
+		return v.UTC().Format("2006-01-02 15:04:05")
+	case primitive.DateTime:
+		return v.Time().UTC().Format("2006-01-02 15:04:05")
+	case primitive.ObjectID:
+		return v.Hex()
+	case primitive.Decimal128:
+		return v.String()
+	case []byte:
+		return truncateCell(string(v))
+	}
+
+	if encoded, err := json.Marshal(value); err == nil {
+		return truncateCell(string(encoded))
+	}
+	return truncateCell(fmt.Sprint(value))
+}
+
+// truncateCell cuts text short to the length a cell can hold
+func truncateCell(text string) string {
+	if len(text) <= maxCellLength {
+		return text
+	}
+	cut := maxCellLength
+	for cut > 0 && !utf8.RuneStart(text[cut]) {
+		cut--
+	}
+	return text[:cut]
+}
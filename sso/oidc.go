@@ -0,0 +1,222 @@
+package sso
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/zucced/goquery/models"
+	"github.com/zucced/goquery/netguard"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/oauth2"
+)
+
+// stateAudience is the audience of state tokens, so they can't be used as sessions
+const stateAudience = "goquery-sso-state"
+
+// StateExpiry is how long a user has to complete sign in at the provider
+const StateExpiry = 10 * time.Minute
+
+// Identity is a user signed in by an identity provider
+type Identity struct {
+	Subject string
+	Email   string
+	Name    string
+}
+
+// stateClaims ties a provider callback to the sign in that started it. The
+// binding is the PKCE challenge of the verifier kept in the browser that
+// started the sign in, so a callback only completes in that browser.
+type stateClaims struct {
+	OrgID   string `json:"org_id"`
+	Nonce   string `json:"nonce"`
+	Binding string `json:"binding"`
+	jwt.RegisteredClaims
+}
+
+// httpClient talks to identity providers. Issuers are set by organization
+// admins, so it refuses to reach the server's own network.
+var httpClient = netguard.NewClient(30 * time.Second)
+
+// providers caches discovered providers by issuer so their signing keys are
+// only fetched when they rotate
+var providers sync.Map
+
+// provider returns the discovered OIDC provider for an issuer
+func provider(ctx context.Context, issuer string) (*oidc.Provider, error) {
+	if cached, ok := providers.Load(issuer); ok {
+		return cached.(*oidc.Provider), nil
+	}
+
+	p, err := oidc.NewProvider(oidc.ClientContext(ctx, httpClient), issuer)
+	if err != nil {
+		return nil, err
+	}
+	providers.Store(issuer, p)
+	return p, nil
+}
+
+// Discover checks an issuer serves OpenID Connect discovery metadata
+func Discover(ctx context.Context, issuer string) error {
+	_, err := provider(ctx, issuer)
+	return err
+}
+
+// oauthConfig returns the OAuth2 client for an organization's provider
+func oauthConfig(ctx context.Context, conf *models.SSOConfig, redirectURL string) (*oauth2.Config, *oidc.Provider, error) {
+	p, err := provider(ctx, conf.Issuer)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &oauth2.Config{
+		ClientID:     conf.ClientID,
+		ClientSecret: conf.ClientSecret,
+		RedirectURL:  redirectURL,
+		Endpoint:     p.Endpoint(),
+		Scopes:       []string{oidc.ScopeOpenID, "email", "profile"},
+	}, p, nil
+}
+
+// AuthCodeURL returns the provider URL a user is sent to for signing in, with
+// the PKCE challenge of the verifier
+func AuthCodeURL(ctx context.Context, conf *models.SSOConfig, redirectURL, state, nonce, verifier string) (string, error) {
+	client, _, err := oauthConfig(ctx, conf, redirectURL)
+	if err != nil {
+		return "", err
+	}
+	return client.AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier)), nil
+}
+
+// Exchange redeems the code the provider sent back, proving with the PKCE
+// verifier it was issued for this sign in, and returns the verified identity
+// from its ID token
+func Exchange(ctx context.Context, conf *models.SSOConfig, redirectURL, code, nonce, verifier string) (*Identity, error) {
+	client, p, err := oauthConfig(ctx, conf, redirectURL)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx = oidc.ClientContext(ctx, httpClient)
+	token, err := client.Exchange(ctx, code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return nil, err
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, errors.New("provider didn't return an ID token")
+	}
+
+	idToken, err := p.Verifier(&oidc.Config{ClientID: conf.ClientID}).Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, err
+	}
+	if idToken.Nonce != nonce {
+		return nil, errors.New("ID token nonce doesn't match")
+	}
+
+	var claims map[string]interface{}
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, err
+	}
+
+	if verified, ok := claims["email_verified"].(bool); ok && !verified {
+		return nil, errors.New("provider hasn't verified the email address")
+	}
+
+	email, _ := claims[conf.EmailClaim()].(string)
+	email = strings.ToLower(strings.TrimSpace(email))
+	if email == "" {
+		return nil, errors.New("ID token has no " + conf.EmailClaim() + " claim")
+	}
+	name, _ := claims[conf.NameClaim()].(string)
+
+	return &Identity{
+		Subject: idToken.Subject,
+		Email:   email,
+		Name:    name,
+	}, nil
+}
+
+// NewState returns a signed state for starting a sign in with an organization's
+// provider, the nonce the ID token must carry and the PKCE verifier. The
+// verifier must stay in the browser starting the sign in, the state is bound
+// to it.
+func NewState(orgID primitive.ObjectID, secret string) (state, nonce, verifier string, err error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", "", err
+	}
+	nonce = hex.EncodeToString(b)
+	verifier = oauth2.GenerateVerifier()
+
+	now := time.Now()
+	claims := &stateClaims{
+		OrgID:   orgID.Hex(),
+		Nonce:   nonce,
+		Binding: oauth2.S256ChallengeFromVerifier(verifier),
+		RegisteredClaims: jwt.RegisteredClaims{
+			Audience:  jwt.ClaimStrings{stateAudience},
+			ExpiresAt: jwt.NewNumericDate(now.Add(StateExpiry)),
+			IssuedAt:  jwt.NewNumericDate(now),
+		},
+	}
+
+	state, err = jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+	if err != nil {
+		return "", "", "", err
+	}
+	return state, nonce, verifier, nil
+}
+
+// ParseState verifies a state returned by a provider was issued for the
+// verifier the browser kept, and returns the organization and nonce of the
+// sign in
+func ParseState(state, secret, verifier string) (primitive.ObjectID, string, error) {
+	token, err := jwt.ParseWithClaims(state, &stateClaims{}, func(token *jwt.Token) (any, error) {
+		return []byte(secret), nil
+	}, jwt.WithAudience(stateAudience), jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	if err != nil || !token.Valid {
+		return primitive.NilObjectID, "", errors.New("invalid or expired state")
+	}
+
+	claims := token.Claims.(*stateClaims)
+	if verifier == "" || subtle.ConstantTimeCompare([]byte(claims.Binding), []byte(oauth2.S256ChallengeFromVerifier(verifier))) != 1 {
+		return primitive.NilObjectID, "", errors.New("state was issued to another browser")
+	}
+
+	orgID, err := primitive.ObjectIDFromHex(claims.OrgID)
+	if err != nil {
+		return primitive.NilObjectID, "", errors.New("invalid organization in state")
+	}
+	return orgID, claims.Nonce, nil
+}
+
+// DomainHasRecord reports whether a domain's DNS carries a TXT record, which
+// proves whoever set the record controls the domain
+func DomainHasRecord(ctx context.Context, domain, record string) (bool, error) {
+	records, err := net.DefaultResolver.LookupTXT(ctx, domain)
+	if err != nil {
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+
+	for _, r := range records {
+		if strings.TrimSpace(r) == record {
+			return true, nil
+		}
+	}
+	return false, nil
+}
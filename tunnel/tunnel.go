@@ -0,0 +1,334 @@
+// Package tunnel dials database connections through SSH bastion hosts.
+package tunnel
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// DefaultPort is the SSH port used when a config doesn't set one
+const DefaultPort = "22"
+
+// ErrHostKeyRequired is returned when connecting without a pinned host key
+var ErrHostKeyRequired = errors.New("the ssh host key of this connection is not pinned yet, re-test the connection to pin its host key")
+
+// errHostKeyScanned stops the handshake of ScanHostKey once it has the key
+var errHostKeyScanned = errors.New("host key scanned")
+
+// Config describes how to reach a bastion host. Either a password or a
+// private key is required to connect, as is the HostKey the server's key must
+// match. ScanHostKey fetches it to pin on first use.
+type Config struct {
+	Host       string
+	Port       string
+	User       string
+	Password   string
+	PrivateKey string
+	Passphrase string
+	HostKey    string
+}
+
+// Validate checks that the config has everything needed to connect
+func (c Config) Validate() error {
+	if c.Host == "" || c.User == "" {
+		return errors.New("ssh tunnel host and user are required")
+	}
+	if c.Password == "" && c.PrivateKey == "" {
+		return errors.New("ssh tunnel requires a password or a private key")
+	}
+	if c.HostKey != "" {
+		if _, _, _, _, err := ssh.ParseAuthorizedKey([]byte(c.HostKey)); err != nil {
+			return fmt.Errorf("invalid ssh host key: %v", err)
+		}
+	}
+	return nil
+}
+
+// address returns the host:port of the bastion
+func (c Config) address() string {
+	port := c.Port
+	if port == "" {
+		port = DefaultPort
+	}
+	return net.JoinHostPort(c.Host, port)
+}
+
+// key identifies the config in the client cache. Secrets are part of the key
+// so that changing credentials opens a new connection.
+func (c Config) key() string {
+	sum := sha256.Sum256([]byte(c.address() + "\x00" + c.User + "\x00" + c.Password + "\x00" + c.PrivateKey + "\x00" + c.Passphrase + "\x00" + c.HostKey))
+	return hex.EncodeToString(sum[:])
+}
+
+// clientConfig builds the ssh client configuration
+func (c Config) clientConfig(timeout time.Duration) (*ssh.ClientConfig, error) {
+	var auth []ssh.AuthMethod
+	if c.PrivateKey != "" {
+		var signer ssh.Signer
+		var err error
+		if c.Passphrase != "" {
+			signer, err = ssh.ParsePrivateKeyWithPassphrase([]byte(c.PrivateKey), []byte(c.Passphrase))
+		} else {
+			signer, err = ssh.ParsePrivateKey([]byte(c.PrivateKey))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid ssh private key: %v", err)
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+	if c.Password != "" {
+		auth = append(auth, ssh.Password(c.Password))
+	}
+
+	// Never connect to a host whose key isn't known
+	if c.HostKey == "" {
+		return nil, ErrHostKeyRequired
+	}
+	hostKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(c.HostKey))
+	if err != nil {
+		return nil, fmt.Errorf("invalid ssh host key: %v", err)
+	}
+
+	return &ssh.ClientConfig{
+		User:            c.User,
+		Auth:            auth,
+		HostKeyCallback: ssh.FixedHostKey(hostKey),
+		Timeout:         timeout,
+	}, nil
+}
+
+// Health reports the state of a tunnel
+type Health struct {
+	Status      string `json:"status"`
+	Address     string `json:"address"`
+	LatencyMs   int64  `json:"latency_ms"`
+	Fingerprint string `json:"host_key_fingerprint,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// client is a cached ssh connection to a bastion
+type client struct {
+	*ssh.Client
+	fingerprint string
+}
+
+// Manager keeps one ssh connection per bastion config and dials database
+// connections through it. Connections that drop are reopened on next use.
+type Manager struct {
+	mu      sync.Mutex
+	timeout time.Duration
+	clients map[string]*client
+}
+
+// NewManager creates a tunnel manager. timeout bounds the ssh handshake.
+func NewManager(timeout time.Duration) *Manager {
+	return &Manager{
+		timeout: timeout,
+		clients: make(map[string]*client),
+	}
+}
+
+// Dialer returns a function dialing addresses through the bastion
+func (m *Manager) Dialer(cfg Config) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return m.DialContext(ctx, cfg, network, addr)
+	}
+}
+
+// DialContext opens a connection to addr through the bastion. A stale ssh
+// connection is dropped and the dial is retried once on a fresh one.
+func (m *Manager) DialContext(ctx context.Context, cfg Config, network, addr string) (net.Conn, error) {
+	c, err := m.client(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := c.DialContext(ctx, network, addr)
+	if err == nil {
+		return conn, nil
+	}
+
+	m.forget(cfg.key(), c)
+	if ctx.Err() != nil {
+		return nil, fmt.Errorf("failed to dial %s through ssh tunnel: %v", addr, err)
+	}
+
+	c, err = m.client(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	conn, err = c.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial %s through ssh tunnel: %v", addr, err)
+	}
+	return conn, nil
+}
+
+// Check connects to the bastion, opening a connection if needed, and
+// measures the round trip of a keepalive request
+func (m *Manager) Check(ctx context.Context, cfg Config) Health {
+	health := Health{Address: cfg.address()}
+
+	start := time.Now()
+	c, err := m.client(ctx, cfg)
+	if err == nil {
+		health.Fingerprint = c.fingerprint
+		if _, _, err = c.SendRequest("keepalive@openssh.com", true, nil); err != nil {
+			m.forget(cfg.key(), c)
+		}
+	}
+	health.LatencyMs = time.Since(start).Milliseconds()
+
+	if err != nil {
+		health.Status = "error"
+		health.Error = err.Error()
+		return health
+	}
+
+	health.Status = "ok"
+	return health
+}
+
+// ScanHostKey connects to the bastion and returns the host key it presents, in
+// authorized_keys format, without authenticating. Callers pin the key so later
+// connections only trust that host.
+func (m *Manager) ScanHostKey(ctx context.Context, cfg Config) (string, error) {
+	var hostKey ssh.PublicKey
+	clientConfig := &ssh.ClientConfig{
+		User: cfg.User,
+		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			hostKey = key
+			return errHostKeyScanned
+		},
+		Timeout: m.timeout,
+	}
+
+	sshConn, _, _, err := m.handshake(ctx, cfg, clientConfig)
+	if err == nil {
+		sshConn.Close()
+	}
+	if hostKey == nil {
+		if err == nil {
+			err = errors.New("ssh host presented no host key")
+		}
+		return "", err
+	}
+	return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(hostKey))), nil
+}
+
+// Close closes every open ssh connection
+func (m *Manager) Close() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for key, c := range m.clients {
+		c.Close()
+		delete(m.clients, key)
+	}
+}
+
+// client returns the cached ssh connection for cfg, opening one if needed
+func (m *Manager) client(ctx context.Context, cfg Config) (*client, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	key := cfg.key()
+	m.mu.Lock()
+	c, ok := m.clients[key]
+	m.mu.Unlock()
+	if ok {
+		return c, nil
+	}
+
+	c, err := m.connect(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	if existing, ok := m.clients[key]; ok {
+		// Another caller connected first, keep theirs
+		m.mu.Unlock()
+		c.Close()
+		return existing, nil
+	}
+	m.clients[key] = c
+	m.mu.Unlock()
+
+	// Drop the connection from the cache once the bastion closes it
+	go func() {
+		c.Wait()
+		m.forget(key, c)
+	}()
+
+	return c, nil
+}
+
+// connect opens a new ssh connection to the bastion
+func (m *Manager) connect(ctx context.Context, cfg Config) (*client, error) {
+	clientConfig, err := cfg.clientConfig(m.timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	var fingerprint string
+	verify := clientConfig.HostKeyCallback
+	clientConfig.HostKeyCallback = func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		fingerprint = ssh.FingerprintSHA256(key)
+		return verify(hostname, remote, key)
+	}
+
+	sshConn, chans, reqs, err := m.handshake(ctx, cfg, clientConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return &client{
+		Client:      ssh.NewClient(sshConn, chans, reqs),
+		fingerprint: fingerprint,
+	}, nil
+}
+
+// handshake dials the bastion and runs the ssh handshake
+func (m *Manager) handshake(ctx context.Context, cfg Config, clientConfig *ssh.ClientConfig) (ssh.Conn, <-chan ssh.NewChannel, <-chan *ssh.Request, error) {
+	dialer := net.Dialer{Timeout: m.timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", cfg.address())
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to reach ssh host %s: %v", cfg.address(), err)
+	}
+
+	// Bound the handshake by the context as well as the timeout
+	deadline := time.Now().Add(m.timeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	conn.SetDeadline(deadline)
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, cfg.address(), clientConfig)
+	if err != nil {
+		conn.Close()
+		return nil, nil, nil, fmt.Errorf("ssh handshake with %s failed: %v", cfg.address(), err)
+	}
+	conn.SetDeadline(time.Time{})
+
+	return sshConn, chans, reqs, nil
+}
+
+// forget removes c from the cache and closes it
+func (m *Manager) forget(key string, c *client) {
+	m.mu.Lock()
+	if m.clients[key] == c {
+		delete(m.clients, key)
+	}
+	m.mu.Unlock()
+	c.Close()
+}
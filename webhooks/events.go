@@ -0,0 +1,126 @@
+package webhooks
+
+import (
+	"github.com/zucced/goquery/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// QueryFinished sends query.completed or query.failed once a query reaches
+// either status. Results are left out, receivers fetch them through the API.
+func (d *Dispatcher) QueryFinished(query *models.Query) {
+	var event string
+	switch query.Status {
+	case models.QueryStatusCompleted:
+		event = EventQueryCompleted
+	case models.QueryStatusFailed:
+		event = EventQueryFailed
+	default:
+		return
+	}
+
+	d.Emit(query.UserID, event, map[string]interface{}{
+		"query_id":       query.ID,
+		"database_id":    query.DatabaseID,
+		"name":           query.Name,
+		"status":         query.Status,
+		"sql":            query.GeneratedSQL,
+		"error":          query.Error,
+		"row_count":      len(query.Results),
+		"execution_time": query.ExecutionTime,
+	})
+}
+
+// SchemaChanged sends schema.changed when a refresh finds tables that were
+// added, removed or changed
+func (d *Dispatcher) SchemaChanged(db *models.Database, change *models.SchemaChange) {
+	if change == nil || change.Empty() {
+		return
+	}
+
+	d.Emit(db.UserID, EventSchemaChanged, map[string]interface{}{
+		"database_id":    db.ID,
+		"name":           db.Name,
+		"added_tables":   change.AddedTables,
+		"removed_tables": change.RemovedTables,
+		"changed_tables": change.ChangedTables,
+	})
+}
+
+// AlertFired sends alert.fired when a refreshed metric card breaches a
+// threshold its previous value didn't
+func (d *Dispatcher) AlertFired(dashboard *models.Dashboard, card *models.DashboardCard, data *models.CardData) {
+	if card.Metric == nil || !card.Metric.Alerts(data.Value, data.PreviousValue) {
+		return
+	}
+
+	summary := card.Metric.Summarize(data.Value, data.PreviousValue)
+	d.Emit(dashboard.UserID, EventAlertFired, map[string]interface{}{
+		"dashboard_id":   dashboard.ID,
+		"dashboard_name": dashboard.Name,
+		"card_id":        card.ID,
+		"card_title":     card.Title,
+		"status":         summary.Status,
+		"value":          summary.Value,
+		"previous_value": summary.PreviousValue,
+		"warning":        card.Metric.Warning,
+		"critical":       card.Metric.Critical,
+	})
+}
+
+// ExportReady sends export.ready when a dashboard snapshot is captured and
+// can be exported. scheduleID is set for snapshots of scheduled reports.
+func (d *Dispatcher) ExportReady(userID primitive.ObjectID, snapshot *models.DashboardSnapshot, scheduleID primitive.ObjectID) {
+	if d == nil {
+		return
+	}
+
+	data := map[string]interface{}{
+		"snapshot_id":  snapshot.ID,
+		"dashboard_id": snapshot.DashboardID,
+		"name":         snapshot.Name,
+		"export_url":   d.publicURL + "/api/dashboards/" + snapshot.DashboardID.Hex() + "/snapshots/" + snapshot.ID.Hex() + "/export",
+	}
+	if !scheduleID.IsZero() {
+		data["schedule_id"] = scheduleID
+	}
+	d.Emit(userID, EventExportReady, data)
+}
+
+// AlertChanged sends alert.triggered or alert.resolved when an alert on a
+// query's results changes state. value is the value the rule read, if any.
+func (d *Dispatcher) AlertChanged(alert *models.Alert, query *models.Query, state models.AlertState, value *float64) {
+	event := EventAlertResolved
+	if state == models.AlertStateTriggered {
+		event = EventAlertTriggered
+	}
+
+	d.Emit(alert.UserID, event, map[string]interface{}{
+		"alert_id":   alert.ID,
+		"alert_name": alert.Name,
+		"query_id":   query.ID,
+		"query_name": query.Name,
+		"rule":       alert.Rule,
+		"condition":  alert.Rule.String(),
+		"value":      value,
+	})
+}
+
+// FreshnessChanged sends freshness.stale when a table's data goes stale and
+// freshness.recovered when stale data is fresh again
+func (d *Dispatcher) FreshnessChanged(check *models.FreshnessCheck, db *models.Database) {
+	event := EventDataFresh
+	if check.State == models.FreshnessStale {
+		event = EventDataStale
+	}
+
+	d.Emit(check.UserID, event, map[string]interface{}{
+		"check_id":      check.ID,
+		"database_id":   db.ID,
+		"database_name": db.Name,
+		"table":         check.Table,
+		"column":        check.Column,
+		"max_age":       check.MaxAge,
+		"latest_at":     check.LatestAt,
+		"stale_since":   check.StaleSince,
+	})
+}
@@ -0,0 +1,263 @@
+// Package webhooks sends lifecycle events to the URLs users subscribe with.
+// Each event is recorded as a delivery and sent by a background job, so
+// failed deliveries are retried with backoff and survive restarts.
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/zucced/goquery/models"
+	"github.com/zucced/goquery/netguard"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Events webhooks can subscribe to
+const (
+	EventQueryCompleted = "query.completed"
+	EventQueryFailed    = "query.failed"
+	EventSchemaChanged  = "schema.changed"
+	EventAlertFired     = "alert.fired"
+	EventExportReady    = "export.ready"
+	EventAlertTriggered = "alert.triggered"
+	EventAlertResolved  = "alert.resolved"
+	EventDataStale      = "freshness.stale"
+	EventDataFresh      = "freshness.recovered"
+)
+
+// EventPing is sent when a webhook is tested. Every webhook receives it.
+const EventPing = "ping"
+
+// Events lists the events webhooks can subscribe to
+var Events = []string{
+	EventQueryCompleted,
+	EventQueryFailed,
+	EventSchemaChanged,
+	EventAlertFired,
+	EventExportReady,
+	EventAlertTriggered,
+	EventAlertResolved,
+	EventDataStale,
+	EventDataFresh,
+}
+
+// IsEvent reports whether webhooks can subscribe to an event
+func IsEvent(event string) bool {
+	for _, known := range Events {
+		if known == event {
+			return true
+		}
+	}
+	return false
+}
+
+// JobType is the type of the jobs that send deliveries
+const JobType = "webhook_delivery"
+
+// Request headers sent with every delivery
+const (
+	EventHeader     = "X-GoQuery-Event"
+	DeliveryHeader  = "X-GoQuery-Delivery"
+	TimestampHeader = "X-GoQuery-Timestamp"
+	SignatureHeader = "X-GoQuery-Signature"
+)
+
+// requestTimeout bounds a single delivery attempt
+const requestTimeout = 10 * time.Second
+
+// Dispatcher records events for the webhooks subscribed to them and sends
+// the deliveries. A nil Dispatcher drops events, for callers started without
+// webhooks.
+type Dispatcher struct {
+	store     models.Store
+	publicURL string
+	client    *http.Client
+}
+
+// NewDispatcher creates a dispatcher. publicURL is the address the API is
+// reached at, used for the links in events.
+func NewDispatcher(store models.Store, publicURL string) *Dispatcher {
+	return &Dispatcher{
+		store:     store,
+		publicURL: publicURL,
+		client:    netguard.NewClient(requestTimeout),
+	}
+}
+
+// body is the JSON body of every delivery
+type body struct {
+	ID        primitive.ObjectID `json:"id"`
+	Event     string             `json:"event"`
+	CreatedAt time.Time          `json:"created_at"`
+	Data      interface{}        `json:"data"`
+}
+
+// Emit queues an event for every active webhook of a user subscribed to it.
+// Failures are logged rather than returned, an event never fails the action
+// that caused it.
+func (d *Dispatcher) Emit(userID primitive.ObjectID, event string, data interface{}) {
+	if d == nil {
+		return
+	}
+
+	// The caller's context may be about to end, e.g. when a request returns
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	hooks, err := d.store.GetWebhooksForEvent(ctx, userID, event)
+	if err != nil {
+		log.Printf("Failed to list webhooks for %s: %v", event, err)
+		return
+	}
+
+	for _, webhook := range hooks {
+		if _, err := d.enqueue(ctx, webhook, event, data); err != nil {
+			log.Printf("Failed to queue %s for webhook %s: %v", event, webhook.ID.Hex(), err)
+		}
+	}
+}
+
+// Ping queues a ping event for a webhook, whatever events it subscribes to
+func (d *Dispatcher) Ping(ctx context.Context, webhook *models.Webhook) (*models.WebhookDelivery, error) {
+	return d.enqueue(ctx, webhook, EventPing, map[string]interface{}{
+		"webhook_id": webhook.ID,
+	})
+}
+
+// enqueue records a delivery of an event and queues the job that sends it
+func (d *Dispatcher) enqueue(ctx context.Context, webhook *models.Webhook, event string, data interface{}) (*models.WebhookDelivery, error) {
+	delivery := &models.WebhookDelivery{
+		ID:        primitive.NewObjectID(),
+		WebhookID: webhook.ID,
+		Event:     event,
+	}
+
+	payload, err := json.Marshal(body{
+		ID:        delivery.ID,
+		Event:     event,
+		CreatedAt: time.Now().UTC(),
+		Data:      data,
+	})
+	if err != nil {
+		return nil, err
+	}
+	delivery.Payload = string(payload)
+
+	if err := d.store.CreateWebhookDelivery(ctx, delivery); err != nil {
+		return nil, err
+	}
+
+	_, err = d.store.EnqueueJob(ctx, JobType, deliveryJob{DeliveryID: delivery.ID}, "", time.Time{})
+	if err != nil {
+		return nil, err
+	}
+
+	return delivery, nil
+}
+
+// deliveryJob is the payload of the jobs that send deliveries
+type deliveryJob struct {
+	DeliveryID primitive.ObjectID `bson:"delivery_id"`
+}
+
+// Deliver sends a delivery, the handler of JobType jobs. A failed attempt is
+// recorded on the delivery and returned so the job is retried; the delivery
+// fails for good with the job's last attempt.
+func (d *Dispatcher) Deliver(ctx context.Context, job *models.Job) error {
+	var args deliveryJob
+	if err := job.DecodePayload(&args); err != nil {
+		return err
+	}
+
+	delivery, err := d.store.GetWebhookDeliveryByID(ctx, args.DeliveryID)
+	if err != nil {
+		return err
+	}
+	if delivery == nil || delivery.Status != models.WebhookDeliveryPending {
+		return nil
+	}
+
+	now := time.Now()
+	delivery.Attempts++
+	delivery.LastAttemptAt = &now
+
+	webhook, err := d.store.GetWebhookByID(ctx, delivery.WebhookID)
+	if err != nil {
+		return err
+	}
+	if webhook == nil {
+		delivery.Status = models.WebhookDeliveryFailed
+		delivery.Error = "webhook was deleted"
+		return d.store.UpdateWebhookDelivery(ctx, delivery)
+	}
+
+	status, sendErr := d.send(ctx, webhook, delivery)
+	delivery.ResponseStatus = status
+	if sendErr == nil {
+		delivery.Status = models.WebhookDeliverySucceeded
+		delivery.Error = ""
+		delivery.DeliveredAt = &now
+	} else {
+		delivery.Error = sendErr.Error()
+		if job.Attempts >= job.MaxAttempts {
+			delivery.Status = models.WebhookDeliveryFailed
+		}
+	}
+
+	if err := d.store.UpdateWebhookDelivery(ctx, delivery); err != nil {
+		log.Printf("Failed to record attempt of webhook delivery %s: %v", delivery.ID.Hex(), err)
+	}
+	return sendErr
+}
+
+// send posts a delivery to its webhook and returns the response status.
+// Responses other than 2xx are errors.
+func (d *Dispatcher) send(ctx context.Context, webhook *models.Webhook, delivery *models.WebhookDelivery) (int, error) {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewBufferString(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "GoQuery-Webhooks")
+	req.Header.Set(EventHeader, delivery.Event)
+	req.Header.Set(DeliveryHeader, delivery.ID.Hex())
+	req.Header.Set(TimestampHeader, timestamp)
+	req.Header.Set(SignatureHeader, Sign(webhook.Secret, timestamp, []byte(delivery.Payload)))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	// Drain a little of the body so the connection can be reused
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("webhook responded with status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// Sign returns the signature header of a request body: the hex HMAC-SHA256
+// of the timestamp, a dot and the body, keyed with the webhook's secret.
+// Receivers compute the same value to check a request came from GoQuery.
+func Sign(secret, timestamp string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
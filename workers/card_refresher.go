@@ -0,0 +1,95 @@
+package workers
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/zucced/goquery/alerts"
+	"github.com/zucced/goquery/limiter"
+	"github.com/zucced/goquery/models"
+	"github.com/zucced/goquery/webhooks"
+)
+
+// StartCardRefresher periodically reruns the queries behind dashboard cards
+// that have a refresh interval and caches their results. Breached metric
+// thresholds are sent to the owner's webhooks. It stops when ctx is done.
+func StartCardRefresher(ctx context.Context, store models.Store, execLimiter *limiter.ExecutionLimiter, hooks *webhooks.Dispatcher, interval time.Duration) {
+	running.Add(1)
+	go func() {
+		defer running.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				refreshDueCards(ctx, store, execLimiter, hooks)
+			}
+		}
+	}()
+}
+
+// refreshDueCards refreshes every card whose refresh interval has elapsed
+func refreshDueCards(ctx context.Context, store models.Store, execLimiter *limiter.ExecutionLimiter, hooks *webhooks.Dispatcher) {
+	listCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	dashboards, err := store.GetDashboardsWithRefreshingCards(listCtx)
+	cancel()
+	if err != nil {
+		log.Printf("Failed to list dashboards for card refresh: %v", err)
+		return
+	}
+
+	for _, dashboard := range dashboards {
+		for i := range dashboard.Cards {
+			card := &dashboard.Cards[i]
+			if card.RefreshInterval <= 0 || card.QueryID.IsZero() {
+				continue
+			}
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			refreshCardIfDue(ctx, store, execLimiter, hooks, dashboard, card)
+		}
+	}
+}
+
+// refreshCardIfDue refreshes a single card when its cached data is older than its interval
+func refreshCardIfDue(ctx context.Context, store models.Store, execLimiter *limiter.ExecutionLimiter, hooks *webhooks.Dispatcher, dashboard *models.Dashboard, card *models.DashboardCard) {
+	cardCtx, cancel := jobContext(ctx, 2*time.Minute)
+	defer cancel()
+
+	data, err := store.GetCardData(cardCtx, dashboard.ID, card.ID)
+	if err != nil {
+		log.Printf("Failed to load cached data for card %s: %v", card.ID.Hex(), err)
+		return
+	}
+
+	interval := time.Duration(card.RefreshInterval) * time.Second
+	if data != nil && time.Since(data.LastAttemptAt) < interval {
+		return
+	}
+
+	startedAt := time.Now()
+	data, err = store.RefreshCardData(cardCtx, execLimiter, dashboard, card)
+	recordQueryRun(cardCtx, store, models.NewQueryRun(models.QueryRunTriggerCardRefresh, dashboard, card, startedAt, data, err))
+	alerts.EvaluateCard(cardCtx, store, alerts.SourceScheduled, card, data, err)
+	if err != nil {
+		log.Printf("Failed to refresh card %s on dashboard %s: %v", card.ID.Hex(), dashboard.ID.Hex(), err)
+		return
+	}
+	hooks.AlertFired(dashboard, card, data)
+}
+
+// recordQueryRun adds a scheduled run to its query's history. A run that
+// can't be recorded is logged, it doesn't fail the refresh.
+func recordQueryRun(ctx context.Context, store models.Store, run *models.QueryRun) {
+	if err := store.CreateQueryRun(ctx, run); err != nil {
+		log.Printf("Failed to record run of query %s: %v", run.QueryID.Hex(), err)
+	}
+}
@@ -0,0 +1,71 @@
+package workers
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/zucced/goquery/models"
+)
+
+// StartConnectionMonitor tests every saved connection each interval and
+// records whether it is healthy, degraded or unreachable. At most
+// concurrency connections are tested at once. It stops when ctx is done.
+func StartConnectionMonitor(ctx context.Context, store models.Store, concurrency int, interval time.Duration) {
+	running.Add(1)
+	go func() {
+		defer running.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				checkConnections(ctx, store, concurrency)
+			}
+		}
+	}()
+}
+
+// checkConnections tests every saved connection and stores the results
+func checkConnections(ctx context.Context, store models.Store, concurrency int) {
+	listCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	databases, err := store.GetDatabasesForHealthCheck(listCtx)
+	cancel()
+	if err != nil {
+		log.Printf("Failed to list databases for health check: %v", err)
+		return
+	}
+
+	slots := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for _, db := range databases {
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			return
+		case slots <- struct{}{}:
+		}
+
+		wg.Add(1)
+		go func(db *models.Database) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			checkCtx, checkCancel := jobContext(ctx, 2*time.Minute)
+			defer checkCancel()
+			health := models.CheckConnectionHealth(checkCtx, db, db.Health)
+
+			updateCtx, cancel := jobContext(ctx, 10*time.Second)
+			defer cancel()
+			if err := store.UpdateConnectionHealth(updateCtx, db.ID, health); err != nil {
+				log.Printf("Failed to store health of database %s: %v", db.ID.Hex(), err)
+			}
+		}(db)
+	}
+	wg.Wait()
+}
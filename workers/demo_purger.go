@@ -0,0 +1,48 @@
+package workers
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/zucced/goquery/models"
+)
+
+// StartDemoPurger periodically deletes demo users whose session has ended,
+// along with the connections, queries and dashboards they created. It stops
+// when ctx is done.
+func StartDemoPurger(ctx context.Context, store models.Store, interval time.Duration) {
+	running.Add(1)
+	go func() {
+		defer running.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			purgeDemoUsers(ctx, store)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// purgeDemoUsers runs a single purge pass
+func purgeDemoUsers(ctx context.Context, store models.Store) {
+	purgeCtx, cancel := jobContext(ctx, time.Minute)
+	defer cancel()
+
+	users, err := store.PurgeExpiredDemoUsers(purgeCtx, time.Now())
+	if err != nil {
+		log.Printf("Failed to purge demo users: %v", err)
+		return
+	}
+
+	if users > 0 {
+		log.Printf("Purged %d expired demo users", users)
+	}
+}
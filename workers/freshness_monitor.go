@@ -0,0 +1,145 @@
+package workers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/zucced/goquery/models"
+	"github.com/zucced/goquery/notify"
+	"github.com/zucced/goquery/webhooks"
+)
+
+// StartFreshnessMonitor probes the tables of due freshness checks each
+// interval, at most concurrency at once. When a table's data goes stale, or
+// stale data is fresh again, the check's notification channels and the
+// owner's webhooks are told. It stops when ctx is done.
+func StartFreshnessMonitor(ctx context.Context, store models.Store, hooks *webhooks.Dispatcher, sender *notify.Sender, concurrency int, interval time.Duration) {
+	running.Add(1)
+	go func() {
+		defer running.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				runFreshnessChecks(ctx, store, hooks, sender, concurrency)
+			}
+		}
+	}()
+}
+
+// runFreshnessChecks probes every due freshness check
+func runFreshnessChecks(ctx context.Context, store models.Store, hooks *webhooks.Dispatcher, sender *notify.Sender, concurrency int) {
+	listCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	checks, err := store.GetDueFreshnessChecks(listCtx, time.Now())
+	cancel()
+	if err != nil {
+		log.Printf("Failed to list due freshness checks: %v", err)
+		return
+	}
+
+	slots := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for _, check := range checks {
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			return
+		case slots <- struct{}{}:
+		}
+
+		wg.Add(1)
+		go func(check *models.FreshnessCheck) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			checkCtx, checkCancel := jobContext(ctx, 2*time.Minute)
+			defer checkCancel()
+			runFreshnessCheck(checkCtx, store, hooks, sender, check)
+		}(check)
+	}
+	wg.Wait()
+}
+
+// runFreshnessCheck claims a check's probe, probes its table and records the
+// outcome, notifying when the state changes
+func runFreshnessCheck(ctx context.Context, store models.Store, hooks *webhooks.Dispatcher, sender *notify.Sender, check *models.FreshnessCheck) {
+	now := time.Now()
+
+	// Advance the check first so a slow or failing probe isn't retried every tick
+	claimed, err := store.ClaimFreshnessCheck(ctx, check, now)
+	if err != nil {
+		log.Printf("Failed to claim freshness check %s: %v", check.ID.Hex(), err)
+		return
+	}
+	if !claimed {
+		return
+	}
+
+	db, err := store.GetDatabaseByID(ctx, check.DatabaseID)
+	if err != nil {
+		log.Printf("Failed to load database of freshness check %s: %v", check.ID.Hex(), err)
+		return
+	}
+	if db == nil {
+		return
+	}
+
+	previous := check.State
+	latest, probeErr := models.FetchLatestTimestamp(ctx, db, check.Table, check.Column)
+	check.Judge(latest, probeErr, time.Now())
+
+	saved, err := store.SaveFreshnessResult(ctx, check, previous)
+	if err != nil {
+		log.Printf("Failed to save result of freshness check %s: %v", check.ID.Hex(), err)
+		return
+	}
+
+	// Notify when data goes stale and when stale data recovers. A first
+	// probe that finds the data fresh isn't worth a notification.
+	if !saved || check.State == previous {
+		return
+	}
+	if check.State != models.FreshnessStale && previous != models.FreshnessStale {
+		return
+	}
+
+	hooks.FreshnessChanged(check, db)
+	sender.SendTo(check.UserID, check.ChannelIDs, models.NotificationSourceFreshness, freshnessMessage(check, db))
+}
+
+// freshnessMessage builds the message notification channels get when a
+// check changes state
+func freshnessMessage(check *models.FreshnessCheck, db *models.Database) *notify.Message {
+	latest := "no rows"
+	if check.LatestAt != nil {
+		latest = check.LatestAt.UTC().Format(time.RFC3339)
+	}
+
+	title := fmt.Sprintf("Data in %s on %s is fresh again", check.Table, db.Name)
+	event := webhooks.EventDataFresh
+	if check.State == models.FreshnessStale {
+		title = fmt.Sprintf("Data in %s on %s is stale", check.Table, db.Name)
+		event = webhooks.EventDataStale
+	}
+
+	return &notify.Message{
+		Title: title,
+		Body:  fmt.Sprintf("Expected %s, newest is %s.", check.Describe(), latest),
+		Data: map[string]interface{}{
+			"event":       event,
+			"check_id":    check.ID,
+			"database_id": db.ID,
+			"table":       check.Table,
+			"column":      check.Column,
+			"latest_at":   check.LatestAt,
+		},
+	}
+}
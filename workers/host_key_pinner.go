@@ -0,0 +1,68 @@
+package workers
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/zucced/goquery/models"
+)
+
+// StartHostKeyPinner pins the ssh host keys of connections saved before keys
+// were pinned, trusting the key each bastion host presents on first use.
+// Connections whose bastion host can't be reached are retried each interval.
+// It stops when ctx is done.
+func StartHostKeyPinner(ctx context.Context, store models.Store, interval time.Duration) {
+	running.Add(1)
+	go func() {
+		defer running.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			if pinHostKeys(ctx, store) {
+				return
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// pinHostKeys pins the host key of every tunnel without one, reporting
+// whether none were left to pin
+func pinHostKeys(ctx context.Context, store models.Store) bool {
+	listCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	databases, err := store.GetDatabasesWithUnpinnedSSHHostKey(listCtx)
+	cancel()
+	if err != nil {
+		log.Printf("Failed to list connections with unpinned ssh host keys: %v", err)
+		return false
+	}
+
+	pinned := 0
+	for _, db := range databases {
+		if ctx.Err() != nil {
+			return false
+		}
+
+		pinCtx, pinCancel := jobContext(ctx, 30*time.Second)
+		err := models.PinSSHHostKey(pinCtx, db)
+		if err == nil {
+			err = store.SetSSHHostKey(pinCtx, db.ID, db.SSHTunnel.HostKey)
+		}
+		pinCancel()
+		if err != nil {
+			log.Printf("Failed to pin the ssh host key of database %s: %v", db.ID.Hex(), err)
+			continue
+		}
+		pinned++
+	}
+
+	return pinned == len(databases)
+}
@@ -0,0 +1,208 @@
+package workers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/zucced/goquery/models"
+	"github.com/zucced/goquery/notify"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// jobLease is how long a worker holds a job before another worker may
+	// take it over. Workers extend the lease while the job runs.
+	jobLease = time.Minute
+	// jobTimeout bounds a single attempt of a job
+	jobTimeout = 10 * time.Minute
+	// jobRetryBase and jobRetryMax bound the backoff between attempts, which
+	// doubles after each failure
+	jobRetryBase = 30 * time.Second
+	jobRetryMax  = time.Hour
+)
+
+// JobHandler runs a single attempt of a job. Returning an error schedules a
+// retry, or marks the job dead once it has no attempts left.
+type JobHandler func(ctx context.Context, job *models.Job) error
+
+var (
+	jobHandlersMu sync.RWMutex
+	jobHandlers   = make(map[string]JobHandler)
+)
+
+// RegisterJobHandler sets the handler of a job type. Only jobs of registered
+// types are claimed, so handlers must be registered before StartJobRunner.
+func RegisterJobHandler(jobType string, handler JobHandler) {
+	jobHandlersMu.Lock()
+	defer jobHandlersMu.Unlock()
+
+	jobHandlers[jobType] = handler
+}
+
+// jobHandler returns the handler of a job type, or nil when there is none
+func jobHandler(jobType string) JobHandler {
+	jobHandlersMu.RLock()
+	defer jobHandlersMu.RUnlock()
+
+	return jobHandlers[jobType]
+}
+
+// jobTypes returns the registered job types
+func jobTypes() []string {
+	jobHandlersMu.RLock()
+	defer jobHandlersMu.RUnlock()
+
+	types := make([]string, 0, len(jobHandlers))
+	for jobType := range jobHandlers {
+		types = append(types, jobType)
+	}
+	return types
+}
+
+// StartJobRunner starts concurrency workers running the jobs of the
+// registered types, each checking every interval for a due job when idle.
+// Jobs survive restarts and are shared with the workers of other servers.
+// Jobs that fail on their last attempt are reported to the job failure
+// notification channels. It stops when ctx is done.
+func StartJobRunner(ctx context.Context, store models.Store, sender *notify.Sender, concurrency int, interval time.Duration) {
+	types := jobTypes()
+	if len(types) == 0 {
+		return
+	}
+
+	hostname, _ := os.Hostname()
+	running.Add(concurrency)
+	for i := 0; i < concurrency; i++ {
+		owner := fmt.Sprintf("%s-%d-%s", hostname, i, primitive.NewObjectID().Hex())
+		go runJobs(ctx, store, sender, types, owner, interval)
+	}
+}
+
+// runJobs claims and runs jobs one at a time until ctx is done
+func runJobs(ctx context.Context, store models.Store, sender *notify.Sender, types []string, owner string, interval time.Duration) {
+	defer running.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		// Run jobs back to back while there are due ones
+		for ctx.Err() == nil && runNextJob(ctx, store, sender, types, owner) {
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// runNextJob claims a due job and runs it, reporting whether there was one
+func runNextJob(ctx context.Context, store models.Store, sender *notify.Sender, types []string, owner string) bool {
+	claimCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	job, err := store.ClaimJob(claimCtx, types, owner, jobLease)
+	cancel()
+	if err != nil {
+		log.Printf("Failed to claim a job: %v", err)
+		return false
+	}
+	if job == nil {
+		return false
+	}
+
+	jobCtx, cancel := jobContext(ctx, jobTimeout)
+	defer cancel()
+
+	// Keep the lease while the job runs
+	stopLease := make(chan struct{})
+	var leaseDone sync.WaitGroup
+	leaseDone.Add(1)
+	go func() {
+		defer leaseDone.Done()
+		extendJobLease(jobCtx, store, job, stopLease, cancel)
+	}()
+
+	jobErr := runJob(jobCtx, job)
+	close(stopLease)
+	leaseDone.Wait()
+
+	updateCtx, updateCancel := jobContext(ctx, 10*time.Second)
+	defer updateCancel()
+
+	if jobErr == nil {
+		if err := store.CompleteJob(updateCtx, job); err != nil {
+			log.Printf("Failed to complete job %s: %v", job.ID.Hex(), err)
+		}
+		return true
+	}
+
+	log.Printf("Job %s (%s) failed on attempt %d of %d: %v", job.ID.Hex(), job.Type, job.Attempts, job.MaxAttempts, jobErr)
+	if err := store.FailJob(updateCtx, job, jobErr, time.Now().Add(jobBackoff(job.Attempts))); err != nil {
+		log.Printf("Failed to record failure of job %s: %v", job.ID.Hex(), err)
+	}
+
+	// A failed delivery isn't reported through the channels, it could fail the same way
+	if job.Attempts >= job.MaxAttempts && job.Type != notify.JobType {
+		sender.SendJobFailure(job, jobErr)
+	}
+	return true
+}
+
+// runJob runs a job's handler, turning a panic into an error so a bad job
+// doesn't take the worker down
+func runJob(ctx context.Context, job *models.Job) (err error) {
+	handler := jobHandler(job.Type)
+	if handler == nil {
+		return fmt.Errorf("no handler for job type %q", job.Type)
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("job panicked: %v", r)
+		}
+	}()
+	return handler(ctx, job)
+}
+
+// extendJobLease extends a job's lease until stop is closed. It cancels the
+// job when the lease is lost, since another worker will take it over.
+func extendJobLease(ctx context.Context, store models.Store, job *models.Job, stop <-chan struct{}, cancel context.CancelFunc) {
+	ticker := time.NewTicker(jobLease / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			leased, err := store.ExtendJobLease(ctx, job, jobLease)
+			if err != nil {
+				log.Printf("Failed to extend lease of job %s: %v", job.ID.Hex(), err)
+				continue
+			}
+			if !leased {
+				log.Printf("Lost lease of job %s, canceling it", job.ID.Hex())
+				cancel()
+				return
+			}
+		}
+	}
+}
+
+// jobBackoff returns how long to wait before the next attempt of a job that
+// failed attempts times
+func jobBackoff(attempts int) time.Duration {
+	backoff := jobRetryBase
+	for i := 1; i < attempts && backoff < jobRetryMax; i++ {
+		backoff *= 2
+	}
+	if backoff > jobRetryMax {
+		backoff = jobRetryMax
+	}
+	return backoff
+}
@@ -0,0 +1,124 @@
+package workers
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/zucced/goquery/alerts"
+	"github.com/zucced/goquery/limiter"
+	"github.com/zucced/goquery/models"
+)
+
+// StartMaterializer periodically refreshes the results of materialized
+// queries whose next refresh is due. It stops when ctx is done.
+func StartMaterializer(ctx context.Context, store models.Store, execLimiter *limiter.ExecutionLimiter, interval time.Duration) {
+	running.Add(1)
+	go func() {
+		defer running.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				refreshDueMaterializations(ctx, store, execLimiter)
+			}
+		}
+	}()
+}
+
+// refreshDueMaterializations refreshes every materialized query whose next
+// refresh has passed
+func refreshDueMaterializations(ctx context.Context, store models.Store, execLimiter *limiter.ExecutionLimiter) {
+	listCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	queries, err := store.GetDueMaterializedQueries(listCtx, time.Now())
+	cancel()
+	if err != nil {
+		log.Printf("Failed to list due materialized queries: %v", err)
+		return
+	}
+
+	for _, query := range queries {
+		if ctx.Err() != nil {
+			return
+		}
+		refreshMaterialization(ctx, store, execLimiter, query)
+	}
+}
+
+// refreshMaterialization claims a query's refresh, runs it as its owner and
+// stores the results
+func refreshMaterialization(ctx context.Context, store models.Store, execLimiter *limiter.ExecutionLimiter, query *models.Query) {
+	refreshCtx, cancel := jobContext(ctx, 10*time.Minute)
+	defer cancel()
+
+	startedAt := time.Now()
+	materialization := query.Materialization
+
+	// A query with an invalid cron expression or timezone can never refresh again
+	if _, err := models.NextReportRun(materialization.Cron, materialization.Timezone, startedAt); err != nil {
+		log.Printf("Unmaterializing query %s: %v", query.ID.Hex(), err)
+		if err := store.UnmaterializeQuery(refreshCtx, query.ID); err != nil {
+			log.Printf("Failed to unmaterialize query %s: %v", query.ID.Hex(), err)
+		}
+		return
+	}
+
+	// Advance the query first so a slow or failing refresh isn't retried every tick
+	claimed, err := store.ClaimMaterialization(refreshCtx, query, startedAt)
+	if err != nil {
+		log.Printf("Failed to claim refresh of query %s: %v", query.ID.Hex(), err)
+		return
+	}
+	if !claimed {
+		return
+	}
+
+	results, columns, executionTime, err := runMaterializedQuery(refreshCtx, store, execLimiter, query)
+	alerts.Evaluate(refreshCtx, store, alerts.SourceScheduled, query.ID, results, err)
+
+	run := &models.QueryRun{
+		QueryID:    query.ID,
+		UserID:     query.UserID,
+		Trigger:    models.QueryRunTriggerMaterialize,
+		Status:     models.QueryStatusCompleted,
+		DurationMs: time.Since(startedAt).Milliseconds(),
+		RowCount:   len(results),
+		StartedAt:  startedAt,
+	}
+	if err != nil {
+		run.Status = models.QueryStatusFailed
+		run.Error = err.Error()
+		run.RowCount = 0
+	}
+	recordQueryRun(refreshCtx, store, run)
+
+	if err != nil {
+		log.Printf("Failed to refresh materialized query %s: %v", query.ID.Hex(), err)
+		if err := store.RecordMaterializationFailure(refreshCtx, query, err); err != nil {
+			log.Printf("Failed to record refresh of query %s: %v", query.ID.Hex(), err)
+		}
+		return
+	}
+
+	// Results are capped like those of runs by hand
+	if preferences, err := store.GetUserPreferences(refreshCtx, query.UserID); err == nil {
+		results = preferences.LimitResults(results)
+	}
+
+	if err := store.SaveMaterializedResults(refreshCtx, query, results, columns, executionTime, time.Now()); err != nil {
+		log.Printf("Failed to save results of materialized query %s: %v", query.ID.Hex(), err)
+	}
+}
+
+// runMaterializedQuery runs a materialized query as its owner
+func runMaterializedQuery(ctx context.Context, store models.Store, execLimiter *limiter.ExecutionLimiter, query *models.Query) ([]models.QueryResult, []models.ResultColumn, string, error) {
+	if err := query.CanMaterialize(); err != nil {
+		return nil, nil, "", err
+	}
+	return executeScheduledQuery(ctx, store, execLimiter, query.UserID, query)
+}
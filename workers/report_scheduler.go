@@ -0,0 +1,292 @@
+package workers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/zucced/goquery/alerts"
+	"github.com/zucced/goquery/export"
+	"github.com/zucced/goquery/limiter"
+	"github.com/zucced/goquery/mailer"
+	"github.com/zucced/goquery/models"
+	"github.com/zucced/goquery/notify"
+	"github.com/zucced/goquery/webhooks"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// StartReportScheduler periodically runs due report schedules: it refreshes
+// every card on the dashboard, snapshots the results and emails a summary to
+// the schedule's recipients and email channels. Its other notification
+// channels are told the report is ready. Breached metric thresholds and the
+// snapshot are sent to the owner's webhooks. It stops when ctx is done.
+func StartReportScheduler(ctx context.Context, store models.Store, execLimiter *limiter.ExecutionLimiter, mail mailer.Mailer, hooks *webhooks.Dispatcher, sender *notify.Sender, interval time.Duration) {
+	running.Add(1)
+	go func() {
+		defer running.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				runDueReports(ctx, store, execLimiter, mail, hooks, sender)
+			}
+		}
+	}()
+}
+
+// errDashboardArchived skips reports for archived dashboards without recording a delivery
+var errDashboardArchived = errors.New("dashboard is archived")
+
+// runDueReports runs every report schedule whose next run has passed
+func runDueReports(ctx context.Context, store models.Store, execLimiter *limiter.ExecutionLimiter, mail mailer.Mailer, hooks *webhooks.Dispatcher, sender *notify.Sender) {
+	listCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	schedules, err := store.GetDueReportSchedules(listCtx, time.Now())
+	cancel()
+	if err != nil {
+		log.Printf("Failed to list due report schedules: %v", err)
+		return
+	}
+
+	for _, schedule := range schedules {
+		if ctx.Err() != nil {
+			return
+		}
+		runReport(ctx, store, execLimiter, mail, hooks, sender, schedule)
+	}
+}
+
+// runReport claims a schedule's run, delivers the report and records the outcome
+func runReport(ctx context.Context, store models.Store, execLimiter *limiter.ExecutionLimiter, mail mailer.Mailer, hooks *webhooks.Dispatcher, sender *notify.Sender, schedule *models.ReportSchedule) {
+	reportCtx, cancel := jobContext(ctx, 10*time.Minute)
+	defer cancel()
+
+	startedAt := time.Now()
+
+	// A schedule with an invalid cron expression or timezone can never run again
+	if _, err := models.NextReportRun(schedule.Cron, schedule.Timezone, startedAt); err != nil {
+		log.Printf("Disabling report schedule %s: %v", schedule.ID.Hex(), err)
+		if err := store.DisableReportSchedule(reportCtx, schedule.ID); err != nil {
+			log.Printf("Failed to disable report schedule %s: %v", schedule.ID.Hex(), err)
+		}
+		return
+	}
+
+	// Advance the schedule first so a slow or failing report isn't retried every tick
+	claimed, err := store.ClaimReportSchedule(reportCtx, schedule, startedAt)
+	if err != nil {
+		log.Printf("Failed to claim report schedule %s: %v", schedule.ID.Hex(), err)
+		return
+	}
+	if !claimed {
+		return
+	}
+
+	delivery := &models.ReportDelivery{
+		ScheduleID:  schedule.ID,
+		DashboardID: schedule.DashboardID,
+		Recipients:  schedule.Recipients,
+		Status:      models.ReportDeliverySent,
+		StartedAt:   startedAt,
+	}
+
+	snapshotID, err := deliverReport(reportCtx, store, execLimiter, mail, hooks, sender, schedule)
+	if errors.Is(err, errDashboardArchived) {
+		return
+	}
+	delivery.SnapshotID = snapshotID
+	if err != nil {
+		log.Printf("Failed to deliver report schedule %s: %v", schedule.ID.Hex(), err)
+		delivery.Status = models.ReportDeliveryFailed
+		delivery.Error = err.Error()
+	}
+	delivery.CompletedAt = time.Now()
+
+	if err := store.CreateReportDelivery(reportCtx, delivery); err != nil {
+		log.Printf("Failed to record delivery for report schedule %s: %v", schedule.ID.Hex(), err)
+	}
+}
+
+// deliverReport refreshes the dashboard's cards, snapshots them and emails the summary
+func deliverReport(ctx context.Context, store models.Store, execLimiter *limiter.ExecutionLimiter, mail mailer.Mailer, hooks *webhooks.Dispatcher, sender *notify.Sender, schedule *models.ReportSchedule) (primitive.ObjectID, error) {
+	dashboard, err := store.GetDashboardByID(ctx, schedule.DashboardID)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	if dashboard == nil {
+		return primitive.NilObjectID, errors.New("dashboard not found")
+	}
+	if dashboard.ArchivedAt != nil {
+		return primitive.NilObjectID, errDashboardArchived
+	}
+
+	// Run every card query so the report has current data. Failures are kept
+	// on the card and shown in the report instead of aborting it.
+	var runIDs []primitive.ObjectID
+	for i := range dashboard.Cards {
+		card := &dashboard.Cards[i]
+		if card.QueryID.IsZero() {
+			continue
+		}
+		startedAt := time.Now()
+		data, err := store.RefreshCardData(ctx, execLimiter, dashboard, card)
+		run := models.NewQueryRun(models.QueryRunTriggerReport, dashboard, card, startedAt, data, err)
+		run.ReportScheduleID = schedule.ID
+		recordQueryRun(ctx, store, run)
+		if !run.ID.IsZero() {
+			runIDs = append(runIDs, run.ID)
+		}
+		alerts.EvaluateCard(ctx, store, alerts.SourceScheduled, card, data, err)
+		if err != nil {
+			log.Printf("Failed to refresh card %s for report %s: %v", card.ID.Hex(), schedule.ID.Hex(), err)
+			continue
+		}
+		hooks.AlertFired(dashboard, card, data)
+	}
+
+	snapshot, err := store.CaptureDashboardSnapshot(ctx, dashboard, fmt.Sprintf("%s - %s", schedule.Name, time.Now().UTC().Format("2006-01-02")))
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	hooks.ExportReady(schedule.UserID, snapshot, schedule.ID)
+
+	// The snapshot keeps the results of the runs after the card is refreshed again
+	if err := store.SetQueryRunsSnapshot(ctx, runIDs, snapshot.ID); err != nil {
+		log.Printf("Failed to link query runs to the snapshot of report %s: %v", schedule.ID.Hex(), err)
+	}
+
+	var channels []*models.NotificationChannel
+	if len(schedule.ChannelIDs) > 0 {
+		channels, err = store.GetNotificationChannelsByUserID(ctx, schedule.UserID, schedule.ChannelIDs)
+		if err != nil {
+			return snapshot.ID, err
+		}
+	}
+
+	// Email channels get the report itself, along with the recipients
+	recipients := schedule.Recipients
+	var emailChannels []*models.NotificationChannel
+	var otherChannels []*models.NotificationChannel
+	for _, channel := range channels {
+		if channel.Type == models.ChannelTypeEmail {
+			emailChannels = append(emailChannels, channel)
+			recipients = mergeRecipients(recipients, channel.Emails)
+		} else {
+			otherChannels = append(otherChannels, channel)
+		}
+	}
+
+	notice := &notify.Message{
+		Title: fmt.Sprintf("Report %q is ready", schedule.Name),
+		Body:  fmt.Sprintf("A snapshot of dashboard %q was taken for this report.", dashboard.Name),
+		Data: map[string]interface{}{
+			"schedule_id":  schedule.ID,
+			"dashboard_id": dashboard.ID,
+			"snapshot_id":  snapshot.ID,
+		},
+	}
+	sender.Send(otherChannels, models.NotificationSourceReport, notice)
+
+	if len(recipients) == 0 {
+		return snapshot.ID, nil
+	}
+
+	// Recipients needn't be users, so sensitive columns are always masked
+	if err := store.NewResultMasker(primitive.NilObjectID).MaskSnapshot(ctx, snapshot); err != nil {
+		return snapshot.ID, err
+	}
+
+	msg, err := buildReportMessage(schedule, snapshot, recipients)
+	if err != nil {
+		return snapshot.ID, err
+	}
+
+	err = mail.Send(ctx, msg)
+	for _, channel := range emailChannels {
+		sender.Record(ctx, channel, models.NotificationSourceReport, notice, err)
+	}
+	return snapshot.ID, err
+}
+
+// mergeRecipients adds addresses missing from recipients, without changing
+// the schedule's own list
+func mergeRecipients(recipients, addresses []string) []string {
+	merged := recipients[:len(recipients):len(recipients)]
+	for _, address := range addresses {
+		found := false
+		for _, recipient := range merged {
+			if strings.EqualFold(recipient, address) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			merged = append(merged, address)
+		}
+	}
+	return merged
+}
+
+// buildReportMessage renders a snapshot into a report email
+func buildReportMessage(schedule *models.ReportSchedule, snapshot *models.DashboardSnapshot, recipients []string) (*mailer.Message, error) {
+	msg := &mailer.Message{
+		To:      recipients,
+		Subject: snapshot.Name,
+	}
+
+	// Inline chart images for chart cards, with dates in the schedule's timezone
+	charts := make(map[primitive.ObjectID]string)
+	if schedule.IncludeCharts {
+		location, err := time.LoadLocation(schedule.Timezone)
+		if err != nil {
+			location = time.UTC
+		}
+
+		for _, card := range snapshot.Cards {
+			data, err := export.RenderCardChart(card, location)
+			if err != nil {
+				if !errors.Is(err, export.ErrNotChartable) {
+					log.Printf("Failed to render chart for card %s: %v", card.CardID.Hex(), err)
+				}
+				continue
+			}
+
+			contentID := "chart-" + card.CardID.Hex() + "@goquery"
+			charts[card.CardID] = contentID
+			msg.Attachments = append(msg.Attachments, mailer.Attachment{
+				Filename:    "chart-" + card.CardID.Hex() + ".png",
+				ContentType: "image/png",
+				Data:        data,
+				Inline:      true,
+				ContentID:   contentID,
+			})
+		}
+	}
+
+	html, text, err := export.RenderSnapshotEmail(snapshot, charts)
+	if err != nil {
+		return nil, err
+	}
+	msg.HTMLBody = html
+	msg.TextBody = text
+
+	// Full report as a PDF attachment
+	pdf, err := export.RenderSnapshot(snapshot, export.FormatPDF)
+	if err != nil {
+		return nil, err
+	}
+	msg.Attachments = append(msg.Attachments, mailer.Attachment{
+		Filename:    fmt.Sprintf("report-%s.pdf", snapshot.CreatedAt.UTC().Format("2006-01-02")),
+		ContentType: export.FormatPDF.ContentType(),
+		Data:        pdf,
+	})
+
+	return msg, nil
+}
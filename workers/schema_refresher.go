@@ -0,0 +1,169 @@
+package workers
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/zucced/goquery/models"
+	"github.com/zucced/goquery/realtime"
+	"github.com/zucced/goquery/webhooks"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// schemaRefreshQueueSize bounds the number of refreshes waiting for a worker
+const schemaRefreshQueueSize = 256
+
+// SchemaRefresher refreshes database schemas in the background, both on
+// request and on each connection's refresh interval
+type SchemaRefresher struct {
+	mu      sync.Mutex
+	queue   chan primitive.ObjectID
+	pending map[primitive.ObjectID]bool
+	store   models.Store
+	gateway *realtime.Gateway
+	hooks   *webhooks.Dispatcher
+}
+
+// StartSchemaRefresher starts concurrency workers refreshing queued schemas,
+// and checks every interval for connections whose refresh is due. Progress is
+// published on each database's schema channel of the gateway, and changed
+// schemas are sent to the owner's webhooks. It stops when ctx is done.
+func StartSchemaRefresher(ctx context.Context, store models.Store, concurrency int, interval time.Duration, gateway *realtime.Gateway, hooks *webhooks.Dispatcher) *SchemaRefresher {
+	r := &SchemaRefresher{
+		queue:   make(chan primitive.ObjectID, schemaRefreshQueueSize),
+		pending: make(map[primitive.ObjectID]bool),
+		store:   store,
+		gateway: gateway,
+		hooks:   hooks,
+	}
+
+	running.Add(concurrency + 1)
+	for i := 0; i < concurrency; i++ {
+		go r.work(ctx)
+	}
+
+	go func() {
+		defer running.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				r.enqueueDue(ctx)
+			}
+		}
+	}()
+
+	return r
+}
+
+// Refresh queues a schema refresh for a database. It returns false when the
+// queue is full. A database that is already queued is only refreshed once.
+func (r *SchemaRefresher) Refresh(id primitive.ObjectID) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.pending[id] {
+		return true
+	}
+
+	select {
+	case r.queue <- id:
+		r.pending[id] = true
+		r.publish(id, models.SchemaRefreshPending, "")
+		return true
+	default:
+		return false
+	}
+}
+
+// work refreshes queued databases until ctx is done
+func (r *SchemaRefresher) work(ctx context.Context) {
+	defer running.Done()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case id := <-r.queue:
+			// Allow the database to be queued again while it refreshes, so
+			// changes made in the meantime are picked up
+			r.mu.Lock()
+			delete(r.pending, id)
+			r.mu.Unlock()
+
+			r.publish(id, models.SchemaRefreshRunning, "")
+			refreshCtx, cancel := jobContext(ctx, 5*time.Minute)
+			change, err := r.store.RefreshDatabaseSchema(refreshCtx, id)
+			if err != nil {
+				log.Printf("Failed to refresh schema for database %s: %v", id.Hex(), err)
+				r.publish(id, models.SchemaRefreshFailed, err.Error())
+			} else {
+				r.publish(id, models.SchemaRefreshSucceeded, "")
+				r.notifyChange(refreshCtx, id, change)
+			}
+			cancel()
+		}
+	}
+}
+
+// enqueueDue queues every database whose refresh interval has elapsed
+func (r *SchemaRefresher) enqueueDue(ctx context.Context) {
+	listCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	databases, err := r.store.GetDatabasesWithSchemaRefresh(listCtx)
+	if err != nil {
+		log.Printf("Failed to list databases for schema refresh: %v", err)
+		return
+	}
+
+	now := time.Now()
+	for _, db := range databases {
+		if !db.SchemaRefreshDue(now) {
+			continue
+		}
+		if !r.Refresh(db.ID) {
+			log.Printf("Schema refresh queue is full, skipping database %s", db.ID.Hex())
+			return
+		}
+	}
+}
+
+// notifyChange sends a changed schema to the webhooks of the database's owner
+func (r *SchemaRefresher) notifyChange(ctx context.Context, id primitive.ObjectID, change *models.SchemaChange) {
+	if r.hooks == nil || change == nil || change.Empty() {
+		return
+	}
+
+	db, err := r.store.GetDatabaseByID(ctx, id)
+	if err != nil || db == nil {
+		log.Printf("Failed to load database %s for its schema change: %v", id.Hex(), err)
+		return
+	}
+	r.hooks.SchemaChanged(db, change)
+}
+
+// publish tells the gateway connections following a database about the
+// progress of its schema refresh
+func (r *SchemaRefresher) publish(id primitive.ObjectID, status, refreshErr string) {
+	if r.gateway == nil {
+		return
+	}
+
+	r.gateway.Publish(realtime.Message{
+		Channel: realtime.SchemaChannel(id),
+		Type:    "schema_refresh",
+		Data: map[string]interface{}{
+			"database_id": id,
+			"status":      status,
+			"error":       refreshErr,
+		},
+	})
+}
@@ -0,0 +1,172 @@
+package workers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/zucced/goquery/limiter"
+	"github.com/zucced/goquery/models"
+	"github.com/zucced/goquery/sheets"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/oauth2"
+)
+
+// StartSheetsExporter periodically runs due Google Sheets exports: it reruns
+// each export's query and writes the results to its spreadsheet. It stops
+// when ctx is done.
+func StartSheetsExporter(ctx context.Context, store models.Store, execLimiter *limiter.ExecutionLimiter, googleConf *oauth2.Config, interval time.Duration) {
+	running.Add(1)
+	go func() {
+		defer running.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				runDueSheetExports(ctx, store, execLimiter, googleConf)
+			}
+		}
+	}()
+}
+
+// runDueSheetExports runs every scheduled export whose next run has passed
+func runDueSheetExports(ctx context.Context, store models.Store, execLimiter *limiter.ExecutionLimiter, googleConf *oauth2.Config) {
+	listCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	exports, err := store.GetDueSheetExports(listCtx, time.Now())
+	cancel()
+	if err != nil {
+		log.Printf("Failed to list due sheet exports: %v", err)
+		return
+	}
+
+	for _, export := range exports {
+		if ctx.Err() != nil {
+			return
+		}
+		runSheetExport(ctx, store, execLimiter, googleConf, export)
+	}
+}
+
+// runSheetExport claims an export's run, reruns its query and writes the results
+func runSheetExport(ctx context.Context, store models.Store, execLimiter *limiter.ExecutionLimiter, googleConf *oauth2.Config, export *models.SheetExport) {
+	exportCtx, cancel := jobContext(ctx, 10*time.Minute)
+	defer cancel()
+
+	startedAt := time.Now()
+
+	// An export with an invalid cron expression or timezone can never run again
+	if _, err := models.NextReportRun(export.Cron, export.Timezone, startedAt); err != nil {
+		log.Printf("Unscheduling sheet export %s: %v", export.ID.Hex(), err)
+		if err := store.UnscheduleSheetExport(exportCtx, export.ID); err != nil {
+			log.Printf("Failed to unschedule sheet export %s: %v", export.ID.Hex(), err)
+		}
+		return
+	}
+
+	// Advance the export first so a slow or failing run isn't retried every tick
+	claimed, err := store.ClaimSheetExport(exportCtx, export, startedAt)
+	if err != nil {
+		log.Printf("Failed to claim sheet export %s: %v", export.ID.Hex(), err)
+		return
+	}
+	if !claimed {
+		return
+	}
+
+	query, err := store.GetQueryByID(exportCtx, export.QueryID)
+	if err != nil {
+		log.Printf("Failed to load query for sheet export %s: %v", export.ID.Hex(), err)
+		return
+	}
+
+	// The export goes with its query
+	if query == nil {
+		if err := store.DeleteSheetExport(exportCtx, export.QueryID, export.UserID); err != nil {
+			log.Printf("Failed to delete sheet export %s of a deleted query: %v", export.ID.Hex(), err)
+		}
+		return
+	}
+
+	results, columns, err := runExportQuery(exportCtx, store, execLimiter, export, query)
+	if err != nil {
+		log.Printf("Failed to run query for sheet export %s: %v", export.ID.Hex(), err)
+		if err := store.RecordSheetExport(exportCtx, export, 0, err, time.Now()); err != nil {
+			log.Printf("Failed to record sheet export %s: %v", export.ID.Hex(), err)
+		}
+		return
+	}
+
+	if err := sheets.Export(exportCtx, store, googleConf, export, columns, results); err != nil {
+		log.Printf("Failed to write sheet export %s: %v", export.ID.Hex(), err)
+	}
+}
+
+// runExportQuery runs an export's query again as the user who set it up,
+// without changing the query's stored results, masked for that user
+func runExportQuery(ctx context.Context, store models.Store, execLimiter *limiter.ExecutionLimiter, export *models.SheetExport, query *models.Query) ([]models.QueryResult, []models.ResultColumn, error) {
+	// The user may have lost access since setting up the export
+	allowed, err := store.CanAccessQuery(ctx, query, export.UserID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !allowed {
+		return nil, nil, errors.New("you no longer have access to this query")
+	}
+	if query.IsWrite {
+		return nil, nil, errors.New("write queries can't be exported on a schedule")
+	}
+
+	results, columns, _, err := executeScheduledQuery(ctx, store, execLimiter, export.UserID, query)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// The user only gets the results they may see
+	results, err = store.NewResultMasker(export.UserID).Mask(ctx, query, results)
+	return results, columns, err
+}
+
+// executeScheduledQuery runs a saved query for a schedule as the given user,
+// without changing the query's stored results. Federated queries join the
+// current results of their sources.
+func executeScheduledQuery(ctx context.Context, store models.Store, execLimiter *limiter.ExecutionLimiter, userID primitive.ObjectID, query *models.Query) ([]models.QueryResult, []models.ResultColumn, string, error) {
+	if query.Federated() {
+		tables, err := store.LoadFederatedTables(ctx, query.Sources, userID)
+		if err != nil {
+			return nil, nil, "", err
+		}
+
+		release, err := execLimiter.Acquire(ctx, userID.Hex(), models.FederationLimiterKey)
+		if err != nil {
+			return nil, nil, "", err
+		}
+		defer release()
+
+		return models.ExecuteFederatedQuery(ctx, tables, query.GeneratedSQL)
+	}
+
+	db, err := store.GetDatabaseByID(ctx, query.DatabaseID)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	if db == nil {
+		return nil, nil, "", errors.New("database not found")
+	}
+	if db.RequiresConfirmation() {
+		return nil, nil, "", errors.New("queries against this database need confirmation and can't run on a schedule")
+	}
+
+	release, err := execLimiter.Acquire(ctx, userID.Hex(), db.ID.Hex())
+	if err != nil {
+		return nil, nil, "", err
+	}
+	defer release()
+
+	return models.ExecuteQuery(ctx, db, query.GeneratedSQL)
+}
@@ -0,0 +1,44 @@
+package workers
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// running tracks the worker goroutines, which exit after finishing the job
+// they are on once their context is done
+var running sync.WaitGroup
+
+// Go runs fn in the background, tracked like a worker so shutting down waits
+// for it to finish. Handlers use it for work that outlives their request.
+func Go(fn func()) {
+	running.Add(1)
+	go func() {
+		defer running.Done()
+		fn()
+	}()
+}
+
+// Wait blocks until every worker has stopped, or returns ctx's error if it is
+// done first
+func Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		running.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// jobContext returns the context of a single job. It isn't canceled with ctx,
+// so stopping the workers lets a job that has started finish.
+func jobContext(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.WithoutCancel(ctx), timeout)
+}
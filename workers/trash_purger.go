@@ -0,0 +1,53 @@
+package workers
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/zucced/goquery/models"
+)
+
+// StartTrashPurger periodically deletes queries and dashboards that have been
+// in the trash for longer than the retention period. It stops when ctx is done.
+func StartTrashPurger(ctx context.Context, store models.Store, retention, interval time.Duration) {
+	running.Add(1)
+	go func() {
+		defer running.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			purgeTrash(ctx, store, retention)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// purgeTrash runs a single purge pass
+func purgeTrash(ctx context.Context, store models.Store, retention time.Duration) {
+	purgeCtx, cancel := jobContext(ctx, time.Minute)
+	defer cancel()
+
+	cutoff := time.Now().Add(-retention)
+
+	queries, err := store.PurgeDeletedQueries(purgeCtx, cutoff)
+	if err != nil {
+		log.Printf("Failed to purge trashed queries: %v", err)
+	}
+
+	dashboards, err := store.PurgeDeletedDashboards(purgeCtx, cutoff)
+	if err != nil {
+		log.Printf("Failed to purge trashed dashboards: %v", err)
+	}
+
+	if queries > 0 || dashboards > 0 {
+		log.Printf("Purged %d queries and %d dashboards from trash", queries, dashboards)
+	}
+}